@@ -0,0 +1,483 @@
+//
+// Exports the complete transaction history of an account, oldest first, from a Clio keyspace or
+// through Clio's account_tx API.
+//
+// Every transaction is annotated with the balance changes it caused for the account, taken from the
+// AccountRoot (XRP) and RippleState (issued currency) nodes of its metadata. The XRP change includes
+// the fee when the account sent the transaction.
+//
+// CSV output has one row per transaction; issued currency changes are joined into a single column as
+// "value currency/counterparty" separated by semicolons. NDJSON output has one object per transaction.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+	"xrplf/clio/xrpl/rpc"
+)
+
+const (
+	pageLimit = 200
+)
+
+var (
+	account   = kingpin.Arg("account", "Account to export the history of").Required().String()
+	source    = kingpin.Flag("source", "Where to read the history from").Default("api").Enum("api", "db")
+	clioURL   = kingpin.Flag("clio", "Clio JSON-RPC endpoint, used with --source api").Default("http://127.0.0.1:51233").String()
+	ledgerMin = kingpin.Flag("ledger-min", "First ledger to export").Uint32()
+	ledgerMax = kingpin.Flag("ledger-max", "Last ledger to export").Uint32()
+	since     = kingpin.Flag("since", "Only export transactions closed at or after this time (RFC3339)").String()
+	until     = kingpin.Flag("until", "Only export transactions closed before this time (RFC3339)").String()
+	format    = kingpin.Flag("format", "Output format").Default("csv").Enum("csv", "ndjson")
+	outFile   = kingpin.Flag("out", "Write the output to this file instead of stdout").Short('o').String()
+	failed    = kingpin.Flag("include-failed", "Also export transactions that did not succeed (tec results)").Default("true").Bool()
+
+	clusterHosts = kingpin.Flag("hosts", "Your Scylla nodes IP addresses, comma separated, used with --source db").String()
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+// a transaction in rippled JSON form, regardless of where it was read from
+type historyTx struct {
+	LedgerIndex uint64
+	Date        uint32
+	Hash        string
+	Tx          map[string]interface{}
+	Meta        map[string]interface{}
+}
+
+type balanceChange struct {
+	Currency     string `json:"currency"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Change       string `json:"change"`
+	Balance      string `json:"balance"`
+}
+
+type record struct {
+	LedgerIndex    uint64          `json:"ledger_index"`
+	Date           string          `json:"date"`
+	Hash           string          `json:"hash"`
+	Type           string          `json:"type"`
+	Result         string          `json:"result"`
+	Account        string          `json:"account"`
+	Destination    string          `json:"destination,omitempty"`
+	Fee            string          `json:"fee_drops"`
+	Delivered      interface{}     `json:"delivered_amount,omitempty"`
+	BalanceChanges []balanceChange `json:"balance_changes"`
+}
+
+func parseRat(v interface{}) (*big.Rat, bool) {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case map[string]interface{}:
+		s, _ = value["value"].(string)
+	default:
+		return nil, false
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	return r, ok
+}
+
+func formatRat(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	s := strings.TrimRight(r.FloatString(100), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// returns the fields of an affected node before and after the transaction
+func nodeFields(node map[string]interface{}) (string, map[string]interface{}, map[string]interface{}) {
+	for kind, v := range node {
+		n, _ := v.(map[string]interface{})
+		entryType, _ := n["LedgerEntryType"].(string)
+		final, _ := n["FinalFields"].(map[string]interface{})
+		previous, _ := n["PreviousFields"].(map[string]interface{})
+
+		switch kind {
+		case "CreatedNode":
+			created, _ := n["NewFields"].(map[string]interface{})
+			return entryType, nil, created
+		case "ModifiedNode":
+			before := make(map[string]interface{})
+			for k, v := range final {
+				before[k] = v
+			}
+			for k, v := range previous {
+				before[k] = v
+			}
+			return entryType, before, final
+		case "DeletedNode":
+			before := make(map[string]interface{})
+			for k, v := range final {
+				before[k] = v
+			}
+			for k, v := range previous {
+				before[k] = v
+			}
+			return entryType, before, nil
+		}
+	}
+	return "", nil, nil
+}
+
+func balanceChanges(meta map[string]interface{}, address string) []balanceChange {
+	nodes, _ := meta["AffectedNodes"].([]interface{})
+	var changes []balanceChange
+
+	for _, n := range nodes {
+		node, _ := n.(map[string]interface{})
+		entryType, before, after := nodeFields(node)
+
+		switch entryType {
+		case "AccountRoot":
+			owner, _ := after["Account"].(string)
+			if owner == "" {
+				owner, _ = before["Account"].(string)
+			}
+			if owner != address {
+				continue
+			}
+
+			prev, next := new(big.Rat), new(big.Rat)
+			if r, ok := parseRat(before["Balance"]); ok {
+				prev = r
+			}
+			if r, ok := parseRat(after["Balance"]); ok {
+				next = r
+			}
+
+			if prev.Cmp(next) != 0 {
+				changes = append(changes, balanceChange{Currency: "XRP", Change: formatRat(new(big.Rat).Sub(next, prev)), Balance: formatRat(next)})
+			}
+
+		case "RippleState":
+			fields := after
+			if fields == nil {
+				fields = before
+			}
+
+			low, _ := fields["LowLimit"].(map[string]interface{})
+			high, _ := fields["HighLimit"].(map[string]interface{})
+			lowIssuer, _ := low["issuer"].(string)
+			highIssuer, _ := high["issuer"].(string)
+
+			// balances are stored from the low account's point of view
+			var sign int64
+			var counterparty string
+			switch address {
+			case lowIssuer:
+				sign, counterparty = 1, highIssuer
+			case highIssuer:
+				sign, counterparty = -1, lowIssuer
+			default:
+				continue
+			}
+
+			prev, next := new(big.Rat), new(big.Rat)
+			if r, ok := parseRat(before["Balance"]); ok {
+				prev = r
+			}
+			if r, ok := parseRat(after["Balance"]); ok {
+				next = r
+			}
+
+			if prev.Cmp(next) == 0 {
+				continue
+			}
+
+			currency := ""
+			if b, ok := fields["Balance"].(map[string]interface{}); ok {
+				currency, _ = b["currency"].(string)
+			}
+
+			s := big.NewRat(sign, 1)
+			changes = append(changes, balanceChange{
+				Currency:     currency,
+				Counterparty: counterparty,
+				Change:       formatRat(new(big.Rat).Mul(s, new(big.Rat).Sub(next, prev))),
+				Balance:      formatRat(new(big.Rat).Mul(s, next)),
+			})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Currency == "XRP" && changes[j].Currency != "XRP" })
+	return changes
+}
+
+func toRecord(t *historyTx, address string) record {
+	r := record{LedgerIndex: t.LedgerIndex, Hash: t.Hash}
+	if t.Date != 0 {
+		r.Date = xrpl.RippleTime(t.Date).UTC().Format(time.RFC3339)
+	}
+
+	r.Type, _ = t.Tx["TransactionType"].(string)
+	r.Account, _ = t.Tx["Account"].(string)
+	r.Destination, _ = t.Tx["Destination"].(string)
+	r.Fee, _ = t.Tx["Fee"].(string)
+	r.Result, _ = t.Meta["TransactionResult"].(string)
+	r.Delivered = t.Meta["delivered_amount"]
+	if r.Delivered == nil {
+		r.Delivered = t.Meta["DeliveredAmount"]
+	}
+	r.BalanceChanges = balanceChanges(t.Meta, address)
+	return r
+}
+
+type window struct {
+	since time.Time
+	until time.Time
+}
+
+// returns whether t is inside the window and whether any later transaction can still be
+func (w *window) check(t *historyTx) (bool, bool) {
+	if w.since.IsZero() && w.until.IsZero() {
+		return true, true
+	}
+
+	closed := xrpl.RippleTime(t.Date)
+	if !w.until.IsZero() && !closed.Before(w.until) {
+		return false, false
+	}
+	return w.since.IsZero() || !closed.Before(w.since), true
+}
+
+// walks the account's history from the API oldest first
+func fetchFromAPI(ctx context.Context, emit func(*historyTx) bool) error {
+	client := rpc.NewClient(*clioURL, time.Duration(*clusterFlags.Timeout)*time.Millisecond)
+
+	params := map[string]interface{}{
+		"account":          *account,
+		"forward":          true,
+		"limit":            pageLimit,
+		"api_version":      1,
+		"ledger_index_min": -1,
+		"ledger_index_max": -1,
+	}
+	if *ledgerMin != 0 {
+		params["ledger_index_min"] = *ledgerMin
+	}
+	if *ledgerMax != 0 {
+		params["ledger_index_max"] = *ledgerMax
+	}
+
+	for {
+		result, err := client.Call(ctx, "account_tx", params)
+		if err != nil {
+			return err
+		}
+
+		txs, _ := result["transactions"].([]interface{})
+		for _, entry := range txs {
+			e, _ := entry.(map[string]interface{})
+			tx, _ := e["tx"].(map[string]interface{})
+			meta, _ := e["meta"].(map[string]interface{})
+
+			t := &historyTx{Tx: tx, Meta: meta}
+			t.Hash, _ = tx["hash"].(string)
+			t.LedgerIndex, _ = rpc.Uint(tx["ledger_index"])
+			date, _ := rpc.Uint(tx["date"])
+			t.Date = uint32(date)
+
+			if !emit(t) {
+				return nil
+			}
+		}
+
+		marker, ok := result["marker"]
+		if !ok {
+			return nil
+		}
+		params["marker"] = marker
+	}
+}
+
+// walks the account's history in the account_tx table oldest first, the same way Clio does for forward requests
+func fetchFromDB(emit func(*historyTx) bool) error {
+	if *clusterHosts == "" {
+		return fmt.Errorf("--hosts is required with --source db")
+	}
+
+	id, err := xrpl.DecodeAccountID(*account)
+	if err != nil {
+		return err
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	maxSeq := int64(math.MaxUint32)
+	if *ledgerMax != 0 {
+		maxSeq = int64(*ledgerMax)
+	}
+
+	cursor := []interface{}{int64(*ledgerMin) - 1, int64(math.MaxUint32)}
+	for {
+		iter := session.Query("SELECT hash, seq_idx FROM account_tx WHERE account = ? AND seq_idx > ? ORDER BY seq_idx ASC LIMIT ?", id, cursor, pageLimit).Iter()
+
+		var hashes [][]byte
+		var hash []byte
+		var seq, idx int64
+		for iter.Scan(&hash, &seq, &idx) {
+			if seq > maxSeq {
+				break
+			}
+			hashes = append(hashes, hash)
+			hash = nil
+			cursor = []interface{}{seq, idx}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+
+		for _, h := range hashes {
+			var txBlob, metaBlob []byte
+			var ledgerSeq, date int64
+			if err := session.Query("SELECT transaction, metadata, ledger_sequence, date FROM transactions WHERE hash = ?", h).Scan(&txBlob, &metaBlob, &ledgerSeq, &date); err != nil {
+				return fmt.Errorf("transaction %X: %w", h, err)
+			}
+
+			tx, err := xrpl.Decode(txBlob)
+			if err != nil {
+				return fmt.Errorf("transaction %X: %w", h, err)
+			}
+
+			meta, err := xrpl.Decode(metaBlob)
+			if err != nil {
+				return fmt.Errorf("metadata of %X: %w", h, err)
+			}
+
+			t := &historyTx{LedgerIndex: uint64(ledgerSeq), Date: uint32(date), Hash: fmt.Sprintf("%X", h), Tx: tx, Meta: meta}
+			if !emit(t) {
+				return nil
+			}
+		}
+
+		if len(hashes) < pageLimit {
+			return nil
+		}
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	// -o is --out here
+	kingpin.CommandLine.GetFlag("consistency").Short('c')
+	kingpin.Parse()
+
+	if _, err := xrpl.DecodeAccountID(*account); err != nil {
+		log.Fatalf("Invalid account %s: %s", *account, err)
+	}
+
+	var w window
+	for _, bound := range []struct {
+		value  string
+		target *time.Time
+	}{{*since, &w.since}, {*until, &w.until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			log.Fatalf("Bad time %s: %s", bound.value, err)
+		}
+		*bound.target = t
+	}
+
+	out := os.Stdout
+	if *outFile != "" {
+		var err error
+		out, err = os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+
+	csvWriter := csv.NewWriter(out)
+	encoder := json.NewEncoder(out)
+	if *format == "csv" {
+		csvWriter.Write([]string{"ledger_index", "date", "hash", "type", "result", "account", "destination", "fee_drops", "xrp_change_drops", "xrp_balance_drops", "iou_changes"})
+	}
+
+	var exported, seen int
+	emit := func(t *historyTx) bool {
+		seen++
+		inside, more := w.check(t)
+		if !more {
+			return false
+		}
+
+		r := toRecord(t, *account)
+		if !inside || (!*failed && r.Result != "tesSUCCESS") {
+			return true
+		}
+
+		exported++
+		if *format == "ndjson" {
+			encoder.Encode(r)
+			return true
+		}
+
+		var xrpChange, xrpBalance string
+		var ious []string
+		for _, c := range r.BalanceChanges {
+			if c.Currency == "XRP" {
+				xrpChange, xrpBalance = c.Change, c.Balance
+			} else {
+				ious = append(ious, fmt.Sprintf("%s %s/%s", c.Change, c.Currency, c.Counterparty))
+			}
+		}
+
+		csvWriter.Write([]string{strconv.FormatUint(r.LedgerIndex, 10), r.Date, r.Hash, r.Type, r.Result, r.Account, r.Destination, r.Fee, xrpChange, xrpBalance, strings.Join(ious, ";")})
+		if exported%1000 == 0 {
+			log.Printf("... %d transactions exported ...\n", exported)
+		}
+		return true
+	}
+
+	var err error
+	if *source == "db" {
+		err = fetchFromDB(emit)
+	} else {
+		err = fetchFromAPI(context.Background(), emit)
+	}
+
+	csvWriter.Flush()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := csvWriter.Error(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Exported %d of %d transactions of %s\n", exported, seen, *account)
+}
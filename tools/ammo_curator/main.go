@@ -0,0 +1,492 @@
+//
+// Curates raw captured request corpora into ammo sets: one JSON request per line, deduplicated,
+// with account addresses anonymized and requests grouped by method cost class.
+//
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl"
+)
+
+var (
+	inputs    = kingpin.Arg("corpus", "Raw corpus files (one JSON request per line, optionally .gz)").Required().ExistingFiles()
+	outputDir = kingpin.Flag("out", "Directory to write the curated ammo sets into").Short('o').Default("ammo").String()
+	format    = kingpin.Flag("format", "Output request format").Default("jsonrpc").Enum("jsonrpc", "ws")
+
+	accountMode = kingpin.Flag("accounts", "How to handle account addresses found in requests").Default("randomize").Enum("keep", "strip", "randomize", "pool")
+	accountPool = kingpin.Flag("account-pool", "File with one address per line to map captured accounts onto (with --accounts=pool)").ExistingFile()
+	salt        = kingpin.Flag("salt", "Secret mixed into randomized accounts so the mapping cannot be reversed").Default("clio-ammo").String()
+
+	keepMethods = kingpin.Flag("methods", "Comma separated list of methods to keep. Defaults to every method Clio serves").String()
+	mixSize     = kingpin.Flag("mix", "Also write mixed.ndjson with this many requests sampled by observed frequency").Default("0").Int()
+	seed        = kingpin.Flag("seed", "Random seed used for sampling").Default("1").Int64()
+)
+
+// methods Clio answers itself; everything else gets forwarded to rippled and says little about Clio
+var clioMethods = map[string]string{
+	"ping":               "light",
+	"random":             "light",
+	"version":            "light",
+	"server_info":        "light",
+	"ledger_range":       "light",
+	"ledger_entry":       "light",
+	"account_info":       "light",
+	"tx":                 "light",
+	"transaction_entry":  "light",
+	"nft_info":           "light",
+	"deposit_authorized": "light",
+	"account_currencies": "medium",
+	"account_channels":   "medium",
+	"account_lines":      "medium",
+	"account_nfts":       "medium",
+	"account_objects":    "medium",
+	"account_offers":     "medium",
+	"amm_info":           "medium",
+	"book_offers":        "medium",
+	"nft_buy_offers":     "medium",
+	"nft_sell_offers":    "medium",
+	"ledger":             "medium",
+	"subscribe":          "medium",
+	"unsubscribe":        "light",
+	"account_tx":         "heavy",
+	"nft_history":        "heavy",
+	"nfts_by_issuer":     "heavy",
+	"ledger_data":        "heavy",
+	"book_changes":       "heavy",
+	"gateway_balances":   "heavy",
+	"noripple_check":     "heavy",
+}
+
+// fields that must never end up in a shared corpus
+var secretFields = []string{"secret", "seed", "seed_hex", "passphrase", "key_type"}
+
+var addressPattern = regexp.MustCompile(`r[1-9A-HJ-NP-Za-km-z]{24,34}`)
+
+type request struct {
+	Method string
+	Params map[string]interface{}
+}
+
+type stats struct {
+	InputLines     uint64            `json:"input_lines"`
+	ParseErrors    uint64            `json:"parse_errors"`
+	Dropped        uint64            `json:"dropped"`
+	Duplicates     uint64            `json:"duplicates"`
+	Unique         uint64            `json:"unique"`
+	AccountsMapped int               `json:"accounts_mapped"`
+	SecretsRemoved uint64            `json:"secrets_removed"`
+	Methods        map[string]uint64 `json:"methods"`
+	Classes        map[string]uint64 `json:"classes"`
+}
+
+type entry struct {
+	Request request
+	Count   uint64
+	Class   string
+}
+
+type anonymizer struct {
+	mapping map[string]string
+	pool    []string
+}
+
+func (a *anonymizer) mapAccount(account string) (string, bool) {
+	if _, err := xrpl.DecodeAccountID(account); err != nil {
+		return account, false
+	}
+
+	if mapped, ok := a.mapping[account]; ok {
+		return mapped, true
+	}
+
+	var mapped string
+	switch *accountMode {
+	case "randomize":
+		mac := hmac.New(sha256.New, []byte(*salt))
+		mac.Write([]byte(account))
+		mapped = xrpl.EncodeAccountID(mac.Sum(nil)[:20])
+	case "pool":
+		mapped = a.pool[len(a.mapping)%len(a.pool)]
+	default:
+		return account, true
+	}
+
+	a.mapping[account] = mapped
+	return mapped, true
+}
+
+// rewrites every valid address inside v; returns whether any account was seen
+func (a *anonymizer) rewrite(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		found := false
+		out := addressPattern.ReplaceAllStringFunc(t, func(m string) string {
+			mapped, ok := a.mapAccount(m)
+			found = found || ok
+			return mapped
+		})
+		return out, found
+	case map[string]interface{}:
+		found := false
+		for k, inner := range t {
+			var f bool
+			t[k], f = a.rewrite(inner)
+			found = found || f
+		}
+		return t, found
+	case []interface{}:
+		found := false
+		for i, inner := range t {
+			var f bool
+			t[i], f = a.rewrite(inner)
+			found = found || f
+		}
+		return t, found
+	}
+
+	return v, false
+}
+
+func parseRequest(line []byte) (request, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(line)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return request{}, err
+	}
+
+	// JSON-RPC style: {"method": ..., "params": [{...}]}
+	if method, ok := raw["method"].(string); ok {
+		params := map[string]interface{}{}
+		if list, ok := raw["params"].([]interface{}); ok && len(list) > 0 {
+			if p, ok := list[0].(map[string]interface{}); ok {
+				params = p
+			}
+		}
+		return request{Method: method, Params: params}, nil
+	}
+
+	// WebSocket style: {"command": ..., ...}
+	if command, ok := raw["command"].(string); ok {
+		delete(raw, "command")
+		delete(raw, "id")
+		return request{Method: command, Params: raw}, nil
+	}
+
+	return request{}, fmt.Errorf("neither method nor command present")
+}
+
+// cost class of a request; some methods get much more expensive depending on parameters
+func classify(r request) string {
+	class := clioMethods[r.Method]
+
+	switch r.Method {
+	case "ledger":
+		for _, heavy := range []string{"transactions", "expand", "full", "accounts", "diff"} {
+			if b, _ := r.Params[heavy].(bool); b {
+				return "heavy"
+			}
+		}
+	case "account_objects", "account_lines", "account_offers", "book_offers":
+		if limit, ok := r.Params["limit"].(json.Number); ok {
+			if n, err := limit.Int64(); err == nil && n > 200 {
+				return "heavy"
+			}
+		}
+	}
+
+	return class
+}
+
+func canonicalKey(r request) string {
+	b, _ := json.Marshal(map[string]interface{}{"m": r.Method, "p": r.Params})
+	return string(b)
+}
+
+func (r request) render() ([]byte, error) {
+	if *format == "ws" {
+		out := map[string]interface{}{"command": r.Method}
+		for k, v := range r.Params {
+			out[k] = v
+		}
+		return json.Marshal(out)
+	}
+
+	return json.Marshal(map[string]interface{}{"method": r.Method, "params": []interface{}{r.Params}})
+}
+
+func openCorpus(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+func loadPool(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if _, err := xrpl.DecodeAccountID(line); err != nil {
+			return nil, fmt.Errorf("invalid account %q in pool: %w", line, err)
+		}
+
+		pool = append(pool, line)
+	}
+
+	if len(pool) == 0 {
+		return nil, fmt.Errorf("account pool %s is empty", path)
+	}
+
+	return pool, nil
+}
+
+func writeLines(path string, lines [][]byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		w.Write(l)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeJSON(path string, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	anon := anonymizer{mapping: make(map[string]string)}
+	if *accountMode == "pool" {
+		if *accountPool == "" {
+			log.Fatal("--accounts=pool requires --account-pool")
+		}
+
+		pool, err := loadPool(*accountPool)
+		if err != nil {
+			log.Fatal(err)
+		}
+		anon.pool = pool
+	}
+
+	allowed := make(map[string]bool)
+	if *keepMethods != "" {
+		for _, m := range strings.Split(*keepMethods, ",") {
+			allowed[strings.TrimSpace(m)] = true
+		}
+	} else {
+		for m := range clioMethods {
+			allowed[m] = true
+		}
+	}
+
+	st := stats{Methods: make(map[string]uint64), Classes: make(map[string]uint64)}
+	entries := make(map[string]*entry)
+	var order []string
+
+	for _, path := range *inputs {
+		in, err := openCorpus(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			st.InputLines++
+
+			r, err := parseRequest([]byte(line))
+			if err != nil {
+				st.ParseErrors++
+				continue
+			}
+
+			if !allowed[r.Method] {
+				st.Dropped++
+				continue
+			}
+
+			for _, s := range secretFields {
+				if _, ok := r.Params[s]; ok {
+					delete(r.Params, s)
+					st.SecretsRemoved++
+				}
+			}
+
+			if *accountMode != "keep" {
+				rewritten, found := anon.rewrite(r.Params)
+				if found && *accountMode == "strip" {
+					st.Dropped++
+					continue
+				}
+				r.Params = rewritten.(map[string]interface{})
+			}
+
+			key := canonicalKey(r)
+			if e, ok := entries[key]; ok {
+				e.Count++
+				st.Duplicates++
+				continue
+			}
+
+			entries[key] = &entry{Request: r, Count: 1, Class: classify(r)}
+			order = append(order, key)
+		}
+
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("%s: %s\n", path, err)
+		}
+		in.Close()
+	}
+
+	st.Unique = uint64(len(entries))
+	st.AccountsMapped = len(anon.mapping)
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	byClass := make(map[string][][]byte)
+	var all [][]byte
+	weights := map[string]map[string]uint64{"methods": {}, "classes": {}}
+
+	for _, key := range order {
+		e := entries[key]
+		line, err := e.Request.render()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		all = append(all, line)
+		byClass[e.Class] = append(byClass[e.Class], line)
+
+		st.Methods[e.Request.Method] += e.Count
+		st.Classes[e.Class] += e.Count
+		weights["methods"][e.Request.Method] += e.Count
+		weights["classes"][e.Class] += e.Count
+	}
+
+	if err := writeLines(filepath.Join(*outputDir, "ammo_all.ndjson"), all); err != nil {
+		log.Fatal(err)
+	}
+
+	for class, lines := range byClass {
+		if err := writeLines(filepath.Join(*outputDir, "ammo_"+class+".ndjson"), lines); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := writeJSON(filepath.Join(*outputDir, "weights.json"), weights); err != nil {
+		log.Fatal(err)
+	}
+
+	if *mixSize > 0 && len(order) > 0 {
+		// sample proportionally to how often each unique request was captured
+		rng := rand.New(rand.NewSource(*seed))
+		cumulative := make([]uint64, len(order))
+		var total uint64
+		for i, key := range order {
+			total += entries[key].Count
+			cumulative[i] = total
+		}
+
+		mixed := make([][]byte, 0, *mixSize)
+		for i := 0; i < *mixSize; i++ {
+			target := uint64(rng.Int63n(int64(total)))
+			idx := sort.Search(len(cumulative), func(j int) bool { return cumulative[j] > target })
+			mixed = append(mixed, all[idx])
+		}
+
+		if err := writeLines(filepath.Join(*outputDir, "mixed.ndjson"), mixed); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := writeJSON(filepath.Join(*outputDir, "stats.json"), st); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Input lines       : %d\n", st.InputLines)
+	log.Printf("Parse errors      : %d\n", st.ParseErrors)
+	log.Printf("Dropped           : %d\n", st.Dropped)
+	log.Printf("Duplicates        : %d\n", st.Duplicates)
+	log.Printf("Unique requests   : %d\n", st.Unique)
+	log.Printf("Accounts mapped   : %d\n", st.AccountsMapped)
+	log.Printf("Secrets removed   : %d\n\n", st.SecretsRemoved)
+
+	var methods []string
+	for m := range st.Methods {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return st.Methods[methods[i]] > st.Methods[methods[j]] })
+
+	for _, m := range methods {
+		log.Printf("%-20s %-7s %d\n", m, clioMethods[m], st.Methods[m])
+	}
+
+	log.Printf("\nAmmo written to %s\n", *outputDir)
+}
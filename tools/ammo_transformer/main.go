@@ -0,0 +1,372 @@
+//
+// Rewrites ammo corpora between API versions 1 and 2.
+//
+// Every request is rewritten to the behaviour it had in its source version, expressed in the target
+// version, and gets the target api_version set. Version 1 accepts any JSON value where a few flags
+// expect a boolean, version 2 requires real booleans; account_tx in version 2 rejects ledger ranges
+// outside the stored range and ledger specifiers combined with a range, both of which version 1
+// tolerates. Requests whose outcome cannot be preserved are not written to the output but to --rejects,
+// together with the reason, so they can be reviewed by hand.
+//
+// Both JSON-RPC and WebSocket requests are accepted and written back in the form they came in.
+//
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	inputs    = kingpin.Arg("corpus", "Corpus files (one JSON request per line, optionally .gz)").Required().ExistingFiles()
+	toVersion = kingpin.Flag("to", "API version to rewrite the requests to").Required().Enum("1", "2")
+	fromFlag  = kingpin.Flag("from", "API version of requests that do not set api_version").Default("1").Enum("1", "2")
+	outFile   = kingpin.Flag("out", "File to write the rewritten corpus to. Defaults to stdout").Short('o').String()
+	rejects   = kingpin.Flag("rejects", "File to write requests without an equivalent to, with the reason").String()
+)
+
+// flags that version 1 converts from any JSON value and version 2 requires to be booleans
+var lenientBools = map[string][]string{
+	"account_info":   {"signer_lists"},
+	"account_tx":     {"binary", "forward"},
+	"tx":             {"binary"},
+	"noripple_check": {"transactions"},
+}
+
+type request struct {
+	Method string
+	Params map[string]interface{}
+	ws     bool
+	id     interface{}
+}
+
+type stats struct {
+	Lines       uint64            `json:"lines"`
+	ParseErrors uint64            `json:"parse_errors"`
+	Unchanged   uint64            `json:"already_in_target_version"`
+	Rewritten   uint64            `json:"rewritten"`
+	Rejected    uint64            `json:"rejected"`
+	Changes     map[string]uint64 `json:"changes"`
+	Reasons     map[string]uint64 `json:"reject_reasons"`
+}
+
+func parseRequest(line []byte) (request, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(line)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return request{}, err
+	}
+
+	// JSON-RPC style: {"method": ..., "params": [{...}]}
+	if method, ok := raw["method"].(string); ok {
+		params := map[string]interface{}{}
+		if list, ok := raw["params"].([]interface{}); ok && len(list) > 0 {
+			if p, ok := list[0].(map[string]interface{}); ok {
+				params = p
+			}
+		}
+		return request{Method: method, Params: params, id: raw["id"]}, nil
+	}
+
+	// WebSocket style: {"command": ..., ...}
+	if command, ok := raw["command"].(string); ok {
+		id := raw["id"]
+		delete(raw, "command")
+		delete(raw, "id")
+		return request{Method: command, Params: raw, ws: true, id: id}, nil
+	}
+
+	return request{}, fmt.Errorf("neither method nor command present")
+}
+
+func (r request) render() ([]byte, error) {
+	if r.ws {
+		out := map[string]interface{}{"command": r.Method}
+		for k, v := range r.Params {
+			out[k] = v
+		}
+		if r.id != nil {
+			out["id"] = r.id
+		}
+		return json.Marshal(out)
+	}
+
+	out := map[string]interface{}{"method": r.Method, "params": []interface{}{r.Params}}
+	if r.id != nil {
+		out["id"] = r.id
+	}
+	return json.Marshal(out)
+}
+
+// same conversion as Clio's JsonBool
+func toBool(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case json.Number:
+		f, _ := t.Float64()
+		return f != 0
+	case string:
+		return t != "" && t[0] != 0
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return false
+}
+
+func integer(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+// rewrites a version 1 request into version 2; returns the applied changes or a reason it has no equivalent
+func upgrade(r request) ([]string, string) {
+	var changes []string
+
+	for _, field := range lenientBools[r.Method] {
+		if v, ok := r.Params[field]; ok {
+			if _, isBool := v.(bool); !isBool {
+				r.Params[field] = toBool(v)
+				changes = append(changes, fmt.Sprintf("%s.%s coerced to boolean", r.Method, field))
+			}
+		}
+	}
+
+	if r.Method == "account_tx" {
+		for _, field := range []string{"ledger_index_min", "ledger_index_max"} {
+			// version 1 reads negative bounds as "no bound", version 2 rejects them as out of range
+			if i, ok := integer(r.Params[field]); ok && i < 0 {
+				delete(r.Params, field)
+				changes = append(changes, fmt.Sprintf("account_tx.%s removed", field))
+			} else if ok {
+				changes = append(changes, fmt.Sprintf("account_tx.%s kept, it must lie within the stored range in version 2", field))
+			}
+		}
+
+		_, hasMin := r.Params["ledger_index_min"]
+		_, hasMax := r.Params["ledger_index_max"]
+		_, hasHash := r.Params["ledger_hash"]
+		_, hasIndex := r.Params["ledger_index"]
+
+		// version 1 respects the range when both are given, version 2 refuses the combination
+		if (hasMin || hasMax) && (hasHash || hasIndex) {
+			delete(r.Params, "ledger_hash")
+			delete(r.Params, "ledger_index")
+			changes = append(changes, "account_tx ledger specifier dropped in favour of the range")
+		}
+	}
+
+	if r.Method == "ledger_entry" && !hasLedgerEntrySelector(r.Params) {
+		return nil, "ledger_entry without an object selector fails with a different error in version 2"
+	}
+
+	return changes, ""
+}
+
+// rewrites a version 2 request into version 1
+func downgrade(r request) ([]string, string) {
+	if r.Method == "account_tx" {
+		_, hasMin := r.Params["ledger_index_min"]
+		_, hasMax := r.Params["ledger_index_max"]
+		_, hasHash := r.Params["ledger_hash"]
+		_, hasIndex := r.Params["ledger_index"]
+
+		if (hasMin || hasMax) && (hasHash || hasIndex) {
+			return nil, "account_tx with both a ledger specifier and a range is an error in version 2 only"
+		}
+	}
+
+	if r.Method == "ledger_entry" && !hasLedgerEntrySelector(r.Params) {
+		return nil, "ledger_entry without an object selector fails with a different error in version 1"
+	}
+
+	return nil, ""
+}
+
+var ledgerEntrySelectors = []string{
+	"index", "account_root", "check", "offer", "ripple_state", "directory", "escrow", "payment_channel",
+	"deposit_preauth", "ticket", "nft_page", "amm", "did", "oracle", "bridge", "xchain_owned_claim_id",
+	"xchain_owned_create_account_claim_id",
+}
+
+func hasLedgerEntrySelector(params map[string]interface{}) bool {
+	for _, s := range ledgerEntrySelectors {
+		if _, ok := params[s]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func openCorpus(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	target := 1
+	if *toVersion == "2" {
+		target = 2
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = bufio.NewWriter(f)
+	}
+
+	var rejected *bufio.Writer
+	if *rejects != "" {
+		f, err := os.Create(*rejects)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rejected = bufio.NewWriter(f)
+	}
+
+	s := stats{Changes: make(map[string]uint64), Reasons: make(map[string]uint64)}
+
+	for _, path := range *inputs {
+		in, err := openCorpus(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %s", path, err)
+		}
+
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(strings.TrimSpace(string(line))) == 0 {
+				continue
+			}
+			s.Lines++
+
+			r, err := parseRequest(line)
+			if err != nil {
+				s.ParseErrors++
+				continue
+			}
+
+			source := 1
+			if *fromFlag == "2" {
+				source = 2
+			}
+			if v, ok := integer(r.Params["api_version"]); ok {
+				source = int(v)
+			}
+
+			var changes []string
+			var reason string
+			switch {
+			case source == target:
+				s.Unchanged++
+			case target == 2:
+				changes, reason = upgrade(r)
+			default:
+				changes, reason = downgrade(r)
+			}
+
+			if reason != "" {
+				s.Rejected++
+				s.Reasons[reason]++
+				if rejected != nil {
+					entry, _ := json.Marshal(map[string]interface{}{"reason": reason, "request": json.RawMessage(line)})
+					rejected.Write(entry)
+					rejected.WriteByte('\n')
+				}
+				continue
+			}
+
+			if source != target {
+				s.Rewritten++
+			}
+			for _, c := range changes {
+				s.Changes[c]++
+			}
+
+			r.Params["api_version"] = target
+			rendered, err := r.render()
+			if err != nil {
+				s.ParseErrors++
+				continue
+			}
+			out.Write(rendered)
+			out.WriteByte('\n')
+		}
+
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("Failed to read %s: %s", path, err)
+		}
+		in.Close()
+	}
+
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if rejected != nil {
+		if err := rejected.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("TOTAL LINES: %d\n", s.Lines)
+	log.Printf("TOTAL PARSE ERRORS: %d\n", s.ParseErrors)
+	log.Printf("TOTAL REWRITTEN: %d\n", s.Rewritten)
+	log.Printf("TOTAL ALREADY AT VERSION %d: %d\n", target, s.Unchanged)
+	log.Printf("TOTAL WITHOUT EQUIVALENT: %d\n", s.Rejected)
+
+	for _, m := range []map[string]uint64{s.Changes, s.Reasons} {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			log.Printf("  %6d %s\n", m[k], k)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+//
+// Generates JSON Schemas and request corpora for the API methods supported by Clio.
+//
+// Methods and the domains of their parameters are described in a JSON file (methods.json next to this
+// tool is built in and used unless --methods is given). Every parameter either references one of the
+// shared types or carries its own JSON Schema fragment, valid example values and invalid values.
+//
+// For every method the generator writes:
+//   - schemas/<method>.json: a JSON Schema matching the request in both JSON-RPC and WebSocket form
+//   - corpus/<method>.ndjson: one case per line, {"case", "expect", "request"}, where expect is
+//     "valid" or "invalid"; valid cases vary one parameter at a time, invalid cases break one at a time.
+//     Valid only means well-formed: the server may still answer with e.g. entryNotFound. Some invalid
+//     values (a made up marker, say) pass the schema and are only rejected by the server
+//   - seeds/<method>/<n>.json: the raw requests of the corpus, one per file, for fuzzers
+//
+// With --handlers pointing at src/rpc/common/impl/HandlerProvider.cpp, methods registered in Clio but
+// missing from the description get a permissive schema and a bare request, so coverage grows with the
+// server. --check makes the run fail when that happens.
+//
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+//go:embed methods.json
+var builtinMethods []byte
+
+var (
+	methodsFile  = kingpin.Flag("methods", "Method description file. Defaults to the built-in description").String()
+	handlersFile = kingpin.Flag("handlers", "Path to HandlerProvider.cpp to discover methods registered in Clio").String()
+	outDir       = kingpin.Flag("out", "Output directory").Default("api_corpus").String()
+	noSeeds      = kingpin.Flag("no-seeds", "Do not write individual seed files").Default("false").Bool()
+	check        = kingpin.Flag("check", "Fail if a method registered in Clio is missing from the description").Default("false").Bool()
+)
+
+type domain struct {
+	Type     string          `json:"type"`
+	Schema   json.RawMessage `json:"schema"`
+	Examples []interface{}   `json:"examples"`
+	Invalid  []interface{}   `json:"invalid"`
+	Required bool            `json:"required"`
+}
+
+type method struct {
+	Params        map[string]*domain `json:"params"`
+	AnyOfRequired [][]string         `json:"any_of_required"`
+}
+
+type description struct {
+	Types   map[string]*domain `json:"types"`
+	Methods map[string]*method `json:"methods"`
+}
+
+type testCase struct {
+	Case    string                 `json:"case"`
+	Expect  string                 `json:"expect"`
+	Request map[string]interface{} `json:"request"`
+}
+
+type indexEntry struct {
+	Method     string `json:"method"`
+	Schema     string `json:"schema"`
+	Corpus     string `json:"corpus"`
+	Cases      int    `json:"cases"`
+	Documented bool   `json:"documented"`
+}
+
+var handlerPattern = regexp.MustCompile(`\{"([a-z_]+)",\s*\{`)
+
+func loadDescription() (*description, error) {
+	raw := builtinMethods
+	if *methodsFile != "" {
+		var err error
+		if raw, err = os.ReadFile(*methodsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var d description
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+
+	// resolve type references so every parameter carries its own schema and values
+	for name, m := range d.Methods {
+		for param, p := range m.Params {
+			if p.Type == "" {
+				continue
+			}
+
+			t, ok := d.Types[p.Type]
+			if !ok {
+				return nil, fmt.Errorf("%s.%s references unknown type %q", name, param, p.Type)
+			}
+
+			if p.Schema == nil {
+				p.Schema = t.Schema
+			}
+			if p.Examples == nil {
+				p.Examples = t.Examples
+			}
+			if p.Invalid == nil {
+				p.Invalid = t.Invalid
+			}
+		}
+	}
+
+	return &d, nil
+}
+
+func registeredMethods(path string) ([]string, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range handlerPattern.FindAllStringSubmatch(string(source), -1) {
+		names = append(names, m[1])
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no handlers found in %s", path)
+	}
+	return names, nil
+}
+
+func sortedKeys(params map[string]*domain) []string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func paramsSchema(m *method) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for _, name := range sortedKeys(m.Params) {
+		p := m.Params[name]
+		if p.Schema != nil {
+			properties[name] = p.Schema
+		} else {
+			properties[name] = map[string]interface{}{}
+		}
+		if p.Required {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if len(m.AnyOfRequired) > 0 {
+		var anyOf []interface{}
+		for _, group := range m.AnyOfRequired {
+			anyOf = append(anyOf, map[string]interface{}{"required": group})
+		}
+		schema["anyOf"] = anyOf
+	}
+
+	return schema
+}
+
+// a request is accepted either as JSON-RPC, with the parameters in a single element array, or as a
+// WebSocket command with the parameters inlined
+func requestSchema(name string, m *method) map[string]interface{} {
+	params := paramsSchema(m)
+
+	return map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id":     name + ".json",
+		"title":   name + " request",
+		"oneOf": []interface{}{
+			map[string]interface{}{
+				"type":     "object",
+				"required": []string{"method"},
+				"properties": map[string]interface{}{
+					"method": map[string]interface{}{"const": name},
+					"params": map[string]interface{}{"type": "array", "maxItems": 1, "items": params},
+				},
+			},
+			map[string]interface{}{
+				"allOf": []interface{}{
+					map[string]interface{}{
+						"type":       "object",
+						"required":   []string{"command"},
+						"properties": map[string]interface{}{"command": map[string]interface{}{"const": name}},
+					},
+					params,
+				},
+			},
+		},
+	}
+}
+
+func copyParams(params map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	return out
+}
+
+func request(name string, params map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"method": name, "params": []interface{}{params}}
+}
+
+func generateCases(name string, m *method) []testCase {
+	var cases []testCase
+	add := func(label, expect string, params map[string]interface{}) {
+		cases = append(cases, testCase{Case: label, Expect: expect, Request: request(name, params)})
+	}
+
+	inGroup := make(map[string]bool)
+	for _, group := range m.AnyOfRequired {
+		for _, p := range group {
+			inGroup[p] = true
+		}
+	}
+
+	// required parameters plus the members of one of the any_of groups, all at their first example
+	base := func(group int) map[string]interface{} {
+		params := make(map[string]interface{})
+		for _, p := range sortedKeys(m.Params) {
+			if d := m.Params[p]; d.Required && len(d.Examples) > 0 {
+				params[p] = d.Examples[0]
+			}
+		}
+
+		if group < len(m.AnyOfRequired) {
+			for _, p := range m.AnyOfRequired[group] {
+				if d, ok := m.Params[p]; ok && len(d.Examples) > 0 {
+					params[p] = d.Examples[0]
+				}
+			}
+		}
+		return params
+	}
+
+	groups := len(m.AnyOfRequired)
+	if groups == 0 {
+		groups = 1
+	}
+
+	for g := 0; g < groups; g++ {
+		label := "minimal"
+		if len(m.AnyOfRequired) > 0 {
+			label = "minimal/" + strings.Join(m.AnyOfRequired[g], "+")
+		}
+		add(label, "valid", base(g))
+	}
+
+	names := sortedKeys(m.Params)
+
+	// every example of every parameter, one parameter at a time
+	for _, p := range names {
+		d := m.Params[p]
+		start := 0
+		if d.Required || inGroup[p] {
+			start = 1
+		}
+
+		for i := start; i < len(d.Examples); i++ {
+			params := base(0)
+			if inGroup[p] {
+				for g, group := range m.AnyOfRequired {
+					for _, member := range group {
+						if member == p {
+							params = base(g)
+						}
+					}
+				}
+			}
+			params[p] = d.Examples[i]
+			add(fmt.Sprintf("with/%s/%d", p, i), "valid", params)
+		}
+	}
+
+	full := base(0)
+	for _, p := range names {
+		if d := m.Params[p]; !inGroup[p] && len(d.Examples) > 0 {
+			full[p] = d.Examples[0]
+		}
+	}
+	if len(full) > len(base(0)) {
+		add("full", "valid", full)
+	}
+
+	// break one parameter at a time
+	for _, p := range names {
+		for i, v := range m.Params[p].Invalid {
+			params := base(0)
+			params[p] = v
+			add(fmt.Sprintf("invalid/%s/%d", p, i), "invalid", params)
+		}
+	}
+
+	for _, p := range names {
+		if m.Params[p].Required {
+			params := base(0)
+			delete(params, p)
+			add("missing/"+p, "invalid", params)
+		}
+	}
+
+	if len(m.AnyOfRequired) > 0 {
+		params := base(0)
+		for p := range inGroup {
+			delete(params, p)
+		}
+		add("missing/any_of", "invalid", params)
+	}
+
+	return cases
+}
+
+func writeJSON(path string, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
+
+func writeMethod(name string, m *method) (int, error) {
+	if err := writeJSON(filepath.Join(*outDir, "schemas", name+".json"), requestSchema(name, m)); err != nil {
+		return 0, err
+	}
+
+	cases := generateCases(name, m)
+
+	f, err := os.Create(filepath.Join(*outDir, "corpus", name+".ndjson"))
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, c := range cases {
+		if err := encoder.Encode(c); err != nil {
+			return 0, err
+		}
+	}
+
+	if *noSeeds {
+		return len(cases), nil
+	}
+
+	seedDir := filepath.Join(*outDir, "seeds", name)
+	if err := os.MkdirAll(seedDir, 0755); err != nil {
+		return 0, err
+	}
+
+	for i, c := range cases {
+		body, err := json.Marshal(c.Request)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(filepath.Join(seedDir, fmt.Sprintf("%03d.json", i)), body, 0644); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(cases), nil
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	desc, err := loadDescription()
+	if err != nil {
+		log.Fatalf("Failed to load the method description: %s", err)
+	}
+
+	documented := make(map[string]bool)
+	for name := range desc.Methods {
+		documented[name] = true
+	}
+
+	var undocumented []string
+	if *handlersFile != "" {
+		registered, err := registeredMethods(*handlersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, name := range registered {
+			if _, ok := desc.Methods[name]; !ok {
+				undocumented = append(undocumented, name)
+				desc.Methods[name] = &method{Params: map[string]*domain{}}
+			}
+		}
+
+		known := make(map[string]bool)
+		for _, name := range registered {
+			known[name] = true
+		}
+		for name := range documented {
+			if !known[name] {
+				log.Printf("WARNING: %s is described but not registered in %s\n", name, *handlersFile)
+			}
+		}
+	}
+
+	for _, dir := range []string{"schemas", "corpus"} {
+		if err := os.MkdirAll(filepath.Join(*outDir, dir), 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var names []string
+	for name := range desc.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var index []indexEntry
+	var total int
+	for _, name := range names {
+		n, err := writeMethod(name, desc.Methods[name])
+		if err != nil {
+			log.Fatalf("Failed to write %s: %s", name, err)
+		}
+
+		total += n
+		index = append(index, indexEntry{
+			Method:     name,
+			Schema:     filepath.Join("schemas", name+".json"),
+			Corpus:     filepath.Join("corpus", name+".ndjson"),
+			Cases:      n,
+			Documented: documented[name],
+		})
+	}
+
+	if err := writeJSON(filepath.Join(*outDir, "index.json"), index); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d cases for %d methods to %s\n", total, len(names), *outDir)
+
+	if len(undocumented) > 0 {
+		sort.Strings(undocumented)
+		log.Printf("Methods without a description: %s\n", strings.Join(undocumented, ", "))
+		if *check {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+//
+// Restores ledger-range archives from S3 back into a Clio keyspace.
+//
+// An archive lives under <prefix>/<name>/ and consists of a manifest.json plus one gzip-compressed
+// NDJSON file per table. Every line of a data file is a single row keyed by column name, with blob
+// columns hex-encoded and tuple columns (seq_idx) encoded as a two element array:
+//
+//	{"keyspace": "clio_fh", "first_ledger": 1000, "last_ledger": 2000, "created_at": "...",
+//	 "files": [{"table": "objects", "key": "objects.ndjson.gz", "sha256": "...", "rows": 42}, ...]}
+//
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+)
+
+const (
+	defaultWorkers = 16
+)
+
+var (
+	bucket    = kingpin.Flag("bucket", "S3 bucket holding the archives").Required().String()
+	prefix    = kingpin.Flag("prefix", "Key prefix under which archives are stored").Default("clio-archive").String()
+	region    = kingpin.Flag("region", "S3 region").Default("us-east-1").String()
+	endpoint  = kingpin.Flag("endpoint", "Custom S3 endpoint (i.e. MinIO or Ceph RGW)").String()
+	pathStyle = kingpin.Flag("path-style", "Use path-style S3 addressing").Default("false").Bool()
+
+	listCmd = kingpin.Command("list", "List archives available under the prefix")
+
+	verifyCmd     = kingpin.Command("verify", "Download an archive and verify its checksums without touching the DB")
+	verifyArchive = verifyCmd.Arg("archive", "Name of the archive (i.e. 1000-2000)").Required().String()
+
+	restoreCmd      = kingpin.Command("restore", "Verify an archive and insert its rows into a keyspace")
+	restoreArchive  = restoreCmd.Arg("archive", "Name of the archive (i.e. 1000-2000)").Required().String()
+	clusterHosts    = restoreCmd.Flag("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	keyspace        = restoreCmd.Flag("keyspace", "Keyspace to restore into. Defaults to the keyspace recorded in the manifest").Short('k').String()
+	clusterFlags    = cassandra.RegisterFlags(restoreCmd)
+	workers         = restoreCmd.Flag("workers", "Number of parallel insert workers").Short('w').Default(fmt.Sprintf("%d", defaultWorkers)).Int()
+	tables          = restoreCmd.Flag("tables", "Comma separated list of tables to restore. Defaults to every table in the archive; ledger_range is not updated when given, as the other tables miss the window").String()
+	skipLedgerRange = restoreCmd.Flag("skip-ledger-range", "Do not update the ledger_range table after restoring; required to restore an archive that is not contiguous with the range already in the keyspace").Default("false").Bool()
+	workDir         = restoreCmd.Flag("work-dir", "Directory used to stage downloaded archive files").Default(os.TempDir()).String()
+)
+
+type archiveFile struct {
+	Table  string `json:"table"`
+	Key    string `json:"key"`
+	Sha256 string `json:"sha256"`
+	Rows   uint64 `json:"rows"`
+}
+
+type manifest struct {
+	Keyspace    string        `json:"keyspace"`
+	FirstLedger uint64        `json:"first_ledger"`
+	LastLedger  uint64        `json:"last_ledger"`
+	CreatedAt   string        `json:"created_at"`
+	Files       []archiveFile `json:"files"`
+}
+
+type columnType int
+
+const (
+	colBlob columnType = iota
+	colBigint
+	colBoolean
+	colTuple
+)
+
+type column struct {
+	Name string
+	Type columnType
+}
+
+var tableColumns = map[string][]column{
+	"objects":               {{"key", colBlob}, {"sequence", colBigint}, {"object", colBlob}},
+	"transactions":          {{"hash", colBlob}, {"ledger_sequence", colBigint}, {"date", colBigint}, {"transaction", colBlob}, {"metadata", colBlob}},
+	"ledger_transactions":   {{"ledger_sequence", colBigint}, {"hash", colBlob}},
+	"successor":             {{"key", colBlob}, {"seq", colBigint}, {"next", colBlob}},
+	"diff":                  {{"seq", colBigint}, {"key", colBlob}},
+	"account_tx":            {{"account", colBlob}, {"seq_idx", colTuple}, {"hash", colBlob}},
+	"ledgers":               {{"sequence", colBigint}, {"header", colBlob}},
+	"ledger_hashes":         {{"hash", colBlob}, {"sequence", colBigint}},
+	"nf_tokens":             {{"token_id", colBlob}, {"sequence", colBigint}, {"owner", colBlob}, {"is_burned", colBoolean}},
+	"issuer_nf_tokens_v2":   {{"issuer", colBlob}, {"taxon", colBigint}, {"token_id", colBlob}},
+	"nf_token_uris":         {{"token_id", colBlob}, {"sequence", colBigint}, {"uri", colBlob}},
+	"nf_token_transactions": {{"token_id", colBlob}, {"seq_idx", colTuple}, {"hash", colBlob}},
+}
+
+func newS3Client(ctx context.Context) *s3.Client {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(*region))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if *endpoint != "" {
+			o.BaseEndpoint = aws.String(*endpoint)
+		}
+		o.UsePathStyle = *pathStyle
+	})
+}
+
+// archiveKey is the S3 key of a file of an archive; S3 keys are separated by slashes on every OS
+func archiveKey(archive string, name string) string {
+	return path.Join(*prefix, archive, name)
+}
+
+func listArchives(ctx context.Context, client *s3.Client) error {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    bucket,
+		Prefix:    aws.String(strings.TrimSuffix(*prefix, "/") + "/"),
+		Delimiter: aws.String("/"),
+	})
+
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range page.CommonPrefixes {
+			names = append(names, path.Base(strings.TrimSuffix(aws.ToString(p.Prefix), "/")))
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		m, err := fetchManifest(ctx, client, name)
+		if err != nil {
+			fmt.Printf("%-30s (no readable manifest: %s)\n", name, err)
+			continue
+		}
+
+		var rows uint64
+		for _, f := range m.Files {
+			rows += f.Rows
+		}
+
+		fmt.Printf("%-30s ledgers %d -> %d, %d tables, %d rows, created %s\n", name, m.FirstLedger, m.LastLedger, len(m.Files), rows, m.CreatedAt)
+	}
+
+	return nil
+}
+
+func fetchManifest(ctx context.Context, client *s3.Client, archive string) (*manifest, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String(archiveKey(archive, "manifest.json"))})
+	if err != nil {
+		return nil, err
+	}
+
+	defer out.Body.Close()
+
+	var m manifest
+	if err := json.NewDecoder(out.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("malformed manifest: %w", err)
+	}
+
+	if m.FirstLedger == 0 || m.LastLedger < m.FirstLedger {
+		return nil, fmt.Errorf("manifest has an invalid ledger range %d -> %d", m.FirstLedger, m.LastLedger)
+	}
+
+	return &m, nil
+}
+
+// rejects selected tables that the archive has no file for, so that a typo does not restore nothing
+func checkSelected(m *manifest, selected map[string]bool) error {
+	archived := make(map[string]bool)
+	for _, f := range m.Files {
+		archived[f.Table] = true
+	}
+
+	var unknown []string
+	for t := range selected {
+		if !archived[t] {
+			unknown = append(unknown, t)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("archive has no table %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// downloads every file of the archive into dir and checks it against the sha256 recorded in the manifest
+func downloadAndVerify(ctx context.Context, client *s3.Client, archive string, m *manifest, dir string, selected map[string]bool) (map[string]string, error) {
+	local := make(map[string]string)
+
+	for _, f := range m.Files {
+		if selected != nil && !selected[f.Table] {
+			continue
+		}
+
+		if _, ok := tableColumns[f.Table]; !ok {
+			return nil, fmt.Errorf("archive contains unknown table %s", f.Table)
+		}
+
+		log.Printf("Downloading %s (%s)\n", f.Key, f.Table)
+
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String(archiveKey(archive, f.Key))})
+		if err != nil {
+			return nil, err
+		}
+
+		target := filepath.Join(dir, path.Base(f.Key))
+		file, err := os.Create(target)
+		if err != nil {
+			out.Body.Close()
+			return nil, err
+		}
+
+		hasher := sha256.New()
+		size, err := io.Copy(io.MultiWriter(file, hasher), out.Body)
+		out.Body.Close()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		if !strings.EqualFold(sum, f.Sha256) {
+			return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", f.Key, f.Sha256, sum)
+		}
+
+		log.Printf("Verified %s (%d bytes, sha256 %s)\n", f.Key, size, sum)
+		local[f.Table] = target
+	}
+
+	return local, nil
+}
+
+func insertQuery(table string) string {
+	var names []string
+	var marks []string
+	for _, c := range tableColumns[table] {
+		names = append(names, c.Name)
+		marks = append(marks, "?")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(marks, ", "))
+}
+
+func decodeRow(table string, line []byte) ([]interface{}, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for _, c := range tableColumns[table] {
+		v, ok := raw[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing column %s", c.Name)
+		}
+
+		switch c.Type {
+		case colBlob:
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			values = append(values, b)
+		case colBigint:
+			var n int64
+			if err := json.Unmarshal(v, &n); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			values = append(values, n)
+		case colBoolean:
+			var b bool
+			if err := json.Unmarshal(v, &b); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			values = append(values, b)
+		case colTuple:
+			var t [2]int64
+			if err := json.Unmarshal(v, &t); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			values = append(values, []interface{}{t[0], t[1]})
+		}
+	}
+
+	return values, nil
+}
+
+func restoreTable(cluster *gocql.ClusterConfig, table string, file string) (uint64, uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer gz.Close()
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer session.Close()
+
+	var wg sync.WaitGroup
+	var totalInserts uint64
+	var totalErrors uint64
+
+	query := insertQuery(table)
+	rows := make(chan []interface{}, *workers*100)
+
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for values := range rows {
+				if err := session.Query(query, values...).Exec(); err != nil {
+					log.Printf("INSERT ERROR: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: %s %v\n", query, values)
+					atomic.AddUint64(&totalErrors, 1)
+				} else if n := atomic.AddUint64(&totalInserts, 1); n%100000 == 0 {
+					log.Printf("... %d rows restored into %s ...\n", n, table)
+				}
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	var lineNo uint64
+	for scanner.Scan() {
+		lineNo++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		values, err := decodeRow(table, scanner.Bytes())
+		if err != nil {
+			close(rows)
+			wg.Wait()
+			return totalInserts, totalErrors, fmt.Errorf("%s line %d: %w", file, lineNo, err)
+		}
+
+		rows <- values
+	}
+
+	close(rows)
+	wg.Wait()
+
+	return totalInserts, totalErrors, scanner.Err()
+}
+
+// extendedLedgerRange is ledger_range once the archive is restored; a range with a gap would advertise
+// ledgers the keyspace does not have, so archives not contiguous with it are refused
+func extendedLedgerRange(cluster *gocql.ClusterConfig, m *manifest) (uint64, uint64, error) {
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer session.Close()
+
+	first, latest, err := cassandra.GetLedgerRange(session)
+	found := err == nil
+	if err != nil && err != gocql.ErrNotFound {
+		return 0, 0, err
+	}
+
+	newFirst, newLatest := m.FirstLedger, m.LastLedger
+	if found {
+		log.Printf("Current DB ledger range is %d:%d\n", first, latest)
+
+		contiguous := m.LastLedger+1 >= first && m.FirstLedger <= latest+1
+		if !contiguous {
+			return 0, 0, fmt.Errorf("archive %d:%d is not contiguous with DB range %d:%d; refusing to update ledger_range (use --skip-ledger-range to restore it anyway)",
+				m.FirstLedger, m.LastLedger, first, latest)
+		}
+
+		newFirst = min(newFirst, first)
+		newLatest = max(newLatest, latest)
+	}
+
+	return newFirst, newLatest, nil
+}
+
+// extends (or initializes) ledger_range so that the restored window becomes queryable
+func updateLedgerRange(cluster *gocql.ClusterConfig, m *manifest) error {
+	newFirst, newLatest, err := extendedLedgerRange(cluster, m)
+	if err != nil {
+		return err
+	}
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	query := "UPDATE ledger_range SET sequence = ? WHERE is_latest = ?"
+	if err := session.Query(query, newFirst, false).Exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [seq=%d][false]\n", query, newFirst)
+		return err
+	}
+
+	if err := session.Query(query, newLatest, true).Exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [seq=%d][true]\n", query, newLatest)
+		return err
+	}
+
+	log.Printf("Updated ledger_range to %d:%d\n", newFirst, newLatest)
+	return nil
+}
+
+func stage(ctx context.Context, client *s3.Client, archive string, selected map[string]bool) (*manifest, map[string]string, string) {
+	m, err := fetchManifest(ctx, client, archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Archive %s covers ledgers %d -> %d (keyspace %s, %d files)\n", archive, m.FirstLedger, m.LastLedger, m.Keyspace, len(m.Files))
+
+	if err := checkSelected(m, selected); err != nil {
+		log.Fatal(err)
+	}
+
+	dir, err := os.MkdirTemp(*workDir, "clio-restore-")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := downloadAndVerify(ctx, client, archive, m, dir, selected)
+	if err != nil {
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+
+	return m, files, dir
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	command := kingpin.Parse()
+
+	ctx := context.Background()
+	client := newS3Client(ctx)
+
+	switch command {
+	case listCmd.FullCommand():
+		if err := listArchives(ctx, client); err != nil {
+			log.Fatal(err)
+		}
+
+	case verifyCmd.FullCommand():
+		_, _, dir := stage(ctx, client, *verifyArchive, nil)
+		os.RemoveAll(dir)
+		log.Println("All checksums match")
+
+	case restoreCmd.FullCommand():
+		var selected map[string]bool
+		if *tables != "" {
+			selected = make(map[string]bool)
+			for _, t := range strings.Split(*tables, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					selected[t] = true
+				}
+			}
+		}
+
+		if selected != nil && !*skipLedgerRange {
+			log.Println("Not updating ledger_range: only some tables are restored")
+			*skipLedgerRange = true
+		}
+
+		m, files, dir := stage(ctx, client, *restoreArchive, selected)
+
+		// log.Fatal skips deferred calls, the staged files are removed before every exit
+		fatal := func(v ...interface{}) {
+			os.RemoveAll(dir)
+			log.Fatal(v...)
+		}
+
+		cluster := clusterFlags.NewCluster(*clusterHosts, m.Keyspace)
+		if *keyspace != "" {
+			cluster.Keyspace = *keyspace
+		}
+
+		// ledger_range can only advertise the window once the headers of its ledgers are restored, and a gap is
+		// refused, before any row is written
+		if !*skipLedgerRange {
+			if _, ok := files["ledgers"]; !ok {
+				fatal("Archive has no ledgers table; refusing to update ledger_range (use --skip-ledger-range to restore it anyway)")
+			}
+			if _, _, err := extendedLedgerRange(cluster, m); err != nil {
+				fatal(err)
+			}
+		}
+
+		startTime := time.Now().UTC()
+
+		var totalInserts uint64
+		var totalErrors uint64
+
+		var restoreOrder []string
+		for table := range files {
+			restoreOrder = append(restoreOrder, table)
+		}
+		sort.Strings(restoreOrder)
+
+		for _, table := range restoreOrder {
+			log.Printf("Restoring %s into %s.%s\n", files[table], cluster.Keyspace, table)
+			inserts, errs, err := restoreTable(cluster, table, files[table])
+			totalInserts += inserts
+			totalErrors += errs
+			if err != nil {
+				fatal(err)
+			}
+			log.Printf("Restored %d rows into %s (%d errors)\n\n", inserts, table, errs)
+		}
+
+		log.Printf("TOTAL ERRORS: %d\n", totalErrors)
+		log.Printf("TOTAL ROWS RESTORED: %d\n\n", totalInserts)
+
+		if totalErrors > 0 {
+			fatal("Some rows failed to restore; not touching ledger_range. Re-run the restore once the cluster is healthy.")
+		}
+
+		// ledger_range must only move once every table holds the window, so it is always written last
+		if !*skipLedgerRange {
+			if err := updateLedgerRange(cluster, m); err != nil {
+				fatal(err)
+			}
+		}
+
+		os.RemoveAll(dir)
+		fmt.Printf("Total Execution Time: %s\n\n", time.Since(startTime))
+	}
+}
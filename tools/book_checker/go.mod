@@ -0,0 +1,23 @@
+module xrplf/clio/book_checker
+
+go 1.21.6
+
+require (
+	github.com/alecthomas/kingpin/v2 v2.4.0
+	github.com/gocql/gocql v1.6.0
+	xrplf/clio/cassandra v0.0.0
+	xrplf/clio/xrpl v0.0.0
+)
+
+require (
+	github.com/alecthomas/units v0.0.0-20211218093645-b94a6e3cc137 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	gopkg.in/inf.v0 v0.9.1 // indirect
+)
+
+replace xrplf/clio/cassandra => ../cassandra
+
+replace xrplf/clio/xrpl => ../xrpl
@@ -0,0 +1,488 @@
+//
+// Compares Clio's book_offers output against an order book rebuilt straight from the objects and
+// successor tables of the same keyspace at the same ledger.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+	"xrplf/clio/xrpl/rpc"
+)
+
+const (
+	maxBookOffersLimit = 100
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	clioURL      = kingpin.Flag("clio", "Clio JSON-RPC endpoint").Default("http://127.0.0.1:51233").String()
+
+	takerPays = kingpin.Flag("taker-pays", "Currency the taker pays, as XRP or CUR/issuer").String()
+	takerGets = kingpin.Flag("taker-gets", "Currency the taker gets, as XRP or CUR/issuer").String()
+	booksFile = kingpin.Flag("books", "File with one 'taker_pays taker_gets' pair per line, checked in addition to --taker-pays/--taker-gets").String()
+	ledgerIdx = kingpin.Flag("ledger", "Ledger index to check at. Defaults to the latest ledger in ledger_range").Short('i').Uint64()
+	limit     = kingpin.Flag("limit", "Number of top offers to compare per book (Clio caps book_offers at 100)").Default("100").Int()
+	both      = kingpin.Flag("both-sides", "Also check the reverse book of every pair").Default("false").Bool()
+	reportTo  = kingpin.Flag("report", "Write a JSON report to this file").String()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+type issue struct {
+	Currency string
+	Issuer   string
+}
+
+func (i issue) String() string {
+	if i.Currency == "XRP" {
+		return "XRP"
+	}
+	return i.Currency + "/" + i.Issuer
+}
+
+func (i issue) json() map[string]interface{} {
+	if i.Currency == "XRP" {
+		return map[string]interface{}{"currency": "XRP"}
+	}
+	return map[string]interface{}{"currency": i.Currency, "issuer": i.Issuer}
+}
+
+func (i issue) raw() ([]byte, []byte, error) {
+	cur, err := xrpl.EncodeCurrency(i.Currency)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if i.Currency == "XRP" {
+		return cur, make([]byte, 20), nil
+	}
+
+	iss, err := xrpl.DecodeAccountID(i.Issuer)
+	return cur, iss, err
+}
+
+type book struct {
+	Pays issue
+	Gets issue
+}
+
+func (b book) String() string {
+	return fmt.Sprintf("%s -> %s", b.Pays, b.Gets)
+}
+
+type offer struct {
+	Key       string
+	Directory string
+	Quality   uint64
+	Fields    map[string]interface{}
+}
+
+type finding struct {
+	Book    string `json:"book"`
+	Kind    string `json:"kind"`
+	Offer   string `json:"offer,omitempty"`
+	Message string `json:"message"`
+}
+
+type report struct {
+	Tool        string    `json:"tool"`
+	LedgerIndex uint64    `json:"ledger_index"`
+	Passed      bool      `json:"passed"`
+	Books       int       `json:"books"`
+	Compared    int       `json:"offers_compared"`
+	Findings    []finding `json:"findings"`
+}
+
+func parseIssue(s string) (issue, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "XRP") {
+		return issue{Currency: "XRP"}, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return issue{}, fmt.Errorf("invalid issue %q, expected CUR/issuer", s)
+	}
+
+	if _, err := xrpl.DecodeAccountID(parts[1]); err != nil {
+		return issue{}, fmt.Errorf("invalid issuer in %q: %w", s, err)
+	}
+
+	return issue{Currency: parts[0], Issuer: parts[1]}, nil
+}
+
+func loadBooks() ([]book, error) {
+	var books []book
+
+	if *takerPays != "" || *takerGets != "" {
+		pays, err := parseIssue(*takerPays)
+		if err != nil {
+			return nil, err
+		}
+
+		gets, err := parseIssue(*takerGets)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, book{Pays: pays, Gets: gets})
+	}
+
+	if *booksFile != "" {
+		f, err := os.Open(*booksFile)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			parts := strings.Fields(line)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid book line %q", line)
+			}
+
+			pays, err := parseIssue(parts[0])
+			if err != nil {
+				return nil, err
+			}
+
+			gets, err := parseIssue(parts[1])
+			if err != nil {
+				return nil, err
+			}
+
+			books = append(books, book{Pays: pays, Gets: gets})
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if *both {
+		for _, b := range books {
+			books = append(books, book{Pays: b.Gets, Gets: b.Pays})
+		}
+	}
+
+	return books, nil
+}
+
+func fetchSuccessor(session *gocql.Session, key []byte, seq uint64) ([]byte, error) {
+	var next []byte
+	if err := session.Query("SELECT next FROM successor WHERE key = ? AND seq <= ? ORDER BY seq DESC LIMIT 1", key, seq).Scan(&next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// rebuilds the first `want` offers of a book by walking the quality directories in key order, the same
+// data Clio's book_offers is built from but without going through any of its code
+func reconstructBook(session *gocql.Session, b book, seq uint64, want int) ([]offer, []finding, error) {
+	var offers []offer
+	var findings []finding
+
+	paysCur, paysIss, err := b.Pays.raw()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	getsCur, getsIss, err := b.Gets.raw()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base := xrpl.BookBase(paysCur, paysIss, getsCur, getsIss)
+	end := xrpl.QualityNext(base)
+	tip := base
+
+	for len(offers) < want {
+		dirKey, err := fetchSuccessor(session, tip, seq)
+		if err == gocql.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if bytes.Compare(dirKey, end) >= 0 {
+			break
+		}
+
+		tip = dirKey
+		quality := xrpl.BookQuality(dirKey)
+
+		for page := uint64(0); len(offers) < want; {
+			pageKey := xrpl.DirPageKey(dirKey, page)
+			blob, _, err := cassandra.FetchObject(session, pageKey, seq)
+			if err != nil {
+				findings = append(findings, finding{Book: b.String(), Kind: "missing_directory_page",
+					Message: fmt.Sprintf("directory page %X (page %d of %X) cannot be read: %s", pageKey, page, dirKey, err)})
+				break
+			}
+
+			dir, err := xrpl.Decode(blob)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decoding directory %X: %w", pageKey, err)
+			}
+
+			indexes, _ := dir["Indexes"].([]interface{})
+			for _, idx := range indexes {
+				key, _ := idx.(string)
+				rawKey, _ := hex.DecodeString(key)
+
+				obj, _, err := cassandra.FetchObject(session, rawKey, seq)
+				if err != nil {
+					findings = append(findings, finding{Book: b.String(), Kind: "dangling_index", Offer: key,
+						Message: fmt.Sprintf("directory %X lists %s but the offer cannot be read: %s", dirKey, key, err)})
+					continue
+				}
+
+				fields, err := xrpl.Decode(obj)
+				if err != nil {
+					return nil, nil, fmt.Errorf("decoding offer %s: %w", key, err)
+				}
+
+				if fields["LedgerEntryType"] != "Offer" {
+					findings = append(findings, finding{Book: b.String(), Kind: "not_an_offer", Offer: key,
+						Message: fmt.Sprintf("directory %X lists %s which is a %v", dirKey, key, fields["LedgerEntryType"])})
+					continue
+				}
+
+				if dirField, _ := fields["BookDirectory"].(string); !strings.EqualFold(dirField, hex.EncodeToString(dirKey)) {
+					findings = append(findings, finding{Book: b.String(), Kind: "wrong_directory", Offer: key,
+						Message: fmt.Sprintf("offer is listed in %X but its BookDirectory is %s", dirKey, dirField)})
+				}
+
+				offers = append(offers, offer{Key: key, Directory: fmt.Sprintf("%X", dirKey), Quality: quality, Fields: fields})
+			}
+
+			next, _ := dir["IndexNext"].(string)
+			if next == "" || next == "0" {
+				break
+			}
+
+			if _, err := fmt.Sscanf(next, "%x", &page); err != nil {
+				return nil, nil, fmt.Errorf("directory %X has invalid IndexNext %s", pageKey, next)
+			}
+		}
+	}
+
+	if len(offers) > want {
+		offers = offers[:want]
+	}
+
+	return offers, findings, nil
+}
+
+func fetchClioOffers(ctx context.Context, client *rpc.Client, b book, seq uint64, want int) ([]map[string]interface{}, error) {
+	result, err := client.Call(ctx, "book_offers", map[string]interface{}{
+		"taker_pays":   b.Pays.json(),
+		"taker_gets":   b.Gets.json(),
+		"ledger_index": seq,
+		"limit":        want,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := result["offers"].([]interface{})
+
+	var offers []map[string]interface{}
+	for _, o := range raw {
+		if m, ok := o.(map[string]interface{}); ok {
+			offers = append(offers, m)
+		}
+	}
+
+	return offers, nil
+}
+
+func sameAmount(a interface{}, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+func compareBook(b book, dbOffers []offer, clioOffers []map[string]interface{}) []finding {
+	var findings []finding
+
+	dbPos := make(map[string]int)
+	for i, o := range dbOffers {
+		dbPos[strings.ToUpper(o.Key)] = i
+	}
+
+	clioPos := make(map[string]int)
+	for i, o := range clioOffers {
+		key, _ := o["index"].(string)
+		key = strings.ToUpper(key)
+
+		if _, dup := clioPos[key]; dup {
+			findings = append(findings, finding{Book: b.String(), Kind: "duplicate", Offer: key, Message: "offer returned more than once by book_offers"})
+			continue
+		}
+		clioPos[key] = i
+
+		pos, ok := dbPos[key]
+		if !ok {
+			findings = append(findings, finding{Book: b.String(), Kind: "extra", Offer: key,
+				Message: fmt.Sprintf("book_offers returned offer at position %d that is not in the reconstructed top of the book", i)})
+			continue
+		}
+
+		if pos != i {
+			findings = append(findings, finding{Book: b.String(), Kind: "ordering", Offer: key,
+				Message: fmt.Sprintf("offer is at position %d in book_offers but at %d in the reconstructed book", i, pos)})
+		}
+
+		for _, field := range []string{"TakerGets", "TakerPays", "Account", "Sequence"} {
+			if !sameAmount(normalize(o[field]), normalize(dbOffers[pos].Fields[field])) {
+				findings = append(findings, finding{Book: b.String(), Kind: "field_mismatch", Offer: key,
+					Message: fmt.Sprintf("%s differs: book_offers has %v, objects table has %v", field, o[field], dbOffers[pos].Fields[field])})
+			}
+		}
+	}
+
+	for i, o := range dbOffers {
+		if _, ok := clioPos[strings.ToUpper(o.Key)]; !ok {
+			findings = append(findings, finding{Book: b.String(), Kind: "missing", Offer: o.Key,
+				Message: fmt.Sprintf("offer at position %d of the reconstructed book (directory %s) is missing from book_offers", i, o.Directory)})
+		}
+	}
+
+	var lastQuality uint64
+	for i, o := range clioOffers {
+		key, _ := o["index"].(string)
+		pos, ok := dbPos[strings.ToUpper(key)]
+		if !ok {
+			continue
+		}
+
+		if q := dbOffers[pos].Quality; q < lastQuality {
+			findings = append(findings, finding{Book: b.String(), Kind: "ordering", Offer: key,
+				Message: fmt.Sprintf("offer at position %d has a better quality than the offer before it", i)})
+		} else {
+			lastQuality = q
+		}
+	}
+
+	return findings
+}
+
+// book_offers renders numbers as json.Number while the decoder uses int64; compare their textual forms
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case json.Number:
+		return n.String()
+	case int64:
+		return fmt.Sprintf("%d", n)
+	}
+	return v
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	books, err := loadBooks()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(books) == 0 {
+		log.Fatal("Please specify a book with --taker-pays/--taker-gets or --books")
+	}
+
+	if *limit < 1 || *limit > maxBookOffersLimit {
+		log.Fatalf("--limit must be between 1 and %d\n", maxBookOffersLimit)
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	seq := *ledgerIdx
+	if seq == 0 {
+		if seq, err = cassandra.LatestLedger(session); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("Checking %d book(s) at ledger %d\n\n", len(books), seq)
+
+	ctx := context.Background()
+	client := rpc.NewClient(*clioURL, time.Duration(*clusterFlags.Timeout)*time.Millisecond)
+	rep := report{Tool: "book_checker", LedgerIndex: seq, Books: len(books)}
+
+	for _, b := range books {
+		dbOffers, findings, err := reconstructBook(session, b, seq, *limit)
+		if err != nil {
+			log.Fatalf("Failed to reconstruct %s: %s\n", b, err)
+		}
+
+		clioOffers, err := fetchClioOffers(ctx, client, b, seq, *limit)
+		if err != nil {
+			rep.Findings = append(rep.Findings, finding{Book: b.String(), Kind: "rpc_error", Message: err.Error()})
+			log.Printf("%s: book_offers failed: %s\n", b, err)
+			continue
+		}
+
+		findings = append(findings, compareBook(b, dbOffers, clioOffers)...)
+		rep.Compared += len(dbOffers)
+		rep.Findings = append(rep.Findings, findings...)
+
+		log.Printf("%s: %d offers reconstructed, %d returned by Clio, %d problems\n", b, len(dbOffers), len(clioOffers), len(findings))
+		for _, f := range findings {
+			log.Printf("  [%s] %s %s\n", f.Kind, f.Offer, f.Message)
+		}
+	}
+
+	rep.Passed = len(rep.Findings) == 0
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("\nTOTAL OFFERS COMPARED: %d\n", rep.Compared)
+	log.Printf("TOTAL PROBLEMS: %d\n", len(rep.Findings))
+
+	if !rep.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,431 @@
+//
+// Records a timeline of a Clio server's cache, memory and ETL state during an experiment.
+//
+// server_info is polled every --interval and the cache size, fill state and hit rates, the validated
+// ledger and the ETL writer state are written as one CSV row per sample. With --pid the resident memory
+// of the local Clio process is read from /proc, and every --metric is scraped from the Prometheus
+// endpoint given with --metrics.
+//
+// Samples are tagged with the label of the experiment phase they fall into. Phases come from --labels,
+// an NDJSON file of {"start", "end", "label"} objects with RFC 3339 times, or are set live through
+// POST /label?name=<label> on --listen, so load generators can mark the start of every run. A summary
+// per label is printed when the watcher is stopped with Ctrl-C or after --duration.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	clioURL    = kingpin.Arg("url", "JSON-RPC URL of the Clio server").Required().String()
+	interval   = kingpin.Flag("interval", "Polling interval").Default("5s").Duration()
+	runFor     = kingpin.Flag("duration", "Stop after this long, 0 to run until interrupted").Default("0s").Duration()
+	outFile    = kingpin.Flag("out", "CSV file to write the timeline to. Defaults to stdout").Short('o').String()
+	pid        = kingpin.Flag("pid", "PID of a local Clio process to read memory usage of").Int()
+	metricsURL = kingpin.Flag("metrics", "Clio Prometheus metrics URL").String()
+	metrics    = kingpin.Flag("metric", "Prometheus sample to record, as it appears in the exposition; can be repeated").Strings()
+	labelsFile = kingpin.Flag("labels", "NDJSON file of experiment phases: {\"start\", \"end\", \"label\"}").ExistingFile()
+	listen     = kingpin.Flag("listen", "Address to accept live POST /label?name=<label> requests on").String()
+)
+
+type phase struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Label string    `json:"label"`
+}
+
+type sample struct {
+	Time             time.Time
+	Label            string
+	CacheSize        float64
+	CacheFull        bool
+	CacheSeq         float64
+	ObjectHitRate    float64
+	SuccessorHitRate float64
+	ValidatedSeq     float64
+	ValidatedAge     float64
+	IsWriter         float64
+	ReadOnly         float64
+	PublishAge       float64
+	RSSBytes         float64
+	HWMBytes         float64
+	Metrics          []float64
+}
+
+type labels struct {
+	mu     sync.Mutex
+	phases []phase
+	live   string
+}
+
+// the live label wins over phases from the file
+func (l *labels) at(t time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.live != "" {
+		return l.live
+	}
+
+	for _, p := range l.phases {
+		if !t.Before(p.Start) && (p.End.IsZero() || t.Before(p.End)) {
+			return p.Label
+		}
+	}
+	return ""
+}
+
+func (l *labels) set(label string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.live = label
+}
+
+func loadPhases(path string) ([]phase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var phases []phase
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var p phase
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			return nil, fmt.Errorf("invalid phase %q: %w", line, err)
+		}
+		phases = append(phases, p)
+	}
+
+	return phases, scanner.Err()
+}
+
+func number(v interface{}) float64 {
+	switch t := v.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		return f
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	}
+	return math.NaN()
+}
+
+// reads VmRSS and VmHWM, in bytes
+func memory(pid int) (float64, float64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	var rss, hwm float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		kb, _ := strconv.ParseFloat(fields[1], 64)
+		switch fields[0] {
+		case "VmRSS:":
+			rss = kb * 1024
+		case "VmHWM:":
+			hwm = kb * 1024
+		}
+	}
+
+	return rss, hwm, scanner.Err()
+}
+
+const scrapeTimeout = 5 * time.Second
+
+// a metrics endpoint that stops answering must not stall the polling loop
+var scrapeClient = http.Client{Timeout: scrapeTimeout}
+
+func scrape(url string) (map[string]float64, error) {
+	resp, err := scrapeClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			samples[line[:i]] = v
+		}
+	}
+
+	return samples, scanner.Err()
+}
+
+func poll(ctx context.Context, client *rpc.Client) (*sample, error) {
+	s := &sample{Time: time.Now()}
+
+	result, err := client.Call(ctx, "server_info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info, _ := result["info"].(map[string]interface{})
+	cache, _ := info["cache"].(map[string]interface{})
+	s.CacheSize = number(cache["size"])
+	s.CacheFull, _ = cache["is_full"].(bool)
+	s.CacheSeq = number(cache["latest_ledger_seq"])
+	s.ObjectHitRate = number(cache["object_hit_rate"])
+	s.SuccessorHitRate = number(cache["successor_hit_rate"])
+
+	validated, _ := info["validated_ledger"].(map[string]interface{})
+	s.ValidatedSeq = number(validated["seq"])
+	s.ValidatedAge = number(validated["age"])
+
+	// only present for admin connections
+	etl, _ := info["etl"].(map[string]interface{})
+	s.IsWriter = number(etl["is_writer"])
+	s.ReadOnly = number(etl["read_only"])
+	s.PublishAge = number(etl["last_publish_age_seconds"])
+
+	s.RSSBytes, s.HWMBytes = math.NaN(), math.NaN()
+	if *pid != 0 {
+		if rss, hwm, err := memory(*pid); err == nil {
+			s.RSSBytes, s.HWMBytes = rss, hwm
+		} else {
+			log.Printf("ERROR: failed to read memory of process %d: %s\n", *pid, err)
+		}
+	}
+
+	if *metricsURL != "" {
+		values, err := scrape(*metricsURL)
+		if err != nil {
+			log.Printf("ERROR: failed to scrape %s: %s\n", *metricsURL, err)
+		}
+		for _, m := range *metrics {
+			v, ok := values[m]
+			if !ok {
+				v = math.NaN()
+			}
+			s.Metrics = append(s.Metrics, v)
+		}
+	}
+
+	return s, nil
+}
+
+func format(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+type aggregate struct {
+	samples       int
+	first, last   *sample
+	min, max, sum map[string]float64
+	count         map[string]int
+}
+
+func (a *aggregate) add(name string, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if _, ok := a.min[name]; !ok || v < a.min[name] {
+		a.min[name] = v
+	}
+	if _, ok := a.max[name]; !ok || v > a.max[name] {
+		a.max[name] = v
+	}
+	a.sum[name] += v
+	a.count[name]++
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	l := &labels{}
+	if *labelsFile != "" {
+		phases, err := loadPhases(*labelsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l.phases = phases
+	}
+
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/label", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "POST only", http.StatusMethodNotAllowed)
+				return
+			}
+			name := r.URL.Query().Get("name")
+			l.set(name)
+			log.Printf("Label set to %q\n", name)
+		})
+
+		go func() {
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	out := os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := csv.NewWriter(out)
+	header := []string{"time", "elapsed_s", "label", "cache_size", "cache_full", "cache_latest_seq", "object_hit_rate", "successor_hit_rate",
+		"validated_seq", "validated_age", "is_writer", "read_only", "last_publish_age_s", "rss_bytes", "hwm_bytes"}
+	w.Write(append(header, *metrics...))
+	w.Flush()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *runFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *runFor)
+		defer cancel()
+	}
+
+	client := rpc.NewClient(*clioURL, *interval)
+	startTime := time.Now()
+	aggregates := make(map[string]*aggregate)
+	var order []string
+	var failures int
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		s, err := poll(ctx, client)
+		if err != nil && ctx.Err() == nil {
+			failures++
+			log.Printf("ERROR: server_info failed: %s\n", err)
+		}
+
+		if s != nil {
+			s.Label = l.at(s.Time)
+			row := []string{
+				s.Time.UTC().Format(time.RFC3339Nano), format(s.Time.Sub(startTime).Seconds()), s.Label,
+				format(s.CacheSize), strconv.FormatBool(s.CacheFull), format(s.CacheSeq), format(s.ObjectHitRate), format(s.SuccessorHitRate),
+				format(s.ValidatedSeq), format(s.ValidatedAge), format(s.IsWriter), format(s.ReadOnly), format(s.PublishAge),
+				format(s.RSSBytes), format(s.HWMBytes),
+			}
+			for _, v := range s.Metrics {
+				row = append(row, format(v))
+			}
+			w.Write(row)
+			w.Flush()
+
+			a, ok := aggregates[s.Label]
+			if !ok {
+				a = &aggregate{first: s, min: make(map[string]float64), max: make(map[string]float64), sum: make(map[string]float64), count: make(map[string]int)}
+				aggregates[s.Label] = a
+				order = append(order, s.Label)
+			}
+			a.samples++
+			a.last = s
+			a.add("cache_size", s.CacheSize)
+			a.add("object_hit_rate", s.ObjectHitRate)
+			a.add("successor_hit_rate", s.SuccessorHitRate)
+			a.add("rss_mb", s.RSSBytes/(1024*1024))
+			a.add("validated_age", s.ValidatedAge)
+		}
+
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+	}
+
+	log.Println()
+	log.Printf("TOTAL SAMPLES FAILED: %d\n", failures)
+	for _, label := range order {
+		a := aggregates[label]
+		name := label
+		if name == "" {
+			name = "(unlabelled)"
+		}
+
+		span := a.last.Time.Sub(a.first.Time)
+		log.Printf("%s: %d samples over %s\n", name, a.samples, span.Round(time.Second))
+
+		var keys []string
+		for k := range a.sum {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			log.Printf("  %-20s min %14.3f  mean %14.3f  max %14.3f\n", k, a.min[k], a.sum[k]/float64(a.count[k]), a.max[k])
+		}
+
+		if span > 0 && !math.IsNaN(a.first.CacheSize) && !math.IsNaN(a.last.CacheSize) {
+			log.Printf("  cache growth         %.1f objects/s\n", (a.last.CacheSize-a.first.CacheSize)/span.Seconds())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package cassandra contains what the Clio tools reading a keyspace share: the cluster flags and
+// configuration, the ledger_range lookups and the token ranges of parallel full table scans.
+package cassandra
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+)
+
+// Flags are the cluster flags every tool takes. The hosts and the keyspace stay with the tools, which
+// take them as arguments or flags with defaults of their own
+type Flags struct {
+	Consistency *string
+	Timeout     *int
+	Username    *string
+	Password    *string
+}
+
+// flagger is a kingpin application or one of its commands
+type flagger interface {
+	Flag(name string, help string) *kingpin.FlagClause
+}
+
+// RegisterFlags adds --consistency (-o), --timeout (-t), --username and --password to the application or
+// command
+func RegisterFlags(app flagger) *Flags {
+	return &Flags{
+		Consistency: app.Flag("consistency", "Cluster consistency level. Use 'localone' for multi DC").Short('o').Default("localquorum").String(),
+		Timeout:     app.Flag("timeout", "Maximum duration for query execution in millisecond").Short('t').Default("15000").Int(),
+		Username:    app.Flag("username", "Username to use when connecting to the cluster").String(),
+		Password:    app.Flag("password", "Password to use when connecting to the cluster").String(),
+	}
+}
+
+// NewCluster returns the configuration of the cluster of the comma separated hosts; the keyspace is left
+// unset when empty
+func (f *Flags) NewCluster(hosts string, keyspace string) *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Consistency = GetConsistencyLevel(*f.Consistency)
+	cluster.Timeout = time.Duration(*f.Timeout) * time.Millisecond
+	cluster.Keyspace = keyspace
+
+	if *f.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: *f.Username,
+			Password: *f.Password,
+		}
+	}
+
+	return cluster
+}
+
+// GetConsistencyLevel maps a --consistency value to its level; unknown values are ONE
+func GetConsistencyLevel(consistencyValue string) gocql.Consistency {
+	switch consistencyValue {
+	case "any":
+		return gocql.Any
+	case "one":
+		return gocql.One
+	case "two":
+		return gocql.Two
+	case "three":
+		return gocql.Three
+	case "quorum":
+		return gocql.Quorum
+	case "all":
+		return gocql.All
+	case "localquorum":
+		return gocql.LocalQuorum
+	case "eachquorum":
+		return gocql.EachQuorum
+	case "localone":
+		return gocql.LocalOne
+	default:
+		return gocql.One
+	}
+}
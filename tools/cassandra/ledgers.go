@@ -0,0 +1,24 @@
+package cassandra
+
+import "github.com/gocql/gocql"
+
+// GetLedgerRange returns the earliest and the latest ledger of ledger_range, or gocql.ErrNotFound when
+// the keyspace holds no ledgers yet
+func GetLedgerRange(session *gocql.Session) (uint64, uint64, error) {
+	var first, latest uint64
+	if err := session.Query("select sequence from ledger_range where is_latest = ?", false).Scan(&first); err != nil {
+		return 0, 0, err
+	}
+	if err := session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&latest); err != nil {
+		return 0, 0, err
+	}
+	return first, latest, nil
+}
+
+// LatestLedger returns the latest ledger of ledger_range, or gocql.ErrNotFound when the keyspace holds no
+// ledgers yet
+func LatestLedger(session *gocql.Session) (uint64, error) {
+	var latest uint64
+	err := session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&latest)
+	return latest, err
+}
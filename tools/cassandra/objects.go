@@ -0,0 +1,27 @@
+package cassandra
+
+import (
+	"fmt"
+
+	"github.com/gocql/gocql"
+)
+
+// ErrDeleted is returned for objects whose newest version is the empty blob Clio writes when an object is
+// deleted. It matches gocql.ErrNotFound, so that callers not telling the two apart treat both as absent
+var ErrDeleted = fmt.Errorf("object deleted: %w", gocql.ErrNotFound)
+
+// FetchObject returns the newest version of the object at or before seq and the ledger it was written in.
+// Objects that never existed are gocql.ErrNotFound; deleted ones are ErrDeleted, along with the ledger
+// that deleted them
+func FetchObject(session *gocql.Session, key []byte, seq uint64) ([]byte, uint64, error) {
+	var object []byte
+	var objectSeq uint64
+	if err := session.Query("SELECT object, sequence FROM objects WHERE key = ? AND sequence <= ? ORDER BY sequence DESC LIMIT 1", key, seq).Scan(&object, &objectSeq); err != nil {
+		return nil, 0, err
+	}
+
+	if len(object) == 0 {
+		return nil, objectSeq, ErrDeleted
+	}
+	return object, objectSeq, nil
+}
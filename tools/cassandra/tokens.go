@@ -0,0 +1,56 @@
+package cassandra
+
+import (
+	"math"
+	"math/rand"
+)
+
+// TokenRange is a slice of the Murmur3 token ring, scanned with token(key) >= ? AND token(key) <= ?
+type TokenRange struct {
+	StartRange int64
+	EndRange   int64
+}
+
+// GetTokenRanges splits the token ring into 100 ranges per worker, so that the workers stay busy while
+// the ranges differ in size
+func GetTokenRanges(workerCount int) []*TokenRange {
+	var n = workerCount
+	var m = int64(n * 100)
+	var maxSize uint64 = math.MaxInt64 * 2
+	var rangeSize = maxSize / uint64(m)
+
+	var start int64 = math.MinInt64
+	var end int64
+	var shouldBreak = false
+
+	var ranges = make([]*TokenRange, m)
+
+	for i := int64(0); i < m; i++ {
+		end = start + int64(rangeSize)
+		if start > 0 && end < 0 {
+			end = math.MaxInt64
+			shouldBreak = true
+		}
+
+		ranges[i] = &TokenRange{StartRange: start, EndRange: end}
+
+		if shouldBreak {
+			ranges = ranges[:i+1]
+			break
+		}
+
+		start = end + 1
+	}
+
+	return ranges
+}
+
+// Shuffle spreads the ranges that are scanned at the same time over the nodes
+func Shuffle(data []*TokenRange) {
+	for i := 1; i < len(data); i++ {
+		r := rand.Intn(i + 1)
+		if i != r {
+			data[r], data[i] = data[i], data[r]
+		}
+	}
+}
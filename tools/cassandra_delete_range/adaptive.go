@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"xrplf/clio/cassandra_delete_range/internal/cass"
+)
+
+const (
+	minAdaptivePageSize = 100
+	minAdaptivePage     = 0.05 // smallest fraction of the configured page size
+)
+
+// adaptiveLimit caps how many workers of a table query the cluster at once, and scales the page size of
+// its scan, from the latency and transient error rate of the queries of every --adaptive-interval. It
+// starts at a quarter of the workers, halves the limit when the cluster struggles and grows it again by
+// a twentieth of the workers while it keeps up
+type adaptiveLimit struct {
+	table   string
+	phase   string
+	workers int
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	active    int
+	pageScale float64
+	queries   int
+	failures  int
+	latency   time.Duration
+	lowest    time.Duration // lowest average latency of an interval, the baseline of the target latency
+
+	done chan struct{}
+}
+
+// newAdaptiveLimit returns nil unless --adaptive
+func newAdaptiveLimit(table string, phase string, workers int) *adaptiveLimit {
+	if !*adaptive {
+		return nil
+	}
+
+	l := &adaptiveLimit{
+		table:     table,
+		phase:     phase,
+		workers:   workers,
+		limit:     max(1, workers/4),
+		pageScale: 1,
+		done:      make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mu)
+
+	go l.run()
+	return l
+}
+
+func (l *adaptiveLimit) run() {
+	ticker := time.NewTicker(*adaptiveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.adjust()
+		}
+	}
+}
+
+func (l *adaptiveLimit) stop() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+}
+
+// acquire blocks until the worker may run a query
+func (l *adaptiveLimit) acquire() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *adaptiveLimit) release() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.cond.Signal()
+}
+
+// timed wraps a query attempt to account its latency and outcome
+func (l *adaptiveLimit) timed(op func() error) func() error {
+	if l == nil {
+		return op
+	}
+
+	return func() error {
+		start := time.Now()
+		err := op()
+		elapsed := time.Since(start)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.queries++
+		l.latency += elapsed
+		if err != nil && cass.Retryable(err) {
+			l.failures++
+		}
+		return err
+	}
+}
+
+// pageSize scales the configured page size of the table
+func (l *adaptiveLimit) pageSize(size int) int {
+	if l == nil {
+		return size
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(min(size, minAdaptivePageSize), int(float64(size)*l.pageScale))
+}
+
+func (l *adaptiveLimit) adjust() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.queries == 0 {
+		return
+	}
+
+	average := l.latency / time.Duration(l.queries)
+	errorRate := float64(l.failures) / float64(l.queries)
+	if l.lowest == 0 || average < l.lowest {
+		l.lowest = average
+	}
+
+	target := *adaptiveTargetLatency
+	if target <= 0 {
+		target = 3 * l.lowest
+	}
+
+	limit, pageScale := l.limit, l.pageScale
+	switch {
+	case errorRate > *adaptiveMaxErrorRate:
+		// timeouts of the scan are the ones a smaller page helps with
+		l.limit = max(1, l.limit/2)
+		l.pageScale = max(minAdaptivePage, l.pageScale/2)
+	case average > target:
+		l.limit = max(1, l.limit/2)
+	default:
+		l.limit = min(l.workers, l.limit+max(1, l.workers/20))
+		l.pageScale = min(1, l.pageScale*1.25)
+	}
+
+	l.queries, l.failures, l.latency = 0, 0, 0
+	workerLimitGauge.WithLabelValues(l.table, l.phase).Set(float64(l.limit))
+
+	if l.limit != limit || l.pageScale != pageScale {
+		log.Printf("ADAPTIVE %s %s: %d -> %d of %d workers, page size at %.0f%% (average latency %s, %.1f%% transient errors)\n",
+			l.table, l.phase, limit, l.limit, l.workers, l.pageScale*100, average.Round(time.Millisecond), errorRate*100)
+	}
+
+	l.cond.Broadcast()
+}
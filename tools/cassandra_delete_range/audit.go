@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// auditLog records every row a run deleted in a gzip compressed file of tab separated lines:
+//
+//	objects	<key hex>	<sequence>	=
+//	objects	<key hex>	<sequence>	>=
+//	ledgers	-	<sequence>	=
+//
+// where >= marks range deletes of every version of the key from the sequence on, window the deletes of
+// all versions of the window, and - the tables deleted by ledger. Resumed runs append another gzip member,
+// which gzip readers read as one stream
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(f)
+	return &auditLog{file: f, gz: gz, buf: bufio.NewWriter(gz)}, nil
+}
+
+func (a *auditLog) record(table string, query string, rows []deleteParams) {
+	if a == nil {
+		return
+	}
+
+	op := deleteOp(table, query)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, r := range rows {
+		if len(r.Blob) > 0 {
+			fmt.Fprintf(a.buf, "%s\t%x\t%d\t%s\n", table, r.Blob, r.Seq, op)
+		} else {
+			fmt.Fprintf(a.buf, "%s\t-\t%d\t%s\n", table, r.Seq, op)
+		}
+	}
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.buf.Flush(); err != nil {
+		return err
+	}
+	if err := a.gz.Close(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
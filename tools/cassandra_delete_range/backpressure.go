@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const backpressureTimeout = 5 * time.Second
+
+// clusterBackpressure caps how many queries all workers run at once while a node of the cluster falls
+// behind, as told by the Scylla REST API of every node each --backpressure-interval: pending compactions,
+// hints in progress and mutations dropped since the last poll. It halves the cap on every poll a node
+// struggles and raises it again by a twentieth on every poll they all keep up, until it is lifted
+type clusterBackpressure struct {
+	nodes  []string
+	client http.Client
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int // queries at once, 0 while not capped
+	full   int // queries at once when the cap was set
+	active int
+	peak   int // most queries at once since the last poll
+
+	dropped map[string]int64 // dropped mutations of every node at the last poll
+	failing map[string]bool  // metrics that could not be read, warned about once
+}
+
+var backpressure *clusterBackpressure // nil unless --backpressure
+
+func startBackpressure(cluster *gocql.ClusterConfig) {
+	if !*backpressureEnabled {
+		return
+	}
+	if *backpressureInterval <= 0 {
+		fatal(exitInvalid, "--backpressure-interval must be positive")
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+	nodes := knownNodes(cluster, session)
+	session.Close()
+
+	b := &clusterBackpressure{
+		client:  http.Client{Timeout: backpressureTimeout},
+		dropped: make(map[string]int64),
+		failing: make(map[string]bool),
+	}
+	for _, node := range nodes {
+		host := node
+		if h, _, err := net.SplitHostPort(node); err == nil {
+			host = h
+		}
+		b.nodes = append(b.nodes, host)
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	// the first poll only records the dropped mutations so far
+	b.struggles()
+	backpressure = b
+	log.Printf("Watching the backpressure of %d nodes every %s\n", len(b.nodes), *backpressureInterval)
+
+	go b.run()
+}
+
+func (b *clusterBackpressure) run() {
+	ticker := time.NewTicker(*backpressureInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.adjust(b.struggles())
+	}
+}
+
+// read fetches one metric of a node; metrics the node does not answer for are not watched on it
+func (b *clusterBackpressure) read(host string, path string, v any) bool {
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, fmt.Sprint(*scyllaAPIPort)), Path: path}
+
+	err := func() error {
+		resp, err := b.client.Get(u.String())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s answered %s", u.String(), resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}()
+
+	if err != nil {
+		if !b.failing[u.String()] {
+			b.failing[u.String()] = true
+			log.Printf("WARNING: not watching %s of %s: %s\n", path, host, err)
+		}
+		return false
+	}
+	if b.failing[u.String()] {
+		delete(b.failing, u.String())
+		log.Printf("Watching %s of %s again\n", path, host)
+	}
+	return true
+}
+
+// struggles polls every node and returns why the cluster falls behind, nothing when it keeps up
+func (b *clusterBackpressure) struggles() []string {
+	var reasons []string
+	for _, host := range b.nodes {
+		var pending int64
+		if b.read(host, "/compaction_manager/metrics/pending_tasks", &pending) && pending > *maxPendingCompactions {
+			reasons = append(reasons, fmt.Sprintf("%d pending compactions on %s", pending, host))
+		}
+
+		var hints int64
+		if b.read(host, "/storage_proxy/hints_in_progress", &hints) && hints > *maxHintsInProgress {
+			reasons = append(reasons, fmt.Sprintf("%d hints in progress on %s", hints, host))
+		}
+
+		var verbs []struct {
+			Verb  string `json:"verb"`
+			Count int64  `json:"count"`
+		}
+		if b.read(host, "/messaging_service/messages/dropped_by_ver", &verbs) {
+			var dropped int64
+			for _, v := range verbs {
+				if v.Verb == "MUTATION" {
+					dropped += v.Count
+				}
+			}
+			last, seen := b.dropped[host]
+			b.dropped[host] = dropped
+			if seen && dropped-last > *maxDroppedMutations {
+				reasons = append(reasons, fmt.Sprintf("%d mutations dropped on %s", dropped-last, host))
+			}
+		}
+	}
+	return reasons
+}
+
+func (b *clusterBackpressure) adjust(reasons []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	limit := b.limit
+	switch {
+	case len(reasons) > 0:
+		if b.limit == 0 {
+			b.full = max(1, b.peak)
+			b.limit = b.full
+		}
+		b.limit = max(1, b.limit/2)
+	case b.limit > 0:
+		b.limit += max(1, b.full/20)
+		if b.limit >= b.full {
+			b.limit = 0
+		}
+	}
+	b.peak = b.active
+
+	switch {
+	case len(reasons) > 0:
+		log.Printf("BACKPRESSURE %s: %s -> %d queries at once\n", strings.Join(reasons, ", "), queriesDescription(limit), b.limit)
+	case b.limit != limit:
+		log.Printf("BACKPRESSURE the cluster keeps up: %s -> %s queries at once\n", queriesDescription(limit), queriesDescription(b.limit))
+	}
+
+	b.cond.Broadcast()
+}
+
+// acquire blocks until the worker may run a query
+func (b *clusterBackpressure) acquire() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.limit > 0 && b.active >= b.limit {
+		b.cond.Wait()
+	}
+	b.active++
+	b.peak = max(b.peak, b.active)
+}
+
+func (b *clusterBackpressure) release() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.active--
+	b.cond.Signal()
+}
+
+func queriesDescription(limit int) string {
+	if limit == 0 {
+		return "all"
+	}
+	return fmt.Sprint(limit)
+}
+
+func backpressureDescription() string {
+	if !*backpressureEnabled {
+		return "off"
+	}
+	return fmt.Sprintf("every %s, pending compactions > %d, hints in progress > %d, dropped mutations > %d",
+		*backpressureInterval, *maxPendingCompactions, *maxHintsInProgress, *maxDroppedMutations)
+}
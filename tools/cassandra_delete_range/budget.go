@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// queries a run makes before --max-errors judges a percentage, so that the first failure does not stop it
+const minBudgetQueries = 1000
+
+// errorBudget stops the run once more scans and deletes failed than --max-errors allows
+type errorBudget struct {
+	limit    uint64  // absolute number of failed queries; 0 when a fraction is given
+	fraction float64 // failed queries per query made
+	spec     string
+
+	errors   atomic.Uint64
+	exceeded atomic.Bool
+}
+
+var budget *errorBudget // nil unless --max-errors
+
+// parseErrorBudget reads --max-errors: a number of failed queries, or a percentage of the queries made
+func parseErrorBudget(spec string) (*errorBudget, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	if percent, ok := strings.CutSuffix(spec, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("--max-errors %s: percentage must be above 0 and at most 100", spec)
+		}
+		return &errorBudget{fraction: p / 100, spec: spec}, nil
+	}
+
+	n, err := strconv.ParseUint(spec, 10, 64)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("--max-errors %s: must be a positive number or a percentage like 1%%", spec)
+	}
+	return &errorBudget{limit: n, spec: spec}, nil
+}
+
+// add counts failed queries and stops the run when they are over the budget
+func (b *errorBudget) add(n uint64) {
+	if b == nil {
+		return
+	}
+
+	failed := b.errors.Add(n)
+	over := false
+	if b.limit > 0 {
+		over = failed > b.limit
+	} else if queries := atomic.LoadUint64(&usage.queries); queries >= minBudgetQueries {
+		over = float64(failed) > b.fraction*float64(queries)
+	}
+
+	if over && b.exceeded.CompareAndSwap(false, true) {
+		stopRun(fmt.Sprintf("%d queries failed, more than --max-errors %s", failed, b.spec))
+	}
+}
+
+// wasExceeded tells whether the budget stopped the run
+func (b *errorBudget) wasExceeded() bool {
+	return b != nil && b.exceeded.Load()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// compactPrunedTables runs a major compaction of the tables the run deleted from on every node, one node
+// after the other, so that the tombstones and the data they shadow are dropped from disk. Only tombstones
+// older than gc_grace_seconds are purged, younger ones stay until a later compaction
+func compactPrunedTables(cluster *gocql.ClusterConfig, report *runReport) error {
+	var tables []string
+	for _, t := range report.Tables {
+		if t.Deletes > 0 {
+			tables = append(tables, t.Table)
+		}
+	}
+	if len(tables) == 0 {
+		log.Println("Not compacting: nothing was deleted")
+		return nil
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		return err
+	}
+	nodes := knownNodes(cluster, session)
+	session.Close()
+
+	var failed int
+	for _, node := range nodes {
+		host := node
+		if h, _, err := net.SplitHostPort(node); err == nil {
+			host = h
+		}
+
+		log.Printf("Compacting %s of %s on %s\n", strings.Join(tables, ", "), *keyspace, host)
+		started := time.Now()
+
+		var err error
+		if *compaction == "scylla" {
+			err = compactScylla(host, tables)
+		} else {
+			err = compactNodetool(host, tables)
+		}
+
+		if err != nil {
+			log.Printf("ERROR compaction on %s failed: %s\n", host, err)
+			failed++
+			continue
+		}
+		log.Printf("Compacted on %s in %s\n", host, time.Since(started).Round(time.Second))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("compaction failed on %d of %d nodes", failed, len(nodes))
+	}
+	return nil
+}
+
+// compactScylla asks the REST API of a Scylla node for a major compaction, which answers once it is done
+func compactScylla(host string, tables []string) error {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     net.JoinHostPort(host, fmt.Sprint(*scyllaAPIPort)),
+		Path:     "/storage_service/keyspace_compaction/" + url.PathEscape(*keyspace),
+		RawQuery: url.Values{"cf": {strings.Join(tables, ",")}}.Encode(),
+	}
+
+	resp, err := http.Post(u.String(), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", u.String(), resp.Status)
+	}
+	return nil
+}
+
+// compactNodetool runs nodetool compact against the JMX port of a Cassandra node
+func compactNodetool(host string, tables []string) error {
+	args := append([]string{"-h", host, "-p", fmt.Sprint(*jmxPort), "compact", *keyspace}, tables...)
+	out, err := exec.Command(*nodetoolPath, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", *nodetoolPath, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
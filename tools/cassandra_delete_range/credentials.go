@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// credentials is the content of --credentials-file, YAML or JSON
+type credentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// loadCredentials returns the username and password to connect with. --username and --password, or the
+// CASSANDRA_USERNAME and CASSANDRA_PASSWORD environment variables they default to, win over the ones of
+// --credentials-file
+func loadCredentials() (string, string) {
+	for _, arg := range os.Args[1:] {
+		if arg == "--password" || strings.HasPrefix(arg, "--password=") {
+			log.Println("WARNING: --password shows in the shell history and process listings; use CASSANDRA_PASSWORD or --credentials-file instead")
+			break
+		}
+	}
+
+	username, password := *userName, *password
+	if *credentialsFile == "" {
+		return username, password
+	}
+
+	info, err := os.Stat(*credentialsFile)
+	if err != nil {
+		fatal(exitInvalid, err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Printf("WARNING: %s can be read by other users, restrict it with chmod 600\n", *credentialsFile)
+	}
+
+	data, err := os.ReadFile(*credentialsFile)
+	if err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	var c credentials
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		fatalf(exitInvalid, "Invalid credentials file %s: %s", *credentialsFile, err)
+	}
+
+	if username == "" {
+		username = c.Username
+	}
+	if password == "" {
+		password = c.Password
+	}
+	return username, password
+}
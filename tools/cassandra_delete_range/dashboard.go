@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dashboardInterval = time.Second
+	dashboardFailures = 8
+	dashboardBarWidth = 30
+)
+
+// liveDashboard redraws the progress of every table phase, its live rate and errors, and the latest
+// failures on the terminal instead of scrolling logs past. The logs, and stderr when it is the terminal
+// too, go to --dashboard-log meanwhile
+type liveDashboard struct {
+	title   string
+	started time.Time
+	logFile *os.File
+	stderr  *os.File
+
+	mu        sync.Mutex
+	phases    []*progress
+	lastItems map[*progress]uint64
+	failures  []string
+	partial   []byte
+
+	done      chan struct{}
+	completed chan struct{}
+}
+
+var dashboard *liveDashboard // nil unless --dashboard
+
+func startDashboard(title string) {
+	if !stdoutIsTerminal() {
+		log.Println("WARNING: not showing the dashboard, stdout is not a terminal")
+		return
+	}
+
+	f, err := os.OpenFile(*dashboardLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Showing the dashboard, logs go to %s\n", *dashboardLog)
+
+	d := &liveDashboard{
+		title:     title,
+		started:   time.Now(),
+		logFile:   f,
+		stderr:    os.Stderr,
+		lastItems: make(map[*progress]uint64),
+		done:      make(chan struct{}),
+		completed: make(chan struct{}),
+	}
+
+	log.SetOutput(d)
+	if stderrIsTerminal() {
+		os.Stderr = f
+	}
+
+	dashboard = d
+	go d.run()
+}
+
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write takes the log output: it goes to the log file, and errors and warnings also to the failures shown
+func (d *liveDashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(d.partial[:i])
+		d.partial = d.partial[i+1:]
+		if strings.Contains(line, "ERROR") || strings.Contains(line, "WARNING") {
+			d.failures = append(d.failures, line)
+			if len(d.failures) > dashboardFailures {
+				d.failures = d.failures[1:]
+			}
+		}
+	}
+
+	return d.logFile.Write(p)
+}
+
+func (d *liveDashboard) add(p *progress) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.phases = append(d.phases, p)
+}
+
+func (d *liveDashboard) run() {
+	defer close(d.completed)
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+
+	for {
+		d.draw()
+		select {
+		case <-d.done:
+			d.draw()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *liveDashboard) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "%s, running for %s\n", d.title, time.Since(d.started).Round(time.Second))
+
+	state := "running"
+	if interrupted() {
+		state = "stopping"
+	} else if gate != nil {
+		gate.mu.Lock()
+		if gate.paused {
+			state = "PAUSED, waiting for the cluster to recover"
+		}
+		gate.mu.Unlock()
+	}
+	fmt.Fprintf(&b, "%s, %d retries, %d bytes read\n\n", state, retry.Retries(), atomic.LoadUint64(&usage.readBytes))
+
+	for _, p := range d.phases {
+		steps := atomic.LoadUint64(&p.steps)
+		items := atomic.LoadUint64(&p.items)
+		errors := atomic.LoadUint64(&p.errors)
+
+		fraction := 1.0
+		if p.total > 0 {
+			fraction = min(1, float64(steps)/float64(p.total))
+		}
+		filled := int(fraction * dashboardBarWidth)
+		bar := strings.Repeat("#", filled) + strings.Repeat(".", dashboardBarWidth-filled)
+
+		rate := "done"
+		select {
+		case <-p.completed:
+		default:
+			rate = fmt.Sprintf("%.0f %s/s", float64(items-d.lastItems[p])/dashboardInterval.Seconds(), p.itemUnit)
+		}
+		d.lastItems[p] = items
+
+		fmt.Fprintf(&b, "%-20s %-7s [%s] %5.1f%% %d/%d %s, %d %s, %s, %d errors\n",
+			p.table, p.phase, bar, fraction*100, steps, p.total, p.stepUnit, items, p.itemUnit, rate, errors)
+	}
+
+	if len(d.failures) > 0 {
+		b.WriteString("\nLatest errors and warnings:\n")
+		for _, f := range d.failures {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+	}
+
+	os.Stdout.WriteString(b.String())
+}
+
+// stop draws the final state and gives the terminal back to the logs
+func (d *liveDashboard) stop() {
+	if d == nil {
+		return
+	}
+
+	close(d.done)
+	<-d.completed
+
+	log.SetOutput(os.Stdout)
+	os.Stderr = d.stderr
+	d.logFile.Close()
+	log.Printf("Logs of the run are in %s\n", *dashboardLog)
+}
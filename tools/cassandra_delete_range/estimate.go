@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"xrplf/clio/cassandra"
+)
+
+// estimateRun turns a prune into a dry run of its scans, possibly over a sample of the token ranges
+type estimateRun struct {
+	sample float64 // fraction of the token ranges scanned
+}
+
+var estimating *estimateRun // nil unless the estimate command
+
+func runEstimate() {
+	window := windowOfFlags(*estimateAfter, *estimateFrom, *estimateTo, *estimateKeepLatest)
+
+	if *estimateSample <= 0 || *estimateSample > 1 {
+		fatal(exitInvalid, "Please specify a --sample between 0 (exclusive) and 1")
+	}
+
+	estimating = &estimateRun{sample: *estimateSample}
+	prune(*estimateHosts, window)
+}
+
+// windowOfFlags is the window of the --after, --from and --to, or --keep-latest flags of the commands that
+// scan without deleting
+func windowOfFlags(after uint64, from uint64, to uint64, keepLatest uint64) ledgerWindow {
+	var windows []ledgerWindow
+	if after > 0 {
+		windows = append(windows, ledgerWindow{from: after + 1, open: true})
+	}
+	if from > 0 || to > 0 {
+		if from == 0 || to < from {
+			fatal(exitInvalid, "Please specify a window of ledgers with 0 < --from <= --to")
+		}
+		windows = append(windows, ledgerWindow{from: from, to: to})
+	}
+	if keepLatest > 0 {
+		windows = append(windows, ledgerWindow{keepLatest: keepLatest})
+	}
+
+	if len(windows) != 1 {
+		fatal(exitInvalid, "Please specify exactly one of --after, --from and --to, or --keep-latest")
+	}
+	return windows[0]
+}
+
+// sampleRanges keeps the share of the shuffled token ranges to scan
+func (e *estimateRun) sampleRanges(all []*cassandra.TokenRange) []*cassandra.TokenRange {
+	n := max(1, int(float64(len(all))*e.sample))
+	e.sample = float64(n) / float64(len(all))
+	return all[:n]
+}
+
+// print extrapolates the scans of the sample to the whole tables. Deletes are expected to go at
+// --max-delete-rate, or else at the rate all workers achieve with the mean query latency of the scans
+func (e *estimateRun) print(report *runReport) {
+	var latency time.Duration
+	var queries uint64
+	for _, h := range usage.perHost() {
+		latency += h.latency
+		queries += h.Queries
+	}
+
+	deleteRate := *maxDeleteRate
+	if deleteRate <= 0 && queries > 0 {
+		deleteRate = float64(workerCount) / (latency.Seconds() / float64(queries))
+	}
+
+	fmt.Printf("\nEstimate of deleting %d -> %d from %.1f%% of the token ranges\n", report.FromLedger, report.ToLedger, e.sample*100)
+	fmt.Println("=====================")
+	fmt.Printf("%-20s %14s %14s %12s %12s\n", "table", "rows", "deletes", "scan time", "delete time")
+
+	var totalRows, totalDeletes float64
+	var totalScan, totalDelete time.Duration
+	for _, t := range report.Tables {
+		// the deletes of the other tables are one per ledger, they are not sampled
+		scale := 1.0
+		if queryTemplates[t.Table].Scan != "" {
+			scale = 1 / e.sample
+		}
+
+		rows := float64(t.RowsTraversed) * scale
+		deletes := float64(t.Deletes) * scale
+		scanTime := time.Duration(t.DurationSec * scale * float64(time.Second))
+		var deleteTime time.Duration
+		if deleteRate > 0 {
+			deleteTime = time.Duration(deletes / deleteRate * float64(time.Second))
+		}
+
+		fmt.Printf("%-20s %14.0f %14.0f %12s %12s\n", t.Table, rows, deletes, scanTime.Round(time.Second), deleteTime.Round(time.Second))
+		totalRows += rows
+		totalDeletes += deletes
+		totalScan += scanTime
+		totalDelete += deleteTime
+	}
+
+	fmt.Printf("%-20s %14.0f %14.0f %12s %12s\n", "total", totalRows, totalDeletes, totalScan.Round(time.Second), totalDelete.Round(time.Second))
+	fmt.Printf("\nExpected runtime: %s at %.0f deletes per second\n", (totalScan + totalDelete).Round(time.Second), deleteRate)
+}
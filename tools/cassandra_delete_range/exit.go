@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// exit codes, so that scripts running the tool can tell its failures apart
+const (
+	exitFailed       = 1   // any other failure; log.Fatal exits with it too
+	exitInvalid      = 2   // invalid options, a window that does not fit the ledger range in DB or refused deletes
+	exitConnection   = 3   // the cluster could not be reached, or did not recover from a pause
+	exitPartial      = 4   // the run completed with more failed queries than --allowed-errors
+	exitVerifyFailed = 5   // verify found rows outside of the ledger range
+	exitAborted      = 130 // the operator declined to continue or interrupted the run, as shells report SIGINT
+)
+
+func fatal(code int, v ...any) {
+	log.Print(v...)
+	os.Exit(code)
+}
+
+func fatalf(code int, format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fleetTimeout = 10 * time.Second
+
+type serverInfoResponse struct {
+	Result struct {
+		Status string `json:"status"`
+		Info   struct {
+			CompleteLedgers string `json:"complete_ledgers"`
+		} `json:"info"`
+	} `json:"result"`
+}
+
+// earliestCompleteLedger asks a rippled or Clio server for server_info and returns the first ledger of its
+// complete_ledgers, i.e. 32570 of "32570-90000000,90000005-90000010"
+func earliestCompleteLedger(endpoint string) (uint64, error) {
+	body := []byte(`{"method": "server_info", "params": [{}]}`)
+	client := http.Client{Timeout: fleetTimeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server_info: HTTP %s", resp.Status)
+	}
+
+	var info serverInfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("server_info: %w", err)
+	}
+
+	complete := strings.TrimSpace(info.Result.Info.CompleteLedgers)
+	if complete == "" || complete == "empty" {
+		return 0, fmt.Errorf("server_info: no complete ledgers (status %q)", info.Result.Status)
+	}
+
+	first, _, _ := strings.Cut(strings.Split(complete, ",")[0], "-")
+	seq, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("server_info: complete_ledgers %q: %w", complete, err)
+	}
+	return seq, nil
+}
+
+// checkFleet refuses to delete ledgers that a server of --fleet-endpoint still has, so that this keyspace
+// does not keep less history than the fleet expects it to serve. Deletes of the latest ledgers, which are
+// written again, are not checked
+func checkFleet(window ledgerWindow) {
+	if len(*fleetEndpoints) == 0 {
+		return
+	}
+	if window.open {
+		log.Println("Not checking the fleet: the latest ledgers are deleted to be written again")
+		return
+	}
+
+	for _, endpoint := range *fleetEndpoints {
+		earliest, err := earliestCompleteLedger(endpoint)
+		if err != nil {
+			fatalf(exitConnection, "Could not check fleet server %s: %s\n", endpoint, err)
+		}
+
+		log.Printf("Fleet server %s has complete ledgers from %d\n", endpoint, earliest)
+		if window.to >= earliest {
+			fatalf(exitInvalid, "Refusing to delete ledgers %d -> %d: fleet server %s still serves them from %d on\n", max(window.from, earliest), window.to, endpoint, earliest)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// runGuard holds the row of --lock-table while a run deletes, so that no other run prunes the keyspace at
+// the same time and writers checking the row hold off. The row expires after --lock-ttl unless the guard
+// refreshes it, so a crashed run does not leave it behind for long. The guard also polls ledger_range and
+// stops the run once its latest ledger moves, as a writer is then ingesting into the keyspace
+type runGuard struct {
+	session *gocql.Session
+	owner   gocql.UUID
+	latest  uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startGuard(cluster *gocql.ClusterConfig, window ledgerWindow, latest uint64) (*runGuard, error) {
+	if *lockTable == "" && *writerCheckInterval == 0 {
+		return nil, nil
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &runGuard{session: session, owner: gocql.TimeUUID(), latest: latest, stop: make(chan struct{}), done: make(chan struct{})}
+	if *lockTable != "" {
+		if err := g.lock(window); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+
+	go g.run()
+	return g, nil
+}
+
+func (g *runGuard) lock(window ledgerWindow) error {
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name text PRIMARY KEY, owner timeuuid, host text, ledgers text, heartbeat timestamp)", *lockTable)
+	if err := g.session.Query(create).Exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", create)
+		return err
+	}
+
+	host, _ := os.Hostname()
+	existing := make(map[string]interface{})
+	insert := fmt.Sprintf("INSERT INTO %s (name, owner, host, ledgers, heartbeat) VALUES ('prune', ?, ?, ?, ?) IF NOT EXISTS USING TTL ?", *lockTable)
+	applied, err := g.session.Query(insert, g.owner, host, window.String(), time.Now(), int(lockTTL.Seconds())).MapScanCAS(existing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", insert)
+		return err
+	}
+	if !applied {
+		return fmt.Errorf("another run holds the %s table: host %v deleting %v, last heartbeat %v; it expires %s after that heartbeat",
+			*lockTable, existing["host"], existing["ledgers"], existing["heartbeat"], *lockTTL)
+	}
+
+	log.Printf("Holding the lock of the %s table as %s\n", *lockTable, g.owner)
+	return nil
+}
+
+func (g *runGuard) run() {
+	defer close(g.done)
+
+	interval := *writerCheckInterval
+	if *lockTable != "" && (interval == 0 || interval > *lockTTL/3) {
+		interval = *lockTTL / 3
+	}
+	lastCheck := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if *lockTable != "" {
+			g.heartbeat()
+		}
+
+		if *writerCheckInterval > 0 && time.Since(lastCheck) >= *writerCheckInterval {
+			lastCheck = time.Now()
+			g.checkWriters()
+		}
+	}
+}
+
+// heartbeat refreshes the lock; a run that lost it stops, as another one may have taken it since
+func (g *runGuard) heartbeat() {
+	update := fmt.Sprintf("UPDATE %s USING TTL ? SET heartbeat = ? WHERE name = 'prune' IF owner = ?", *lockTable)
+	applied, err := g.session.Query(update, int(lockTTL.Seconds()), time.Now(), g.owner).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		log.Printf("ERROR failed refreshing the lock: %s\n", err)
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [owner=%s]\n", update, g.owner)
+		return
+	}
+	if !applied {
+		stopRun(fmt.Sprintf("the lock of the %s table expired", *lockTable))
+	}
+}
+
+func (g *runGuard) checkWriters() {
+	var latest uint64
+	if err := g.session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&latest); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: select sequence from ledger_range [is_latest=true]: %s\n", err)
+		return
+	}
+	if latest > g.latest {
+		stopRun(fmt.Sprintf("ledger_range moved from %d to %d, a Clio writer is ingesting", g.latest, latest))
+	}
+}
+
+// release stops the guard and gives the lock back
+func (g *runGuard) release() {
+	if g == nil {
+		return
+	}
+
+	close(g.stop)
+	<-g.done
+	defer g.session.Close()
+
+	if *lockTable == "" {
+		return
+	}
+
+	del := fmt.Sprintf("DELETE FROM %s WHERE name = 'prune' IF owner = ?", *lockTable)
+	if _, err := g.session.Query(del, g.owner).MapScanCAS(make(map[string]interface{})); err != nil {
+		log.Printf("ERROR failed releasing the lock, it expires after %s: %s\n", *lockTTL, err)
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [owner=%s]\n", del, g.owner)
+	}
+}
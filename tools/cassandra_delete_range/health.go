@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// isAvailabilityError tells whether a query failed because replicas were down or did not answer in time
+func isAvailabilityError(err error) bool {
+	var unavailable *gocql.RequestErrUnavailable
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	var readTimeout *gocql.RequestErrReadTimeout
+
+	return errors.As(err, &unavailable) ||
+		errors.As(err, &writeTimeout) ||
+		errors.As(err, &readTimeout) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse) ||
+		errors.Is(err, gocql.ErrNoConnections) ||
+		errors.Is(err, gocql.ErrConnectionClosed) ||
+		errors.Is(err, gocql.ErrUnavailable)
+}
+
+// healthGate pauses the deletes once too many of the recent ones failed for lack of available replicas,
+// and resumes them when the cluster has recovered
+type healthGate struct {
+	cluster   *gocql.ClusterConfig
+	threshold int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	outcomes []bool // ring of the most recent outcomes, true for availability errors
+	next     int
+	failures int
+	paused   bool
+	pauses   int
+}
+
+func newHealthGate(cluster *gocql.ClusterConfig, rate float64, window int) *healthGate {
+	g := &healthGate{
+		cluster:   cluster,
+		threshold: int(math.Ceil(rate * float64(window))),
+		outcomes:  make([]bool, window),
+	}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *healthGate) record(failed bool) {
+	if g.outcomes[g.next] {
+		g.failures--
+	}
+	if failed {
+		g.failures++
+	}
+
+	g.outcomes[g.next] = failed
+	g.next = (g.next + 1) % len(g.outcomes)
+}
+
+func (g *healthGate) success() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.record(false)
+}
+
+// failure records a failed query; it returns true when the caller waited out a pause and should retry
+func (g *healthGate) failure(err error) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	availability := isAvailabilityError(err)
+	g.record(availability)
+	if !availability {
+		return false
+	}
+
+	if !g.paused && g.failures >= g.threshold {
+		g.paused = true
+		g.pauses++
+		log.Printf("PAUSING: %d of the last %d queries failed for lack of available replicas (%s)\n", g.failures, len(g.outcomes), err)
+		go g.waitForRecovery()
+	}
+
+	if !g.paused {
+		return false
+	}
+
+	for g.paused {
+		g.cond.Wait()
+	}
+	return true
+}
+
+// knownNodes returns the addresses of the contact points and of every peer they know about
+func knownNodes(cluster *gocql.ClusterConfig, session *gocql.Session) []string {
+	seen := make(map[string]bool)
+	var nodes []string
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			nodes = append(nodes, host)
+		}
+	}
+
+	for _, h := range cluster.Hosts {
+		add(h)
+	}
+
+	if session != nil {
+		var ip net.IP
+		iter := session.Query("SELECT rpc_address FROM system.peers").Iter()
+		for iter.Scan(&ip) {
+			add(ip.String())
+		}
+		iter.Close()
+	}
+
+	return nodes
+}
+
+func (g *healthGate) check() (int, int, error) {
+	cluster := *g.cluster
+	cluster.QueryObserver = nil
+	cluster.NumConns = 1
+
+	session, sessionErr := createSession(&cluster)
+	if sessionErr == nil {
+		defer session.Close()
+	}
+
+	nodes := knownNodes(g.cluster, session)
+	up := 0
+	for _, node := range nodes {
+		host := node
+		if _, _, err := net.SplitHostPort(node); err != nil {
+			host = net.JoinHostPort(node, strconv.Itoa(cluster.Port))
+		}
+		if conn, err := net.DialTimeout("tcp", host, 3*time.Second); err == nil {
+			conn.Close()
+			up++
+		}
+	}
+
+	if sessionErr != nil {
+		return up, len(nodes), sessionErr
+	}
+
+	// a read at the run's consistency level proves that enough replicas answer again
+	var seq uint64
+	err := session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&seq)
+	return up, len(nodes), err
+}
+
+func (g *healthGate) waitForRecovery() {
+	started := time.Now()
+	for {
+		time.Sleep(*healthInterval)
+		if interrupted() {
+			log.Println("Not waiting for the cluster to recover: the run was interrupted")
+			break
+		}
+
+		up, total, err := g.check()
+		if err == nil && up == total {
+			break
+		}
+
+		reason := "waiting for all nodes to come back"
+		if err != nil {
+			reason = fmt.Sprintf("probe failed: %s", err)
+		}
+		log.Printf("PAUSED for %s: %d/%d nodes up, %s\n", time.Since(started).Round(time.Second), up, total, reason)
+
+		if *maxPause > 0 && time.Since(started) > *maxPause {
+			fatalf(exitConnection, "Cluster did not recover within %s. Aborting...", *maxPause)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	log.Printf("RESUMING: cluster recovered after %s\n", time.Since(started).Round(time.Second))
+	for i := range g.outcomes {
+		g.outcomes[i] = false
+	}
+	g.failures = 0
+	g.paused = false
+	g.cond.Broadcast()
+}
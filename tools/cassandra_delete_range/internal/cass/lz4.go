@@ -0,0 +1,47 @@
+package cass
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// LZ4Compressor compresses frames the way Cassandra's LZ4 frame compression expects: the uncompressed
+// length as a big-endian uint32, followed by one lz4 block. It implements gocql.Compressor
+type LZ4Compressor struct{}
+
+func (LZ4Compressor) Name() string {
+	return "lz4"
+}
+
+func (LZ4Compressor) Encode(data []byte) ([]byte, error) {
+	// a destination of at least CompressBlockBound bytes always receives a compressed block
+	buf := make([]byte, 4+lz4.CompressBlockBound(len(data)))
+	var compressor lz4.Compressor
+	n, err := compressor.CompressBlock(data, buf[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	return buf[:4+n], nil
+}
+
+func (LZ4Compressor) Decode(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("lz4 frame of %d bytes is shorter than its length prefix", len(data))
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if length == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, length)
+	n, err := lz4.UncompressBlock(data[4:], buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
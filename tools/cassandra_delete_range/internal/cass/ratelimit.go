@@ -0,0 +1,50 @@
+// Package cass holds the helpers the scan and delete workers share when talking to the cluster.
+package cass
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by all workers; a nil limiter does not limit at all
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows perSecond operations per second, in bursts of at most a tenth of a second's
+// worth; it returns nil when perSecond is not positive
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	burst := math.Max(1, perSecond/10)
+	return &RateLimiter{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait blocks until the caller may run one operation
+func (l *RateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	// taking the token right away queues up concurrent callers behind each other
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,76 @@
+package cass
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Retryable tells whether a query failed for a transient reason, i.e. replicas that were down,
+// overloaded or did not answer in time
+func Retryable(err error) bool {
+	var unavailable *gocql.RequestErrUnavailable
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	var readTimeout *gocql.RequestErrReadTimeout
+	var requestErr gocql.RequestError
+
+	if errors.As(err, &requestErr) {
+		switch requestErr.Code() {
+		case gocql.ErrCodeOverloaded, gocql.ErrCodeBootstrapping:
+			return true
+		}
+	}
+
+	return errors.As(err, &unavailable) ||
+		errors.As(err, &writeTimeout) ||
+		errors.As(err, &readTimeout) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse) ||
+		errors.Is(err, gocql.ErrNoConnections) ||
+		errors.Is(err, gocql.ErrConnectionClosed) ||
+		errors.Is(err, gocql.ErrUnavailable)
+}
+
+// RetryPolicy retries transient failures with an exponential backoff; it is shared by all workers
+type RetryPolicy struct {
+	Attempts  int           // attempts per query including the first one; below 2 nothing is retried
+	BaseDelay time.Duration // delay before the first retry, doubled for every further one
+	MaxDelay  time.Duration
+	Jitter    float64 // fraction of every delay that is randomized, between 0 and 1
+
+	retries uint64
+}
+
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay << (retry - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	return d - time.Duration(p.Jitter*rand.Float64()*float64(d))
+}
+
+// Do runs op until it succeeds, fails for a reason not worth retrying or runs out of attempts,
+// and returns its last error
+func (p *RetryPolicy) Do(op func() error) error {
+	err := op()
+	if p == nil {
+		return err
+	}
+
+	for retry := 1; err != nil && retry < p.Attempts && Retryable(err); retry++ {
+		atomic.AddUint64(&p.retries, 1)
+		time.Sleep(p.delay(retry))
+		err = op()
+	}
+	return err
+}
+
+// Retries returns the number of retries so far
+func (p *RetryPolicy) Retries() uint64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&p.retries)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+var interruptReceived atomic.Bool
+
+// interrupted tells whether the run got SIGINT or SIGTERM and is winding down: scans stop before their next
+// page and deletes before their next query, the queries in flight finish
+func interrupted() bool {
+	return interruptReceived.Load()
+}
+
+// stopRun winds the run down like an interrupt, i.e. when another process started writing to the keyspace
+func stopRun(reason string) {
+	if interruptReceived.CompareAndSwap(false, true) {
+		log.Printf("ERROR %s; finishing the queries in flight and stopping\n", reason)
+	}
+}
+
+// handleSignals winds the run down on the first SIGINT or SIGTERM, so that the resume marker, the audit log
+// and the report describe exactly what was done; a second one exits at once
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		if interruptReceived.CompareAndSwap(false, true) {
+			log.Printf("WARNING: %s received, finishing the queries in flight; repeat it to exit at once\n", sig)
+		}
+
+		sig = <-signals
+		log.Printf("WARNING: %s received again, exiting without recording the progress\n", sig)
+		os.Exit(exitAborted)
+	}()
+}
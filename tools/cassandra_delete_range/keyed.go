@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// keyedRun keeps the books of the commands that delete rows by key rather than by ledger the way prune
+// does: it holds the guard while deleting, records the rows in --audit-file and writes --report-file and
+// the manifest. Their manifests name the command, they do not make any ledgers clean for --skip-cleaned
+type keyedRun struct {
+	cluster *gocql.ClusterConfig
+	guard   *runGuard
+	report  *runReport
+}
+
+// startKeyedRun is called once the deletes are confirmed; the keys are deleted at every ledger of the
+// keyspace, which is what the guard holds the lock for
+func startKeyedRun(cluster *gocql.ClusterConfig, command string) *keyedRun {
+	earliest, latest, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	guard, err := startGuard(cluster, ledgerWindow{from: earliest, to: latest}, latest)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *auditFile != "" {
+		if audit, err = openAuditLog(*auditFile); err != nil {
+			guard.release()
+			log.Fatal(err)
+		}
+	}
+
+	return &keyedRun{
+		cluster: cluster,
+		guard:   guard,
+		report: &runReport{
+			Command:    command,
+			Started:    time.Now().UTC(),
+			Hosts:      strings.Join(cluster.Hosts, ","),
+			Keyspace:   *keyspace,
+			FromLedger: earliest,
+			ToLedger:   latest,
+
+			LedgerRangeBefore: ledgerRange{First: earliest, Latest: latest},
+			LedgerRangeAfter:  ledgerRange{First: earliest, Latest: latest},
+		},
+	}
+}
+
+// deleteRows deletes every row of the keys of info from the table and adds the table to the report
+func (k *keyedRun) deleteRows(table string, info *deleteInfo) (uint64, uint64) {
+	t := k.report.startTable(table)
+	deletes, failed := performDeleteQueries(k.cluster, table, info, columnSettings{UseBlob: true})
+	t.finish(uint64(len(info.Data)), deletes, failed)
+
+	k.report.TotalRows += uint64(len(info.Data))
+	k.report.TotalDeletes += deletes
+	k.report.TotalErrors += failed
+	return deletes, failed
+}
+
+// finish releases the guard, closes the audit log and writes the report and, unless interrupted, the manifest
+func (k *keyedRun) finish() {
+	k.guard.release()
+
+	if err := audit.close(); err != nil {
+		log.Printf("ERROR failed writing audit log: %s\n", err)
+	}
+
+	k.report.Interrupted = interrupted()
+	if *reportFile != "" {
+		k.report.finish()
+		if err := k.report.write(*reportFile); err != nil {
+			log.Printf("ERROR failed writing report: %s\n", err)
+		} else {
+			log.Printf("Report written to %s\n", *reportFile)
+		}
+	}
+
+	if k.report.Interrupted {
+		return
+	}
+
+	manifest := newManifest(k.report, k.report.LedgerRangeBefore)
+	if err := writeManifest(k.cluster, manifest); err != nil {
+		log.Printf("ERROR failed writing manifest: %s\n", err)
+	}
+}
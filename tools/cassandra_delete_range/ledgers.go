@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+)
+
+const (
+	// ledgers of one chunk of a ledgerSet, as bits
+	ledgerChunkSize = 1 << 16
+
+	// gaps printed by find-gaps; --out has all of them
+	maxPrintedGaps = 100
+)
+
+// ledgerSet holds the sequences found in a table as a bitmap per chunk, so that tens of millions of ledgers
+// take a few megabytes
+type ledgerSet struct {
+	mu     sync.Mutex
+	chunks map[uint64]*[ledgerChunkSize / 64]uint64
+	count  uint64
+	min    uint64
+	max    uint64
+}
+
+func newLedgerSet() *ledgerSet {
+	return &ledgerSet{chunks: make(map[uint64]*[ledgerChunkSize / 64]uint64)}
+}
+
+func (s *ledgerSet) add(seqs []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seq := range seqs {
+		chunk, ok := s.chunks[seq/ledgerChunkSize]
+		if !ok {
+			chunk = new([ledgerChunkSize / 64]uint64)
+			s.chunks[seq/ledgerChunkSize] = chunk
+		}
+
+		bit := uint64(1) << (seq % 64)
+		word := &chunk[seq%ledgerChunkSize/64]
+		if *word&bit != 0 {
+			continue
+		}
+		*word |= bit
+
+		if s.count == 0 || seq < s.min {
+			s.min = seq
+		}
+		if s.count == 0 || seq > s.max {
+			s.max = seq
+		}
+		s.count++
+	}
+}
+
+func (s *ledgerSet) has(seq uint64) bool {
+	chunk, ok := s.chunks[seq/ledgerChunkSize]
+	return ok && chunk[seq%ledgerChunkSize/64]&(uint64(1)<<(seq%64)) != 0
+}
+
+// countBetween counts the ledgers of from -> to (inclusive)
+func (s *ledgerSet) countBetween(from uint64, to uint64) uint64 {
+	var n uint64
+	for seq := from; seq <= to; seq++ {
+		if seq%64 == 0 && seq+63 <= to {
+			if chunk, ok := s.chunks[seq/ledgerChunkSize]; ok {
+				n += uint64(bits.OnesCount64(chunk[seq%ledgerChunkSize/64]))
+			}
+			seq += 63
+			continue
+		}
+		if s.has(seq) {
+			n++
+		}
+	}
+	return n
+}
+
+// gaps lists the runs of ledgers of from -> to (inclusive) that are missing
+func (s *ledgerSet) gaps(from uint64, to uint64) []ledgerGap {
+	var gaps []ledgerGap
+	for seq := from; seq <= to; seq++ {
+		// skip full words of present ledgers
+		if seq%64 == 0 && seq+63 <= to {
+			if chunk, ok := s.chunks[seq/ledgerChunkSize]; ok && chunk[seq%ledgerChunkSize/64] == ^uint64(0) {
+				seq += 63
+				continue
+			}
+		}
+		if s.has(seq) {
+			continue
+		}
+
+		if n := len(gaps); n > 0 && gaps[n-1].To == seq-1 {
+			gaps[n-1].To = seq
+			gaps[n-1].Missing++
+		} else {
+			gaps = append(gaps, ledgerGap{From: seq, To: seq, Missing: 1})
+		}
+	}
+	return gaps
+}
+
+// contiguousDown finds the earliest ledger of the run of contiguous ledgers that ends at latest
+func (s *ledgerSet) contiguousDown(latest uint64) uint64 {
+	earliest := latest
+	for earliest > 0 && s.has(earliest-1) {
+		earliest--
+	}
+	return earliest
+}
+
+type ledgerGap struct {
+	From    uint64 `json:"from"`
+	To      uint64 `json:"to"`
+	Missing uint64 `json:"missing"`
+}
+
+type gapsReport struct {
+	Time     time.Time   `json:"time"`
+	Keyspace string      `json:"keyspace"`
+	Table    string      `json:"table"`
+	Earliest uint64      `json:"earliest"`
+	Latest   uint64      `json:"latest"`
+	Ledgers  uint64      `json:"ledgers"`
+	Missing  uint64      `json:"missing"`
+	Errors   uint64      `json:"errors"`
+	Gaps     []ledgerGap `json:"gaps"`
+}
+
+// scanLedgerSequences reads the sequence of every row of the ledgers or ledger_hashes table; the number of
+// token ranges that failed is returned, the set misses their ledgers
+func scanLedgerSequences(session *gocql.Session, table string, phase string) (*ledgerSet, uint64) {
+	query, keyed := queryTemplates[table].Partitions, false
+	if query == "" {
+		query, keyed = queryTemplates[table].Scan, true
+	}
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for _, r := range ranges {
+		rangesChannel <- r
+	}
+	close(rangesChannel)
+
+	tableProgress := startProgress(table, phase, uint64(len(ranges)), "token ranges", "ledgers")
+	defer tableProgress.stop()
+
+	set := newLedgerSet()
+	var errCount uint64
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			for r := range rangesChannel {
+				var seqs []uint64
+				var key []byte
+				var seq uint64
+				var err error
+				scanner := speculative(session.Query(query, r.StartRange, r.EndRange)).PageSize(*clusterPageSize).Iter().Scanner()
+				for err == nil && scanner.Next() {
+					if keyed {
+						err = scanner.Scan(&key, &seq)
+					} else {
+						err = scanner.Scan(&seq)
+					}
+					if err == nil {
+						seqs = append(seqs, seq)
+					}
+				}
+				if scanErr := scanner.Err(); scanErr != nil {
+					err = scanErr
+				}
+
+				if err != nil {
+					log.Printf("ERROR: %s query failed: %s\n", phase, err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d]", query, r.StartRange, r.EndRange))
+					mu.Lock()
+					errCount++
+					mu.Unlock()
+					tableProgress.addErrors(1)
+				}
+
+				set.add(seqs)
+				tableProgress.addItems(uint64(len(seqs)))
+				tableProgress.addSteps(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return set, errCount
+}
+
+// runRepairLedgerRange points ledger_range at the contiguous ledgers that end at the newest one of the
+// ledgers table, i.e. after a crashed run left it at deleted ledgers
+func runRepairLedgerRange(cluster *gocql.ClusterConfig) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	loadTables(cluster)
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	earliestInDB, latestInDB, rangeErr := getLedgerRange(cluster)
+	if rangeErr != nil {
+		log.Printf("WARNING: ledger_range could not be read: %s\n", rangeErr)
+	}
+
+	log.Println("Scanning ledgers table")
+	set, errCount := scanLedgerSequences(session, "ledgers", "repair")
+	if errCount > 0 {
+		fatalf(exitFailed, "%d token ranges of the ledgers table could not be scanned; not repairing ledger_range from a partial scan\n", errCount)
+	}
+	if set.count == 0 {
+		fatal(exitFailed, "The ledgers table is empty, there is no ledger range to repair")
+	}
+
+	latest := set.max
+	earliest := set.contiguousDown(latest)
+	log.Printf("Ledgers table has %d ledgers between %d and %d; the contiguous ledgers up to the newest are %d:%d\n", set.count, set.min, set.max, earliest, latest)
+
+	if earliest > set.min {
+		log.Printf("WARNING: %d older ledgers before the gap at %d are left out of ledger_range\n", set.countBetween(set.min, earliest-1), earliest-1)
+	}
+
+	if rangeErr == nil && earliestInDB == earliest && latestInDB == latest {
+		log.Println("ledger_range is correct already")
+		return
+	}
+
+	log.Printf("Will write ledger range %d:%d to ledger_range\n", earliest, latest)
+	if !confirm() {
+		fatal(exitAborted, "Aborting...")
+	}
+
+	if err := updateLedgerRange(cluster, earliest, false); err != nil {
+		log.Fatal(err)
+	}
+	if err := updateLedgerRange(cluster, latest, true); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("ledger_range repaired to %d:%d\n", earliest, latest)
+}
+
+// runFindGaps reports the ledgers of ledger_range that are missing from the ledgers or ledger_hashes table,
+// which Clio answers with lgrNotFound
+func runFindGaps(cluster *gocql.ClusterConfig) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	loadTables(cluster)
+	if !slices.Contains(tableNames, *gapsTable) {
+		fatalf(exitInvalid, "The %s table is not in keyspace %s\n", *gapsTable, *keyspace)
+	}
+
+	earliest, latest, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	log.Printf("Scanning %s table\n", *gapsTable)
+	set, errCount := scanLedgerSequences(session, *gapsTable, "find-gaps")
+
+	report := &gapsReport{
+		Time:     time.Now().UTC(),
+		Keyspace: *keyspace,
+		Table:    *gapsTable,
+		Earliest: earliest,
+		Latest:   latest,
+		Errors:   errCount,
+	}
+	if earliest <= latest {
+		report.Ledgers = set.countBetween(earliest, latest)
+		report.Gaps = set.gaps(earliest, latest)
+	}
+	for _, g := range report.Gaps {
+		report.Missing += g.Missing
+	}
+
+	printGapsReport(report)
+
+	if *gapsOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*gapsOut, data, 0644)
+		}
+		if err != nil {
+			log.Printf("ERROR failed writing gaps report: %s\n", err)
+		} else {
+			log.Printf("Gaps report written to %s\n", *gapsOut)
+		}
+	}
+
+	if errCount > 0 {
+		fatalf(exitFailed, "%d token ranges could not be scanned, the ledgers of their rows are reported missing\n", errCount)
+	}
+	if len(report.Gaps) > 0 {
+		os.Exit(exitVerifyFailed)
+	}
+}
+
+func printGapsReport(report *gapsReport) {
+	fmt.Printf("\nLedgers of keyspace %s in the %s table, ledger range %d:%d\n", report.Keyspace, report.Table, report.Earliest, report.Latest)
+	fmt.Println("=====================")
+
+	for i, g := range report.Gaps {
+		if i == maxPrintedGaps {
+			fmt.Printf("... and %d more gaps\n", len(report.Gaps)-maxPrintedGaps)
+			break
+		}
+		if g.From == g.To {
+			fmt.Printf("missing %d\n", g.From)
+		} else {
+			fmt.Printf("missing %d -> %d (%d ledgers)\n", g.From, g.To, g.Missing)
+		}
+	}
+
+	if len(report.Gaps) == 0 {
+		fmt.Printf("\nPASSED: all %d ledgers are in the %s table\n", report.Ledgers, report.Table)
+	} else {
+		fmt.Printf("\nFAILED: %d ledgers in %d gaps are missing from the %s table\n", report.Missing, len(report.Gaps), report.Table)
+	}
+}
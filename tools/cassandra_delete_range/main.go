@@ -5,11 +5,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	"math/rand"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,6 +19,9 @@ import (
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/cassandra_delete_range/internal/cass"
 )
 
 const (
@@ -26,9 +31,24 @@ const (
 )
 
 var (
-	clusterHosts      = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
-	earliestLedgerIdx = kingpin.Flag("ledgerIdx", "Sets the earliest ledger_index to keep untouched").Short('i').Required().Uint64()
+	deleteCmd         = kingpin.Command("delete", "Delete all data after a ledger index").Default()
+	clusterHosts      = deleteCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	earliestLedgerIdx = deleteCmd.Flag("ledgerIdx", "Sets the earliest ledger_index to keep untouched").Short('i').Required().Uint64()
+
+	deleteRangeCmd   = kingpin.Command("delete-range", "Delete the data of a window of ledgers only, i.e. a corrupted segment before re-ETLing it")
+	deleteRangeHosts = deleteRangeCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	windowFrom       = deleteRangeCmd.Arg("from", "First ledger index to delete").Required().Uint64()
+	windowTo         = deleteRangeCmd.Arg("to", "Last ledger index to delete").Required().Uint64()
+
+	keepLatestCmd   = kingpin.Command("keep-latest", "Delete all data but the given number of most recent ledgers, keeping the state of the earliest one kept")
+	keepLatestHosts = keepLatestCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	keepLatestCount = keepLatestCmd.Arg("ledgers", "Number of most recent ledgers to keep").Required().Uint64()
+
+	deleteBeforeTimeCmd   = kingpin.Command("delete-before-time", "Delete all data of the ledgers that closed before a time, keeping the state of the first one after it")
+	deleteBeforeTimeHosts = deleteBeforeTimeCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	deleteBeforeTime      = deleteBeforeTimeCmd.Arg("time", "RFC3339 time, i.e. 2024-01-31T00:00:00Z").Required().String()
 
+	assumeYes             = kingpin.Flag("yes", "Do not ask for confirmation; required when stdin is not a terminal, i.e. in cron jobs").Short('y').Default("false").Bool()
 	nodesInCluster        = kingpin.Flag("nodes-in-cluster", "Number of nodes in your Scylla cluster").Short('n').Default(fmt.Sprintf("%d", defaultNumberOfNodesInCluster)).Int()
 	coresInNode           = kingpin.Flag("cores-in-node", "Number of cores in each node").Short('c').Default(fmt.Sprintf("%d", defaultNumberOfCoresInNode)).Int()
 	smudgeFactor          = kingpin.Flag("smudge-factor", "Yet another factor to make parallelism cooler").Short('s').Default(fmt.Sprintf("%d", defaultSmudgeFactor)).Int()
@@ -36,29 +56,181 @@ var (
 	clusterTimeout        = kingpin.Flag("timeout", "Maximum duration for query execution in millisecond").Short('t').Default("15000").Int()
 	clusterNumConnections = kingpin.Flag("cluster-number-of-connections", "Number of connections per host per session (in our case, per thread)").Short('b').Default("1").Int()
 	clusterCQLVersion     = kingpin.Flag("cql-version", "The CQL version to use").Short('l').Default("3.0.0").String()
+	protocolVersion       = kingpin.Flag("protocol-version", "Native protocol version to use, 3 or 4, i.e. 4 for Cassandra 3.11 clusters that pin it; 0 negotiates it. Lower versions are tried when the nodes refuse it").Default("0").Int()
 	clusterPageSize       = kingpin.Flag("cluster-page-size", "Page size of results").Short('p').Default("5000").Int()
 	keyspace              = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
 
-	userName = kingpin.Flag("username", "Username to use when connecting to the cluster").String()
-	password = kingpin.Flag("password", "Password to use when connecting to the cluster").String()
+	useTLS        = kingpin.Flag("tls", "Connect to the cluster with TLS; implied by the other --tls-* flags").Default("false").Bool()
+	tlsCA         = kingpin.Flag("tls-ca", "CA certificate to verify the nodes with instead of the system ones").ExistingFile()
+	tlsCert       = kingpin.Flag("tls-cert", "Client certificate, for clusters requiring client authentication").ExistingFile()
+	tlsKey        = kingpin.Flag("tls-key", "Private key of the client certificate").ExistingFile()
+	tlsSkipVerify = kingpin.Flag("tls-skip-verify", "Do not verify the certificates and host names of the nodes").Default("false").Bool()
+
+	compression = kingpin.Flag("compression", "Compress the frames between the tool and the nodes: none, snappy or lz4").Default("none").Enum("none", "snappy", "lz4")
+
+	speculativeAttempts = kingpin.Flag("speculative-attempts", "Send a scan query to another replica too when the first one did not answer within --speculative-delay, up to this many more times; 0 disables it").Default("0").Int()
+	speculativeDelay    = kingpin.Flag("speculative-delay", "Time a scan query waits for its replica before --speculative-attempts sends it to another one").Default("200ms").Duration()
+
+	tokenAware = kingpin.Flag("token-aware", "Send every delete to a replica of its row instead of any coordinator").Default("false").Bool()
+	localDC    = kingpin.Flag("local-dc", "Only use the coordinators of this datacenter, i.e. with 'localone' or 'localquorum' in multi DC deployments").String()
+
+	userName        = kingpin.Flag("username", "Username to use when connecting to the cluster").Envar("CASSANDRA_USERNAME").String()
+	password        = kingpin.Flag("password", "Password to use when connecting to the cluster; prefer CASSANDRA_PASSWORD or --credentials-file, which do not show in process listings").Envar("CASSANDRA_PASSWORD").String()
+	credentialsFile = kingpin.Flag("credentials-file", "YAML or JSON file with the username and password to use when connecting to the cluster").String()
 
 	skipSuccessorTable          = kingpin.Flag("skip-successor", "Whether to skip deletion from successor table").Default("false").Bool()
 	skipObjectsTable            = kingpin.Flag("skip-objects", "Whether to skip deletion from objects table").Default("false").Bool()
+	skipAccountTxTable          = kingpin.Flag("skip-account-tx", "Whether to skip deletion from account_tx table").Default("false").Bool()
+	skipNFTokenTxTable          = kingpin.Flag("skip-nf-token-transactions", "Whether to skip deletion from nf_token_transactions table").Default("false").Bool()
 	skipLedgerHashesTable       = kingpin.Flag("skip-ledger-hashes", "Whether to skip deletion from ledger_hashes table").Default("false").Bool()
 	skipTransactionsTable       = kingpin.Flag("skip-transactions", "Whether to skip deletion from transactions table").Default("false").Bool()
+	skipNFTokensTable           = kingpin.Flag("skip-nf-tokens", "Whether to skip deletion from nf_tokens table").Default("false").Bool()
+	skipNFTokenURIsTable        = kingpin.Flag("skip-nf-token-uris", "Whether to skip deletion from nf_token_uris table").Default("false").Bool()
 	skipDiffTable               = kingpin.Flag("skip-diff", "Whether to skip deletion from diff table").Default("false").Bool()
 	skipLedgerTransactionsTable = kingpin.Flag("skip-ledger-transactions", "Whether to skip deletion from ledger_transactions table").Default("false").Bool()
 	skipLedgersTable            = kingpin.Flag("skip-ledgers", "Whether to skip deletion from ledgers table").Default("false").Bool()
-	skipWriteLatestLedger       = kingpin.Flag("skip-write-latest-ledger", "Whether to skip writing the latest ledger index").Default("false").Bool()
-
-	workerCount = 1           // the calculated number of parallel goroutines the client should run
-	ranges      []*tokenRange // the calculated ranges to be executed in parallel
+	skipWriteLatestLedger       = kingpin.Flag("skip-write-latest-ledger", "Whether to skip moving the latest or earliest ledger index in ledger_range when deleting up to it").Default("false").Bool()
+	pauseErrorRate              = kingpin.Flag("pause-error-rate", "Pause deleting when this fraction of recent deletes failed with unavailable or timeout errors; 0 disables pausing").Default("0.5").Float64()
+	pauseWindow                 = kingpin.Flag("pause-window", "Number of recent deletes the error rate is computed over").Default("200").Int()
+	healthInterval              = kingpin.Flag("health-interval", "Time between cluster health checks while paused").Default("10s").Duration()
+	maxPause                    = kingpin.Flag("max-pause", "Abort when the cluster has not recovered after pausing this long; 0 waits forever").Default("30m").Duration()
+	objectTypes                 = kingpin.Flag("object-types", "Only delete objects table rows of these ledger entry types, comma separated (i.e. Offer,DirectoryNode); 'deleted' selects the rows marking deleted objects").String()
+	tablesFile                  = kingpin.Flag("tables-file", "YAML or JSON file defining more tables to prune, i.e. ones newer Clio versions added; the MPT, DID and oracle tables are not in the schema of this Clio version and can only be pruned once defined here").ExistingFile()
+	skipTables                  = kingpin.Flag("skip-table", "Skip deletion from this table; can be repeated").Strings()
+	onlyTables                  = kingpin.Flag("only", "Only delete from these tables, comma separated, i.e. --only=objects,successor; every other table is skipped").Strings()
+	queryOverrides              = kingpin.Flag("query-overrides", "YAML or JSON file replacing the scan and delete queries of some tables, i.e. for forked schemas").ExistingFile()
+	manifestDir                 = kingpin.Flag("manifest-dir", "Directory to write the manifest of what a run deleted to").Default(".").String()
+	manifestTable               = kingpin.Flag("manifest-table", "Table of the keyspace manifests are also stored in and read from; empty disables it").Default("prune_manifests").String()
+	skipCleaned                 = kingpin.Flag("skip-cleaned", "Skip ledgers that earlier runs recorded as deleted in their manifests, as long as ledger_range did not move since").Default("false").Bool()
+	previousManifests           = kingpin.Flag("previous-manifest", "Manifest file of an earlier run to use with --skip-cleaned, in addition to the manifest table; can be repeated").ExistingFiles()
+	telemetryFile               = kingpin.Flag("telemetry-file", "Opt in to appending anonymized run characteristics (table sizes, throughput, error classes, cluster type) to this file, to share with the maintainers if you like").String()
+	reportFile                  = kingpin.Flag("report-file", "Write a JSON report of the run, including the new ledger_range, the flags used and the cluster load it caused, to this file").String()
+	maxDeleteRate               = kingpin.Flag("max-delete-rate", "Maximum number of deletes per second, shared by all workers; 0 does not limit").Default("0").Float64()
+	maintenanceWindowSpec       = kingpin.Flag("window", "Only delete between these local times of day, i.e. 22:00-06:00; outside of them the deletes pause until the window opens again, scans go on").String()
+	maxScanRate                 = kingpin.Flag("max-scan-rate", "Maximum number of rows scanned per second, shared by all workers; 0 does not limit").Default("0").Float64()
+	allowedErrors               = kingpin.Flag("allowed-errors", "Number of failed queries a run may have and still exit with 0; with more it exits with 4").Default("0").Uint64()
+	fleetEndpoints              = kingpin.Flag("fleet-endpoint", "JSON-RPC URL of a rippled or Clio server of the fleet; the run refuses to delete ledgers that its server_info still has in complete_ledgers. Can be repeated").Strings()
+	mirrorHosts                 = kingpin.Flag("mirror-hosts", "Nodes of a standby cluster, comma separated, to repeat every delete and the ledger_range update on, so that it keeps the same data without being scanned").String()
+	mirrorKeyspace              = kingpin.Flag("mirror-keyspace", "Keyspace of --mirror-hosts; defaults to --keyspace").String()
+	tokenStarts                 = kingpin.Flag("token-start", "First token of a part of the ring to limit the run to, i.e. to retry failed ranges; repeat it with --token-end for several parts, and use --token-start=-123 for negative tokens. Tables deleted by ledger and ledger_range are left to a run over the whole ring").Int64List()
+	tokenEnds                   = kingpin.Flag("token-end", "Last token (inclusive) of the part of the ring of the --token-start given at the same position").Int64List()
+	maxErrors                   = kingpin.Flag("max-errors", "Stop the run, recording its progress in --resume-file, and exit with 4 once more queries failed than this number or percentage of the queries made, i.e. 500 or 1%").String()
+	parallelTables              = kingpin.Flag("parallel-tables", "Number of tables pruned at once; they share the computed number of parallel threads and the rate limits").Default("1").Int()
+	planFilePath                = kingpin.Flag("plan-file", "Write every table, key and sequence a run is about to delete to this CSV file, gzip compressed when it ends with .gz, or to a Parquet file when it ends with .parquet; see the plan and apply commands to review a plan before deleting").String()
+	skipPrunedRanges            = kingpin.Flag("skip-pruned-ranges", "Before scanning a token range of a table, probe it for rows in the window and skip it when there are none, i.e. when rerunning a prune that was interrupted without --resume-file").Default("false").Bool()
+	auditFile                   = kingpin.Flag("audit-file", "Append every deleted table, key and sequence to this gzip compressed file, to review the run or replay it against a backup").String()
+	runID                       = kingpin.Flag("run-id", "ID of the run in --runs-dir; an earlier run with it is continued. Defaults to the start time").String()
+	runsDir                     = kingpin.Flag("runs-dir", "Directory recording every run, with its flags, progress and outcome, for `runs list` and `runs resume`; empty disables it").Default("cassandra_delete_range_runs").String()
+	resumeFile                  = kingpin.Flag("resume-file", "Record the progress of the run in this file, down to the page of every token range being scanned, and continue from it after an interruption; removed once the run completes").String()
+	metricsPort                 = kingpin.Flag("metrics-port", "Serve Prometheus metrics of the run on this port; 0 disables them").Default("0").Int()
+	showDashboard               = kingpin.Flag("dashboard", "Show the progress, rates and errors of every table on a terminal dashboard instead of logging them").Default("false").Bool()
+	dashboardLog                = kingpin.Flag("dashboard-log", "File the logs go to while the dashboard is shown").Default("cassandra_delete_range.log").String()
+	progressInterval            = kingpin.Flag("progress-interval", "Time between two progress lines while scanning or deleting a table; 0 disables them").Default("30s").Duration()
+	retryAttempts               = kingpin.Flag("retry-attempts", "Attempts of a scan or delete query that failed for a transient reason, i.e. a timeout or an overloaded node, including the first one").Default("3").Int()
+	retryBaseDelay              = kingpin.Flag("retry-base-delay", "Delay before the first retry, doubled for every further one").Default("100ms").Duration()
+	retryMaxDelay               = kingpin.Flag("retry-max-delay", "Maximum delay between two retries").Default("5s").Duration()
+	retryJitter                 = kingpin.Flag("retry-jitter", "Fraction of every retry delay that is randomized, between 0 and 1").Default("0.2").Float64()
+	collapseSuccessor           = kingpin.Flag("collapse-successor", "When deleting the oldest ledgers, also delete the successor rows of keys that are not in the linked list of the earliest ledger kept anymore; keeps the newest row of every key scanned in memory").Default("false").Bool()
+	keepLastObjectVersion       = kingpin.Flag("keep-last-object-version", "When deleting the oldest ledgers, keep the newest version at or before the end of the window of every key of the objects, successor, nf_tokens and nf_token_uris tables, so that the earliest ledger kept has its full state; --no-keep-last-object-version deletes every version in the window, i.e. for full wipes").Default("true").Bool()
+	rangeDeletes                = kingpin.Flag("range-deletes", "When deleting till latest, delete all versions of a successor or objects key with a single range tombstone instead of one tombstone per row").Default("true").Bool()
+	batchSize                   = kingpin.Flag("batch-size", "Delete up to this many rows of the same partition, i.e. versions of an object, in one unlogged batch; 1 sends every delete on its own").Default("1").Int()
+	tombstoneWarnThreshold      = kingpin.Flag("tombstone-warn-threshold", "tombstone_warn_threshold of the cluster; warn about partitions the deletes put more tombstones in").Default("1000").Uint64()
+	tombstoneFailThreshold      = kingpin.Flag("tombstone-failure-threshold", "tombstone_failure_threshold of the cluster; refuse deleting from a table when that puts more tombstones in one of its partitions").Default("100000").Uint64()
+	force                       = kingpin.Flag("force", "Delete even when reads of some partitions will fail for their tombstones").Default("false").Bool()
+	lockTable                   = kingpin.Flag("lock-table", "Table of the keyspace holding the lock a run takes so that no other run or writer checking it touches the keyspace meanwhile; empty disables it").Default("prune_lock").String()
+	lockTTL                     = kingpin.Flag("lock-ttl", "Time the lock of a crashed run is held before it expires").Default("2m").Duration()
+	writerCheckInterval         = kingpin.Flag("writer-check-interval", "Time between two checks that ledger_range did not move, i.e. because a Clio writer is running, which stops the run; 0 disables them").Default("30s").Duration()
+	online                      = kingpin.Flag("online", "Prune while Clio keeps serving reads and writing: one thread per node, scans at LOCAL_ONE, deletes and scans rate limited unless --max-delete-rate or --max-scan-rate are given, and the latest --online-keep-latest ledgers out of reach").Default("false").Bool()
+	onlineKeepLatest            = kingpin.Flag("online-keep-latest", "Number of most recent ledgers --online refuses to delete").Default("10000").Uint64()
+	compaction                  = kingpin.Flag("compaction", "Run a major compaction of the pruned tables on every node once done, through the REST API of Scylla or the nodetool of Cassandra, to free up the disk space").Default("none").Enum("none", "scylla", "nodetool")
+	scyllaAPIPort               = kingpin.Flag("scylla-api-port", "Port of the Scylla REST API for --compaction=scylla").Default("10000").Int()
+	nodetoolPath                = kingpin.Flag("nodetool", "nodetool executable for --compaction=nodetool").Default("nodetool").String()
+	jmxPort                     = kingpin.Flag("jmx-port", "JMX port of the Cassandra nodes for --compaction=nodetool").Default("7199").Int()
+	adaptive                    = kingpin.Flag("adaptive", "Scale the number of workers querying at once and the page size with the latency and error rate of the cluster, up to the computed ones").Default("false").Bool()
+	adaptiveInterval            = kingpin.Flag("adaptive-interval", "Time between two adjustments of --adaptive").Default("5s").Duration()
+	adaptiveTargetLatency       = kingpin.Flag("adaptive-target-latency", "Average query latency above which --adaptive backs off; 0 uses three times the lowest one seen").Default("0").Duration()
+	adaptiveMaxErrorRate        = kingpin.Flag("adaptive-max-error-rate", "Fraction of timeouts and unavailable errors above which --adaptive backs off").Default("0.01").Float64()
+	backpressureEnabled         = kingpin.Flag("backpressure", "Poll the Scylla REST API of every node and halve the queries running at once while a node has too many pending compactions, hints in progress or dropped mutations; they go up again once it caught up").Default("false").Bool()
+	backpressureInterval        = kingpin.Flag("backpressure-interval", "Time between two polls of --backpressure").Default("30s").Duration()
+	maxPendingCompactions       = kingpin.Flag("max-pending-compactions", "Pending compactions of a node above which --backpressure backs off").Default("100").Int64()
+	maxHintsInProgress          = kingpin.Flag("max-hints-in-progress", "Hints in progress of a node above which --backpressure backs off").Default("1000").Int64()
+	maxDroppedMutations         = kingpin.Flag("max-dropped-mutations", "Mutations a node dropped between two polls above which --backpressure backs off").Default("0").Int64()
+
+	estimateCmd        = kingpin.Command("estimate", "Only scan the tables to report the rows a prune would delete and how long it would take")
+	estimateHosts      = estimateCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	estimateAfter      = estimateCmd.Flag("after", "Estimate deleting all data after this ledger index, like delete --ledgerIdx").Uint64()
+	estimateFrom       = estimateCmd.Flag("from", "First ledger index of a window to estimate deleting, like delete-range").Uint64()
+	estimateTo         = estimateCmd.Flag("to", "Last ledger index of a window to estimate deleting, like delete-range").Uint64()
+	estimateKeepLatest = estimateCmd.Flag("keep-latest", "Estimate deleting all data but this many most recent ledgers, like keep-latest").Uint64()
+	estimateSample     = estimateCmd.Flag("sample", "Fraction of the token ranges to scan, the counts are extrapolated from it").Default("1").Float64()
+
+	planCmd        = kingpin.Command("plan", "Only scan the tables and write every row a prune would delete to a plan file, to review it and delete later with apply")
+	planHosts      = planCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	planAfter      = planCmd.Flag("after", "Plan deleting all data after this ledger index, like delete --ledgerIdx").Uint64()
+	planFrom       = planCmd.Flag("from", "First ledger index of a window to plan deleting, like delete-range").Uint64()
+	planTo         = planCmd.Flag("to", "Last ledger index of a window to plan deleting, like delete-range").Uint64()
+	planKeepLatest = planCmd.Flag("keep-latest", "Plan deleting all data but this many most recent ledgers, like keep-latest").Uint64()
+	planOut        = planCmd.Flag("out", "Plan file to write, gzip compressed when it ends with .gz, Parquet when it ends with .parquet").Required().String()
+
+	applyCmd   = kingpin.Command("apply", "Delete the rows of a plan file written by plan, as long as ledger_range did not move since")
+	applyHosts = applyCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	applyPlan  = applyCmd.Arg("plan-file", "Plan file written by plan").Required().String()
+
+	statsCmd        = kingpin.Command("stats", "Report the partitions, size and rows of every table, per bucket of ledgers with --scan, to choose what to prune")
+	statsHosts      = statsCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	statsScan       = statsCmd.Flag("scan", "Scan the tables to count their rows per bucket of ledgers; otherwise only the size estimates of the nodes are reported").Default("false").Bool()
+	statsBucketSize = statsCmd.Flag("bucket-size", "Number of ledgers per bucket of --scan").Default("1000000").Uint64()
+	statsSample     = statsCmd.Flag("sample", "Fraction of the token ranges --scan reads, the counts are extrapolated from it").Default("1").Float64()
+
+	verifyCmd   = kingpin.Command("verify", "Scan the keyspace after pruning and report the rows outside of the ledger range of ledger_range; exits with 5 when there are any")
+	verifyHosts = verifyCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	verifyOut   = verifyCmd.Flag("out", "Also write the report as JSON to this file").String()
+
+	watchCmd      = kingpin.Command("watch", "Watch ledger_range and the ledgers table to confirm that writers are ingesting")
+	watchHosts    = watchCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	watchInterval = watchCmd.Flag("interval", "Time between two polls").Default("10s").Duration()
+	watchCount    = watchCmd.Flag("count", "Stop after this many polls; 0 polls until interrupted").Default("0").Int()
+	watchOut      = watchCmd.Flag("out", "Append every sample as a JSON line to this file").String()
+	watchMaxAge   = watchCmd.Flag("max-tip-age", "Tip age above which ingestion is reported as stalled").Default("1m").Duration()
+
+	repairCmd   = kingpin.Command("repair-ledger-range", "Scan the ledgers table and point ledger_range at the contiguous ledgers that end at the newest one")
+	repairHosts = repairCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+
+	gapsCmd   = kingpin.Command("find-gaps", "Scan the ledgers or ledger_hashes table and report the ledgers of ledger_range it misses; exits with 5 when there are any")
+	gapsHosts = gapsCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	gapsTable = gapsCmd.Flag("table", "Table to look for the ledgers in: ledgers or ledger_hashes").Default("ledgers").Enum("ledgers", "ledger_hashes")
+	gapsOut   = gapsCmd.Flag("out", "Also write every gap as JSON to this file").String()
+
+	preflightCmd   = kingpin.Command("preflight", "Check that the nodes are reachable, the credentials may delete, the tables have the Clio layout and paging works, before a real run")
+	preflightHosts = preflightCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+
+	cleanSuccessorCmd   = kingpin.Command("clean-successor", "Delete the successor rows of keys that have no row in the objects table anymore, i.e. left behind by deleting the oldest ledgers")
+	cleanSuccessorHosts = cleanSuccessorCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+
+	nftIssuerCmd   = kingpin.Command("delete-nft-issuer", "Delete every NFT of an issuer from nf_tokens, nf_token_uris, nf_token_transactions and issuer_nf_tokens_v2, i.e. of spam issuers of private networks")
+	nftIssuerHosts = nftIssuerCmd.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	nftIssuer      = nftIssuerCmd.Arg("issuer", "Address or hex AccountID of the issuer").Required().String()
+
+	runsCmd       = kingpin.Command("runs", "Show and continue the runs of --runs-dir")
+	runsListCmd   = runsCmd.Command("list", "List the runs with their status")
+	runsResumeCmd = runsCmd.Command("resume", "Continue an interrupted, failed or crashed run with the flags it was started with")
+	resumeRunID   = runsResumeCmd.Arg("id", "ID of the run").Required().String()
+
+	fallbackProtocol atomic.Int32 // the native protocol version sessions fell back to; 0 until they did
+
+	workerCount = 1                     // the calculated number of parallel goroutines the client should run
+	ranges      []*cassandra.TokenRange // the calculated ranges to be executed in parallel
+	gate        *healthGate             // pauses deletes while the cluster is unavailable; nil when disabled
+	cleaned     *cleanRegions           // ledgers earlier runs deleted already; nil unless --skip-cleaned
+	resume      *resumeState            // progress to continue an interrupted run from; nil unless --resume-file
+	audit       *auditLog               // record of the deleted rows; nil unless --audit-file
+	deleteRate  *cass.RateLimiter       // throttles deletes; nil unless --max-delete-rate
+	scanRate    *cass.RateLimiter       // throttles scanned rows; nil unless --max-scan-rate
+	retry       *cass.RetryPolicy       // retries transient query failures
 )
 
-type tokenRange struct {
-	StartRange int64
-	EndRange   int64
-}
+// the lowest native protocol version createSession falls back to
+const minProtocolVersion = 3
 
 type deleteParams struct {
 	Seq  uint64
@@ -75,39 +247,7 @@ type deleteInfo struct {
 	Data  []deleteParams
 }
 
-func getTokenRanges() []*tokenRange {
-	var n = workerCount
-	var m = int64(n * 100)
-	var maxSize uint64 = math.MaxInt64 * 2
-	var rangeSize = maxSize / uint64(m)
-
-	var start int64 = math.MinInt64
-	var end int64
-	var shouldBreak = false
-
-	var ranges = make([]*tokenRange, m)
-
-	for i := int64(0); i < m; i++ {
-		end = start + int64(rangeSize)
-		if start > 0 && end < 0 {
-			end = math.MaxInt64
-			shouldBreak = true
-		}
-
-		ranges[i] = &tokenRange{StartRange: start, EndRange: end}
-
-		if shouldBreak {
-			break
-		}
-
-		start = end + 1
-	}
-
-	return ranges
-}
-
-func splitDeleteWork(info *deleteInfo) [][]deleteParams {
-	var n = workerCount
+func splitDeleteWork(info *deleteInfo, n int) [][]deleteParams {
 	var chunkSize = len(info.Data) / n
 	var chunks [][]deleteParams
 
@@ -133,169 +273,820 @@ func splitDeleteWork(info *deleteInfo) [][]deleteParams {
 	return chunks
 }
 
-func shuffle(data []*tokenRange) {
-	for i := 1; i < len(data); i++ {
-		r := rand.Intn(i + 1)
-		if i != r {
-			data[r], data[i] = data[i], data[r]
-		}
-	}
-}
-
-func getConsistencyLevel(consistencyValue string) gocql.Consistency {
-	switch consistencyValue {
-	case "any":
-		return gocql.Any
-	case "one":
-		return gocql.One
-	case "two":
-		return gocql.Two
-	case "three":
-		return gocql.Three
-	case "quorum":
-		return gocql.Quorum
-	case "all":
-		return gocql.All
-	case "localquorum":
-		return gocql.LocalQuorum
-	case "eachquorum":
-		return gocql.EachQuorum
-	case "localone":
-		return gocql.LocalOne
-	default:
-		return gocql.One
+// partitionBatch returns how many of the leading rows go in one unlogged batch: rows of the same partition,
+// which is the blob of the deletes binding both columns, up to --batch-size
+func partitionBatch(rows []deleteParams, bindCount int) int {
+	if *batchSize <= 1 || bindCount != 2 {
+		return 1
 	}
+
+	n := 1
+	for n < len(rows) && n < *batchSize && bytes.Equal(rows[n].Blob, rows[0].Blob) {
+		n++
+	}
+	return n
 }
 
-func main() {
-	log.SetOutput(os.Stdout)
-	kingpin.Parse()
+// rangeDeleteQueries replaces the deletes of the rows of a key by one delete of all its versions from the
+// first ledger of the window on
+func rangeDeleteQueries(info deleteInfo, fromLedgerIdx uint64, rangeDeleteTemplate string) deleteInfo {
+	sortDeleteParams(info.Data)
 
-	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
-	ranges = getTokenRanges()
-	shuffle(ranges)
+	ranged := deleteInfo{Query: rangeDeleteTemplate}
+	for i, p := range info.Data {
+		if i > 0 && bytes.Equal(p.Blob, info.Data[i-1].Blob) {
+			continue
+		}
+		ranged.Data = append(ranged.Data, deleteParams{Seq: fromLedgerIdx, Blob: p.Blob})
+	}
+
+	log.Printf("Range deletes replacing %d row deletes: %d\n", len(info.Data), len(ranged.Data))
+	return ranged
+}
+
+// sortDeleteParams orders rows by blob and then sequence
+func sortDeleteParams(data []deleteParams) {
+	sort.Slice(data, func(i, j int) bool {
+		if c := bytes.Compare(data[i].Blob, data[j].Blob); c != 0 {
+			return c < 0
+		}
+		return data[i].Seq < data[j].Seq
+	})
+}
+
+// newHostPolicy returns the host selection policy of the flags, or nil for gocql's default round robin
+func newHostPolicy() gocql.HostSelectionPolicy {
+	var policy gocql.HostSelectionPolicy
+	if *localDC != "" {
+		policy = gocql.DCAwareRoundRobinPolicy(*localDC)
+	}
+
+	if *tokenAware {
+		if policy == nil {
+			policy = gocql.RoundRobinHostPolicy()
+		}
+		policy = gocql.TokenAwareHostPolicy(policy)
+	}
+
+	return policy
+}
+
+// createSession connects with a host selection policy of its own, as gocql does not allow sessions to share one.
+// When the nodes refuse the protocol version, the lower ones are tried; the first one that works is used
+// by the sessions created after it
+func createSession(cluster *gocql.ClusterConfig) (*gocql.Session, error) {
+	c := *cluster
+	if v := int(fallbackProtocol.Load()); v != 0 && (c.ProtoVersion == 0 || c.ProtoVersion > v) {
+		c.ProtoVersion = v
+	}
+
+	for {
+		c.PoolConfig.HostSelectionPolicy = newHostPolicy()
+		session, err := c.CreateSession()
+		if err == nil || !isProtocolError(err) || c.ProtoVersion == minProtocolVersion {
+			return session, err
+		}
+
+		if c.ProtoVersion == 0 {
+			// gocql only discovers down from v4, nodes pinned to a version may not answer that
+			c.ProtoVersion = 4
+		} else {
+			c.ProtoVersion--
+		}
+		if fallbackProtocol.Swap(int32(c.ProtoVersion)) != int32(c.ProtoVersion) {
+			log.Printf("WARNING: %s; falling back to native protocol v%d\n", err, c.ProtoVersion)
+		}
+	}
+}
 
-	hosts := strings.Split(*clusterHosts, ",")
+// isProtocolError tells whether the nodes refused the protocol version, not whether they were unreachable
+func isProtocolError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "protocol version") && !strings.Contains(msg, "dial tcp")
+}
 
-	cluster := gocql.NewCluster(hosts...)
-	cluster.Consistency = getConsistencyLevel(*clusterConsistency)
+func newClusterConfig(hosts string) *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Consistency = cassandra.GetConsistencyLevel(*clusterConsistency)
 	cluster.Timeout = time.Duration(*clusterTimeout * 1000 * 1000)
 	cluster.NumConns = *clusterNumConnections
 	cluster.CQLVersion = *clusterCQLVersion
+	if *protocolVersion != 0 && (*protocolVersion < minProtocolVersion || *protocolVersion > 4) {
+		fatal(exitInvalid, "--protocol-version must be 0, 3 or 4")
+	}
+	cluster.ProtoVersion = *protocolVersion
 	cluster.PageSize = *clusterPageSize
 	cluster.Keyspace = *keyspace
 
-	if *userName != "" {
+	switch *compression {
+	case "snappy":
+		cluster.Compressor = &gocql.SnappyCompressor{}
+	case "lz4":
+		cluster.Compressor = cass.LZ4Compressor{}
+	}
+
+	if username, password := loadCredentials(); username != "" {
 		cluster.Authenticator = gocql.PasswordAuthenticator{
-			Username: *userName,
-			Password: *password,
+			Username: username,
+			Password: password,
 		}
 	}
 
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal(exitInvalid, "--tls-cert and --tls-key must be given together")
+	}
+
+	if *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsSkipVerify {
+		cluster.SslOpts = &gocql.SslOptions{
+			CaPath:                 *tlsCA,
+			CertPath:               *tlsCert,
+			KeyPath:                *tlsKey,
+			EnableHostVerification: !*tlsSkipVerify,
+		}
+	}
+
+	return cluster
+}
+
+// speculative keeps slow replicas from stalling the scans: they are idempotent, so a scan query can be sent
+// to another replica while the first one has not answered yet
+func speculative(q *gocql.Query) *gocql.Query {
+	if *speculativeAttempts <= 0 {
+		return q
+	}
+	return q.Idempotent(true).SetSpeculativeExecutionPolicy(&gocql.SimpleSpeculativeExecution{NumAttempts: *speculativeAttempts, TimeoutDelay: *speculativeDelay})
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	// kingpin exits with 1 on invalid arguments, they are invalid options like any other
+	kingpin.CommandLine.Terminate(func(code int) {
+		if code != 0 {
+			code = exitInvalid
+		}
+		os.Exit(code)
+	})
+
+	command := kingpin.Parse()
+
+	var err error
+	if maintenance, err = parseMaintenanceWindow(*maintenanceWindowSpec); err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	switch command {
+	case deleteCmd.FullCommand():
+		runDelete()
+	case deleteRangeCmd.FullCommand():
+		runDeleteRange()
+	case keepLatestCmd.FullCommand():
+		runKeepLatest()
+	case deleteBeforeTimeCmd.FullCommand():
+		runDeleteBeforeTime()
+	case estimateCmd.FullCommand():
+		runEstimate()
+	case planCmd.FullCommand():
+		runPlan()
+	case applyCmd.FullCommand():
+		runApply(newClusterConfig(*applyHosts))
+	case statsCmd.FullCommand():
+		runStats(newClusterConfig(*statsHosts))
+	case verifyCmd.FullCommand():
+		runVerify(newClusterConfig(*verifyHosts))
+	case watchCmd.FullCommand():
+		runWatch(newClusterConfig(*watchHosts))
+	case repairCmd.FullCommand():
+		runRepairLedgerRange(newClusterConfig(*repairHosts))
+	case gapsCmd.FullCommand():
+		runFindGaps(newClusterConfig(*gapsHosts))
+	case preflightCmd.FullCommand():
+		runPreflight(newClusterConfig(*preflightHosts))
+	case cleanSuccessorCmd.FullCommand():
+		runCleanSuccessor(newClusterConfig(*cleanSuccessorHosts))
+	case nftIssuerCmd.FullCommand():
+		runDeleteNFTIssuer(newClusterConfig(*nftIssuerHosts))
+	case runsListCmd.FullCommand():
+		runListRuns()
+	case runsResumeCmd.FullCommand():
+		runResumeRun()
+	}
+}
+
+// ledgerWindow is the ledgers a run deletes; open windows reach till the latest ledger of ledger_range
+// and head windows start at its earliest ledger
+type ledgerWindow struct {
+	from       uint64
+	to         uint64
+	open       bool
+	head       bool
+	keepLatest uint64    // when set, the window is every ledger but this many most recent ones
+	before     time.Time // when set, the window is every ledger that closed before this time
+}
+
+func (w ledgerWindow) String() string {
+	if w.open {
+		return fmt.Sprintf("%d -> latest (%d)", w.from, w.to)
+	}
+	return fmt.Sprintf("%d -> %d", w.from, w.to)
+}
+
+// resolveBeforeTime turns a window of the ledgers closed before a time into one of sequences
+func resolveBeforeTime(cluster *gocql.ClusterConfig, window ledgerWindow, earliestLedgerIdxInDB uint64, latestLedgerIdxInDB uint64) ledgerWindow {
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	seq, closeTime, found, err := lastLedgerBefore(session, earliestLedgerIdxInDB, latestLedgerIdxInDB, window.before)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !found {
+		fatalf(exitInvalid, "No ledger in DB closed before %s, nothing to delete. Aborting...", window.before.Format(time.RFC3339))
+	}
+
+	log.Printf("Ledger %d is the last one closed before %s, at %s\n", seq, window.before.Format(time.RFC3339), closeTime.Format(time.RFC3339))
+	window.from = earliestLedgerIdxInDB
+	window.to = seq
+	return window
+}
+
+// resolveWindow checks the window against the ledger range in DB and fills in the bounds relative to it
+func resolveWindow(window ledgerWindow, earliestLedgerIdxInDB uint64, latestLedgerIdxInDB uint64) ledgerWindow {
+	if window.keepLatest > 0 {
+		if latestLedgerIdxInDB-earliestLedgerIdxInDB < window.keepLatest {
+			fatalf(exitInvalid, "There are only %d ledgers in DB, nothing to delete. Aborting...", latestLedgerIdxInDB-earliestLedgerIdxInDB+1)
+		}
+
+		window.from = earliestLedgerIdxInDB
+		window.to = latestLedgerIdxInDB - window.keepLatest
+	}
+
+	if window.open {
+		if earliestLedgerIdxInDB > window.from-1 {
+			fatal(exitInvalid, "Earliest ledger index in DB is greater than the one specified. Aborting...")
+		}
+
+		if latestLedgerIdxInDB < window.from-1 {
+			fatal(exitInvalid, "Latest ledger index in DB is smaller than the one specified. Aborting...")
+		}
+
+		window.to = latestLedgerIdxInDB
+		return window
+	}
+
+	if latestLedgerIdxInDB < window.from {
+		fatal(exitInvalid, "Latest ledger index in DB is smaller than the window. Aborting...")
+	}
+
+	switch {
+	case earliestLedgerIdxInDB >= window.from && latestLedgerIdxInDB <= window.to:
+		fatal(exitInvalid, "Window covers every ledger in DB. Aborting...")
+	case earliestLedgerIdxInDB > window.to:
+		// i.e. an --online run raised the earliest ledger and was interrupted before deleting all of the window
+		log.Printf("Window ends before the earliest ledger %d; deleting the rows left in it\n", earliestLedgerIdxInDB)
+		window.head = true
+	case earliestLedgerIdxInDB >= window.from:
+		if *keepLastObjectVersion {
+			log.Printf("Window starts at the earliest ledger %d; the state of ledger %d is kept and becomes the earliest\n", earliestLedgerIdxInDB, window.to+1)
+		} else {
+			log.Printf("WARNING: window starts at the earliest ledger %d and every object version in it is deleted; ledger %d becomes the earliest without the state of the objects not modified since\n", earliestLedgerIdxInDB, window.to+1)
+		}
+		window.from = earliestLedgerIdxInDB
+		window.head = true
+	case latestLedgerIdxInDB <= window.to:
+		log.Printf("Window reaches the latest ledger %d; deleting everything from %d on\n", latestLedgerIdxInDB, window.from)
+		window.open = true
+		window.to = latestLedgerIdxInDB
+	default:
+		log.Println("WARNING: ledger_range is left untouched, so Clio will consider the window's ledgers available until they are re-ETLed")
+	}
+
+	return window
+}
+
+func runDelete() {
 	if *earliestLedgerIdx == 0 {
-		log.Println("Please specify ledger index to delete from")
-		return
+		fatal(exitInvalid, "Please specify ledger index to delete from")
+	}
+
+	prune(*clusterHosts, ledgerWindow{from: *earliestLedgerIdx + 1, open: true})
+}
+
+func runDeleteRange() {
+	if *windowFrom == 0 || *windowTo < *windowFrom {
+		fatal(exitInvalid, "Please specify a window of ledgers with 0 < from <= to")
+	}
+
+	prune(*deleteRangeHosts, ledgerWindow{from: *windowFrom, to: *windowTo})
+}
+
+func runKeepLatest() {
+	if *keepLatestCount == 0 {
+		fatal(exitInvalid, "Please specify a positive number of ledgers to keep")
+	}
+
+	prune(*keepLatestHosts, ledgerWindow{keepLatest: *keepLatestCount})
+}
+
+func runDeleteBeforeTime() {
+	before, err := time.Parse(time.RFC3339, *deleteBeforeTime)
+	if err != nil {
+		fatalf(exitInvalid, "Please specify the time as RFC3339: %s", err)
+	}
+
+	prune(*deleteBeforeTimeHosts, ledgerWindow{before: before})
+}
+
+func prune(hosts string, window ledgerWindow) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	applyOnline()
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+	if estimating != nil {
+		ranges = estimating.sampleRanges(ranges)
+	}
+
+	cluster := newClusterConfig(hosts)
+	cluster.QueryObserver = usage
+	cluster.BatchObserver = usage
+
+	if *pauseErrorRate > 0 {
+		if *pauseWindow < 1 {
+			fatal(exitInvalid, "--pause-window must be positive")
+		}
+		gate = newHealthGate(cluster, *pauseErrorRate, *pauseWindow)
+	}
+
+	if *retryJitter < 0 || *retryJitter > 1 {
+		fatal(exitInvalid, "--retry-jitter must be between 0 and 1")
+	}
+	if *parallelTables < 1 {
+		fatal(exitInvalid, "--parallel-tables must be positive")
+	}
+
+	if *parallelTables > 1 && *resumeFile != "" {
+		fatal(exitInvalid, "--resume-file tracks one table at a time and cannot be used with --parallel-tables")
+	}
+
+	var err error
+	if budget, err = parseErrorBudget(*maxErrors); err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	if ranges, err = restrictTokenRanges(ranges); err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	if *collapseSuccessor && *resumeFile != "" {
+		fatal(exitInvalid, "--collapse-successor needs the whole successor table scanned in one run and cannot be used with --resume-file")
+	}
+
+	if *collapseSuccessor && !*keepLastObjectVersion {
+		fatal(exitInvalid, "--collapse-successor walks the linked list of the earliest ledger kept, which --no-keep-last-object-version deletes")
+	}
+
+	if *batchSize < 1 {
+		fatal(exitInvalid, "--batch-size must be positive")
+	}
+
+	if (*lockTable != "" && *lockTTL < 3*time.Second) || *writerCheckInterval < 0 {
+		fatal(exitInvalid, "--lock-ttl must be at least 3s and --writer-check-interval not negative")
+	}
+
+	if *speculativeAttempts > 0 && *speculativeDelay <= 0 {
+		fatal(exitInvalid, "--speculative-delay must be positive")
+	}
+
+	if *adaptive && (*adaptiveInterval <= 0 || *adaptiveMaxErrorRate < 0 || *adaptiveMaxErrorRate > 1) {
+		fatal(exitInvalid, "--adaptive-interval must be positive and --adaptive-max-error-rate between 0 and 1")
+	}
+
+	retry = &cass.RetryPolicy{Attempts: *retryAttempts, BaseDelay: *retryBaseDelay, MaxDelay: *retryMaxDelay, Jitter: *retryJitter}
+
+	if *metricsPort > 0 {
+		serveMetrics(*metricsPort)
+	}
+
+	deleteRate = cass.NewRateLimiter(*maxDeleteRate)
+	scanRate = cass.NewRateLimiter(*maxScanRate)
+
+	if *objectTypes != "" {
+		if err := parseObjectTypes(*objectTypes); err != nil {
+			fatal(exitInvalid, err)
+		}
+	}
+
+	overridden := loadTables(cluster)
+	checkOnlyTables()
+
+	earliestLedgerIdxInDB, latestLedgerIdxInDB, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !window.before.IsZero() {
+		window = resolveBeforeTime(cluster, window, earliestLedgerIdxInDB, latestLedgerIdxInDB)
+	}
+
+	window = resolveWindow(window, earliestLedgerIdxInDB, latestLedgerIdxInDB)
+	checkOnlineWindow(window, latestLedgerIdxInDB)
+	checkFleet(window)
+
+	if estimating == nil {
+		openMirror(earliestLedgerIdxInDB, latestLedgerIdxInDB)
 	}
 
 	runParameters := fmt.Sprintf(`
 Execution Parameters:
 =====================
 
-Range to be deleted           : %d -> latest
+Range to be deleted           : %s
 Scylla cluster nodes          : %s
 Keyspace                      : %s
+Mirror                        : %s
 Consistency                   : %s
 Timeout (ms)                  : %d
 Connections per host          : %d
 CQL Version                   : %s
+Protocol version              : %s
 Page size                     : %d
 # of parallel threads         : %d
+Tables pruned at once         : %d
+Allowed errors                : %d
+Max errors                    : %s
+Token ranges                  : %s
+Skip pruned ranges            : %t
+Online                        : %t
+Compaction                    : %s
+Lock table                    : %s
+Writer check interval         : %s
+Tombstone thresholds          : %d warn, %d fail (force %t)
 # of ranges to be executed    : %d
+Per table overrides           : %s
+Adaptive concurrency          : %t
+Backpressure                  : %s
+Delete batch size             : %d
+Keep last object version      : %t
+Range deletes                 : %t
+Collapse successor list       : %t
+Audit file                    : %s
+
+Only tables                   : %s
 
 Skip deletion of:
 - successor table             : %t
 - objects table               : %t
+- nf_tokens table             : %t
+- nf_token_uris table         : %t
+- account_tx table            : %t
+- nf_token_transactions table : %t
 - ledger_hashes table         : %t
 - transactions table          : %t
 - diff table                  : %t
 - ledger_transactions table   : %t
 - ledgers table               : %t
+- other tables                : %s
 
 Will rite latest ledger       : %t
+Pause at delete error rate    : %.2f
+Overridden queries            : %s
+Object types to delete        : %s
+Skip cleaned ledgers          : %t
+Max deletes per second        : %s
+Maintenance window            : %s
+Max rows scanned per second   : %s
+Query attempts                : %d (backoff %s -> %s)
+Token aware                   : %t
+Speculative scan attempts     : %d (after %s)
+Local datacenter              : %s
+TLS                           : %t
+Compression                   : %s
 
 `,
-		*earliestLedgerIdx,
-		*clusterHosts,
+		window,
+		hosts,
 		*keyspace,
+		mirrorDescription(),
 		*clusterConsistency,
 		cluster.Timeout/1000/1000,
 		*clusterNumConnections,
 		*clusterCQLVersion,
+		protocolDescription(),
 		*clusterPageSize,
 		workerCount,
+		*parallelTables,
+		*allowedErrors,
+		limitDescription(*maxErrors),
+		tokenSubsetDescription(),
+		*skipPrunedRanges,
+		*online,
+		*compaction,
+		fileDescription(*lockTable),
+		*writerCheckInterval,
+		*tombstoneWarnThreshold,
+		*tombstoneFailThreshold,
+		*force,
 		len(ranges),
+		tableSettingsDescription(),
+		*adaptive,
+		backpressureDescription(),
+		*batchSize,
+		*keepLastObjectVersion,
+		*rangeDeletes,
+		*collapseSuccessor,
+		fileDescription(*auditFile),
+		onlyTablesDescription(),
 		*skipSuccessorTable,
 		*skipObjectsTable,
+		*skipNFTokensTable,
+		*skipNFTokenURIsTable,
+		*skipAccountTxTable,
+		*skipNFTokenTxTable,
 		*skipLedgerHashesTable,
 		*skipTransactionsTable,
 		*skipDiffTable,
 		*skipLedgerTransactionsTable,
 		*skipLedgersTable,
-		!*skipWriteLatestLedger)
+		skippedTablesDescription(),
+		!*skipWriteLatestLedger,
+		*pauseErrorRate,
+		strings.Join(overridden, ", "),
+		objectTypesDescription(),
+		*skipCleaned,
+		rateDescription(*maxDeleteRate),
+		maintenanceDescription(),
+		rateDescription(*maxScanRate),
+		*retryAttempts,
+		*retryBaseDelay,
+		*retryMaxDelay,
+		*tokenAware,
+		*speculativeAttempts,
+		*speculativeDelay,
+		localDCDescription(),
+		cluster.SslOpts != nil,
+		*compression)
 
 	fmt.Println(runParameters)
 
-	log.Printf("Will delete everything after ledger index %d (exclusive) and till latest\n", *earliestLedgerIdx)
-	log.Println("WARNING: Please make sure that there are no Clio writers operating on the DB while this script is running")
-	log.Println("Are you sure you want to continue? (y/n)")
+	if estimating == nil {
+		if window.open {
+			log.Printf("Will delete everything after ledger index %d (exclusive) and till latest\n", window.from-1)
+		} else {
+			log.Printf("Will delete ledgers %d -> %d (inclusive)\n", window.from, window.to)
+		}
+		if !*online {
+			log.Println("WARNING: Please make sure that there are no Clio writers operating on the DB while this script is running")
+		}
 
-	var continueFlag string
-	if fmt.Scanln(&continueFlag); continueFlag != "y" {
-		log.Println("Aborting...")
-		return
+		if !confirm() {
+			fatal(exitAborted, "Aborting...")
+		}
 	}
 
+	handleSignals()
+	startBackpressure(cluster)
+
 	startTime := time.Now().UTC()
 
-	earliestLedgerIdxInDB, latestLedgerIdxInDB, err := getLedgerRange(cluster)
-	if err != nil {
+	if *skipCleaned {
+		manifests, err := loadManifests(cluster)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cleaned = newCleanRegions(manifests, latestLedgerIdxInDB)
+	}
+
+	report := &runReport{
+		Started:    startTime,
+		Hosts:      hosts,
+		Keyspace:   *keyspace,
+		FromLedger: window.from,
+		ToLedger:   window.to,
+		ToLatest:   window.open,
+
+		LedgerRangeBefore: ledgerRange{First: earliestLedgerIdxInDB, Latest: latestLedgerIdxInDB},
+		LedgerRangeAfter:  ledgerRange{First: earliestLedgerIdxInDB, Latest: latestLedgerIdxInDB},
+	}
+
+	startRun(window)
+
+	if *resumeFile != "" && estimating == nil {
+		if resume, err = loadResume(*resumeFile, window); err != nil {
+			fatal(exitInvalid, err)
+		}
+	}
+
+	if *auditFile != "" && estimating == nil {
+		if audit, err = openAuditLog(*auditFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *planFilePath != "" {
+		header := planHeader{
+			Keyspace:          *keyspace,
+			From:              window.from,
+			To:                window.to,
+			Open:              window.open,
+			Head:              window.head,
+			LedgerRange:       report.LedgerRangeBefore,
+			Sample:            1,
+			UpdateLedgerRange: !*skipWriteLatestLedger && !tokenSubset(),
+			Created:           startTime,
+		}
+		if estimating != nil {
+			header.Sample = estimating.sample
+		}
+		if plan, err = openPlanFile(*planFilePath, header); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var guard *runGuard
+	if estimating == nil {
+		if guard, err = startGuard(cluster, window, latestLedgerIdxInDB); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *showDashboard {
+		startDashboard(fmt.Sprintf("Pruning %s of keyspace %s", window, *keyspace))
+	}
+
+	if err := raiseOnlineEarliestLedger(cluster, window, report); err != nil {
+		guard.release()
 		log.Fatal(err)
 	}
 
-	if earliestLedgerIdxInDB > *earliestLedgerIdx {
-		log.Fatal("Earliest ledger index in DB is greater than the one specified. Aborting...")
+	err = deleteLedgerData(cluster, window, report)
+	guard.release()
+	dashboard.stop()
+
+	if err := audit.close(); err != nil {
+		log.Printf("ERROR failed writing audit log: %s\n", err)
+	}
+	if err := plan.close(); err != nil {
+		log.Printf("ERROR failed writing plan file: %s\n", err)
+	} else if plan != nil {
+		log.Printf("Plan written to %s\n", *planFilePath)
+	}
+
+	if estimating != nil {
+		if err != nil {
+			log.Fatal(err)
+		}
+		estimating.print(report)
+		return
+	}
+
+	report.Interrupted = errors.Is(err, errInterrupted)
+	if *reportFile != "" || *telemetryFile != "" {
+		report.finish()
+	}
+
+	if *telemetryFile != "" {
+		if err := appendTelemetry(cluster, report); err != nil {
+			log.Printf("ERROR failed writing telemetry: %s\n", err)
+		} else {
+			log.Printf("Anonymized run characteristics appended to %s\n", *telemetryFile)
+		}
 	}
 
-	if latestLedgerIdxInDB < *earliestLedgerIdx {
-		log.Fatal("Latest ledger index in DB is smaller than the one specified. Aborting...")
+	if *reportFile != "" {
+		if err := report.write(*reportFile); err != nil {
+			log.Printf("ERROR failed writing report: %s\n", err)
+		} else {
+			log.Printf("Report written to %s\n", *reportFile)
+		}
 	}
 
-	if err := deleteLedgerData(cluster, *earliestLedgerIdx+1, latestLedgerIdxInDB); err != nil {
+	if errors.Is(err, errInterrupted) {
+		resume.flush()
+		if resume != nil {
+			log.Printf("Progress recorded in %s; run again with the same options to continue\n", *resumeFile)
+		}
+		log.Printf("Interrupted after %s, ledger_range was not updated\n", time.Since(startTime))
+		if currentRun != nil {
+			log.Printf("Continue with: runs resume %s\n", currentRun.ID)
+		}
+		finishRun(runInterrupted, report)
+		if budget.wasExceeded() {
+			os.Exit(exitPartial)
+		}
+		os.Exit(exitAborted)
+	}
+
+	if err != nil {
+		finishRun(runFailed, report)
+	}
+	if errors.Is(err, errTooManyTombstones) {
+		fatal(exitInvalid, err)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
+	resume.finish()
+
+	manifest := newManifest(report, report.LedgerRangeBefore)
+	if err := writeManifest(cluster, manifest); err != nil {
+		log.Printf("ERROR failed writing manifest: %s\n", err)
+	}
+
+	compacted := false
+	if *compaction != "none" {
+		if err := compactPrunedTables(cluster, report); err != nil {
+			log.Printf("ERROR %s\n", err)
+		} else {
+			compacted = true
+		}
+	}
+
 	fmt.Printf("Total Execution Time: %s\n\n", time.Since(startTime))
-	fmt.Println("NOTE: Cassandra/ScyllaDB only writes tombstones. You need to run compaction to free up disk space.")
+	if !compacted {
+		fmt.Println("NOTE: Cassandra/ScyllaDB only writes tombstones. You need to run compaction to free up disk space.")
+	}
+
+	if report.TotalErrors > *allowedErrors {
+		finishRun(runPartial, report)
+		fatalf(exitPartial, "WARNING: %d queries failed, more than the %d allowed; see the FAILED QUERY lines on stderr\n", report.TotalErrors, *allowedErrors)
+	}
+	finishRun(runCompleted, report)
 }
 
-func getLedgerRange(cluster *gocql.ClusterConfig) (uint64, uint64, error) {
-	var (
-		firstLedgerIdx  uint64
-		latestLedgerIdx uint64
-	)
+func protocolDescription() string {
+	if *protocolVersion == 0 {
+		return "negotiated"
+	}
+	return fmt.Sprintf("v%d", *protocolVersion)
+}
 
-	session, err := cluster.CreateSession()
-	if err != nil {
-		log.Fatal(err)
+func localDCDescription() string {
+	if *localDC == "" {
+		return "any"
 	}
+	return *localDC
+}
 
-	defer session.Close()
+func rateDescription(perSecond float64) string {
+	if perSecond <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g", perSecond)
+}
 
-	if err := session.Query("select sequence from ledger_range where is_latest = ?", false).Scan(&firstLedgerIdx); err != nil {
-		return 0, 0, err
+func limitDescription(limit string) string {
+	if limit == "" {
+		return "unlimited"
+	}
+	return limit
+}
+
+func fileDescription(path string) string {
+	if path == "" {
+		return "none"
 	}
+	return path
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	if err := session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&latestLedgerIdx); err != nil {
+// confirm asks the operator to go ahead, unless --yes was given; without a terminal to ask on it refuses
+func confirm() bool {
+	if *assumeYes {
+		log.Println("Continuing without confirmation (--yes)")
+		return true
+	}
+
+	if !stdinIsTerminal() {
+		log.Println("Not asking for confirmation because stdin is not a terminal; use --yes to run unattended")
+		return false
+	}
+
+	log.Println("Are you sure you want to continue? (y/n)")
+
+	var continueFlag string
+	fmt.Scanln(&continueFlag)
+	return continueFlag == "y"
+}
+
+func getLedgerRange(cluster *gocql.ClusterConfig) (uint64, uint64, error) {
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	firstLedgerIdx, latestLedgerIdx, err := cassandra.GetLedgerRange(session)
+	if err != nil {
 		return 0, 0, err
 	}
 
@@ -303,292 +1094,833 @@ func getLedgerRange(cluster *gocql.ClusterConfig) (uint64, uint64, error) {
 	return firstLedgerIdx, latestLedgerIdx, nil
 }
 
-func deleteLedgerData(cluster *gocql.ClusterConfig, fromLedgerIdx uint64, toLedgerIdx uint64) error {
+func deleteLedgerData(cluster *gocql.ClusterConfig, window ledgerWindow, report *runReport) error {
+	fromLedgerIdx, toLedgerIdx := window.from, window.to
+
+	scanToLedgerIdx := toLedgerIdx
+	simpleToLedgerIdx := toLedgerIdx
+	if window.open {
+		// also delete the rows Clio wrote past the latest ledger of ledger_range before it was stopped
+		scanToLedgerIdx = math.MaxUint64
+
+		// Note: we deliberately add 1 extra ledger to make sure we delete any data Clio might have written
+		// if it crashed or was stopped in the middle of writing just before it wrote ledger_range.
+		simpleToLedgerIdx = toLedgerIdx + 1
+	}
+
 	var totalErrors uint64
 	var totalRows uint64
 	var totalDeletes uint64
 
-	var info deleteInfo
-	var rowsCount uint64
-	var deleteCount uint64
-	var errCount uint64
-
-	log.Printf("Start scanning and removing data for %d -> latest (%d according to ledger_range table)\n\n", fromLedgerIdx, toLedgerIdx)
-
-	// successor queries
-	if !*skipSuccessorTable {
-		log.Println("Generating delete queries for successor table")
-		info, rowsCount, errCount = prepareDeleteQueries(cluster, fromLedgerIdx,
-			"SELECT key, seq FROM successor WHERE token(key) >= ? AND token(key) <= ?",
-			"DELETE FROM successor WHERE key = ? AND seq = ?")
-		log.Printf("Total delete queries: %d\n", len(info.Data))
-		log.Printf("Total traversed rows: %d\n\n", rowsCount)
-		totalErrors += errCount
-		totalRows += rowsCount
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: true, UseSeq: true})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// objects queries
-	if !*skipObjectsTable {
-		log.Println("Generating delete queries for objects table")
-		info, rowsCount, errCount = prepareDeleteQueries(cluster, fromLedgerIdx,
-			"SELECT key, sequence FROM objects WHERE token(key) >= ? AND token(key) <= ?",
-			"DELETE FROM objects WHERE key = ? AND sequence = ?")
-		log.Printf("Total delete queries: %d\n", len(info.Data))
-		log.Printf("Total traversed rows: %d\n\n", rowsCount)
-		totalErrors += errCount
-		totalRows += rowsCount
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: true, UseSeq: true})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// ledger_hashes queries
-	if !*skipLedgerHashesTable {
-		log.Println("Generating delete queries for ledger_hashes table")
-		info, rowsCount, errCount = prepareDeleteQueries(cluster, fromLedgerIdx,
-			"SELECT hash, sequence FROM ledger_hashes WHERE token(hash) >= ? AND token(hash) <= ?",
-			"DELETE FROM ledger_hashes WHERE hash = ?")
-		log.Printf("Total delete queries: %d\n", len(info.Data))
-		log.Printf("Total traversed rows: %d\n\n", rowsCount)
-		totalErrors += errCount
-		totalRows += rowsCount
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: true, UseSeq: false})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// transactions queries
-	if !*skipTransactionsTable {
-		log.Println("Generating delete queries for transactions table")
-		info, rowsCount, errCount = prepareDeleteQueries(cluster, fromLedgerIdx,
-			"SELECT hash, ledger_sequence FROM transactions WHERE token(hash) >= ? AND token(hash) <= ?",
-			"DELETE FROM transactions WHERE hash = ?")
-		log.Printf("Total delete queries: %d\n", len(info.Data))
-		log.Printf("Total traversed rows: %d\n\n", rowsCount)
-		totalErrors += errCount
-		totalRows += rowsCount
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: true, UseSeq: false})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// diff queries
-	if !*skipDiffTable {
-		log.Println("Generating delete queries for diff table")
-		info = prepareSimpleDeleteQueries(fromLedgerIdx, toLedgerIdx,
-			"DELETE FROM diff WHERE seq = ?")
-		log.Printf("Total delete queries: %d\n\n", len(info.Data))
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: true, UseSeq: true})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// ledger_transactions queries
-	if !*skipLedgerTransactionsTable {
-		log.Println("Generating delete queries for ledger_transactions table")
-		info = prepareSimpleDeleteQueries(fromLedgerIdx, toLedgerIdx,
-			"DELETE FROM ledger_transactions WHERE ledger_sequence = ?")
-		log.Printf("Total delete queries: %d\n\n", len(info.Data))
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: false, UseSeq: true})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// ledgers queries
-	if !*skipLedgersTable {
-		log.Println("Generating delete queries for ledgers table")
-		info = prepareSimpleDeleteQueries(fromLedgerIdx, toLedgerIdx,
-			"DELETE FROM ledgers WHERE sequence = ?")
-		log.Printf("Total delete queries: %d\n\n", len(info.Data))
-		deleteCount, errCount = performDeleteQueries(cluster, &info, columnSettings{UseBlob: false, UseSeq: true})
-		totalErrors += errCount
-		totalDeletes += deleteCount
-	}
-
-	// TODO: tbd what to do with account_tx as it got tuple for seq_idx
-	// TODO: also, whether we need to take care of nft tables and other stuff like that
-
-	if !*skipWriteLatestLedger {
-		if err := updateLedgerRange(cluster, fromLedgerIdx-1); err != nil {
+	if window.open {
+		log.Printf("Start scanning and removing data for %d -> latest (%d according to ledger_range table)\n\n", fromLedgerIdx, toLedgerIdx)
+	} else {
+		log.Printf("Start scanning and removing data for %d -> %d\n\n", fromLedgerIdx, toLedgerIdx)
+	}
+
+	var selected []string
+	for _, name := range tableNames {
+		def := queryTemplates[name]
+		tableToLedgerIdx := simpleToLedgerIdx
+		if def.Scan != "" {
+			tableToLedgerIdx = scanToLedgerIdx
+		}
+
+		if skipTable(name) || cleaned.skipTable(name, fromLedgerIdx, tableToLedgerIdx) || resume.tableDone(name) {
+			continue
+		}
+		if def.Scan == "" && tokenSubset() {
+			log.Printf("Skipping %s table: it is deleted by ledger, not by token; run over the whole ring to delete it\n\n", name)
+			continue
+		}
+		selected = append(selected, name)
+	}
+
+	// the tombstones of all tables are checked before the first delete; --force and estimates never refuse
+	var scans map[string]*tableScan
+	if !*force && estimating == nil {
+		var err error
+		if scans, err = scanAhead(cluster, selected, window); err != nil {
+			return err
+		}
+	}
+
+	// tables are independent of each other, --parallel-tables of them are pruned at once
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	running := make(chan struct{}, max(1, *parallelTables))
+
+	for _, name := range selected {
+		def := queryTemplates[name]
+
+		running <- struct{}{}
+		mu.Lock()
+		if firstErr == nil && interrupted() {
+			firstErr = errInterrupted
+		}
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		wg.Add(1)
+		go func(name string, def queryTemplate) {
+			defer wg.Done()
+			defer func() { <-running }()
+
+			rowsCount, deleteCount, errCount, err := pruneTable(cluster, name, def, window, simpleToLedgerIdx, scans[name], report)
+
+			mu.Lock()
+			defer mu.Unlock()
+			totalErrors += errCount
+			totalRows += rowsCount
+			totalDeletes += deleteCount
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(name, def)
+	}
+
+	wg.Wait()
+
+	report.TotalErrors = totalErrors
+	report.TotalRows = totalRows
+	report.TotalDeletes = totalDeletes
+
+	if errors.Is(firstErr, errInterrupted) {
+		logTotals(totalErrors, totalRows, totalDeletes)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if tokenSubset() && (window.open || window.head) && !*skipWriteLatestLedger && estimating == nil {
+		log.Println("Not updating ledger_range: only parts of the ring were pruned")
+	}
+
+	if !*skipWriteLatestLedger && estimating == nil && !tokenSubset() {
+		if err := moveLedgerRange(cluster, window, report); err != nil {
+			return err
+		}
+	}
+
+	logTotals(totalErrors, totalRows, totalDeletes)
+	log.Printf("Completed deletion for %d -> %d\n\n", fromLedgerIdx, toLedgerIdx)
+
+	return nil
+}
+
+// moveLedgerRange points ledger_range, and the one of the mirror, past the deleted ledgers of open and head
+// windows
+func moveLedgerRange(cluster *gocql.ClusterConfig, window ledgerWindow, report *runReport) error {
+	if window.open {
+		if err := updateLedgerRange(cluster, window.from-1, true); err != nil {
 			log.Printf("ERROR failed updating ledger range: %s\n", err)
 			return err
 		}
 
-		log.Printf("Updated latest ledger to %d in ledger_range table\n\n", fromLedgerIdx-1)
+		if err := updateMirrorLedgerRange(window.from-1, true); err != nil {
+			log.Printf("ERROR failed updating ledger range of the mirror: %s\n", err)
+			return err
+		}
+
+		log.Printf("Updated latest ledger to %d in ledger_range table\n\n", window.from-1)
+		report.LedgerRangeUpdated = true
+		report.LedgerRangeAfter.Latest = window.from - 1
+	}
+
+	// online prunes raised the earliest ledger before deleting
+	if window.head && !*online {
+		return moveEarliestLedger(cluster, window, report)
+	}
+
+	return nil
+}
+
+// moveEarliestLedger points the earliest ledger of ledger_range, and the one of the mirror, at the ledger
+// after a head window; it is never lowered
+func moveEarliestLedger(cluster *gocql.ClusterConfig, window ledgerWindow, report *runReport) error {
+	if window.to+1 <= report.LedgerRangeBefore.First {
+		return nil
+	}
+
+	if err := updateLedgerRange(cluster, window.to+1, false); err != nil {
+		log.Printf("ERROR failed updating ledger range: %s\n", err)
+		return err
+	}
+
+	if err := updateMirrorLedgerRange(window.to+1, false); err != nil {
+		log.Printf("ERROR failed updating ledger range of the mirror: %s\n", err)
+		return err
 	}
 
+	log.Printf("Updated earliest ledger to %d in ledger_range table\n\n", window.to+1)
+	report.LedgerRangeUpdated = true
+	report.LedgerRangeAfter.First = window.to + 1
+	return nil
+}
+
+func logTotals(totalErrors uint64, totalRows uint64, totalDeletes uint64) {
 	log.Printf("TOTAL ERRORS: %d\n", totalErrors)
 	log.Printf("TOTAL ROWS TRAVERSED: %d\n", totalRows)
-	log.Printf("TOTAL DELETES: %d\n\n", totalDeletes)
+	log.Printf("TOTAL DELETES: %d\n", totalDeletes)
+	log.Printf("TOTAL RETRIES: %d\n", retry.Retries())
+	log.Printf("TOTAL BYTES READ: %d\n\n", atomic.LoadUint64(&usage.readBytes))
+}
 
-	log.Printf("Completed deletion for %d -> %d\n\n", fromLedgerIdx, toLedgerIdx)
+// tableScan is what the scan of a table found: the rows to delete and the rows it traversed and failed on
+type tableScan struct {
+	info      deleteInfo
+	rowsCount uint64
+	errCount  uint64
+}
 
-	return nil
+// scanAhead scans the tables deleted by key before anything is deleted, and refuses the run when the deletes of
+// any of them write too many tombstones. The marker of --resume-file tracks the scan of one table at a time, so
+// with it these scans are not tracked and are thrown away, the tables are scanned again as they are pruned;
+// without it they are returned for pruneTable to use
+func scanAhead(cluster *gocql.ClusterConfig, names []string, window ledgerWindow) (map[string]*tableScan, error) {
+	tracked := resume
+	resume = nil
+	defer func() { resume = tracked }()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	scans := make(map[string]*tableScan)
+	running := make(chan struct{}, max(1, *parallelTables))
+
+	for _, name := range names {
+		def := queryTemplates[name]
+		if def.Scan == "" {
+			continue
+		}
+
+		running <- struct{}{}
+		if interrupted() {
+			break
+		}
+
+		wg.Add(1)
+		go func(name string, def queryTemplate) {
+			defer wg.Done()
+			defer func() { <-running }()
+
+			log.Printf("Scanning %s table before deleting anything\n", name)
+			info, rowsCount, errCount := scanTable(cluster, name, def, window)
+
+			mu.Lock()
+			defer mu.Unlock()
+			scans[name] = &tableScan{info: info, rowsCount: rowsCount, errCount: errCount}
+		}(name, def)
+	}
+
+	wg.Wait()
+	if interrupted() {
+		return nil, errInterrupted
+	}
+
+	var errs []error
+	for _, name := range names {
+		if scan, ok := scans[name]; ok {
+			if err := checkTombstones(name, &scan.info); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if tracked != nil {
+		return nil, nil
+	}
+	return scans, nil
+}
+
+// pruneTable deletes the rows of the window from one table and returns the rows it traversed, deleted and
+// failed on. Tables deleted by key are scanned first, unless scanAhead did already
+func pruneTable(cluster *gocql.ClusterConfig, name string, def queryTemplate, window ledgerWindow, simpleToLedgerIdx uint64, scan *tableScan, report *runReport) (uint64, uint64, uint64, error) {
+	table := report.startTable(name)
+	if err := resume.beginTable(name); err != nil {
+		return 0, 0, 0, err
+	}
+	log.Printf("Generating delete queries for %s table\n", name)
+
+	var info deleteInfo
+	var rowsCount uint64
+	var scanErrCount uint64
+	if scan != nil {
+		info, rowsCount, scanErrCount = scan.info, scan.rowsCount, scan.errCount
+		log.Printf("Total delete queries of %s: %d\n", name, len(info.Data))
+		log.Printf("Total traversed rows of %s: %d\n\n", name, rowsCount)
+	} else if def.Scan != "" {
+		info, rowsCount, scanErrCount = scanTable(cluster, name, def, window)
+		if interrupted() {
+			table.finish(rowsCount, 0, scanErrCount)
+			return rowsCount, 0, scanErrCount, errInterrupted
+		}
+		if err := checkTombstones(name, &info); err != nil {
+			table.finish(rowsCount, 0, scanErrCount)
+			return rowsCount, 0, scanErrCount, err
+		}
+		log.Printf("Total delete queries of %s: %d\n", name, len(info.Data))
+		log.Printf("Total traversed rows of %s: %d\n\n", name, rowsCount)
+	} else {
+		info = prepareSimpleDeleteQueries(window.from, simpleToLedgerIdx, def.Delete)
+		info = cleaned.filterSeqs(name, info)
+		log.Printf("Total delete queries of %s: %d\n\n", name, len(info.Data))
+	}
+	plan.write(name, &info)
+
+	// scanned tables delete by key, the others by ledger
+	deleteCount, errCount := performDeleteQueries(cluster, name, &info, columnSettings{UseBlob: def.Scan != "", UseSeq: def.Scan == ""})
+	table.finish(rowsCount, deleteCount, errCount+scanErrCount)
+	if interrupted() {
+		return rowsCount, deleteCount, errCount + scanErrCount, errInterrupted
+	}
+	resume.finishTable(name)
+
+	return rowsCount, deleteCount, errCount + scanErrCount, nil
+}
+
+// deleteExec returns the execution of the deletes of a group of rows: the bound prepared query for one row,
+// an unlogged batch for more
+func deleteExec(session *gocql.Session, preparedQuery *gocql.Query, q string, bc int, colSettings columnSettings, group []deleteParams) func() error {
+	if len(group) > 1 {
+		batch := session.NewBatch(gocql.UnloggedBatch)
+		for _, r := range group {
+			batch.Query(q, r.Blob, r.Seq)
+		}
+		return func() error { return session.ExecuteBatch(batch) }
+	}
+
+	if r := group[0]; bc == 2 {
+		preparedQuery.Bind(r.Blob, r.Seq)
+	} else if bc == 1 {
+		if colSettings.UseSeq {
+			preparedQuery.Bind(r.Seq)
+		} else if colSettings.UseBlob {
+			preparedQuery.Bind(r.Blob)
+		}
+	}
+	return preparedQuery.Exec
 }
 
 func prepareSimpleDeleteQueries(fromLedgerIdx uint64, toLedgerIdx uint64, deleteQueryTemplate string) deleteInfo {
 	var info = deleteInfo{Query: deleteQueryTemplate}
-
-	// Note: we deliberately add 1 extra ledger to make sure we delete any data Clio might have written
-	// if it crashed or was stopped in the middle of writing just before it wrote ledger_range.
-	for i := fromLedgerIdx; i <= toLedgerIdx+1; i++ {
+	for i := fromLedgerIdx; i <= toLedgerIdx; i++ {
 		info.Data = append(info.Data, deleteParams{Seq: i})
 	}
 
 	return info
 }
 
-func prepareDeleteQueries(cluster *gocql.ClusterConfig, fromLedgerIdx uint64, queryTemplate string, deleteQueryTemplate string) (deleteInfo, uint64, uint64) {
-	rangesChannel := make(chan *tokenRange, len(ranges))
+// scannedRow is a row of a versioned table that is kept back until every version of its key was scanned
+type scannedRow struct {
+	params   deleteParams
+	selected bool
+}
+
+// when filter is set, the scan query selects a third (blob) column that rows must pass the filter with;
+// with supersededOnly it sees every version at or before toLedgerIdx+1.
+// With supersededOnly, a row is only deleted when a newer row of the same key at or before toLedgerIdx+1
+// supersedes it, so that the state of the ledger after the window stays intact
+func prepareDeleteQueries(cluster *gocql.ClusterConfig, table string, fromLedgerIdx uint64, toLedgerIdx uint64, supersededOnly bool, queryTemplate string, deleteQueryTemplate string, filter func(key []byte, seq uint64, blob []byte) bool) (deleteInfo, uint64, uint64) {
+	if rows, scanned, err := resume.scanned(); err != nil {
+		log.Printf("ERROR: failed reading the rows found by an earlier run: %s\n", err)
+		return deleteInfo{Query: deleteQueryTemplate}, 0, 1
+	} else if scanned {
+		// the spool holds the rows in scan order; the interrupted run split its delete chunks after sorting
+		log.Printf("Using the %d rows to delete an earlier run found\n", len(rows))
+		info := deleteInfo{Query: deleteQueryTemplate, Data: rows}
+		resume.sortRows(&info)
+		return info, 0, 0
+	}
+
+	spooled, err := resume.spooledRows()
+	if err != nil {
+		log.Printf("ERROR: failed reading the rows found by an earlier run: %s\n", err)
+		return deleteInfo{Query: deleteQueryTemplate}, 0, 1
+	}
+
+	var skippedRanges uint64
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
 	for i := range ranges {
+		if resume.rangeDone(ranges[i].StartRange) {
+			skippedRanges++
+			continue
+		}
 		rangesChannel <- ranges[i]
 	}
 
 	close(rangesChannel)
 
+	if skippedRanges > 0 {
+		log.Printf("Continuing the scan of an earlier run: %d token ranges done, %d rows to delete found\n", skippedRanges, len(spooled))
+	}
+
 	outChannel := make(chan deleteParams)
-	var info = deleteInfo{Query: deleteQueryTemplate}
+	collected := make(chan struct{})
+	var info = deleteInfo{Query: deleteQueryTemplate, Data: spooled}
 
 	go func() {
+		defer close(collected)
 		for params := range outChannel {
 			info.Data = append(info.Data, params)
 		}
 	}()
 
+	// rows are spooled before the page they came from is recorded as done
+	emit := func(params deleteParams) {
+		resume.spoolRow(params)
+		outChannel <- params
+	}
+
 	var wg sync.WaitGroup
 	var sessionCreationWaitGroup sync.WaitGroup
 	var totalRows uint64
 	var totalErrors uint64
+	var prunedRanges uint64
+
+	// the sequence of windowed tables is the first half of a (sequence, index) tuple, and only the newest
+	// row of every partition is scanned
+	windowed := queryTemplates[table].WindowDelete != ""
+	var windowProbe string
+	if windowed {
+		windowProbe = windowProbeQuery(queryTemplates[table], fromLedgerIdx, toLedgerIdx)
+	}
+
+	// superseded versions cannot be probed for, every key keeps one in the window
+	probe := queryTemplates[table].Probe
+	if !*skipPrunedRanges || supersededOnly {
+		probe = ""
+	}
+
+	tableProgress := startProgress(table, "scan", uint64(len(ranges)), "token ranges", "rows")
+	defer tableProgress.stop()
+	tableProgress.addSteps(skippedRanges)
+
+	rowsScanned := rowsScannedCounter.WithLabelValues(table)
+	scanErrors := errorsCounter.WithLabelValues(table, "scan")
+
+	workers := workersFor(table)
+	pageSize := pageSizeFor(table)
+	limiter := newAdaptiveLimit(table, "scan", workers)
+	defer limiter.stop()
 
-	wg.Add(workerCount)
-	sessionCreationWaitGroup.Add(workerCount)
+	wg.Add(workers)
+	sessionCreationWaitGroup.Add(workers)
 
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < workers; i++ {
 		go func(q string) {
 			defer wg.Done()
 
 			var session *gocql.Session
 			var err error
-			if session, err = cluster.CreateSession(); err == nil {
+			if session, err = createSession(cluster); err == nil {
 				defer session.Close()
 
 				sessionCreationWaitGroup.Done()
 				sessionCreationWaitGroup.Wait()
-				preparedQuery := session.Query(q)
+				preparedQuery := speculative(scanConsistency(session.Query(q)))
 
 				for r := range rangesChannel {
+					if interrupted() {
+						break
+					}
+
+					if probe != "" && !hasRowsInWindow(session, probe, r, fromLedgerIdx, toLedgerIdx) {
+						atomic.AddUint64(&prunedRanges, 1)
+						resume.finishRange(r.StartRange)
+						tableProgress.addSteps(1)
+						continue
+					}
+
 					preparedQuery.Bind(r.StartRange, r.EndRange)
 
-					var pageState []byte
+					// all rows of a key share its token and thus come from the same token range
+					var versions []scannedRow
+					flushVersions := func() {
+						var newest uint64
+						for _, v := range versions {
+							newest = max(newest, v.params.Seq)
+						}
+						for _, v := range versions {
+							if v.selected && fromLedgerIdx <= v.params.Seq && v.params.Seq < newest {
+								emit(v.params)
+							}
+						}
+						versions = versions[:0]
+					}
+
 					var rowsRetrieved uint64
 					var key []byte
 					var seq uint64
+					var index int64
+					var blob []byte
+
+					scan := rangeScan{
+						query:    preparedQuery,
+						text:     queryTemplate,
+						r:        r,
+						pageSize: pageSize,
+						limiter:  limiter,
+						row: func(scanner gocql.Scanner) error {
+							var err error
+							if filter != nil {
+								err = scanner.Scan(&key, &seq, &blob)
+							} else if windowed {
+								err = scanner.Scan(&key, &seq, &index)
+							} else {
+								err = scanner.Scan(&key, &seq)
+							}
+							if err != nil {
+								return err
+							}
 
-					for {
-						iter := preparedQuery.PageSize(*clusterPageSize).PageState(pageState).Iter()
-						nextPageState := iter.PageState()
-						scanner := iter.Scanner()
-
-						for scanner.Next() {
-							err = scanner.Scan(&key, &seq)
-							if err == nil {
-								rowsRetrieved++
+							rowsRetrieved++
+							tableProgress.addItems(1)
+							rowsScanned.Inc()
+							usage.addRead(len(key) + 8 + len(blob))
 
-								// only grab the rows that are in the correct range of sequence numbers
-								if fromLedgerIdx <= seq {
-									outChannel <- deleteParams{Seq: seq, Blob: key}
+							if supersededOnly {
+								if len(versions) > 0 && !bytes.Equal(versions[0].params.Blob, key) {
+									flushVersions()
 								}
-							} else {
-								log.Printf("ERROR: page iteration failed: %s\n", err)
-								fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d][pagestate=%x]", queryTemplate, r.StartRange, r.EndRange, pageState))
-								atomic.AddUint64(&totalErrors, 1)
+								if seq <= toLedgerIdx+1 {
+									versions = append(versions, scannedRow{params: deleteParams{Seq: seq, Blob: key}, selected: filter == nil || filter(key, seq, blob)})
+								}
+								return nil
 							}
-						}
 
-						if len(nextPageState) == 0 {
-							break
-						}
+							// a partition whose newest row is past the window has rows in it only when the probe
+							// finds one, the others are not deleted from
+							if windowed && fromLedgerIdx <= seq && seq > toLedgerIdx {
+								inWindow, found, err := windowRow(session, windowProbe, key)
+								if err != nil {
+									log.Printf("ERROR: window probe failed: %s\n", err)
+									fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [key=0x%x]", windowProbe, key))
+									atomic.AddUint64(&totalErrors, 1)
+									scanErrors.Inc()
+									tableProgress.addErrors(1)
+									budget.add(1)
+								} else if found {
+									emit(deleteParams{Seq: inWindow, Blob: key})
+								}
+								return nil
+							}
 
-						pageState = nextPageState
+							// only grab the rows that are in the correct range of sequence numbers
+							if fromLedgerIdx <= seq && seq <= toLedgerIdx && (filter == nil || filter(key, seq, blob)) {
+								emit(deleteParams{Seq: seq, Blob: key})
+							}
+							return nil
+						},
+						failed: func() {
+							atomic.AddUint64(&totalErrors, 1)
+							scanErrors.Inc()
+							tableProgress.addErrors(1)
+							budget.add(1)
+						},
+						// versions of a key that is split across pages are not spooled yet, so a resumed
+						// run may leave some superseded rows behind but never deletes the newest one
+						savePage: func(pageState []byte) { resume.savePage(r.StartRange, pageState) },
 					}
+					complete := scan.run(resume.pageState(r.StartRange))
 
+					flushVersions()
+					if complete {
+						resume.finishRange(r.StartRange)
+					}
 					atomic.AddUint64(&totalRows, rowsRetrieved)
+					tableProgress.addSteps(1)
 				}
 			} else {
 				log.Printf("ERROR: %s\n", err)
 				fmt.Fprintf(os.Stderr, "FAILED TO CREATE SESSION: %s\n", err)
 				atomic.AddUint64(&totalErrors, 1)
+				scanErrors.Inc()
+				tableProgress.addErrors(1)
+				budget.add(1)
 			}
 		}(queryTemplate)
 	}
 
 	wg.Wait()
 	close(outChannel)
+	<-collected
+
+	if probe != "" {
+		log.Printf("Token ranges of %s without rows in the window, not scanned: %d of %d\n", table, prunedRanges, len(ranges))
+	}
+
+	// an interrupted scan is continued by the next run
+	if !interrupted() {
+		resume.sortRows(&info)
+		resume.finishScan()
+	}
 
 	return info, totalRows, totalErrors
 }
 
-func performDeleteQueries(cluster *gocql.ClusterConfig, info *deleteInfo, colSettings columnSettings) (uint64, uint64) {
+// rangeScan pages through the rows of one token range with a scan query, bound to the range, the way every
+// scan of the tool does: rate limited, under backpressure and with retried page fetches
+type rangeScan struct {
+	query    *gocql.Query
+	text     string // the query as shown on FAILED QUERY lines
+	r        *cassandra.TokenRange
+	pageSize int
+	limiter  *adaptiveLimit
+	row      func(scanner gocql.Scanner) error // scans and handles a row, an error counts as a failed row
+	failed   func()                            // counts a failed page or row
+	savePage func(pageState []byte)            // records the page state once the rows before it are handled
+}
+
+// run scans the token range from the given page state and tells whether it was scanned to the end
+func (s rangeScan) run(pageState []byte) bool {
+	for {
+		// the page state of the token range is saved, a resumed run continues from it
+		if interrupted() {
+			return false
+		}
+
+		// only fetching a page is retried, rows of a page that failed midway are not scanned twice
+		var iter *gocql.Iter
+		var scanner gocql.Scanner
+		var more bool
+		backpressure.acquire()
+		s.limiter.acquire()
+		err := retry.Do(s.limiter.timed(func() error {
+			iter = s.query.PageSize(s.limiter.pageSize(s.pageSize)).PageState(pageState).Iter()
+			scanner = iter.Scanner()
+			if more = scanner.Next(); !more {
+				return scanner.Err()
+			}
+			return nil
+		}))
+		s.limiter.release()
+		backpressure.release()
+		if err != nil {
+			s.fail("page query failed", err, pageState)
+			return false
+		}
+
+		nextPageState := iter.PageState()
+
+		for ; more; more = scanner.Next() {
+			scanRate.Wait()
+			if err := s.row(scanner); err != nil {
+				s.fail("page iteration failed", err, pageState)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			s.fail("page iteration failed", err, pageState)
+		}
+
+		if len(nextPageState) == 0 {
+			return true
+		}
+
+		if s.savePage != nil {
+			s.savePage(nextPageState)
+		}
+		pageState = nextPageState
+	}
+}
+
+func (s rangeScan) fail(what string, err error, pageState []byte) {
+	log.Printf("ERROR: %s: %s\n", what, err)
+	fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d][pagestate=%x]", s.text, s.r.StartRange, s.r.EndRange, pageState))
+	s.failed()
+}
+
+// hasRowsInWindow probes a token range for rows of the window; when the probe fails, the range is scanned
+func hasRowsInWindow(session *gocql.Session, probe string, r *cassandra.TokenRange, fromLedgerIdx uint64, toLedgerIdx uint64) bool {
+	var seq uint64
+	err := speculative(scanConsistency(session.Query(probe, r.StartRange, r.EndRange, fromLedgerIdx, min(toLedgerIdx, math.MaxInt64)))).Scan(&seq)
+	if err == gocql.ErrNotFound {
+		return false
+	}
+	if err != nil {
+		log.Printf("WARNING: probe failed, scanning the token range: %s\n", err)
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d]", probe, r.StartRange, r.EndRange))
+	}
+	return true
+}
+
+// windowRow returns the sequence of the newest row of a partition of a windowed table in the window
+func windowRow(session *gocql.Session, probe string, key []byte) (uint64, bool, error) {
+	var seq uint64
+	var index int64
+
+	scanRate.Wait()
+	backpressure.acquire()
+	err := retry.Do(func() error {
+		return speculative(scanConsistency(session.Query(probe, key))).Scan(&seq, &index)
+	})
+	backpressure.release()
+
+	if errors.Is(err, gocql.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	usage.addRead(len(key) + 16)
+	return seq, true, nil
+}
+
+func performDeleteQueries(cluster *gocql.ClusterConfig, table string, info *deleteInfo, colSettings columnSettings) (uint64, uint64) {
+	if estimating != nil {
+		return uint64(len(info.Data)), 0
+	}
+
 	var wg sync.WaitGroup
 	var sessionCreationWaitGroup sync.WaitGroup
 	var totalDeletes uint64
 	var totalErrors uint64
 
-	chunks := splitDeleteWork(info)
-	chunksChannel := make(chan []deleteParams, len(chunks))
+	// rows of a partition must be next to each other to be batched
+	if *batchSize > 1 {
+		sortDeleteParams(info.Data)
+	}
+
+	workers := workersFor(table)
+	chunks := splitDeleteWork(info, workers)
+	chunksChannel := make(chan int, len(chunks))
+	var skippedDeletes uint64
 	for i := range chunks {
-		chunksChannel <- chunks[i]
+		if resume.chunkDone(i) {
+			skippedDeletes += uint64(len(chunks[i]))
+			continue
+		}
+		chunksChannel <- i
 	}
 
 	close(chunksChannel)
 
-	wg.Add(workerCount)
-	sessionCreationWaitGroup.Add(workerCount)
+	if skippedDeletes > 0 {
+		log.Printf("Continuing the deletes of an earlier run: %d of %d done\n", skippedDeletes, len(info.Data))
+	}
+
+	tableProgress := startProgress(table, "delete", uint64(len(info.Data)), "queries", "deletes")
+	defer tableProgress.stop()
+	tableProgress.addSteps(skippedDeletes)
+
+	deletes := deletesCounter.WithLabelValues(table)
+	deleteErrors := errorsCounter.WithLabelValues(table, "delete")
+
+	limiter := newAdaptiveLimit(table, "delete", workers)
+	defer limiter.stop()
+
+	wg.Add(workers)
+	sessionCreationWaitGroup.Add(workers)
 
 	query := info.Query
 	bindCount := strings.Count(query, "?")
 
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < workers; i++ {
 		go func(number int, q string, bc int) {
 			defer wg.Done()
 
-			var session *gocql.Session
+			var session, mirrorSession *gocql.Session
 			var err error
-			if session, err = cluster.CreateSession(); err == nil {
+			if session, err = createSession(cluster); err == nil && mirror != nil {
+				if mirrorSession, err = createSession(mirror); err == nil {
+					defer mirrorSession.Close()
+				} else {
+					session.Close()
+				}
+			}
+			if err == nil {
 				defer session.Close()
 
 				sessionCreationWaitGroup.Done()
 				sessionCreationWaitGroup.Wait()
 				preparedQuery := session.Query(q)
+				var mirrorQuery *gocql.Query
+				if mirrorSession != nil {
+					mirrorQuery = mirrorSession.Query(q)
+				}
 
-				for chunk := range chunksChannel {
-					for _, r := range chunk {
-						if bc == 2 {
-							preparedQuery.Bind(r.Blob, r.Seq)
-						} else if bc == 1 {
-							if colSettings.UseSeq {
-								preparedQuery.Bind(r.Seq)
-							} else if colSettings.UseBlob {
-								preparedQuery.Bind(r.Blob)
+				for idx := range chunksChannel {
+					rows := chunks[idx]
+					for len(rows) > 0 && !interrupted() {
+						maintenance.wait()
+						if interrupted() {
+							break
+						}
+
+						group := rows[:partitionBatch(rows, bc)]
+						rows = rows[len(group):]
+
+						exec := deleteExec(session, preparedQuery, q, bc, colSettings, group)
+						if mirrorSession != nil {
+							// the group is deleted from the mirror once it is gone from the pruned keyspace; a retry
+							// after a mirror failure does not repeat the deletes of the pruned keyspace
+							primaryExec, mirrorExec := exec, deleteExec(mirrorSession, mirrorQuery, q, bc, colSettings, group)
+							primaryDone := false
+							exec = func() error {
+								if !primaryDone {
+									if err := primaryExec(); err != nil {
+										return err
+									}
+									primaryDone = true
+								}
+								return mirrorExec()
 							}
 						}
 
-						if err := preparedQuery.Exec(); err != nil {
+						for range group {
+							deleteRate.Wait()
+						}
+						backpressure.acquire()
+						limiter.acquire()
+						err := retry.Do(limiter.timed(exec))
+						limiter.release()
+						backpressure.release()
+						for err != nil && !interrupted() && gate.failure(err) {
+							backpressure.acquire()
+							limiter.acquire()
+							err = retry.Do(limiter.timed(exec))
+							limiter.release()
+							backpressure.release()
+						}
+
+						n := uint64(len(group))
+						if err != nil {
 							log.Printf("DELETE ERROR: %s\n", err)
-							fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [blob=0x%x][seq=%d]", info.Query, r.Blob, r.Seq))
-							atomic.AddUint64(&totalErrors, 1)
+							for _, r := range group {
+								fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [blob=0x%x][seq=%d]", info.Query, r.Blob, r.Seq))
+							}
+							atomic.AddUint64(&totalErrors, n)
+							deleteErrors.Add(float64(n))
+							tableProgress.addErrors(n)
+							budget.add(n)
 						} else {
-							atomic.AddUint64(&totalDeletes, 1)
+							gate.success()
+							audit.record(table, info.Query, group)
+							atomic.AddUint64(&totalDeletes, n)
+							tableProgress.addItems(n)
+							deletes.Add(float64(n))
 						}
+						tableProgress.addSteps(n)
+					}
+					if len(rows) > 0 {
+						break // interrupted, the rest of the chunk is deleted by the next run
 					}
+					resume.finishChunk(idx)
 				}
 			} else {
 				log.Printf("ERROR: %s\n", err)
 				fmt.Fprintf(os.Stderr, "FAILED TO CREATE SESSION: %s\n", err)
 				atomic.AddUint64(&totalErrors, 1)
+				deleteErrors.Inc()
+				tableProgress.addErrors(1)
+				budget.add(1)
 			}
 		}(i, query, bindCount)
 	}
@@ -597,16 +1929,81 @@ func performDeleteQueries(cluster *gocql.ClusterConfig, info *deleteInfo, colSet
 	return totalDeletes, totalErrors
 }
 
-func updateLedgerRange(cluster *gocql.ClusterConfig, ledgerIndex uint64) error {
-	log.Printf("Updating latest ledger to %d\n", ledgerIndex)
+// scanTable finds the rows of a scanned table to delete
+func scanTable(cluster *gocql.ClusterConfig, name string, def queryTemplate, window ledgerWindow) (deleteInfo, uint64, uint64) {
+	fromLedgerIdx, toLedgerIdx := window.from, window.to
+	if window.open {
+		// also delete the rows Clio wrote past the latest ledger of ledger_range before it was stopped
+		toLedgerIdx = math.MaxUint64
+	}
+
+	scanQuery := def.Scan
+	var filter func([]byte, uint64, []byte) bool
+	var chain *successorChain
+	var skippedRows uint64
+	switch {
+	case name == "successor" && window.head && *collapseSuccessor:
+		chain = newSuccessorChain()
+		scanQuery = def.TypedScan
+		filter = chain.record
+	case name == "objects" && len(objectTypeFilter) > 0:
+		scanQuery = def.TypedScan
+		filter = func(_ []byte, _ uint64, blob []byte) bool {
+			if matchesObjectTypes(blob) {
+				return true
+			}
+			atomic.AddUint64(&skippedRows, 1)
+			return false
+		}
+	}
+
+	deleteQuery := def.Delete
+	if def.WindowDelete != "" {
+		// only the newest row of every partition is scanned; partitions whose newest row is past the window
+		// are probed for a row in it
+		deleteQuery = windowDeleteQuery(def, fromLedgerIdx, toLedgerIdx)
+	}
+
+	info, rowsCount, errCount := prepareDeleteQueries(cluster, name, fromLedgerIdx, toLedgerIdx, def.Versioned && window.head && *keepLastObjectVersion,
+		scanQuery,
+		deleteQuery,
+		filter)
+
+	if chain != nil && !interrupted() {
+		if errCount > 0 {
+			log.Printf("ERROR not collapsing the successor linked list: %d scan errors\n", errCount)
+		} else if unlinked, err := chain.unlinked(); err != nil {
+			log.Printf("ERROR not collapsing the successor linked list: %s\n", err)
+		} else {
+			log.Printf("Keys no longer in the successor linked list of ledger %d: %d\n", window.to+1, len(unlinked))
+			info.Data = append(info.Data, unlinked...)
+		}
+	} else if filter != nil {
+		log.Printf("Rows kept because of their object type: %d\n", skippedRows)
+	}
+
+	// a range delete would also take the versions of the key the filter kept, i.e. deletion markers
+	if window.open && *rangeDeletes && def.RangeDelete != "" && filter == nil {
+		info = rangeDeleteQueries(info, fromLedgerIdx, def.RangeDelete)
+	}
+
+	return info, rowsCount, errCount
+}
+
+func updateLedgerRange(cluster *gocql.ClusterConfig, ledgerIndex uint64, isLatest bool) error {
+	if isLatest {
+		log.Printf("Updating latest ledger to %d\n", ledgerIndex)
+	} else {
+		log.Printf("Updating earliest ledger to %d\n", ledgerIndex)
+	}
 
-	if session, err := cluster.CreateSession(); err == nil {
+	if session, err := createSession(cluster); err == nil {
 		defer session.Close()
 
 		query := "UPDATE ledger_range SET sequence = ? WHERE is_latest = ?"
-		preparedQuery := session.Query(query, ledgerIndex, true)
+		preparedQuery := session.Query(query, ledgerIndex, isLatest)
 		if err := preparedQuery.Exec(); err != nil {
-			fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [seq=%d][true]\n", query, ledgerIndex)
+			fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [seq=%d][%t]\n", query, ledgerIndex, isLatest)
 			return err
 		}
 	} else {
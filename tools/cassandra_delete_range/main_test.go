@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveWindow(t *testing.T) {
+	*keepLastObjectVersion = true
+
+	tests := []struct {
+		name     string
+		window   ledgerWindow
+		earliest uint64
+		latest   uint64
+		want     ledgerWindow
+	}{
+		{
+			"open", ledgerWindow{from: 150, open: true}, 100, 200,
+			ledgerWindow{from: 150, to: 200, open: true},
+		},
+		{
+			"open from the ledger after the latest", ledgerWindow{from: 201, open: true}, 100, 200,
+			ledgerWindow{from: 201, to: 200, open: true},
+		},
+		{
+			"middle", ledgerWindow{from: 120, to: 150}, 100, 200,
+			ledgerWindow{from: 120, to: 150},
+		},
+		{
+			"head", ledgerWindow{from: 1, to: 150}, 100, 200,
+			ledgerWindow{from: 100, to: 150, head: true},
+		},
+		{
+			"head from the earliest", ledgerWindow{from: 100, to: 150}, 100, 200,
+			ledgerWindow{from: 100, to: 150, head: true},
+		},
+		{
+			"before the earliest", ledgerWindow{from: 10, to: 50}, 100, 200,
+			ledgerWindow{from: 10, to: 50, head: true},
+		},
+		{
+			"tail", ledgerWindow{from: 150, to: 300}, 100, 200,
+			ledgerWindow{from: 150, to: 200, open: true},
+		},
+		{
+			"keep latest", ledgerWindow{keepLatest: 50}, 100, 200,
+			ledgerWindow{from: 100, to: 150, head: true, keepLatest: 50},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := resolveWindow(tt.window, tt.earliest, tt.latest); got != tt.want {
+			t.Errorf("%s: resolveWindow(%+v, %d, %d) = %+v, want %+v", tt.name, tt.window, tt.earliest, tt.latest, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type tableManifest struct {
+	Table      string `json:"table"`
+	FromLedger uint64 `json:"from_ledger"`
+	// nil for scanned tables of runs up to the latest ledger, where every row from FromLedger on was deleted
+	ToLedger    *uint64  `json:"to_ledger"`
+	Deleted     uint64   `json:"deleted"`
+	Errors      uint64   `json:"errors"`
+	ObjectTypes []string `json:"object_types,omitempty"`
+}
+
+type ledgerRange struct {
+	First  uint64 `json:"first"`
+	Latest uint64 `json:"latest"`
+}
+
+// pruneManifest describes what a run removed, so later runs can tell which regions are clean already
+type pruneManifest struct {
+	ID                string          `json:"id"`
+	Command           string          `json:"command,omitempty"` // set by the commands other than prune
+	ToolVersion       string          `json:"tool_version"`
+	Started           time.Time       `json:"started"`
+	Finished          time.Time       `json:"finished"`
+	Keyspace          string          `json:"keyspace"`
+	FromLedger        uint64          `json:"from_ledger"`
+	ToLedger          uint64          `json:"to_ledger"`
+	LedgerRangeBefore ledgerRange     `json:"ledger_range_before"`
+	LatestAfter       uint64          `json:"ledger_range_latest_after"`
+	Complete          bool            `json:"complete"`
+	Tables            []tableManifest `json:"tables"`
+}
+
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " " + s.Value
+		}
+	}
+	return version
+}
+
+func newManifest(report *runReport, before ledgerRange) *pruneManifest {
+	m := &pruneManifest{
+		ID:                gocql.TimeUUID().String(),
+		Command:           report.Command,
+		ToolVersion:       toolVersion(),
+		Started:           report.Started,
+		Finished:          time.Now().UTC(),
+		Keyspace:          report.Keyspace,
+		FromLedger:        report.FromLedger,
+		ToLedger:          report.ToLedger,
+		LedgerRangeBefore: before,
+		LatestAfter:       before.Latest,
+		Complete:          report.TotalErrors == 0 && !tokenSubset(),
+	}
+
+	if report.LedgerRangeUpdated && report.ToLatest {
+		m.LatestAfter = report.FromLedger - 1
+	}
+
+	for _, t := range report.Tables {
+		mt := tableManifest{Table: t.Table, FromLedger: report.FromLedger, Deleted: t.Deletes, Errors: t.Errors}
+		if !report.ToLatest {
+			to := report.ToLedger
+			mt.ToLedger = &to
+		} else if queryTemplates[t.Table].Scan == "" {
+			// see deleteLedgerData for the extra ledger
+			to := report.ToLedger + 1
+			mt.ToLedger = &to
+		}
+		if t.Table == "objects" && len(objectTypeFilter) > 0 {
+			for name := range objectTypeFilter {
+				mt.ObjectTypes = append(mt.ObjectTypes, name)
+			}
+		}
+		m.Tables = append(m.Tables, mt)
+	}
+
+	return m
+}
+
+// writeManifest stores the manifest as a file in --manifest-dir and, unless disabled, in the keyspace
+func writeManifest(cluster *gocql.ClusterConfig, m *pruneManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(*manifestDir, fmt.Sprintf("prune-manifest-%s-%d-%d-%d.json", m.Keyspace, m.FromLedger, m.ToLedger, m.Finished.Unix()))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("Manifest written to %s\n", path)
+
+	if *manifestTable == "" {
+		return nil
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id timeuuid PRIMARY KEY, finished timestamp, manifest text)", *manifestTable)
+	if err := session.Query(create).Exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", create)
+		return err
+	}
+
+	id, _ := gocql.ParseUUID(m.ID)
+	insert := fmt.Sprintf("INSERT INTO %s (id, finished, manifest) VALUES (?, ?, ?)", *manifestTable)
+	if err := session.Query(insert, id, m.Finished, string(data)).Exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED QUERY: %s [id=%s]\n", insert, m.ID)
+		return err
+	}
+
+	log.Printf("Manifest %s stored in the %s table\n", m.ID, *manifestTable)
+	return nil
+}
+
+// loadManifests reads the manifests of --previous-manifest and of the manifest table
+func loadManifests(cluster *gocql.ClusterConfig) ([]*pruneManifest, error) {
+	var manifests []*pruneManifest
+	for _, path := range *previousManifests {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var m pruneManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		manifests = append(manifests, &m)
+	}
+
+	if *manifestTable == "" {
+		return manifests, nil
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	defer session.Close()
+
+	var text string
+	iter := session.Query(fmt.Sprintf("SELECT manifest FROM %s", *manifestTable)).Iter()
+	for iter.Scan(&text) {
+		var m pruneManifest
+		if err := json.Unmarshal([]byte(text), &m); err != nil {
+			log.Printf("WARNING: ignoring unreadable manifest: %s\n", err)
+			continue
+		}
+		manifests = append(manifests, &m)
+	}
+
+	if err := iter.Close(); err != nil {
+		// the table only exists once a run stored a manifest
+		log.Printf("No manifests read from the %s table: %s\n", *manifestTable, err)
+	}
+
+	return manifests, nil
+}
+
+type ledgerSpan struct {
+	from uint64
+	to   *uint64
+}
+
+func (s ledgerSpan) covers(from uint64, to uint64) bool {
+	return s.from <= from && (s.to == nil || *s.to >= to)
+}
+
+// cleanRegions holds the ledgers of every table that earlier runs deleted and nothing wrote to since
+type cleanRegions struct {
+	spans map[string][]ledgerSpan
+}
+
+// only complete manifests of this keyspace, written when ledger_range was where it is now, are trusted
+func newCleanRegions(manifests []*pruneManifest, latestInDB uint64) *cleanRegions {
+	c := &cleanRegions{spans: make(map[string][]ledgerSpan)}
+	for _, m := range manifests {
+		// runs deleting by key leave the rows of other keys in every ledger, applied plans maybe the rows of
+		// other object types
+		if m.Keyspace != *keyspace || !m.Complete || m.Command != "" || m.LatestAfter != latestInDB {
+			continue
+		}
+
+		log.Printf("Using manifest %s of the run that deleted %d -> %d\n", m.ID, m.FromLedger, m.ToLedger)
+		for _, t := range m.Tables {
+			if len(t.ObjectTypes) == 0 {
+				c.spans[t.Table] = append(c.spans[t.Table], ledgerSpan{from: t.FromLedger, to: t.ToLedger})
+			}
+		}
+	}
+	return c
+}
+
+// skipTable tells whether every ledger of from -> to is clean in the table already
+func (c *cleanRegions) skipTable(table string, from uint64, to uint64) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, s := range c.spans[table] {
+		if s.covers(from, to) {
+			log.Printf("Skipping %s table: ledgers %d -> %d were already deleted by an earlier run\n\n", table, from, to)
+			return true
+		}
+	}
+	return false
+}
+
+// filterSeqs drops the deletes of ledgers that are clean in the table already
+func (c *cleanRegions) filterSeqs(table string, info deleteInfo) deleteInfo {
+	if c == nil || len(c.spans[table]) == 0 {
+		return info
+	}
+
+	filtered := deleteInfo{Query: info.Query}
+	for _, d := range info.Data {
+		clean := false
+		for _, s := range c.spans[table] {
+			clean = clean || s.covers(d.Seq, d.Seq)
+		}
+		if !clean {
+			filtered.Data = append(filtered.Data, d)
+		}
+	}
+
+	if skipped := len(info.Data) - len(filtered.Data); skipped > 0 {
+		log.Printf("Skipping %d ledgers of the %s table that were already deleted by an earlier run\n", skipped, table)
+	}
+	return filtered
+}
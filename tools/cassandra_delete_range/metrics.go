@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	rowsScannedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clio_prune_rows_scanned_total",
+		Help: "Rows read while scanning a table for the rows to delete",
+	}, []string{"table"})
+	deletesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clio_prune_deletes_total",
+		Help: "Successful delete queries",
+	}, []string{"table"})
+	errorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clio_prune_errors_total",
+		Help: "Scan pages and deletes that failed for good, after retrying",
+	}, []string{"table", "phase"})
+	queryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clio_prune_query_duration_seconds",
+		Help:    "Latency of every query attempt against the cluster",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"table", "kind"})
+	workerLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "clio_prune_worker_limit",
+		Help: "Workers of a table allowed to query at once by --adaptive",
+	}, []string{"table", "phase"})
+)
+
+// serveMetrics publishes the metrics on /metrics of the port
+func serveMetrics(port int) {
+	prometheus.MustRegister(rowsScannedCounter, deletesCounter, errorsCounter, queryLatency, workerLimitGauge)
+
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	}()
+
+	log.Printf("Serving metrics on :%d/metrics\n", port)
+}
+
+// tableOfStatement finds the table a query of the run belongs to
+func tableOfStatement(statement string) string {
+	for table, q := range queryTemplates {
+		if statement == q.Scan || statement == q.Delete || statement == q.TypedScan || statement == q.RangeDelete {
+			return table
+		}
+	}
+	return "other"
+}
+
+func observeQueryLatency(q gocql.ObservedQuery) {
+	kind := "delete"
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(q.Statement)), "SELECT") {
+		kind = "scan"
+	}
+
+	queryLatency.WithLabelValues(tableOfStatement(q.Statement), kind).Observe(q.End.Sub(q.Start).Seconds())
+}
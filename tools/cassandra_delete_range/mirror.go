@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gocql/gocql"
+)
+
+var mirror *gocql.ClusterConfig // the standby keyspace the deletes are repeated on; nil unless --mirror-hosts
+
+// openMirror connects to the keyspace of --mirror-hosts, which must hold the same ledgers as the pruned one
+// for the rows scanned in the pruned one to be all there is to delete in it
+func openMirror(earliest uint64, latest uint64) {
+	if *mirrorHosts == "" {
+		return
+	}
+
+	cluster := newClusterConfig(*mirrorHosts)
+	if *mirrorKeyspace != "" {
+		cluster.Keyspace = *mirrorKeyspace
+	}
+	cluster.QueryObserver = usage
+	cluster.BatchObserver = usage
+
+	mirrorEarliest, mirrorLatest, err := getLedgerRange(cluster)
+	if err != nil {
+		fatal(exitConnection, fmt.Errorf("mirror: %w", err))
+	}
+	if mirrorEarliest != earliest || mirrorLatest != latest {
+		fatalf(exitInvalid, "The ledger range of the mirror is %d:%d, not %d:%d; it must hold the same ledgers\n", mirrorEarliest, mirrorLatest, earliest, latest)
+	}
+
+	mirror = cluster
+}
+
+func updateMirrorLedgerRange(ledgerIndex uint64, isLatest bool) error {
+	if mirror == nil {
+		return nil
+	}
+
+	return updateLedgerRange(mirror, ledgerIndex, isLatest)
+}
+
+func mirrorDescription() string {
+	if mirror == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s (keyspace %s)", *mirrorHosts, mirror.Keyspace)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/cassandra_delete_range/internal/cass"
+	"xrplf/clio/xrpl"
+)
+
+// offset of the issuer in an NFToken ID: flags, transfer fee, issuer, taxon and sequence
+const nftIssuerOffset = 2 + 2
+
+// the tables of the NFTs of one issuer, keyed by token ID; issuer_nf_tokens_v2 is keyed by issuer
+var nftTokenTables = []string{"nf_tokens", "nf_token_uris", "nf_token_transactions"}
+
+// parseAccountID reads an account as a classic address, i.e. rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh, or as
+// the 40 hex digits of its AccountID
+func parseAccountID(account string) ([]byte, error) {
+	if id, err := hex.DecodeString(account); err == nil && len(id) == 20 {
+		return id, nil
+	}
+
+	id, err := xrpl.DecodeAccountID(account)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an address nor an AccountID: %w", account, err)
+	}
+	return id, nil
+}
+
+// nftDeleteQuery deletes every row of a token from one of nftTokenTables, or of an issuer from
+// issuer_nf_tokens_v2
+func nftDeleteQuery(table string) string {
+	if table == "issuer_nf_tokens_v2" {
+		return "DELETE FROM issuer_nf_tokens_v2 WHERE issuer = ?"
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE token_id = ?", table)
+}
+
+// issuerTokenIDs lists the NFTs of the issuer from issuer_nf_tokens_v2 or, in keyspaces without it, from
+// a scan of nf_tokens
+func issuerTokenIDs(session *gocql.Session, issuer []byte) ([][]byte, error) {
+	var ids [][]byte
+	if slices.Contains(tableNames, "issuer_nf_tokens_v2") {
+		var id []byte
+		iter := session.Query("SELECT token_id FROM issuer_nf_tokens_v2 WHERE issuer = ?", issuer).PageSize(*clusterPageSize).Iter()
+		for iter.Scan(&id) {
+			ids = append(ids, slices.Clone(id))
+		}
+		return ids, iter.Close()
+	}
+
+	log.Println("Keyspace has no issuer_nf_tokens_v2 table, scanning nf_tokens for the NFTs of the issuer")
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	tokens := newKeyScan("nf_tokens", "nft-issuer")
+	eachRange(func(r *cassandra.TokenRange) {
+		tokens.scan(session, r, func(id []byte) {
+			if len(id) >= nftIssuerOffset+20 && bytes.Equal(id[nftIssuerOffset:nftIssuerOffset+20], issuer) {
+				mu.Lock()
+				if !seen[string(id)] {
+					seen[string(id)] = true
+					ids = append(ids, slices.Clone(id))
+				}
+				mu.Unlock()
+			}
+		})
+	})
+	tokens.stop()
+	if errCount := tokens.failed(); errCount > 0 {
+		return nil, fmt.Errorf("%d token ranges of nf_tokens could not be scanned", errCount)
+	}
+	return ids, nil
+}
+
+// runDeleteNFTIssuer deletes every NFT of an issuer from the NFT tables, i.e. of spam issuers of private
+// networks. The NFToken pages in the objects table are not touched
+func runDeleteNFTIssuer(cluster *gocql.ClusterConfig) {
+	issuer, err := parseAccountID(*nftIssuer)
+	if err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	cluster.QueryObserver = usage
+	cluster.BatchObserver = usage
+	retry = &cass.RetryPolicy{Attempts: *retryAttempts, BaseDelay: *retryBaseDelay, MaxDelay: *retryMaxDelay, Jitter: *retryJitter}
+	deleteRate = cass.NewRateLimiter(*maxDeleteRate)
+	scanRate = cass.NewRateLimiter(*maxScanRate)
+
+	// issuer_nf_tokens_v2 is not pruned by ledger, it is only listed to be dropped when missing
+	tableNames = append(tableNames, "issuer_nf_tokens_v2")
+	loadTables(cluster)
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+	startBackpressure(cluster)
+
+	ids, err := issuerTokenIDs(session, issuer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Issuer %s (%X) has %d NFTs\n", *nftIssuer, issuer, len(ids))
+	if len(ids) == 0 {
+		return
+	}
+
+	var tables []string
+	for _, table := range nftTokenTables {
+		if slices.Contains(tableNames, table) {
+			tables = append(tables, table)
+		}
+	}
+	issuerTable := slices.Contains(tableNames, "issuer_nf_tokens_v2")
+	if issuerTable {
+		log.Printf("Will delete every row of them from %s and issuer_nf_tokens_v2\n", strings.Join(tables, ", "))
+	} else {
+		log.Printf("Will delete every row of them from %s\n", strings.Join(tables, ", "))
+	}
+
+	if !confirm() {
+		fatal(exitAborted, "Aborting...")
+	}
+
+	handleSignals()
+	run := startKeyedRun(cluster, "delete-nft-issuer")
+
+	var totalDeletes, totalErrors uint64
+	for _, table := range tables {
+		info := deleteInfo{Query: nftDeleteQuery(table)}
+		for _, id := range ids {
+			info.Data = append(info.Data, deleteParams{Blob: id})
+		}
+
+		deletes, failed := run.deleteRows(table, &info)
+		log.Printf("Deleted %d NFTs from %s, %d failed\n", deletes, table, failed)
+		totalDeletes += deletes
+		totalErrors += failed
+
+		if interrupted() {
+			run.finish()
+			os.Exit(exitAborted)
+		}
+	}
+
+	// the issuer's list goes last, so that an interrupted or failed run still finds the NFTs to delete again
+	if issuerTable && totalErrors == 0 {
+		info := deleteInfo{Query: nftDeleteQuery("issuer_nf_tokens_v2"), Data: []deleteParams{{Blob: issuer}}}
+		_, failed := run.deleteRows("issuer_nf_tokens_v2", &info)
+		totalErrors += failed
+	}
+	run.finish()
+
+	logTotals(totalErrors, uint64(len(ids)), totalDeletes)
+	if totalErrors > 0 {
+		os.Exit(exitPartial)
+	}
+}
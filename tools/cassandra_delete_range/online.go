@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// the rate limits of --online unless --max-delete-rate or --max-scan-rate are given
+const (
+	onlineDeleteRate = 500
+	onlineScanRate   = 5000
+)
+
+// applyOnline lowers the load of a run to what a cluster serving reads can take: one thread per node
+// and rate limited scans and deletes. Writers are expected to keep ingesting, so ledger_range moving
+// does not stop the run
+func applyOnline() {
+	if !*online {
+		return
+	}
+
+	workerCount = *nodesInCluster
+	if *maxDeleteRate <= 0 {
+		*maxDeleteRate = onlineDeleteRate
+	}
+	if *maxScanRate <= 0 {
+		*maxScanRate = onlineScanRate
+	}
+	*writerCheckInterval = 0
+}
+
+// checkOnlineWindow keeps the deletes of --online away from the ledgers clients read and writers write
+func checkOnlineWindow(window ledgerWindow, latestLedgerIdxInDB uint64) {
+	if !*online {
+		return
+	}
+
+	if window.open {
+		fatal(exitInvalid, "--online cannot delete up to the latest ledger, writers are still writing it")
+	}
+	if window.to+*onlineKeepLatest > latestLedgerIdxInDB {
+		fatalf(exitInvalid, "--online keeps the latest %d ledgers, the window ends at %d and the latest ledger is %d", *onlineKeepLatest, window.to, latestLedgerIdxInDB)
+	}
+
+	log.Printf("Running online: %d threads, scans at LOCAL_ONE, the latest %d ledgers are kept\n", workerCount, *onlineKeepLatest)
+}
+
+// scanConsistency is the consistency of the scan queries; --online only reads from one local replica
+func scanConsistency(q *gocql.Query) *gocql.Query {
+	if *online {
+		return q.Consistency(gocql.LocalOne)
+	}
+	return q
+}
+
+// raiseOnlineEarliestLedger moves the earliest ledger of ledger_range past a head window before anything
+// is deleted when running --online, so that Clio stops serving the ledgers of the window before their
+// rows go
+func raiseOnlineEarliestLedger(cluster *gocql.ClusterConfig, window ledgerWindow, report *runReport) error {
+	if !*online || !window.head || *skipWriteLatestLedger || estimating != nil || tokenSubset() {
+		return nil
+	}
+
+	log.Printf("Running online: raising the earliest ledger to %d before deleting\n", window.to+1)
+	return moveEarliestLedger(cluster, window, report)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/parquet-go/parquet-go"
+
+	"xrplf/clio/cassandra_delete_range/internal/cass"
+)
+
+// planFile is the CSV file of --plan-file listing every row a run is about to delete, written once the
+// scan of a table is done and before its deletes run:
+//
+//	# plan {"keyspace":"clio","from":32570,"to":1000000,"head":true,...}
+//	table,key,sequence,op
+//	objects,<key hex>,<sequence>,=
+//	objects,<key hex>,<sequence>,>=
+//	ledgers,,<sequence>,=
+//
+// where >= marks range deletes of every version of the key from the sequence on, and window the deletes
+// of all versions of the window. The comment on top is the planHeader. Write it with plan, or estimate
+// --sample 1, to review it before apply deletes its rows.
+//
+// When the name ends with .parquet the same columns are written as a Parquet file instead, with the
+// planHeader in the key-value metadata under "plan"
+type planFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	csv     *csv.Writer
+	parquet *parquet.GenericWriter[planRecord]
+	err     error // the first failed Parquet write
+}
+
+const planHeaderPrefix = "# plan "
+
+// planMetadataKey is the key-value metadata entry holding the planHeader of Parquet plans
+const planMetadataKey = "plan"
+
+// planRecord is a row of a Parquet plan, with the columns of the CSV one
+type planRecord struct {
+	Table    string `parquet:"table,dict"`
+	Key      string `parquet:"key"`
+	Sequence uint64 `parquet:"sequence"`
+	Op       string `parquet:"op,dict"`
+}
+
+func isParquetPlan(path string) bool {
+	return strings.HasSuffix(path, ".parquet")
+}
+
+// planHeader is what a plan was written against, so that apply only deletes its rows while they are still
+// the ones to delete
+type planHeader struct {
+	Keyspace          string      `json:"keyspace"`
+	From              uint64      `json:"from"`
+	To                uint64      `json:"to"`
+	Open              bool        `json:"open,omitempty"`
+	Head              bool        `json:"head,omitempty"`
+	LedgerRange       ledgerRange `json:"ledger_range"`
+	Sample            float64     `json:"sample"` // fraction of the token ranges scanned
+	UpdateLedgerRange bool        `json:"update_ledger_range"`
+	Created           time.Time   `json:"created"`
+}
+
+func (h planHeader) window() ledgerWindow {
+	return ledgerWindow{from: h.From, to: h.To, open: h.Open, head: h.Head}
+}
+
+var plan *planFile // nil unless --plan-file
+
+func openPlanFile(path string, header planHeader) (*planFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &planFile{file: f}
+	data, err := json.Marshal(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if isParquetPlan(path) {
+		p.parquet = parquet.NewGenericWriter[planRecord](f, parquet.KeyValueMetadata(planMetadataKey, string(data)), parquet.Compression(&parquet.Snappy))
+		return p, nil
+	}
+
+	var w io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		p.gz = gzip.NewWriter(f)
+		w = p.gz
+	}
+	fmt.Fprintf(w, "%s%s\n", planHeaderPrefix, data)
+	p.csv = csv.NewWriter(w)
+	p.csv.Write([]string{"table", "key", "sequence", "op"})
+	return p, nil
+}
+
+func (p *planFile) write(table string, info *deleteInfo) {
+	if p == nil {
+		return
+	}
+
+	op := deleteOp(table, info.Query)
+	keyed := queryTemplates[table].Scan != ""
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.parquet != nil {
+		records := make([]planRecord, len(info.Data))
+		for i, r := range info.Data {
+			records[i] = planRecord{Table: table, Sequence: r.Seq, Op: op}
+			if keyed {
+				records[i].Key = hex.EncodeToString(r.Blob)
+			}
+		}
+		if _, err := p.parquet.Write(records); err != nil && p.err == nil {
+			p.err = err
+		}
+		return
+	}
+
+	for _, r := range info.Data {
+		key := ""
+		if keyed {
+			key = hex.EncodeToString(r.Blob)
+		}
+		p.csv.Write([]string{table, key, strconv.FormatUint(r.Seq, 10), op})
+	}
+}
+
+func (p *planFile) close() error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.parquet != nil {
+		err := p.err
+		if closeErr := p.parquet.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := p.file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	p.csv.Flush()
+	if err := p.csv.Error(); err != nil {
+		return err
+	}
+	if p.gz != nil {
+		if err := p.gz.Close(); err != nil {
+			return err
+		}
+	}
+	return p.file.Close()
+}
+
+// readPlan reads the header of a plan file and passes every row of it to fn, stopping at the first error
+func readPlan(path string, fn func(table string, op string, row deleteParams) error) (planHeader, error) {
+	if isParquetPlan(path) {
+		return readParquetPlan(path, fn)
+	}
+
+	var header planHeader
+	f, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return header, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	br := bufio.NewReader(r)
+	found := false
+	for {
+		if b, err := br.Peek(1); err != nil || b[0] != '#' {
+			break
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return header, fmt.Errorf("%s: %w", path, err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), planHeaderPrefix); ok {
+			if err := json.Unmarshal([]byte(data), &header); err != nil {
+				return header, fmt.Errorf("%s: plan header: %w", path, err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return header, fmt.Errorf("%s has no plan header; write it with plan", path)
+	}
+
+	c := csv.NewReader(br)
+	c.FieldsPerRecord = 4
+	c.ReuseRecord = true
+	if columns, err := c.Read(); err != nil || strings.Join(columns, ",") != "table,key,sequence,op" {
+		return header, fmt.Errorf("%s: not a plan file", path)
+	}
+
+	for {
+		record, err := c.Read()
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return header, fmt.Errorf("%s: %w", path, err)
+		}
+
+		key, err := hex.DecodeString(record[1])
+		if err != nil {
+			return header, fmt.Errorf("%s: key %q: %w", path, record[1], err)
+		}
+		seq, err := strconv.ParseUint(record[2], 10, 64)
+		if err != nil {
+			return header, fmt.Errorf("%s: sequence %q: %w", path, record[2], err)
+		}
+		if err := fn(record[0], record[3], deleteParams{Seq: seq, Blob: key}); err != nil {
+			return header, err
+		}
+	}
+}
+
+func readParquetPlan(path string, fn func(table string, op string, row deleteParams) error) (planHeader, error) {
+	var header planHeader
+	f, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return header, err
+	}
+
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		return header, fmt.Errorf("%s: not a plan file: %w", path, err)
+	}
+
+	data, ok := pf.Lookup(planMetadataKey)
+	if !ok {
+		return header, fmt.Errorf("%s has no plan header; write it with plan", path)
+	}
+	if err := json.Unmarshal([]byte(data), &header); err != nil {
+		return header, fmt.Errorf("%s: plan header: %w", path, err)
+	}
+
+	r := parquet.NewGenericReader[planRecord](pf)
+	defer r.Close()
+
+	records := make([]planRecord, 1024)
+	for {
+		n, err := r.Read(records)
+		for _, record := range records[:n] {
+			key, keyErr := hex.DecodeString(record.Key)
+			if keyErr != nil {
+				return header, fmt.Errorf("%s: key %q: %w", path, record.Key, keyErr)
+			}
+			if err := fn(record.Table, record.Op, deleteParams{Seq: record.Sequence, Blob: key}); err != nil {
+				return header, err
+			}
+		}
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return header, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// planQuery is the delete query of the rows of a table a plan deletes with op
+func planQuery(table string, op string, window ledgerWindow) (string, error) {
+	def := queryTemplates[table]
+	switch {
+	case op == "=" && def.Delete != "":
+		return def.Delete, nil
+	case op == ">=" && def.RangeDelete != "":
+		return def.RangeDelete, nil
+	case op == "window" && def.WindowDelete != "":
+		to := window.to
+		if window.open {
+			to = math.MaxUint64
+		}
+		return windowDeleteQuery(def, window.from, to), nil
+	}
+	return "", fmt.Errorf("the plan deletes rows of %s with %s, which the table has no delete query for", table, op)
+}
+
+func runPlan() {
+	window := windowOfFlags(*planAfter, *planFrom, *planTo, *planKeepLatest)
+
+	*planFilePath = *planOut
+	estimating = &estimateRun{sample: 1}
+	prune(*planHosts, window)
+
+	log.Printf("Review the plan, then delete its rows with: apply <hosts> %s\n", *planOut)
+}
+
+// runApply deletes the rows of a plan. The plan must cover the whole ring and ledger_range must not have
+// moved since: its earliest ledger must be the same, and for deletes till latest its latest one too
+func runApply(cluster *gocql.ClusterConfig) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	applyOnline()
+
+	cluster.QueryObserver = usage
+	cluster.BatchObserver = usage
+	retry = &cass.RetryPolicy{Attempts: *retryAttempts, BaseDelay: *retryBaseDelay, MaxDelay: *retryMaxDelay, Jitter: *retryJitter}
+	deleteRate = cass.NewRateLimiter(*maxDeleteRate)
+
+	var err error
+	if budget, err = parseErrorBudget(*maxErrors); err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	loadTables(cluster)
+
+	// the rows are counted first, so that they are known to be deletable, and the tombstones of all tables
+	// checked, before any is deleted
+	counts := make(map[string]uint64)
+	tombstones := make(map[string]*tombstoneCount)
+	var tables []string
+	var total uint64
+	header, err := readPlan(*applyPlan, func(table string, _ string, row deleteParams) error {
+		def, ok := queryTemplates[table]
+		if !ok {
+			return fmt.Errorf("the plan deletes rows of %s, which is not pruned; give the --tables-file it was written with", table)
+		}
+		if counts[table] == 0 {
+			tables = append(tables, table)
+			tombstones[table] = &tombstoneCount{}
+		}
+		counts[table]++
+		total++
+		if def.Scan != "" {
+			tombstones[table].add(row.Blob)
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	if header.Sample < 1 {
+		fatalf(exitInvalid, "The plan covers %.1f%% of the token ranges only; write it with plan\n", header.Sample*100)
+	}
+	if header.Keyspace != *keyspace {
+		fatalf(exitInvalid, "The plan is of keyspace %s, not %s\n", header.Keyspace, *keyspace)
+	}
+
+	earliest, latest, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// an interrupted --online apply raised the earliest ledger past the window already
+	raised := *online && header.Head && earliest == header.To+1
+	if (earliest != header.LedgerRange.First && !raised) || (header.Open && latest != header.LedgerRange.Latest) {
+		fatalf(exitInvalid, "ledger_range moved from %d:%d to %d:%d since the plan was written; write it again with plan\n",
+			header.LedgerRange.First, header.LedgerRange.Latest, earliest, latest)
+	}
+
+	window := header.window()
+	checkOnlineWindow(window, latest)
+	checkFleet(window)
+	openMirror(earliest, latest)
+
+	log.Printf("Plan of ledgers %s, written %s against ledger_range %d:%d\n", window, header.Created.Format(time.RFC3339), header.LedgerRange.First, header.LedgerRange.Latest)
+	for _, table := range tables {
+		log.Printf("- %s: %d deletes\n", table, counts[table])
+	}
+	if !header.UpdateLedgerRange {
+		log.Println("ledger_range is left untouched, as the run the plan was written for would have")
+	}
+
+	var errs []error
+	for _, table := range tables {
+		if queryTemplates[table].Scan == "" {
+			continue
+		}
+		if err := tombstones[table].check(table); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	if !confirm() {
+		fatal(exitAborted, "Aborting...")
+	}
+
+	handleSignals()
+	startBackpressure(cluster)
+
+	guard, err := startGuard(cluster, window, latest)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *auditFile != "" {
+		if audit, err = openAuditLog(*auditFile); err != nil {
+			guard.release()
+			log.Fatal(err)
+		}
+	}
+
+	// its manifest names the command: the plan may have been written with --object-types, so it does not
+	// make any ledgers clean for --skip-cleaned
+	report := &runReport{
+		Command:    "apply",
+		Started:    time.Now().UTC(),
+		Hosts:      strings.Join(cluster.Hosts, ","),
+		Keyspace:   *keyspace,
+		FromLedger: window.from,
+		ToLedger:   window.to,
+		ToLatest:   window.open,
+
+		LedgerRangeBefore: ledgerRange{First: earliest, Latest: latest},
+		LedgerRangeAfter:  ledgerRange{First: earliest, Latest: latest},
+	}
+
+	if header.UpdateLedgerRange {
+		if err := raiseOnlineEarliestLedger(cluster, window, report); err != nil {
+			guard.release()
+			log.Fatal(err)
+		}
+	}
+
+	// the rows of a table are next to each other in the plan, they are deleted a table at a time
+	var current string
+	var info deleteInfo
+	var table *tableReport
+	var tableRows, tableDeletes, tableErrors uint64
+	flush := func() error {
+		if len(info.Data) == 0 {
+			return nil
+		}
+
+		def := queryTemplates[current]
+		deletes, failed := performDeleteQueries(cluster, current, &info, columnSettings{UseBlob: def.Scan != "", UseSeq: def.Scan == ""})
+		log.Printf("Deleted %d rows of %s, %d failed\n", deletes, current, failed)
+		tableRows += uint64(len(info.Data))
+		tableDeletes += deletes
+		tableErrors += failed
+		table.finish(tableRows, tableDeletes, tableErrors)
+		report.TotalRows += uint64(len(info.Data))
+		report.TotalDeletes += deletes
+		report.TotalErrors += failed
+		info = deleteInfo{}
+
+		if interrupted() {
+			return errInterrupted
+		}
+		return nil
+	}
+
+	_, err = readPlan(*applyPlan, func(name string, op string, row deleteParams) error {
+		query, err := planQuery(name, op, window)
+		if err != nil {
+			return err
+		}
+		if name != current || query != info.Query {
+			if err := flush(); err != nil {
+				return err
+			}
+			if name != current {
+				table = report.startTable(name)
+				tableRows, tableDeletes, tableErrors = 0, 0, 0
+			}
+			current = name
+			info.Query = query
+		}
+		info.Data = append(info.Data, row)
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && header.UpdateLedgerRange && !*skipWriteLatestLedger {
+		err = moveLedgerRange(cluster, window, report)
+	}
+	guard.release()
+
+	if err := audit.close(); err != nil {
+		log.Printf("ERROR failed writing audit log: %s\n", err)
+	}
+
+	report.Interrupted = errors.Is(err, errInterrupted)
+	if *reportFile != "" {
+		report.finish()
+		if err := report.write(*reportFile); err != nil {
+			log.Printf("ERROR failed writing report: %s\n", err)
+		} else {
+			log.Printf("Report written to %s\n", *reportFile)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errInterrupted):
+		logTotals(report.TotalErrors, total, report.TotalDeletes)
+		log.Println("Interrupted, ledger_range was not updated; apply the plan again to delete the rest")
+		os.Exit(exitAborted)
+	case err != nil:
+		log.Fatal(err)
+	}
+
+	manifest := newManifest(report, report.LedgerRangeBefore)
+	if err := writeManifest(cluster, manifest); err != nil {
+		log.Printf("ERROR failed writing manifest: %s\n", err)
+	}
+
+	logTotals(report.TotalErrors, total, report.TotalDeletes)
+	if report.TotalErrors > *allowedErrors {
+		fatalf(exitPartial, "WARNING: %d queries failed, more than the %d allowed; see the FAILED QUERY lines on stderr\n", report.TotalErrors, *allowedErrors)
+	}
+}
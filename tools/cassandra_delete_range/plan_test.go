@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type planRow struct {
+	table string
+	op    string
+	row   deleteParams
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	header := planHeader{Keyspace: "clio", From: 100, To: 150, Head: true, LedgerRange: ledgerRange{First: 100, Latest: 200}, Sample: 1}
+	key := bytes.Repeat([]byte{0xab}, 32)
+
+	want := []planRow{
+		{"objects", "=", deleteParams{Seq: 120, Blob: key}},
+		{"objects", ">=", deleteParams{Seq: 151, Blob: key}},
+		{"account_tx", "window", deleteParams{Seq: 140, Blob: key[:20]}},
+		{"ledgers", "=", deleteParams{Seq: 101, Blob: []byte{}}},
+	}
+
+	for _, name := range []string{"plan.csv", "plan.csv.gz", "plan.parquet"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		p, err := openPlanFile(path, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.write("objects", &deleteInfo{Query: queryTemplates["objects"].Delete, Data: []deleteParams{want[0].row}})
+		p.write("objects", &deleteInfo{Query: queryTemplates["objects"].RangeDelete, Data: []deleteParams{want[1].row}})
+		p.write("account_tx", &deleteInfo{Query: windowDeleteQuery(queryTemplates["account_tx"], 100, 150), Data: []deleteParams{want[2].row}})
+		p.write("ledgers", &deleteInfo{Query: queryTemplates["ledgers"].Delete, Data: []deleteParams{want[3].row}})
+		if err := p.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []planRow
+		read, err := readPlan(path, func(table string, op string, row deleteParams) error {
+			got = append(got, planRow{table, op, deleteParams{Seq: row.Seq, Blob: bytes.Clone(row.Blob)}})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if read.window() != header.window() || read.Keyspace != header.Keyspace || read.LedgerRange != header.LedgerRange {
+			t.Errorf("%s: header = %+v, want %+v", name, read, header)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: rows = %v, want %v", name, got, want)
+		}
+		for i := range got {
+			if got[i].table != want[i].table || got[i].op != want[i].op || got[i].row.Seq != want[i].row.Seq || !bytes.Equal(got[i].row.Blob, want[i].row.Blob) {
+				t.Errorf("%s: row %d = %v, want %v", name, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestReadPlanErrors(t *testing.T) {
+	header := "# plan {\"keyspace\":\"clio\",\"from\":100,\"to\":150}\n"
+	columns := "table,key,sequence,op\n"
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"no header", columns + "objects,ab,120,=\n", "no plan header"},
+		{"bad header", "# plan {\n" + columns, "plan header"},
+		{"no columns", header + "objects,ab,120,=\n", "not a plan file"},
+		{"bad key", header + columns + "objects,xy,120,=\n", "key"},
+		{"bad sequence", header + columns + "objects,ab,latest,=\n", "sequence"},
+		{"short row", header + columns + "objects,ab,120\n", "wrong number of fields"},
+		{"callback", header + columns + "objects,ab,120,=\n", "stop"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "plan.csv")
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := readPlan(path, func(string, string, deleteParams) error { return errors.New("stop") })
+		checkError(t, tt.name, err, tt.err)
+	}
+}
+
+func TestPlanQuery(t *testing.T) {
+	window := ledgerWindow{from: 100, to: 150}
+
+	tests := []struct {
+		table  string
+		op     string
+		window ledgerWindow
+		want   string
+		err    string
+	}{
+		{"objects", "=", window, queryTemplates["objects"].Delete, ""},
+		{"objects", ">=", window, queryTemplates["objects"].RangeDelete, ""},
+		{"transactions", "=", window, queryTemplates["transactions"].Delete, ""},
+		{"account_tx", "window", window, windowDeleteQuery(queryTemplates["account_tx"], 100, 150), ""},
+		{"account_tx", "window", ledgerWindow{from: 100, to: 150, open: true}, windowDeleteQuery(queryTemplates["account_tx"], 100, math.MaxUint64), ""},
+
+		{"transactions", ">=", window, "", "no delete query"},
+		{"account_tx", "=", window, "", "no delete query"},
+		{"objects", "window", window, "", "no delete query"},
+		{"unknown", "=", window, "", "no delete query"},
+	}
+
+	for _, tt := range tests {
+		name := tt.table + " " + tt.op
+		got, err := planQuery(tt.table, tt.op, tt.window)
+		checkError(t, name, err, tt.err)
+		if got != tt.want {
+			t.Errorf("%s: planQuery = %q, want %q", name, got, tt.want)
+		}
+	}
+
+	if q := windowDeleteQuery(queryTemplates["account_tx"], 100, math.MaxUint64); !strings.Contains(q, "<= (9223372036854775807, 9223372036854775807)") {
+		t.Errorf("windowDeleteQuery of an open window = %q, want the last sequence capped at the bigint maximum", q)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// clioColumns are the columns of the tables Clio creates, see src/data/cassandra/Schema.hpp
+var clioColumns = map[string][]string{
+	"objects":               {"key", "sequence", "object"},
+	"transactions":          {"hash", "ledger_sequence", "date", "transaction", "metadata"},
+	"ledger_transactions":   {"ledger_sequence", "hash"},
+	"successor":             {"key", "seq", "next"},
+	"diff":                  {"seq", "key"},
+	"account_tx":            {"account", "seq_idx", "hash"},
+	"ledgers":               {"sequence", "header"},
+	"ledger_hashes":         {"hash", "sequence"},
+	"ledger_range":          {"is_latest", "sequence"},
+	"nf_tokens":             {"token_id", "sequence", "owner", "is_burned"},
+	"nf_token_uris":         {"token_id", "sequence", "uri"},
+	"nf_token_transactions": {"token_id", "seq_idx", "hash"},
+}
+
+// preflight prints one line per check and remembers whether any failed
+type preflight struct {
+	failed bool
+}
+
+func (p *preflight) ok(format string, v ...any) {
+	fmt.Printf("[ OK ] %s\n", fmt.Sprintf(format, v...))
+}
+
+func (p *preflight) warn(format string, v ...any) {
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, v...))
+}
+
+func (p *preflight) fail(format string, v ...any) {
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, v...))
+	p.failed = true
+}
+
+// runPreflight checks that a prune of the keyspace can run: the nodes answer, the credentials may delete,
+// the tables are the ones of Clio and paging works
+func runPreflight(cluster *gocql.ClusterConfig) {
+	p := &preflight{}
+	fmt.Printf("\nPreflight of keyspace %s\n", *keyspace)
+	fmt.Println("=====================")
+
+	for _, host := range cluster.Hosts {
+		addr := host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			addr = net.JoinHostPort(host, strconv.Itoa(cluster.Port))
+		}
+		conn, err := net.DialTimeout("tcp", addr, cluster.ConnectTimeout)
+		if err != nil {
+			p.fail("contact point %s is not reachable: %s", addr, err)
+			continue
+		}
+		conn.Close()
+		p.ok("contact point %s is reachable", addr)
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		p.fail("no session: %s", err)
+		os.Exit(exitConnection)
+	}
+
+	defer session.Close()
+	p.ok("connected to keyspace %s", *keyspace)
+
+	existing, err := keyspaceColumns(session)
+	if err != nil {
+		p.fail("could not read the schema: %s", err)
+	}
+	checkSchema(p, existing)
+	checkPermissions(p, session, existing)
+	checkPaging(p, session)
+
+	if p.failed {
+		fmt.Println("\nFAILED: fix the checks above before pruning")
+		os.Exit(exitFailed)
+	}
+	fmt.Println("\nPASSED: the keyspace is ready to be pruned")
+}
+
+// keyspaceColumns reads the columns of every table of the keyspace
+func keyspaceColumns(session *gocql.Session) (map[string][]string, error) {
+	columns := make(map[string][]string)
+	var table, column string
+	iter := session.Query("SELECT table_name, column_name FROM system_schema.columns WHERE keyspace_name = ?", *keyspace).Iter()
+	for iter.Scan(&table, &column) {
+		columns[table] = append(columns[table], column)
+	}
+	return columns, iter.Close()
+}
+
+// clioTables lists the pruned tables and ledger_range, which are the tables of Clio; the ones of
+// --tables-file are not checked
+func clioTables() []string {
+	return append(slices.Clone(tableNames), "ledger_range")
+}
+
+func checkSchema(p *preflight, existing map[string][]string) {
+	for _, table := range clioTables() {
+		columns, ok := existing[table]
+		if !ok {
+			if table == "ledger_range" || table == "ledgers" || table == "objects" {
+				p.fail("table %s is missing", table)
+			} else {
+				p.warn("table %s is missing, it is not pruned", table)
+			}
+			continue
+		}
+
+		var missing []string
+		for _, c := range clioColumns[table] {
+			if !slices.Contains(columns, c) {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) > 0 {
+			p.fail("table %s lacks the columns %s of the Clio layout", table, strings.Join(missing, ", "))
+		} else {
+			p.ok("table %s has the Clio layout", table)
+		}
+	}
+}
+
+// checkPermissions looks for MODIFY on every pruned table, granted on the table, the keyspace or all
+// keyspaces; without authentication everyone may modify
+func checkPermissions(p *preflight, session *gocql.Session, existing map[string][]string) {
+	username, _ := loadCredentials()
+	if username == "" {
+		p.warn("no credentials given, MODIFY permissions are not checked")
+		return
+	}
+
+	granted := make(map[string]bool)
+	var resource, permission string
+	iter := session.Query(fmt.Sprintf(`LIST MODIFY PERMISSION OF "%s"`, strings.ReplaceAll(username, `"`, `""`))).Iter()
+	for iter.Scan(nil, nil, &resource, &permission) {
+		granted[resource] = true
+	}
+	if err := iter.Close(); err != nil {
+		var superuser bool
+		if err := session.Query("SELECT is_superuser FROM system_auth.roles WHERE role = ?", username).Scan(&superuser); err == nil && superuser {
+			p.ok("%s is a superuser and may modify every table", username)
+			return
+		}
+		p.warn("could not list the permissions of %s: %s", username, err)
+		return
+	}
+
+	for _, table := range clioTables() {
+		if _, ok := existing[table]; !ok && len(existing) > 0 {
+			continue
+		}
+		if granted["<all keyspaces>"] || granted[fmt.Sprintf("<keyspace %s>", *keyspace)] || granted[fmt.Sprintf("<table %s.%s>", *keyspace, table)] {
+			p.ok("%s may modify %s", username, table)
+		} else {
+			p.fail("%s lacks MODIFY permission on %s", username, table)
+		}
+	}
+}
+
+// checkPaging reads the ledgers table a few rows per page, which fails with drivers or proxies that
+// mishandle paging states
+func checkPaging(p *preflight, session *gocql.Session) {
+	const pageSize, rowsRead = 2, 5
+
+	var seq uint64
+	var rows int
+	var pages int
+	var pageState []byte
+	for rows < rowsRead {
+		iter := session.Query("SELECT sequence FROM ledgers").PageSize(pageSize).PageState(pageState).Iter()
+		for iter.Scan(&seq) {
+			rows++
+		}
+		pageState = iter.PageState()
+		if err := iter.Close(); err != nil {
+			p.fail("paging through the ledgers table failed: %s", err)
+			return
+		}
+		pages++
+		if len(pageState) == 0 {
+			break
+		}
+	}
+
+	if rows > pageSize && pages < 2 {
+		p.fail("%d rows of the ledgers table came in one page of %d", rows, pageSize)
+		return
+	}
+	p.ok("paging works (%d rows in pages of %d)", rows, pageSize)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// progress logs how far a phase of a table got every --progress-interval, with an ETA extrapolated from
+// the steps done so far
+type progress struct {
+	table     string
+	phase     string
+	stepUnit  string // what the total is counted in, i.e. token ranges
+	itemUnit  string // what the rate is counted in, i.e. rows
+	total     uint64
+	steps     uint64
+	items     uint64
+	errors    uint64
+	started   time.Time
+	done      chan struct{}
+	completed chan struct{}
+}
+
+func startProgress(table string, phase string, total uint64, stepUnit string, itemUnit string) *progress {
+	p := &progress{
+		table:     table,
+		phase:     phase,
+		stepUnit:  stepUnit,
+		itemUnit:  itemUnit,
+		total:     total,
+		started:   time.Now(),
+		done:      make(chan struct{}),
+		completed: make(chan struct{}),
+	}
+
+	dashboard.add(p)
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer close(p.completed)
+	if *progressInterval <= 0 {
+		<-p.done
+		return
+	}
+
+	ticker := time.NewTicker(*progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.log()
+		}
+	}
+}
+
+func (p *progress) log() {
+	steps := atomic.LoadUint64(&p.steps)
+	items := atomic.LoadUint64(&p.items)
+	elapsed := time.Since(p.started)
+
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(steps) * 100 / float64(p.total)
+	}
+
+	eta := "unknown"
+	if steps > 0 && steps <= p.total {
+		eta = (time.Duration(float64(elapsed) * float64(p.total-steps) / float64(steps))).Round(time.Second).String()
+	}
+
+	log.Printf("PROGRESS %s %s: %.1f%% (%d/%d %s), %d %s at %.0f/s, ETA %s\n",
+		p.table, p.phase, percent, steps, p.total, p.stepUnit, items, p.itemUnit, float64(items)/elapsed.Seconds(), eta)
+}
+
+func (p *progress) addItems(n uint64) {
+	atomic.AddUint64(&p.items, n)
+}
+
+func (p *progress) addErrors(n uint64) {
+	atomic.AddUint64(&p.errors, n)
+}
+
+func (p *progress) addSteps(n uint64) {
+	atomic.AddUint64(&p.steps, n)
+}
+
+func (p *progress) stop() {
+	close(p.done)
+	<-p.completed
+}
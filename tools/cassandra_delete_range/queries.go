@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/yaml.v3"
+)
+
+// queryTemplate defines how a table is pruned; tables of --tables-file are defined the same way
+type queryTemplate struct {
+	Name   string `yaml:"name"` // only used in --tables-file
+	Scan   string `yaml:"scan"`
+	Delete string `yaml:"delete"`
+
+	// versioned tables hold the changes of a key per ledger, so in head windows they keep the newest
+	// version of every key for the ledger after the window to have its full state
+	Versioned bool `yaml:"versioned"`
+
+	// scan of the ledger sequences of tables keyed by ledger, used by verify and stats
+	Partitions string `yaml:"partitions"`
+
+	// scan that also selects the object blob, used to prune by object type, or the next key of successor rows
+	TypedScan string `yaml:"typed_scan"`
+
+	// delete of every version of a key from a ledger on, used when deleting till latest
+	RangeDelete string `yaml:"range_delete"`
+
+	// tables whose rows are clustered by a (sequence, index) tuple, i.e. the transactions of an account, are
+	// scanned for the newest row of every partition only and pruned with one delete of the window per
+	// partition that has rows in it; it is formatted with the first and last sequence and the highest index
+	WindowDelete string `yaml:"-"`
+
+	// query for the newest row of a partition of a windowed table in the window, used when the newest row
+	// of the partition is past the window; binds the key and is formatted like WindowDelete
+	WindowProbe string `yaml:"-"`
+
+	// query for any row of a token range in a window of ledgers, used to skip the token ranges an earlier
+	// run pruned already; binds the token range, then the first and last sequence
+	Probe string `yaml:"probe"`
+}
+
+// the queries run for every table; tables with a scan query are traversed by token range, the others are
+// deleted ledger by ledger
+var queryTemplates = map[string]queryTemplate{
+	"successor": {
+		Scan:        "SELECT key, seq FROM successor WHERE token(key) >= ? AND token(key) <= ?",
+		Delete:      "DELETE FROM successor WHERE key = ? AND seq = ?",
+		TypedScan:   "SELECT key, seq, next FROM successor WHERE token(key) >= ? AND token(key) <= ?",
+		RangeDelete: "DELETE FROM successor WHERE key = ? AND seq >= ?",
+		Probe:       "SELECT seq FROM successor WHERE token(key) >= ? AND token(key) <= ? AND seq >= ? AND seq <= ? LIMIT 1 ALLOW FILTERING",
+		Versioned:   true,
+	},
+	"objects": {
+		Scan:        "SELECT key, sequence FROM objects WHERE token(key) >= ? AND token(key) <= ?",
+		Delete:      "DELETE FROM objects WHERE key = ? AND sequence = ?",
+		TypedScan:   "SELECT key, sequence, object FROM objects WHERE token(key) >= ? AND token(key) <= ?",
+		RangeDelete: "DELETE FROM objects WHERE key = ? AND sequence >= ?",
+		Probe:       "SELECT sequence FROM objects WHERE token(key) >= ? AND token(key) <= ? AND sequence >= ? AND sequence <= ? LIMIT 1 ALLOW FILTERING",
+		Versioned:   true,
+	},
+	"nf_tokens": {
+		Scan:        "SELECT token_id, sequence FROM nf_tokens WHERE token(token_id) >= ? AND token(token_id) <= ?",
+		Delete:      "DELETE FROM nf_tokens WHERE token_id = ? AND sequence = ?",
+		RangeDelete: "DELETE FROM nf_tokens WHERE token_id = ? AND sequence >= ?",
+		Probe:       "SELECT sequence FROM nf_tokens WHERE token(token_id) >= ? AND token(token_id) <= ? AND sequence >= ? AND sequence <= ? LIMIT 1 ALLOW FILTERING",
+		Versioned:   true,
+	},
+	"nf_token_uris": {
+		Scan:        "SELECT token_id, sequence FROM nf_token_uris WHERE token(token_id) >= ? AND token(token_id) <= ?",
+		Delete:      "DELETE FROM nf_token_uris WHERE token_id = ? AND sequence = ?",
+		RangeDelete: "DELETE FROM nf_token_uris WHERE token_id = ? AND sequence >= ?",
+		Probe:       "SELECT sequence FROM nf_token_uris WHERE token(token_id) >= ? AND token(token_id) <= ? AND sequence >= ? AND sequence <= ? LIMIT 1 ALLOW FILTERING",
+		Versioned:   true,
+	},
+	"account_tx": {
+		Scan:         "SELECT account, seq_idx FROM account_tx WHERE token(account) >= ? AND token(account) <= ? PER PARTITION LIMIT 1",
+		WindowDelete: "DELETE FROM account_tx WHERE account = ? AND seq_idx >= (%d, 0) AND seq_idx <= (%d, %d)",
+		WindowProbe:  "SELECT seq_idx FROM account_tx WHERE account = ? AND seq_idx >= (%d, 0) AND seq_idx <= (%d, %d) LIMIT 1",
+	},
+	"nf_token_transactions": {
+		Scan:         "SELECT token_id, seq_idx FROM nf_token_transactions WHERE token(token_id) >= ? AND token(token_id) <= ? PER PARTITION LIMIT 1",
+		WindowDelete: "DELETE FROM nf_token_transactions WHERE token_id = ? AND seq_idx >= (%d, 0) AND seq_idx <= (%d, %d)",
+		WindowProbe:  "SELECT seq_idx FROM nf_token_transactions WHERE token_id = ? AND seq_idx >= (%d, 0) AND seq_idx <= (%d, %d) LIMIT 1",
+	},
+	"ledger_hashes": {
+		Scan:   "SELECT hash, sequence FROM ledger_hashes WHERE token(hash) >= ? AND token(hash) <= ?",
+		Delete: "DELETE FROM ledger_hashes WHERE hash = ?",
+		Probe:  "SELECT sequence FROM ledger_hashes WHERE token(hash) >= ? AND token(hash) <= ? AND sequence >= ? AND sequence <= ? LIMIT 1 ALLOW FILTERING",
+	},
+	"transactions": {
+		Scan:   "SELECT hash, ledger_sequence FROM transactions WHERE token(hash) >= ? AND token(hash) <= ?",
+		Delete: "DELETE FROM transactions WHERE hash = ?",
+		Probe:  "SELECT ledger_sequence FROM transactions WHERE token(hash) >= ? AND token(hash) <= ? AND ledger_sequence >= ? AND ledger_sequence <= ? LIMIT 1 ALLOW FILTERING",
+	},
+	"diff": {
+		Delete:     "DELETE FROM diff WHERE seq = ?",
+		Partitions: "SELECT DISTINCT seq FROM diff WHERE token(seq) >= ? AND token(seq) <= ?",
+	},
+	"ledger_transactions": {
+		Delete:     "DELETE FROM ledger_transactions WHERE ledger_sequence = ?",
+		Partitions: "SELECT DISTINCT ledger_sequence FROM ledger_transactions WHERE token(ledger_sequence) >= ? AND token(ledger_sequence) <= ?",
+	},
+	"ledgers": {
+		Delete:     "DELETE FROM ledgers WHERE sequence = ?",
+		Partitions: "SELECT sequence FROM ledgers WHERE token(sequence) >= ? AND token(sequence) <= ?",
+	},
+}
+
+var selectColumnsRegex = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s`)
+
+func validateScan(query string, columns int, description string) error {
+	m := selectColumnsRegex.FindStringSubmatch(query)
+	if m == nil {
+		return fmt.Errorf("must be a SELECT")
+	}
+	if n := len(strings.Split(m[1], ",")); n != columns {
+		return fmt.Errorf("must select exactly %d columns (%s), got %d", columns, description, n)
+	}
+	if n := strings.Count(query, "?"); n != 2 {
+		return fmt.Errorf("must have 2 bind markers (token range start and end), got %d", n)
+	}
+	return nil
+}
+
+// deleteOp tells how the delete query of a table selects rows, for the audit log and the plan file: = for
+// one version, >= for every version from the sequence on and window for the versions of the window
+func deleteOp(table string, query string) string {
+	switch {
+	case query == queryTemplates[table].RangeDelete, query == nftDeleteQuery(table):
+		return ">="
+	case queryTemplates[table].WindowDelete != "":
+		return "window"
+	}
+	return "="
+}
+
+// windowDeleteQuery is the delete query of the versions of the ledgers from, to of a partition
+func windowDeleteQuery(def queryTemplate, from uint64, to uint64) string {
+	return fmt.Sprintf(def.WindowDelete, from, min(to, math.MaxInt64), int64(math.MaxInt64))
+}
+
+// windowProbeQuery is the query for the newest row of a partition in the ledgers from, to
+func windowProbeQuery(def queryTemplate, from uint64, to uint64) string {
+	return fmt.Sprintf(def.WindowProbe, from, min(to, math.MaxInt64), int64(math.MaxInt64))
+}
+
+func validateProbe(query string) error {
+	if m := selectColumnsRegex.FindStringSubmatch(query); m == nil {
+		return fmt.Errorf("must be a SELECT")
+	}
+	if n := strings.Count(query, "?"); n != 4 {
+		return fmt.Errorf("must have 4 bind markers (token range start and end, first and last sequence), got %d", n)
+	}
+	return nil
+}
+
+// validateOverride makes sure a replacement query binds and returns the same values as the one it replaces
+func validateOverride(table string, def queryTemplate, override queryTemplate) error {
+	if override.Scan != "" {
+		if def.Scan == "" {
+			return fmt.Errorf("%s: the table is not scanned, only its delete query can be overridden", table)
+		}
+		if err := validateScan(override.Scan, 2, "the key blob and the ledger sequence"); err != nil {
+			return fmt.Errorf("%s: scan query %w", table, err)
+		}
+	}
+
+	if override.TypedScan != "" {
+		if def.TypedScan == "" {
+			return fmt.Errorf("%s: the table has no typed scan", table)
+		}
+		if err := validateScan(override.TypedScan, 3, "the key blob, the ledger sequence and the object blob"); err != nil {
+			return fmt.Errorf("%s: typed_scan query %w", table, err)
+		}
+	}
+
+	if override.Delete != "" {
+		if def.WindowDelete != "" {
+			return fmt.Errorf("%s: the table is pruned with a delete of the window per partition, only its scan can be overridden", table)
+		}
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(override.Delete)), "DELETE") {
+			return fmt.Errorf("%s: delete query must be a DELETE", table)
+		}
+		if n, want := strings.Count(override.Delete, "?"), strings.Count(def.Delete, "?"); n != want {
+			return fmt.Errorf("%s: delete query must have %d bind markers like %q, got %d", table, want, def.Delete, n)
+		}
+	}
+
+	if override.Probe != "" {
+		if def.Scan == "" {
+			return fmt.Errorf("%s: the table is not scanned, it has no probe", table)
+		}
+		if err := validateProbe(override.Probe); err != nil {
+			return fmt.Errorf("%s: probe query %w", table, err)
+		}
+	}
+
+	if override.RangeDelete != "" {
+		if def.RangeDelete == "" {
+			return fmt.Errorf("%s: the table is not pruned with range deletes", table)
+		}
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(override.RangeDelete)), "DELETE") {
+			return fmt.Errorf("%s: range_delete query must be a DELETE", table)
+		}
+		if n := strings.Count(override.RangeDelete, "?"); n != 2 {
+			return fmt.Errorf("%s: range_delete query must have 2 bind markers (the key blob and the first sequence), got %d", table, n)
+		}
+	}
+
+	return nil
+}
+
+// loadQueryOverrides replaces the default queries with the ones of a YAML (or JSON) file:
+//
+//	objects:
+//	  scan: SELECT key, sequence FROM my_keyspace.objects WHERE token(key) >= ? AND token(key) <= ?
+//	  delete: DELETE FROM my_keyspace.objects WHERE key = ? AND sequence = ?
+//
+// Bind markers are bound in the order of the default queries: the key blob first, then the sequence.
+// Returns the names of the tables whose queries changed.
+func loadQueryOverrides(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]queryTemplate
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var tables []string
+	for table, override := range overrides {
+		def, ok := queryTemplates[table]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown table %q", path, table)
+		}
+
+		if err := validateOverride(table, def, override); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if override.Scan != "" {
+			def.Scan = strings.TrimSpace(override.Scan)
+			// the probe of the default schema does not fit a replaced scan
+			def.Probe = ""
+		}
+		if override.Delete != "" {
+			def.Delete = strings.TrimSpace(override.Delete)
+		}
+		if override.TypedScan != "" {
+			def.TypedScan = strings.TrimSpace(override.TypedScan)
+		}
+		if override.RangeDelete != "" {
+			def.RangeDelete = strings.TrimSpace(override.RangeDelete)
+		}
+		if override.Probe != "" {
+			def.Probe = strings.TrimSpace(override.Probe)
+		}
+		queryTemplates[table] = def
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// loadTableDefinitions adds the tables of a YAML (or JSON) file to the ones pruned, after the built-in ones:
+//
+//   - name: mpt_holders
+//     scan: SELECT mpt_id, ledger_sequence FROM mpt_holders WHERE token(mpt_id) >= ? AND token(mpt_id) <= ?
+//     delete: DELETE FROM mpt_holders WHERE mpt_id = ? AND ledger_sequence = ?
+//   - name: my_ledger_stats
+//     delete: DELETE FROM my_ledger_stats WHERE seq = ?
+//
+// Scanned tables select the key blob and the ledger sequence and delete by key, or by key and sequence;
+// the others are deleted ledger by ledger. Returns the names of the tables added.
+func loadTableDefinitions(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var defs []queryTemplate
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var tables []string
+	for _, def := range defs {
+		if err := validateDefinition(def); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		def.Scan = strings.TrimSpace(def.Scan)
+		def.Delete = strings.TrimSpace(def.Delete)
+		def.Partitions = strings.TrimSpace(def.Partitions)
+		queryTemplates[def.Name] = def
+		tableNames = append(tableNames, def.Name)
+		tables = append(tables, def.Name)
+	}
+
+	return tables, nil
+}
+
+func validateDefinition(def queryTemplate) error {
+	if def.Name == "" {
+		return fmt.Errorf("every table needs a name")
+	}
+	if _, ok := queryTemplates[def.Name]; ok {
+		return fmt.Errorf("%s: the table is defined already, use --query-overrides to change its queries", def.Name)
+	}
+	if def.TypedScan != "" || def.RangeDelete != "" {
+		return fmt.Errorf("%s: typed_scan and range_delete are only supported for built-in tables", def.Name)
+	}
+
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(def.Delete)), "DELETE") {
+		return fmt.Errorf("%s: delete query must be a DELETE", def.Name)
+	}
+
+	binds := strings.Count(def.Delete, "?")
+	if def.Scan == "" {
+		if def.Versioned {
+			return fmt.Errorf("%s: versioned tables must be scanned", def.Name)
+		}
+		if binds != 1 {
+			return fmt.Errorf("%s: delete query of a table deleted ledger by ledger must have 1 bind marker (the sequence), got %d", def.Name, binds)
+		}
+	} else {
+		if err := validateScan(def.Scan, 2, "the key blob and the ledger sequence"); err != nil {
+			return fmt.Errorf("%s: scan query %w", def.Name, err)
+		}
+		if binds != 1 && binds != 2 {
+			return fmt.Errorf("%s: delete query of a scanned table must have 1 or 2 bind markers (the key blob, then the sequence), got %d", def.Name, binds)
+		}
+	}
+
+	if def.Partitions != "" {
+		if err := validateScan(def.Partitions, 1, "the ledger sequence"); err != nil {
+			return fmt.Errorf("%s: partitions query %w", def.Name, err)
+		}
+	}
+
+	if def.Probe != "" {
+		if def.Scan == "" {
+			return fmt.Errorf("%s: only scanned tables are probed", def.Name)
+		}
+		if err := validateProbe(def.Probe); err != nil {
+			return fmt.Errorf("%s: probe query %w", def.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadTables applies --tables-file and --query-overrides, leaves out the tables the keyspace does not have
+// and returns the names of the tables whose queries were overridden
+func loadTables(cluster *gocql.ClusterConfig) []string {
+	if *tablesFile != "" {
+		tables, err := loadTableDefinitions(*tablesFile)
+		if err != nil {
+			fatalf(exitInvalid, "Invalid table definitions: %s", err)
+		}
+		log.Printf("Pruning additional tables of %s: %s\n", *tablesFile, strings.Join(tables, ", "))
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+	dropMissingTables(session)
+	session.Close()
+
+	overridden := []string{"none"}
+	if *queryOverrides != "" {
+		tables, err := loadQueryOverrides(*queryOverrides)
+		if err != nil {
+			fatalf(exitInvalid, "Invalid query overrides: %s", err)
+		}
+		overridden = tables
+	}
+	return overridden
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    string
+		override queryTemplate
+		err      string // part of the error, empty if the override is valid
+	}{
+		{"scan", "objects", queryTemplate{Scan: "SELECT key, sequence FROM ks.objects WHERE token(key) >= ? AND token(key) <= ?"}, ""},
+		{"delete", "objects", queryTemplate{Delete: "delete from ks.objects where key = ? and sequence = ?"}, ""},
+		{"typed scan", "objects", queryTemplate{TypedScan: "SELECT key, sequence, object FROM ks.objects WHERE token(key) >= ? AND token(key) <= ?"}, ""},
+		{"range delete", "successor", queryTemplate{RangeDelete: "DELETE FROM ks.successor WHERE key = ? AND seq >= ?"}, ""},
+		{"probe", "transactions", queryTemplate{Probe: "SELECT ledger_sequence FROM ks.transactions WHERE token(hash) >= ? AND token(hash) <= ? AND ledger_sequence >= ? AND ledger_sequence <= ? LIMIT 1 ALLOW FILTERING"}, ""},
+		{"windowed scan", "account_tx", queryTemplate{Scan: "SELECT account, seq_idx FROM ks.account_tx WHERE token(account) >= ? AND token(account) <= ? PER PARTITION LIMIT 1"}, ""},
+
+		{"scan columns", "objects", queryTemplate{Scan: "SELECT key FROM objects WHERE token(key) >= ? AND token(key) <= ?"}, "exactly 2 columns"},
+		{"scan binds", "objects", queryTemplate{Scan: "SELECT key, sequence FROM objects WHERE token(key) >= ?"}, "2 bind markers"},
+		{"scan select", "objects", queryTemplate{Scan: "DELETE FROM objects WHERE key = ?"}, "must be a SELECT"},
+		{"scan of unscanned table", "ledgers", queryTemplate{Scan: "SELECT sequence, sequence FROM ledgers WHERE token(sequence) >= ? AND token(sequence) <= ?"}, "not scanned"},
+		{"typed scan columns", "objects", queryTemplate{TypedScan: "SELECT key, sequence FROM objects WHERE token(key) >= ? AND token(key) <= ?"}, "exactly 3 columns"},
+		{"typed scan of untyped table", "transactions", queryTemplate{TypedScan: "SELECT hash, ledger_sequence, tx FROM transactions WHERE token(hash) >= ? AND token(hash) <= ?"}, "no typed scan"},
+		{"delete binds", "objects", queryTemplate{Delete: "DELETE FROM objects WHERE key = ?"}, "2 bind markers"},
+		{"delete of a select", "transactions", queryTemplate{Delete: "SELECT hash FROM transactions WHERE hash = ?"}, "must be a DELETE"},
+		{"delete of windowed table", "account_tx", queryTemplate{Delete: "DELETE FROM account_tx WHERE account = ?"}, "only its scan"},
+		{"range delete binds", "objects", queryTemplate{RangeDelete: "DELETE FROM objects WHERE key = ?"}, "2 bind markers"},
+		{"range delete of unversioned table", "transactions", queryTemplate{RangeDelete: "DELETE FROM transactions WHERE hash = ? AND ledger_sequence >= ?"}, "not pruned with range deletes"},
+		{"probe binds", "objects", queryTemplate{Probe: "SELECT sequence FROM objects WHERE token(key) >= ? AND token(key) <= ? LIMIT 1"}, "4 bind markers"},
+		{"probe of unscanned table", "diff", queryTemplate{Probe: "SELECT seq FROM diff WHERE token(seq) >= ? AND token(seq) <= ? AND seq >= ? AND seq <= ?"}, "no probe"},
+	}
+
+	for _, tt := range tests {
+		checkError(t, tt.name, validateOverride(tt.table, queryTemplates[tt.table], tt.override), tt.err)
+	}
+}
+
+func TestValidateDefinition(t *testing.T) {
+	tests := []struct {
+		name string
+		def  queryTemplate
+		err  string
+	}{
+		{"scanned", queryTemplate{
+			Name:   "mpt_holders",
+			Scan:   "SELECT mpt_id, ledger_sequence FROM mpt_holders WHERE token(mpt_id) >= ? AND token(mpt_id) <= ?",
+			Delete: "DELETE FROM mpt_holders WHERE mpt_id = ? AND ledger_sequence = ?",
+		}, ""},
+		{"scanned by key", queryTemplate{
+			Name:   "dids",
+			Scan:   "SELECT account, ledger_sequence FROM dids WHERE token(account) >= ? AND token(account) <= ?",
+			Delete: "DELETE FROM dids WHERE account = ?",
+		}, ""},
+		{"versioned", queryTemplate{
+			Name:      "oracles",
+			Scan:      "SELECT key, sequence FROM oracles WHERE token(key) >= ? AND token(key) <= ?",
+			Delete:    "DELETE FROM oracles WHERE key = ? AND sequence = ?",
+			Versioned: true,
+			Probe:     "SELECT sequence FROM oracles WHERE token(key) >= ? AND token(key) <= ? AND sequence >= ? AND sequence <= ? LIMIT 1 ALLOW FILTERING",
+		}, ""},
+		{"per ledger", queryTemplate{
+			Name:       "my_ledger_stats",
+			Delete:     "DELETE FROM my_ledger_stats WHERE seq = ?",
+			Partitions: "SELECT DISTINCT seq FROM my_ledger_stats WHERE token(seq) >= ? AND token(seq) <= ?",
+		}, ""},
+
+		{"no name", queryTemplate{Delete: "DELETE FROM t WHERE seq = ?"}, "needs a name"},
+		{"built-in", queryTemplate{Name: "objects", Delete: "DELETE FROM objects WHERE key = ? AND sequence = ?"}, "defined already"},
+		{"typed scan", queryTemplate{
+			Name:      "t",
+			Scan:      "SELECT key, seq FROM t WHERE token(key) >= ? AND token(key) <= ?",
+			TypedScan: "SELECT key, seq, blob FROM t WHERE token(key) >= ? AND token(key) <= ?",
+			Delete:    "DELETE FROM t WHERE key = ? AND seq = ?",
+		}, "only supported for built-in tables"},
+		{"no delete", queryTemplate{Name: "t"}, "must be a DELETE"},
+		{"versioned per ledger", queryTemplate{Name: "t", Delete: "DELETE FROM t WHERE seq = ?", Versioned: true}, "must be scanned"},
+		{"per ledger binds", queryTemplate{Name: "t", Delete: "DELETE FROM t WHERE key = ? AND seq = ?"}, "1 bind marker"},
+		{"scanned binds", queryTemplate{
+			Name:   "t",
+			Scan:   "SELECT key, seq FROM t WHERE token(key) >= ? AND token(key) <= ?",
+			Delete: "DELETE FROM t WHERE key = ? AND seq = ? AND other = ?",
+		}, "1 or 2 bind markers"},
+		{"scan columns", queryTemplate{
+			Name:   "t",
+			Scan:   "SELECT key, seq, blob FROM t WHERE token(key) >= ? AND token(key) <= ?",
+			Delete: "DELETE FROM t WHERE key = ?",
+		}, "exactly 2 columns"},
+		{"partitions columns", queryTemplate{
+			Name:       "t",
+			Delete:     "DELETE FROM t WHERE seq = ?",
+			Partitions: "SELECT seq, other FROM t WHERE token(seq) >= ? AND token(seq) <= ?",
+		}, "exactly 1 columns"},
+		{"probe of unscanned table", queryTemplate{
+			Name:   "t",
+			Delete: "DELETE FROM t WHERE seq = ?",
+			Probe:  "SELECT seq FROM t WHERE token(seq) >= ? AND token(seq) <= ? AND seq >= ? AND seq <= ?",
+		}, "only scanned tables"},
+	}
+
+	for _, tt := range tests {
+		checkError(t, tt.name, validateDefinition(tt.def), tt.err)
+	}
+}
+
+func checkError(t *testing.T, name string, err error, want string) {
+	t.Helper()
+
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("%s: unexpected error %s", name, err)
+	case want != "" && err == nil:
+		t.Errorf("%s: no error, want one containing %q", name, want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("%s: error %q does not contain %q", name, err, want)
+	}
+}
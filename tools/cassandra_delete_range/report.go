@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+)
+
+type hostUsage struct {
+	Host          string  `json:"host"`
+	Queries       uint64  `json:"queries"`
+	Reads         uint64  `json:"reads"`
+	Writes        uint64  `json:"writes"`
+	Retries       uint64  `json:"retries"`
+	Errors        uint64  `json:"errors"`
+	RowsReturned  uint64  `json:"rows_returned"`
+	MeanLatencyMS float64 `json:"mean_latency_ms"`
+
+	latency time.Duration
+}
+
+// resourceUsage is the query observer of every session; it accounts the work the run caused per host
+type resourceUsage struct {
+	readBytes uint64
+	queries   uint64
+	writes    uint64
+
+	mu           sync.Mutex
+	hosts        map[string]*hostUsage
+	errorClasses map[string]uint64
+}
+
+var usage = &resourceUsage{hosts: make(map[string]*hostUsage), errorClasses: make(map[string]uint64)}
+
+// addRead accounts the size of the columns of a scanned row; gocql does not expose frame sizes
+func (u *resourceUsage) addRead(bytes int) {
+	atomic.AddUint64(&u.readBytes, uint64(bytes))
+}
+
+func (u *resourceUsage) ObserveQuery(_ context.Context, q gocql.ObservedQuery) {
+	observeQueryLatency(q)
+
+	write := !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(q.Statement)), "SELECT")
+
+	atomic.AddUint64(&u.queries, 1)
+	if write {
+		atomic.AddUint64(&u.writes, 1)
+	}
+
+	host := "unknown"
+	if q.Host != nil {
+		host = q.Host.ConnectAddress().String()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	h, ok := u.hosts[host]
+	if !ok {
+		h = &hostUsage{Host: host}
+		u.hosts[host] = h
+	}
+
+	h.Queries++
+	h.RowsReturned += uint64(q.Rows)
+	h.latency += q.End.Sub(q.Start)
+	if write {
+		h.Writes++
+	} else {
+		h.Reads++
+	}
+	if q.Attempt > 0 {
+		h.Retries++
+	}
+	if q.Err != nil {
+		h.Errors++
+		u.errorClasses[errorClass(q.Err)]++
+	}
+}
+
+// ObserveBatch accounts an unlogged batch of deletes as the single round trip it is
+func (u *resourceUsage) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	if len(b.Statements) == 0 {
+		return
+	}
+
+	u.ObserveQuery(ctx, gocql.ObservedQuery{
+		Keyspace:  b.Keyspace,
+		Statement: b.Statements[0],
+		Start:     b.Start,
+		End:       b.End,
+		Host:      b.Host,
+		Err:       b.Err,
+		Attempt:   b.Attempt,
+	})
+}
+
+func (u *resourceUsage) perHost() []hostUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var hosts []hostUsage
+	for _, h := range u.hosts {
+		c := *h
+		if c.Queries > 0 {
+			c.MeanLatencyMS = float64(c.latency) / float64(c.Queries) / float64(time.Millisecond)
+		}
+		hosts = append(hosts, c)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+type tableReport struct {
+	Table         string    `json:"table"`
+	Started       time.Time `json:"started"`
+	DurationSec   float64   `json:"duration_s"`
+	RowsTraversed uint64    `json:"rows_traversed"`
+	Deletes       uint64    `json:"deletes"`
+	Errors        uint64    `json:"errors"`
+	ReadBytes     uint64    `json:"read_bytes"`
+	Queries       uint64    `json:"queries"`
+	Writes        uint64    `json:"writes"`
+
+	readBytes uint64
+	queries   uint64
+	writes    uint64
+}
+
+func (t *tableReport) finish(rows uint64, deletes uint64, errors uint64) {
+	t.DurationSec = time.Since(t.Started).Seconds()
+	t.RowsTraversed = rows
+	t.Deletes = deletes
+	t.Errors = errors
+	t.ReadBytes = atomic.LoadUint64(&usage.readBytes) - t.readBytes
+	t.Queries = atomic.LoadUint64(&usage.queries) - t.queries
+	t.Writes = atomic.LoadUint64(&usage.writes) - t.writes
+}
+
+type usageReport struct {
+	ReadBytes uint64      `json:"read_bytes"`
+	Queries   uint64      `json:"queries"`
+	Writes    uint64      `json:"writes"`
+	Hosts     []hostUsage `json:"hosts"`
+}
+
+type runReport struct {
+	Command            string            `json:"command,omitempty"`
+	Started            time.Time         `json:"started"`
+	Finished           time.Time         `json:"finished"`
+	DurationSec        float64           `json:"duration_s"`
+	Hosts              string            `json:"hosts"`
+	Keyspace           string            `json:"keyspace"`
+	FromLedger         uint64            `json:"from_ledger"`
+	ToLedger           uint64            `json:"to_ledger"`
+	ToLatest           bool              `json:"to_latest"`
+	Tables             []*tableReport    `json:"tables"`
+	TotalRows          uint64            `json:"total_rows_traversed"`
+	TotalDeletes       uint64            `json:"total_deletes"`
+	TotalErrors        uint64            `json:"total_errors"`
+	LedgerRangeUpdated bool              `json:"ledger_range_updated"`
+	LedgerRangeBefore  ledgerRange       `json:"ledger_range_before"`
+	LedgerRangeAfter   ledgerRange       `json:"ledger_range_after"`
+	Interrupted        bool              `json:"interrupted"`
+	Pauses             int               `json:"pauses"`
+	Retries            uint64            `json:"retries"`
+	Usage              usageReport       `json:"usage"`
+	ToolVersion        string            `json:"tool_version"`
+	Config             map[string]string `json:"config"`
+
+	mu sync.Mutex // guards Tables while tables are pruned in parallel
+}
+
+// startTable snapshots the usage counters so the table's share of the work can be reported
+func (r *runReport) startTable(name string) *tableReport {
+	t := &tableReport{
+		Table:     name,
+		Started:   time.Now().UTC(),
+		readBytes: atomic.LoadUint64(&usage.readBytes),
+		queries:   atomic.LoadUint64(&usage.queries),
+		writes:    atomic.LoadUint64(&usage.writes),
+	}
+	r.mu.Lock()
+	r.Tables = append(r.Tables, t)
+	r.mu.Unlock()
+	return t
+}
+
+// finish fills in the end of the run and the usage totals
+func (r *runReport) finish() {
+	r.Finished = time.Now().UTC()
+	r.DurationSec = r.Finished.Sub(r.Started).Seconds()
+	r.Usage = usageReport{
+		ReadBytes: atomic.LoadUint64(&usage.readBytes),
+		Queries:   atomic.LoadUint64(&usage.queries),
+		Writes:    atomic.LoadUint64(&usage.writes),
+		Hosts:     usage.perHost(),
+	}
+
+	r.Retries = retry.Retries()
+	r.ToolVersion = toolVersion()
+	r.Config = runConfig()
+
+	if gate != nil {
+		gate.mu.Lock()
+		r.Pauses = gate.pauses
+		gate.mu.Unlock()
+	}
+}
+
+// runConfig holds the value of every flag of the run, except for the password
+func runConfig() map[string]string {
+	config := make(map[string]string)
+	for _, f := range kingpin.CommandLine.Model().Flags {
+		if f.Name == "help" {
+			continue
+		}
+		config[f.Name] = f.String()
+		if f.Name == "password" && f.String() != "" {
+			config[f.Name] = "<redacted>"
+		}
+	}
+	return config
+}
+
+func (r *runReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
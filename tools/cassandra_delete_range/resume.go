@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const resumeWriteInterval = 5 * time.Second
+
+// resumeMarker is what an interrupted run left behind for the next one to continue from
+type resumeMarker struct {
+	Keyspace    string   `json:"keyspace"`
+	FromLedger  uint64   `json:"from_ledger"`
+	ToLedger    uint64   `json:"to_ledger"`
+	WorkerCount int      `json:"worker_count"`
+	DoneTables  []string `json:"done_tables"`
+
+	// worker counts of --<table>-workers
+	TableWorkers map[string]int `json:"table_workers,omitempty"`
+
+	// the table in progress
+	Table string `json:"table,omitempty"`
+	// set once its scan finished and all the rows to delete are in the spool file
+	Scanned bool `json:"scanned"`
+	// start tokens of its finished token ranges
+	DoneRanges []int64 `json:"done_ranges"`
+	// next page of its unfinished token ranges, hex encoded and keyed by start token
+	PageStates map[string]string `json:"page_states"`
+	// indexes of its finished delete chunks, see splitDeleteWork
+	DoneChunks []int `json:"done_chunks"`
+}
+
+// resumeState keeps the marker of --resume-file up to date. The rows a scan finds are spooled to a file
+// next to it before the page they came from is recorded as done, so a resumed run may scan some pages
+// twice but never misses a row
+type resumeState struct {
+	path string
+
+	mu         sync.Mutex
+	marker     resumeMarker
+	doneRanges map[int64]bool
+	doneChunks map[int]bool
+	spool      *os.File
+	spoolBuf   *bufio.Writer
+	lastWrite  time.Time
+}
+
+func loadResume(path string, window ledgerWindow) (*resumeState, error) {
+	r := &resumeState{
+		path:       path,
+		marker:     resumeMarker{Keyspace: *keyspace, FromLedger: window.from, ToLedger: window.to, WorkerCount: workerCount, TableWorkers: tableWorkerOverrides()},
+		doneRanges: make(map[int64]bool),
+		doneChunks: make(map[int]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m resumeMarker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if m.Keyspace != *keyspace || m.FromLedger != window.from || m.ToLedger != window.to {
+		return nil, fmt.Errorf("%s is the marker of a run deleting %d -> %d of keyspace %s; remove it to start over", path, m.FromLedger, m.ToLedger, m.Keyspace)
+	}
+
+	if m.WorkerCount != workerCount {
+		return nil, fmt.Errorf("%s is the marker of a run with %d parallel threads, resume with the same --nodes-in-cluster, --cores-in-node and --smudge-factor", path, m.WorkerCount)
+	}
+
+	if !maps.Equal(m.TableWorkers, r.marker.TableWorkers) {
+		return nil, fmt.Errorf("%s is the marker of a run with table worker overrides %v, resume with the same --<table>-workers", path, m.TableWorkers)
+	}
+
+	r.marker = m
+	for _, start := range m.DoneRanges {
+		r.doneRanges[start] = true
+	}
+	for _, idx := range m.DoneChunks {
+		r.doneChunks[idx] = true
+	}
+
+	if m.Table != "" {
+		r.spool, err = os.OpenFile(r.spoolPath(), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		r.spoolBuf = bufio.NewWriter(r.spool)
+
+		if err := truncateTornLine(r.spool); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Resuming from %s: %d tables done, %s in progress\n", path, len(m.DoneTables), m.Table)
+	return r, nil
+}
+
+// truncateTornLine drops a row the interruption only wrote partly; rows are far shorter than the tail read
+func truncateTornLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	tail := make([]byte, min(info.Size(), 64*1024))
+	offset := info.Size() - int64(len(tail))
+	if _, err := f.ReadAt(tail, offset); err != nil {
+		return err
+	}
+
+	return f.Truncate(offset + int64(bytes.LastIndexByte(tail, '\n')+1))
+}
+
+func (r *resumeState) spoolPath() string {
+	return r.path + ".spool"
+}
+
+// tableDone tells whether an earlier run finished the table already
+func (r *resumeState) tableDone(table string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.marker.DoneTables {
+		if t == table {
+			log.Printf("Skipping %s table: an earlier run of %s finished it\n\n", table, r.path)
+			return true
+		}
+	}
+	return false
+}
+
+// beginTable starts tracking a table, unless it is the one the interrupted run was working on
+func (r *resumeState) beginTable(table string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.marker.Table == table {
+		return nil
+	}
+
+	r.closeSpool()
+	spool, err := os.Create(r.spoolPath())
+	if err != nil {
+		return err
+	}
+
+	r.spool = spool
+	r.spoolBuf = bufio.NewWriter(spool)
+	r.marker.Table = table
+	r.marker.Scanned = false
+	r.marker.DoneRanges = nil
+	r.marker.PageStates = nil
+	r.marker.DoneChunks = nil
+	r.doneRanges = make(map[int64]bool)
+	r.doneChunks = make(map[int]bool)
+	return r.write(true)
+}
+
+// scanned returns the rows to delete of the table in progress when its scan finished in an earlier run
+func (r *resumeState) scanned() ([]deleteParams, bool, error) {
+	if r == nil {
+		return nil, false, nil
+	}
+
+	r.mu.Lock()
+	scanned := r.marker.Scanned
+	r.mu.Unlock()
+
+	if !scanned {
+		return nil, false, nil
+	}
+
+	rows, err := r.spooledRows()
+	return rows, true, err
+}
+
+// spooledRows reads back the rows the scans of the table in progress found so far
+func (r *resumeState) spooledRows() ([]deleteParams, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	err := r.spoolBuf.Flush()
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(r.spoolPath())
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var rows []deleteParams
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		seq, key, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			continue // torn by a crash
+		}
+
+		s, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			continue
+		}
+		blob, err := hex.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		rows = append(rows, deleteParams{Seq: s, Blob: blob})
+	}
+
+	return rows, scanner.Err()
+}
+
+// sortRows puts the rows to delete in an order that does not depend on the scan, so that the delete
+// chunks of a resumed run are the same as those of the interrupted one
+func (r *resumeState) sortRows(info *deleteInfo) {
+	if r == nil {
+		return
+	}
+
+	sortDeleteParams(info.Data)
+}
+
+func (r *resumeState) spoolRow(p deleteParams) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.spoolBuf, "%d %x\n", p.Seq, p.Blob)
+}
+
+func (r *resumeState) rangeDone(start int64) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doneRanges[start]
+}
+
+// pageState returns the page an interrupted scan of the token range was about to fetch
+func (r *resumeState) pageState(start int64) []byte {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, _ := hex.DecodeString(r.marker.PageStates[strconv.FormatInt(start, 10)])
+	return state
+}
+
+func (r *resumeState) savePage(start int64, next []byte) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.marker.PageStates == nil {
+		r.marker.PageStates = make(map[string]string)
+	}
+	r.marker.PageStates[strconv.FormatInt(start, 10)] = hex.EncodeToString(next)
+	r.writeOrLog(false)
+}
+
+func (r *resumeState) finishRange(start int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.doneRanges[start] = true
+	r.marker.DoneRanges = append(r.marker.DoneRanges, start)
+	delete(r.marker.PageStates, strconv.FormatInt(start, 10))
+	r.writeOrLog(false)
+}
+
+// finishScan records that every row to delete of the table in progress is in the spool file
+func (r *resumeState) finishScan() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.marker.Scanned = true
+	r.marker.DoneRanges = nil
+	r.marker.PageStates = nil
+	r.writeOrLog(true)
+}
+
+func (r *resumeState) chunkDone(idx int) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doneChunks[idx]
+}
+
+func (r *resumeState) finishChunk(idx int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.doneChunks[idx] = true
+	r.marker.DoneChunks = append(r.marker.DoneChunks, idx)
+	r.writeOrLog(false)
+}
+
+func (r *resumeState) finishTable(table string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeSpool()
+	os.Remove(r.spoolPath())
+
+	r.marker.DoneTables = append(r.marker.DoneTables, table)
+	r.marker.Table = ""
+	r.marker.Scanned = false
+	r.marker.DoneRanges = nil
+	r.marker.PageStates = nil
+	r.marker.DoneChunks = nil
+	r.writeOrLog(true)
+}
+
+// flush records the progress of an interrupted run right away
+func (r *resumeState) flush() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeOrLog(true)
+}
+
+// finish removes the marker once the run completed
+func (r *resumeState) finish() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeSpool()
+	os.Remove(r.spoolPath())
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("ERROR failed removing %s: %s\n", r.path, err)
+	}
+}
+
+func (r *resumeState) closeSpool() {
+	if r.spool == nil {
+		return
+	}
+
+	r.spoolBuf.Flush()
+	r.spool.Close()
+	r.spool = nil
+	r.spoolBuf = nil
+}
+
+func (r *resumeState) writeOrLog(force bool) {
+	if err := r.write(force); err != nil {
+		log.Printf("ERROR failed writing %s: %s\n", r.path, err)
+	}
+}
+
+// write stores the marker, at most every resumeWriteInterval unless forced; the spooled rows are
+// flushed first so the marker never gets ahead of them
+func (r *resumeState) write(force bool) error {
+	if !force && time.Since(r.lastWrite) < resumeWriteInterval {
+		return nil
+	}
+
+	if r.spool != nil {
+		if err := r.spoolBuf.Flush(); err != nil {
+			return err
+		}
+		if err := r.spool.Sync(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(r.marker)
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return err
+	}
+
+	r.lastWrite = time.Now()
+	return nil
+}
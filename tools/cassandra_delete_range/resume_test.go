@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTruncateTornLine(t *testing.T) {
+	long := strings.Repeat("x", 70*1024) + "\n"
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whole rows", "objects,ab,1\nobjects,cd,2\n", "objects,ab,1\nobjects,cd,2\n"},
+		{"torn row", "objects,ab,1\nobjects,cd", "objects,ab,1\n"},
+		{"only a torn row", "objects,ab", ""},
+		{"torn row after a long file", long + "objects,ab,1\nobj", long + "objects,ab,1\n"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "resume.spool")
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = truncateTornLine(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: spool is %d bytes ending in %q, want %d bytes", tt.name, len(data), data[max(0, len(data)-20):], len(tt.want))
+		}
+	}
+}
+
+func TestResumeDeleteChunks(t *testing.T) {
+	defer func(r *resumeState) { resume = r }(resume)
+	defer func(n int) { workerCount = n }(workerCount)
+	workerCount = 4
+
+	window := ledgerWindow{from: 100, to: 150}
+	path := filepath.Join(t.TempDir(), "resume.json")
+
+	// the interrupted run spools the rows in scan order, sorts them once the scan is done and then deletes
+	// a few of its chunks
+	first, err := loadResume(path, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.beginTable("objects"); err != nil {
+		t.Fatal(err)
+	}
+
+	var scannedRows []deleteParams
+	for i := 0; i < 50; i++ {
+		key := []byte{byte((i * 37) % 50), byte(i % 3)}
+		scannedRows = append(scannedRows, deleteParams{Seq: uint64(100 + i%7), Blob: key})
+	}
+	for _, row := range scannedRows {
+		first.spoolRow(row)
+	}
+
+	info := deleteInfo{Data: append([]deleteParams{}, scannedRows...)}
+	first.sortRows(&info)
+	first.finishScan()
+
+	deleted := make(map[string]int)
+	chunks := splitDeleteWork(&info, workerCount)
+	for _, idx := range []int{0, 2} {
+		for _, row := range chunks[idx] {
+			deleted[fmt.Sprintf("%x/%d", row.Blob, row.Seq)]++
+		}
+		first.finishChunk(idx)
+	}
+	first.flush()
+	first.closeSpool()
+
+	// the resumed run takes the rows from the spool and must skip exactly the rows of the finished chunks
+	resume, err = loadResume(path, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resume.beginTable("objects"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, _, errors := prepareDeleteQueries(nil, "objects", window.from, window.to, false, "", "", nil)
+	if errors != 0 {
+		t.Fatalf("prepareDeleteQueries failed with %d errors", errors)
+	}
+
+	for idx, chunk := range splitDeleteWork(&resumed, workerCount) {
+		if resume.chunkDone(idx) {
+			continue
+		}
+		for _, row := range chunk {
+			deleted[fmt.Sprintf("%x/%d", row.Blob, row.Seq)]++
+		}
+	}
+	resume.closeSpool()
+
+	for _, row := range scannedRows {
+		if n := deleted[fmt.Sprintf("%x/%d", row.Blob, row.Seq)]; n != 1 {
+			t.Errorf("row %x at %d deleted %d times, want once", row.Blob, row.Seq, n)
+		}
+	}
+}
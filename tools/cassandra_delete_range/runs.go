@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// statuses of a run; a run whose process is gone while it is running crashed
+const (
+	runRunning     = "running"
+	runInterrupted = "interrupted"
+	runFailed      = "failed"
+	runPartial     = "partial"
+	runCompleted   = "completed"
+	runCrashed     = "crashed"
+)
+
+// runRecord is what --runs-dir keeps of a prune: how it was started, how far it got and how it ended
+type runRecord struct {
+	ID              string     `json:"id"`
+	Args            []string   `json:"args"`
+	PasswordOmitted bool       `json:"password_omitted,omitempty"` // the value of --password is not stored
+	Keyspace        string     `json:"keyspace"`
+	Window          string     `json:"window"`
+	Started         time.Time  `json:"started"`
+	Finished        *time.Time `json:"finished,omitempty"`
+	Status          string     `json:"status"`
+	PID             int        `json:"pid"`
+	ResumeFile      string     `json:"resume_file,omitempty"`
+	Deletes         uint64     `json:"deletes"`
+	Errors          uint64     `json:"errors"`
+	Attempts        int        `json:"attempts"`
+
+	dir string
+}
+
+var currentRun *runRecord // nil for estimates and when --runs-dir is empty
+
+func runDir(id string) string {
+	return filepath.Join(*runsDir, id)
+}
+
+func readRun(id string) (*runRecord, error) {
+	data, err := os.ReadFile(filepath.Join(runDir(id), "run.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var r runRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("run %s: %w", id, err)
+	}
+	r.dir = runDir(id)
+	return &r, nil
+}
+
+func (r *runRecord) write() {
+	if r == nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(r.dir, "run.json"), data, 0644)
+	}
+	if err != nil {
+		log.Printf("ERROR failed writing the record of run %s: %s\n", r.ID, err)
+	}
+}
+
+// storedArgs drops the password from the command line, it is not written to the runs directory, and the
+// flags naming the run, which resuming it sets
+func storedArgs(args []string) ([]string, bool) {
+	var stored []string
+	passwordOmitted := false
+outer:
+	for i := 0; i < len(args); i++ {
+		for _, flag := range []string{"--password", "--run-id", "--runs-dir"} {
+			if args[i] == flag || strings.HasPrefix(args[i], flag+"=") {
+				if args[i] == flag {
+					i++
+				}
+				passwordOmitted = passwordOmitted || flag == "--password"
+				continue outer
+			}
+		}
+		stored = append(stored, args[i])
+	}
+	return stored, passwordOmitted
+}
+
+// startRun records the run in --runs-dir, under --run-id or a new ID; a run continued under its ID keeps
+// its progress in the resume file of its directory
+func startRun(window ledgerWindow) {
+	if *runsDir == "" || estimating != nil {
+		return
+	}
+
+	id := *runID
+	if id == "" {
+		id = time.Now().UTC().Format("20060102-150405")
+	}
+	if strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		fatalf(exitInvalid, "--run-id %s: must be usable as a directory name\n", id)
+	}
+
+	r, err := readRun(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		r = &runRecord{ID: id, Keyspace: *keyspace, Window: window.String(), Started: time.Now().UTC(), dir: runDir(id)}
+		r.Args, r.PasswordOmitted = storedArgs(os.Args[1:])
+		if err := os.MkdirAll(r.dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	case err != nil:
+		log.Fatal(err)
+	case r.Status == runCompleted:
+		fatalf(exitInvalid, "Run %s completed already\n", id)
+	case r.Status == runRunning && processAlive(r.PID):
+		fatalf(exitInvalid, "Run %s is running already as process %d\n", id, r.PID)
+	default:
+		log.Printf("Continuing run %s, started %s\n", id, r.Started.Format(time.RFC3339))
+	}
+
+	// the resume marker tracks one table at a time
+	if *resumeFile == "" && *parallelTables == 1 && !*collapseSuccessor {
+		*resumeFile = filepath.Join(r.dir, "resume.json")
+	}
+
+	r.Status = runRunning
+	r.Finished = nil
+	r.PID = os.Getpid()
+	r.ResumeFile = *resumeFile
+	r.Attempts++
+	r.write()
+
+	currentRun = r
+	log.Printf("Run ID %s, recorded in %s\n", id, r.dir)
+}
+
+// finishRun records the end of the run
+func finishRun(status string, report *runReport) {
+	if currentRun == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	currentRun.Finished = &now
+	currentRun.Status = status
+	currentRun.Deletes += report.TotalDeletes
+	currentRun.Errors += report.TotalErrors
+	currentRun.write()
+}
+
+func processAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+func runStatus(r *runRecord) string {
+	if r.Status == runRunning && !processAlive(r.PID) {
+		return runCrashed
+	}
+	return r.Status
+}
+
+func runListRuns() {
+	entries, err := os.ReadDir(*runsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var runs []*runRecord
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		r, err := readRun(e.Name())
+		if err != nil {
+			log.Printf("WARNING: ignoring %s: %s\n", filepath.Join(*runsDir, e.Name()), err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Started.Before(runs[j].Started) })
+
+	fmt.Printf("%-24s %-12s %-20s %-16s %-40s %12s %8s\n", "id", "status", "started", "keyspace", "range", "deletes", "errors")
+	for _, r := range runs {
+		fmt.Printf("%-24s %-12s %-20s %-16s %-40s %12d %8d\n", r.ID, runStatus(r), r.Started.Format("2006-01-02 15:04:05"), r.Keyspace, r.Window, r.Deletes, r.Errors)
+	}
+}
+
+// runResumeRun starts the command line of a run again under its ID, which continues it from its resume file
+func runResumeRun() {
+	r, err := readRun(*resumeRunID)
+	if err != nil {
+		fatal(exitInvalid, err)
+	}
+
+	switch status := runStatus(r); status {
+	case runCompleted:
+		fatalf(exitInvalid, "Run %s completed already\n", r.ID)
+	case runRunning:
+		fatalf(exitInvalid, "Run %s is running already as process %d\n", r.ID, r.PID)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := append([]string{os.Args[0], "--run-id=" + r.ID, "--runs-dir=" + *runsDir}, r.Args...)
+	log.Printf("Resuming run %s: %s\n", r.ID, strings.Join(args[1:], " "))
+	if r.PasswordOmitted {
+		log.Println("The password is not stored with the run; give it with CASSANDRA_PASSWORD or --credentials-file")
+	}
+
+	if err := syscall.Exec(executable, args, os.Environ()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often a paused delete looks whether the run was interrupted
+const maintenancePoll = time.Second
+
+// maintenanceWindow is the time of day deletes may run in, in local time; windows ending before they start
+// span midnight, i.e. 22:00-06:00
+type maintenanceWindow struct {
+	spec  string
+	start time.Duration // since midnight
+	end   time.Duration
+
+	mu     sync.Mutex
+	paused bool
+}
+
+var maintenance *maintenanceWindow // nil unless --window
+
+func parseMaintenanceWindow(spec string) (*maintenanceWindow, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	from, to, ok := strings.Cut(spec, "-")
+	start, startErr := parseTimeOfDay(from)
+	end, endErr := parseTimeOfDay(to)
+	if !ok || startErr != nil || endErr != nil || start == end {
+		return nil, fmt.Errorf("--window %s: must be two different times of day, i.e. 22:00-06:00", spec)
+	}
+	return &maintenanceWindow{spec: spec, start: start, end: end}, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	if w.start < w.end {
+		return w.start <= now && now < w.end
+	}
+	return now >= w.start || now < w.end
+}
+
+// untilOpen is the time left until the window opens next
+func (w *maintenanceWindow) untilOpen(t time.Time) time.Duration {
+	d := w.start - sinceMidnight(t)
+	if d < 0 {
+		d += 24 * time.Hour
+	}
+	return d
+}
+
+// wait holds a delete back while the time is outside of the window, or until the run is interrupted
+func (w *maintenanceWindow) wait() {
+	if w == nil {
+		return
+	}
+
+	for !interrupted() {
+		now := time.Now()
+		open := w.contains(now)
+
+		w.mu.Lock()
+		if open && w.paused {
+			log.Printf("Maintenance window %s opened, resuming the deletes\n", w.spec)
+		} else if !open && !w.paused {
+			log.Printf("Outside of the maintenance window %s, pausing the deletes for %s\n", w.spec, w.untilOpen(now).Round(time.Second))
+		}
+		w.paused = !open
+		w.mu.Unlock()
+
+		if open {
+			return
+		}
+		time.Sleep(min(w.untilOpen(now), maintenancePoll))
+	}
+}
+
+func maintenanceDescription() string {
+	if maintenance == nil {
+		return "always"
+	}
+	return fmt.Sprintf("%s local time", maintenance.spec)
+}
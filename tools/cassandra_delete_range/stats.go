@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+)
+
+// tableStats is what stats found out about one table
+type tableStats struct {
+	table      string
+	partitions uint64 // from system.size_estimates of every node
+	bytes      uint64 // from system.size_estimates of every node
+	rows       uint64 // extrapolated from the scan
+	buckets    map[uint64]uint64
+	errors     uint64
+	mu         sync.Mutex
+}
+
+func runStats(cluster *gocql.ClusterConfig) {
+	if *statsBucketSize == 0 {
+		fatal(exitInvalid, "--bucket-size must be positive")
+	}
+	if *statsSample <= 0 || *statsSample > 1 {
+		fatal(exitInvalid, "--sample must be between 0 (exclusive) and 1")
+	}
+
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+	sampled := max(1, int(float64(len(ranges))**statsSample))
+	scale := float64(len(ranges)) / float64(sampled)
+	ranges = ranges[:sampled]
+
+	loadTables(cluster)
+
+	earliest, latest, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	var stats []*tableStats
+	for _, table := range tableNames {
+		s := &tableStats{table: table, buckets: make(map[uint64]uint64)}
+		sizeEstimates(cluster, session, s)
+		if def := queryTemplates[table]; *statsScan && (def.Scan != "" || def.Partitions != "") && def.WindowDelete == "" {
+			log.Printf("Scanning %s table\n", table)
+			scanStats(session, s, scale)
+		}
+		stats = append(stats, s)
+	}
+
+	printStats(stats, earliest, latest, scale)
+}
+
+// sizeEstimates sums up the estimates every node keeps of its token ranges
+func sizeEstimates(cluster *gocql.ClusterConfig, session *gocql.Session, s *tableStats) {
+	for _, node := range knownNodes(cluster, session) {
+		nodeCluster := *cluster
+		nodeCluster.Hosts = []string{node}
+		nodeCluster.HostFilter = gocql.WhiteListHostFilter(node)
+		nodeCluster.QueryObserver = nil
+
+		nodeSession, err := createSession(&nodeCluster)
+		if err != nil {
+			log.Printf("ERROR: no size estimates of node %s: %s\n", node, err)
+			continue
+		}
+
+		var partitions, meanSize int64
+		iter := nodeSession.Query("SELECT partitions_count, mean_partition_size FROM system.size_estimates WHERE keyspace_name = ? AND table_name = ?", *keyspace, s.table).Iter()
+		for iter.Scan(&partitions, &meanSize) {
+			s.partitions += uint64(partitions)
+			s.bytes += uint64(partitions * meanSize)
+		}
+		if err := iter.Close(); err != nil {
+			log.Printf("ERROR: no size estimates of node %s: %s\n", node, err)
+		}
+		nodeSession.Close()
+	}
+}
+
+// scanStats counts the rows of the table per bucket of ledgers
+func scanStats(session *gocql.Session, s *tableStats, scale float64) {
+	query, keyed := queryTemplates[s.table].Scan, true
+	if query == "" {
+		query, keyed = queryTemplates[s.table].Partitions, false
+	}
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for _, r := range ranges {
+		rangesChannel <- r
+	}
+	close(rangesChannel)
+
+	tableProgress := startProgress(s.table, "stats", uint64(len(ranges)), "token ranges", "rows")
+	defer tableProgress.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			for r := range rangesChannel {
+				buckets := make(map[uint64]uint64)
+				var rows uint64
+
+				var key []byte
+				var seq uint64
+				var err error
+				scanner := session.Query(query, r.StartRange, r.EndRange).PageSize(*clusterPageSize).Iter().Scanner()
+				for err == nil && scanner.Next() {
+					if keyed {
+						err = scanner.Scan(&key, &seq)
+					} else {
+						err = scanner.Scan(&seq)
+					}
+					if err == nil {
+						buckets[seq / *statsBucketSize]++
+						rows++
+					}
+				}
+				if scanErr := scanner.Err(); scanErr != nil {
+					err = scanErr
+				}
+
+				s.mu.Lock()
+				if err != nil {
+					log.Printf("ERROR: stats query failed: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d]", query, r.StartRange, r.EndRange))
+					s.errors++
+				}
+				for b, n := range buckets {
+					s.buckets[b] += n
+				}
+				s.rows += rows
+				s.mu.Unlock()
+
+				tableProgress.addItems(rows)
+				tableProgress.addSteps(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	s.rows = uint64(float64(s.rows) * scale)
+	for b, n := range s.buckets {
+		s.buckets[b] = uint64(float64(n) * scale)
+	}
+}
+
+func printStats(stats []*tableStats, earliest uint64, latest uint64, scale float64) {
+	fmt.Printf("\nKeyspace %s, ledger range %d:%d\n", *keyspace, earliest, latest)
+	fmt.Println("=====================")
+	fmt.Printf("%-20s %14s %12s %14s %8s\n", "table", "partitions", "size", "rows", "errors")
+
+	var totalBytes uint64
+	for _, s := range stats {
+		rows := "-"
+		if def := queryTemplates[s.table]; *statsScan && (def.Scan != "" || def.Partitions != "") && def.WindowDelete == "" {
+			rows = fmt.Sprintf("%d", s.rows)
+		}
+		fmt.Printf("%-20s %14d %12s %14s %8d\n", s.table, s.partitions, formatBytes(s.bytes), rows, s.errors)
+		totalBytes += s.bytes
+	}
+	fmt.Printf("%-20s %14s %12s\n", "total", "", formatBytes(totalBytes))
+
+	if !*statsScan {
+		fmt.Println("\nPartitions and sizes are the estimates of the nodes; use --scan to see them per ledger bucket")
+		return
+	}
+
+	if scale > 1 {
+		fmt.Printf("\nRows are extrapolated from %.1f%% of the token ranges\n", 100/scale)
+	}
+
+	// every table's size is spread over the buckets by its rows
+	bucketSet := make(map[uint64]bool)
+	for _, s := range stats {
+		for b := range s.buckets {
+			bucketSet[b] = true
+		}
+	}
+	var buckets []uint64
+	for b := range bucketSet {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	fmt.Printf("\n%-25s", "ledgers")
+	for _, s := range stats {
+		fmt.Printf(" %20s", s.table)
+	}
+	fmt.Printf(" %12s\n", "size")
+
+	for _, b := range buckets {
+		fmt.Printf("%-25s", fmt.Sprintf("%d-%d", b**statsBucketSize, (b+1)**statsBucketSize-1))
+		var size float64
+		for _, s := range stats {
+			fmt.Printf(" %20d", s.buckets[b])
+			if s.rows > 0 {
+				size += float64(s.bytes) * float64(s.buckets[b]) / float64(s.rows)
+			}
+		}
+		fmt.Printf(" %12s\n", formatBytes(uint64(size)))
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
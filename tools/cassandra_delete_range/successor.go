@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/cassandra_delete_range/internal/cass"
+)
+
+// the keys the successor linked list of every ledger starts and ends with
+var (
+	successorHead = string(make([]byte, 32))
+	successorTail = string(bytes.Repeat([]byte{0xff}, 32))
+)
+
+type successorLink struct {
+	seq  uint64
+	next string
+}
+
+// successorChain collects the newest version at or before a ledger of every successor key, to find the
+// keys that are not part of the linked list of that ledger anymore. Deleted objects are unlinked by
+// pointing their predecessor past them, so their last version stays behind although no later ledger
+// reaches it; all their versions up to the ledger can go
+type successorChain struct {
+	mu     sync.Mutex
+	newest map[string]successorLink
+}
+
+func newSuccessorChain() *successorChain {
+	return &successorChain{newest: make(map[string]successorLink)}
+}
+
+// record is the filter of the scan of the successor table; every row is selected
+func (c *successorChain) record(key []byte, seq uint64, next []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if link, ok := c.newest[string(key)]; !ok || seq > link.seq {
+		c.newest[string(key)] = successorLink{seq: seq, next: string(next)}
+	}
+	return true
+}
+
+// unlinked walks the linked list from its head and returns the newest versions of the keys it did not
+// reach, except book bases. It fails when the list is broken, as the scan must then have missed rows
+func (c *successorChain) unlinked() ([]deleteParams, error) {
+	linked := make(map[string]bool)
+	for key := successorHead; key != successorTail; {
+		if linked[key] {
+			return nil, fmt.Errorf("the linked list loops back to key %x", key)
+		}
+
+		link, ok := c.newest[key]
+		if !ok {
+			return nil, fmt.Errorf("the linked list is broken at key %x", key)
+		}
+
+		linked[key] = true
+		key = link.next
+	}
+
+	var rows []deleteParams
+	for key, link := range c.newest {
+		if !linked[key] && !isBookBase(key) {
+			rows = append(rows, deleteParams{Seq: link.seq, Blob: []byte(key)})
+		}
+	}
+	return rows, nil
+}
+
+// isBookBase tells whether the key is the base of an order book, whose quality part is zero. Book bases
+// are not part of the linked list, their successor is the first directory of the book
+func isBookBase(key string) bool {
+	return len(key) == 32 && key[24:] == successorHead[24:]
+}
+
+// keyScan scans the keys of a table a token range at a time, through the scan path of prepareDeleteQueries
+type keyScan struct {
+	table    string
+	query    string
+	progress *progress
+	limiter  *adaptiveLimit
+	errCount atomic.Uint64
+}
+
+func newKeyScan(table string, phase string) *keyScan {
+	return &keyScan{
+		table:    table,
+		query:    queryTemplates[table].Scan,
+		progress: startProgress(table, phase, uint64(len(ranges)), "token ranges", "rows"),
+		limiter:  newAdaptiveLimit(table, phase, workerCount),
+	}
+}
+
+func (s *keyScan) stop() {
+	s.limiter.stop()
+	s.progress.stop()
+}
+
+// scan calls fn with the key of every row of the token range and tells whether all of them were seen
+func (s *keyScan) scan(session *gocql.Session, r *cassandra.TokenRange, fn func(key []byte)) bool {
+	defer s.progress.addSteps(1)
+
+	var key []byte
+	var seq uint64
+	var rowFailed bool
+	complete := rangeScan{
+		query:    speculative(scanConsistency(session.Query(s.query, r.StartRange, r.EndRange))),
+		text:     s.query,
+		r:        r,
+		pageSize: pageSizeFor(s.table),
+		limiter:  s.limiter,
+		row: func(scanner gocql.Scanner) error {
+			if err := scanner.Scan(&key, &seq); err != nil {
+				return err
+			}
+			s.progress.addItems(1)
+			usage.addRead(len(key) + 8)
+			fn(key)
+			return nil
+		},
+		failed: func() {
+			rowFailed = true
+			s.progress.addErrors(1)
+			budget.add(1)
+		},
+	}.run(nil)
+
+	if !complete || rowFailed {
+		s.errCount.Add(1)
+		return false
+	}
+	return true
+}
+
+// failed returns the number of token ranges that were not scanned completely
+func (s *keyScan) failed() uint64 {
+	return s.errCount.Load()
+}
+
+// eachRange calls fn with every token range, from workerCount goroutines at once
+func eachRange(fn func(r *cassandra.TokenRange)) {
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for _, r := range ranges {
+		rangesChannel <- r
+	}
+	close(rangesChannel)
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+			for r := range rangesChannel {
+				fn(r)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runCleanSuccessor deletes every row of the successor keys that have no row in the objects table anymore,
+// i.e. left behind by runs deleting the oldest ledgers without --collapse-successor. The linked list
+// sentinels and book bases are never objects and are kept
+func runCleanSuccessor(cluster *gocql.ClusterConfig) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	cluster.QueryObserver = usage
+	cluster.BatchObserver = usage
+	retry = &cass.RetryPolicy{Attempts: *retryAttempts, BaseDelay: *retryBaseDelay, MaxDelay: *retryMaxDelay, Jitter: *retryJitter}
+	deleteRate = cass.NewRateLimiter(*maxDeleteRate)
+	scanRate = cass.NewRateLimiter(*maxScanRate)
+
+	loadTables(cluster)
+	if queryTemplates["successor"].Scan == "" || queryTemplates["successor"].RangeDelete == "" || queryTemplates["objects"].Scan == "" {
+		fatal(exitInvalid, "clean-successor needs the scan and range delete queries of the successor table and the scan query of the objects table")
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+	startBackpressure(cluster)
+
+	log.Println("WARNING: Please make sure that there are no Clio writers operating on the DB, keys they write between the two scans would be taken for orphans")
+
+	// the keys of both tables share the partitioner, so the object of a successor key is in the same token
+	// range. Of every range the successor keys are scanned first, so that keys written in the meantime are
+	// not mistaken for orphans
+	successors := newKeyScan("successor", "clean-successor")
+	objects := newKeyScan("objects", "clean-successor")
+
+	var mu sync.Mutex
+	info := deleteInfo{Query: queryTemplates["successor"].RangeDelete}
+	log.Println("Scanning successor and objects tables")
+	eachRange(func(r *cassandra.TokenRange) {
+		candidates := make(map[string]bool)
+		if !successors.scan(session, r, func(key []byte) {
+			if k := string(key); k != successorHead && k != successorTail && !isBookBase(k) {
+				candidates[k] = true
+			}
+		}) {
+			objects.progress.addSteps(1)
+			return
+		}
+
+		if !objects.scan(session, r, func(key []byte) {
+			delete(candidates, string(key))
+		}) {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for key := range candidates {
+			info.Data = append(info.Data, deleteParams{Blob: []byte(key)})
+		}
+	})
+	successors.stop()
+	objects.stop()
+
+	if errCount := successors.failed() + objects.failed(); errCount > 0 {
+		fatalf(exitFailed, "%d token ranges could not be scanned; not deleting from a partial scan, keys of objects it missed would be taken for orphans\n", errCount)
+	}
+
+	log.Printf("Successor keys without objects: %d\n", len(info.Data))
+	if len(info.Data) == 0 {
+		return
+	}
+
+	if !confirm() {
+		fatal(exitAborted, "Aborting...")
+	}
+
+	handleSignals()
+	run := startKeyedRun(cluster, "clean-successor")
+	deletes, failed := run.deleteRows("successor", &info)
+	run.finish()
+	log.Printf("Deleted all rows of %d successor keys, %d failed\n", deletes, failed)
+
+	if interrupted() {
+		os.Exit(exitAborted)
+	}
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func successorKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// bookBase is the key of an order book base, whose last 8 bytes are zero
+func bookBase(b byte) []byte {
+	return append(bytes.Repeat([]byte{b}, 24), make([]byte, 8)...)
+}
+
+type successorRow struct {
+	key  []byte
+	seq  uint64
+	next []byte
+}
+
+func TestSuccessorChainUnlinked(t *testing.T) {
+	head := []byte(successorHead)
+	tail := []byte(successorTail)
+	a, b, c := successorKey(0xa), successorKey(0xb), successorKey(0xc)
+
+	tests := []struct {
+		name string
+		rows []successorRow
+		want []deleteParams
+		err  string
+	}{
+		{
+			name: "empty list",
+			rows: []successorRow{{head, 1, tail}},
+		},
+		{
+			name: "every key linked",
+			rows: []successorRow{{head, 1, a}, {a, 1, b}, {b, 2, tail}},
+		},
+		{
+			name: "deleted key",
+			rows: []successorRow{{head, 1, a}, {a, 1, b}, {b, 1, tail}, {a, 5, tail}},
+			want: []deleteParams{{Seq: 1, Blob: b}},
+		},
+		{
+			name: "newest version wins",
+			rows: []successorRow{{head, 1, a}, {a, 1, c}, {c, 3, tail}, {head, 4, b}, {b, 4, tail}},
+			want: []deleteParams{{Seq: 1, Blob: a}, {Seq: 3, Blob: c}},
+		},
+		{
+			name: "book bases are kept",
+			rows: []successorRow{{head, 1, tail}, {bookBase(0xd), 2, a}},
+		},
+		{
+			name: "broken list",
+			rows: []successorRow{{head, 1, a}, {b, 1, tail}},
+			err:  "broken",
+		},
+		{
+			name: "loop",
+			rows: []successorRow{{head, 1, a}, {a, 1, b}, {b, 1, a}},
+			err:  "loops back",
+		},
+		{
+			name: "no head",
+			rows: []successorRow{{a, 1, tail}},
+			err:  "broken",
+		},
+	}
+
+	for _, tt := range tests {
+		chain := newSuccessorChain()
+		for _, r := range tt.rows {
+			chain.record(r.key, r.seq, r.next)
+		}
+
+		got, err := chain.unlinked()
+		checkError(t, tt.name, err, tt.err)
+		if err != nil {
+			continue
+		}
+
+		sort.Slice(got, func(i, j int) bool { return bytes.Compare(got[i].Blob, got[j].Blob) < 0 })
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: unlinked = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Seq != tt.want[i].Seq || !bytes.Equal(got[i].Blob, tt.want[i].Blob) {
+				t.Errorf("%s: unlinked = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestIsBookBase(t *testing.T) {
+	tests := []struct {
+		key  []byte
+		want bool
+	}{
+		{bookBase(0xd), true},
+		{successorKey(0xd), false},
+		{[]byte(successorHead), true},
+		{[]byte(strings.Repeat("\x00", 31)), false},
+	}
+
+	for _, tt := range tests {
+		if got := isBookBase(string(tt.key)); got != tt.want {
+			t.Errorf("isBookBase(%x) = %t, want %t", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+)
+
+// tableNames lists the tables in the order they are pruned; the ones of --tables-file come last
+var tableNames = []string{"successor", "objects", "nf_tokens", "nf_token_uris", "account_tx", "nf_token_transactions", "ledger_hashes", "transactions", "diff", "ledger_transactions", "ledgers"}
+
+// the --skip-* flags of the built-in tables
+var skipFlags = map[string]*bool{
+	"successor":             skipSuccessorTable,
+	"objects":               skipObjectsTable,
+	"nf_tokens":             skipNFTokensTable,
+	"nf_token_uris":         skipNFTokenURIsTable,
+	"account_tx":            skipAccountTxTable,
+	"nf_token_transactions": skipNFTokenTxTable,
+	"ledger_hashes":         skipLedgerHashesTable,
+	"transactions":          skipTransactionsTable,
+	"diff":                  skipDiffTable,
+	"ledger_transactions":   skipLedgerTransactionsTable,
+	"ledgers":               skipLedgersTable,
+}
+
+func skipTable(table string) bool {
+	if flag, ok := skipFlags[table]; ok && *flag {
+		return true
+	}
+	if only := onlyTableNames(); len(only) > 0 && !slices.Contains(only, table) {
+		return true
+	}
+	return slices.Contains(*skipTables, table)
+}
+
+// onlyTableNames lists the tables of --only, which can be repeated or hold several comma separated ones
+func onlyTableNames() []string {
+	var names []string
+	for _, value := range *onlyTables {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// checkOnlyTables refuses the tables of --only that are neither built in nor in --tables-file
+func checkOnlyTables() {
+	for _, name := range onlyTableNames() {
+		if _, ok := queryTemplates[name]; !ok {
+			fatalf(exitInvalid, "--only: unknown table %s", name)
+		}
+	}
+}
+
+// gc_grace_seconds of the tables of the keyspace, the time their tombstones stay at least
+var gcGrace = make(map[string]time.Duration)
+
+// dropMissingTables stops pruning the tables the keyspace does not have, i.e. the NFT tables of keyspaces
+// older Clio versions created, and reads the gc_grace_seconds of the others
+func dropMissingTables(session *gocql.Session) {
+	existing := make(map[string]bool)
+	var name string
+	var gcGraceSeconds int
+	iter := session.Query("SELECT table_name, gc_grace_seconds FROM system_schema.tables WHERE keyspace_name = ?", *keyspace).Iter()
+	for iter.Scan(&name, &gcGraceSeconds) {
+		existing[name] = true
+		gcGrace[name] = time.Duration(gcGraceSeconds) * time.Second
+	}
+	if err := iter.Close(); err != nil || len(existing) == 0 {
+		log.Printf("WARNING: could not list the tables of keyspace %s, assuming all of them exist: %v\n", *keyspace, err)
+		return
+	}
+
+	var kept []string
+	for _, table := range tableNames {
+		if existing[table] {
+			kept = append(kept, table)
+		} else {
+			log.Printf("Skipping %s table: keyspace %s does not have it\n", table, *keyspace)
+		}
+	}
+	tableNames = kept
+}
+
+// skippedTablesDescription lists the tables skipped with --skip-table
+func skippedTablesDescription() string {
+	if len(*skipTables) == 0 {
+		return "none"
+	}
+	return strings.Join(*skipTables, ", ")
+}
+
+func onlyTablesDescription() string {
+	if only := onlyTableNames(); len(only) > 0 {
+		return strings.Join(only, ", ")
+	}
+	return "all"
+}
+
+// tableSettings overrides the parallelism and page size of one table, i.e. --objects-workers; zero keeps
+// the global ones
+type tableSettings struct {
+	workers  *int
+	pageSize *int // nil for tables that are not scanned
+}
+
+var perTable = newTableSettings()
+
+func newTableSettings() map[string]tableSettings {
+	settings := make(map[string]tableSettings)
+	for _, table := range tableNames {
+		flag := strings.ReplaceAll(table, "_", "-")
+
+		var s tableSettings
+		s.workers = kingpin.Flag(flag+"-workers", fmt.Sprintf("Number of parallel threads for the %s table instead of the computed one", table)).Default("0").Int()
+		if queryTemplates[table].Scan != "" {
+			s.pageSize = kingpin.Flag(flag+"-page-size", fmt.Sprintf("Page size of the %s table scan instead of --cluster-page-size", table)).Default("0").Int()
+		}
+		settings[table] = s
+	}
+	return settings
+}
+
+func workersFor(table string) int {
+	if s, ok := perTable[table]; ok && *s.workers > 0 {
+		return *s.workers
+	}
+	// tables pruned at once share the threads
+	return max(1, workerCount / *parallelTables)
+}
+
+func pageSizeFor(table string) int {
+	if s, ok := perTable[table]; ok && s.pageSize != nil && *s.pageSize > 0 {
+		return *s.pageSize
+	}
+	return *clusterPageSize
+}
+
+// tableWorkerOverrides returns the tables whose worker count was overridden; resumed runs must use the same,
+// the delete chunks depend on it
+func tableWorkerOverrides() map[string]int {
+	overrides := make(map[string]int)
+	for table, s := range perTable {
+		if *s.workers > 0 {
+			overrides[table] = *s.workers
+		}
+	}
+	return overrides
+}
+
+func tableSettingsDescription() string {
+	var overrides []string
+	for _, table := range tableNames {
+		s := perTable[table]
+		var parts []string
+		if *s.workers > 0 {
+			parts = append(parts, fmt.Sprintf("%d threads", *s.workers))
+		}
+		if s.pageSize != nil && *s.pageSize > 0 {
+			parts = append(parts, fmt.Sprintf("page size %d", *s.pageSize))
+		}
+		if len(parts) > 0 {
+			overrides = append(overrides, fmt.Sprintf("%s (%s)", table, strings.Join(parts, ", ")))
+		}
+	}
+
+	if len(overrides) == 0 {
+		return "none"
+	}
+	return strings.Join(overrides, ", ")
+}
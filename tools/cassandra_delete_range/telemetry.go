@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// errorClass names the kind of a query error without anything identifying the cluster
+func errorClass(err error) string {
+	var unavailable *gocql.RequestErrUnavailable
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	var readTimeout *gocql.RequestErrReadTimeout
+
+	switch {
+	case errors.As(err, &unavailable), errors.Is(err, gocql.ErrUnavailable):
+		return "unavailable"
+	case errors.As(err, &writeTimeout):
+		return "write_timeout"
+	case errors.As(err, &readTimeout):
+		return "read_timeout"
+	case errors.Is(err, gocql.ErrTimeoutNoResponse):
+		return "no_response"
+	case errors.Is(err, gocql.ErrNoConnections), errors.Is(err, gocql.ErrConnectionClosed):
+		return "connection"
+	}
+
+	if reqErr, ok := err.(gocql.RequestError); ok && reqErr.Code() == gocql.ErrCodeOverloaded {
+		return "overloaded"
+	}
+	return "other"
+}
+
+// roughly rounds a count to two significant digits so sizes cannot identify a network or a cluster
+func roughly(n uint64) uint64 {
+	if n < 100 {
+		return n
+	}
+
+	scale := math.Pow(10, math.Floor(math.Log10(float64(n)))-1)
+	return uint64(math.Round(float64(n)/scale) * scale)
+}
+
+type telemetryTable struct {
+	Table            string  `json:"table"`
+	RowsTraversed    uint64  `json:"rows_traversed"`
+	Deletes          uint64  `json:"deletes"`
+	DurationSec      float64 `json:"duration_s"`
+	DeletesPerSecond float64 `json:"deletes_per_s"`
+}
+
+// telemetryRecord holds the characteristics of a run; no hosts, keyspace or ledger indexes are recorded
+type telemetryRecord struct {
+	Time         time.Time         `json:"time"`
+	ToolVersion  string            `json:"tool_version"`
+	ClusterType  string            `json:"cluster_type"`
+	Nodes        int               `json:"nodes"`
+	Workers      int               `json:"workers"`
+	NodesSetting int               `json:"nodes_in_cluster"`
+	CoresSetting int               `json:"cores_in_node"`
+	SmudgeFactor int               `json:"smudge_factor"`
+	PageSize     int               `json:"page_size"`
+	Consistency  string            `json:"consistency"`
+	Ledgers      uint64            `json:"ledgers"`
+	DurationSec  float64           `json:"duration_s"`
+	Tables       []telemetryTable  `json:"tables"`
+	ErrorClasses map[string]uint64 `json:"error_classes"`
+	Pauses       int               `json:"pauses"`
+}
+
+// clusterType tells Scylla, which has a system.versions table, from Cassandra
+func clusterType(session *gocql.Session) (string, int) {
+	nodes := 1
+	var peer []byte
+	iter := session.Query("SELECT host_id FROM system.peers").Iter()
+	for iter.Scan(&peer) {
+		nodes++
+	}
+	iter.Close()
+
+	var version string
+	if err := session.Query("SELECT version FROM system.versions").Scan(&version); err == nil {
+		return "scylla", nodes
+	}
+	return "cassandra", nodes
+}
+
+// appendTelemetry adds the characteristics of a finished run as a JSON line to --telemetry-file
+func appendTelemetry(cluster *gocql.ClusterConfig, report *runReport) error {
+	r := telemetryRecord{
+		Time:         time.Now().UTC().Truncate(time.Hour),
+		ToolVersion:  toolVersion(),
+		ClusterType:  "unknown",
+		Workers:      workerCount,
+		NodesSetting: *nodesInCluster,
+		CoresSetting: *coresInNode,
+		SmudgeFactor: *smudgeFactor,
+		PageSize:     *clusterPageSize,
+		Consistency:  *clusterConsistency,
+		Ledgers:      roughly(report.ToLedger - report.FromLedger + 1),
+		DurationSec:  math.Round(report.DurationSec),
+		ErrorClasses: make(map[string]uint64),
+		Pauses:       report.Pauses,
+	}
+
+	if session, err := createSession(cluster); err == nil {
+		r.ClusterType, r.Nodes = clusterType(session)
+		session.Close()
+	}
+
+	for _, t := range report.Tables {
+		tt := telemetryTable{
+			Table:         t.Table,
+			RowsTraversed: roughly(t.RowsTraversed),
+			Deletes:       roughly(t.Deletes),
+			DurationSec:   math.Round(t.DurationSec),
+		}
+		if t.DurationSec > 0 {
+			tt.DeletesPerSecond = math.Round(float64(t.Deletes) / t.DurationSec)
+		}
+		r.Tables = append(r.Tables, tt)
+	}
+
+	usage.mu.Lock()
+	for class, n := range usage.errorClasses {
+		r.ErrorClasses[class] = n
+	}
+	usage.mu.Unlock()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(*telemetryFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
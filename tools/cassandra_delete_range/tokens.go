@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"xrplf/clio/cassandra"
+)
+
+// tokenSubset tells whether --token-start limits the run to parts of the ring
+func tokenSubset() bool {
+	return len(*tokenStarts) > 0
+}
+
+// restrictTokenRanges clips the token ranges to the parts of the ring of --token-start and --token-end
+func restrictTokenRanges(all []*cassandra.TokenRange) ([]*cassandra.TokenRange, error) {
+	if len(*tokenStarts) != len(*tokenEnds) {
+		return nil, fmt.Errorf("--token-start and --token-end must be given the same number of times")
+	}
+	if !tokenSubset() {
+		return all, nil
+	}
+
+	for i, start := range *tokenStarts {
+		if start > (*tokenEnds)[i] {
+			return nil, fmt.Errorf("--token-start %d is after its --token-end %d", start, (*tokenEnds)[i])
+		}
+	}
+
+	var restricted []*cassandra.TokenRange
+	for _, r := range all {
+		for i, start := range *tokenStarts {
+			clipped := cassandra.TokenRange{StartRange: max(r.StartRange, start), EndRange: min(r.EndRange, (*tokenEnds)[i])}
+			if clipped.StartRange <= clipped.EndRange {
+				restricted = append(restricted, &clipped)
+			}
+		}
+	}
+	return restricted, nil
+}
+
+func tokenSubsetDescription() string {
+	if !tokenSubset() {
+		return "whole ring"
+	}
+
+	var parts []string
+	for i, start := range *tokenStarts {
+		parts = append(parts, fmt.Sprintf("%d:%d", start, (*tokenEnds)[i]))
+	}
+	return strings.Join(parts, ", ")
+}
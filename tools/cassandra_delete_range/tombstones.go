@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var errTooManyTombstones = errors.New("too many tombstones")
+
+// tombstoneCount counts the tombstones the deletes of a scanned table write per partition, i.e. per key
+type tombstoneCount struct {
+	total        int
+	worst        uint64
+	perPartition map[string]uint64
+}
+
+func (c *tombstoneCount) add(key []byte) {
+	if c.perPartition == nil {
+		c.perPartition = make(map[string]uint64)
+	}
+	c.total++
+	c.perPartition[string(key)]++
+	c.worst = max(c.worst, c.perPartition[string(key)])
+}
+
+// checkTombstones counts the tombstones the deletes of a scanned table write per partition before they run.
+// Reads of a partition with more tombstones than tombstone_failure_threshold fail with
+// TombstoneOverwhelmingException until gc_grace_seconds passed and a compaction purged them, so unless
+// --force such deletes are refused
+func checkTombstones(table string, info *deleteInfo) error {
+	var count tombstoneCount
+	for _, r := range info.Data {
+		count.add(r.Blob)
+	}
+	return count.check(table)
+}
+
+func (c *tombstoneCount) check(table string) error {
+	var overWarn, overFail int
+	for _, n := range c.perPartition {
+		if n > *tombstoneWarnThreshold {
+			overWarn++
+		}
+		if n > *tombstoneFailThreshold {
+			overFail++
+		}
+	}
+
+	grace, known := gcGrace[table]
+	log.Printf("Tombstones of %s: %d in %d partitions, at most %d per partition; gc_grace_seconds %s\n", table, c.total, len(c.perPartition), c.worst, graceDescription(grace, known))
+
+	if known && grace == 0 && c.total > 0 {
+		log.Printf("WARNING: gc_grace_seconds of %s is 0, replicas that miss a delete may bring the row back; make sure all nodes are up\n", table)
+	}
+
+	if overWarn > 0 {
+		log.Printf("WARNING: %d partitions of %s get more than %d tombstones; reads of them will be slow for at least %s and until compacted\n", overWarn, table, *tombstoneWarnThreshold, graceDescription(grace, known))
+	}
+
+	if overFail == 0 {
+		return nil
+	}
+
+	if *force || estimating != nil {
+		log.Printf("WARNING: %d partitions of %s get more than %d tombstones; reads of them will fail until they are compacted\n", overFail, table, *tombstoneFailThreshold)
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d partitions of %s would get more than %d tombstones and fail reads until compacted; raise --tombstone-failure-threshold to the one of the cluster or use --force",
+		errTooManyTombstones, overFail, table, *tombstoneFailThreshold)
+}
+
+func graceDescription(grace time.Duration, known bool) string {
+	if !known {
+		return "unknown"
+	}
+	return grace.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"xrplf/clio/xrpl"
+)
+
+// name used on the command line for the empty blobs Clio writes when an object is deleted
+const deletedObjectType = "deleted"
+
+// ledger entry types whose objects table rows are deleted; empty when every row is
+var objectTypeFilter = make(map[string]bool)
+
+func parseObjectTypes(list string) error {
+	known := map[string]string{deletedObjectType: deletedObjectType}
+	for _, name := range xrpl.LedgerEntryTypes {
+		known[strings.ToLower(name)] = name
+	}
+
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+
+		name, ok := known[strings.ToLower(t)]
+		if !ok {
+			return fmt.Errorf("unknown ledger entry type %q", t)
+		}
+		objectTypeFilter[name] = true
+	}
+
+	if len(objectTypeFilter) == 0 {
+		return fmt.Errorf("--object-types does not name any ledger entry type")
+	}
+	return nil
+}
+
+func objectTypesDescription() string {
+	if len(objectTypeFilter) == 0 {
+		return "all"
+	}
+
+	var names []string
+	for name := range objectTypeFilter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// matchesObjectTypes tells whether an objects table row holds one of the selected ledger entry types
+func matchesObjectTypes(blob []byte) bool {
+	if len(blob) == 0 {
+		return objectTypeFilter[deletedObjectType]
+	}
+
+	t, err := xrpl.DecodeLedgerEntryType(blob)
+	return err == nil && objectTypeFilter[t]
+}
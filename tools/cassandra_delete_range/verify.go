@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+)
+
+const maxVerifyExamples = 10
+
+// tableCheck is the verdict of verify on one table
+type tableCheck struct {
+	Table string `json:"table"`
+	Rows  uint64 `json:"rows"`
+	// rows before the earliest or after the latest ledger of ledger_range
+	Outside uint64 `json:"outside"`
+	// versions of a successor or objects key that a newer version at or before the earliest ledger replaces
+	Superseded uint64   `json:"superseded"`
+	Errors     uint64   `json:"errors"`
+	Examples   []string `json:"examples,omitempty"`
+	Passed     bool     `json:"passed"`
+
+	mu sync.Mutex
+}
+
+func (c *tableCheck) add(rows uint64, outside uint64, superseded uint64, examples []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Rows += rows
+	c.Outside += outside
+	c.Superseded += superseded
+	for _, e := range examples {
+		if len(c.Examples) < maxVerifyExamples {
+			c.Examples = append(c.Examples, e)
+		}
+	}
+}
+
+type verifyReport struct {
+	Time        time.Time     `json:"time"`
+	Keyspace    string        `json:"keyspace"`
+	Earliest    uint64        `json:"earliest"`
+	Latest      uint64        `json:"latest"`
+	LedgerRange []string      `json:"ledger_range_problems,omitempty"`
+	Tables      []*tableCheck `json:"tables"`
+	Passed      bool          `json:"passed"`
+}
+
+func runVerify(cluster *gocql.ClusterConfig) {
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges = cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	loadTables(cluster)
+
+	earliest, latest, err := getLedgerRange(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	report := &verifyReport{Time: time.Now().UTC(), Keyspace: *keyspace, Earliest: earliest, Latest: latest}
+	report.LedgerRange = checkLedgerRange(session, earliest, latest)
+	report.Passed = len(report.LedgerRange) == 0
+
+	for _, table := range tableNames {
+		if def := queryTemplates[table]; def.Scan == "" && def.Partitions == "" {
+			log.Printf("Not verifying %s table: it has no scan or partitions query\n", table)
+			continue
+		} else if def.WindowDelete != "" {
+			log.Printf("Not verifying %s table: only the newest row of its partitions is scanned\n", table)
+			continue
+		}
+
+		log.Printf("Verifying %s table\n", table)
+		check := verifyTable(session, table, earliest, latest)
+		check.Passed = check.Outside == 0 && check.Superseded == 0 && check.Errors == 0
+		report.Passed = report.Passed && check.Passed
+		report.Tables = append(report.Tables, check)
+	}
+
+	printVerifyReport(report)
+
+	if *verifyOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*verifyOut, data, 0644)
+		}
+		if err != nil {
+			log.Printf("ERROR failed writing verify report: %s\n", err)
+		} else {
+			log.Printf("Verify report written to %s\n", *verifyOut)
+		}
+	}
+
+	if !report.Passed {
+		os.Exit(exitVerifyFailed)
+	}
+}
+
+// checkLedgerRange makes sure ledger_range is consistent and that its ends are in the ledgers table
+func checkLedgerRange(session *gocql.Session, earliest uint64, latest uint64) []string {
+	var problems []string
+	if earliest > latest {
+		problems = append(problems, fmt.Sprintf("earliest ledger %d is after latest ledger %d", earliest, latest))
+	}
+
+	for _, seq := range []uint64{earliest, latest} {
+		_, found, err := ledgerCloseTime(session, seq)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed reading ledger %d: %s", seq, err))
+		} else if !found {
+			problems = append(problems, fmt.Sprintf("ledger %d of ledger_range is not in the ledgers table", seq))
+		}
+	}
+
+	return problems
+}
+
+func verifyTable(session *gocql.Session, table string, earliest uint64, latest uint64) *tableCheck {
+	check := &tableCheck{Table: table}
+
+	// scanned tables select the key and the sequence, the others the sequence only
+	query, keyed, versioned := queryTemplates[table].Scan, true, queryTemplates[table].Versioned
+	if query == "" {
+		query, keyed = queryTemplates[table].Partitions, false
+	}
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for _, r := range ranges {
+		rangesChannel <- r
+	}
+	close(rangesChannel)
+
+	tableProgress := startProgress(table, "verify", uint64(len(ranges)), "token ranges", "rows")
+	defer tableProgress.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			for r := range rangesChannel {
+				rows, outside, superseded, examples, err := verifyTokenRange(session, query, r, keyed, versioned, earliest, latest)
+				if err != nil {
+					log.Printf("ERROR: verify query failed: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", fmt.Sprintf("%s [from=%d][to=%d]", query, r.StartRange, r.EndRange))
+					check.mu.Lock()
+					check.Errors++
+					check.mu.Unlock()
+				}
+
+				check.add(rows, outside, superseded, examples)
+				tableProgress.addItems(rows)
+				tableProgress.addSteps(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return check
+}
+
+// verifyTokenRange counts the rows of a token range outside of the ledger range. Versioned tables may keep
+// rows before the earliest ledger, but only the newest of every key
+func verifyTokenRange(session *gocql.Session, query string, r *cassandra.TokenRange, keyed bool, versioned bool, earliest uint64, latest uint64) (uint64, uint64, uint64, []string, error) {
+	var rows, outside, superseded uint64
+	var examples []string
+
+	var lastKey []byte
+	var atOrBeforeEarliest uint64
+	flushKey := func() {
+		if atOrBeforeEarliest > 1 {
+			superseded += atOrBeforeEarliest - 1
+			if len(examples) < maxVerifyExamples {
+				examples = append(examples, fmt.Sprintf("key=%x has %d versions at or before ledger %d", lastKey, atOrBeforeEarliest, earliest))
+			}
+		}
+		atOrBeforeEarliest = 0
+	}
+
+	var key []byte
+	var seq uint64
+	scanner := session.Query(query, r.StartRange, r.EndRange).PageSize(*clusterPageSize).Iter().Scanner()
+	for scanner.Next() {
+		var err error
+		if keyed {
+			err = scanner.Scan(&key, &seq)
+		} else {
+			err = scanner.Scan(&seq)
+		}
+		if err != nil {
+			return rows, outside, superseded, examples, err
+		}
+		rows++
+
+		if versioned {
+			if !bytes.Equal(key, lastKey) {
+				flushKey()
+				lastKey = append(lastKey[:0], key...)
+			}
+			if seq <= earliest {
+				atOrBeforeEarliest++
+				continue
+			}
+		}
+
+		if seq < earliest || seq > latest {
+			outside++
+			if len(examples) < maxVerifyExamples {
+				if keyed {
+					examples = append(examples, fmt.Sprintf("key=%x seq=%d", key, seq))
+				} else {
+					examples = append(examples, fmt.Sprintf("seq=%d", seq))
+				}
+			}
+		}
+	}
+	flushKey()
+
+	return rows, outside, superseded, examples, scanner.Err()
+}
+
+func printVerifyReport(report *verifyReport) {
+	fmt.Printf("\nVerification of keyspace %s, ledger range %d:%d\n", report.Keyspace, report.Earliest, report.Latest)
+	fmt.Println("=====================")
+
+	for _, problem := range report.LedgerRange {
+		fmt.Printf("ledger_range: %s\n", problem)
+	}
+
+	fmt.Printf("%-20s %6s %14s %10s %10s %8s\n", "table", "", "rows", "outside", "superseded", "errors")
+	for _, c := range report.Tables {
+		verdict := "PASS"
+		if !c.Passed {
+			verdict = "FAIL"
+		}
+		fmt.Printf("%-20s %6s %14d %10d %10d %8d\n", c.Table, verdict, c.Rows, c.Outside, c.Superseded, c.Errors)
+		for _, e := range c.Examples {
+			fmt.Printf("    %s\n", e)
+		}
+	}
+
+	if report.Passed {
+		fmt.Println("\nPASSED: no rows outside of the ledger range")
+	} else {
+		fmt.Println("\nFAILED: see the tables above; stop the writers before verifying, they write ahead of ledger_range")
+	}
+}
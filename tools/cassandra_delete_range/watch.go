@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	rippleEpoch = 946684800
+
+	// offset of the close time in a ledger header blob: sequence, drops, 3 hashes and the parent close time
+	closeTimeOffset = 4 + 8 + 32*3 + 4
+
+	// upper bound of ledgers probed past the previous tip in one poll
+	maxTipProbe = 10000
+)
+
+type watchSample struct {
+	Time         time.Time `json:"time"`
+	First        uint64    `json:"first"`
+	Latest       uint64    `json:"latest"`
+	Tip          uint64    `json:"tip"`
+	TipCloseTime time.Time `json:"tip_close_time,omitempty"`
+	TipAgeSec    float64   `json:"tip_age_s"`
+	Rate         float64   `json:"ledgers_per_minute"`
+	AverageRate  float64   `json:"average_ledgers_per_minute"`
+	Status       string    `json:"status"`
+}
+
+func ledgerCloseTime(session *gocql.Session, seq uint64) (time.Time, bool, error) {
+	var header []byte
+	if err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&header); err != nil {
+		if err == gocql.ErrNotFound {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+
+	if len(header) < closeTimeOffset+4 {
+		return time.Time{}, false, fmt.Errorf("ledger header of %d is too short", seq)
+	}
+
+	closeTime := binary.BigEndian.Uint32(header[closeTimeOffset : closeTimeOffset+4])
+	return time.Unix(int64(closeTime)+rippleEpoch, 0).UTC(), true, nil
+}
+
+// lastLedgerBefore binary searches the ledgers table for the last ledger between earliest and latest that
+// closed before t; ledgers missing from the table are skipped
+func lastLedgerBefore(session *gocql.Session, earliest uint64, latest uint64, t time.Time) (uint64, time.Time, bool, error) {
+	var last uint64
+	var lastCloseTime time.Time
+	found := false
+
+	lo, hi := earliest, latest
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+
+		// the first ledger at or after mid that the table has
+		seq := mid
+		closeTime, ok, err := ledgerCloseTime(session, seq)
+		for ; err == nil && !ok && seq < hi; closeTime, ok, err = ledgerCloseTime(session, seq) {
+			seq++
+		}
+		if err != nil {
+			return 0, time.Time{}, false, err
+		}
+
+		if ok && closeTime.Before(t) {
+			last, lastCloseTime, found = seq, closeTime, true
+			lo = seq + 1
+		} else {
+			if mid == 0 {
+				break
+			}
+			hi = mid - 1
+		}
+	}
+
+	return last, lastCloseTime, found, nil
+}
+
+// finds the highest ledger written after from, which can be ahead of ledger_range while a writer is
+// in the middle of writing a ledger
+func findTip(session *gocql.Session, from uint64) (uint64, time.Time, error) {
+	tip := from
+	closeTime, _, err := ledgerCloseTime(session, from)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	for seq := from + 1; seq <= from+maxTipProbe; seq++ {
+		t, found, err := ledgerCloseTime(session, seq)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if !found {
+			break
+		}
+		tip, closeTime = seq, t
+	}
+
+	return tip, closeTime, nil
+}
+
+func runWatch(cluster *gocql.ClusterConfig) {
+	session, err := createSession(cluster)
+	if err != nil {
+		fatal(exitConnection, err)
+	}
+
+	defer session.Close()
+
+	var out *os.File
+	if *watchOut != "" {
+		if out, err = os.OpenFile(*watchOut, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+
+	var first, prev *watchSample
+	for polls := 0; *watchCount == 0 || polls < *watchCount; polls++ {
+		if polls > 0 {
+			time.Sleep(*watchInterval)
+		}
+
+		s := watchSample{Time: time.Now().UTC()}
+		if err := session.Query("select sequence from ledger_range where is_latest = ?", false).Scan(&s.First); err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED QUERY: select sequence from ledger_range [is_latest=false]: %s\n", err)
+			continue
+		}
+		if err := session.Query("select sequence from ledger_range where is_latest = ?", true).Scan(&s.Latest); err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED QUERY: select sequence from ledger_range [is_latest=true]: %s\n", err)
+			continue
+		}
+
+		from := s.Latest
+		if prev != nil && prev.Tip > from {
+			from = prev.Tip
+		}
+		if s.Tip, s.TipCloseTime, err = findTip(session, from); err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED QUERY: SELECT header FROM ledgers [from=%d]: %s\n", from, err)
+			continue
+		}
+
+		if !s.TipCloseTime.IsZero() {
+			s.TipAgeSec = s.Time.Sub(s.TipCloseTime).Seconds()
+		}
+
+		if prev != nil && prev.Latest > s.Latest {
+			log.Printf("ledger_range moved back from %d to %d\n", prev.Latest, s.Latest)
+			first = nil
+		}
+
+		if prev != nil && first != nil {
+			s.Rate = float64(int64(s.Latest)-int64(prev.Latest)) / s.Time.Sub(prev.Time).Minutes()
+			s.AverageRate = float64(int64(s.Latest)-int64(first.Latest)) / s.Time.Sub(first.Time).Minutes()
+		}
+
+		switch {
+		case first == nil:
+			s.Status = "started"
+		case s.Latest > prev.Latest:
+			s.Status = "ingesting"
+		case s.TipCloseTime.IsZero() || s.TipAgeSec > watchMaxAge.Seconds():
+			s.Status = "stalled"
+		default:
+			s.Status = "waiting"
+		}
+
+		log.Printf("range %d -> %d, tip %d (%d ahead of range), tip age %.0fs, %.1f ledgers/min (average %.1f): %s\n",
+			s.First, s.Latest, s.Tip, s.Tip-s.Latest, s.TipAgeSec, s.Rate, s.AverageRate, s.Status)
+
+		if out != nil {
+			data, _ := json.Marshal(s)
+			if _, err := out.Write(append(data, '\n')); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if first == nil {
+			first = &s
+		}
+		prev = &s
+	}
+}
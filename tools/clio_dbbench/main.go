@@ -0,0 +1,458 @@
+//
+// Benchmarks Clio's Cassandra read patterns directly against a keyspace, without the Clio server.
+//
+// Keys, transaction hashes and accounts are sampled from the keyspace first, then a configurable mix of
+// the queries Clio issues is run with a fixed number of workers:
+//
+//	successor   a walk of --walk-length successor lookups starting at a sampled key (book/ledger_data walks)
+//	object      an object point read at a random sequence in the available range
+//	account_tx  a page of account_tx for a sampled account, following up to --pages pages
+//	tx          a transaction lookup by hash
+//
+// Latencies are reported per operation as percentiles and a histogram; --json writes them as a report.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+)
+
+var (
+	clusterHosts          = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	clusterFlags          = cassandra.RegisterFlags(kingpin.CommandLine)
+	clusterNumConnections = kingpin.Flag("cluster-number-of-connections", "Number of connections per host").Short('b').Default("2").Int()
+	keyspace              = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+
+	concurrency = kingpin.Flag("concurrency", "Number of concurrent workers").Short('c').Default("32").Int()
+	duration    = kingpin.Flag("duration", "How long to run the benchmark for").Short('d').Default("60s").Duration()
+	warmup      = kingpin.Flag("warmup", "Run this long before recording latencies").Default("5s").Duration()
+	mix         = kingpin.Flag("mix", "Weights of the operations, i.e. successor=1,object=4,account_tx=2,tx=2").Default("successor=1,object=4,account_tx=2,tx=2").String()
+	walkLength  = kingpin.Flag("walk-length", "Number of successor lookups in one walk").Default("20").Int()
+	pageLimit   = kingpin.Flag("page-limit", "Rows per account_tx page, like the limit of account_tx requests").Default("200").Int()
+	pages       = kingpin.Flag("pages", "Maximum number of account_tx pages read per operation").Default("1").Int()
+	sampleSize  = kingpin.Flag("sample-size", "Number of keys, hashes and accounts to sample before running").Default("10000").Int()
+	seed        = kingpin.Flag("seed", "Seed for sampling and the operation mix").Default("1").Int64()
+	jsonOut     = kingpin.Flag("json", "Write results as JSON to this file").String()
+)
+
+var operations = []string{"successor", "object", "account_tx", "tx"}
+
+type samples struct {
+	minSeq   uint32
+	maxSeq   uint32
+	keys     [][]byte
+	hashes   [][]byte
+	accounts [][]byte
+}
+
+type recorder struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	rows      map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: make(map[string][]time.Duration), errors: make(map[string]int), rows: make(map[string]int)}
+}
+
+func parseMix(s string) ([]string, []int, error) {
+	var names []string
+	var weights []int
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("bad mix entry %q", part)
+		}
+
+		known := false
+		for _, op := range operations {
+			known = known || op == name
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("unknown operation %q", name)
+		}
+
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, nil, fmt.Errorf("bad weight for %s", name)
+		}
+
+		if w > 0 {
+			names = append(names, name)
+			weights = append(weights, w)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no operation has a positive weight")
+	}
+	return names, weights, nil
+}
+
+// collects keys from the diffs, transaction hashes and accounts of random ledgers in the range
+func sample(session *gocql.Session, rng *rand.Rand) (*samples, error) {
+	s := &samples{}
+
+	first, latest, err := cassandra.GetLedgerRange(session)
+	if err == gocql.ErrNotFound {
+		return nil, fmt.Errorf("ledger_range is incomplete")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.minSeq, s.maxSeq = uint32(first), uint32(latest)
+
+	for attempts := 0; attempts < *sampleSize && (len(s.keys) < *sampleSize || len(s.hashes) < *sampleSize); attempts++ {
+		ledger := s.minSeq + uint32(rng.Int63n(int64(s.maxSeq-s.minSeq)+1))
+
+		iter := session.Query("SELECT key FROM diff WHERE seq = ?", ledger).Iter()
+		var key []byte
+		for iter.Scan(&key) && len(s.keys) < *sampleSize {
+			s.keys = append(s.keys, key)
+			key = nil
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+
+		iter = session.Query("SELECT hash FROM ledger_transactions WHERE ledger_sequence = ?", ledger).Iter()
+		var hash []byte
+		for iter.Scan(&hash) && len(s.hashes) < *sampleSize {
+			s.hashes = append(s.hashes, hash)
+			hash = nil
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	// jump to random tokens so accounts are spread over the whole ring
+	for attempts := 0; attempts < *sampleSize && len(s.accounts) < *sampleSize; attempts++ {
+		iter := session.Query("SELECT account FROM account_tx WHERE token(account) > ? PER PARTITION LIMIT 1 LIMIT 100", rng.Int63()-rng.Int63()).Iter()
+		var account []byte
+		found := 0
+		for iter.Scan(&account) && len(s.accounts) < *sampleSize {
+			s.accounts = append(s.accounts, account)
+			account = nil
+			found++
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+
+		if found == 0 && attempts > 100 {
+			break
+		}
+	}
+
+	return s, nil
+}
+
+func pick(rng *rand.Rand, values [][]byte) []byte {
+	return values[rng.Intn(len(values))]
+}
+
+func (s *samples) randomSeq(rng *rand.Rand) uint32 {
+	return s.minSeq + uint32(rng.Int63n(int64(s.maxSeq-s.minSeq)+1))
+}
+
+// runs one operation, returning the number of rows read
+func run(session *gocql.Session, rng *rand.Rand, s *samples, op string) (int, error) {
+	switch op {
+	case "successor":
+		key := pick(rng, s.keys)
+		seq := s.randomSeq(rng)
+		for i := 0; i < *walkLength; i++ {
+			var next []byte
+			err := session.Query("SELECT next FROM successor WHERE key = ? AND seq <= ? ORDER BY seq DESC LIMIT 1", key, seq).Scan(&next)
+			if err == gocql.ErrNotFound {
+				return i, nil
+			}
+			if err != nil {
+				return i, err
+			}
+			key = next
+		}
+		return *walkLength, nil
+
+	case "object":
+		var object []byte
+		var sequence int64
+		err := session.Query("SELECT object, sequence FROM objects WHERE key = ? AND sequence <= ? ORDER BY sequence DESC LIMIT 1", pick(rng, s.keys), s.randomSeq(rng)).Scan(&object, &sequence)
+		if err == gocql.ErrNotFound {
+			return 0, nil
+		}
+		return 1, err
+
+	case "account_tx":
+		account := pick(rng, s.accounts)
+		cursor := []interface{}{int64(math.MaxUint32), int64(math.MaxUint32)}
+		rows := 0
+		for page := 0; page < *pages; page++ {
+			iter := session.Query("SELECT hash, seq_idx FROM account_tx WHERE account = ? AND seq_idx < ? LIMIT ?", account, cursor, *pageLimit).Iter()
+			var hash []byte
+			var seq, idx int64
+			n := 0
+			for iter.Scan(&hash, &seq, &idx) {
+				n++
+			}
+			if err := iter.Close(); err != nil {
+				return rows, err
+			}
+
+			rows += n
+			if n < *pageLimit {
+				break
+			}
+			cursor = []interface{}{seq, idx}
+		}
+		return rows, nil
+
+	case "tx":
+		var tx, meta []byte
+		var ledgerSeq, date int64
+		err := session.Query("SELECT transaction, metadata, ledger_sequence, date FROM transactions WHERE hash = ?", pick(rng, s.hashes)).Scan(&tx, &meta, &ledgerSeq, &date)
+		if err == gocql.ErrNotFound {
+			return 0, nil
+		}
+		return 1, err
+	}
+
+	return 0, fmt.Errorf("unknown operation %s", op)
+}
+
+type opResult struct {
+	Operation string         `json:"operation"`
+	Count     int            `json:"count"`
+	Errors    int            `json:"errors"`
+	Rows      int            `json:"rows"`
+	OpsPerSec float64        `json:"ops_per_sec"`
+	MinMS     float64        `json:"min_ms"`
+	P50MS     float64        `json:"p50_ms"`
+	P90MS     float64        `json:"p90_ms"`
+	P99MS     float64        `json:"p99_ms"`
+	P999MS    float64        `json:"p999_ms"`
+	MaxMS     float64        `json:"max_ms"`
+	Histogram map[string]int `json:"histogram"`
+}
+
+var bucketBounds = []time.Duration{
+	time.Millisecond / 2, time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	500 * time.Millisecond, time.Second,
+}
+
+func bucketLabel(i int) string {
+	if i == len(bucketBounds) {
+		return ">" + bucketBounds[i-1].String()
+	}
+	return "<=" + bucketBounds[i].String()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+func summarize(op string, latencies []time.Duration, errors int, rows int, elapsed time.Duration) opResult {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	r := opResult{Operation: op, Count: len(latencies), Errors: errors, Rows: rows, Histogram: make(map[string]int)}
+	r.OpsPerSec = float64(len(latencies)) / elapsed.Seconds()
+	if len(latencies) == 0 {
+		return r
+	}
+
+	r.MinMS = ms(latencies[0])
+	r.P50MS = ms(percentile(latencies, 0.5))
+	r.P90MS = ms(percentile(latencies, 0.9))
+	r.P99MS = ms(percentile(latencies, 0.99))
+	r.P999MS = ms(percentile(latencies, 0.999))
+	r.MaxMS = ms(latencies[len(latencies)-1])
+
+	for _, l := range latencies {
+		i := sort.Search(len(bucketBounds), func(i int) bool { return l <= bucketBounds[i] })
+		r.Histogram[bucketLabel(i)]++
+	}
+
+	return r
+}
+
+func printResult(r opResult) {
+	fmt.Printf("%s: %d ops (%.1f/s), %d errors, %d rows\n", r.Operation, r.Count, r.OpsPerSec, r.Errors, r.Rows)
+	fmt.Printf("  min %.2fms  p50 %.2fms  p90 %.2fms  p99 %.2fms  p99.9 %.2fms  max %.2fms\n", r.MinMS, r.P50MS, r.P90MS, r.P99MS, r.P999MS, r.MaxMS)
+
+	for i := 0; i <= len(bucketBounds); i++ {
+		n := r.Histogram[bucketLabel(i)]
+		if n == 0 {
+			continue
+		}
+		bar := strings.Repeat("#", int(math.Ceil(50*float64(n)/float64(r.Count))))
+		fmt.Printf("  %8s %8d %s\n", bucketLabel(i), n, bar)
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	names, weights, err := parseMix(*mix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+	cluster.NumConns = *clusterNumConnections
+	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	rng := rand.New(rand.NewSource(*seed))
+	log.Printf("Sampling up to %d keys, hashes and accounts...\n", *sampleSize)
+	s, err := sample(session, rng)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Sampled %d keys, %d transaction hashes and %d accounts from ledgers %d -> %d\n", len(s.keys), len(s.hashes), len(s.accounts), s.minSeq, s.maxSeq)
+
+	available := map[string]bool{"successor": len(s.keys) > 0, "object": len(s.keys) > 0, "account_tx": len(s.accounts) > 0, "tx": len(s.hashes) > 0}
+	totalWeight := 0
+	for i, name := range names {
+		if !available[name] {
+			log.Printf("No samples for %s, dropping it from the mix\n", name)
+			weights[i] = 0
+		}
+		totalWeight += weights[i]
+	}
+
+	if totalWeight == 0 {
+		log.Fatal("Nothing left to benchmark")
+	}
+
+	log.Printf("Running %s with %d workers after a %s warmup (mix %s)\n", *duration, *concurrency, *warmup, *mix)
+
+	start := time.Now()
+	recordFrom := start.Add(*warmup)
+	end := recordFrom.Add(*duration)
+
+	var wg sync.WaitGroup
+	var completed uint64
+	recorders := make([]*recorder, *concurrency)
+	for w := 0; w < *concurrency; w++ {
+		recorders[w] = newRecorder()
+		wg.Add(1)
+
+		go func(r *recorder, rng *rand.Rand) {
+			defer wg.Done()
+			for {
+				now := time.Now()
+				if now.After(end) {
+					return
+				}
+
+				pickWeight := rng.Intn(totalWeight)
+				op := names[0]
+				for i, weight := range weights {
+					if pickWeight < weight {
+						op = names[i]
+						break
+					}
+					pickWeight -= weight
+				}
+
+				rows, err := run(session, rng, s, op)
+				if now.Before(recordFrom) {
+					continue
+				}
+
+				if err != nil {
+					r.errors[op]++
+					fmt.Fprintf(os.Stderr, "FAILED QUERY (%s): %s\n", op, err)
+					continue
+				}
+
+				r.latencies[op] = append(r.latencies[op], time.Since(now))
+				r.rows[op] += rows
+				atomic.AddUint64(&completed, 1)
+			}
+		}(recorders[w], rand.New(rand.NewSource(*seed+int64(w)+1)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(10 * time.Second)
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			log.Printf("... %d operations recorded ...\n", atomic.LoadUint64(&completed))
+		}
+	}
+	ticker.Stop()
+
+	var results []opResult
+	for _, op := range names {
+		var latencies []time.Duration
+		errors, rows := 0, 0
+		for _, r := range recorders {
+			latencies = append(latencies, r.latencies[op]...)
+			errors += r.errors[op]
+			rows += r.rows[op]
+		}
+
+		result := summarize(op, latencies, errors, rows, *duration)
+		results = append(results, result)
+		printResult(result)
+	}
+
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(map[string]interface{}{
+			"keyspace":    *keyspace,
+			"concurrency": *concurrency,
+			"duration_s":  duration.Seconds(),
+			"mix":         *mix,
+			"operations":  results,
+		}, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile(*jsonOut, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
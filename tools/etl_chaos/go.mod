@@ -0,0 +1,15 @@
+module xrplf/clio/etl_chaos
+
+go 1.21.6
+
+require (
+	github.com/alecthomas/kingpin/v2 v2.4.0
+	xrplf/clio/xrpl v0.0.0
+)
+
+require (
+	github.com/alecthomas/units v0.0.0-20211218093645-b94a6e3cc137 // indirect
+	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
+)
+
+replace xrplf/clio/xrpl => ../xrpl
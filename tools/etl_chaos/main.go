@@ -0,0 +1,526 @@
+//
+// Injects faults into Clio's ETL sources on a schedule and checks that Clio keeps publishing ledgers.
+//
+// Sources are given as name=target. A target starting with http is the control API of a fake_rippled
+// instance, through which outages, slow and failing gRPC responses, corrupt or truncated ledger data,
+// paused ledger closes and websocket disconnects are injected. Any other target is a command that is
+// run as `<command> <fault> start|stop <json parameters>` to control a real source, e.g. a script that
+// pauses a container or drops traffic with iptables.
+//
+// The schedule is a JSON array of events:
+//
+//	[{"at": "30s", "source": "a", "fault": "outage", "duration": "1m"},
+//	 {"at": "2m", "source": "b", "fault": "slow", "delay_ms": 3000, "duration": "30s"},
+//	 {"at": "3m", "source": "a", "fault": "corrupt", "rate": 0.5, "duration": "30s"}]
+//
+// Faults are outage, slow, errors, corrupt, truncate, pause and disconnect (which has no duration).
+//
+// While the schedule runs Clio's validated ledger is polled. Whenever at least one source is healthy,
+// Clio must publish a new ledger within --sla of a fault starting or ending, and must never stall for
+// longer than --sla. With --log the Clio log file is followed and matching lines are counted per event.
+// All faults are cleared at the end, including on Ctrl-C.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	clioURL      = kingpin.Flag("clio", "JSON-RPC URL of the Clio server").Required().String()
+	sourceFlags  = kingpin.Flag("source", "ETL source as name=control URL of a fake_rippled, or name=command; can be repeated").Required().Strings()
+	scheduleFile = kingpin.Flag("schedule", "JSON file with the fault schedule").Required().ExistingFile()
+	sla          = kingpin.Flag("sla", "Maximum time without a new validated ledger while a source is healthy").Default("30s").Duration()
+	pollInterval = kingpin.Flag("poll", "Interval between polls of Clio's validated ledger").Default("1s").Duration()
+	settle       = kingpin.Flag("settle", "Time to keep monitoring after the last event ended").Default("30s").Duration()
+	logFile      = kingpin.Flag("log", "Clio log file to follow").String()
+	logPattern   = kingpin.Flag("log-pattern", "Regular expression selecting interesting log lines").Default(`ETL:(WRN|ERR|FTL)|Backend:(ERR|FTL)`).String()
+	reportTo     = kingpin.Flag("report", "Write a JSON report to this file").String()
+)
+
+var control = &http.Client{Timeout: 10 * time.Second}
+
+type event struct {
+	At       string  `json:"at"`
+	Source   string  `json:"source"`
+	Fault    string  `json:"fault"`
+	Duration string  `json:"duration"`
+	DelayMs  int     `json:"delay_ms,omitempty"`
+	Rate     float64 `json:"rate,omitempty"`
+
+	at, duration time.Duration
+	started      time.Time
+	ended        time.Time
+	recovered    *int64
+	logStart     int
+	logLines     int
+}
+
+type source struct {
+	name   string
+	target string
+	faults map[string]bool
+}
+
+func (s *source) healthy() bool {
+	return len(s.faults) == 0
+}
+
+type finding struct {
+	Kind    string `json:"kind"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+type eventResult struct {
+	Source      string     `json:"source"`
+	Fault       string     `json:"fault"`
+	Started     time.Time  `json:"started"`
+	Ended       *time.Time `json:"ended,omitempty"`
+	RecoveredMs *int64     `json:"first_ledger_after_ms,omitempty"`
+	LogLines    int        `json:"log_lines"`
+}
+
+type report struct {
+	Tool          string        `json:"tool"`
+	LedgerIndex   uint64        `json:"ledger_index"`
+	Passed        bool          `json:"passed"`
+	LongestStallS float64       `json:"longest_stall_s"`
+	Events        []eventResult `json:"events"`
+	Findings      []finding     `json:"findings"`
+}
+
+// fields of fake_rippled's faults to set when a fault starts; they are reset to their zero value when it stops
+func faultFields(e *event) (map[string]interface{}, error) {
+	switch e.Fault {
+	case "outage":
+		return map[string]interface{}{"outage": true}, nil
+	case "pause":
+		return map[string]interface{}{"paused": true}, nil
+	case "slow":
+		return map[string]interface{}{"grpc_delay_ms": e.DelayMs}, nil
+	case "errors":
+		return map[string]interface{}{"grpc_error_rate": e.Rate}, nil
+	case "corrupt":
+		return map[string]interface{}{"corrupt_rate": e.Rate}, nil
+	case "truncate":
+		return map[string]interface{}{"truncate_rate": e.Rate}, nil
+	}
+	return nil, fmt.Errorf("unknown fault %q", e.Fault)
+}
+
+func post(url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := control.Post(url, "application/json", strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		out, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s *source) apply(e *event, start bool) error {
+	if !strings.HasPrefix(s.target, "http") {
+		action := "stop"
+		if start {
+			action = "start"
+		}
+		params, _ := json.Marshal(e)
+		out, err := exec.Command(s.target, e.Fault, action, string(params)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	base := strings.TrimRight(s.target, "/")
+	if e.Fault == "disconnect" {
+		if start {
+			return post(base+"/disconnect", nil)
+		}
+		return nil
+	}
+
+	fields, err := faultFields(e)
+	if err != nil {
+		return err
+	}
+
+	if !start {
+		for k, v := range fields {
+			switch v.(type) {
+			case bool:
+				fields[k] = false
+			default:
+				fields[k] = 0
+			}
+		}
+	}
+
+	return post(base+"/faults", fields)
+}
+
+func (s *source) clear() error {
+	if !strings.HasPrefix(s.target, "http") {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, strings.TrimRight(s.target, "/")+"/faults", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := control.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type monitor struct {
+	mu          sync.Mutex
+	lastSeq     uint64
+	lastChange  time.Time
+	longest     time.Duration
+	stallSince  time.Time
+	anyHealthy  func() bool
+	findings    []finding
+	stallRaised bool
+	logLines    int
+}
+
+func (m *monitor) observe(seq uint64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if seq > m.lastSeq {
+		m.lastSeq = seq
+		m.lastChange = now
+		m.stallRaised = false
+		return
+	}
+
+	// stalls only count while Clio has a healthy source to fail over to
+	if !m.anyHealthy() {
+		m.lastChange = now
+		return
+	}
+
+	stall := now.Sub(m.lastChange)
+	if stall > m.longest {
+		m.longest = stall
+	}
+
+	if stall > *sla && !m.stallRaised {
+		m.stallRaised = true
+		m.findings = append(m.findings, finding{Kind: "stall", Message: fmt.Sprintf("no new ledger since %d for %s with a healthy source available", seq, stall.Round(time.Second))})
+		log.Printf("FAILED: Clio stuck at ledger %d for %s\n", seq, stall.Round(time.Second))
+	}
+}
+
+func (m *monitor) last() (uint64, time.Time, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSeq, m.lastChange, m.logLines
+}
+
+func followLog(ctx context.Context, path string, pattern *regexp.Regexp, m *monitor) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("ERROR: failed to open %s: %s\n", path, err)
+		return
+	}
+
+	defer f.Close()
+
+	f.Seek(0, io.SeekEnd)
+	reader := bufio.NewReader(f)
+	var partial string
+
+	for ctx.Err() == nil {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			partial += line
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		line = partial + line
+		partial = ""
+		if pattern.MatchString(line) {
+			m.mu.Lock()
+			m.logLines++
+			m.mu.Unlock()
+			log.Printf("LOG: %s", line)
+		}
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	sources := make(map[string]*source)
+	var names []string
+	for _, f := range *sourceFlags {
+		name, target, ok := strings.Cut(f, "=")
+		if !ok {
+			log.Fatalf("Source %q must be given as name=target", f)
+		}
+		sources[name] = &source{name: name, target: target, faults: make(map[string]bool)}
+		names = append(names, name)
+	}
+
+	data, err := os.ReadFile(*scheduleFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var schedule []*event
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		log.Fatalf("Invalid schedule: %s", err)
+	}
+
+	var end time.Duration
+	for _, e := range schedule {
+		if _, ok := sources[e.Source]; !ok {
+			log.Fatalf("Schedule refers to unknown source %q", e.Source)
+		}
+		if e.at, err = time.ParseDuration(e.At); err != nil {
+			log.Fatalf("Invalid time %q: %s", e.At, err)
+		}
+		if e.Fault != "disconnect" {
+			if e.duration, err = time.ParseDuration(e.Duration); err != nil {
+				log.Fatalf("Invalid duration %q: %s", e.Duration, err)
+			}
+			if _, err := faultFields(e); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if e.at+e.duration > end {
+			end = e.at + e.duration
+		}
+	}
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].at < schedule[j].at })
+
+	var mu sync.Mutex
+	anyHealthy := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range sources {
+			if s.healthy() {
+				return true
+			}
+		}
+		return false
+	}
+
+	clearAll := func() {
+		for _, name := range names {
+			if err := sources[name].clear(); err != nil {
+				log.Printf("ERROR: failed to clear faults of %s: %s\n", name, err)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := rpc.NewClient(*clioURL, *pollInterval+5*time.Second)
+	startSeq, err := client.ValidatedLedgerIndex(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get Clio's validated ledger: %s", err)
+	}
+
+	m := &monitor{lastSeq: startSeq, lastChange: time.Now(), anyHealthy: anyHealthy}
+
+	if *logFile != "" {
+		pattern, err := regexp.Compile(*logPattern)
+		if err != nil {
+			log.Fatalf("Invalid --log-pattern: %s", err)
+		}
+		go followLog(ctx, *logFile, pattern, m)
+	}
+
+	log.Printf("Clio at ledger %d, running %d events over %s with a %s SLA\n", startSeq, len(schedule), end, *sla)
+
+	startTime := time.Now()
+	runCtx, cancel := context.WithTimeout(ctx, end+*settle)
+	defer cancel()
+
+	// starts and stops of faults, in time order
+	type action struct {
+		at    time.Duration
+		e     *event
+		start bool
+	}
+	var actions []action
+	for _, e := range schedule {
+		actions = append(actions, action{e.at, e, true})
+		if e.Fault != "disconnect" {
+			actions = append(actions, action{e.at + e.duration, e, false})
+		}
+	}
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].at < actions[j].at })
+
+	// transitions after which Clio has to show progress within the SLA
+	type checkpoint struct {
+		at    time.Time
+		what  string
+		e     *event
+		start bool
+	}
+	var pending []checkpoint
+
+	ticker := time.NewTicker(*pollInterval)
+	defer ticker.Stop()
+
+	next := 0
+	for runCtx.Err() == nil {
+		now := time.Now()
+		for next < len(actions) && now.Sub(startTime) >= actions[next].at {
+			a := actions[next]
+			next++
+
+			s := sources[a.e.Source]
+			if err := s.apply(a.e, a.start); err != nil {
+				log.Printf("ERROR: failed to %s %s on %s: %s\n", map[bool]string{true: "start", false: "stop"}[a.start], a.e.Fault, s.name, err)
+				continue
+			}
+
+			mu.Lock()
+			if a.start {
+				a.e.started = now
+				if a.e.Fault != "disconnect" {
+					s.faults[a.e.Fault] = true
+				}
+			} else {
+				a.e.ended = now
+				delete(s.faults, a.e.Fault)
+			}
+			mu.Unlock()
+
+			_, _, logLines := m.last()
+			if a.start {
+				a.e.logStart = logLines
+			} else {
+				a.e.logLines = logLines - a.e.logStart
+			}
+
+			what := map[bool]string{true: "started", false: "stopped"}[a.start]
+			log.Printf("%s %s on %s\n", a.e.Fault, what, s.name)
+			pending = append(pending, checkpoint{at: now, what: fmt.Sprintf("%s %s on %s", a.e.Fault, what, s.name), e: a.e, start: a.start})
+		}
+
+		seq, err := client.ValidatedLedgerIndex(runCtx)
+		if err == nil {
+			m.observe(seq, time.Now())
+		} else if runCtx.Err() == nil {
+			log.Printf("ERROR: failed to poll Clio: %s\n", err)
+		}
+
+		lastSeq, lastChange, _ := m.last()
+		var remaining []checkpoint
+		for _, c := range pending {
+			switch {
+			case lastChange.After(c.at):
+				if c.start {
+					recovered := lastChange.Sub(c.at).Milliseconds()
+					c.e.recovered = &recovered
+				}
+				log.Printf("  Clio published %d %s after %s\n", lastSeq, lastChange.Sub(c.at).Round(time.Millisecond), c.what)
+			case !anyHealthy():
+				// nothing to fail over to, wait for a source to come back
+				c.at = time.Now()
+				remaining = append(remaining, c)
+			case time.Since(c.at) > *sla:
+				m.mu.Lock()
+				m.findings = append(m.findings, finding{Kind: "sla", Object: c.e.Source, Message: fmt.Sprintf("no new ledger within %s after %s", *sla, c.what)})
+				m.mu.Unlock()
+				log.Printf("FAILED: no new ledger within %s after %s\n", *sla, c.what)
+			default:
+				remaining = append(remaining, c)
+			}
+		}
+		pending = remaining
+
+		select {
+		case <-runCtx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	clearAll()
+
+	rep := report{Tool: "etl_chaos", LedgerIndex: m.lastSeq, LongestStallS: m.longest.Seconds(), Findings: m.findings}
+	if rep.Findings == nil {
+		rep.Findings = []finding{}
+	}
+	for _, e := range schedule {
+		if e.ended.IsZero() && !e.started.IsZero() {
+			e.logLines = m.logLines - e.logStart
+		}
+		result := eventResult{Source: e.Source, Fault: e.Fault, Started: e.started, RecoveredMs: e.recovered, LogLines: e.logLines}
+		if !e.ended.IsZero() {
+			result.Ended = &e.ended
+		}
+		rep.Events = append(rep.Events, result)
+	}
+	rep.Passed = len(rep.Findings) == 0
+
+	log.Println()
+	for _, e := range rep.Events {
+		recovered := "never"
+		if e.RecoveredMs != nil {
+			recovered = fmt.Sprintf("%dms", *e.RecoveredMs)
+		}
+		log.Printf("%-10s %-10s first ledger after %8s, %d log lines\n", e.Source, e.Fault, recovered, e.LogLines)
+	}
+	log.Printf("Clio went from ledger %d to %d\n", startSeq, m.lastSeq)
+	log.Printf("LONGEST STALL WITH A HEALTHY SOURCE: %s\n", m.longest.Round(time.Second))
+	log.Printf("TOTAL SLA VIOLATIONS: %d\n", len(rep.Findings))
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !rep.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,353 @@
+//
+// Inspects the tip of a Clio keyspace for ledgers that were only partially written, i.e. because a
+// writer crashed in the middle of a ledger.
+//
+// Clio writes the data of a ledger to all tables first and updates ledger_range last, so data for
+// sequences above the latest ledger in ledger_range means a write was interrupted. The inspector
+// probes the last --window ledgers of the range and everything above it until --lookahead consecutive
+// sequences have no data, and reports per table the highest sequence with data and with complete data.
+//
+// A ledger counts as complete when its header and hash index are present, every transaction listed in
+// ledger_transactions exists, the transaction tree rebuilt from them hashes to the TxHash of the header,
+// the diff is not empty when there are transactions and the sampled diff keys have an object version at
+// the ledger.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	window       = kingpin.Flag("window", "Number of ledgers at the end of ledger_range to verify").Default("10").Uint32()
+	lookahead    = kingpin.Flag("lookahead", "Stop probing above ledger_range after this many consecutive empty sequences").Default("10").Uint32()
+	objectSample = kingpin.Flag("object-sample", "Number of diff keys per ledger checked for an object version").Default("100").Int()
+	reportTo     = kingpin.Flag("report", "Write a JSON report to this file").String()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+var tables = []string{"ledgers", "ledger_hashes", "ledger_transactions", "transactions", "diff", "objects"}
+
+type ledgerStatus struct {
+	Sequence        uint64   `json:"sequence"`
+	Header          bool     `json:"header"`
+	HashIndexed     bool     `json:"hash_indexed"`
+	TxHashes        int      `json:"ledger_transactions"`
+	TxPresent       int      `json:"transactions_present"`
+	TxExpected      int      `json:"transactions_expected"`
+	DiffKeys        int      `json:"diff_keys"`
+	ObjectsChecked  int      `json:"objects_checked"`
+	ObjectsAtLedger int      `json:"objects_at_ledger"`
+	Complete        bool     `json:"complete"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+func (s *ledgerStatus) hasData() bool {
+	return s.Header || s.HashIndexed || s.TxHashes > 0 || s.DiffKeys > 0 || s.ObjectsAtLedger > 0
+}
+
+func (s *ledgerStatus) tableHasData(table string) bool {
+	switch table {
+	case "ledgers":
+		return s.Header
+	case "ledger_hashes":
+		return s.HashIndexed
+	case "ledger_transactions":
+		return s.TxHashes > 0
+	case "transactions":
+		return s.TxPresent > 0
+	case "diff":
+		return s.DiffKeys > 0
+	case "objects":
+		return s.ObjectsAtLedger > 0
+	}
+	return false
+}
+
+type tableStatus struct {
+	HighestWithData uint64 `json:"highest_with_data"`
+	HighestComplete uint64 `json:"highest_complete"`
+}
+
+type report struct {
+	Tool           string                  `json:"tool"`
+	Passed         bool                    `json:"passed"`
+	RangeMin       uint64                  `json:"ledger_range_min"`
+	RangeMax       uint64                  `json:"ledger_range_max"`
+	HighestLedger  uint64                  `json:"highest_complete_ledger"`
+	PartialTip     bool                    `json:"partial_tip"`
+	Tables         map[string]*tableStatus `json:"tables"`
+	Ledgers        []*ledgerStatus         `json:"ledgers"`
+	Recommendation string                  `json:"recommendation"`
+}
+
+func probe(session *gocql.Session, seq uint64) (*ledgerStatus, error) {
+	s := &ledgerStatus{Sequence: seq, TxExpected: -1}
+
+	var header *xrpl.LedgerHeader
+	var headerBlob []byte
+	err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&headerBlob)
+	if err != nil && err != gocql.ErrNotFound {
+		return nil, err
+	}
+
+	if len(headerBlob) > 0 {
+		s.Header = true
+		decoded, err := xrpl.DecodeLedgerHeader(headerBlob)
+		if err != nil {
+			s.Problems = append(s.Problems, fmt.Sprintf("undecodable header: %s", err))
+		} else {
+			header = decoded
+			hash := header.Hash
+			if hash == nil {
+				hash = header.ComputeHash()
+			}
+
+			var indexed uint64
+			err := session.Query("SELECT sequence FROM ledger_hashes WHERE hash = ?", hash).Scan(&indexed)
+			switch {
+			case err == gocql.ErrNotFound:
+			case err != nil:
+				return nil, err
+			case indexed != seq:
+				s.Problems = append(s.Problems, fmt.Sprintf("ledger_hashes maps the hash to ledger %d", indexed))
+			default:
+				s.HashIndexed = true
+			}
+		}
+	}
+
+	var hashes [][]byte
+	iter := session.Query("SELECT hash FROM ledger_transactions WHERE ledger_sequence = ?", seq).Iter()
+	var hash []byte
+	for iter.Scan(&hash) {
+		hashes = append(hashes, hash)
+		hash = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	s.TxHashes = len(hashes)
+
+	maxIndex := int64(-1)
+	txs := make([]xrpl.TxWithMeta, 0, len(hashes))
+	for _, h := range hashes {
+		var tx xrpl.TxWithMeta
+		var ledgerSeq uint64
+		err := session.Query("SELECT transaction, metadata, ledger_sequence FROM transactions WHERE hash = ?", h).Scan(&tx.Transaction, &tx.Metadata, &ledgerSeq)
+		if err == gocql.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if ledgerSeq != seq {
+			s.Problems = append(s.Problems, fmt.Sprintf("transaction %X is recorded in ledger %d", h, ledgerSeq))
+			continue
+		}
+
+		s.TxPresent++
+		txs = append(txs, tx)
+		if meta, err := xrpl.Decode(tx.Metadata); err == nil {
+			if idx, ok := meta["TransactionIndex"].(int64); ok && idx > maxIndex {
+				maxIndex = idx
+			}
+		}
+	}
+
+	if maxIndex >= 0 {
+		s.TxExpected = int(maxIndex) + 1
+	}
+
+	var keys [][]byte
+	iter = session.Query("SELECT key FROM diff WHERE seq = ?", seq).Iter()
+	var key []byte
+	for iter.Scan(&key) {
+		keys = append(keys, key)
+		key = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	s.DiffKeys = len(keys)
+
+	for i, k := range keys {
+		if i >= *objectSample {
+			break
+		}
+
+		s.ObjectsChecked++
+		var objectSeq uint64
+		err := session.Query("SELECT sequence FROM objects WHERE key = ? AND sequence = ?", k, seq).Scan(&objectSeq)
+		if err == gocql.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.ObjectsAtLedger++
+	}
+
+	if !s.Header {
+		s.Problems = append(s.Problems, "header missing")
+	} else if !s.HashIndexed {
+		s.Problems = append(s.Problems, "ledger_hashes entry missing")
+	}
+
+	if s.TxPresent < s.TxHashes {
+		s.Problems = append(s.Problems, fmt.Sprintf("%d of %d transactions missing", s.TxHashes-s.TxPresent, s.TxHashes))
+	}
+
+	if s.TxExpected > s.TxHashes {
+		s.Problems = append(s.Problems, fmt.Sprintf("metadata indexes %d transactions but ledger_transactions lists %d", s.TxExpected, s.TxHashes))
+	}
+
+	// the indexes cannot show that the transactions with the highest indexes are missing from both
+	// ledger_transactions and transactions, so the transaction tree is rebuilt and compared with the header
+	if header != nil && len(txs) == s.TxHashes {
+		if root := xrpl.TransactionTreeHash(txs); !bytes.Equal(root, header.TxHash) {
+			s.Problems = append(s.Problems, fmt.Sprintf("transaction tree hashes to %X, header has %X", root, header.TxHash))
+		}
+	}
+
+	if s.TxHashes > 0 && s.DiffKeys == 0 {
+		s.Problems = append(s.Problems, "ledger has transactions but no diff")
+	}
+
+	if s.ObjectsAtLedger < s.ObjectsChecked {
+		s.Problems = append(s.Problems, fmt.Sprintf("%d of %d sampled diff keys have no object version at the ledger", s.ObjectsChecked-s.ObjectsAtLedger, s.ObjectsChecked))
+	}
+
+	s.Complete = len(s.Problems) == 0
+	return s, nil
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	rep := report{Tool: "etl_inspector", Tables: make(map[string]*tableStatus)}
+	if rep.RangeMin, rep.RangeMax, err = cassandra.GetLedgerRange(session); err != nil {
+		log.Fatalf("Failed to read ledger_range: %s", err)
+	}
+
+	for _, t := range tables {
+		rep.Tables[t] = &tableStatus{}
+	}
+
+	log.Printf("ledger_range claims %d -> %d\n", rep.RangeMin, rep.RangeMax)
+
+	start := rep.RangeMin
+	if rep.RangeMax-rep.RangeMin >= uint64(*window) {
+		start = rep.RangeMax - uint64(*window) + 1
+	}
+
+	var empty uint32
+	for seq := start; seq <= rep.RangeMax || empty < *lookahead; seq++ {
+		s, err := probe(session, seq)
+		if err != nil {
+			log.Fatalf("Failed to probe ledger %d: %s", seq, err)
+		}
+
+		if seq > rep.RangeMax {
+			if !s.hasData() {
+				empty++
+				continue
+			}
+			empty = 0
+		}
+
+		rep.Ledgers = append(rep.Ledgers, s)
+		for _, t := range tables {
+			if s.tableHasData(t) {
+				rep.Tables[t].HighestWithData = seq
+				if s.Complete {
+					rep.Tables[t].HighestComplete = seq
+				}
+			}
+		}
+
+		status := "complete"
+		if !s.Complete {
+			status = strings.Join(s.Problems, "; ")
+		}
+		log.Printf("  %d: %d txs, %d diff keys: %s\n", seq, s.TxHashes, s.DiffKeys, status)
+	}
+
+	// the highest ledger such that it and every ledger below it in the window are complete
+	for _, s := range rep.Ledgers {
+		if !s.Complete {
+			break
+		}
+		rep.HighestLedger = s.Sequence
+	}
+
+	var incompleteInRange []uint64
+	for _, s := range rep.Ledgers {
+		if s.Sequence > rep.RangeMax {
+			rep.PartialTip = true
+		} else if !s.Complete {
+			incompleteInRange = append(incompleteInRange, s.Sequence)
+		}
+	}
+
+	switch {
+	case len(incompleteInRange) > 0:
+		sort.Slice(incompleteInRange, func(i, j int) bool { return incompleteInRange[i] < incompleteInRange[j] })
+		keep := incompleteInRange[0] - 1
+		rep.Recommendation = fmt.Sprintf("ledgers inside ledger_range are incomplete; run cassandra_delete_range --ledgerIdx %d to delete everything after ledger %d and let Clio rewrite it", keep, keep)
+	case rep.PartialTip:
+		rep.Recommendation = fmt.Sprintf("partially written ledgers exist above ledger_range; run cassandra_delete_range --ledgerIdx %d before restarting writers", rep.RangeMax)
+	default:
+		rep.Recommendation = "no cleanup required"
+	}
+
+	rep.Passed = !rep.PartialTip && len(incompleteInRange) == 0
+
+	log.Println()
+	for _, t := range tables {
+		log.Printf("%-20s highest with data %d, highest complete %d\n", t, rep.Tables[t].HighestWithData, rep.Tables[t].HighestComplete)
+	}
+	log.Printf("\nRECOMMENDATION: %s\n", rep.Recommendation)
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !rep.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"xrplf/clio/xrpl"
+)
+
+// modification types of org.xrpl.rpc.v1.RawLedgerObject; objects of ledger data are unspecified
+const (
+	modUnspecified = 0
+	modCreated     = 1
+	modModified    = 2
+	modDeleted     = 3
+)
+
+type rawObject struct {
+	Key         []byte
+	Data        []byte
+	ModType     int
+	Predecessor []byte
+	Successor   []byte
+}
+
+type txPair struct {
+	Hash []byte
+	Tx   []byte
+	Meta []byte
+}
+
+type bookSuccessor struct {
+	BookBase  []byte
+	FirstBook []byte
+}
+
+type ledger struct {
+	Seq            uint32
+	Header         []byte
+	Hash           []byte
+	CloseTime      uint32
+	Drops          uint64
+	Txs            []txPair
+	Objects        []rawObject
+	BookSuccessors []bookSuccessor
+}
+
+// an immutable snapshot of the state map of one ledger
+type state struct {
+	keys    [][]byte
+	objects map[string][]byte
+}
+
+func newState() *state {
+	return &state{objects: make(map[string][]byte)}
+}
+
+func (s *state) get(key []byte) ([]byte, bool) {
+	v, ok := s.objects[string(key)]
+	return v, ok
+}
+
+// returns the index of the first key strictly greater than key
+func (s *state) upperBound(key []byte) int {
+	return sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], key) > 0 })
+}
+
+func (s *state) neighbors(key []byte) ([]byte, []byte) {
+	idx := sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], key) >= 0 })
+
+	var pred, succ []byte
+	if idx > 0 {
+		pred = s.keys[idx-1]
+	}
+
+	if idx < len(s.keys) && bytes.Equal(s.keys[idx], key) {
+		idx++
+	}
+
+	if idx < len(s.keys) {
+		succ = s.keys[idx]
+	}
+
+	return pred, succ
+}
+
+// produces the next state by applying a set of changes; deleted objects have empty data
+func (s *state) apply(changes []rawObject) *state {
+	next := &state{objects: make(map[string][]byte, len(s.objects)+len(changes))}
+	for k, v := range s.objects {
+		next.objects[k] = v
+	}
+
+	structural := false
+	for _, c := range changes {
+		_, existed := next.objects[string(c.Key)]
+		if len(c.Data) == 0 {
+			delete(next.objects, string(c.Key))
+			structural = structural || existed
+		} else {
+			next.objects[string(c.Key)] = c.Data
+			structural = structural || !existed
+		}
+	}
+
+	if !structural {
+		next.keys = s.keys
+		return next
+	}
+
+	next.keys = make([][]byte, 0, len(next.objects))
+	for k := range next.objects {
+		next.keys = append(next.keys, []byte(k))
+	}
+	sort.Slice(next.keys, func(i, j int) bool { return bytes.Compare(next.keys[i], next.keys[j]) < 0 })
+
+	return next
+}
+
+// returns the book base if data is the root page of an order book directory
+func bookBaseOf(key []byte, data []byte) []byte {
+	if t, err := xrpl.DecodeLedgerEntryType(data); err != nil || t != "DirectoryNode" {
+		return nil
+	}
+
+	dir, err := xrpl.Decode(data)
+	if err != nil {
+		return nil
+	}
+
+	if _, ok := dir["TakerPaysCurrency"]; !ok || dir["RootIndex"] != strings.ToUpper(hex.EncodeToString(key)) {
+		return nil
+	}
+
+	return xrpl.BookBaseOf(key)
+}
+
+// returns the first book directory of the book starting at base, or nil when the book is empty
+func (s *state) firstBook(base []byte) []byte {
+	idx := sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], base) >= 0 })
+	if idx < len(s.keys) && bytes.HasPrefix(s.keys[idx], base[:24]) {
+		return s.keys[idx]
+	}
+	return nil
+}
+
+// fills in mod types and neighbors of created/deleted objects, as rippled does with get_object_neighbors,
+// and collects the new first directory of every book whose directories were created or deleted
+func annotateChanges(prev *state, next *state, changes []rawObject) []bookSuccessor {
+	books := make(map[string]bool)
+	var successors []bookSuccessor
+
+	for i := range changes {
+		c := &changes[i]
+		prevData, existed := prev.get(c.Key)
+
+		var base []byte
+		switch {
+		case len(c.Data) == 0:
+			c.ModType = modDeleted
+			c.Predecessor, c.Successor = next.neighbors(c.Key)
+			base = bookBaseOf(c.Key, prevData)
+		case existed:
+			c.ModType = modModified
+		default:
+			c.ModType = modCreated
+			c.Predecessor, c.Successor = next.neighbors(c.Key)
+			base = bookBaseOf(c.Key, c.Data)
+		}
+
+		if base != nil && !books[string(base)] {
+			books[string(base)] = true
+			successors = append(successors, bookSuccessor{BookBase: base, FirstBook: next.firstBook(base)})
+		}
+	}
+
+	return successors
+}
+
+type ledgerSource interface {
+	// genesis returns the first ledger together with its complete state
+	genesis() (*ledger, *state, error)
+	// next builds the ledger following prev; ok is false when the source is exhausted
+	next(prev *ledger, prevState *state) (l *ledger, changes []rawObject, ok bool, err error)
+}
+
+type chain struct {
+	mu sync.RWMutex
+
+	source      ledgerSource
+	ledgers     map[uint32]*ledger
+	states      map[uint32]*state
+	first       uint32
+	last        uint32
+	keepLedgers uint32
+	keepStates  uint32
+	subscribers []func(*ledger)
+	exhausted   bool
+}
+
+func newChain(source ledgerSource, keepLedgers uint32, keepStates uint32) (*chain, error) {
+	l, s, err := source.genesis()
+	if err != nil {
+		return nil, err
+	}
+
+	return &chain{
+		source:      source,
+		ledgers:     map[uint32]*ledger{l.Seq: l},
+		states:      map[uint32]*state{l.Seq: s},
+		first:       l.Seq,
+		last:        l.Seq,
+		keepLedgers: keepLedgers,
+		keepStates:  keepStates,
+	}, nil
+}
+
+func (c *chain) onClose(f func(*ledger)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers = append(c.subscribers, f)
+}
+
+// advance closes the next ledger; returns false once the source has nothing more to serve
+func (c *chain) advance() (bool, error) {
+	c.mu.RLock()
+	if c.exhausted {
+		c.mu.RUnlock()
+		return false, nil
+	}
+	prev := c.ledgers[c.last]
+	prevState := c.states[c.last]
+	c.mu.RUnlock()
+
+	l, changes, ok, err := c.source.next(prev, prevState)
+	if err != nil || !ok {
+		c.mu.Lock()
+		c.exhausted = !ok
+		c.mu.Unlock()
+		return false, err
+	}
+
+	nextState := prevState.apply(changes)
+	l.BookSuccessors = annotateChanges(prevState, nextState, changes)
+	l.Objects = changes
+
+	c.mu.Lock()
+	c.ledgers[l.Seq] = l
+	c.states[l.Seq] = nextState
+	c.last = l.Seq
+
+	for seq := range c.states {
+		if seq+c.keepStates <= c.last {
+			delete(c.states, seq)
+		}
+	}
+
+	for seq := range c.ledgers {
+		if seq+c.keepLedgers <= c.last {
+			delete(c.ledgers, seq)
+		}
+	}
+
+	for c.ledgers[c.first] == nil && c.first < c.last {
+		c.first++
+	}
+
+	subscribers := c.subscribers
+	c.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(l)
+	}
+
+	return true, nil
+}
+
+func (c *chain) ledger(seq uint32) (*ledger, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l, ok := c.ledgers[seq]
+	return l, ok
+}
+
+func (c *chain) state(seq uint32) (*state, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.states[seq]
+	return s, ok
+}
+
+func (c *chain) latest() *ledger {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ledgers[c.last]
+}
+
+func (c *chain) validatedRange() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.first == c.last {
+		return fmt.Sprintf("%d", c.first)
+	}
+	return fmt.Sprintf("%d-%d", c.first, c.last)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// faults injected into the served data; changed at runtime through the control API
+type faults struct {
+	GRPCDelayMS   int     `json:"grpc_delay_ms"`
+	GRPCErrorRate float64 `json:"grpc_error_rate"`
+	TruncateRate  float64 `json:"truncate_rate"`
+	CorruptRate   float64 `json:"corrupt_rate"`
+	Paused        bool    `json:"paused"`
+	Outage        bool    `json:"outage"`
+}
+
+type faultState struct {
+	mu  sync.RWMutex
+	cur faults
+	rng *rand.Rand
+}
+
+func (f *faultState) get() faults {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cur
+}
+
+func (f *faultState) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < rate
+}
+
+func (f *faultState) rollIndex(n int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Intn(n)
+}
+
+type controlServer struct {
+	chain  *chain
+	faults *faultState
+	ws     *wsServer
+	closer func() error
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (c *controlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	latest := c.chain.latest()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"validated_ledgers": c.chain.validatedRange(),
+		"ledger_index":      latest.Seq,
+		"ledger_hash":       upperHex(latest.Hash),
+		"ws_clients":        c.ws.clientCount(),
+		"faults":            c.faults.get(),
+	})
+}
+
+// GET returns the active faults; POST merges the given fields into them
+func (c *controlServer) handleFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		c.faults.mu.Lock()
+		next := c.faults.cur
+		err := json.NewDecoder(r.Body).Decode(&next)
+		if err == nil {
+			c.faults.cur = next
+		}
+		c.faults.mu.Unlock()
+
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		log.Printf("Faults changed: %+v\n", next)
+	case http.MethodDelete:
+		c.faults.mu.Lock()
+		c.faults.cur = faults{}
+		c.faults.mu.Unlock()
+		log.Println("Faults cleared")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, c.faults.get())
+}
+
+func (c *controlServer) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := c.ws.disconnectAll()
+	log.Printf("Disconnected %d websocket clients\n", n)
+	writeJSON(w, http.StatusOK, map[string]int{"disconnected": n})
+}
+
+// closes a ledger immediately, regardless of the close interval and pause fault
+func (c *controlServer) handleClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := c.closer(); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]uint32{"ledger_index": c.chain.latest().Seq})
+}
+
+func serveControl(addr string, c *controlServer) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/faults", c.handleFaults)
+	mux.HandleFunc("/disconnect", c.handleDisconnect)
+	mux.HandleFunc("/close", c.handleClose)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
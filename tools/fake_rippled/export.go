@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	firstKey = make([]byte, 32)
+	lastKey  = []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+)
+
+type exportedObject struct {
+	Key  string `json:"key"`
+	Data string `json:"data"`
+}
+
+type exportedTx struct {
+	Tx   string `json:"tx"`
+	Meta string `json:"meta"`
+}
+
+type exportedLedger struct {
+	Seq     uint32           `json:"seq"`
+	Header  string           `json:"header"`
+	Txs     []exportedTx     `json:"txs"`
+	Objects []exportedObject `json:"objects"`
+}
+
+func createGzip(path string) (*gzip.Writer, io.Closer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gzip.NewWriter(f), f, nil
+}
+
+// exports the full state of `from` followed by every ledger in [from, to] into dir
+func exportKeyspace(session *gocql.Session, from uint32, to uint32, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	gz, closer, err := createGzip(filepath.Join(dir, "state.ndjson.gz"))
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(gz)
+	var count int
+	for key := firstKey; ; {
+		var next []byte
+		if err := session.Query("SELECT next FROM successor WHERE key = ? AND seq <= ? ORDER BY seq DESC LIMIT 1", key, from).Scan(&next); err != nil {
+			return fmt.Errorf("successor of %X: %w", key, err)
+		}
+
+		if string(next) == string(lastKey) {
+			break
+		}
+
+		data, _, err := cassandra.FetchObject(session, next, uint64(from))
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return err
+		}
+
+		if len(data) > 0 {
+			if err := encoder.Encode(exportedObject{Key: hex.EncodeToString(next), Data: hex.EncodeToString(data)}); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if count%10000 == 0 && count > 0 {
+			log.Printf("... %d state objects exported ...\n", count)
+		}
+
+		key = next
+	}
+
+	gz.Close()
+	closer.Close()
+	log.Printf("Exported %d state objects of ledger %d\n", count, from)
+
+	gz, closer, err = createGzip(filepath.Join(dir, "ledgers.ndjson.gz"))
+	if err != nil {
+		return err
+	}
+
+	defer closer.Close()
+	defer gz.Close()
+
+	encoder = json.NewEncoder(gz)
+	for seq := from; seq <= to; seq++ {
+		var header []byte
+		if err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&header); err != nil {
+			return fmt.Errorf("header of %d: %w", seq, err)
+		}
+
+		out := exportedLedger{Seq: seq, Header: hex.EncodeToString(header)}
+
+		type indexedTx struct {
+			index int64
+			tx    exportedTx
+		}
+		var txs []indexedTx
+
+		iter := session.Query("SELECT hash FROM ledger_transactions WHERE ledger_sequence = ?", seq).Iter()
+		var hash []byte
+		for iter.Scan(&hash) {
+			var tx, meta []byte
+			if err := session.Query("SELECT transaction, metadata FROM transactions WHERE hash = ?", hash).Scan(&tx, &meta); err != nil {
+				return fmt.Errorf("transaction %X: %w", hash, err)
+			}
+
+			decoded, err := xrpl.Decode(meta)
+			if err != nil {
+				return fmt.Errorf("metadata of %X: %w", hash, err)
+			}
+
+			index, _ := decoded["TransactionIndex"].(int64)
+			txs = append(txs, indexedTx{index: index, tx: exportedTx{Tx: hex.EncodeToString(tx), Meta: hex.EncodeToString(meta)}})
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+
+		sort.Slice(txs, func(i, j int) bool { return txs[i].index < txs[j].index })
+		for _, t := range txs {
+			out.Txs = append(out.Txs, t.tx)
+		}
+
+		if seq > from {
+			iter = session.Query("SELECT key FROM diff WHERE seq = ?", seq).Iter()
+			var key []byte
+			for iter.Scan(&key) {
+				// deleted objects are exported empty
+				data, _, err := cassandra.FetchObject(session, key, uint64(seq))
+				if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+					return err
+				}
+				out.Objects = append(out.Objects, exportedObject{Key: hex.EncodeToString(key), Data: hex.EncodeToString(data)})
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+		}
+
+		if err := encoder.Encode(out); err != nil {
+			return err
+		}
+
+		log.Printf("Exported ledger %d (%d transactions, %d changed objects)\n", seq, len(out.Txs), len(out.Objects))
+	}
+
+	return nil
+}
+
+// replays ledgers previously written by exportKeyspace
+type exportedSource struct {
+	scanner *bufio.Scanner
+	state   string
+}
+
+func newExportedSource(dir string) (*exportedSource, error) {
+	f, err := os.Open(filepath.Join(dir, "ledgers.ndjson.gz"))
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 1024*1024), 512*1024*1024)
+
+	return &exportedSource{scanner: scanner, state: filepath.Join(dir, "state.ndjson.gz")}, nil
+}
+
+func (e *exportedSource) read() (*ledger, []rawObject, bool, error) {
+	if !e.scanner.Scan() {
+		return nil, nil, false, e.scanner.Err()
+	}
+
+	var in exportedLedger
+	if err := json.Unmarshal(e.scanner.Bytes(), &in); err != nil {
+		return nil, nil, false, err
+	}
+
+	headerBlob, err := hex.DecodeString(in.Header)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	header, err := xrpl.DecodeLedgerHeader(headerBlob)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	l := &ledger{Seq: in.Seq, Header: headerBlob, Hash: header.Hash, CloseTime: header.CloseTime, Drops: header.Drops}
+	for _, t := range in.Txs {
+		tx, err := hex.DecodeString(t.Tx)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		meta, err := hex.DecodeString(t.Meta)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		l.Txs = append(l.Txs, txPair{Hash: xrpl.TransactionID(tx), Tx: tx, Meta: meta})
+	}
+
+	var changes []rawObject
+	for _, o := range in.Objects {
+		key, err := hex.DecodeString(o.Key)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		data, err := hex.DecodeString(o.Data)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		changes = append(changes, rawObject{Key: key, Data: data})
+	}
+
+	return l, changes, true, nil
+}
+
+func (e *exportedSource) genesis() (*ledger, *state, error) {
+	l, _, ok, err := e.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("export contains no ledgers")
+	}
+
+	f, err := os.Open(e.state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objects []rawObject
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var o exportedObject
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			return nil, nil, err
+		}
+
+		key, _ := hex.DecodeString(o.Key)
+		data, _ := hex.DecodeString(o.Data)
+		objects = append(objects, rawObject{Key: key, Data: data})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("Loaded %d state objects for ledger %d\n", len(objects), l.Seq)
+	return l, newState().apply(objects), nil
+}
+
+func (e *exportedSource) next(prev *ledger, prevState *state) (*ledger, []rawObject, bool, error) {
+	l, changes, ok, err := e.read()
+	if err != nil || !ok {
+		return nil, nil, ok, err
+	}
+
+	if l.Seq != prev.Seq+1 {
+		return nil, nil, false, fmt.Errorf("export jumps from ledger %d to %d", prev.Seq, l.Seq)
+	}
+
+	return l, changes, true, nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// messages are encoded by hand with protowire, so the server passes raw bytes through this codec
+type rawCodec struct{}
+
+type rawMessage []byte
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case rawMessage:
+		return m, nil
+	case *rawMessage:
+		return *m, nil
+	}
+	return nil, fmt.Errorf("unexpected message type %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+type ledgerRequest struct {
+	sequence           uint32
+	hasSequence        bool
+	transactions       bool
+	expand             bool
+	getObjects         bool
+	getObjectNeighbors bool
+	marker             []byte
+	endMarker          []byte
+}
+
+// walks the fields of a message, calling f with the field number and its raw value
+func forEachField(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := f(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parses org.xrpl.rpc.v1.LedgerSpecifier
+func parseLedgerSpecifier(b []byte, req *ledgerRequest) error {
+	return forEachField(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			if n != 0 {
+				return fmt.Errorf("ledger shortcuts are not supported")
+			}
+		case 2:
+			req.sequence = uint32(n)
+			req.hasSequence = true
+		case 3:
+			return fmt.Errorf("lookup by ledger hash is not supported")
+		}
+		return nil
+	})
+}
+
+// parses GetLedgerRequest and GetLedgerDataRequest, which share the ledger specifier at field 1
+func parseLedgerRequest(b []byte, data bool) (*ledgerRequest, error) {
+	req := &ledgerRequest{}
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1:
+			return parseLedgerSpecifier(v, req)
+		case data && num == 2:
+			req.marker = v
+		case data && num == 3:
+			req.endMarker = v
+		case !data && num == 2:
+			req.transactions = n != 0
+		case !data && num == 3:
+			req.expand = n != 0
+		case !data && num == 4:
+			req.getObjects = n != 0
+		case !data && num == 7:
+			req.getObjectNeighbors = n != 0
+		}
+		return nil
+	})
+
+	if err == nil && !req.hasSequence {
+		err = fmt.Errorf("ledger sequence is required")
+	}
+
+	return req, err
+}
+
+// parses GetLedgerDiffRequest: the base ledger, the desired ledger and whether to send the objects
+func parseLedgerDiffRequest(b []byte) (*ledgerRequest, *ledgerRequest, bool, error) {
+	base, desired := &ledgerRequest{}, &ledgerRequest{}
+	includeBlobs := false
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			return parseLedgerSpecifier(v, base)
+		case 2:
+			return parseLedgerSpecifier(v, desired)
+		case 3:
+			includeBlobs = n != 0
+		}
+		return nil
+	})
+
+	if err == nil && (!base.hasSequence || !desired.hasSequence) {
+		err = fmt.Errorf("base and desired ledger sequences are required")
+	}
+
+	return base, desired, includeBlobs, err
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendBoolField(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// encodes org.xrpl.rpc.v1.RawLedgerObject
+func encodeRawObject(o rawObject, neighbors bool) []byte {
+	var b []byte
+	if len(o.Data) > 0 {
+		b = appendBytesField(b, 1, o.Data)
+	}
+	b = appendBytesField(b, 2, o.Key)
+	b = appendVarintField(b, 3, uint64(o.ModType))
+	if neighbors && len(o.Predecessor) > 0 {
+		b = appendBytesField(b, 4, o.Predecessor)
+	}
+	if neighbors && len(o.Successor) > 0 {
+		b = appendBytesField(b, 5, o.Successor)
+	}
+	return b
+}
+
+func encodeRawObjects(objects []rawObject, neighbors bool) []byte {
+	var b []byte
+	for _, o := range objects {
+		b = appendBytesField(b, 1, encodeRawObject(o, neighbors))
+	}
+	return b
+}
+
+type grpcServer struct {
+	chain    *chain
+	faults   *faultState
+	pageSize int
+}
+
+// applies delay, error and outage faults common to every call
+func (g *grpcServer) intercept(ctx context.Context, method string) error {
+	f := g.faults.get()
+	if f.Outage {
+		return status.Error(codes.Unavailable, "outage")
+	}
+
+	if f.GRPCDelayMS > 0 {
+		select {
+		case <-time.After(time.Duration(f.GRPCDelayMS) * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if g.faults.roll(f.GRPCErrorRate) {
+		log.Printf("Injecting error into %s\n", method)
+		return status.Error(codes.Internal, "injected failure")
+	}
+
+	return nil
+}
+
+// drops a random suffix of the objects or flips a byte of one of them, depending on the active faults
+func (g *grpcServer) damage(objects []rawObject) []rawObject {
+	f := g.faults.get()
+	if len(objects) == 0 {
+		return objects
+	}
+
+	if g.faults.roll(f.TruncateRate) {
+		objects = objects[:g.faults.rollIndex(len(objects))]
+		log.Printf("Truncating response to %d objects\n", len(objects))
+	}
+
+	if len(objects) > 0 && g.faults.roll(f.CorruptRate) {
+		idx := g.faults.rollIndex(len(objects))
+		damaged := make([]rawObject, len(objects))
+		copy(damaged, objects)
+
+		data := append([]byte{}, damaged[idx].Data...)
+		if len(data) > 0 {
+			data[g.faults.rollIndex(len(data))] ^= 0xff
+		}
+		damaged[idx].Data = data
+		objects = damaged
+		log.Printf("Corrupting object %X\n", objects[idx].Key)
+	}
+
+	return objects
+}
+
+func (g *grpcServer) getLedger(ctx context.Context, in []byte) (rawMessage, error) {
+	if err := g.intercept(ctx, "GetLedger"); err != nil {
+		return nil, err
+	}
+
+	req, err := parseLedgerRequest(in, false)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	l, ok := g.chain.ledger(req.sequence)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "ledger not found")
+	}
+
+	out := appendBytesField(nil, 1, l.Header)
+
+	if req.transactions && req.expand {
+		var list []byte
+		for _, t := range l.Txs {
+			var tm []byte
+			tm = appendBytesField(tm, 1, t.Tx)
+			tm = appendBytesField(tm, 2, t.Meta)
+			list = appendBytesField(list, 1, tm)
+		}
+		out = appendBytesField(out, 3, list)
+	} else if req.transactions {
+		var list []byte
+		for _, t := range l.Txs {
+			list = appendBytesField(list, 1, t.Hash)
+		}
+		out = appendBytesField(out, 2, list)
+	}
+
+	out = appendBoolField(out, 4, true)
+
+	if req.getObjects {
+		out = appendBytesField(out, 5, encodeRawObjects(g.damage(l.Objects), req.getObjectNeighbors))
+	}
+
+	out = appendBoolField(out, 7, true)
+	out = appendBoolField(out, 8, req.getObjects)
+	out = appendBoolField(out, 9, req.getObjects && req.getObjectNeighbors)
+
+	if req.getObjects && req.getObjectNeighbors {
+		for _, s := range l.BookSuccessors {
+			var bs []byte
+			bs = appendBytesField(bs, 1, s.BookBase)
+			if len(s.FirstBook) > 0 {
+				bs = appendBytesField(bs, 2, s.FirstBook)
+			}
+			out = appendBytesField(out, 10, bs)
+		}
+	}
+
+	return out, nil
+}
+
+func (g *grpcServer) getLedgerData(ctx context.Context, in []byte) (rawMessage, error) {
+	if err := g.intercept(ctx, "GetLedgerData"); err != nil {
+		return nil, err
+	}
+
+	req, err := parseLedgerRequest(in, true)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	l, ok := g.chain.ledger(req.sequence)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "ledger not found")
+	}
+
+	s, ok := g.chain.state(req.sequence)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "ledger state is no longer available")
+	}
+
+	start := 0
+	if len(req.marker) > 0 {
+		start = s.upperBound(req.marker)
+	}
+
+	var page []rawObject
+	idx := start
+	for ; idx < len(s.keys) && len(page) < g.pageSize; idx++ {
+		key := s.keys[idx]
+		if len(req.endMarker) > 0 && string(key) >= string(req.endMarker) {
+			break
+		}
+
+		data, _ := s.get(key)
+		page = append(page, rawObject{Key: key, Data: data})
+	}
+
+	out := appendVarintField(nil, 1, uint64(l.Seq))
+	out = appendBytesField(out, 2, l.Hash)
+	out = appendBytesField(out, 3, encodeRawObjects(g.damage(page), false))
+
+	more := idx < len(s.keys) && (len(req.endMarker) == 0 || string(s.keys[idx]) < string(req.endMarker))
+	if more && len(page) > 0 {
+		out = appendBytesField(out, 4, page[len(page)-1].Key)
+	}
+
+	out = appendBoolField(out, 5, true)
+	return out, nil
+}
+
+// returns the objects created, modified or deleted between the base and the desired ledger, encoded like
+// the objects of GetLedger; deleted objects carry no data, and neither does any object without include_blobs
+func (g *grpcServer) getLedgerDiff(ctx context.Context, in []byte) (rawMessage, error) {
+	if err := g.intercept(ctx, "GetLedgerDiff"); err != nil {
+		return nil, err
+	}
+
+	baseReq, desiredReq, includeBlobs, err := parseLedgerDiffRequest(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	base, ok := g.chain.state(baseReq.sequence)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "base ledger state is no longer available")
+	}
+
+	desired, ok := g.chain.state(desiredReq.sequence)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "desired ledger state is no longer available")
+	}
+
+	var diff []rawObject
+	i, j := 0, 0
+	for i < len(base.keys) || j < len(desired.keys) {
+		var cmp int
+		switch {
+		case i == len(base.keys):
+			cmp = 1
+		case j == len(desired.keys):
+			cmp = -1
+		default:
+			cmp = bytes.Compare(base.keys[i], desired.keys[j])
+		}
+
+		switch {
+		case cmp < 0:
+			diff = append(diff, rawObject{Key: base.keys[i], ModType: modDeleted})
+			i++
+		case cmp > 0:
+			data, _ := desired.get(desired.keys[j])
+			diff = append(diff, rawObject{Key: desired.keys[j], Data: data, ModType: modCreated})
+			j++
+		default:
+			before, _ := base.get(base.keys[i])
+			after, _ := desired.get(desired.keys[j])
+			if !bytes.Equal(before, after) {
+				diff = append(diff, rawObject{Key: desired.keys[j], Data: after, ModType: modModified})
+			}
+			i++
+			j++
+		}
+	}
+
+	if !includeBlobs {
+		for k := range diff {
+			diff[k].Data = nil
+		}
+	}
+
+	return appendBytesField(nil, 1, encodeRawObjects(g.damage(diff), false)), nil
+}
+
+func unaryHandler(f func(*grpcServer, context.Context, []byte) (rawMessage, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		var in rawMessage
+		if err := dec(&in); err != nil {
+			return nil, err
+		}
+		return f(srv.(*grpcServer), ctx, in)
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "org.xrpl.rpc.v1.XRPLedgerAPIService",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetLedger", Handler: unaryHandler((*grpcServer).getLedger)},
+		{MethodName: "GetLedgerData", Handler: unaryHandler((*grpcServer).getLedgerData)},
+		{MethodName: "GetLedgerDiff", Handler: unaryHandler((*grpcServer).getLedgerDiff)},
+	},
+	Metadata: "org/xrpl/rpc/v1/xrp_ledger.proto",
+}
+
+func serveGRPC(addr string, g *grpcServer) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.MaxSendMsgSize(1024*1024*1024))
+	server.RegisterService(&serviceDesc, g)
+	return server.Serve(lis)
+}
@@ -0,0 +1,149 @@
+//
+// A fake rippled that Clio can use as its ETL source.
+//
+// Ledgers are either generated synthetically (XRP payments between a pool of accounts) or replayed
+// from a directory written by the export command, which dumps a ledger range out of an existing Clio
+// keyspace. Closed ledgers are announced on the websocket ledger stream and served over the subset of
+// org.xrpl.rpc.v1.XRPLedgerAPIService that Clio uses (GetLedger, GetLedgerData and GetLedgerDiff).
+//
+// Faults can be injected at runtime through the control API:
+//
+//	GET    /status      validated range, latest ledger, websocket clients and active faults
+//	GET    /faults      active faults
+//	POST   /faults      merge faults, i.e. {"grpc_delay_ms": 500, "grpc_error_rate": 0.1}
+//	DELETE /faults      clear all faults
+//	POST   /disconnect  drop every websocket connection
+//	POST   /close       close a ledger immediately
+//
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/cassandra"
+)
+
+var (
+	serveCmd      = kingpin.Command("serve", "Serve ledgers to Clio").Default()
+	grpcAddr      = serveCmd.Flag("grpc", "Address of the gRPC listener").Default(":50051").String()
+	wsAddr        = serveCmd.Flag("ws", "Address of the websocket listener").Default(":6006").String()
+	controlAddr   = serveCmd.Flag("control", "Address of the fault injection control API").Default(":8090").String()
+	closeInterval = serveCmd.Flag("close-interval", "Time between ledger closes").Default("4s").Duration()
+	startSeq      = serveCmd.Flag("start-seq", "Sequence of the first synthetic ledger").Default("32570").Uint32()
+	accounts      = serveCmd.Flag("accounts", "Number of accounts in the first synthetic ledger").Default("1000").Int()
+	txsPerLedger  = serveCmd.Flag("txs-per-ledger", "Number of payments in every synthetic ledger").Default("20").Int()
+	newAcctRate   = serveCmd.Flag("new-account-rate", "Fraction of synthetic payments that fund a new account").Default("0.05").Float64()
+	seed          = serveCmd.Flag("seed", "Seed for synthetic data and random faults").Default("1").Int64()
+	replayDir     = serveCmd.Flag("replay", "Serve ledgers from a directory written by the export command instead of synthetic ones").String()
+	keepLedgers   = serveCmd.Flag("keep-ledgers", "Number of recent ledgers served over GetLedger").Default("256").Uint32()
+	keepStates    = serveCmd.Flag("keep-states", "Number of recent ledger states served over GetLedgerData and GetLedgerDiff").Default("4").Uint32()
+	pageSize      = serveCmd.Flag("page-size", "Maximum number of objects in a GetLedgerData page").Default("2048").Int()
+	paused        = serveCmd.Flag("paused", "Start without closing ledgers until unpaused through the control API").Default("false").Bool()
+
+	exportCmd    = kingpin.Command("export", "Export a ledger range from a Clio keyspace for replay")
+	clusterHosts = exportCmd.Flag("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	keyspace     = exportCmd.Flag("keyspace", "Clio keyspace to export from").Short('k').Default("clio_fh").String()
+	clusterFlags = cassandra.RegisterFlags(exportCmd)
+	fromLedger   = exportCmd.Flag("from", "First ledger to export; its full state is exported too").Required().Uint32()
+	toLedger     = exportCmd.Flag("to", "Last ledger to export").Required().Uint32()
+	outDir       = exportCmd.Flag("out", "Directory to write the export to").Required().String()
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	switch kingpin.Parse() {
+	case exportCmd.FullCommand():
+		runExport()
+	case serveCmd.FullCommand():
+		runServe()
+	}
+}
+
+func runExport() {
+	if *toLedger < *fromLedger {
+		log.Fatal("--to must not be lower than --from")
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	if err := exportKeyspace(session, *fromLedger, *toLedger, *outDir); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Exported ledgers %d -> %d to %s\n", *fromLedger, *toLedger, *outDir)
+}
+
+func runServe() {
+	var source ledgerSource
+	if *replayDir != "" {
+		s, err := newExportedSource(*replayDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = s
+	} else {
+		source = newSyntheticSource(*seed, *startSeq, *accounts, *txsPerLedger, *newAcctRate)
+	}
+
+	c, err := newChain(source, *keepLedgers, *keepStates)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f := &faultState{rng: rand.New(rand.NewSource(*seed)), cur: faults{Paused: *paused}}
+	ws := newWSServer(c, f)
+
+	var closeMu sync.Mutex
+	closeLedger := func() error {
+		closeMu.Lock()
+		defer closeMu.Unlock()
+
+		ok, err := c.advance()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no more ledgers to close")
+		}
+
+		l := c.latest()
+		log.Printf("Closed ledger %d with %d transactions and %d changed objects\n", l.Seq, len(l.Txs), len(l.Objects))
+		return nil
+	}
+
+	control := &controlServer{chain: c, faults: f, ws: ws, closer: closeLedger}
+	grpcSrv := &grpcServer{chain: c, faults: f, pageSize: *pageSize}
+
+	go func() { log.Fatal(serveGRPC(*grpcAddr, grpcSrv)) }()
+	go func() { log.Fatal(serveWS(*wsAddr, ws)) }()
+	go func() { log.Fatal(serveControl(*controlAddr, control)) }()
+
+	log.Printf("Serving ledger %d; gRPC on %s, websocket on %s, control on %s\n", c.latest().Seq, *grpcAddr, *wsAddr, *controlAddr)
+
+	for range time.Tick(*closeInterval) {
+		if f.get().Paused {
+			continue
+		}
+
+		if err := closeLedger(); err != nil {
+			log.Printf("Not closing ledgers anymore: %s\n", err)
+			select {}
+		}
+	}
+}
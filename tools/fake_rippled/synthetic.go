@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"xrplf/clio/xrpl"
+)
+
+const (
+	totalDrops      = 100000000000 * 1000000
+	initialBalance  = 1000 * 1000000
+	transactionFee  = 12
+	baseReserve     = 10 * 1000000
+	ownerReserve    = 2 * 1000000
+	closeResolution = 10
+)
+
+type account struct {
+	ID       []byte
+	Key      []byte
+	Sequence uint32
+	Balance  int64
+	PrevTxn  []byte
+	PrevSeq  uint32
+}
+
+// generates a self-consistent chain of ledgers full of XRP payments between synthetic accounts
+type syntheticSource struct {
+	rng          *rand.Rand
+	startSeq     uint32
+	txsPerLedger int
+	newAcctRate  float64
+	accounts     []*account
+}
+
+func newSyntheticSource(seed int64, startSeq uint32, accounts int, txsPerLedger int, newAcctRate float64) *syntheticSource {
+	s := &syntheticSource{
+		rng:          rand.New(rand.NewSource(seed)),
+		startSeq:     startSeq,
+		txsPerLedger: txsPerLedger,
+		newAcctRate:  newAcctRate,
+	}
+
+	for i := 0; i < accounts; i++ {
+		s.accounts = append(s.accounts, s.makeAccount(startSeq))
+	}
+
+	return s
+}
+
+func (s *syntheticSource) makeAccount(seq uint32) *account {
+	seed := make([]byte, 32)
+	s.rng.Read(seed)
+	id := xrpl.SHA512Half(seed)[:20]
+
+	return &account{ID: id, Key: xrpl.AccountRootKey(id), Sequence: seq, Balance: initialBalance, PrevTxn: make([]byte, 32), PrevSeq: seq}
+}
+
+func (a *account) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"LedgerEntryType":   "AccountRoot",
+		"Flags":             0,
+		"Account":           a.ID,
+		"Sequence":          a.Sequence,
+		"Balance":           strconv.FormatInt(a.Balance, 10),
+		"OwnerCount":        0,
+		"PreviousTxnID":     a.PrevTxn,
+		"PreviousTxnLgrSeq": a.PrevSeq,
+	}
+}
+
+func (a *account) blob() []byte {
+	b, err := xrpl.Encode(a.fields())
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func feeSettings() []byte {
+	b, err := xrpl.Encode(map[string]interface{}{
+		"LedgerEntryType":   "FeeSettings",
+		"Flags":             0,
+		"BaseFee":           "A",
+		"ReferenceFeeUnits": 10,
+		"ReserveBase":       baseReserve,
+		"ReserveIncrement":  ownerReserve,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func rippleNow() uint32 {
+	return uint32(time.Now().Unix() - xrpl.RippleEpoch)
+}
+
+func (s *syntheticSource) genesis() (*ledger, *state, error) {
+	st := newState()
+	var objects []rawObject
+
+	var balances int64
+	for _, a := range s.accounts {
+		balances += a.Balance
+	}
+
+	// the first account holds everything that is not spread across the others, just like the genesis account
+	s.accounts[0].Balance += totalDrops - balances
+
+	for _, a := range s.accounts {
+		objects = append(objects, rawObject{Key: a.Key, Data: a.blob()})
+	}
+	objects = append(objects, rawObject{Key: xrpl.FeeSettingsKey(), Data: feeSettings()})
+
+	st = st.apply(objects)
+
+	header := xrpl.LedgerHeader{
+		Sequence:            s.startSeq,
+		Drops:               totalDrops,
+		ParentHash:          make([]byte, 32),
+		TxHash:              make([]byte, 32),
+		AccountHash:         pseudoStateHash(s.startSeq, objects),
+		CloseTime:           rippleNow(),
+		CloseTimeResolution: closeResolution,
+	}
+
+	return &ledger{Seq: s.startSeq, Header: header.Encode(), Hash: header.Hash, CloseTime: header.CloseTime, Drops: header.Drops}, st, nil
+}
+
+// pseudoStateHash is the xrpl.PseudoStateHash of the keys and data of the changes
+func pseudoStateHash(seq uint32, changes []rawObject) []byte {
+	objects := make([]xrpl.StateObject, len(changes))
+	for i, c := range changes {
+		objects[i] = xrpl.StateObject{Key: c.Key, Data: c.Data}
+	}
+	return xrpl.PseudoStateHash(seq, objects)
+}
+
+func modifiedNode(a *account, previous map[string]interface{}) map[string]interface{} {
+	final := a.fields()
+	delete(final, "LedgerEntryType")
+	delete(final, "PreviousTxnID")
+	delete(final, "PreviousTxnLgrSeq")
+
+	return map[string]interface{}{"ModifiedNode": map[string]interface{}{
+		"LedgerEntryType":   "AccountRoot",
+		"LedgerIndex":       a.Key,
+		"FinalFields":       final,
+		"PreviousFields":    previous,
+		"PreviousTxnID":     a.PrevTxn,
+		"PreviousTxnLgrSeq": a.PrevSeq,
+	}}
+}
+
+func (s *syntheticSource) next(prev *ledger, prevState *state) (*ledger, []rawObject, bool, error) {
+	seq := prev.Seq + 1
+	drops := prev.Drops
+	touched := make(map[*account]bool)
+	var txs []txPair
+
+	for i := 0; i < s.txsPerLedger; i++ {
+		from := s.accounts[s.rng.Intn(len(s.accounts))]
+		amount := int64(1+s.rng.Intn(1000)) * 1000
+
+		if from.Balance < amount+transactionFee+baseReserve {
+			continue
+		}
+
+		var to *account
+		created := false
+		if s.rng.Float64() < s.newAcctRate {
+			to = s.makeAccount(seq)
+			to.Balance = 0
+			amount += baseReserve
+			created = true
+		} else {
+			to = s.accounts[s.rng.Intn(len(s.accounts))]
+			if to == from {
+				continue
+			}
+		}
+
+		tx := map[string]interface{}{
+			"TransactionType":    "Payment",
+			"Account":            from.ID,
+			"Destination":        to.ID,
+			"Amount":             strconv.FormatInt(amount, 10),
+			"Fee":                strconv.Itoa(transactionFee),
+			"Sequence":           from.Sequence,
+			"Flags":              uint32(0x80000000),
+			"LastLedgerSequence": seq + 4,
+			"SigningPubKey":      append([]byte{0xED}, xrpl.SHA512Half(from.ID)...),
+			"TxnSignature":       append(xrpl.SHA512Half(from.ID, binary.BigEndian.AppendUint32(nil, from.Sequence)), make([]byte, 32)...),
+		}
+
+		txBlob, err := xrpl.Encode(tx)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		txHash := xrpl.TransactionID(txBlob)
+
+		fromPrevious := map[string]interface{}{"Balance": strconv.FormatInt(from.Balance, 10), "Sequence": from.Sequence}
+		toPrevious := map[string]interface{}{"Balance": strconv.FormatInt(to.Balance, 10)}
+
+		from.Balance -= amount + transactionFee
+		from.Sequence++
+		to.Balance += amount
+		drops -= transactionFee
+
+		affected := []interface{}{modifiedNode(from, fromPrevious)}
+		if created {
+			affected = append(affected, map[string]interface{}{"CreatedNode": map[string]interface{}{
+				"LedgerEntryType": "AccountRoot",
+				"LedgerIndex":     to.Key,
+				"NewFields":       map[string]interface{}{"Account": to.ID, "Balance": strconv.FormatInt(to.Balance, 10), "Sequence": to.Sequence},
+			}})
+			s.accounts = append(s.accounts, to)
+		} else {
+			affected = append(affected, modifiedNode(to, toPrevious))
+		}
+
+		meta, err := xrpl.Encode(map[string]interface{}{
+			"AffectedNodes":     affected,
+			"TransactionIndex":  len(txs),
+			"TransactionResult": "tesSUCCESS",
+			"DeliveredAmount":   strconv.FormatInt(amount, 10),
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		from.PrevTxn, from.PrevSeq = txHash, seq
+		to.PrevTxn, to.PrevSeq = txHash, seq
+		touched[from] = true
+		touched[to] = true
+
+		txs = append(txs, txPair{Hash: txHash, Tx: txBlob, Meta: meta})
+	}
+
+	var changes []rawObject
+	for a := range touched {
+		changes = append(changes, rawObject{Key: a.Key, Data: a.blob()})
+	}
+
+	txHash := make([]byte, 32)
+	if len(txs) > 0 {
+		var ids [][]byte
+		for _, t := range txs {
+			ids = append(ids, t.Hash)
+		}
+		txHash = xrpl.SHA512Half(ids...)
+	}
+
+	closeTime := rippleNow()
+	if closeTime <= prev.CloseTime {
+		closeTime = prev.CloseTime + 1
+	}
+
+	header := xrpl.LedgerHeader{
+		Sequence:            seq,
+		Drops:               drops,
+		ParentHash:          prev.Hash,
+		TxHash:              txHash,
+		AccountHash:         pseudoStateHash(seq, changes),
+		ParentCloseTime:     prev.CloseTime,
+		CloseTime:           closeTime,
+		CloseTimeResolution: closeResolution,
+	}
+
+	l := &ledger{Seq: seq, Header: header.Encode(), Hash: header.Hash, CloseTime: closeTime, Drops: drops, Txs: txs}
+	return l, changes, true, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func upperHex(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+type wsClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	ledger  bool
+}
+
+func (c *wsClient) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteJSON(v)
+}
+
+type wsServer struct {
+	chain   *chain
+	faults  *faultState
+	mu      sync.Mutex
+	clients map[*wsClient]bool
+}
+
+func newWSServer(c *chain, f *faultState) *wsServer {
+	s := &wsServer{chain: c, faults: f, clients: make(map[*wsClient]bool)}
+	c.onClose(s.publishLedger)
+	return s
+}
+
+func (s *wsServer) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+func (s *wsServer) disconnectAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.clients)
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	return n
+}
+
+func (s *wsServer) ledgerFields(l *ledger) map[string]interface{} {
+	return map[string]interface{}{
+		"fee_base":          transactionFee,
+		"fee_ref":           10,
+		"ledger_hash":       upperHex(l.Hash),
+		"ledger_index":      l.Seq,
+		"ledger_time":       l.CloseTime,
+		"reserve_base":      baseReserve,
+		"reserve_inc":       ownerReserve,
+		"validated_ledgers": s.chain.validatedRange(),
+	}
+}
+
+func (s *wsServer) publishLedger(l *ledger) {
+	msg := s.ledgerFields(l)
+	msg["type"] = "ledgerClosed"
+	msg["txn_count"] = len(l.Txs)
+
+	s.mu.Lock()
+	var targets []*wsClient
+	for c := range s.clients {
+		if c.ledger {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		if err := c.send(msg); err != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+func (s *wsServer) serverInfo() map[string]interface{} {
+	l := s.chain.latest()
+	return map[string]interface{}{
+		"info": map[string]interface{}{
+			"build_version":     "fake_rippled",
+			"complete_ledgers":  s.chain.validatedRange(),
+			"server_state":      "full",
+			"load_factor":       1,
+			"validation_quorum": 1,
+			"validated_ledger": map[string]interface{}{
+				"seq":              l.Seq,
+				"hash":             upperHex(l.Hash),
+				"base_fee_xrp":     float64(transactionFee) / 1000000,
+				"reserve_base_xrp": baseReserve / 1000000,
+				"reserve_inc_xrp":  ownerReserve / 1000000,
+				"age":              rippleNow() - l.CloseTime,
+			},
+		},
+	}
+}
+
+func (s *wsServer) handle(c *wsClient, req map[string]interface{}) map[string]interface{} {
+	command, _ := req["command"].(string)
+	if command == "" {
+		command, _ = req["method"].(string)
+	}
+
+	var result map[string]interface{}
+	switch command {
+	case "subscribe", "unsubscribe":
+		streams, _ := req["streams"].([]interface{})
+		for _, stream := range streams {
+			if stream == "ledger" {
+				s.mu.Lock()
+				c.ledger = command == "subscribe"
+				s.mu.Unlock()
+
+				if command == "subscribe" {
+					result = s.ledgerFields(s.chain.latest())
+				}
+			}
+		}
+
+		if result == nil {
+			result = map[string]interface{}{}
+		}
+	case "server_info", "server_state":
+		result = s.serverInfo()
+	case "ledger_closed":
+		l := s.chain.latest()
+		result = map[string]interface{}{"ledger_hash": upperHex(l.Hash), "ledger_index": l.Seq}
+	case "fee":
+		l := s.chain.latest()
+		result = map[string]interface{}{
+			"ledger_current_index": l.Seq + 1,
+			"drops": map[string]interface{}{
+				"base_fee":        "10",
+				"median_fee":      "5000",
+				"minimum_fee":     "10",
+				"open_ledger_fee": "10",
+			},
+		}
+	case "ping":
+		result = map[string]interface{}{}
+	default:
+		return map[string]interface{}{
+			"error":         "unknownCmd",
+			"error_code":    32,
+			"error_message": "Unknown method.",
+			"id":            req["id"],
+			"request":       req,
+			"status":        "error",
+			"type":          "response",
+		}
+	}
+
+	return map[string]interface{}{"id": req["id"], "result": result, "status": "success", "type": "response"}
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.faults.get().Outage {
+		http.Error(w, "outage", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Websocket upgrade failed: %s\n", err)
+		return
+	}
+
+	c := &wsClient{conn: conn}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req map[string]interface{}
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(map[string]interface{}{"error": "invalidParams", "status": "error", "type": "response"})
+			continue
+		}
+
+		if err := c.send(s.handle(c, req)); err != nil {
+			return
+		}
+	}
+}
+
+func serveWS(addr string, s *wsServer) error {
+	server := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
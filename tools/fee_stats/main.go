@@ -0,0 +1,309 @@
+//
+// Aggregates transaction fees, transaction counts and ledger fullness over a ledger range of a Clio
+// keyspace into time-series CSV.
+//
+// Every row covers --bucket consecutive ledgers. Fee levels follow rippled's convention where the base
+// fee pays level 256, so a median fee level well above 256 means fees were escalating. Fullness is the
+// number of transactions relative to --target-txs, the ledger size the network is expected to absorb.
+// With --types-out the per-type transaction counts of every bucket are written as a long-format CSV.
+//
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+const (
+	baseFeeLevel = 256
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	fromLedger   = kingpin.Flag("from", "First ledger to analyze").Required().Uint32()
+	toLedger     = kingpin.Flag("to", "Last ledger to analyze").Required().Uint32()
+	bucket       = kingpin.Flag("bucket", "Number of ledgers aggregated into one row").Default("1").Int()
+	baseFee      = kingpin.Flag("base-fee", "Base fee in drops of a reference transaction").Default("10").Int64()
+	targetTxs    = kingpin.Flag("target-txs", "Transactions per ledger considered a full ledger").Default("1000").Int()
+	outFile      = kingpin.Flag("out", "Write the time-series CSV to this file instead of stdout").Short('O').String()
+	typesOut     = kingpin.Flag("types-out", "Write per-type transaction counts to this CSV file").String()
+	workers      = kingpin.Flag("workers", "Number of ledgers fetched in parallel").Short('w').Default("16").Int()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+type ledgerStats struct {
+	seq       uint32
+	closeTime uint32
+	drops     uint64
+	fees      []int64
+	succeeded int
+	failed    int
+	types     map[string]int
+}
+
+func fetchLedger(session *gocql.Session, seq uint32) (*ledgerStats, error) {
+	var headerBlob []byte
+	if err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&headerBlob); err != nil {
+		return nil, fmt.Errorf("header of %d: %w", seq, err)
+	}
+
+	header, err := xrpl.DecodeLedgerHeader(headerBlob)
+	if err != nil {
+		return nil, fmt.Errorf("header of %d: %w", seq, err)
+	}
+
+	s := &ledgerStats{seq: seq, closeTime: header.CloseTime, drops: header.Drops, types: make(map[string]int)}
+
+	iter := session.Query("SELECT hash FROM ledger_transactions WHERE ledger_sequence = ?", seq).Iter()
+	var hashes [][]byte
+	var hash []byte
+	for iter.Scan(&hash) {
+		hashes = append(hashes, hash)
+		hash = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, h := range hashes {
+		var txBlob, metaBlob []byte
+		if err := session.Query("SELECT transaction, metadata FROM transactions WHERE hash = ?", h).Scan(&txBlob, &metaBlob); err != nil {
+			return nil, fmt.Errorf("transaction %X: %w", h, err)
+		}
+
+		tx, err := xrpl.Decode(txBlob)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %X: %w", h, err)
+		}
+
+		meta, err := xrpl.Decode(metaBlob)
+		if err != nil {
+			return nil, fmt.Errorf("metadata of %X: %w", h, err)
+		}
+
+		t, _ := tx["TransactionType"].(string)
+		s.types[t]++
+
+		if fee, ok := xrpl.XRPDrops(tx["Fee"]); ok {
+			s.fees = append(s.fees, fee)
+		}
+
+		if result, _ := meta["TransactionResult"].(string); result == "tesSUCCESS" {
+			s.succeeded++
+		} else {
+			s.failed++
+		}
+	}
+
+	return s, nil
+}
+
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func feeLevel(fee int64) string {
+	return strconv.FormatFloat(float64(fee)*baseFeeLevel/float64(*baseFee), 'f', 1, 64)
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	if *toLedger < *fromLedger {
+		log.Fatal("--to must not be lower than --from")
+	}
+
+	if *bucket < 1 {
+		log.Fatal("--bucket must be at least 1")
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	// the ledger before the range is needed to know how much XRP the first ledger burned
+	first := *fromLedger
+	if first > 0 {
+		first--
+	}
+
+	count := int(*toLedger-first) + 1
+	stats := make([]*ledgerStats, count)
+	seqs := make(chan uint32, count)
+	for seq := first; seq <= *toLedger; seq++ {
+		seqs <- seq
+	}
+	close(seqs)
+
+	var wg sync.WaitGroup
+	var totalErrors, done uint64
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+			for seq := range seqs {
+				s, err := fetchLedger(session, seq)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: %s\n", err)
+					atomic.AddUint64(&totalErrors, 1)
+					continue
+				}
+				stats[seq-first] = s
+
+				if n := atomic.AddUint64(&done, 1); n%1000 == 0 {
+					log.Printf("... %d/%d ledgers fetched ...\n", n, count)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	out := os.Stdout
+	if *outFile != "" {
+		out, err = os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"first_ledger", "last_ledger", "close_time", "ledgers", "txs", "succeeded", "failed", "txs_per_ledger", "fullness",
+		"fees_drops", "burned_drops", "min_fee", "median_fee", "p90_fee", "max_fee", "avg_fee", "median_fee_level", "p90_fee_level"})
+
+	var typesWriter *csv.Writer
+	if *typesOut != "" {
+		f, err := os.Create(*typesOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		typesWriter = csv.NewWriter(f)
+		typesWriter.Write([]string{"first_ledger", "last_ledger", "close_time", "type", "count"})
+	}
+
+	for start := *fromLedger; start <= *toLedger; start += uint32(*bucket) {
+		end := start + uint32(*bucket) - 1
+		if end > *toLedger {
+			end = *toLedger
+		}
+
+		var fees []int64
+		var txs, succeeded, failed, ledgers int
+		var burned, feeSum int64
+		var closeTime uint32
+		types := make(map[string]int)
+
+		for seq := start; seq <= end; seq++ {
+			s := stats[seq-first]
+			if s == nil {
+				continue
+			}
+
+			if closeTime == 0 {
+				closeTime = s.closeTime
+			}
+
+			ledgers++
+			fees = append(fees, s.fees...)
+			succeeded += s.succeeded
+			failed += s.failed
+			txs += s.succeeded + s.failed
+			for t, n := range s.types {
+				types[t] += n
+			}
+			for _, f := range s.fees {
+				feeSum += f
+			}
+
+			if seq > first {
+				if prev := stats[seq-1-first]; prev != nil {
+					burned += int64(prev.drops - s.drops)
+				}
+			}
+		}
+
+		if ledgers == 0 {
+			continue
+		}
+
+		sort.Slice(fees, func(i, j int) bool { return fees[i] < fees[j] })
+
+		var avg float64
+		if len(fees) > 0 {
+			avg = float64(feeSum) / float64(len(fees))
+		}
+
+		perLedger := float64(txs) / float64(ledgers)
+		date := xrpl.RippleTime(closeTime).UTC().Format(time.RFC3339)
+
+		w.Write([]string{
+			strconv.FormatUint(uint64(start), 10),
+			strconv.FormatUint(uint64(end), 10),
+			date,
+			strconv.Itoa(ledgers),
+			strconv.Itoa(txs),
+			strconv.Itoa(succeeded),
+			strconv.Itoa(failed),
+			strconv.FormatFloat(perLedger, 'f', 2, 64),
+			strconv.FormatFloat(perLedger/float64(*targetTxs), 'f', 4, 64),
+			strconv.FormatInt(feeSum, 10),
+			strconv.FormatInt(burned, 10),
+			strconv.FormatInt(percentile(fees, 0), 10),
+			strconv.FormatInt(percentile(fees, 0.5), 10),
+			strconv.FormatInt(percentile(fees, 0.9), 10),
+			strconv.FormatInt(percentile(fees, 1), 10),
+			strconv.FormatFloat(avg, 'f', 2, 64),
+			feeLevel(percentile(fees, 0.5)),
+			feeLevel(percentile(fees, 0.9)),
+		})
+
+		if typesWriter != nil {
+			var names []string
+			for t := range types {
+				names = append(names, t)
+			}
+			sort.Strings(names)
+
+			for _, t := range names {
+				typesWriter.Write([]string{strconv.FormatUint(uint64(start), 10), strconv.FormatUint(uint64(end), 10), date, t, strconv.Itoa(types[t])})
+			}
+		}
+	}
+
+	w.Flush()
+	if typesWriter != nil {
+		typesWriter.Flush()
+	}
+
+	if totalErrors > 0 {
+		log.Printf("TOTAL ERRORS: %d (missing ledgers are left out of their bucket)\n", totalErrors)
+	}
+}
@@ -0,0 +1,317 @@
+//
+// Proves that every ledger in the range advertised by ledger_range is completely stored.
+//
+// For every ledger the header must exist and be for that sequence, every hash listed in
+// ledger_transactions must resolve in transactions for the same ledger, and the TransactionIndex values
+// found in the metadata must be unique and lower than the number of listed transactions. Unless
+// --no-tx-root is given the transaction tree is also rebuilt from the stored transactions and its root
+// compared with the TxHash of the header, which proves that no transaction is missing or altered.
+//
+// The header does not record how many transactions a ledger has, so the indexes alone cannot tell that
+// the transactions with the highest indexes are missing. With --no-tx-root such a ledger passes.
+//
+// The result is a completeness percentage and the exact list of broken sequences, which can be written
+// to a file with --broken-out for targeted re-ingestion.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	fromLedger   = kingpin.Flag("from", "First ledger to check. Defaults to the start of ledger_range").Uint64()
+	toLedger     = kingpin.Flag("to", "Last ledger to check. Defaults to the end of ledger_range").Uint64()
+	workers      = kingpin.Flag("workers", "Number of ledgers checked in parallel").Default("32").Int()
+	noTxRoot     = kingpin.Flag("no-tx-root", "Skip rebuilding the transaction tree and comparing it with the header. Ledgers missing their last transactions then pass").Default("false").Bool()
+	brokenOut    = kingpin.Flag("broken-out", "Write the broken sequences to this file, one per line").String()
+	reportTo     = kingpin.Flag("report", "Write a JSON report to this file").String()
+	maxFindings  = kingpin.Flag("max-findings", "Maximum number of findings kept in the report").Default("1000").Int()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+type finding struct {
+	LedgerIndex uint64 `json:"ledger_index"`
+	Kind        string `json:"kind"`
+	Message     string `json:"message"`
+}
+
+type report struct {
+	Tool         string            `json:"tool"`
+	From         uint64            `json:"from"`
+	To           uint64            `json:"to"`
+	Passed       bool              `json:"passed"`
+	Ledgers      uint64            `json:"ledgers"`
+	Complete     uint64            `json:"complete"`
+	Transactions uint64            `json:"transactions"`
+	Completeness float64           `json:"completeness_percent"`
+	Broken       []string          `json:"broken_ranges"`
+	Kinds        map[string]uint64 `json:"problems_by_kind"`
+	Findings     []finding         `json:"findings"`
+}
+
+// checks one ledger and returns its problems; an error means the check itself could not be done
+func checkLedger(session *gocql.Session, seq uint64) ([]finding, int, error) {
+	var problems []finding
+	problem := func(kind, format string, args ...interface{}) {
+		problems = append(problems, finding{LedgerIndex: seq, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var headerBlob []byte
+	err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&headerBlob)
+	if err != nil && err != gocql.ErrNotFound {
+		return nil, 0, err
+	}
+
+	var header *xrpl.LedgerHeader
+	if len(headerBlob) == 0 {
+		problem("missing_header", "no header in the ledgers table")
+	} else if header, err = xrpl.DecodeLedgerHeader(headerBlob); err != nil {
+		problem("bad_header", "undecodable header: %s", err)
+		header = nil
+	} else if uint64(header.Sequence) != seq {
+		problem("bad_header", "header is for ledger %d", header.Sequence)
+	}
+
+	var hashes [][]byte
+	iter := session.Query("SELECT hash FROM ledger_transactions WHERE ledger_sequence = ?", seq).Iter()
+	var hash []byte
+	for iter.Scan(&hash) {
+		hashes = append(hashes, hash)
+		hash = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	txs := make([]xrpl.TxWithMeta, 0, len(hashes))
+	indexes := make(map[int64]bool)
+	for _, h := range hashes {
+		var tx xrpl.TxWithMeta
+		var ledgerSeq uint64
+		err := session.Query("SELECT transaction, metadata, ledger_sequence FROM transactions WHERE hash = ?", h).Scan(&tx.Transaction, &tx.Metadata, &ledgerSeq)
+		if err == gocql.ErrNotFound {
+			problem("missing_transaction", "transaction %X is listed but not stored", h)
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ledgerSeq != seq {
+			problem("wrong_ledger", "transaction %X is stored for ledger %d", h, ledgerSeq)
+			continue
+		}
+
+		if !bytes.Equal(xrpl.TransactionID(tx.Transaction), h) {
+			problem("bad_transaction", "transaction stored under %X hashes to %X", h, xrpl.TransactionID(tx.Transaction))
+		}
+
+		meta, err := xrpl.Decode(tx.Metadata)
+		if err != nil {
+			problem("bad_metadata", "undecodable metadata of %X: %s", h, err)
+		} else if idx, ok := meta["TransactionIndex"].(int64); ok {
+			if indexes[idx] {
+				problem("duplicate_index", "TransactionIndex %d is used twice", idx)
+			}
+			indexes[idx] = true
+		}
+
+		txs = append(txs, tx)
+	}
+
+	// the indexes run from 0 to count-1, so an index past the listed transactions means some are missing
+	// from ledger_transactions. Missing transactions with the highest indexes leave no such trace; only
+	// the transaction tree root below catches them
+	for idx := range indexes {
+		if idx >= int64(len(hashes)) {
+			problem("count_mismatch", "TransactionIndex %d found but ledger_transactions lists %d transactions", idx, len(hashes))
+			break
+		}
+	}
+
+	if header != nil && !*noTxRoot && len(txs) == len(hashes) {
+		if root := xrpl.TransactionTreeHash(txs); !bytes.Equal(root, header.TxHash) {
+			problem("tx_root_mismatch", "transaction tree hashes to %X, header has %X", root, header.TxHash)
+		}
+	}
+
+	return problems, len(hashes), nil
+}
+
+// turns sorted sequences into "a-b" ranges
+func compress(seqs []uint64) []string {
+	var out []string
+	for i := 0; i < len(seqs); {
+		j := i
+		for j+1 < len(seqs) && seqs[j+1] == seqs[j]+1 {
+			j++
+		}
+
+		if i == j {
+			out = append(out, fmt.Sprintf("%d", seqs[i]))
+		} else {
+			out = append(out, fmt.Sprintf("%d-%d", seqs[i], seqs[j]))
+		}
+		i = j + 1
+	}
+	return out
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	rep := report{Tool: "history_prover", From: *fromLedger, To: *toLedger, Kinds: make(map[string]uint64), Findings: []finding{}}
+	if rep.From == 0 || rep.To == 0 {
+		first, latest, err := cassandra.GetLedgerRange(session)
+		if err != nil {
+			log.Fatalf("Failed to read ledger_range: %s", err)
+		}
+		if rep.From == 0 {
+			rep.From = first
+		}
+		if rep.To == 0 {
+			rep.To = latest
+		}
+	}
+
+	if rep.From > rep.To {
+		log.Fatalf("Empty range %d -> %d", rep.From, rep.To)
+	}
+
+	log.Printf("Checking ledgers %d -> %d using %d workers\n", rep.From, rep.To, *workers)
+
+	seqs := make(chan uint64, *workers)
+	go func() {
+		for seq := rep.From; seq <= rep.To; seq++ {
+			seqs <- seq
+		}
+		close(seqs)
+	}()
+
+	var mu sync.Mutex
+	var broken []uint64
+	var checked, totalErrors uint64
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for seq := range seqs {
+				problems, txCount, err := checkLedger(session, seq)
+				if err != nil {
+					// the ledger could not be proven complete, so it counts as broken
+					log.Printf("ERROR: failed to check ledger %d: %s\n", seq, err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: [seq=%d]\n", seq)
+					atomic.AddUint64(&totalErrors, 1)
+					problems = []finding{{LedgerIndex: seq, Kind: "query_failed", Message: err.Error()}}
+				}
+
+				atomic.AddUint64(&rep.Transactions, uint64(txCount))
+
+				mu.Lock()
+				if len(problems) > 0 {
+					broken = append(broken, seq)
+					for _, p := range problems {
+						rep.Kinds[p.Kind]++
+						if len(rep.Findings) < *maxFindings {
+							rep.Findings = append(rep.Findings, p)
+						}
+					}
+				}
+				mu.Unlock()
+
+				if done := atomic.AddUint64(&checked, 1); done%10000 == 0 {
+					log.Printf("... %d ledgers checked, %.0f/s ...\n", done, float64(done)/time.Since(startTime).Seconds())
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	sort.Slice(broken, func(i, j int) bool { return broken[i] < broken[j] })
+	sort.Slice(rep.Findings, func(i, j int) bool { return rep.Findings[i].LedgerIndex < rep.Findings[j].LedgerIndex })
+
+	rep.Ledgers = checked
+	rep.Complete = checked - uint64(len(broken))
+	rep.Completeness = 100 * float64(rep.Complete) / float64(rep.Ledgers)
+	rep.Broken = compress(broken)
+	rep.Passed = len(broken) == 0
+
+	log.Println()
+	log.Printf("TOTAL LEDGERS: %d\n", rep.Ledgers)
+	log.Printf("TOTAL TRANSACTIONS: %d\n", rep.Transactions)
+	log.Printf("TOTAL BROKEN LEDGERS: %d\n", len(broken))
+	log.Printf("TOTAL ERRORS: %d\n", totalErrors)
+	log.Printf("COMPLETENESS: %.4f%%\n", rep.Completeness)
+
+	var kinds []string
+	for k := range rep.Kinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		log.Printf("  %-20s %d\n", k, rep.Kinds[k])
+	}
+
+	if len(rep.Broken) > 0 {
+		log.Printf("BROKEN: %s\n", strings.Join(rep.Broken, ","))
+	}
+
+	if *brokenOut != "" {
+		var b strings.Builder
+		for _, seq := range broken {
+			fmt.Fprintf(&b, "%d\n", seq)
+		}
+		if err := os.WriteFile(*brokenOut, []byte(b.String()), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !rep.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,269 @@
+//
+// Seeds a Clio keyspace with a few thousand synthetic but structurally realistic ledgers, so that Clio
+// and the other tools can be run locally or in CI without ingesting from a live network.
+//
+// The first ledger holds the complete state: accounts, gateways, fee settings and the skip list. Every
+// following ledger applies a mix of XRP payments (some funding new accounts), trust lines, issued
+// currency payments, offers and their cancellation, and NFT mints and burns. Owner and order book
+// directories, owner counts, object threading and transaction metadata are kept consistent.
+//
+// Rows are written the way Clio's ETL writes them: objects and diffs, successor chains including the
+// book successors, transactions with account_tx and the NFT tables, headers, hashes and the ledger
+// range. Transaction tree hashes in the headers are real; state tree hashes are a digest of the changes.
+//
+// Generation is deterministic for a given --seed, and --dry-run only reports what would be written.
+//
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	clusterHosts      = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Default("127.0.0.1").String()
+	keyspace          = kingpin.Flag("keyspace", "Keyspace to seed").Short('k').Default("clio_fh").String()
+	clusterFlags      = cassandra.RegisterFlags(kingpin.CommandLine)
+	replicationFactor = kingpin.Flag("replication-factor", "Replication factor of the keyspace if it has to be created").Default("1").Int()
+	drop              = kingpin.Flag("drop", "Drop the keyspace before seeding it").Default("false").Bool()
+	workers           = kingpin.Flag("workers", "Number of parallel insert workers").Short('w').Default("32").Int()
+	dryRun            = kingpin.Flag("dry-run", "Generate the ledgers and count the rows without connecting to the cluster").Default("false").Bool()
+
+	ledgers        = kingpin.Flag("ledgers", "Number of ledgers to generate").Default("2000").Int()
+	startSeq       = kingpin.Flag("start-seq", "Sequence of the first ledger").Default("32570").Uint32()
+	accounts       = kingpin.Flag("accounts", "Number of accounts in the first ledger").Default("500").Int()
+	gateways       = kingpin.Flag("gateways", "Number of those accounts issuing currencies").Default("5").Int()
+	txsPerLedger   = kingpin.Flag("txs-per-ledger", "Number of transactions attempted in every ledger").Default("20").Int()
+	newAccountRate = kingpin.Flag("new-account-rate", "Fraction of XRP payments that fund a new account").Default("0.05").Float64()
+	seed           = kingpin.Flag("seed", "Seed of the generator").Default("1").Int64()
+)
+
+// relative weights of the transaction types
+var defaultMix = txMix{Payment: 8, TrustSet: 3, Issue: 3, OfferCreate: 3, OfferCancel: 1, NFTMint: 2, NFTBurn: 0.5}
+
+type row struct {
+	table  string
+	values []interface{}
+}
+
+// writer inserts rows through a pool of workers and counts them per table
+type writer struct {
+	session *gocql.Session
+	rows    chan row
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	counts  map[string]uint64
+	errors  uint64
+}
+
+func newWriter(session *gocql.Session, workers int) *writer {
+	w := &writer{session: session, rows: make(chan row, workers*100), counts: make(map[string]uint64)}
+
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.wg.Done()
+
+			for r := range w.rows {
+				if w.session != nil {
+					query := inserts[r.table]
+					if err := w.session.Query(query, r.values...).Exec(); err != nil {
+						log.Printf("INSERT ERROR: %s\n", err)
+						fmt.Fprintf(os.Stderr, "FAILED QUERY: %s %v\n", query, r.values)
+						atomic.AddUint64(&w.errors, 1)
+						continue
+					}
+				}
+
+				w.mu.Lock()
+				w.counts[r.table]++
+				w.mu.Unlock()
+			}
+		}()
+	}
+
+	return w
+}
+
+func (w *writer) write(table string, values ...interface{}) {
+	w.rows <- row{table, values}
+}
+
+func (w *writer) close() {
+	close(w.rows)
+	w.wg.Wait()
+}
+
+func (w *writer) writeLedger(l *ledgerRecord, first bool) {
+	seq := int64(l.Header.Sequence)
+	w.write("ledgers", seq, l.Header.Encode())
+	w.write("ledger_hashes", l.Header.Hash, seq)
+
+	for _, c := range l.Changes {
+		// like Clio, the initial ledger has no diff
+		if !first {
+			w.write("diff", seq, c.Key)
+		}
+		w.write("objects", c.Key, seq, c.Data)
+	}
+
+	for _, s := range l.Successors {
+		w.write("successor", s.Key, seq, s.Next)
+	}
+
+	for _, t := range l.Txs {
+		seqIdx := []interface{}{seq, int64(t.Index)}
+		w.write("transactions", t.Hash, seq, int64(l.Header.CloseTime), t.Tx, t.Meta)
+		w.write("ledger_transactions", seq, t.Hash)
+
+		for _, account := range t.Accounts {
+			w.write("account_tx", account, seqIdx, t.Hash)
+		}
+
+		for _, n := range t.NFTs {
+			w.write("nf_tokens", n.TokenID, seq, n.Owner, n.Burned)
+			w.write("nf_token_transactions", n.TokenID, seqIdx, t.Hash)
+			if !n.Burned {
+				w.write("issuer_nf_tokens_v2", n.Issuer, int64(n.Taxon), n.TokenID)
+				w.write("nf_token_uris", n.TokenID, seq, n.URI)
+			}
+		}
+	}
+}
+
+func connect() *gocql.Session {
+	cluster := clusterFlags.NewCluster(*clusterHosts, "")
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *drop {
+		log.Printf("Dropping keyspace %s\n", *keyspace)
+		if err := session.Query("DROP KEYSPACE IF EXISTS " + *keyspace).Exec(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := session.Query(createKeyspace(*keyspace, *replicationFactor)).Exec(); err != nil {
+		log.Fatal(err)
+	}
+	session.Close()
+
+	cluster.Keyspace = *keyspace
+	session, err = cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, statement := range schema {
+		if err := session.Query(statement).Exec(); err != nil {
+			log.Fatalf("Failed to create schema: %s", err)
+		}
+	}
+
+	if latest, err := cassandra.LatestLedger(session); err == nil {
+		log.Fatalf("Keyspace %s already holds ledgers up to %d; use --drop to replace them", *keyspace, latest)
+	} else if err != gocql.ErrNotFound {
+		log.Fatal(err)
+	}
+
+	return session
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	if *ledgers < 1 {
+		log.Fatal("--ledgers must be at least 1")
+	}
+	if *gateways < 1 || *gateways >= *accounts {
+		log.Fatal("--gateways must be at least 1 and lower than --accounts")
+	}
+
+	var session *gocql.Session
+	if !*dryRun {
+		session = connect()
+		defer session.Close()
+	}
+
+	w := newWriter(session, *workers)
+	world := newWorld(*seed)
+
+	// ledgers close every 3 to 5 seconds and the last one closes about now
+	closeTime := uint32(time.Now().Unix()-xrpl.RippleEpoch) - uint32(*ledgers)*4
+	last := *startSeq + uint32(*ledgers) - 1
+	txs := 0
+	start := time.Now()
+
+	genesis := world.genesis(*startSeq, closeTime, *accounts, *gateways)
+	w.writeLedger(genesis, true)
+	for seq := *startSeq + 1; seq <= last; seq++ {
+		closeTime += 3 + uint32(world.rng.Intn(3))
+		l := world.next(closeTime, *txsPerLedger, defaultMix, *newAccountRate)
+		w.writeLedger(l, false)
+		txs += len(l.Txs)
+
+		if (seq-*startSeq)%500 == 0 {
+			log.Printf("... generated ledger %d ...\n", seq)
+		}
+	}
+
+	w.close()
+
+	// the range goes in last, so Clio never sees a partially written keyspace
+	if session != nil {
+		for _, r := range []struct {
+			latest bool
+			seq    uint32
+		}{{false, *startSeq}, {true, last}} {
+			if err := session.Query(inserts["ledger_range"], r.latest, int64(r.seq)).Exec(); err != nil {
+				log.Fatalf("Failed to write the ledger range: %s", err)
+			}
+		}
+	}
+
+	log.Printf("Seeded ledgers %d -> %d (last hash %s) in %s\n", *startSeq, last, strings.ToUpper(hex.EncodeToString(world.prev.Hash)), time.Since(start).Round(time.Second))
+
+	var names []string
+	for name := range world.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("  %-20s %d\n", name, world.counts[name])
+	}
+
+	var tables []string
+	for table := range w.counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		log.Printf("  %-22s %d rows\n", table, w.counts[table])
+	}
+
+	log.Printf("TOTAL ACCOUNTS: %d\n", len(world.accounts))
+	log.Printf("TOTAL TRANSACTIONS: %d\n", txs)
+	log.Printf("TOTAL ERRORS: %d\n", w.errors)
+
+	if w.errors > 0 {
+		os.Exit(1)
+	}
+}
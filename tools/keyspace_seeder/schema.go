@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// the tables Clio creates, see src/data/cassandra/Schema.hpp
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS objects (key blob, sequence bigint, object blob, PRIMARY KEY (key, sequence)) WITH CLUSTERING ORDER BY (sequence DESC)`,
+	`CREATE TABLE IF NOT EXISTS transactions (hash blob PRIMARY KEY, ledger_sequence bigint, date bigint, transaction blob, metadata blob)`,
+	`CREATE TABLE IF NOT EXISTS ledger_transactions (ledger_sequence bigint, hash blob, PRIMARY KEY (ledger_sequence, hash))`,
+	`CREATE TABLE IF NOT EXISTS successor (key blob, seq bigint, next blob, PRIMARY KEY (key, seq))`,
+	`CREATE TABLE IF NOT EXISTS diff (seq bigint, key blob, PRIMARY KEY (seq, key))`,
+	`CREATE TABLE IF NOT EXISTS account_tx (account blob, seq_idx tuple<bigint, bigint>, hash blob, PRIMARY KEY (account, seq_idx)) WITH CLUSTERING ORDER BY (seq_idx DESC)`,
+	`CREATE TABLE IF NOT EXISTS ledgers (sequence bigint PRIMARY KEY, header blob)`,
+	`CREATE TABLE IF NOT EXISTS ledger_hashes (hash blob PRIMARY KEY, sequence bigint)`,
+	`CREATE TABLE IF NOT EXISTS ledger_range (is_latest boolean PRIMARY KEY, sequence bigint)`,
+	`CREATE TABLE IF NOT EXISTS nf_tokens (token_id blob, sequence bigint, owner blob, is_burned boolean, PRIMARY KEY (token_id, sequence)) WITH CLUSTERING ORDER BY (sequence DESC)`,
+	`CREATE TABLE IF NOT EXISTS issuer_nf_tokens_v2 (issuer blob, taxon bigint, token_id blob, PRIMARY KEY (issuer, taxon, token_id)) WITH CLUSTERING ORDER BY (taxon ASC, token_id ASC)`,
+	`CREATE TABLE IF NOT EXISTS nf_token_uris (token_id blob, sequence bigint, uri blob, PRIMARY KEY (token_id, sequence)) WITH CLUSTERING ORDER BY (sequence DESC)`,
+	`CREATE TABLE IF NOT EXISTS nf_token_transactions (token_id blob, seq_idx tuple<bigint, bigint>, hash blob, PRIMARY KEY (token_id, seq_idx)) WITH CLUSTERING ORDER BY (seq_idx DESC)`,
+}
+
+var inserts = map[string]string{
+	"objects":               "INSERT INTO objects (key, sequence, object) VALUES (?, ?, ?)",
+	"transactions":          "INSERT INTO transactions (hash, ledger_sequence, date, transaction, metadata) VALUES (?, ?, ?, ?, ?)",
+	"ledger_transactions":   "INSERT INTO ledger_transactions (ledger_sequence, hash) VALUES (?, ?)",
+	"successor":             "INSERT INTO successor (key, seq, next) VALUES (?, ?, ?)",
+	"diff":                  "INSERT INTO diff (seq, key) VALUES (?, ?)",
+	"account_tx":            "INSERT INTO account_tx (account, seq_idx, hash) VALUES (?, ?, ?)",
+	"ledgers":               "INSERT INTO ledgers (sequence, header) VALUES (?, ?)",
+	"ledger_hashes":         "INSERT INTO ledger_hashes (hash, sequence) VALUES (?, ?)",
+	"ledger_range":          "INSERT INTO ledger_range (is_latest, sequence) VALUES (?, ?)",
+	"nf_tokens":             "INSERT INTO nf_tokens (token_id, sequence, owner, is_burned) VALUES (?, ?, ?, ?)",
+	"issuer_nf_tokens_v2":   "INSERT INTO issuer_nf_tokens_v2 (issuer, taxon, token_id) VALUES (?, ?, ?)",
+	"nf_token_uris":         "INSERT INTO nf_token_uris (token_id, sequence, uri) VALUES (?, ?, ?)",
+	"nf_token_transactions": "INSERT INTO nf_token_transactions (token_id, seq_idx, hash) VALUES (?, ?, ?)",
+}
+
+func createKeyspace(keyspace string, replicationFactor int) string {
+	return fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = {'class': 'SimpleStrategy', 'replication_factor': '%d'} AND durable_writes = true", keyspace, replicationFactor)
+}
@@ -0,0 +1,1006 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"xrplf/clio/xrpl"
+)
+
+const (
+	totalDrops      = 100000000000 * 1000000
+	initialBalance  = 10000 * 1000000
+	transactionFee  = 12
+	baseReserve     = 10 * 1000000
+	ownerReserve    = 2 * 1000000
+	closeResolution = 10
+	dirPageSize     = 32
+	nftPageSize     = 32
+	skipListSize    = 256
+
+	lsfLowReserve    = 0x00010000
+	lsfHighReserve   = 0x00020000
+	lsfDefaultRipple = 0x00800000
+	nftTransferable  = 0x0008
+)
+
+var accountOne = xrpl.EncodeAccountID(append(make([]byte, 19), 1))
+
+type account struct {
+	ID         []byte
+	Address    string
+	Key        []byte
+	Currencies []string
+}
+
+type txRecord struct {
+	Hash     []byte
+	Tx       []byte
+	Meta     []byte
+	Index    uint32
+	Accounts [][]byte
+	NFTs     []nftRecord
+
+	meta map[string]interface{}
+}
+
+type nftRecord struct {
+	TokenID []byte
+	Issuer  []byte
+	Taxon   uint32
+	Owner   []byte
+	Burned  bool
+	URI     []byte
+}
+
+type change = xrpl.StateObject
+
+type successor struct {
+	Key  []byte
+	Next []byte
+}
+
+type ledgerRecord struct {
+	Header     *xrpl.LedgerHeader
+	Txs        []txRecord
+	Changes    []change
+	Successors []successor
+}
+
+var (
+	firstKey = make([]byte, 32)
+	lastKey  = bytes.Repeat([]byte{0xff}, 32)
+)
+
+// generates a self-consistent chain of ledgers with accounts, trust lines, offers and NFTs
+type world struct {
+	rng  *rand.Rand
+	seq  uint32
+	prev *xrpl.LedgerHeader
+
+	objects  map[string]map[string]interface{}
+	keys     [][]byte
+	accounts []*account
+	byID     map[string]*account
+	gateways []*account
+	offers   [][]byte
+	nfts     map[string][]byte // token ID -> owner
+	nftIDs   [][]byte
+	bookDirs map[string]bool
+
+	// keys touched in the ledger being built, with whether they existed when it started
+	touched map[string]bool
+
+	counts map[string]int
+}
+
+func newWorld(seed int64) *world {
+	return &world{rng: rand.New(rand.NewSource(seed)), objects: make(map[string]map[string]interface{}), nfts: make(map[string][]byte), bookDirs: make(map[string]bool), byID: make(map[string]*account), counts: make(map[string]int)}
+}
+
+func (w *world) newAccount() *account {
+	seed := make([]byte, 32)
+	w.rng.Read(seed)
+	id := xrpl.SHA512Half(seed)[:20]
+	a := &account{ID: id, Address: xrpl.EncodeAccountID(id), Key: xrpl.AccountRootKey(id)}
+	w.byID[string(id)] = a
+	return a
+}
+
+func accountRoot(a *account, balance int64, seq uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"LedgerEntryType":   "AccountRoot",
+		"Flags":             uint32(0),
+		"Account":           a.ID,
+		"Sequence":          seq,
+		"Balance":           strconv.FormatInt(balance, 10),
+		"OwnerCount":        uint32(0),
+		"PreviousTxnID":     make([]byte, 32),
+		"PreviousTxnLgrSeq": seq,
+	}
+}
+
+func copyFields(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if list, ok := v.([]interface{}); ok {
+			v = append([]interface{}{}, list...)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func encode(fields map[string]interface{}) []byte {
+	b, err := xrpl.Encode(fields)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode %v: %s", fields["LedgerEntryType"], err))
+	}
+	return b
+}
+
+func sortKeys(keys [][]byte) {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+}
+
+// returns the closest keys around key in the current state, using firstKey and lastKey at the edges
+func (w *world) neighbors(key []byte) ([]byte, []byte) {
+	idx := sort.Search(len(w.keys), func(i int) bool { return bytes.Compare(w.keys[i], key) >= 0 })
+
+	pred, succ := firstKey, lastKey
+	if idx > 0 {
+		pred = w.keys[idx-1]
+	}
+	if idx < len(w.keys) && bytes.Equal(w.keys[idx], key) {
+		idx++
+	}
+	if idx < len(w.keys) {
+		succ = w.keys[idx]
+	}
+	return pred, succ
+}
+
+func isBookDir(fields map[string]interface{}) bool {
+	if fields == nil || fields["LedgerEntryType"] != "DirectoryNode" {
+		return false
+	}
+	_, owned := fields["Owner"]
+	return !owned
+}
+
+// genesis builds the first ledger, which holds the complete state
+func (w *world) genesis(startSeq uint32, closeTime uint32, accounts int, gateways int) *ledgerRecord {
+	w.seq = startSeq
+	currencies := []string{"USD", "EUR", "JPY", "BTC", "CNY", "GBP"}
+
+	balances := int64(0)
+	for i := 0; i < accounts; i++ {
+		a := w.newAccount()
+		w.accounts = append(w.accounts, a)
+		if i > 0 && i <= gateways {
+			a.Currencies = []string{currencies[(i-1)%len(currencies)]}
+			w.gateways = append(w.gateways, a)
+		}
+	}
+
+	// the first account holds everything that is not spread across the others, just like the genesis account
+	for i, a := range w.accounts {
+		balance := int64(initialBalance)
+		if i == 0 {
+			balance = totalDrops - int64(initialBalance)*int64(len(w.accounts)-1)
+		}
+		balances += balance
+
+		root := accountRoot(a, balance, startSeq)
+		if len(a.Currencies) > 0 {
+			root["Flags"] = uint32(lsfDefaultRipple)
+		}
+		w.objects[string(a.Key)] = root
+	}
+
+	w.objects[string(xrpl.FeeSettingsKey())] = map[string]interface{}{
+		"LedgerEntryType":   "FeeSettings",
+		"Flags":             uint32(0),
+		"BaseFee":           "A",
+		"ReferenceFeeUnits": uint32(10),
+		"ReserveBase":       uint32(baseReserve),
+		"ReserveIncrement":  uint32(ownerReserve),
+	}
+	w.objects[string(xrpl.SkipListKey())] = map[string]interface{}{
+		"LedgerEntryType":    "LedgerHashes",
+		"Flags":              uint32(0),
+		"LastLedgerSequence": startSeq - 1,
+		"Hashes":             []interface{}{},
+	}
+
+	l := &ledgerRecord{}
+	for k, fields := range w.objects {
+		key := []byte(k)
+		w.keys = append(w.keys, key)
+		l.Changes = append(l.Changes, change{Key: key, Data: encode(fields)})
+	}
+	sortKeys(w.keys)
+	sort.Slice(l.Changes, func(i, j int) bool { return bytes.Compare(l.Changes[i].Key, l.Changes[j].Key) < 0 })
+
+	// the initial load writes the whole successor chain
+	prev := firstKey
+	for _, key := range w.keys {
+		l.Successors = append(l.Successors, successor{Key: prev, Next: key})
+		prev = key
+	}
+	l.Successors = append(l.Successors, successor{Key: prev, Next: lastKey})
+
+	l.Header = &xrpl.LedgerHeader{
+		Sequence:            startSeq,
+		Drops:               uint64(balances),
+		ParentHash:          make([]byte, 32),
+		TxHash:              make([]byte, 32),
+		AccountHash:         xrpl.PseudoStateHash(startSeq, l.Changes),
+		CloseTime:           closeTime,
+		CloseTimeResolution: closeResolution,
+	}
+	l.Header.Encode()
+	w.prev = l.Header
+	return l
+}
+
+// a transaction being built; it snapshots every object it touches to produce the metadata
+type txContext struct {
+	w        *world
+	before   map[string]map[string]interface{}
+	order    []string
+	accounts map[string][]byte
+	nfts     []nftRecord
+}
+
+func (w *world) begin() *txContext {
+	return &txContext{w: w, before: make(map[string]map[string]interface{}), accounts: make(map[string][]byte)}
+}
+
+func (t *txContext) touch(key []byte) {
+	k := string(key)
+	if _, ok := t.before[k]; ok {
+		return
+	}
+
+	var snapshot map[string]interface{}
+	if fields, ok := t.w.objects[k]; ok {
+		snapshot = copyFields(fields)
+	}
+	t.before[k] = snapshot
+	t.order = append(t.order, k)
+
+	if _, ok := t.w.touched[k]; !ok {
+		t.w.touched[k] = snapshot != nil
+	}
+}
+
+func (t *txContext) get(key []byte) map[string]interface{} {
+	t.touch(key)
+	return t.w.objects[string(key)]
+}
+
+func (t *txContext) create(key []byte, fields map[string]interface{}) {
+	t.touch(key)
+	t.w.objects[string(key)] = fields
+}
+
+func (t *txContext) erase(key []byte) {
+	t.touch(key)
+	delete(t.w.objects, string(key))
+}
+
+func (t *txContext) involve(ids ...[]byte) {
+	for _, id := range ids {
+		t.accounts[string(id)] = id
+	}
+}
+
+func (t *txContext) adjustOwnerCount(id []byte, delta int) {
+	root := t.get(xrpl.AccountRootKey(id))
+	root["OwnerCount"] = uint32(int(root["OwnerCount"].(uint32)) + delta)
+}
+
+func pageNumber(v interface{}) uint64 {
+	n, _ := strconv.ParseUint(fmt.Sprint(v), 16, 64)
+	return n
+}
+
+func setPageLink(page map[string]interface{}, field string, n uint64) {
+	if n == 0 {
+		delete(page, field)
+		return
+	}
+	page[field] = strconv.FormatUint(n, 16)
+}
+
+// dirInsert appends an entry to the last page of a directory like rippled's dirAdd, creating the root or a
+// new page as needed, and returns the page the entry went to
+func (t *txContext) dirInsert(root []byte, entry []byte, newPage func() map[string]interface{}) uint64 {
+	rootPage := t.w.objects[string(root)]
+	if rootPage == nil {
+		rootPage = newPage()
+		rootPage["RootIndex"] = root
+		rootPage["Indexes"] = []interface{}{entry}
+		t.create(root, rootPage)
+		return 0
+	}
+
+	last := pageNumber(rootPage["IndexPrevious"])
+	lastKey := xrpl.DirPageKey(root, last)
+	lastPage := t.get(lastKey)
+	if indexes := lastPage["Indexes"].([]interface{}); len(indexes) < dirPageSize {
+		lastPage["Indexes"] = append(indexes, entry)
+		return last
+	}
+
+	n := last + 1
+	page := newPage()
+	page["RootIndex"] = root
+	page["Indexes"] = []interface{}{entry}
+	setPageLink(page, "IndexPrevious", last)
+	t.create(xrpl.DirPageKey(root, n), page)
+
+	setPageLink(lastPage, "IndexNext", n)
+	setPageLink(t.get(root), "IndexPrevious", n)
+	return n
+}
+
+// dirRemove takes an entry off a directory page, unlinking the page once it is empty and deleting the
+// directory once nothing is left in it
+func (t *txContext) dirRemove(root []byte, n uint64, entry []byte) {
+	key := xrpl.DirPageKey(root, n)
+	page := t.get(key)
+
+	var kept []interface{}
+	for _, e := range page["Indexes"].([]interface{}) {
+		if !bytes.Equal(e.([]byte), entry) {
+			kept = append(kept, e)
+		}
+	}
+	page["Indexes"] = kept
+	if len(kept) > 0 {
+		return
+	}
+
+	if n != 0 {
+		prev, next := pageNumber(page["IndexPrevious"]), pageNumber(page["IndexNext"])
+		setPageLink(t.get(xrpl.DirPageKey(root, prev)), "IndexNext", next)
+		setPageLink(t.get(xrpl.DirPageKey(root, next)), "IndexPrevious", prev)
+		t.erase(key)
+	}
+
+	rootPage := t.get(root)
+	if len(rootPage["Indexes"].([]interface{})) == 0 && pageNumber(rootPage["IndexNext"]) == 0 {
+		t.erase(root)
+	}
+}
+
+func (t *txContext) ownerDirInsert(owner []byte, entry []byte) string {
+	n := t.dirInsert(xrpl.OwnerDirKey(owner), entry, func() map[string]interface{} {
+		return map[string]interface{}{"LedgerEntryType": "DirectoryNode", "Flags": uint32(0), "Owner": owner}
+	})
+	return strconv.FormatUint(n, 16)
+}
+
+var threaded = map[string]bool{"AccountRoot": true, "RippleState": true, "Offer": true, "NFTokenPage": true}
+
+func metaFields(fields map[string]interface{}) map[string]interface{} {
+	out := copyFields(fields)
+	delete(out, "LedgerEntryType")
+	delete(out, "PreviousTxnID")
+	delete(out, "PreviousTxnLgrSeq")
+	return out
+}
+
+// commit signs off the transaction: it charges the fee, threads the touched objects and builds the metadata
+func (t *txContext) commit(tx map[string]interface{}, sender *account, extra map[string]interface{}) txRecord {
+	w := t.w
+	root := t.get(sender.Key)
+	balance, _ := strconv.ParseInt(root["Balance"].(string), 10, 64)
+	sequence := root["Sequence"].(uint32)
+	root["Balance"] = strconv.FormatInt(balance-transactionFee, 10)
+	root["Sequence"] = sequence + 1
+	t.involve(sender.ID)
+
+	tx["Account"] = sender.ID
+	tx["Fee"] = strconv.Itoa(transactionFee)
+	tx["Sequence"] = sequence
+	tx["LastLedgerSequence"] = w.seq + 4
+	tx["SigningPubKey"] = append([]byte{0xED}, xrpl.SHA512Half(sender.ID)...)
+	tx["TxnSignature"] = append(xrpl.SHA512Half(sender.ID, binary.BigEndian.AppendUint32(nil, sequence)), make([]byte, 32)...)
+	if _, ok := tx["Flags"]; !ok {
+		tx["Flags"] = uint32(0)
+	}
+
+	txBlob := encode(tx)
+	txHash := xrpl.TransactionID(txBlob)
+
+	sort.Strings(t.order)
+	var affected []interface{}
+	for _, k := range t.order {
+		before, after := t.before[k], w.objects[k]
+		var node map[string]interface{}
+		var kind string
+
+		switch {
+		case before == nil && after == nil:
+			continue
+		case before == nil:
+			kind = "CreatedNode"
+			node = map[string]interface{}{"LedgerEntryType": after["LedgerEntryType"], "NewFields": metaFields(after)}
+		case after == nil:
+			kind = "DeletedNode"
+			node = map[string]interface{}{"LedgerEntryType": before["LedgerEntryType"], "FinalFields": metaFields(before)}
+		default:
+			kind = "ModifiedNode"
+			previous := make(map[string]interface{})
+			for field, v := range metaFields(before) {
+				if !reflect.DeepEqual(v, after[field]) {
+					previous[field] = v
+				}
+			}
+			node = map[string]interface{}{"LedgerEntryType": after["LedgerEntryType"], "FinalFields": metaFields(after)}
+			if len(previous) > 0 {
+				node["PreviousFields"] = previous
+			}
+			if threaded[after["LedgerEntryType"].(string)] {
+				node["PreviousTxnID"] = before["PreviousTxnID"]
+				node["PreviousTxnLgrSeq"] = before["PreviousTxnLgrSeq"]
+			}
+		}
+
+		node["LedgerIndex"] = []byte(k)
+		affected = append(affected, map[string]interface{}{kind: node})
+
+		if after != nil && threaded[after["LedgerEntryType"].(string)] {
+			after["PreviousTxnID"] = txHash
+			after["PreviousTxnLgrSeq"] = w.seq
+		}
+	}
+
+	meta := map[string]interface{}{
+		"AffectedNodes":     affected,
+		"TransactionIndex":  uint32(0),
+		"TransactionResult": "tesSUCCESS",
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+
+	// the metadata is encoded when the ledger closes, once the transaction index is known
+	record := txRecord{Hash: txHash, Tx: txBlob, NFTs: t.nfts, meta: meta}
+	for _, id := range t.accounts {
+		record.Accounts = append(record.Accounts, id)
+	}
+	sortKeys(record.Accounts)
+	return record
+}
+
+func (w *world) randomAccount() *account {
+	return w.accounts[w.rng.Intn(len(w.accounts))]
+}
+
+func (w *world) balance(a *account) int64 {
+	b, _ := strconv.ParseInt(w.objects[string(a.Key)]["Balance"].(string), 10, 64)
+	return b
+}
+
+func (w *world) ownerCount(a *account) int64 {
+	return int64(w.objects[string(a.Key)]["OwnerCount"].(uint32))
+}
+
+// spendable is what an account can pay without going below its reserve
+func (w *world) spendable(a *account) int64 {
+	return w.balance(a) - baseReserve - ownerReserve*(w.ownerCount(a)+1) - transactionFee
+}
+
+func addDrops(fields map[string]interface{}, delta int64) {
+	b, _ := strconv.ParseInt(fields["Balance"].(string), 10, 64)
+	fields["Balance"] = strconv.FormatInt(b+delta, 10)
+}
+
+func (w *world) payment(newAccountRate float64) (*txRecord, bool) {
+	from := w.randomAccount()
+	amount := int64(1+w.rng.Intn(1000)) * 1000
+	if w.spendable(from) < amount+baseReserve {
+		return nil, false
+	}
+
+	t := w.begin()
+	var to *account
+	if w.rng.Float64() < newAccountRate {
+		to = w.newAccount()
+		amount += baseReserve
+		t.create(to.Key, accountRoot(to, 0, w.seq))
+		w.accounts = append(w.accounts, to)
+		w.counts["accounts created"]++
+	} else if to = w.randomAccount(); to == from {
+		return nil, false
+	}
+
+	addDrops(t.get(from.Key), -amount)
+	addDrops(t.get(to.Key), amount)
+	t.involve(to.ID)
+	w.counts["XRP payments"]++
+
+	delivered := strconv.FormatInt(amount, 10)
+	tx := map[string]interface{}{"TransactionType": "Payment", "Destination": to.ID, "Amount": delivered}
+	r := t.commit(tx, from, map[string]interface{}{"DeliveredAmount": delivered})
+	return &r, true
+}
+
+func orderedPair(a, b []byte) ([]byte, []byte, bool) {
+	if bytes.Compare(a, b) < 0 {
+		return a, b, true
+	}
+	return b, a, false
+}
+
+func iou(currency string, issuer string, value int64) map[string]interface{} {
+	return map[string]interface{}{"currency": currency, "issuer": issuer, "value": strconv.FormatInt(value, 10)}
+}
+
+func (w *world) randomGatewayCurrency() (*account, string) {
+	gw := w.gateways[w.rng.Intn(len(w.gateways))]
+	return gw, gw.Currencies[0]
+}
+
+func (w *world) trustLineKey(holder *account, gw *account, currency string) []byte {
+	code, _ := xrpl.EncodeCurrency(currency)
+	return xrpl.RippleStateKey(holder.ID, gw.ID, code)
+}
+
+func (w *world) trustSet() (*txRecord, bool) {
+	holder := w.randomAccount()
+	gw, currency := w.randomGatewayCurrency()
+	key := w.trustLineKey(holder, gw, currency)
+	if holder == gw || w.objects[string(key)] != nil || w.spendable(holder) < ownerReserve {
+		return nil, false
+	}
+
+	t := w.begin()
+	limit := int64(1000 * (1 + w.rng.Intn(100)))
+	low, high, holderIsLow := orderedPair(holder.ID, gw.ID)
+	lowLimit, highLimit := int64(0), limit
+	flags := uint32(lsfHighReserve)
+	if holderIsLow {
+		lowLimit, highLimit = limit, 0
+		flags = lsfLowReserve
+	}
+
+	t.create(key, map[string]interface{}{
+		"LedgerEntryType": "RippleState",
+		"Flags":           flags,
+		"Balance":         iou(currency, accountOne, 0),
+		"LowLimit":        iou(currency, xrpl.EncodeAccountID(low), lowLimit),
+		"HighLimit":       iou(currency, xrpl.EncodeAccountID(high), highLimit),
+	})
+	line := w.objects[string(key)]
+	holderNode, gwNode := t.ownerDirInsert(holder.ID, key), t.ownerDirInsert(gw.ID, key)
+	line["LowNode"], line["HighNode"] = gwNode, holderNode
+	if holderIsLow {
+		line["LowNode"], line["HighNode"] = holderNode, gwNode
+	}
+	t.adjustOwnerCount(holder.ID, 1)
+	t.involve(gw.ID)
+	w.counts["trust lines created"]++
+
+	tx := map[string]interface{}{"TransactionType": "TrustSet", "LimitAmount": iou(currency, gw.Address, limit)}
+	r := t.commit(tx, holder, nil)
+	return &r, true
+}
+
+func parseValue(v interface{}) int64 {
+	n, _ := strconv.ParseInt(v.(map[string]interface{})["value"].(string), 10, 64)
+	return n
+}
+
+// issues currency from a gateway to one of its trust lines, within the holder's limit
+func (w *world) issue() (*txRecord, bool) {
+	holder := w.randomAccount()
+	gw, currency := w.randomGatewayCurrency()
+	key := w.trustLineKey(holder, gw, currency)
+	line := w.objects[string(key)]
+	if line == nil {
+		return nil, false
+	}
+
+	_, _, holderIsLow := orderedPair(holder.ID, gw.ID)
+	balance := parseValue(line["Balance"])
+	limit := parseValue(line["HighLimit"])
+	if holderIsLow {
+		limit = parseValue(line["LowLimit"])
+	} else {
+		balance = -balance
+	}
+
+	room := limit - balance
+	if room <= 0 {
+		return nil, false
+	}
+	amount := 1 + w.rng.Int63n(room)
+
+	t := w.begin()
+	line = t.get(key)
+	if holderIsLow {
+		line["Balance"] = iou(currency, accountOne, balance+amount)
+	} else {
+		line["Balance"] = iou(currency, accountOne, -(balance + amount))
+	}
+	t.involve(holder.ID)
+	w.counts["issued payments"]++
+
+	delivered := iou(currency, gw.Address, amount)
+	tx := map[string]interface{}{"TransactionType": "Payment", "Destination": holder.ID, "Amount": delivered}
+	r := t.commit(tx, gw, map[string]interface{}{"DeliveredAmount": delivered})
+	return &r, true
+}
+
+// quality encodes an exchange rate the way rippled's getRate does
+func quality(rate float64) uint64 {
+	exponent := int(math.Floor(math.Log10(rate))) - 15
+	mantissa := uint64(math.Round(rate / math.Pow(10, float64(exponent))))
+	if mantissa >= 10000000000000000 {
+		mantissa /= 10
+		exponent++
+	}
+	return uint64(exponent+100)<<56 | mantissa
+}
+
+func issueBytes(currency string, issuer *account) ([]byte, []byte) {
+	code, _ := xrpl.EncodeCurrency(currency)
+	if issuer == nil {
+		return code, make([]byte, 20)
+	}
+	return code, issuer.ID
+}
+
+// places an offer selling or buying a gateway's currency for XRP; the two sides never cross
+func (w *world) offerCreate() (*txRecord, bool) {
+	owner := w.randomAccount()
+	gw, currency := w.randomGatewayCurrency()
+	if w.spendable(owner) < 10*ownerReserve {
+		return nil, false
+	}
+
+	units := int64(1 + w.rng.Intn(500))
+	var pays, gets interface{}
+	var paysCur, paysIss, getsCur, getsIss []byte
+	var rate float64
+
+	if w.rng.Intn(2) == 0 {
+		drops := units * int64(500000+w.rng.Intn(100000))
+		pays, gets = strconv.FormatInt(drops, 10), iou(currency, gw.Address, units)
+		paysCur, paysIss = issueBytes("XRP", nil)
+		getsCur, getsIss = issueBytes(currency, gw)
+		rate = float64(drops) / float64(units)
+	} else {
+		drops := units * int64(400000+w.rng.Intn(90000))
+		pays, gets = iou(currency, gw.Address, units), strconv.FormatInt(drops, 10)
+		paysCur, paysIss = issueBytes(currency, gw)
+		getsCur, getsIss = issueBytes("XRP", nil)
+		rate = float64(units) / float64(drops)
+	}
+
+	q := quality(rate)
+	dirKey := xrpl.BookBase(paysCur, paysIss, getsCur, getsIss)
+	binary.BigEndian.PutUint64(dirKey[24:], q)
+
+	sequence := w.objects[string(owner.Key)]["Sequence"].(uint32)
+	key := xrpl.OfferKey(owner.ID, sequence)
+
+	t := w.begin()
+	bookNode := t.dirInsert(dirKey, key, func() map[string]interface{} {
+		return map[string]interface{}{
+			"LedgerEntryType":   "DirectoryNode",
+			"Flags":             uint32(0),
+			"TakerPaysCurrency": paysCur,
+			"TakerPaysIssuer":   paysIss,
+			"TakerGetsCurrency": getsCur,
+			"TakerGetsIssuer":   getsIss,
+			"ExchangeRate":      fmt.Sprintf("%016X", q),
+		}
+	})
+
+	t.create(key, map[string]interface{}{
+		"LedgerEntryType": "Offer",
+		"Flags":           uint32(0),
+		"Account":         owner.ID,
+		"Sequence":        sequence,
+		"TakerPays":       pays,
+		"TakerGets":       gets,
+		"BookDirectory":   dirKey,
+		"BookNode":        strconv.FormatUint(bookNode, 16),
+	})
+	w.objects[string(key)]["OwnerNode"] = t.ownerDirInsert(owner.ID, key)
+	t.adjustOwnerCount(owner.ID, 1)
+	t.involve(gw.ID)
+	w.offers = append(w.offers, key)
+	w.counts["offers created"]++
+
+	tx := map[string]interface{}{"TransactionType": "OfferCreate", "TakerPays": pays, "TakerGets": gets}
+	r := t.commit(tx, owner, nil)
+	return &r, true
+}
+
+func (w *world) offerCancel() (*txRecord, bool) {
+	if len(w.offers) == 0 {
+		return nil, false
+	}
+
+	i := w.rng.Intn(len(w.offers))
+	key := w.offers[i]
+	offer := w.objects[string(key)]
+	owner := w.accountByID(offer["Account"].([]byte))
+
+	t := w.begin()
+	t.dirRemove(offer["BookDirectory"].([]byte), pageNumber(offer["BookNode"]), key)
+	t.dirRemove(xrpl.OwnerDirKey(owner.ID), pageNumber(offer["OwnerNode"]), key)
+	t.adjustOwnerCount(owner.ID, -1)
+	t.erase(key)
+	w.offers = append(w.offers[:i], w.offers[i+1:]...)
+	w.counts["offers cancelled"]++
+
+	tx := map[string]interface{}{"TransactionType": "OfferCancel", "OfferSequence": offer["Sequence"]}
+	r := t.commit(tx, owner, nil)
+	return &r, true
+}
+
+func (w *world) accountByID(id []byte) *account {
+	return w.byID[string(id)]
+}
+
+// every account keeps its NFTs on a single page, the one that would be the last page in rippled
+func nftPageKey(owner []byte) []byte {
+	return append(append([]byte{}, owner...), bytes.Repeat([]byte{0xff}, 12)...)
+}
+
+func cipheredTaxon(tokenSeq uint32, taxon uint32) uint32 {
+	return taxon ^ (384160001*tokenSeq + 2459)
+}
+
+func (w *world) nftMint() (*txRecord, bool) {
+	owner := w.randomAccount()
+	pageKey := nftPageKey(owner.ID)
+	page := w.objects[string(pageKey)]
+	if (page != nil && len(page["NFTokens"].([]interface{})) >= nftPageSize) || w.spendable(owner) < ownerReserve {
+		return nil, false
+	}
+
+	t := w.begin()
+	root := t.get(owner.Key)
+	minted, _ := root["MintedNFTokens"].(uint32)
+	root["MintedNFTokens"] = minted + 1
+
+	taxon := uint32(w.rng.Intn(4))
+	id := binary.BigEndian.AppendUint16(nil, nftTransferable)
+	id = binary.BigEndian.AppendUint16(id, 0)
+	id = append(id, owner.ID...)
+	id = binary.BigEndian.AppendUint32(id, cipheredTaxon(minted, taxon))
+	id = binary.BigEndian.AppendUint32(id, minted)
+	uri := []byte(fmt.Sprintf("ipfs://synthetic/%X", id[24:]))
+
+	token := map[string]interface{}{"NFToken": map[string]interface{}{"NFTokenID": id, "URI": uri}}
+	if page == nil {
+		t.create(pageKey, map[string]interface{}{"LedgerEntryType": "NFTokenPage", "Flags": uint32(0), "NFTokens": []interface{}{token}})
+		t.adjustOwnerCount(owner.ID, 1)
+	} else {
+		page = t.get(pageKey)
+		tokens := append(page["NFTokens"].([]interface{}), token)
+		sort.Slice(tokens, func(i, j int) bool {
+			a := tokens[i].(map[string]interface{})["NFToken"].(map[string]interface{})["NFTokenID"].([]byte)
+			b := tokens[j].(map[string]interface{})["NFToken"].(map[string]interface{})["NFTokenID"].([]byte)
+			if c := bytes.Compare(a[20:], b[20:]); c != 0 {
+				return c < 0
+			}
+			return bytes.Compare(a, b) < 0
+		})
+		page["NFTokens"] = tokens
+	}
+
+	t.nfts = append(t.nfts, nftRecord{TokenID: id, Issuer: owner.ID, Taxon: taxon, Owner: owner.ID, URI: uri})
+	w.nfts[string(id)] = owner.ID
+	w.nftIDs = append(w.nftIDs, id)
+	w.counts["NFTs minted"]++
+
+	tx := map[string]interface{}{"TransactionType": "NFTokenMint", "NFTokenTaxon": taxon, "Flags": uint32(nftTransferable), "URI": uri}
+	r := t.commit(tx, owner, nil)
+	return &r, true
+}
+
+func (w *world) nftBurn() (*txRecord, bool) {
+	if len(w.nftIDs) == 0 {
+		return nil, false
+	}
+
+	i := w.rng.Intn(len(w.nftIDs))
+	id := w.nftIDs[i]
+	owner := w.accountByID(w.nfts[string(id)])
+	issuer := w.accountByID(id[4:24])
+	pageKey := nftPageKey(owner.ID)
+
+	t := w.begin()
+	page := t.get(pageKey)
+	var kept []interface{}
+	for _, token := range page["NFTokens"].([]interface{}) {
+		if !bytes.Equal(token.(map[string]interface{})["NFToken"].(map[string]interface{})["NFTokenID"].([]byte), id) {
+			kept = append(kept, token)
+		}
+	}
+	if len(kept) == 0 {
+		t.erase(pageKey)
+		t.adjustOwnerCount(owner.ID, -1)
+	} else {
+		page["NFTokens"] = kept
+	}
+
+	root := t.get(issuer.Key)
+	burned, _ := root["BurnedNFTokens"].(uint32)
+	root["BurnedNFTokens"] = burned + 1
+	t.involve(issuer.ID)
+
+	taxon := cipheredTaxon(binary.BigEndian.Uint32(id[28:]), binary.BigEndian.Uint32(id[24:28]))
+	t.nfts = append(t.nfts, nftRecord{TokenID: id, Issuer: issuer.ID, Taxon: taxon, Owner: owner.ID, Burned: true})
+	delete(w.nfts, string(id))
+	w.nftIDs = append(w.nftIDs[:i], w.nftIDs[i+1:]...)
+	w.counts["NFTs burned"]++
+
+	tx := map[string]interface{}{"TransactionType": "NFTokenBurn", "NFTokenID": id}
+	r := t.commit(tx, owner, nil)
+	return &r, true
+}
+
+type txMix struct {
+	Payment, TrustSet, Issue, OfferCreate, OfferCancel, NFTMint, NFTBurn float64
+}
+
+// returns the first key strictly greater than key in keys, or lastKey
+func successorIn(keys [][]byte, key []byte) []byte {
+	idx := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], key) > 0 })
+	if idx < len(keys) {
+		return keys[idx]
+	}
+	return lastKey
+}
+
+// next builds the ledger following the previous one with up to txsPerLedger transactions
+func (w *world) next(closeTime uint32, txsPerLedger int, mix txMix, newAccountRate float64) *ledgerRecord {
+	w.seq++
+	w.touched = make(map[string]bool)
+
+	builders := []struct {
+		weight float64
+		build  func() (*txRecord, bool)
+	}{
+		{mix.Payment, func() (*txRecord, bool) { return w.payment(newAccountRate) }},
+		{mix.TrustSet, w.trustSet},
+		{mix.Issue, w.issue},
+		{mix.OfferCreate, w.offerCreate},
+		{mix.OfferCancel, w.offerCancel},
+		{mix.NFTMint, w.nftMint},
+		{mix.NFTBurn, w.nftBurn},
+	}
+	var total float64
+	for _, b := range builders {
+		total += b.weight
+	}
+
+	l := &ledgerRecord{}
+	drops := w.prev.Drops
+	for i := 0; i < txsPerLedger; i++ {
+		pick := w.rng.Float64() * total
+		for _, b := range builders {
+			if pick -= b.weight; pick > 0 {
+				continue
+			}
+			if r, ok := b.build(); ok {
+				r.Index = uint32(len(l.Txs))
+				r.meta["TransactionIndex"] = r.Index
+				r.Meta = encode(r.meta)
+				l.Txs = append(l.Txs, *r)
+				drops -= transactionFee
+			}
+			break
+		}
+	}
+
+	// the skip list is updated by the ledger close itself, outside of any transaction
+	skipList := w.objects[string(xrpl.SkipListKey())]
+	w.touched[string(xrpl.SkipListKey())] = true
+	hashes := append(skipList["Hashes"].([]interface{}), w.prev.Hash)
+	if len(hashes) > skipListSize {
+		hashes = hashes[len(hashes)-skipListSize:]
+	}
+	skipList["Hashes"] = hashes
+	skipList["LastLedgerSequence"] = w.seq - 1
+
+	var keys []string
+	for k := range w.touched {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// objects created and deleted within the ledger never show up
+	var created, deleted [][]byte
+	for _, k := range keys {
+		fields, exists := w.objects[k]
+		existed := w.touched[k]
+		switch {
+		case !existed && !exists:
+			continue
+		case !exists:
+			deleted = append(deleted, []byte(k))
+			l.Changes = append(l.Changes, change{Key: []byte(k)})
+			continue
+		case !existed:
+			created = append(created, []byte(k))
+		}
+		l.Changes = append(l.Changes, change{Key: []byte(k), Data: encode(fields)})
+	}
+
+	// the first directory of a book moves when a directory is added in front of it or the first one is deleted
+	oldKeys := w.keys
+	books := make(map[string]bool)
+	for _, key := range created {
+		if isBookDir(w.objects[string(key)]) && bytes.Compare(key, successorIn(oldKeys, xrpl.BookBaseOf(key))) < 0 {
+			books[string(xrpl.BookBaseOf(key))] = true
+		}
+	}
+	for _, key := range deleted {
+		if w.bookDirs[string(key)] && bytes.Equal(key, successorIn(oldKeys, xrpl.BookBaseOf(key))) {
+			books[string(xrpl.BookBaseOf(key))] = true
+		}
+	}
+
+	if len(created) > 0 || len(deleted) > 0 {
+		w.keys = make([][]byte, 0, len(w.objects))
+		for k := range w.objects {
+			w.keys = append(w.keys, []byte(k))
+		}
+		sortKeys(w.keys)
+	}
+
+	for _, key := range created {
+		if isBookDir(w.objects[string(key)]) {
+			w.bookDirs[string(key)] = true
+		}
+		pred, succ := w.neighbors(key)
+		l.Successors = append(l.Successors, successor{Key: pred, Next: key}, successor{Key: key, Next: succ})
+	}
+	for _, key := range deleted {
+		delete(w.bookDirs, string(key))
+		pred, succ := w.neighbors(key)
+		l.Successors = append(l.Successors, successor{Key: pred, Next: succ})
+	}
+	for base := range books {
+		l.Successors = append(l.Successors, successor{Key: []byte(base), Next: successorIn(w.keys, []byte(base))})
+	}
+
+	l.Header = &xrpl.LedgerHeader{
+		Sequence:            w.seq,
+		Drops:               drops,
+		ParentHash:          w.prev.Hash,
+		TxHash:              make([]byte, 32),
+		AccountHash:         xrpl.PseudoStateHash(w.seq, l.Changes),
+		ParentCloseTime:     w.prev.CloseTime,
+		CloseTime:           closeTime,
+		CloseTimeResolution: closeResolution,
+	}
+	if len(l.Txs) > 0 {
+		var txs []xrpl.TxWithMeta
+		for _, t := range l.Txs {
+			txs = append(txs, xrpl.TxWithMeta{Transaction: t.Tx, Metadata: t.Meta})
+		}
+		l.Header.TxHash = xrpl.TransactionTreeHash(txs)
+	}
+	l.Header.Encode()
+	w.prev = l.Header
+	return l
+}
@@ -0,0 +1,291 @@
+//
+// Shows what changed in a ledger, straight from the diff and objects tables of a Clio keyspace.
+//
+// Every key in the diff of a ledger is classified as created, modified or deleted by comparing the
+// object version written in that ledger with the version the previous ledger saw. Objects are decoded
+// so that the changed fields of modified entries can be listed.
+//
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	ledgerIdx    = kingpin.Flag("ledger", "Ledger to show the changes of").Short('i').Uint64()
+	fromLedger   = kingpin.Flag("from", "First ledger of a range to show the changes of").Uint64()
+	toLedger     = kingpin.Flag("to", "Last ledger of a range to show the changes of").Uint64()
+	types        = kingpin.Flag("type", "Only show entries of this ledger entry type (i.e. Offer, RippleState); can be repeated").Strings()
+	verbose      = kingpin.Flag("verbose", "List every changed entry, not only the per-type summary").Short('v').Default("false").Bool()
+	jsonOutput   = kingpin.Flag("json", "Print NDJSON, one object per ledger, instead of text").Default("false").Bool()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+type fieldChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type entryChange struct {
+	Key     string                 `json:"key"`
+	Type    string                 `json:"type"`
+	Change  string                 `json:"change"`
+	Fields  map[string]fieldChange `json:"fields,omitempty"`
+	Before  map[string]interface{} `json:"before,omitempty"`
+	After   map[string]interface{} `json:"after,omitempty"`
+	Problem string                 `json:"problem,omitempty"`
+}
+
+type typeSummary struct {
+	Created  int `json:"created"`
+	Modified int `json:"modified"`
+	Deleted  int `json:"deleted"`
+}
+
+type ledgerDiff struct {
+	LedgerIndex uint64                  `json:"ledger_index"`
+	Changes     int                     `json:"changes"`
+	Summary     map[string]*typeSummary `json:"summary"`
+	Entries     []entryChange           `json:"entries,omitempty"`
+}
+
+func decode(blob []byte) (map[string]interface{}, string) {
+	if len(blob) == 0 {
+		return nil, ""
+	}
+
+	fields, err := xrpl.Decode(blob)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return fields, ""
+}
+
+func changedFields(before map[string]interface{}, after map[string]interface{}) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+	for name, v := range after {
+		if old, ok := before[name]; !ok || !reflect.DeepEqual(old, v) {
+			changes[name] = fieldChange{Before: before[name], After: v}
+		}
+	}
+
+	for name, v := range before {
+		if _, ok := after[name]; !ok {
+			changes[name] = fieldChange{Before: v}
+		}
+	}
+
+	// threading fields change with every modification and only add noise
+	delete(changes, "PreviousTxnID")
+	delete(changes, "PreviousTxnLgrSeq")
+	return changes
+}
+
+func diffLedger(session *gocql.Session, seq uint64, wanted map[string]bool) (*ledgerDiff, error) {
+	d := &ledgerDiff{LedgerIndex: seq, Summary: make(map[string]*typeSummary)}
+
+	var keys [][]byte
+	iter := session.Query("SELECT key FROM diff WHERE seq = ?", seq).Iter()
+	var key []byte
+	for iter.Scan(&key) {
+		keys = append(keys, key)
+		key = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+
+	for _, key := range keys {
+		// objects that never existed or were deleted compare as empty blobs
+		after, afterSeq, err := cassandra.FetchObject(session, key, seq)
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return nil, err
+		}
+
+		before, _, err := cassandra.FetchObject(session, key, seq-1)
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return nil, err
+		}
+
+		e := entryChange{Key: strings.ToUpper(hex.EncodeToString(key))}
+		if afterSeq != seq {
+			e.Problem = fmt.Sprintf("key is in the diff but its latest version is from ledger %d", afterSeq)
+		}
+
+		var problem string
+		var beforeFields, afterFields map[string]interface{}
+		switch {
+		case len(after) == 0:
+			e.Change = "deleted"
+			beforeFields, problem = decode(before)
+		case len(before) == 0:
+			e.Change = "created"
+			afterFields, problem = decode(after)
+		default:
+			e.Change = "modified"
+			beforeFields, problem = decode(before)
+			if problem == "" {
+				afterFields, problem = decode(after)
+			}
+		}
+
+		if problem != "" {
+			e.Problem = "failed to decode: " + problem
+		}
+
+		e.Type = "Unknown"
+		for _, fields := range []map[string]interface{}{afterFields, beforeFields} {
+			if t, ok := fields["LedgerEntryType"].(string); ok {
+				e.Type = t
+				break
+			}
+		}
+
+		if len(wanted) > 0 && !wanted[strings.ToLower(e.Type)] {
+			continue
+		}
+
+		summary, ok := d.Summary[e.Type]
+		if !ok {
+			summary = &typeSummary{}
+			d.Summary[e.Type] = summary
+		}
+
+		switch e.Change {
+		case "created":
+			summary.Created++
+			e.After = afterFields
+		case "deleted":
+			summary.Deleted++
+			e.Before = beforeFields
+		case "modified":
+			summary.Modified++
+			e.Fields = changedFields(beforeFields, afterFields)
+		}
+
+		d.Changes++
+		if *verbose || e.Problem != "" {
+			d.Entries = append(d.Entries, e)
+		}
+	}
+
+	return d, nil
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+func printDiff(d *ledgerDiff) {
+	fmt.Printf("Ledger %d: %d changed entries\n", d.LedgerIndex, d.Changes)
+
+	var names []string
+	for name := range d.Summary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := d.Summary[name]
+		fmt.Printf("  %-24s %6d created %6d modified %6d deleted\n", name, s.Created, s.Modified, s.Deleted)
+	}
+
+	for _, e := range d.Entries {
+		fmt.Printf("\n  %s %s %s\n", e.Change, e.Type, e.Key)
+		if e.Problem != "" {
+			fmt.Printf("    PROBLEM: %s\n", e.Problem)
+		}
+
+		var fields []string
+		for name := range e.Fields {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+
+		for _, name := range fields {
+			c := e.Fields[name]
+			fmt.Printf("    %s: %s -> %s\n", name, formatValue(c.Before), formatValue(c.After))
+		}
+
+		for _, object := range []map[string]interface{}{e.After, e.Before} {
+			if object == nil {
+				continue
+			}
+			out, _ := json.MarshalIndent(object, "    ", "  ")
+			fmt.Printf("    %s\n", out)
+		}
+	}
+
+	fmt.Println()
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	from, to := *fromLedger, *toLedger
+	if *ledgerIdx != 0 {
+		from, to = *ledgerIdx, *ledgerIdx
+	}
+
+	if from == 0 || to < from {
+		log.Fatal("Please specify a ledger with --ledger or a range with --from/--to")
+	}
+
+	wanted := make(map[string]bool)
+	for _, t := range *types {
+		wanted[strings.ToLower(t)] = true
+	}
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	encoder := json.NewEncoder(os.Stdout)
+	for seq := from; seq <= to; seq++ {
+		d, err := diffLedger(session, seq, wanted)
+		if err != nil {
+			log.Fatalf("Failed to diff ledger %d: %s", seq, err)
+		}
+
+		if *jsonOutput {
+			encoder.Encode(d)
+		} else {
+			printDiff(d)
+		}
+	}
+}
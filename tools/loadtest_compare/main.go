@@ -0,0 +1,513 @@
+//
+// Compares two or more load test reports and summarises the per-method differences against the
+// first (baseline) report.
+//
+// A report is either JSON, NDJSON or SQLite and holds one record per request:
+//
+//	{"method": "account_tx", "latency_ms": 12.5, "status": "success", "error": ""}
+//
+// JSON reports are either an array of records or an object {"label": "2.1.0", "requests": [...]}.
+// SQLite reports must have a `requests` table with method, latency_ms and status (and optionally
+// error) columns. A request counts as failed if it has an error or its status is not "success".
+//
+// For every method the mean latency delta is reported with a Welch confidence interval, the median
+// delta with a bootstrap confidence interval and the error rate delta with a normal approximation.
+//
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	reports    = kingpin.Arg("reports", "Report files; the first one is the baseline. Use file=label to name a report").Required().Strings()
+	htmlOut    = kingpin.Flag("html", "Write an HTML summary to this file").String()
+	jsonOut    = kingpin.Flag("json", "Write the merged comparison as JSON to this file").String()
+	confidence = kingpin.Flag("confidence", "Confidence level of the intervals").Default("0.95").Float64()
+	resamples  = kingpin.Flag("bootstrap", "Number of bootstrap resamples for the median interval").Default("500").Int()
+	seed       = kingpin.Flag("seed", "Seed of the bootstrap").Default("1").Int64()
+	minCount   = kingpin.Flag("min-count", "Ignore methods with fewer requests than this in any report").Default("10").Int()
+)
+
+type record struct {
+	Method    string  `json:"method"`
+	LatencyMS float64 `json:"latency_ms"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error"`
+}
+
+func (r *record) failed() bool {
+	return r.Error != "" || (r.Status != "" && r.Status != "success")
+}
+
+type report struct {
+	Label     string
+	Latencies map[string][]float64
+	Errors    map[string]int
+	Total     map[string]int
+}
+
+func newReport(label string) *report {
+	return &report{Label: label, Latencies: make(map[string][]float64), Errors: make(map[string]int), Total: make(map[string]int)}
+}
+
+func (r *report) add(rec record) {
+	if rec.Method == "" {
+		rec.Method = "unknown"
+	}
+
+	r.Total[rec.Method]++
+	if rec.failed() {
+		r.Errors[rec.Method]++
+		return
+	}
+	r.Latencies[rec.Method] = append(r.Latencies[rec.Method], rec.LatencyMS)
+}
+
+func loadJSON(path string, r *report) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var records []record
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &records)
+	} else {
+		var wrapped struct {
+			Label    string   `json:"label"`
+			Requests []record `json:"requests"`
+		}
+		err = json.Unmarshal(data, &wrapped)
+		records = wrapped.Requests
+		if wrapped.Label != "" {
+			r.Label = wrapped.Label
+		}
+	}
+
+	for _, rec := range records {
+		r.add(rec)
+	}
+	return err
+}
+
+func loadNDJSON(path string, r *report) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		r.add(rec)
+	}
+	return scanner.Err()
+}
+
+func loadSQLite(path string, r *report) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	query := "SELECT method, latency_ms, COALESCE(status, ''), '' FROM requests"
+	if _, err := db.Exec("SELECT error FROM requests LIMIT 0"); err == nil {
+		query = "SELECT method, latency_ms, COALESCE(status, ''), COALESCE(error, '') FROM requests"
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec record
+		if err := rows.Scan(&rec.Method, &rec.LatencyMS, &rec.Status, &rec.Error); err != nil {
+			return err
+		}
+		r.add(rec)
+	}
+	return rows.Err()
+}
+
+func loadReport(arg string) (*report, error) {
+	path, label, found := strings.Cut(arg, "=")
+	if !found {
+		label = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	r := newReport(label)
+
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		err = loadSQLite(path, r)
+	case ".ndjson", ".jsonl":
+		err = loadNDJSON(path, r)
+	default:
+		err = loadJSON(path, r)
+	}
+
+	if found {
+		r.Label = label
+	}
+
+	for _, l := range r.Latencies {
+		sort.Float64s(l)
+	}
+
+	return r, err
+}
+
+// quantile of sorted values, interpolating between the closest ranks
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func meanVar(v []float64) (float64, float64) {
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	mean := sum / float64(len(v))
+
+	var sq float64
+	for _, x := range v {
+		sq += (x - mean) * (x - mean)
+	}
+
+	if len(v) < 2 {
+		return mean, 0
+	}
+	return mean, sq / float64(len(v)-1)
+}
+
+// inverse of the standard normal CDF (Acklam's approximation)
+func normalQuantile(p float64) float64 {
+	a := []float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := []float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := []float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := []float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+
+	const low = 0.02425
+	switch {
+	case p < low:
+		q := math.Sqrt(-2 * math.Log(p))
+		return (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	case p > 1-low:
+		q := math.Sqrt(-2 * math.Log(1-p))
+		return -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	default:
+		q := p - 0.5
+		r := q * q
+		return (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q / (((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+	}
+}
+
+// confidence interval of the median difference b - a, by resampling both samples
+func bootstrapMedianDelta(rng *rand.Rand, a []float64, b []float64, n int, level float64) (float64, float64) {
+	sampleA := make([]float64, len(a))
+	sampleB := make([]float64, len(b))
+	deltas := make([]float64, 0, n)
+
+	for i := 0; i < n; i++ {
+		for j := range sampleA {
+			sampleA[j] = a[rng.Intn(len(a))]
+		}
+		for j := range sampleB {
+			sampleB[j] = b[rng.Intn(len(b))]
+		}
+		sort.Float64s(sampleA)
+		sort.Float64s(sampleB)
+		deltas = append(deltas, quantile(sampleB, 0.5)-quantile(sampleA, 0.5))
+	}
+
+	sort.Float64s(deltas)
+	alpha := (1 - level) / 2
+	return quantile(deltas, alpha), quantile(deltas, 1-alpha)
+}
+
+type interval struct {
+	Delta float64 `json:"delta"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+// significant when the interval does not contain zero
+func (i interval) Significant() bool {
+	return i.Low > 0 || i.High < 0
+}
+
+type methodStats struct {
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Mean      float64 `json:"mean_ms"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+}
+
+type methodDelta struct {
+	Report    string   `json:"report"`
+	Mean      interval `json:"mean_ms"`
+	Median    interval `json:"p50_ms"`
+	ErrorRate interval `json:"error_rate"`
+}
+
+type methodComparison struct {
+	Method string                 `json:"method"`
+	Stats  map[string]methodStats `json:"stats"`
+	Deltas []methodDelta          `json:"deltas"`
+}
+
+type comparison struct {
+	Baseline   string             `json:"baseline"`
+	Reports    []string           `json:"reports"`
+	Confidence float64            `json:"confidence"`
+	Methods    []methodComparison `json:"methods"`
+	Skipped    []string           `json:"skipped_methods,omitempty"`
+}
+
+func stats(r *report, method string) methodStats {
+	l := r.Latencies[method]
+	s := methodStats{Count: r.Total[method], Errors: r.Errors[method]}
+	if s.Count > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Count)
+	}
+
+	if len(l) > 0 {
+		s.Mean, _ = meanVar(l)
+		s.P50 = quantile(l, 0.5)
+		s.P90 = quantile(l, 0.9)
+		s.P99 = quantile(l, 0.99)
+	}
+	return s
+}
+
+func compare(all []*report) *comparison {
+	z := normalQuantile(1 - (1-*confidence)/2)
+	rng := rand.New(rand.NewSource(*seed))
+
+	c := &comparison{Baseline: all[0].Label, Confidence: *confidence}
+	methods := make(map[string]bool)
+	for _, r := range all {
+		c.Reports = append(c.Reports, r.Label)
+		for m := range r.Total {
+			methods[m] = true
+		}
+	}
+
+	var names []string
+	for m := range methods {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+
+	base := all[0]
+	for _, m := range names {
+		usable := true
+		for _, r := range all {
+			usable = usable && len(r.Latencies[m]) >= *minCount
+		}
+
+		if !usable {
+			c.Skipped = append(c.Skipped, m)
+			continue
+		}
+
+		mc := methodComparison{Method: m, Stats: make(map[string]methodStats)}
+		for _, r := range all {
+			mc.Stats[r.Label] = stats(r, m)
+		}
+
+		a := base.Latencies[m]
+		meanA, varA := meanVar(a)
+		baseStats := mc.Stats[base.Label]
+
+		for _, r := range all[1:] {
+			b := r.Latencies[m]
+			meanB, varB := meanVar(b)
+			se := math.Sqrt(varA/float64(len(a)) + varB/float64(len(b)))
+			d := methodDelta{Report: r.Label}
+			d.Mean = interval{Delta: meanB - meanA, Low: meanB - meanA - z*se, High: meanB - meanA + z*se}
+
+			d.Median.Delta = quantile(b, 0.5) - quantile(a, 0.5)
+			d.Median.Low, d.Median.High = bootstrapMedianDelta(rng, a, b, *resamples, *confidence)
+
+			s := mc.Stats[r.Label]
+			pa, pb := baseStats.ErrorRate, s.ErrorRate
+			se = math.Sqrt(pa*(1-pa)/float64(baseStats.Count) + pb*(1-pb)/float64(s.Count))
+			d.ErrorRate = interval{Delta: pb - pa, Low: pb - pa - z*se, High: pb - pa + z*se}
+
+			mc.Deltas = append(mc.Deltas, d)
+		}
+
+		c.Methods = append(c.Methods, mc)
+	}
+
+	return c
+}
+
+func printComparison(c *comparison) {
+	fmt.Printf("Baseline: %s, confidence %.0f%%\n\n", c.Baseline, c.Confidence*100)
+	for _, m := range c.Methods {
+		fmt.Printf("%s\n", m.Method)
+		for _, label := range c.Reports {
+			s := m.Stats[label]
+			fmt.Printf("  %-20s n=%-8d err=%6.2f%%  mean=%9.2fms  p50=%9.2fms  p90=%9.2fms  p99=%9.2fms\n", label, s.Count, s.ErrorRate*100, s.Mean, s.P50, s.P90, s.P99)
+		}
+
+		for _, d := range m.Deltas {
+			mark := func(i interval) string {
+				if i.Significant() {
+					return "*"
+				}
+				return " "
+			}
+			fmt.Printf("  %-20s mean %+8.2fms [%+.2f, %+.2f]%s  p50 %+8.2fms [%+.2f, %+.2f]%s  err %+6.2f%% [%+.2f, %+.2f]%s\n", "Δ "+d.Report,
+				d.Mean.Delta, d.Mean.Low, d.Mean.High, mark(d.Mean),
+				d.Median.Delta, d.Median.Low, d.Median.High, mark(d.Median),
+				d.ErrorRate.Delta*100, d.ErrorRate.Low*100, d.ErrorRate.High*100, mark(d.ErrorRate))
+		}
+		fmt.Println()
+	}
+
+	if len(c.Skipped) > 0 {
+		fmt.Printf("Skipped methods with fewer than %d successful requests in some report: %s\n", *minCount, strings.Join(c.Skipped, ", "))
+	}
+}
+
+var htmlTemplate = template.Must(template.New("summary").Funcs(template.FuncMap{
+	"pct": func(f float64) string { return fmt.Sprintf("%.2f%%", f*100) },
+	"ms":  func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"class": func(i interval) string {
+		switch {
+		case i.Low > 0:
+			return "worse"
+		case i.High < 0:
+			return "better"
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Load test comparison</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.worse { background: #f8d7da; }
+.better { background: #d4edda; }
+</style>
+</head>
+<body>
+<h1>Load test comparison</h1>
+<p>Baseline <b>{{.Baseline}}</b>. Intervals at {{pct .Confidence}} confidence; red cells are significant regressions, green cells significant improvements.</p>
+{{range $m := .Methods}}
+<h2>{{$m.Method}}</h2>
+<table>
+<tr><th>report</th><th>requests</th><th>error rate</th><th>mean ms</th><th>p50 ms</th><th>p90 ms</th><th>p99 ms</th></tr>
+{{range $label := $.Reports}}{{with index $m.Stats $label}}<tr><td>{{$label}}</td><td>{{.Count}}</td><td>{{pct .ErrorRate}}</td><td>{{ms .Mean}}</td><td>{{ms .P50}}</td><td>{{ms .P90}}</td><td>{{ms .P99}}</td></tr>
+{{end}}{{end}}</table>
+<table>
+<tr><th>vs baseline</th><th>mean Δ ms</th><th>p50 Δ ms</th><th>error rate Δ</th></tr>
+{{range $m.Deltas}}<tr><td>{{.Report}}</td>
+<td class="{{class .Mean}}">{{ms .Mean.Delta}} [{{ms .Mean.Low}}, {{ms .Mean.High}}]</td>
+<td class="{{class .Median}}">{{ms .Median.Delta}} [{{ms .Median.Low}}, {{ms .Median.High}}]</td>
+<td class="{{class .ErrorRate}}">{{pct .ErrorRate.Delta}} [{{pct .ErrorRate.Low}}, {{pct .ErrorRate.High}}]</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Skipped}}<p>Skipped methods with too few requests: {{range .Skipped}}{{.}} {{end}}</p>{{end}}
+</body>
+</html>
+`))
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	if len(*reports) < 2 {
+		log.Fatal("At least two reports are needed for a comparison")
+	}
+
+	if *confidence <= 0 || *confidence >= 1 {
+		log.Fatal("--confidence must be between 0 and 1")
+	}
+
+	var all []*report
+	for _, arg := range *reports {
+		r, err := loadReport(arg)
+		if err != nil {
+			log.Fatalf("Failed to load %s: %s", arg, err)
+		}
+		all = append(all, r)
+	}
+
+	c := compare(all)
+	printComparison(c)
+
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*jsonOut, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *htmlOut != "" {
+		f, err := os.Create(*htmlOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer f.Close()
+
+		if err := htmlTemplate.Execute(f, c); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("HTML summary written to %s\n", *htmlOut)
+	}
+}
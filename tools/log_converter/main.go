@@ -0,0 +1,429 @@
+//
+// Converts Clio's plaintext logs into structured JSON.
+//
+// Lines are expected in the default log_format:
+//
+//	%TimeStamp% (%SourceLocation%) [%ThreadID%] %Channel%:%Severity% %Message%
+//
+// Lines that do not match are treated as the continuation of the previous message. Every entry becomes
+// one JSON object:
+//
+//	{"timestamp": "2024-01-10T12:34:56.123456Z", "file": "etl/impl/Loading.hpp", "line": 72,
+//	 "thread": "0x00007f1c2affd640", "channel": "ETL", "level": "info", "message": "..."}
+//
+// Entries can be written as NDJSON or a JSON array, and shipped to Elasticsearch (bulk API) or
+// Loki (push API, labelled by channel and level).
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	inputs    = kingpin.Arg("files", "Log files to convert, optionally gzipped. Reads stdin when omitted").Strings()
+	channels  = kingpin.Flag("channel", "Only keep entries of this channel (i.e. ETL, RPC, Backend); can be repeated").Strings()
+	minLevel  = kingpin.Flag("level", "Only keep entries at or above this level: trace, debug, info, warning, error or fatal").Default("trace").Enum("trace", "debug", "info", "warning", "error", "fatal")
+	grep      = kingpin.Flag("grep", "Only keep entries whose message matches this regular expression").String()
+	since     = kingpin.Flag("since", "Only keep entries at or after this time (RFC3339)").String()
+	until     = kingpin.Flag("until", "Only keep entries before this time (RFC3339)").String()
+	format    = kingpin.Flag("format", "Output format").Default("ndjson").Enum("ndjson", "json", "none")
+	outFile   = kingpin.Flag("out", "Write the output to this file instead of stdout").Short('o').String()
+	stats     = kingpin.Flag("stats", "Print entry counts per channel and level to stderr at the end").Default("false").Bool()
+	esURL     = kingpin.Flag("elasticsearch", "Ship entries to this Elasticsearch URL").String()
+	esIndex   = kingpin.Flag("es-index", "Elasticsearch index to write to").Default("clio-logs").String()
+	lokiURL   = kingpin.Flag("loki", "Ship entries to this Loki URL").String()
+	lokiJob   = kingpin.Flag("loki-job", "Value of the job label of entries shipped to Loki").Default("clio").String()
+	host      = kingpin.Flag("host", "Value of the host field/label added to shipped entries").String()
+	batchSize = kingpin.Flag("batch-size", "Number of entries per shipping request").Default("1000").Int()
+)
+
+// Clio severity labels and the level names used by its config
+var levels = map[string]string{
+	"TRC": "trace",
+	"DBG": "debug",
+	"NFO": "info",
+	"WRN": "warning",
+	"ERR": "error",
+	"FTL": "fatal",
+}
+
+var levelRank = map[string]int{"trace": 0, "debug": 1, "info": 2, "warning": 3, "error": 4, "fatal": 5}
+
+var lineRegex = regexp.MustCompile(`^(\d{4}-\S+ \d{2}:\d{2}:\d{2}(?:\.\d+)?) \((.*?)(?::(\d+))?\) \[([^\]]+)\] (\w+):(TRC|DBG|NFO|WRN|ERR|FTL) ?(.*)$`)
+
+// boost's default timestamp format first, then ISO variants used with custom formats
+var timeLayouts = []string{
+	"2006-Jan-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+type entry struct {
+	Timestamp string `json:"timestamp"`
+	File      string `json:"file"`
+	Line      int    `json:"line,omitempty"`
+	Thread    string `json:"thread"`
+	Channel   string `json:"channel"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Host      string `json:"host,omitempty"`
+
+	time time.Time
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseLine(line string) (*entry, bool) {
+	m := lineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	t, ok := parseTime(m[1])
+	if !ok {
+		return nil, false
+	}
+
+	e := &entry{
+		Timestamp: t.Format(time.RFC3339Nano),
+		File:      m[2],
+		Thread:    m[4],
+		Channel:   m[5],
+		Level:     levels[m[6]],
+		Message:   m[7],
+		Host:      *host,
+		time:      t,
+	}
+	e.Line, _ = strconv.Atoi(m[3])
+	return e, true
+}
+
+type filter struct {
+	channels map[string]bool
+	minRank  int
+	grep     *regexp.Regexp
+	since    time.Time
+	until    time.Time
+}
+
+func (f *filter) keep(e *entry) bool {
+	if len(f.channels) > 0 && !f.channels[strings.ToLower(e.Channel)] {
+		return false
+	}
+
+	if levelRank[e.Level] < f.minRank {
+		return false
+	}
+
+	if !f.since.IsZero() && e.time.Before(f.since) {
+		return false
+	}
+
+	if !f.until.IsZero() && !e.time.Before(f.until) {
+		return false
+	}
+
+	return f.grep == nil || f.grep.MatchString(e.Message)
+}
+
+// parses a log stream, joining continuation lines to the entry they belong to
+func parse(r io.Reader, emit func(*entry)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	var current *entry
+	for scanner.Scan() {
+		line := scanner.Text()
+		if e, ok := parseLine(line); ok {
+			if current != nil {
+				emit(current)
+			}
+			current = e
+		} else if current != nil {
+			current.Message += "\n" + line
+		}
+	}
+
+	if current != nil {
+		emit(current)
+	}
+	return scanner.Err()
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+type shipper interface {
+	ship(batch []*entry) error
+}
+
+type elasticsearch struct {
+	url   string
+	index string
+}
+
+func (s *elasticsearch) ship(batch []*entry) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, e := range batch {
+		encoder.Encode(map[string]interface{}{"index": map[string]string{"_index": s.index}})
+		encoder.Encode(e)
+	}
+
+	resp, err := http.Post(strings.TrimSuffix(s.url, "/")+"/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode >= 300 {
+		return fmt.Errorf("elasticsearch bulk request failed with status %s", resp.Status)
+	}
+
+	if result.Errors {
+		return fmt.Errorf("elasticsearch rejected some of the entries")
+	}
+	return nil
+}
+
+type loki struct {
+	url string
+	job string
+}
+
+func (s *loki) ship(batch []*entry) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	streams := make(map[string]*stream)
+	var order []string
+	for _, e := range batch {
+		key := e.Channel + "/" + e.Level
+		st, ok := streams[key]
+		if !ok {
+			labels := map[string]string{"job": s.job, "channel": e.Channel, "level": e.Level}
+			if e.Host != "" {
+				labels["host"] = e.Host
+			}
+			st = &stream{Stream: labels}
+			streams[key] = st
+			order = append(order, key)
+		}
+
+		line, _ := json.Marshal(e)
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), string(line)})
+	}
+
+	var push struct {
+		Streams []*stream `json:"streams"`
+	}
+	for _, key := range order {
+		push.Streams = append(push.Streams, streams[key])
+	}
+
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(strings.TrimSuffix(s.url, "/")+"/loki/api/v1/push", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("loki push failed with status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	f := &filter{channels: make(map[string]bool), minRank: levelRank[*minLevel]}
+	for _, c := range *channels {
+		f.channels[strings.ToLower(c)] = true
+	}
+
+	if *grep != "" {
+		f.grep = regexp.MustCompile(*grep)
+	}
+
+	for _, bound := range []struct {
+		value  string
+		target *time.Time
+	}{{*since, &f.since}, {*until, &f.until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			log.Fatalf("Bad time %s: %s", bound.value, err)
+		}
+		*bound.target = t
+	}
+
+	var shippers []shipper
+	if *esURL != "" {
+		shippers = append(shippers, &elasticsearch{url: *esURL, index: *esIndex})
+	}
+	if *lokiURL != "" {
+		shippers = append(shippers, &loki{url: *lokiURL, job: *lokiJob})
+	}
+
+	out := os.Stdout
+	if *outFile != "" {
+		var err error
+		out, err = os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+
+	w := bufio.NewWriter(out)
+
+	encoder := json.NewEncoder(w)
+	counts := make(map[string]int)
+	var total, kept, shipped int
+	var batch []*entry
+
+	flush := func() {
+		for _, s := range shippers {
+			if err := s.ship(batch); err != nil {
+				log.Fatalf("Failed to ship entries: %s", err)
+			}
+		}
+		shipped += len(batch)
+		batch = batch[:0]
+	}
+
+	if *format == "json" {
+		w.WriteString("[")
+	}
+
+	emit := func(e *entry) {
+		total++
+		if !f.keep(e) {
+			return
+		}
+
+		switch *format {
+		case "ndjson":
+			encoder.Encode(e)
+		case "json":
+			if kept > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+			data, _ := json.Marshal(e)
+			w.Write(data)
+		}
+
+		kept++
+		counts[e.Channel+":"+e.Level]++
+
+		if len(shippers) > 0 {
+			batch = append(batch, e)
+			if len(batch) >= *batchSize {
+				flush()
+			}
+		}
+	}
+
+	if len(*inputs) == 0 {
+		if err := parse(os.Stdin, emit); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, path := range *inputs {
+		r, err := openInput(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = parse(r, emit)
+		r.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %s", path, err)
+		}
+	}
+
+	if len(batch) > 0 {
+		flush()
+	}
+
+	if *format == "json" {
+		w.WriteString("\n]\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *stats {
+		var keys []string
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(os.Stderr, "%d entries parsed, %d kept, %d shipped\n", total, kept, shipped)
+		for _, k := range keys {
+			fmt.Fprintf(os.Stderr, "  %-30s %d\n", k, counts[k])
+		}
+	}
+}
@@ -0,0 +1,671 @@
+//
+// Joins per-request load test records with Clio's RPC logs to show where the time of every request went.
+//
+// Requests are read from NDJSON, one record per fired request:
+//
+//	{"time": "2024-01-10T12:34:56.123456Z", "method": "account_tx", "latency_ms": 812.5, "status": "success",
+//	 "error": "", "source": "10.0.0.7:53412", "id": 17}
+//
+// where time is when the request was sent and source is the local address of the connection it was sent
+// on. Clio logs are expected in the default log_format with log_tag_style set to uint or uuid and the RPC,
+// WebServer and Performance channels logging at info level or below (debug adds the server side errors).
+//
+// Every logged request is reconstructed from its lines: the WebServer receipt, the RPC "received request
+// from work queue" line and the "Request processing duration" line, chained by their tags. A fired request
+// is then matched to the logged request of the same method and client IP received closest to its send
+// time, preferring the connection earlier requests from the same source port were matched to. The clock
+// offset between the load generator and Clio is estimated from the matches unless --clock-offset is given.
+//
+// For every request the latency is split into the time spent in Clio's work queue, in the handler, and
+// outside of Clio's request handling (network, parsing, writing the response).
+//
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	requestsFile = kingpin.Arg("requests", "NDJSON file with one record per fired request").Required().ExistingFile()
+	logFiles     = kingpin.Arg("logs", "Clio log files, optionally gzipped").Required().ExistingFiles()
+	timezone     = kingpin.Flag("log-timezone", "Time zone of the log timestamps").Default("UTC").String()
+	clockOffset  = kingpin.Flag("clock-offset", "Time to add to request times to get log times, or 'auto' to estimate it").Default("auto").String()
+	skew         = kingpin.Flag("skew", "Tolerance around a request's send and receive times when matching").Default("100ms").Duration()
+	maxSkew      = kingpin.Flag("max-skew", "Tolerance used while estimating the clock offset").Default("5s").Duration()
+	top          = kingpin.Flag("top", "Number of slowest requests to break down").Default("20").Int()
+	slow         = kingpin.Flag("slow", "Break down every request slower than this instead of the --top slowest").Duration()
+	outFile      = kingpin.Flag("out", "Write every request with its server side view as NDJSON to this file").Short('o').String()
+)
+
+var lineRegex = regexp.MustCompile(`^(\d{4}-\S+ \d{2}:\d{2}:\d{2}(?:\.\d+)?) \((.*?)(?::(\d+))?\) \[([^\]]+)\] (\w+):(TRC|DBG|NFO|WRN|ERR|FTL) ?(.*)$`)
+
+// boost's default timestamp format first, then ISO variants used with custom formats
+var timeLayouts = []string{
+	"2006-Jan-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+var durationRegex = regexp.MustCompile(`^Request processing duration = (\d+) milliseconds\. request = (.*)$`)
+
+type clientRequest struct {
+	Time      time.Time       `json:"time"`
+	Method    string          `json:"method"`
+	LatencyMS float64         `json:"latency_ms"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error"`
+	Source    string          `json:"source"`
+	ID        json.RawMessage `json:"id,omitempty"`
+}
+
+func (r *clientRequest) end() time.Time {
+	return r.Time.Add(time.Duration(r.LatencyMS * float64(time.Millisecond)))
+}
+
+type serverRequest struct {
+	Connection   string    `json:"connection"`
+	Context      string    `json:"context,omitempty"`
+	IP           string    `json:"ip"`
+	Protocol     string    `json:"protocol"`
+	Received     time.Time `json:"received"`
+	QueueMS      float64   `json:"queue_ms"`
+	ProcessingMS float64   `json:"processing_ms"`
+	ServerMS     float64   `json:"server_ms"`
+	Error        string    `json:"error,omitempty"`
+
+	method   string
+	dequeued time.Time
+	finished time.Time
+	params   []string
+	matched  bool
+}
+
+type merged struct {
+	*clientRequest
+	Server    *serverRequest `json:"server,omitempty"`
+	OutsideMS *float64       `json:"outside_ms,omitempty"`
+	Dominant  string         `json:"dominant,omitempty"`
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// splits the tag chain off a message; "[[5] 7] msg" gives [5 7]
+func splitTags(msg string) ([]string, string) {
+	var tags []string
+	for strings.HasPrefix(msg, "[") {
+		depth, end := 0, -1
+		for i, c := range msg {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+
+		chain := msg[1:end]
+		msg = strings.TrimPrefix(msg[end+1:], " ")
+		for strings.HasPrefix(chain, "[") {
+			inner := strings.Index(chain, "]")
+			tags = append(tags, chain[1:inner])
+			chain = strings.TrimSpace(chain[inner+1:])
+		}
+		tags = append(tags, chain)
+	}
+	return tags, msg
+}
+
+// canonical re-serialisation of a JSON value so requests can be compared with their logged params
+func canonical(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+type logParser struct {
+	loc       *time.Location
+	requests  []*serverRequest
+	received  map[string][]time.Time
+	pending   map[string][]*serverRequest
+	byContext map[string]*serverRequest
+}
+
+func newLogParser(loc *time.Location) *logParser {
+	return &logParser{
+		loc:       loc,
+		received:  make(map[string][]time.Time),
+		pending:   make(map[string][]*serverRequest),
+		byContext: make(map[string]*serverRequest),
+	}
+}
+
+// takes the pending request of a connection whose params match, or the oldest one
+func (p *logParser) take(conn string, params string) *serverRequest {
+	queue := p.pending[conn]
+	if len(queue) == 0 {
+		return nil
+	}
+
+	idx := 0
+	for i := len(queue) - 1; i >= 0; i-- {
+		for _, candidate := range queue[i].params {
+			if candidate == params {
+				idx = i
+			}
+		}
+	}
+
+	r := queue[idx]
+	p.pending[conn] = append(queue[:idx:idx], queue[idx+1:]...)
+	return r
+}
+
+func (p *logParser) finish(r *serverRequest, t time.Time) {
+	r.finished = t
+	r.QueueMS = float64(r.dequeued.Sub(r.Received)) / float64(time.Millisecond)
+	r.ServerMS = float64(t.Sub(r.Received)) / float64(time.Millisecond)
+}
+
+func (p *logParser) handle(t time.Time, channel string, msg string) {
+	tags, msg := splitTags(msg)
+	var conn, ctx string
+	if len(tags) > 0 {
+		conn = tags[0]
+	}
+	if len(tags) > 1 {
+		ctx = tags[len(tags)-1]
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "Received request from ip = "):
+		p.received[conn] = append(p.received[conn], t)
+
+	case strings.Contains(msg, " received request from work queue: "):
+		protocol, rest, _ := strings.Cut(msg, " received request from work queue: ")
+		idx := strings.LastIndex(rest, " ip = ")
+		if idx < 0 {
+			return
+		}
+
+		r := &serverRequest{Connection: conn, IP: rest[idx+len(" ip = "):], Protocol: protocol, Received: t, dequeued: t}
+		if queue := p.received[conn]; len(queue) > 0 {
+			r.Received = queue[0]
+			p.received[conn] = queue[1:]
+		}
+
+		var request map[string]interface{}
+		if err := json.Unmarshal([]byte(rest[:idx]), &request); err == nil {
+			r.method, _ = request["method"].(string)
+			if r.method == "" {
+				r.method, _ = request["command"].(string)
+			}
+			r.params = append(r.params, canonical(request))
+			if params, ok := request["params"].([]interface{}); ok && len(params) == 1 {
+				r.params = append(r.params, canonical(params[0]))
+			}
+		}
+
+		p.requests = append(p.requests, r)
+		p.pending[conn] = append(p.pending[conn], r)
+
+	case durationRegex.MatchString(msg):
+		m := durationRegex.FindStringSubmatch(msg)
+		var params interface{}
+		json.Unmarshal([]byte(m[2]), &params)
+
+		r := p.take(conn, canonical(params))
+		if r == nil {
+			return
+		}
+
+		p.finish(r, t)
+		r.Context = ctx
+		r.ProcessingMS, _ = strconv.ParseFloat(m[1], 64)
+		p.byContext[conn+"/"+ctx] = r
+
+	case strings.HasPrefix(msg, "Encountered error: "):
+		r := p.byContext[conn+"/"+ctx]
+		if r == nil || r.Error != "" {
+			return
+		}
+
+		var response map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(msg, "Encountered error: ")), &response); err != nil {
+			return
+		}
+		if result, ok := response["result"].(map[string]interface{}); ok {
+			response = result
+		}
+		r.Error, _ = response["error"].(string)
+
+	case channel == "RPC" && (strings.HasPrefix(msg, "Could not create Web context: ") || strings.HasPrefix(msg, "Caught exception: ")):
+		// both are logged to the Performance channel as well; only the RPC copy is used
+		var r *serverRequest
+		if len(tags) > 1 {
+			r = p.byContext[conn+"/"+ctx]
+		} else if r = p.take(conn, ""); r != nil {
+			p.finish(r, t)
+		}
+		if r != nil && r.Error == "" {
+			_, r.Error, _ = strings.Cut(msg, ": ")
+		}
+	}
+}
+
+func (p *logParser) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		m := lineRegex.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+
+		t, ok := parseTime(m[1], p.loc)
+		if !ok {
+			continue
+		}
+		p.handle(t, m[5], m[7])
+	}
+	return scanner.Err()
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+func loadRequests(path string) ([]*clientRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var requests []*clientRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var r clientRequest
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if r.Time.IsZero() {
+			return nil, fmt.Errorf("%s:%d: request without a time", path, line)
+		}
+		requests = append(requests, &r)
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+	return requests, scanner.Err()
+}
+
+func ipOf(source string) string {
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		return host
+	}
+	return source
+}
+
+// matches every request to the closest logged request of the same method and client, returns the offsets
+// between send and receive times of the matches
+func match(requests []*clientRequest, byMethod map[string][]*serverRequest, offset time.Duration, tolerance time.Duration) (map[*clientRequest]*serverRequest, []time.Duration) {
+	for _, list := range byMethod {
+		for _, s := range list {
+			s.matched = false
+		}
+	}
+
+	matches := make(map[*clientRequest]*serverRequest)
+	connections := make(map[string]string)
+	var offsets []time.Duration
+
+	for _, c := range requests {
+		list := byMethod[c.Method]
+		lo := c.Time.Add(offset - tolerance)
+		hi := c.end().Add(offset + tolerance)
+		ip := ipOf(c.Source)
+		bound, hasBound := connections[c.Source]
+
+		var best *serverRequest
+		var bestScore time.Duration
+		for i := sort.Search(len(list), func(i int) bool { return !list[i].Received.Before(lo) }); i < len(list) && !list[i].Received.After(hi); i++ {
+			s := list[i]
+			if s.matched || (ip != "" && s.IP != "" && s.IP != ip) {
+				continue
+			}
+
+			score := s.Received.Sub(c.Time.Add(offset))
+			if score < 0 {
+				score = -score
+			}
+			if hasBound && s.Connection != bound {
+				// connections are reused, so a request on another one is only picked when nothing else fits
+				score += time.Hour
+			}
+
+			if best == nil || score < bestScore {
+				best, bestScore = s, score
+			}
+		}
+
+		if best == nil {
+			continue
+		}
+
+		best.matched = true
+		matches[c] = best
+		offsets = append(offsets, best.Received.Sub(c.Time))
+		if c.Source != "" && best.Connection != "" {
+			connections[c.Source] = best.Connection
+		}
+	}
+
+	return matches, offsets
+}
+
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// quantile of sorted values, interpolating between the closest ranks
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func mean(v []float64) float64 {
+	if len(v) == 0 {
+		return math.NaN()
+	}
+
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+func breakdown(c *clientRequest, s *serverRequest) *merged {
+	m := &merged{clientRequest: c, Server: s}
+	if s == nil {
+		return m
+	}
+
+	outside := c.LatencyMS - s.ServerMS
+	m.OutsideMS = &outside
+
+	m.Dominant = "queue"
+	largest := s.QueueMS
+	if s.ProcessingMS > largest {
+		m.Dominant, largest = "processing", s.ProcessingMS
+	}
+	if outside > largest {
+		m.Dominant = "outside"
+	}
+	return m
+}
+
+func printSummary(all []*merged) {
+	type methodSummary struct {
+		requests, matched               int
+		latency, queue, processing, out []float64
+	}
+
+	methods := make(map[string]*methodSummary)
+	var names []string
+	for _, m := range all {
+		s, ok := methods[m.Method]
+		if !ok {
+			s = &methodSummary{}
+			methods[m.Method] = s
+			names = append(names, m.Method)
+		}
+
+		s.requests++
+		s.latency = append(s.latency, m.LatencyMS)
+		if m.Server != nil {
+			s.matched++
+			s.queue = append(s.queue, m.Server.QueueMS)
+			s.processing = append(s.processing, m.Server.ProcessingMS)
+			s.out = append(s.out, *m.OutsideMS)
+		}
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-28s %9s %8s %10s %10s %10s %12s %10s\n", "method", "requests", "matched", "p50 ms", "p99 ms", "queue ms", "handler ms", "outside ms")
+	for _, name := range names {
+		s := methods[name]
+		sort.Float64s(s.latency)
+		fmt.Printf("%-28s %9d %7.1f%% %10.2f %10.2f %10.2f %12.2f %10.2f\n", name, s.requests, float64(s.matched)*100/float64(s.requests),
+			quantile(s.latency, 0.5), quantile(s.latency, 0.99), mean(s.queue), mean(s.processing), mean(s.out))
+	}
+	fmt.Println()
+}
+
+func printOutliers(all []*merged) {
+	sorted := append([]*merged{}, all...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].LatencyMS > sorted[j].LatencyMS })
+
+	var outliers []*merged
+	for _, m := range sorted {
+		if *slow > 0 && m.LatencyMS < float64(*slow)/float64(time.Millisecond) {
+			break
+		}
+		if *slow == 0 && len(outliers) >= *top {
+			break
+		}
+		outliers = append(outliers, m)
+	}
+
+	if len(outliers) == 0 {
+		return
+	}
+
+	fmt.Printf("Slowest requests:\n")
+	fmt.Printf("%-27s %-24s %-22s %10s %10s %10s %10s  %-10s %s\n", "time", "method", "source", "latency", "queue", "handler", "outside", "dominant", "connection / error")
+	for _, m := range outliers {
+		if m.Server == nil {
+			fmt.Printf("%-27s %-24s %-22s %10.2f %10s %10s %10s  %-10s %s\n", m.Time.Format(time.RFC3339Nano), m.Method, m.Source, m.LatencyMS, "-", "-", "-", "-", "not found in the logs")
+			continue
+		}
+
+		detail := "[" + m.Server.Connection
+		if m.Server.Context != "" {
+			detail += "] " + m.Server.Context
+		} else {
+			detail += "]"
+		}
+		if m.Server.Error != "" {
+			detail += " " + m.Server.Error
+		} else if m.Error != "" {
+			detail += " client: " + m.Error
+		}
+
+		fmt.Printf("%-27s %-24s %-22s %10.2f %10.2f %10.2f %10.2f  %-10s %s\n", m.Time.Format(time.RFC3339Nano), m.Method, m.Source,
+			m.LatencyMS, m.Server.QueueMS, m.Server.ProcessingMS, *m.OutsideMS, m.Dominant, detail)
+	}
+	fmt.Println()
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Fatalf("Unknown time zone %s: %s", *timezone, err)
+	}
+
+	requests, err := loadRequests(*requestsFile)
+	if err != nil {
+		log.Fatalf("Failed to load requests: %s", err)
+	}
+	if len(requests) == 0 {
+		log.Fatal("No requests to correlate")
+	}
+
+	p := newLogParser(loc)
+	for _, path := range *logFiles {
+		r, err := openInput(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = p.parse(r)
+		r.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %s", path, err)
+		}
+	}
+
+	byMethod := make(map[string][]*serverRequest)
+	for _, s := range p.requests {
+		if !s.finished.IsZero() {
+			byMethod[s.method] = append(byMethod[s.method], s)
+		}
+	}
+	for _, list := range byMethod {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Received.Before(list[j].Received) })
+	}
+
+	var offset time.Duration
+	if *clockOffset == "auto" {
+		_, offsets := match(requests, byMethod, 0, *maxSkew)
+		offset = median(offsets)
+		log.Printf("Estimated clock offset: %s from %d matches\n", offset, len(offsets))
+	} else if offset, err = time.ParseDuration(*clockOffset); err != nil {
+		log.Fatalf("Invalid --clock-offset: %s", err)
+	}
+
+	matches, _ := match(requests, byMethod, offset, *skew)
+
+	all := make([]*merged, 0, len(requests))
+	var clientErrors, explained int
+	for _, c := range requests {
+		m := breakdown(c, matches[c])
+		all = append(all, m)
+
+		if c.Error != "" || (c.Status != "" && c.Status != "success") {
+			clientErrors++
+			if m.Server != nil && m.Server.Error != "" {
+				explained++
+			}
+		}
+	}
+
+	// logged requests received while the load test ran but not matched to any fired request
+	first := requests[0].Time.Add(offset - *skew)
+	var last time.Time
+	for _, c := range requests {
+		if end := c.end(); end.After(last) {
+			last = end
+		}
+	}
+	last = last.Add(offset + *skew)
+
+	var unmatchedServer, serverErrors int
+	for _, list := range byMethod {
+		for _, s := range list {
+			if s.Received.Before(first) || s.Received.After(last) {
+				continue
+			}
+			if s.Error != "" {
+				serverErrors++
+			}
+			if !s.matched {
+				unmatchedServer++
+			}
+		}
+	}
+
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := bufio.NewWriter(f)
+		encoder := json.NewEncoder(w)
+		for _, m := range all {
+			encoder.Encode(m)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+
+	printSummary(all)
+	printOutliers(all)
+
+	log.Printf("TOTAL REQUESTS: %d\n", len(requests))
+	log.Printf("TOTAL LOGGED REQUESTS: %d\n", len(p.requests))
+	log.Printf("TOTAL MATCHED: %d\n", len(matches))
+	log.Printf("TOTAL UNMATCHED REQUESTS: %d\n", len(requests)-len(matches))
+	log.Printf("TOTAL UNMATCHED LOGGED REQUESTS: %d\n", unmatchedServer)
+	log.Printf("TOTAL SERVER ERRORS: %d\n", serverErrors)
+	log.Printf("TOTAL CLIENT ERRORS: %d (%d explained by a server error)\n", clientErrors, explained)
+}
@@ -0,0 +1,323 @@
+//
+// Walks paginated API methods at a pinned validated ledger and checks that the pages add up.
+//
+// Every round walks each --method once per --page-size from each of --connections independent
+// connections, all at the same time. Within a walk no item may appear twice, every returned marker must
+// be accepted by the next request and markers must not repeat. Across walks every page size and every
+// connection must yield exactly the same items; the first walk of the first round is the reference and
+// items missing from or added to any other walk are reported.
+//
+// ledger_data walks of a whole mainnet ledger take a long time, so --max-items stops a walk early. Since
+// ledger_data returns objects in key order, capped walks are compared on their common prefix.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	clioURL     = kingpin.Arg("url", "JSON-RPC URL of the Clio server").Required().String()
+	methods     = kingpin.Flag("method", "Paginated method to walk; can be repeated").Default("account_objects", "ledger_data", "account_lines").Enums("account_objects", "ledger_data", "account_lines")
+	account     = kingpin.Flag("account", "Account to walk account_objects and account_lines for").String()
+	ledgerIdx   = kingpin.Flag("ledger", "Ledger index to pin the walks to. Defaults to the latest validated ledger").Uint64()
+	pageSizes   = kingpin.Flag("page-sizes", "Comma separated page sizes to walk with").Default("10,50,200").String()
+	connections = kingpin.Flag("connections", "Number of independent connections walking concurrently").Default("3").Int()
+	rounds      = kingpin.Flag("rounds", "Number of times every walk is repeated").Default("2").Int()
+	maxItems    = kingpin.Flag("max-items", "Stop a walk after this many items, 0 for no limit").Default("20000").Int()
+	timeout     = kingpin.Flag("timeout", "Timeout of every request").Default("30s").Duration()
+	reportTo    = kingpin.Flag("report", "Write a JSON report to this file").String()
+)
+
+type methodInfo struct {
+	resultField string
+	ordered     bool
+	id          func(item map[string]interface{}) string
+}
+
+func indexOf(item map[string]interface{}) string {
+	index, _ := item["index"].(string)
+	return index
+}
+
+var knownMethods = map[string]methodInfo{
+	"account_objects": {"account_objects", false, indexOf},
+	"ledger_data":     {"state", true, indexOf},
+	"account_lines": {"lines", false, func(item map[string]interface{}) string {
+		peer, _ := item["account"].(string)
+		currency, _ := item["currency"].(string)
+		return peer + "/" + currency
+	}},
+}
+
+type finding struct {
+	Kind    string `json:"kind"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+type report struct {
+	Tool        string    `json:"tool"`
+	LedgerIndex uint64    `json:"ledger_index"`
+	Passed      bool      `json:"passed"`
+	Walks       int       `json:"walks"`
+	Findings    []finding `json:"findings"`
+}
+
+type walk struct {
+	method     string
+	pageSize   int
+	connection int
+	round      int
+	items      []string
+	pages      int
+	capped     bool
+	elapsed    time.Duration
+	findings   []finding
+}
+
+func (w *walk) name() string {
+	return fmt.Sprintf("%s[limit=%d,conn=%d,round=%d]", w.method, w.pageSize, w.connection, w.round)
+}
+
+func (w *walk) fail(kind, format string, args ...interface{}) {
+	w.findings = append(w.findings, finding{Kind: kind, Object: w.name(), Message: fmt.Sprintf(format, args...)})
+}
+
+func (w *walk) run(ctx context.Context, client *rpc.Client, seq uint64) {
+	info := knownMethods[w.method]
+	seen := make(map[string]bool)
+	markers := make(map[string]bool)
+	start := time.Now()
+	defer func() { w.elapsed = time.Since(start) }()
+
+	var marker interface{}
+	for {
+		params := map[string]interface{}{"ledger_index": seq, "limit": w.pageSize}
+		if w.method != "ledger_data" {
+			params["account"] = *account
+		}
+		if marker != nil {
+			params["marker"] = marker
+		}
+
+		result, err := client.Call(ctx, w.method, params)
+		if err != nil {
+			if marker != nil {
+				w.fail("marker_rejected", "page %d with marker %v failed: %s", w.pages+1, marker, err)
+			} else {
+				w.fail("request_failed", "first page failed: %s", err)
+			}
+			return
+		}
+		w.pages++
+
+		items, _ := result[info.resultField].([]interface{})
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			id := info.id(item)
+			if id == "" {
+				w.fail("bad_item", "page %d has an item without an identity", w.pages)
+				continue
+			}
+
+			if seen[id] {
+				w.fail("duplicate", "%s returned twice, again on page %d", id, w.pages)
+				continue
+			}
+
+			if info.ordered && len(w.items) > 0 && id < w.items[len(w.items)-1] {
+				w.fail("out_of_order", "%s on page %d comes after %s", id, w.pages, w.items[len(w.items)-1])
+			}
+
+			seen[id] = true
+			w.items = append(w.items, id)
+		}
+
+		marker = result["marker"]
+		if marker == nil {
+			return
+		}
+
+		key, _ := json.Marshal(marker)
+		if markers[string(key)] {
+			w.fail("marker_loop", "marker %s returned twice, stopping at page %d", key, w.pages)
+			return
+		}
+		markers[string(key)] = true
+
+		if len(items) == 0 {
+			// allowed, but worth knowing: an empty page that still carries a marker
+			log.Printf("NOTE: %s page %d is empty but has a marker\n", w.name(), w.pages)
+		}
+
+		if *maxItems > 0 && len(w.items) >= *maxItems {
+			w.capped = true
+			return
+		}
+	}
+}
+
+// compares a walk against the reference walk of the same method
+func compare(reference, w *walk) {
+	refItems, items := reference.items, w.items
+
+	// capped walks can only be compared on the items both have seen, which is a prefix for ordered methods
+	if reference.capped || w.capped {
+		if !knownMethods[w.method].ordered {
+			return
+		}
+
+		n := len(refItems)
+		if len(items) < n {
+			n = len(items)
+		}
+		refItems, items = refItems[:n], items[:n]
+	}
+
+	in := make(map[string]bool, len(items))
+	for _, id := range items {
+		in[id] = true
+	}
+
+	var skipped, extra []string
+	for _, id := range refItems {
+		if !in[id] {
+			skipped = append(skipped, id)
+		}
+		delete(in, id)
+	}
+	for id := range in {
+		extra = append(extra, id)
+	}
+	sort.Strings(extra)
+
+	if len(skipped) > 0 {
+		w.fail("skipped", "%d items of %s missing, first %s", len(skipped), reference.name(), skipped[0])
+	}
+	if len(extra) > 0 {
+		w.fail("extra", "%d items not in %s, first %s", len(extra), reference.name(), extra[0])
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	var sizes []int
+	for _, s := range strings.Split(*pageSizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid page size %q", s)
+		}
+		sizes = append(sizes, n)
+	}
+
+	for _, m := range *methods {
+		if m != "ledger_data" && *account == "" {
+			log.Fatalf("--account is required to walk %s", m)
+		}
+	}
+
+	ctx := context.Background()
+	clients := make([]*rpc.Client, *connections)
+	for i := range clients {
+		// a client per connection, so walks really do not share a connection
+		clients[i] = rpc.NewClient(*clioURL, *timeout)
+	}
+
+	seq := *ledgerIdx
+	if seq == 0 {
+		var err error
+		if seq, err = clients[0].ValidatedLedgerIndex(ctx); err != nil {
+			log.Fatalf("Failed to get the validated ledger: %s", err)
+		}
+	}
+
+	log.Printf("Walking %s at ledger %d with page sizes %v from %d connections, %d rounds\n", strings.Join(*methods, ", "), seq, sizes, *connections, *rounds)
+
+	rep := report{Tool: "pagination_tester", LedgerIndex: seq, Findings: []finding{}}
+	references := make(map[string]*walk)
+
+	for round := 1; round <= *rounds; round++ {
+		var walks []*walk
+		for _, m := range *methods {
+			for _, size := range sizes {
+				for c := range clients {
+					walks = append(walks, &walk{method: m, pageSize: size, connection: c, round: round})
+				}
+			}
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(len(walks))
+		for _, w := range walks {
+			go func(w *walk) {
+				defer wg.Done()
+				w.run(ctx, clients[w.connection], seq)
+			}(w)
+		}
+		wg.Wait()
+
+		for _, w := range walks {
+			if _, ok := references[w.method]; !ok && len(w.findings) == 0 {
+				references[w.method] = w
+			}
+		}
+
+		for _, w := range walks {
+			if ref, ok := references[w.method]; ok && ref != w {
+				compare(ref, w)
+			}
+
+			status := "ok"
+			if len(w.findings) > 0 {
+				status = fmt.Sprintf("%d problems", len(w.findings))
+			}
+			capped := ""
+			if w.capped {
+				capped = " (capped)"
+			}
+			log.Printf("  %-50s %6d items%s in %4d pages, %s: %s\n", w.name(), len(w.items), capped, w.pages, w.elapsed.Round(time.Millisecond), status)
+
+			rep.Walks++
+			rep.Findings = append(rep.Findings, w.findings...)
+		}
+	}
+
+	for _, f := range rep.Findings {
+		log.Printf("FAILED: %s: %s: %s\n", f.Kind, f.Object, f.Message)
+	}
+
+	rep.Passed = len(rep.Findings) == 0
+	log.Printf("TOTAL WALKS: %d\n", rep.Walks)
+	log.Printf("TOTAL PROBLEMS: %d\n", len(rep.Findings))
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !rep.Passed {
+		os.Exit(1)
+	}
+}
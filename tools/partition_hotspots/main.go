@@ -0,0 +1,353 @@
+//
+// Finds the largest partitions of the per-account and per-NFT history tables of a Clio keyspace.
+//
+// The partition keys of account_tx, nf_token_transactions and issuer_nf_tokens_v2 are scanned in
+// parallel token ranges. A partition always falls into a single token range and its rows come back
+// contiguously, so rows are counted without holding more than the current partition in memory. With
+// --sample only that fraction of the token ranges is scanned and the totals are extrapolated.
+//
+// The largest partitions are ranked per table together with the number of API pages needed to walk
+// them. With --measure the top partitions are also read in full, paged like Clio does, to time the
+// actual cost. Accounts near the top are the ones that will cause pagination timeouts first.
+//
+
+package main
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+const (
+	defaultNumberOfNodesInCluster = 3
+	defaultNumberOfCoresInNode    = 8
+	defaultSmudgeFactor           = 3
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	tableNames   = kingpin.Flag("table", "Table to scan; can be repeated").Default("account_tx", "nf_token_transactions", "issuer_nf_tokens_v2").Enums("account_tx", "nf_token_transactions", "issuer_nf_tokens_v2")
+	top          = kingpin.Flag("top", "Number of partitions to rank per table").Default("50").Int()
+	sample       = kingpin.Flag("sample", "Fraction of the token ranges to scan").Default("1.0").Float64()
+	apiPageSize  = kingpin.Flag("api-page-size", "Rows per API page used for the read cost estimate").Default("200").Int()
+	measure      = kingpin.Flag("measure", "Read this many of the top partitions of every table in full and time it").Default("0").Int()
+	jsonOutput   = kingpin.Flag("json", "Print the report as JSON").Default("false").Bool()
+
+	nodesInCluster  = kingpin.Flag("nodes-in-cluster", "Number of nodes in your Scylla cluster").Short('n').Default(fmt.Sprintf("%d", defaultNumberOfNodesInCluster)).Int()
+	coresInNode     = kingpin.Flag("cores-in-node", "Number of cores in each node").Short('c').Default(fmt.Sprintf("%d", defaultNumberOfCoresInNode)).Int()
+	smudgeFactor    = kingpin.Flag("smudge-factor", "Yet another factor to make parallelism cooler").Short('s').Default(fmt.Sprintf("%d", defaultSmudgeFactor)).Int()
+	clusterFlags    = cassandra.RegisterFlags(kingpin.CommandLine)
+	clusterPageSize = kingpin.Flag("cluster-page-size", "Page size of results").Short('p').Default("5000").Int()
+	keyspace        = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+
+	workerCount = 1
+)
+
+type tableInfo struct {
+	partitionKey string
+	// what the key identifies, and whether it is an account ID to be printed as an address
+	keyName   string
+	isAccount bool
+	// approximate bytes per row from the schema, used for the size estimate
+	rowBytes int
+	// query reading a whole partition in the order Clio pages through it
+	readQuery string
+}
+
+var knownTables = map[string]tableInfo{
+	"account_tx":            {"account", "account", true, 68, "SELECT hash FROM account_tx WHERE account = ?"},
+	"nf_token_transactions": {"token_id", "token_id", false, 80, "SELECT hash FROM nf_token_transactions WHERE token_id = ?"},
+	"issuer_nf_tokens_v2":   {"issuer", "issuer", true, 60, "SELECT token_id FROM issuer_nf_tokens_v2 WHERE issuer = ?"},
+}
+
+type partition struct {
+	Key          string  `json:"key"`
+	Rows         uint64  `json:"rows"`
+	EstimatedMB  float64 `json:"estimated_mb"`
+	APIPages     uint64  `json:"api_pages"`
+	MeasuredMs   float64 `json:"measured_ms,omitempty"`
+	MeasuredRows uint64  `json:"measured_rows,omitempty"`
+	raw          []byte
+}
+
+// a min-heap holding the largest `limit` partitions seen so far
+type ranking struct {
+	mu         sync.Mutex
+	limit      int
+	partitions []*partition
+}
+
+func (r *ranking) Len() int           { return len(r.partitions) }
+func (r *ranking) Less(i, j int) bool { return r.partitions[i].Rows < r.partitions[j].Rows }
+func (r *ranking) Swap(i, j int)      { r.partitions[i], r.partitions[j] = r.partitions[j], r.partitions[i] }
+func (r *ranking) Push(x interface{}) { r.partitions = append(r.partitions, x.(*partition)) }
+func (r *ranking) Pop() interface{} {
+	last := r.partitions[len(r.partitions)-1]
+	r.partitions = r.partitions[:len(r.partitions)-1]
+	return last
+}
+
+func (r *ranking) offer(key []byte, rows uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.partitions) < r.limit {
+		heap.Push(r, &partition{raw: key, Rows: rows})
+		return
+	}
+
+	if rows > r.partitions[0].Rows {
+		r.partitions[0] = &partition{raw: key, Rows: rows}
+		heap.Fix(r, 0)
+	}
+}
+
+func (r *ranking) sorted() []*partition {
+	out := append([]*partition{}, r.partitions...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Rows > out[j].Rows })
+	return out
+}
+
+type tableReport struct {
+	Table               string         `json:"table"`
+	RangesScanned       int            `json:"ranges_scanned"`
+	RangesTotal         int            `json:"ranges_total"`
+	Partitions          uint64         `json:"partitions"`
+	Rows                uint64         `json:"rows"`
+	EstimatedPartitions uint64         `json:"estimated_partitions"`
+	EstimatedRows       uint64         `json:"estimated_rows"`
+	MeanRows            float64        `json:"mean_rows_per_partition"`
+	Histogram           map[string]int `json:"partitions_by_rows"`
+	Errors              uint64         `json:"errors"`
+	Top                 []*partition   `json:"top"`
+}
+
+// power of ten bucket label for a row count, i.e. "100-999"
+func bucket(rows uint64) string {
+	low := uint64(1)
+	for low*10 <= rows {
+		low *= 10
+	}
+	return fmt.Sprintf("%d-%d", low, low*10-1)
+}
+
+func scanTable(session *gocql.Session, table string, ranges []*cassandra.TokenRange, totalRanges int) *tableReport {
+	info := knownTables[table]
+	rank := &ranking{limit: *top}
+	rep := &tableReport{Table: table, RangesScanned: len(ranges), RangesTotal: totalRanges, Histogram: make(map[string]int)}
+
+	var histogramMu sync.Mutex
+	var rangesDone uint64
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for i := range ranges {
+		rangesChannel <- ranges[i]
+	}
+	close(rangesChannel)
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE token(%s) >= ? AND token(%s) <= ?", info.partitionKey, table, info.partitionKey, info.partitionKey)
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			local := make(map[string]int)
+			for r := range rangesChannel {
+				iter := session.Query(query, r.StartRange, r.EndRange).Iter()
+
+				var current []byte
+				var rows uint64
+				flush := func() {
+					if rows == 0 {
+						return
+					}
+					atomic.AddUint64(&rep.Partitions, 1)
+					local[bucket(rows)]++
+					rank.offer(current, rows)
+				}
+
+				var key []byte
+				for iter.Scan(&key) {
+					atomic.AddUint64(&rep.Rows, 1)
+					if rows > 0 && string(key) == string(current) {
+						rows++
+					} else {
+						flush()
+						current = append([]byte{}, key...)
+						rows = 1
+					}
+				}
+				flush()
+
+				if err := iter.Close(); err != nil {
+					log.Printf("ERROR: page iteration failed: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: [table=%s][from=%d][to=%d]\n", table, r.StartRange, r.EndRange)
+					atomic.AddUint64(&rep.Errors, 1)
+				}
+
+				if done := atomic.AddUint64(&rangesDone, 1); done%uint64(workerCount*10) == 0 {
+					log.Printf("... %s: %d/%d token ranges scanned, %d rows ...\n", table, done, len(ranges), atomic.LoadUint64(&rep.Rows))
+				}
+			}
+
+			histogramMu.Lock()
+			for b, n := range local {
+				rep.Histogram[b] += n
+			}
+			histogramMu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	scale := float64(totalRanges) / float64(len(ranges))
+	rep.EstimatedPartitions = uint64(float64(rep.Partitions) * scale)
+	rep.EstimatedRows = uint64(float64(rep.Rows) * scale)
+	if rep.Partitions > 0 {
+		rep.MeanRows = float64(rep.Rows) / float64(rep.Partitions)
+	}
+
+	rep.Top = rank.sorted()
+	for _, p := range rep.Top {
+		if info.isAccount {
+			p.Key = xrpl.EncodeAccountID(p.raw)
+		} else {
+			p.Key = strings.ToUpper(hex.EncodeToString(p.raw))
+		}
+		p.EstimatedMB = float64(p.Rows*uint64(info.rowBytes)) / (1024 * 1024)
+		p.APIPages = (p.Rows + uint64(*apiPageSize) - 1) / uint64(*apiPageSize)
+	}
+
+	return rep
+}
+
+// reads a partition in full, one API page worth of rows per round trip
+func measurePartition(session *gocql.Session, table string, p *partition) error {
+	start := time.Now()
+	iter := session.Query(knownTables[table].readQuery, p.raw).PageSize(*apiPageSize).Iter()
+
+	var value []byte
+	for iter.Scan(&value) {
+		p.MeasuredRows++
+	}
+
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	p.MeasuredMs = float64(time.Since(start).Microseconds()) / 1000
+	return nil
+}
+
+func printReport(rep *tableReport) {
+	info := knownTables[rep.Table]
+
+	fmt.Printf("\n%s: %d partitions, %d rows in %d/%d token ranges\n", rep.Table, rep.Partitions, rep.Rows, rep.RangesScanned, rep.RangesTotal)
+	if rep.RangesScanned < rep.RangesTotal {
+		fmt.Printf("  estimated for the whole table: %d partitions, %d rows\n", rep.EstimatedPartitions, rep.EstimatedRows)
+	}
+	fmt.Printf("  mean rows per partition: %.1f\n", rep.MeanRows)
+
+	var buckets []string
+	for b := range rep.Histogram {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		var a, b uint64
+		fmt.Sscanf(buckets[i], "%d-", &a)
+		fmt.Sscanf(buckets[j], "%d-", &b)
+		return a < b
+	})
+	for _, b := range buckets {
+		fmt.Printf("  %20s rows: %d partitions\n", b, rep.Histogram[b])
+	}
+
+	fmt.Printf("\n  %-4s %-66s %12s %10s %10s %12s\n", "#", info.keyName, "rows", "est MB", "pages", "measured ms")
+	for i, p := range rep.Top {
+		measured := ""
+		if p.MeasuredRows > 0 {
+			measured = fmt.Sprintf("%.1f", p.MeasuredMs)
+		}
+		fmt.Printf("  %-4d %-66s %12d %10.2f %10d %12s\n", i+1, p.Key, p.Rows, p.EstimatedMB, p.APIPages, measured)
+	}
+
+	if rep.Errors > 0 {
+		fmt.Printf("\n  TOTAL ERRORS: %d (counts are incomplete)\n", rep.Errors)
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	if *sample <= 0 || *sample > 1 {
+		log.Fatal("--sample must be in (0, 1]")
+	}
+
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges := cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	sampled := ranges[:int(math.Max(1, math.Round(float64(len(ranges))**sample)))]
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+	cluster.PageSize = *clusterPageSize
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	var reports []*tableReport
+	for _, table := range *tableNames {
+		log.Printf("Scanning %s: %d of %d token ranges using %d workers\n", table, len(sampled), len(ranges), workerCount)
+		startTime := time.Now()
+
+		rep := scanTable(session, table, sampled, len(ranges))
+		log.Printf("Scanned %s in %s\n", table, time.Since(startTime).Round(time.Second))
+
+		for i, p := range rep.Top {
+			if i >= *measure {
+				break
+			}
+			if err := measurePartition(session, table, p); err != nil {
+				log.Printf("ERROR: failed to read partition %s of %s: %s\n", p.Key, table, err)
+			}
+		}
+
+		reports = append(reports, rep)
+	}
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, rep := range reports {
+		printReport(rep)
+	}
+}
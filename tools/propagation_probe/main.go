@@ -0,0 +1,274 @@
+//
+// Measures how long it takes for a validated ledger to become queryable on Clio.
+//
+// The probe subscribes to the ledger stream of a rippled node. For every validated ledger it polls each
+// Clio server with a `ledger` request until the ledger is returned with the same hash, and records the
+// time since the ledger was announced by rippled. Results are exported as Prometheus metrics and can be
+// written as NDJSON:
+//
+//	{"ledger_index": 85000000, "server": "http://clio:51233", "latency_ms": 812.4, "outcome": "ok"}
+//
+// The outcome is one of ok, timeout or hash_mismatch. When a server lags behind the threshold for a
+// number of consecutive ledgers an alert is logged and optionally posted to a webhook; a resolution is
+// posted once it catches up again.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	rippled      = kingpin.Flag("rippled", "Websocket URL of the rippled node whose ledger stream is watched").Required().String()
+	clioURLs     = kingpin.Flag("clio", "JSON-RPC URL of a Clio server to probe; can be repeated").Required().Strings()
+	pollInterval = kingpin.Flag("poll-interval", "Delay between two attempts at fetching a ledger from Clio").Default("100ms").Duration()
+	ledgerWait   = kingpin.Flag("timeout", "Give up on a ledger if Clio does not have it after this long").Default("60s").Duration()
+	metricsAddr  = kingpin.Flag("metrics", "Address to serve Prometheus metrics on").Default(":9110").String()
+	outFile      = kingpin.Flag("out", "Append per-ledger results as NDJSON to this file").String()
+	lagThreshold = kingpin.Flag("lag-threshold", "Propagation latency above which a ledger counts as lagging").Default("5s").Duration()
+	sustained    = kingpin.Flag("sustained", "Number of consecutive lagging ledgers before alerting").Default("5").Int()
+	webhook      = kingpin.Flag("webhook", "URL to POST alert and resolution events to as JSON").String()
+)
+
+var (
+	latencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clio_propagation_seconds",
+		Help:    "Time between rippled announcing a validated ledger and Clio serving it",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 3, 4, 5, 7.5, 10, 15, 30, 60},
+	}, []string{"server"})
+	lastLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "clio_propagation_last_seconds",
+		Help: "Propagation latency of the most recent ledger",
+	}, []string{"server"})
+	failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clio_propagation_failures_total",
+		Help: "Ledgers that never became available or came back with a different hash",
+	}, []string{"server", "outcome"})
+	lagging = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "clio_propagation_alerting",
+		Help: "1 while the server is in sustained lag",
+	}, []string{"server"})
+	lastLedger = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "clio_propagation_rippled_ledger",
+		Help: "Latest validated ledger announced by rippled",
+	})
+)
+
+type result struct {
+	LedgerIndex uint64  `json:"ledger_index"`
+	Server      string  `json:"server"`
+	LatencyMS   float64 `json:"latency_ms"`
+	Outcome     string  `json:"outcome"`
+}
+
+type alertEvent struct {
+	Server      string  `json:"server"`
+	Status      string  `json:"status"`
+	LedgerIndex uint64  `json:"ledger_index"`
+	Consecutive int     `json:"consecutive"`
+	LatencyMS   float64 `json:"latency_ms"`
+}
+
+type server struct {
+	client *rpc.Client
+
+	mu          sync.Mutex
+	consecutive int
+	alerting    bool
+}
+
+type probe struct {
+	servers []*server
+	outMu   sync.Mutex
+	out     *json.Encoder
+}
+
+func postWebhook(event alertEvent) {
+	if *webhook == "" {
+		return
+	}
+
+	body, _ := json.Marshal(event)
+	resp, err := http.Post(*webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to post alert to webhook: %s\n", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// polls one server until it serves the ledger with the expected hash
+func (p *probe) await(s *server, seq uint64, hash string, announced time.Time) result {
+	ctx, cancel := context.WithDeadline(context.Background(), announced.Add(*ledgerWait))
+	defer cancel()
+
+	r := result{LedgerIndex: seq, Server: s.client.URL, Outcome: "timeout"}
+	for {
+		res, err := s.client.Call(ctx, "ledger", map[string]interface{}{"ledger_index": seq})
+		if err == nil {
+			ledger, _ := res["ledger"].(map[string]interface{})
+			got, _ := ledger["ledger_hash"].(string)
+			r.LatencyMS = float64(time.Since(announced).Microseconds()) / 1000
+			r.Outcome = "ok"
+			if got != hash {
+				r.Outcome = "hash_mismatch"
+			}
+			return r
+		}
+
+		select {
+		case <-ctx.Done():
+			r.LatencyMS = float64(time.Since(announced).Microseconds()) / 1000
+			return r
+		case <-time.After(*pollInterval):
+		}
+	}
+}
+
+func (p *probe) record(s *server, r result) {
+	name := s.client.URL
+	if r.Outcome == "ok" {
+		latencyHistogram.WithLabelValues(name).Observe(r.LatencyMS / 1000)
+		lastLatency.WithLabelValues(name).Set(r.LatencyMS / 1000)
+	} else {
+		failures.WithLabelValues(name, r.Outcome).Inc()
+		log.Printf("Ledger %d on %s: %s after %.0fms\n", r.LedgerIndex, name, r.Outcome, r.LatencyMS)
+	}
+
+	if p.out != nil {
+		p.outMu.Lock()
+		p.out.Encode(r)
+		p.outMu.Unlock()
+	}
+
+	lag := r.Outcome != "ok" || r.LatencyMS > float64(lagThreshold.Milliseconds())
+
+	s.mu.Lock()
+	var event *alertEvent
+	if lag {
+		s.consecutive++
+		if s.consecutive >= *sustained && !s.alerting {
+			s.alerting = true
+			event = &alertEvent{Server: name, Status: "firing", LedgerIndex: r.LedgerIndex, Consecutive: s.consecutive, LatencyMS: r.LatencyMS}
+		}
+	} else {
+		if s.alerting {
+			event = &alertEvent{Server: name, Status: "resolved", LedgerIndex: r.LedgerIndex, Consecutive: s.consecutive, LatencyMS: r.LatencyMS}
+		}
+		s.consecutive = 0
+		s.alerting = false
+	}
+	alerting := s.alerting
+	s.mu.Unlock()
+
+	if alerting {
+		lagging.WithLabelValues(name).Set(1)
+	} else {
+		lagging.WithLabelValues(name).Set(0)
+	}
+
+	if event != nil {
+		log.Printf("ALERT %s: %s lagging for %d consecutive ledgers (last %.0fms at ledger %d)\n", event.Status, name, event.Consecutive, event.LatencyMS, event.LedgerIndex)
+		go postWebhook(*event)
+	}
+}
+
+func (p *probe) onValidated(seq uint64, hash string, announced time.Time) {
+	lastLedger.Set(float64(seq))
+	for _, s := range p.servers {
+		go func(s *server) {
+			p.record(s, p.await(s, seq, hash, announced))
+		}(s)
+	}
+}
+
+// follows the ledger stream until the connection fails
+func (p *probe) watch() error {
+	conn, _, err := websocket.DefaultDialer.Dial(*rippled, nil)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if err := conn.WriteJSON(map[string]interface{}{"command": "subscribe", "streams": []string{"ledger"}}); err != nil {
+		return err
+	}
+
+	for {
+		var msg struct {
+			Type        string `json:"type"`
+			LedgerIndex uint64 `json:"ledger_index"`
+			LedgerHash  string `json:"ledger_hash"`
+		}
+
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+
+		if msg.Type != "ledgerClosed" {
+			continue
+		}
+
+		p.onValidated(msg.LedgerIndex, msg.LedgerHash, time.Now())
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	prometheus.MustRegister(latencyHistogram, lastLatency, failures, lagging, lastLedger)
+
+	p := &probe{}
+	for _, url := range *clioURLs {
+		p.servers = append(p.servers, &server{client: rpc.NewClient(url, *pollInterval*10+time.Second)})
+	}
+
+	if *outFile != "" {
+		f, err := os.OpenFile(*outFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		p.out = json.NewEncoder(f)
+	}
+
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+	}()
+
+	log.Printf("Probing %d Clio servers against %s, metrics on %s\n", len(p.servers), *rippled, *metricsAddr)
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := p.watch()
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+
+		fmt.Fprintf(os.Stderr, "Ledger stream of %s lost: %s; reconnecting in %s\n", *rippled, err, backoff)
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,391 @@
+//
+// Packages a failing request into a reproducer bundle that can be attached to a GitHub issue.
+//
+// The request (a JSON-RPC or WebSocket body, read from a file or stdin) is pinned to a validated ledger that
+// both servers have, unless it already names a ledger or --no-pin is given, and sent to Clio and to
+// rippled. The raw responses, the server_info of both servers and a manifest with timings and a summary
+// of how the results differ are written to a .tar.gz together with an ISSUE.md to paste into the issue.
+//
+// server_info output is redacted before it is written: IP addresses, node keys and host IDs are
+// replaced, and the server URLs are left out of the bundle unless --keep-urls is given.
+//
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	requestFile = kingpin.Arg("request", "File holding the failing JSON-RPC request. Read from stdin if omitted").Default("-").String()
+	clioURL     = kingpin.Flag("clio", "JSON-RPC URL of the Clio server").Required().String()
+	rippledURL  = kingpin.Flag("rippled", "JSON-RPC URL of the rippled server").Required().String()
+	ledgerIdx   = kingpin.Flag("ledger", "Ledger index to pin the request to. Defaults to the latest validated ledger both servers have").Uint64()
+	noPin       = kingpin.Flag("no-pin", "Send the request as-is").Default("false").Bool()
+	outFile     = kingpin.Flag("out", "Bundle to write").Default("clio-repro.tar.gz").String()
+	keepURLs    = kingpin.Flag("keep-urls", "Record the server URLs in the bundle").Default("false").Bool()
+	timeout     = kingpin.Flag("timeout", "Timeout of every request").Default("30s").Duration()
+)
+
+// fields of server_info that identify a machine
+var redactedFields = map[string]bool{
+	"ip":               true,
+	"hostid":           true,
+	"pubkey_node":      true,
+	"pubkey_validator": true,
+	"node_public_key":  true,
+	"validator_key":    true,
+	"public_key":       true,
+}
+
+var ipPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\[?[0-9a-fA-F]{1,4}(?::[0-9a-fA-F]{0,4}){3,7}\]?`)
+
+type capture struct {
+	Status    string  `json:"status"`
+	ElapsedMs float64 `json:"elapsed_ms"`
+	Error     string  `json:"error,omitempty"`
+	Version   string  `json:"version,omitempty"`
+	raw       []byte
+	result    map[string]interface{}
+	info      []byte
+}
+
+type manifest struct {
+	Tool        string              `json:"tool"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Method      string              `json:"method"`
+	LedgerIndex uint64              `json:"ledger_index,omitempty"`
+	Pinned      bool                `json:"pinned"`
+	Servers     map[string]*capture `json:"servers"`
+	URLs        map[string]string   `json:"urls,omitempty"`
+	Differences []string            `json:"differences"`
+}
+
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if redactedFields[k] {
+				t[k] = "<redacted>"
+				continue
+			}
+			t[k] = redact(child)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redact(child)
+		}
+		return t
+	case string:
+		return ipPattern.ReplaceAllString(t, "<redacted>")
+	}
+	return v
+}
+
+// like json.MarshalIndent, but keeps "<redacted>" readable
+func marshal(v interface{}) []byte {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+	return out.Bytes()
+}
+
+func indent(raw []byte) []byte {
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		return raw
+	}
+	return append(out.Bytes(), '\n')
+}
+
+func serverInfo(ctx context.Context, client *rpc.Client, c *capture) {
+	body, _ := json.Marshal(map[string]interface{}{"method": "server_info", "params": []interface{}{map[string]interface{}{}}})
+	raw, _, err := client.Raw(ctx, body)
+	if err != nil {
+		log.Printf("WARNING: server_info failed: %s\n", err)
+		return
+	}
+
+	result, err := rpc.ParseResponse(raw)
+	if err != nil {
+		log.Printf("WARNING: server_info failed: %s\n", err)
+		return
+	}
+
+	info, _ := result["info"].(map[string]interface{})
+	if v, ok := info["clio_version"].(string); ok {
+		c.Version = "clio " + v
+	} else if v, ok := info["build_version"].(string); ok {
+		c.Version = "rippled " + v
+	}
+
+	c.info = marshal(redact(result))
+}
+
+func send(ctx context.Context, client *rpc.Client, body []byte) *capture {
+	c := &capture{}
+	raw, elapsed, err := client.Raw(ctx, body)
+	c.ElapsedMs = float64(elapsed.Microseconds()) / 1000
+	c.raw = raw
+
+	if err != nil && raw == nil {
+		c.Status = "transport error"
+		c.Error = err.Error()
+		return c
+	}
+
+	result, err := rpc.ParseResponse(raw)
+	c.result = result
+	switch e := err.(type) {
+	case nil:
+		c.Status = "success"
+	case *rpc.Error:
+		c.Status = "error"
+		c.Error = e.Error()
+	default:
+		c.Status = "malformed"
+		c.Error = err.Error()
+	}
+
+	return c
+}
+
+// fields that legitimately differ between servers and are left out of the comparison
+var volatileFields = map[string]bool{"warnings": true, "warning": true, "status": true, "validated": true, "request": true}
+
+func differences(clio, rippled map[string]interface{}) []string {
+	var out []string
+	keys := make(map[string]bool)
+	for k := range clio {
+		keys[k] = true
+	}
+	for k := range rippled {
+		keys[k] = true
+	}
+
+	var sorted []string
+	for k := range keys {
+		if !volatileFields[k] {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		c, inClio := clio[k]
+		r, inRippled := rippled[k]
+		switch {
+		case !inClio:
+			out = append(out, fmt.Sprintf("%s: only in rippled's result", k))
+		case !inRippled:
+			out = append(out, fmt.Sprintf("%s: only in Clio's result", k))
+		case !reflect.DeepEqual(c, r):
+			out = append(out, fmt.Sprintf("%s: values differ", k))
+		}
+	}
+
+	return out
+}
+
+func issueText(m *manifest, request []byte) string {
+	var b strings.Builder
+	clio, rippled := m.Servers["clio"], m.Servers["rippled"]
+
+	fmt.Fprintf(&b, "### Summary\n\n`%s` returns a different result on Clio than on rippled", m.Method)
+	if m.Pinned {
+		fmt.Fprintf(&b, " at ledger %d", m.LedgerIndex)
+	}
+	b.WriteString(".\n\n### Request\n\n```json\n")
+	b.Write(indent(request))
+	b.WriteString("```\n\n### Servers\n\n| | version | status | time |\n|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| Clio | %s | %s %s | %.1f ms |\n", clio.Version, clio.Status, clio.Error, clio.ElapsedMs)
+	fmt.Fprintf(&b, "| rippled | %s | %s %s | %.1f ms |\n", rippled.Version, rippled.Status, rippled.Error, rippled.ElapsedMs)
+
+	b.WriteString("\n### Differences\n\n")
+	if len(m.Differences) == 0 {
+		b.WriteString("None in the result fields; see the raw responses for details.\n")
+	}
+	for _, d := range m.Differences {
+		fmt.Fprintf(&b, "- %s\n", d)
+	}
+
+	b.WriteString("\nThe attached bundle holds the raw responses (`clio/response.json`, `rippled/response.json`), ")
+	b.WriteString("the redacted `server_info` of both servers and `manifest.json`.\n")
+	return b.String()
+}
+
+func writeBundle(path string, files map[string][]byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for _, name := range names {
+		header := &tar.Header{Name: "clio-repro/" + name, Mode: 0644, Size: int64(len(files[name])), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	var raw []byte
+	var err error
+	if *requestFile == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(*requestFile)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var request map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		log.Fatalf("Request is not a JSON object: %s", err)
+	}
+
+	// WebSocket style requests are turned into JSON-RPC ones
+	if command, ok := request["command"].(string); ok {
+		params := make(map[string]interface{})
+		for k, v := range request {
+			if k != "command" && k != "id" {
+				params[k] = v
+			}
+		}
+		request = map[string]interface{}{"method": command, "params": []interface{}{params}}
+	}
+
+	method, _ := request["method"].(string)
+	if method == "" {
+		log.Fatal("Request has no method")
+	}
+
+	var params map[string]interface{}
+	if list, ok := request["params"].([]interface{}); ok && len(list) > 0 {
+		params, _ = list[0].(map[string]interface{})
+	}
+	if params == nil {
+		params = make(map[string]interface{})
+		request["params"] = []interface{}{params}
+	}
+
+	ctx := context.Background()
+	clio := rpc.NewClient(*clioURL, *timeout)
+	rippled := rpc.NewClient(*rippledURL, *timeout)
+
+	m := &manifest{Tool: "repro_bundle", CreatedAt: time.Now().UTC(), Method: method, Servers: make(map[string]*capture)}
+
+	_, hasIndex := params["ledger_index"]
+	_, hasHash := params["ledger_hash"]
+	if !*noPin && !hasIndex && !hasHash {
+		seq := *ledgerIdx
+		if seq == 0 {
+			clioSeq, err := clio.ValidatedLedgerIndex(ctx)
+			if err != nil {
+				log.Fatalf("Failed to get Clio's validated ledger: %s", err)
+			}
+			rippledSeq, err := rippled.ValidatedLedgerIndex(ctx)
+			if err != nil {
+				log.Fatalf("Failed to get rippled's validated ledger: %s", err)
+			}
+
+			seq = clioSeq
+			if rippledSeq < seq {
+				seq = rippledSeq
+			}
+		}
+
+		params["ledger_index"] = seq
+		m.LedgerIndex = seq
+		m.Pinned = true
+		log.Printf("Pinned the request to ledger %d\n", seq)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, name := range []string{"clio", "rippled"} {
+		client := clio
+		if name == "rippled" {
+			client = rippled
+		}
+
+		c := send(ctx, client, body)
+		serverInfo(ctx, client, c)
+		m.Servers[name] = c
+		log.Printf("%s: %s in %.1fms %s\n", name, c.Status, c.ElapsedMs, c.Error)
+	}
+
+	m.Differences = differences(m.Servers["clio"].result, m.Servers["rippled"].result)
+	if *keepURLs {
+		m.URLs = map[string]string{"clio": *clioURL, "rippled": *rippledURL}
+	}
+
+	files := map[string][]byte{
+		"request.json":  indent(body),
+		"manifest.json": marshal(m),
+		"ISSUE.md":      []byte(issueText(m, body)),
+	}
+	for name, c := range m.Servers {
+		if c.raw != nil {
+			files[name+"/response.json"] = indent(c.raw)
+		}
+		if c.info != nil {
+			files[name+"/server_info.json"] = c.info
+		}
+	}
+
+	if err := writeBundle(*outFile, files); err != nil {
+		log.Fatalf("Failed to write the bundle: %s", err)
+	}
+
+	log.Printf("%d differences, bundle written to %s\n", len(m.Differences), *outFile)
+}
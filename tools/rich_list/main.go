@@ -0,0 +1,331 @@
+//
+// Ranks accounts by XRP balance, and optionally by holdings of issued currencies, at a ledger.
+//
+// The objects table is scanned in parallel token ranges for the latest version of every object as of
+// the ledger (the same query Clio's cache loader uses), so no successor walk is needed. Only the top
+// --top entries of every list are kept in memory. With --stream every qualifying holding is printed as
+// soon as it is found instead, unranked, which suits piping into other tools.
+//
+
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+const (
+	defaultNumberOfNodesInCluster = 3
+	defaultNumberOfCoresInNode    = 8
+	defaultSmudgeFactor           = 3
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	ledgerIdx    = kingpin.Flag("ledger", "Ledger index to rank at. Defaults to the latest ledger in ledger_range").Short('i').Uint64()
+	top          = kingpin.Flag("top", "Number of accounts to keep in every ranked list").Default("1000").Int()
+	minXRP       = kingpin.Flag("min-xrp", "Ignore accounts holding less XRP than this").Default("0").Float64()
+	currencies   = kingpin.Flag("currency", "Also rank holders of this issued currency, as CUR/issuer; can be repeated").Strings()
+	minIOU       = kingpin.Flag("min-iou", "Ignore trust lines holding less than this").Default("0").Float64()
+	format       = kingpin.Flag("format", "Output format").Default("csv").Enum("csv", "ndjson")
+	stream       = kingpin.Flag("stream", "Print qualifying holdings as they are found instead of a ranked list").Default("false").Bool()
+
+	nodesInCluster  = kingpin.Flag("nodes-in-cluster", "Number of nodes in your Scylla cluster").Short('n').Default(fmt.Sprintf("%d", defaultNumberOfNodesInCluster)).Int()
+	coresInNode     = kingpin.Flag("cores-in-node", "Number of cores in each node").Short('c').Default(fmt.Sprintf("%d", defaultNumberOfCoresInNode)).Int()
+	smudgeFactor    = kingpin.Flag("smudge-factor", "Yet another factor to make parallelism cooler").Short('s').Default(fmt.Sprintf("%d", defaultSmudgeFactor)).Int()
+	clusterFlags    = cassandra.RegisterFlags(kingpin.CommandLine)
+	clusterPageSize = kingpin.Flag("cluster-page-size", "Page size of results").Short('p').Default("5000").Int()
+	keyspace        = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+
+	workerCount = 1
+)
+
+type holding struct {
+	Account  string `json:"account"`
+	Currency string `json:"currency"`
+	Issuer   string `json:"issuer,omitempty"`
+	Balance  string `json:"balance"`
+	Rank     int    `json:"rank,omitempty"`
+	value    *big.Float
+}
+
+// a min-heap holding the largest `limit` holdings seen so far
+type ranking struct {
+	mu       sync.Mutex
+	limit    int
+	holdings []*holding
+}
+
+func (r *ranking) Len() int           { return len(r.holdings) }
+func (r *ranking) Less(i, j int) bool { return r.holdings[i].value.Cmp(r.holdings[j].value) < 0 }
+func (r *ranking) Swap(i, j int)      { r.holdings[i], r.holdings[j] = r.holdings[j], r.holdings[i] }
+func (r *ranking) Push(x interface{}) { r.holdings = append(r.holdings, x.(*holding)) }
+func (r *ranking) Pop() interface{} {
+	last := r.holdings[len(r.holdings)-1]
+	r.holdings = r.holdings[:len(r.holdings)-1]
+	return last
+}
+
+func (r *ranking) offer(h *holding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.holdings) < r.limit {
+		heap.Push(r, h)
+		return
+	}
+
+	if h.value.Cmp(r.holdings[0].value) > 0 {
+		r.holdings[0] = h
+		heap.Fix(r, 0)
+	}
+}
+
+func (r *ranking) sorted() []*holding {
+	out := append([]*holding{}, r.holdings...)
+	sort.Slice(out, func(i, j int) bool { return out[i].value.Cmp(out[j].value) > 0 })
+	for i, h := range out {
+		h.Rank = i + 1
+	}
+	return out
+}
+
+type issue struct {
+	currency string
+	issuer   string
+}
+
+type output struct {
+	mu      sync.Mutex
+	csv     *csv.Writer
+	encoder *json.Encoder
+}
+
+func newOutput() *output {
+	o := &output{csv: csv.NewWriter(os.Stdout), encoder: json.NewEncoder(os.Stdout)}
+	if *format == "csv" {
+		o.csv.Write([]string{"rank", "account", "currency", "issuer", "balance"})
+	}
+	return o
+}
+
+func (o *output) write(h *holding) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if *format == "ndjson" {
+		o.encoder.Encode(h)
+		return
+	}
+
+	rank := ""
+	if h.Rank > 0 {
+		rank = fmt.Sprintf("%d", h.Rank)
+	}
+	o.csv.Write([]string{rank, h.Account, h.Currency, h.Issuer, h.Balance})
+}
+
+func (o *output) flush() {
+	o.csv.Flush()
+}
+
+type scanner struct {
+	xrp       *ranking
+	ious      map[issue]*ranking
+	out       *output
+	minDrops  *big.Float
+	minIOU    *big.Float
+	qualified uint64
+}
+
+func (s *scanner) found(r *ranking, h *holding) {
+	atomic.AddUint64(&s.qualified, 1)
+	if *stream {
+		s.out.write(h)
+		return
+	}
+	r.offer(h)
+}
+
+func (s *scanner) process(blob []byte) {
+	entryType, err := xrpl.DecodeLedgerEntryType(blob)
+	if err != nil || (entryType != "AccountRoot" && (entryType != "RippleState" || len(s.ious) == 0)) {
+		return
+	}
+
+	object, err := xrpl.Decode(blob)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED TO DECODE: %s\n", err)
+		return
+	}
+
+	if entryType == "AccountRoot" {
+		drops, ok := xrpl.XRPDrops(object["Balance"])
+		if !ok {
+			return
+		}
+
+		value := new(big.Float).SetInt64(drops)
+		if value.Cmp(s.minDrops) < 0 {
+			return
+		}
+
+		account, _ := object["Account"].(string)
+		s.found(s.xrp, &holding{Account: account, Currency: "XRP", Balance: fmt.Sprintf("%d.%06d", drops/1000000, drops%1000000), value: value})
+		return
+	}
+
+	balance, currency, _, ok := xrpl.IOUValue(object["Balance"])
+	if !ok {
+		return
+	}
+
+	low, _ := object["LowLimit"].(map[string]interface{})
+	high, _ := object["HighLimit"].(map[string]interface{})
+	lowAccount, _ := low["issuer"].(string)
+	highAccount, _ := high["issuer"].(string)
+
+	// a positive balance means the low account holds the currency issued by the high account
+	var holder, issuer string
+	if balance.Sign() > 0 {
+		holder, issuer = lowAccount, highAccount
+	} else {
+		holder, issuer = highAccount, lowAccount
+		balance.Neg(balance)
+	}
+
+	r, ok := s.ious[issue{currency: currency, issuer: issuer}]
+	if !ok || balance.Sign() == 0 || balance.Cmp(s.minIOU) < 0 {
+		return
+	}
+
+	s.found(r, &holding{Account: holder, Currency: currency, Issuer: issuer, Balance: balance.Text('g', 16), value: balance})
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	s := &scanner{
+		xrp:      &ranking{limit: *top},
+		ious:     make(map[issue]*ranking),
+		out:      newOutput(),
+		minDrops: big.NewFloat(*minXRP * 1000000),
+		minIOU:   big.NewFloat(*minIOU),
+	}
+
+	var issues []issue
+	for _, c := range *currencies {
+		cur, iss, ok := strings.Cut(c, "/")
+		if !ok {
+			log.Fatalf("Currency %s must be given as CUR/issuer", c)
+		}
+		if _, err := xrpl.DecodeAccountID(iss); err != nil {
+			log.Fatalf("Invalid issuer %s: %s", iss, err)
+		}
+
+		i := issue{currency: cur, issuer: iss}
+		issues = append(issues, i)
+		s.ious[i] = &ranking{limit: *top}
+	}
+
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges := cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+	cluster.PageSize = *clusterPageSize
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	seq := *ledgerIdx
+	if seq == 0 {
+		if seq, err = cassandra.LatestLedger(session); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("Ranking accounts at ledger %d using %d workers\n", seq, workerCount)
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for i := range ranges {
+		rangesChannel <- ranges[i]
+	}
+	close(rangesChannel)
+
+	var wg sync.WaitGroup
+	var totalObjects, totalErrors, rangesDone uint64
+	startTime := time.Now()
+
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			for r := range rangesChannel {
+				iter := session.Query("SELECT object FROM objects WHERE token(key) >= ? AND token(key) <= ? AND sequence <= ? PER PARTITION LIMIT 1 ALLOW FILTERING", r.StartRange, r.EndRange, seq).Iter()
+
+				var object []byte
+				for iter.Scan(&object) {
+					atomic.AddUint64(&totalObjects, 1)
+					if len(object) > 0 {
+						s.process(object)
+					}
+				}
+
+				if err := iter.Close(); err != nil {
+					log.Printf("ERROR: page iteration failed: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: [from=%d][to=%d][seq=%d]\n", r.StartRange, r.EndRange, seq)
+					atomic.AddUint64(&totalErrors, 1)
+				}
+
+				if done := atomic.AddUint64(&rangesDone, 1); done%uint64(workerCount*10) == 0 {
+					log.Printf("... %d/%d token ranges scanned, %d objects ...\n", done, len(ranges), atomic.LoadUint64(&totalObjects))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if !*stream {
+		for _, h := range s.xrp.sorted() {
+			s.out.write(h)
+		}
+		for _, i := range issues {
+			for _, h := range s.ious[i].sorted() {
+				s.out.write(h)
+			}
+		}
+	}
+	s.out.flush()
+
+	log.Printf("TOTAL OBJECTS SCANNED: %d\n", totalObjects)
+	log.Printf("TOTAL QUALIFYING HOLDINGS: %d\n", s.qualified)
+	log.Printf("TOTAL ERRORS: %d\n", totalErrors)
+	log.Printf("TOTAL EXECUTION TIME: %s\n", time.Since(startTime))
+
+	if totalErrors > 0 {
+		os.Exit(1)
+	}
+}
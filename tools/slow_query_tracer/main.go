@@ -0,0 +1,406 @@
+//
+// Collects Scylla probabilistic traces of Clio's queries for a bounded window and reports the slowest
+// query patterns.
+//
+// Tracing is switched on for every node through the Scylla REST API (--api-port) with the given
+// --probability, left on for --window and then restored to its previous value. With --collect-only the
+// tool does not touch the probability, which is the way to go on Cassandra: enable tracing with
+// `nodetool settraceprobability` yourself and point the tool at the window afterwards with --since.
+//
+// Sessions from system_traces are grouped by query shape (the statement with whitespace normalized).
+// Every shape is mapped back to the Clio RPC methods that issue it, and the slowest sessions are listed
+// with their bound partition keys and, with --events, their trace events.
+//
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	probability  = kingpin.Flag("probability", "Trace probability to set while collecting").Default("0.001").Float64()
+	window       = kingpin.Flag("window", "How long to keep tracing enabled").Default("60s").Duration()
+	settle       = kingpin.Flag("settle", "How long to wait for trace writes after tracing is disabled").Default("5s").Duration()
+	apiPort      = kingpin.Flag("api-port", "Port of the Scylla REST API").Default("10000").Int()
+	collectOnly  = kingpin.Flag("collect-only", "Do not change the trace probability, only collect existing traces").Default("false").Bool()
+	since        = kingpin.Flag("since", "With --collect-only, collect sessions started within this duration").Default("10m").Duration()
+	minDuration  = kingpin.Flag("min-duration", "Ignore sessions faster than this").Default("0s").Duration()
+	slowest      = kingpin.Flag("slowest", "Number of slowest sessions to list per shape").Default("5").Int()
+	allKeyspaces = kingpin.Flag("all-keyspaces", "Also report queries that do not touch the Clio keyspace").Default("false").Bool()
+	events       = kingpin.Flag("events", "Print the trace events of the listed sessions").Default("false").Bool()
+	jsonOutput   = kingpin.Flag("json", "Print the report as JSON").Default("false").Bool()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+// which Clio RPC methods end up issuing queries against each table
+var tableMethods = map[string][]string{
+	"objects":               {"account_info", "ledger_entry", "account_objects", "book_offers", "ledger_data", "cache loading"},
+	"successor":             {"book_offers", "ledger_data", "account_objects", "account_lines", "cache loading"},
+	"transactions":          {"tx", "account_tx", "nft_history", "ledger"},
+	"ledger_transactions":   {"ledger", "ledger_data", "subscriptions"},
+	"account_tx":            {"account_tx"},
+	"nf_tokens":             {"nft_info", "nfts_by_issuer"},
+	"nf_token_uris":         {"nft_info", "nfts_by_issuer"},
+	"nf_token_transactions": {"nft_history"},
+	"issuer_nf_tokens_v2":   {"nfts_by_issuer"},
+	"ledgers":               {"ledger", "ledger_range lookups"},
+	"ledger_hashes":         {"ledger (by hash)"},
+	"ledger_range":          {"server_info", "every request"},
+	"diff":                  {"ledger (diff)", "cache loading"},
+}
+
+var tablePattern = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+([\w."]+)`)
+
+type trace struct {
+	SessionID   string    `json:"session_id"`
+	Coordinator string    `json:"coordinator"`
+	Client      string    `json:"client"`
+	StartedAt   time.Time `json:"started_at"`
+	DurationUs  int       `json:"duration_us"`
+	Consistency string    `json:"consistency,omitempty"`
+	Keys        []string  `json:"bound_values,omitempty"`
+	Events      []string  `json:"events,omitempty"`
+	id          gocql.UUID
+}
+
+type shape struct {
+	Query    string   `json:"query"`
+	Table    string   `json:"table"`
+	Methods  []string `json:"clio_methods,omitempty"`
+	Count    int      `json:"count"`
+	TotalMs  float64  `json:"total_ms"`
+	MeanMs   float64  `json:"mean_ms"`
+	P50Ms    float64  `json:"p50_ms"`
+	P99Ms    float64  `json:"p99_ms"`
+	MaxMs    float64  `json:"max_ms"`
+	Slowest  []*trace `json:"slowest"`
+	duration []int
+}
+
+func traceProbabilityURL(host string) string {
+	return fmt.Sprintf("http://%s:%d/storage_service/trace_probability", host, *apiPort)
+}
+
+func getTraceProbability(host string) (float64, error) {
+	resp, err := http.Get(traceProbabilityURL(host))
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return strconv.ParseFloat(strings.Trim(strings.TrimSpace(string(body)), `"`), 64)
+}
+
+func setTraceProbability(host string, p float64) error {
+	resp, err := http.Post(fmt.Sprintf("%s?probability=%g", traceProbabilityURL(host), p), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// table name without keyspace and quotes
+func tableOf(query string) string {
+	m := tablePattern.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+
+	name := strings.ReplaceAll(m[1], `"`, "")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// methods for a table, tolerating Clio's optional table prefix
+func methodsOf(table string) []string {
+	if methods, ok := tableMethods[table]; ok {
+		return methods
+	}
+
+	for name, methods := range tableMethods {
+		if strings.HasSuffix(table, name) {
+			return methods
+		}
+	}
+
+	return nil
+}
+
+// makes bound values readable; 20 byte blobs are most likely account IDs
+func formatValue(v string) string {
+	raw, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+	if err != nil || !strings.HasPrefix(v, "0x") {
+		return v
+	}
+
+	if len(raw) == 20 {
+		return fmt.Sprintf("%s (%s)", xrpl.EncodeAccountID(raw), strings.ToUpper(v[2:]))
+	}
+	return strings.ToUpper(v[2:])
+}
+
+// bound values are recorded as param[N] by Scylla and as bound_var_N_name by Cassandra
+func boundValues(parameters map[string]string) []string {
+	type bound struct {
+		index int
+		value string
+	}
+
+	var values []bound
+	for k, v := range parameters {
+		var index int
+		switch {
+		case strings.HasPrefix(k, "param["):
+			fmt.Sscanf(k, "param[%d]", &index)
+		case strings.HasPrefix(k, "bound_var_"):
+			fmt.Sscanf(k, "bound_var_%d_", &index)
+		default:
+			continue
+		}
+		values = append(values, bound{index, formatValue(v)})
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i].index < values[j].index })
+
+	out := make([]string, len(values))
+	for i, b := range values {
+		out[i] = b.value
+	}
+	return out
+}
+
+func percentile(sorted []int, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return float64(sorted[idx]) / 1000
+}
+
+func loadEvents(session *gocql.Session, t *trace) error {
+	iter := session.Query("SELECT activity, source, source_elapsed, thread FROM system_traces.events WHERE session_id = ?", t.id).Iter()
+
+	var activity, thread string
+	var source string
+	var elapsed int
+	for iter.Scan(&activity, &source, &elapsed, &thread) {
+		t.Events = append(t.Events, fmt.Sprintf("%8dus %-15s %-12s %s", elapsed, source, thread, activity))
+	}
+
+	return iter.Close()
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	hosts := strings.Split(*clusterHosts, ",")
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, "")
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	from := time.Now().Add(-*since)
+	to := time.Now()
+
+	if !*collectOnly {
+		previous := make(map[string]float64)
+		for _, host := range hosts {
+			p, err := getTraceProbability(host)
+			if err != nil {
+				log.Fatalf("Failed to read the trace probability of %s: %s", host, err)
+			}
+			previous[host] = p
+		}
+
+		// whatever happens from here on, the original probability must be restored
+		restore := func() {
+			for host, p := range previous {
+				if err := setTraceProbability(host, p); err != nil {
+					log.Printf("ERROR: failed to restore the trace probability of %s to %g: %s\n", host, p, err)
+				}
+			}
+		}
+
+		from = time.Now()
+		for _, host := range hosts {
+			if err := setTraceProbability(host, *probability); err != nil {
+				restore()
+				log.Fatalf("Failed to set the trace probability of %s: %s", host, err)
+			}
+		}
+
+		log.Printf("Tracing %g of all queries on %d nodes for %s\n", *probability, len(hosts), *window)
+		time.Sleep(*window)
+		restore()
+		to = time.Now()
+
+		log.Printf("Tracing disabled, waiting %s for trace writes to settle\n", *settle)
+		time.Sleep(*settle)
+	}
+
+	shapes := make(map[string]*shape)
+	var total, skipped int
+
+	iter := session.Query("SELECT session_id, client, coordinator, duration, parameters, started_at FROM system_traces.sessions").Iter()
+
+	var id gocql.UUID
+	var client, coordinator string
+	var duration int
+	var parameters map[string]string
+	var startedAt time.Time
+	for iter.Scan(&id, &client, &coordinator, &duration, &parameters, &startedAt) {
+		if startedAt.Before(from) || startedAt.After(to) || time.Duration(duration)*time.Microsecond < *minDuration {
+			continue
+		}
+
+		query := strings.Join(strings.Fields(parameters["query"]), " ")
+		if query == "" {
+			skipped++
+			continue
+		}
+
+		if !*allKeyspaces && !strings.Contains(query, *keyspace+".") {
+			skipped++
+			continue
+		}
+
+		total++
+		s, ok := shapes[query]
+		if !ok {
+			table := tableOf(query)
+			s = &shape{Query: query, Table: table, Methods: methodsOf(table)}
+			shapes[query] = s
+		}
+
+		s.duration = append(s.duration, duration)
+		s.Slowest = append(s.Slowest, &trace{
+			SessionID:   id.String(),
+			Coordinator: coordinator,
+			Client:      client,
+			StartedAt:   startedAt,
+			DurationUs:  duration,
+			Consistency: parameters["consistency_level"],
+			Keys:        boundValues(parameters),
+			id:          id,
+		})
+
+		// only the slowest sessions are kept, trimming in batches keeps this cheap
+		if len(s.Slowest) > 4**slowest+16 {
+			sort.Slice(s.Slowest, func(i, j int) bool { return s.Slowest[i].DurationUs > s.Slowest[j].DurationUs })
+			s.Slowest = s.Slowest[:*slowest]
+		}
+
+		parameters = nil
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Fatalf("Failed to read system_traces.sessions: %s", err)
+	}
+
+	var report []*shape
+	for _, s := range shapes {
+		sort.Ints(s.duration)
+		s.Count = len(s.duration)
+		for _, d := range s.duration {
+			s.TotalMs += float64(d) / 1000
+		}
+		s.MeanMs = s.TotalMs / float64(s.Count)
+		s.P50Ms = percentile(s.duration, 50)
+		s.P99Ms = percentile(s.duration, 99)
+		s.MaxMs = float64(s.duration[len(s.duration)-1]) / 1000
+
+		sort.Slice(s.Slowest, func(i, j int) bool { return s.Slowest[i].DurationUs > s.Slowest[j].DurationUs })
+		if len(s.Slowest) > *slowest {
+			s.Slowest = s.Slowest[:*slowest]
+		}
+
+		if *events {
+			for _, t := range s.Slowest {
+				if err := loadEvents(session, t); err != nil {
+					log.Printf("ERROR: failed to read the events of session %s: %s\n", t.SessionID, err)
+				}
+			}
+		}
+
+		report = append(report, s)
+	}
+
+	// the shapes costing the cluster the most time first
+	sort.Slice(report, func(i, j int) bool { return report[i].TotalMs > report[j].TotalMs })
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("%d traced queries in %d shapes between %s and %s (%d other sessions skipped)\n",
+		total, len(report), from.Format(time.RFC3339), to.Format(time.RFC3339), skipped)
+
+	for _, s := range report {
+		fmt.Printf("\n%s\n", s.Query)
+		fmt.Printf("  table %s, issued by: %s\n", s.Table, strings.Join(s.Methods, ", "))
+		fmt.Printf("  count %d, total %.1fms, mean %.2fms, p50 %.2fms, p99 %.2fms, max %.2fms\n", s.Count, s.TotalMs, s.MeanMs, s.P50Ms, s.P99Ms, s.MaxMs)
+
+		for _, t := range s.Slowest {
+			fmt.Printf("    %8.2fms %s coordinator %s [%s]\n", float64(t.DurationUs)/1000, t.SessionID, t.Coordinator, strings.Join(t.Keys, ", "))
+			for _, e := range t.Events {
+				fmt.Printf("        %s\n", e)
+			}
+		}
+	}
+}
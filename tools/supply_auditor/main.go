@@ -0,0 +1,310 @@
+//
+// Audits the XRP supply of a ledger stored in a Clio keyspace.
+//
+// The state map is walked through the successor chain like Clio's ledger_data does, and the XRP held
+// by every object is summed: AccountRoot balances, escrowed amounts and unclaimed payment channel
+// funds. The total must equal the coins recorded in the ledger header; any difference indicates
+// missing or corrupted objects. Locked reserves are computed from the FeeSettings of the same ledger.
+//
+// The JSON report has the same shape as book_checker's:
+//
+//	{"tool": "supply_auditor", "ledger_index": 85000000, "passed": true, "findings": [...], ...}
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gocql/gocql"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+const (
+	dropsPerXRP = 1000000
+)
+
+var (
+	lastKey = []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+	ledgerIdx    = kingpin.Flag("ledger", "Ledger index to audit. Defaults to the latest ledger in ledger_range").Short('i').Uint64()
+	workers      = kingpin.Flag("workers", "Number of objects fetched in parallel").Short('w').Default("32").Int()
+	reportTo     = kingpin.Flag("report", "Write a JSON report to this file").String()
+	maxFindings  = kingpin.Flag("max-findings", "Stop recording individual findings after this many").Default("1000").Int()
+
+	clusterFlags = cassandra.RegisterFlags(kingpin.CommandLine)
+	keyspace     = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+)
+
+type finding struct {
+	Kind    string `json:"kind"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+type report struct {
+	Tool             string    `json:"tool"`
+	LedgerIndex      uint64    `json:"ledger_index"`
+	Passed           bool      `json:"passed"`
+	Objects          uint64    `json:"objects"`
+	Accounts         uint64    `json:"accounts"`
+	HeaderDrops      uint64    `json:"header_drops"`
+	CountedDrops     uint64    `json:"counted_drops"`
+	BalanceDrops     uint64    `json:"account_balance_drops"`
+	EscrowDrops      uint64    `json:"escrow_drops"`
+	ChannelDrops     uint64    `json:"payment_channel_drops"`
+	ReserveDrops     uint64    `json:"locked_reserve_drops"`
+	BelowReserve     uint64    `json:"accounts_below_reserve"`
+	ReserveBase      uint64    `json:"reserve_base_drops"`
+	ReserveIncrement uint64    `json:"reserve_increment_drops"`
+	Problems         uint64    `json:"problems"`
+	Findings         []finding `json:"findings"`
+}
+
+type auditor struct {
+	rep         report
+	mu          sync.Mutex
+	reserveBase uint64
+	reserveInc  uint64
+}
+
+func (a *auditor) addFinding(f finding) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rep.Problems++
+	if len(a.rep.Findings) < *maxFindings {
+		a.rep.Findings = append(a.rep.Findings, f)
+	}
+	log.Printf("  [%s] %s %s\n", f.Kind, f.Object, f.Message)
+}
+
+// reads the reserves from FeeSettings, in either the legacy or the XRPFees format
+func (a *auditor) loadReserves(session *gocql.Session, seq uint64) error {
+	blob, _, err := cassandra.FetchObject(session, xrpl.FeeSettingsKey(), seq)
+	if err != nil {
+		return fmt.Errorf("FeeSettings: %w", err)
+	}
+
+	fees, err := xrpl.Decode(blob)
+	if err != nil {
+		return fmt.Errorf("FeeSettings: %w", err)
+	}
+
+	if base, ok := xrpl.XRPDrops(fees["ReserveBaseDrops"]); ok {
+		inc, _ := xrpl.XRPDrops(fees["ReserveIncrementDrops"])
+		a.reserveBase, a.reserveInc = uint64(base), uint64(inc)
+		return nil
+	}
+
+	base, _ := fees["ReserveBase"].(int64)
+	inc, _ := fees["ReserveIncrement"].(int64)
+	a.reserveBase, a.reserveInc = uint64(base), uint64(inc)
+	return nil
+}
+
+func (a *auditor) drops(key []byte, object map[string]interface{}, field string) uint64 {
+	v, ok := xrpl.XRPDrops(object[field])
+	if !ok {
+		if object[field] != nil {
+			a.addFinding(finding{Kind: "bad_amount", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("%s is not an XRP amount", field)})
+		}
+		return 0
+	}
+
+	if v < 0 {
+		a.addFinding(finding{Kind: "negative_amount", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("%s is %d drops", field, v)})
+		return 0
+	}
+	return uint64(v)
+}
+
+func (a *auditor) audit(key []byte, blob []byte) {
+	atomic.AddUint64(&a.rep.Objects, 1)
+
+	entryType, err := xrpl.DecodeLedgerEntryType(blob)
+	if err != nil {
+		a.addFinding(finding{Kind: "decode_error", Object: fmt.Sprintf("%X", key), Message: err.Error()})
+		return
+	}
+
+	switch entryType {
+	case "AccountRoot", "Escrow", "PayChannel":
+	default:
+		return
+	}
+
+	object, err := xrpl.Decode(blob)
+	if err != nil {
+		a.addFinding(finding{Kind: "decode_error", Object: fmt.Sprintf("%X", key), Message: err.Error()})
+		return
+	}
+
+	switch entryType {
+	case "AccountRoot":
+		balance := a.drops(key, object, "Balance")
+		owners, _ := object["OwnerCount"].(int64)
+		reserve := a.reserveBase + a.reserveInc*uint64(owners)
+
+		atomic.AddUint64(&a.rep.Accounts, 1)
+		atomic.AddUint64(&a.rep.BalanceDrops, balance)
+		if balance < reserve {
+			atomic.AddUint64(&a.rep.BelowReserve, 1)
+			reserve = balance
+		}
+		atomic.AddUint64(&a.rep.ReserveDrops, reserve)
+
+	case "Escrow":
+		atomic.AddUint64(&a.rep.EscrowDrops, a.drops(key, object, "Amount"))
+
+	case "PayChannel":
+		amount := a.drops(key, object, "Amount")
+		claimed := a.drops(key, object, "Balance")
+		if claimed > amount {
+			a.addFinding(finding{Kind: "bad_channel", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("claimed %d drops out of %d", claimed, amount)})
+			return
+		}
+		atomic.AddUint64(&a.rep.ChannelDrops, amount-claimed)
+	}
+}
+
+func formatXRP(drops uint64) string {
+	return fmt.Sprintf("%d.%06d XRP", drops/dropsPerXRP, drops%dropsPerXRP)
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	seq := *ledgerIdx
+	if seq == 0 {
+		if seq, err = cassandra.LatestLedger(session); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var headerBlob []byte
+	if err := session.Query("SELECT header FROM ledgers WHERE sequence = ?", seq).Scan(&headerBlob); err != nil {
+		log.Fatalf("Failed to read the header of ledger %d: %s", seq, err)
+	}
+
+	header, err := xrpl.DecodeLedgerHeader(headerBlob)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a := &auditor{rep: report{Tool: "supply_auditor", LedgerIndex: seq, HeaderDrops: header.Drops}}
+	if err := a.loadReserves(session, seq); err != nil {
+		log.Fatal(err)
+	}
+	a.rep.ReserveBase, a.rep.ReserveIncrement = a.reserveBase, a.reserveInc
+
+	log.Printf("Auditing ledger %d, header coins %s, reserves %d + %d per object\n", seq, formatXRP(header.Drops), a.reserveBase, a.reserveInc)
+
+	keys := make(chan []byte, *workers*4)
+	var wg sync.WaitGroup
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				blob, _, err := cassandra.FetchObject(session, key, seq)
+				if err != nil {
+					a.addFinding(finding{Kind: "missing_object", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("in the successor chain but not readable: %s", err)})
+					continue
+				}
+				a.audit(key, blob)
+			}
+		}()
+	}
+
+	// the successor chain is inherently sequential; fetching and decoding objects is not
+	startTime := time.Now()
+	key := make([]byte, 32)
+	var walked uint64
+	for {
+		var next []byte
+		if err := session.Query("SELECT next FROM successor WHERE key = ? AND seq <= ? ORDER BY seq DESC LIMIT 1", key, seq).Scan(&next); err != nil {
+			a.addFinding(finding{Kind: "broken_chain", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("successor lookup failed: %s", err)})
+			break
+		}
+
+		if string(next) == string(lastKey) {
+			break
+		}
+
+		if string(next) <= string(key) {
+			a.addFinding(finding{Kind: "broken_chain", Object: fmt.Sprintf("%X", key), Message: fmt.Sprintf("successor %X does not increase", next)})
+			break
+		}
+
+		keys <- next
+		key = next
+		walked++
+
+		if walked%100000 == 0 {
+			log.Printf("... %d objects walked ...\n", walked)
+		}
+	}
+
+	close(keys)
+	wg.Wait()
+
+	r := &a.rep
+	r.CountedDrops = r.BalanceDrops + r.EscrowDrops + r.ChannelDrops
+	if r.CountedDrops != r.HeaderDrops {
+		diff := int64(r.CountedDrops) - int64(r.HeaderDrops)
+		a.addFinding(finding{Kind: "supply_mismatch", Message: fmt.Sprintf("objects hold %s but the header records %s (difference %d drops)", formatXRP(r.CountedDrops), formatXRP(r.HeaderDrops), diff)})
+	}
+
+	r.Passed = r.Problems == 0
+
+	log.Printf("\nOBJECTS WALKED: %d in %s\n", r.Objects, time.Since(startTime))
+	log.Printf("ACCOUNTS: %d (%d below reserve)\n", r.Accounts, r.BelowReserve)
+	log.Printf("ACCOUNT BALANCES: %s\n", formatXRP(r.BalanceDrops))
+	log.Printf("ESCROWED: %s\n", formatXRP(r.EscrowDrops))
+	log.Printf("IN PAYMENT CHANNELS: %s\n", formatXRP(r.ChannelDrops))
+	log.Printf("LOCKED RESERVES: %s\n", formatXRP(r.ReserveDrops))
+	log.Printf("TOTAL COUNTED: %s\n", formatXRP(r.CountedDrops))
+	log.Printf("HEADER COINS: %s\n", formatXRP(r.HeaderDrops))
+	log.Printf("TOTAL PROBLEMS: %d\n", r.Problems)
+
+	if *reportTo != "" {
+		out, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile(*reportTo, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !r.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,652 @@
+//
+// Renders test artifacts into a single self-contained HTML report that can be shared without any tooling.
+//
+// Every argument is an artifact file, optionally followed by =title. The kind of artifact is detected
+// from its content:
+//
+//   - load test reports: a JSON array, an object {"label": ..., "requests": [...]} or NDJSON of records
+//     {"method": "account_tx", "latency_ms": 12.5, "status": "success", "error": "", "time": 1700000000.5}
+//     where time is optional and is either unix seconds or an RFC 3339 timestamp
+//   - verification reports of the checking tools: {"tool": ..., "passed": ..., "findings": [...]}
+//   - conformance findings: NDJSON of {"kind": ..., "object": ..., "message": ...}
+//   - comparisons written by loadtest_compare --json
+//
+// Charts are inline SVG, so the report has no external dependencies.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	artifacts   = kingpin.Arg("artifacts", "Artifact files; use file=title to name a section").Required().Strings()
+	outFile     = kingpin.Flag("out", "HTML file to write").Short('o').Default("report.html").String()
+	title       = kingpin.Flag("title", "Title of the report").Default("Clio test report").String()
+	bucket      = kingpin.Flag("bucket", "Width of the time buckets of the latency charts").Default("10s").Duration()
+	maxFindings = kingpin.Flag("max-findings", "Maximum number of findings listed per artifact").Default("200").Int()
+)
+
+type record struct {
+	Method    string          `json:"method"`
+	LatencyMS float64         `json:"latency_ms"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error"`
+	Time      json.RawMessage `json:"time"`
+}
+
+func (r *record) failed() bool {
+	return r.Error != "" || (r.Status != "" && r.Status != "success")
+}
+
+// timestamp reads the optional time of a record, either unix seconds (or milliseconds) or RFC 3339
+func (r *record) timestamp() (time.Time, bool) {
+	if len(r.Time) == 0 {
+		return time.Time{}, false
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(r.Time, &seconds); err == nil {
+		if seconds > 1e12 {
+			seconds /= 1000
+		}
+		return time.Unix(0, int64(seconds*1e9)), true
+	}
+
+	var s string
+	if err := json.Unmarshal(r.Time, &s); err == nil {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type finding struct {
+	Kind    string `json:"kind"`
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+type verification struct {
+	Tool        string    `json:"tool"`
+	LedgerIndex uint64    `json:"ledger_index"`
+	Passed      *bool     `json:"passed"`
+	Findings    []finding `json:"findings"`
+}
+
+type interval struct {
+	Delta float64 `json:"delta"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+type methodStats struct {
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Mean      float64 `json:"mean_ms"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+}
+
+type comparison struct {
+	Baseline   string   `json:"baseline"`
+	Reports    []string `json:"reports"`
+	Confidence float64  `json:"confidence"`
+	Methods    []struct {
+		Method string                 `json:"method"`
+		Stats  map[string]methodStats `json:"stats"`
+		Deltas []struct {
+			Report    string   `json:"report"`
+			Mean      interval `json:"mean_ms"`
+			Median    interval `json:"p50_ms"`
+			ErrorRate interval `json:"error_rate"`
+		} `json:"deltas"`
+	} `json:"methods"`
+}
+
+type count struct {
+	Label string
+	Value float64
+}
+
+type loadSection struct {
+	Requests  int
+	Errors    int
+	Duration  time.Duration
+	Methods   []count
+	Stats     map[string]methodStats
+	ErrorsBy  []count
+	Timeline  template.HTML
+	ErrorBars template.HTML
+}
+
+type findingsSection struct {
+	Tool        string
+	LedgerIndex uint64
+	Total       int
+	Findings    []finding
+	Chart       template.HTML
+}
+
+type comparisonSection struct {
+	*comparison
+	Chart template.HTML
+}
+
+type section struct {
+	ID         string
+	Title      string
+	Path       string
+	Status     string
+	Load       *loadSection
+	Findings   *findingsSection
+	Comparison *comparisonSection
+}
+
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Min(float64(len(sorted)-1), q*float64(len(sorted))))]
+}
+
+func statsOf(latencies []float64, total int) methodStats {
+	sort.Float64s(latencies)
+	s := methodStats{Count: total, Errors: total - len(latencies), P50: quantile(latencies, 0.5), P90: quantile(latencies, 0.9), P99: quantile(latencies, 0.99)}
+	for _, l := range latencies {
+		s.Mean += l
+	}
+	if len(latencies) > 0 {
+		s.Mean /= float64(len(latencies))
+	}
+	if total > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(total)
+	}
+	return s
+}
+
+func topCounts(m map[string]int, n int) []count {
+	var counts []count
+	for k, v := range m {
+		counts = append(counts, count{k, float64(v)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Value != counts[j].Value {
+			return counts[i].Value > counts[j].Value
+		}
+		return counts[i].Label < counts[j].Label
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+func newLoadSection(records []record) *loadSection {
+	s := &loadSection{Stats: make(map[string]methodStats)}
+	latencies := make(map[string][]float64)
+	totals := make(map[string]int)
+	errorsBy := make(map[string]int)
+
+	type timed struct {
+		t       time.Time
+		latency float64
+	}
+	var points []timed
+
+	for _, r := range records {
+		if r.Method == "" {
+			r.Method = "unknown"
+		}
+
+		s.Requests++
+		totals[r.Method]++
+		if r.failed() {
+			s.Errors++
+			reason := r.Error
+			if reason == "" {
+				reason = r.Status
+			}
+			errorsBy[r.Method+": "+reason]++
+			continue
+		}
+
+		latencies[r.Method] = append(latencies[r.Method], r.LatencyMS)
+		if t, ok := r.timestamp(); ok {
+			points = append(points, timed{t, r.LatencyMS})
+		}
+	}
+
+	for m, total := range totals {
+		s.Stats[m] = statsOf(latencies[m], total)
+	}
+	s.Methods = topCounts(totals, len(totals))
+	s.ErrorsBy = topCounts(errorsBy, 15)
+	s.ErrorBars = barChart(s.ErrorsBy, "")
+
+	if len(points) == 0 {
+		return s
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].t.Before(points[j].t) })
+	start := points[0].t
+	span := points[len(points)-1].t.Sub(start)
+	s.Duration = span.Round(time.Second)
+
+	buckets := make([][]float64, int(span / *bucket)+1)
+	for _, p := range points {
+		i := int(p.t.Sub(start) / *bucket)
+		buckets[i] = append(buckets[i], p.latency)
+	}
+
+	series := []series{{Name: "p50", Color: "#1f77b4"}, {Name: "p90", Color: "#ff7f0e"}, {Name: "p99", Color: "#d62728"}}
+	for _, b := range buckets {
+		sort.Float64s(b)
+		for i, q := range []float64{0.5, 0.9, 0.99} {
+			v := math.NaN()
+			if len(b) > 0 {
+				v = quantile(b, q)
+			}
+			series[i].Values = append(series[i].Values, v)
+		}
+	}
+	s.Timeline = lineChart(series, *bucket, "ms")
+	return s
+}
+
+func newFindingsSection(v *verification) *findingsSection {
+	s := &findingsSection{Tool: v.Tool, LedgerIndex: v.LedgerIndex, Total: len(v.Findings)}
+
+	kinds := make(map[string]int)
+	for _, f := range v.Findings {
+		kinds[f.Kind]++
+	}
+	s.Chart = barChart(topCounts(kinds, 20), "")
+
+	s.Findings = v.Findings
+	if len(s.Findings) > *maxFindings {
+		s.Findings = s.Findings[:*maxFindings]
+	}
+	return s
+}
+
+func newComparisonSection(c *comparison) *comparisonSection {
+	var deltas []count
+	for _, m := range c.Methods {
+		for _, d := range m.Deltas {
+			label := m.Method
+			if len(c.Reports) > 2 {
+				label += " (" + d.Report + ")"
+			}
+			deltas = append(deltas, count{label, d.Median.Delta})
+		}
+	}
+	return &comparisonSection{comparison: c, Chart: barChart(deltas, "ms")}
+}
+
+const (
+	chartWidth  = 720
+	chartHeight = 240
+	labelWidth  = 260
+	barHeight   = 18
+)
+
+// barChart draws horizontal bars; negative values extend to the left of the axis
+func barChart(counts []count, unit string) template.HTML {
+	if len(counts) == 0 {
+		return ""
+	}
+
+	var maxAbs float64
+	negative := false
+	for _, c := range counts {
+		maxAbs = math.Max(maxAbs, math.Abs(c.Value))
+		negative = negative || c.Value < 0
+	}
+	if maxAbs == 0 {
+		maxAbs = 1
+	}
+
+	plot := float64(chartWidth - labelWidth - 80)
+	axis := float64(labelWidth)
+	if negative {
+		plot /= 2
+		axis += plot
+	}
+
+	var b bytes.Buffer
+	height := len(counts)*(barHeight+4) + 4
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-size="12">`, chartWidth, height)
+	for i, c := range counts {
+		y := 4 + i*(barHeight+4)
+		w := math.Abs(c.Value) / maxAbs * plot
+		x := axis
+		color := "#4c78a8"
+		if c.Value < 0 {
+			x -= w
+			color = "#54a24b"
+		} else if negative {
+			color = "#e45756"
+		}
+
+		label := c.Label
+		if len(label) > 40 {
+			label = label[:39] + "…"
+		}
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, labelWidth-6, y+barHeight-5, template.HTMLEscapeString(label))
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s"><title>%s</title></rect>`, x, y, w, barHeight, color, template.HTMLEscapeString(c.Label))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s%s</text>`, math.Max(axis, x+w)+4, y+barHeight-5, formatValue(c.Value), unit)
+	}
+	fmt.Fprintf(&b, `<line x1="%.1f" y1="0" x2="%.1f" y2="%d" stroke="#888"/></svg>`, axis, axis, height)
+	return template.HTML(b.String())
+}
+
+type series struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+// lineChart draws series of equally spaced values, leaving gaps for NaN
+func lineChart(all []series, step time.Duration, unit string) template.HTML {
+	var maxValue float64
+	points := 0
+	for _, s := range all {
+		points = max(points, len(s.Values))
+		for _, v := range s.Values {
+			if !math.IsNaN(v) {
+				maxValue = math.Max(maxValue, v)
+			}
+		}
+	}
+	if points == 0 || maxValue == 0 {
+		return ""
+	}
+
+	const left, bottom, top = 60, 30, 10
+	plotW := float64(chartWidth - left - 100)
+	plotH := float64(chartHeight - bottom - top)
+	x := func(i int) float64 {
+		if points == 1 {
+			return left
+		}
+		return left + float64(i)/float64(points-1)*plotW
+	}
+	y := func(v float64) float64 { return top + plotH - v/maxValue*plotH }
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-size="12">`, chartWidth, chartHeight)
+	for i := 0; i <= 4; i++ {
+		v := maxValue * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#eee"/>`, left, y(v), left+plotW, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%s%s</text>`, left-4, y(v)+4, formatValue(v), unit)
+	}
+	for _, i := range []int{0, points / 2, points - 1} {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">+%s</text>`, x(i), chartHeight-8, time.Duration(i)*step)
+	}
+
+	for n, s := range all {
+		var path strings.Builder
+		pen := "M"
+		for i, v := range s.Values {
+			if math.IsNaN(v) {
+				pen = "M"
+				continue
+			}
+			fmt.Fprintf(&path, "%s%.1f %.1f ", pen, x(i), y(v))
+			pen = "L"
+		}
+		fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="%s" stroke-width="1.5"/>`, path.String(), s.Color)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="%s">%s</text>`, left+plotW+10, top+12+n*16, s.Color, s.Name)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// load detects the kind of an artifact and builds its section
+func load(path string) (*section, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &section{Path: path, Status: "info"}
+	trimmed := bytes.TrimSpace(data)
+
+	var object map[string]json.RawMessage
+	if bytes.HasPrefix(trimmed, []byte("{")) && json.Unmarshal(trimmed, &object) == nil {
+		switch {
+		case object["findings"] != nil:
+			var v verification
+			if err := json.Unmarshal(trimmed, &v); err != nil {
+				return nil, err
+			}
+			s.Findings = newFindingsSection(&v)
+			s.Status = "passed"
+			if (v.Passed != nil && !*v.Passed) || (v.Passed == nil && len(v.Findings) > 0) {
+				s.Status = "failed"
+			}
+			s.Title = v.Tool
+
+		case object["baseline"] != nil && object["methods"] != nil:
+			var c comparison
+			if err := json.Unmarshal(trimmed, &c); err != nil {
+				return nil, err
+			}
+			s.Comparison = newComparisonSection(&c)
+			s.Title = "Comparison against " + c.Baseline
+			s.Status = "passed"
+			for _, m := range c.Methods {
+				for _, d := range m.Deltas {
+					if d.Mean.Low > 0 || d.Median.Low > 0 || d.ErrorRate.Low > 0 {
+						s.Status = "regressed"
+					}
+				}
+			}
+
+		case object["requests"] != nil:
+			var wrapped struct {
+				Label    string   `json:"label"`
+				Requests []record `json:"requests"`
+			}
+			if err := json.Unmarshal(trimmed, &wrapped); err != nil {
+				return nil, err
+			}
+			s.Load = newLoadSection(wrapped.Requests)
+			s.Title = wrapped.Label
+
+		default:
+			return nil, fmt.Errorf("unrecognised report")
+		}
+		return s, nil
+	}
+
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var records []record
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, err
+		}
+		s.Load = newLoadSection(records)
+		return s, nil
+	}
+
+	// NDJSON of either request records or findings
+	var records []record
+	var findings []finding
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if fields["method"] != nil {
+			var r record
+			json.Unmarshal(scanner.Bytes(), &r)
+			records = append(records, r)
+		} else {
+			var f finding
+			json.Unmarshal(scanner.Bytes(), &f)
+			findings = append(findings, f)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(findings) > 0 && len(records) == 0 {
+		s.Findings = newFindingsSection(&verification{Findings: findings})
+		s.Status = "failed"
+		return s, nil
+	}
+
+	s.Load = newLoadSection(records)
+	return s, nil
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(f float64) string { return fmt.Sprintf("%.2f%%", f*100) },
+	"ms":  func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"class": func(i interval) string {
+		switch {
+		case i.Low > 0:
+			return "worse"
+		case i.High < 0:
+			return "better"
+		}
+		return ""
+	},
+	"stats": func(m map[string]methodStats, method string) methodStats { return m[method] },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+td.text { text-align: left; }
+section { margin-bottom: 3em; }
+.worse, .failed, .regressed { background: #f8d7da; }
+.better, .passed { background: #d4edda; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+<table>
+<tr><th>artifact</th><th>file</th><th>status</th></tr>
+{{range .Sections}}<tr><td><a href="#{{.ID}}">{{.Title}}</a></td><td class="text">{{.Path}}</td><td class="{{.Status}}">{{.Status}}</td></tr>
+{{end}}</table>
+{{range .Sections}}
+<section id="{{.ID}}">
+<h2>{{.Title}}</h2>
+{{with .Load}}
+<p>{{.Requests}} requests, {{.Errors}} failed{{if .Duration}}, over {{.Duration}}{{end}}.</p>
+<table>
+<tr><th>method</th><th>requests</th><th>error rate</th><th>mean ms</th><th>p50 ms</th><th>p90 ms</th><th>p99 ms</th></tr>
+{{$stats := .Stats}}{{range .Methods}}{{$method := .Label}}{{with stats $stats .Label}}<tr><td>{{$method}}</td><td>{{.Count}}</td><td>{{pct .ErrorRate}}</td><td>{{ms .Mean}}</td><td>{{ms .P50}}</td><td>{{ms .P90}}</td><td>{{ms .P99}}</td></tr>
+{{end}}{{end}}</table>
+{{if .Timeline}}<h3>Latency percentiles over time</h3>
+{{.Timeline}}{{end}}
+{{if .ErrorBars}}<h3>Errors</h3>
+{{.ErrorBars}}{{end}}
+{{end}}
+{{with .Findings}}
+<p>{{if .Tool}}{{.Tool}}{{if .LedgerIndex}} at ledger {{.LedgerIndex}}{{end}}: {{end}}{{.Total}} findings{{if gt .Total (len .Findings)}}, the first {{len .Findings}} are listed{{end}}.</p>
+{{if .Chart}}<h3>Findings by kind</h3>
+{{.Chart}}
+<table>
+<tr><th>kind</th><th>object</th><th>message</th></tr>
+{{range .Findings}}<tr><td>{{.Kind}}</td><td class="text">{{.Object}}</td><td class="text">{{.Message}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+{{with .Comparison}}
+<p>Baseline <b>{{.Baseline}}</b>. Intervals at {{pct .Confidence}} confidence; red cells are significant regressions, green cells significant improvements.</p>
+<h3>Median latency change</h3>
+{{.Chart}}
+<table>
+<tr><th>method</th><th>report</th><th>mean Δ ms</th><th>p50 Δ ms</th><th>error rate Δ</th></tr>
+{{range $m := .Methods}}{{range .Deltas}}<tr><td>{{$m.Method}}</td><td>{{.Report}}</td>
+<td class="{{class .Mean}}">{{ms .Mean.Delta}} [{{ms .Mean.Low}}, {{ms .Mean.High}}]</td>
+<td class="{{class .Median}}">{{ms .Median.Delta}} [{{ms .Median.Low}}, {{ms .Median.High}}]</td>
+<td class="{{class .ErrorRate}}">{{pct .ErrorRate.Delta}} [{{pct .ErrorRate.Low}}, {{pct .ErrorRate.High}}]</td></tr>
+{{end}}{{end}}</table>
+{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	page := struct {
+		Title     string
+		Generated string
+		Sections  []*section
+	}{Title: *title, Generated: time.Now().UTC().Format(time.RFC1123)}
+
+	for i, arg := range *artifacts {
+		path, name, _ := strings.Cut(arg, "=")
+		s, err := load(path)
+		if err != nil {
+			log.Fatalf("Failed to load %s: %s", path, err)
+		}
+
+		s.ID = fmt.Sprintf("artifact-%d", i+1)
+		if name != "" {
+			s.Title = name
+		}
+		if s.Title == "" {
+			s.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		page.Sections = append(page.Sections, s)
+		log.Printf("%s: %s (%s)\n", path, s.Title, s.Status)
+	}
+
+	f, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if err := htmlTemplate.Execute(f, page); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Report written to %s\n", *outFile)
+}
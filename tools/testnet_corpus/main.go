@@ -0,0 +1,625 @@
+//
+// Builds a reproducible test data corpus on the XRPL testnet.
+//
+// Accounts are derived from --seed, funded through the testnet faucet, and then create the trust
+// lines, payments, offers and NFTs described by a scenario file:
+//
+//	{"accounts":    [{"name": "gateways", "count": 2, "default_ripple": true}, {"name": "holders", "count": 20}],
+//	 "trust_lines": [{"holders": "holders", "issuer": "gateways", "currency": "USD", "limit": "100000", "fund": "500"}],
+//	 "payments":    [{"from": "holders", "to": "holders", "count": 3, "amount": "1000000"}],
+//	 "offers":      [{"account": "holders", "count": 2, "taker_pays": {"currency": "USD", "issuer": "gateways", "value": "5"},
+//	                  "taker_gets": "10000000"}],
+//	 "nfts":        [{"account": "holders", "count": 2, "taxon": 7, "uri": "ipfs://corpus/{n}"}]}
+//
+// Issuers in amounts and trust lines name an account group; trust lines are set up with every account
+// of the issuing group. Transactions are signed locally with ed25519 keys, so the same seed always
+// yields the same accounts and re-running a scenario only tops up balances that ran low.
+//
+// The results are written as pools with one value per line, ready to be used as ammo placeholders:
+// accounts.txt (and accounts_<group>.txt), transactions.txt, nfts.txt and ledgers.txt, along with
+// corpus.json holding every account seed and transaction.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/xrpl"
+	"xrplf/clio/xrpl/rpc"
+)
+
+var (
+	scenarioFile = kingpin.Arg("scenario", "Scenario file describing the corpus").Required().ExistingFile()
+	rippledURL   = kingpin.Flag("rippled", "JSON-RPC URL of a testnet rippled").Default("https://s.altnet.rippletest.net:51234/").String()
+	faucetURL    = kingpin.Flag("faucet", "Faucet endpoint funding a destination address").Default("https://faucet.altnet.rippletest.net/accounts").String()
+	outDir       = kingpin.Flag("out", "Directory to write the pools to").Short('o').Default("corpus").String()
+	seed         = kingpin.Flag("seed", "Secret the account keys are derived from; the same seed gives the same accounts").Default("clio-corpus").String()
+	parallel     = kingpin.Flag("parallel", "Number of transactions submitted at the same time").Default("8").Int()
+	fee          = kingpin.Flag("fee", "Fee of every transaction in drops").Default("12").Int()
+	minBalance   = kingpin.Flag("min-balance", "Accounts holding fewer XRP than this are funded from the faucet").Default("50").Int()
+	faucetDelay  = kingpin.Flag("faucet-delay", "Pause between faucet requests").Default("2s").Duration()
+	timeout      = kingpin.Flag("timeout", "Timeout of every request").Default("20s").Duration()
+)
+
+const lsfDefaultRipple = 0x00800000
+
+type accountGroup struct {
+	Name          string `json:"name"`
+	Count         int    `json:"count"`
+	DefaultRipple bool   `json:"default_ripple"`
+}
+
+type trustLineStep struct {
+	Holders  string `json:"holders"`
+	Issuer   string `json:"issuer"`
+	Currency string `json:"currency"`
+	Limit    string `json:"limit"`
+	Fund     string `json:"fund"`
+}
+
+type paymentStep struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Count  int             `json:"count"`
+	Amount json.RawMessage `json:"amount"`
+}
+
+type offerStep struct {
+	Account   string          `json:"account"`
+	Count     int             `json:"count"`
+	TakerPays json.RawMessage `json:"taker_pays"`
+	TakerGets json.RawMessage `json:"taker_gets"`
+}
+
+type nftStep struct {
+	Account     string `json:"account"`
+	Count       int    `json:"count"`
+	Taxon       uint32 `json:"taxon"`
+	URI         string `json:"uri"`
+	Flags       uint32 `json:"flags"`
+	TransferFee uint32 `json:"transfer_fee"`
+}
+
+type scenario struct {
+	Accounts   []accountGroup  `json:"accounts"`
+	TrustLines []trustLineStep `json:"trust_lines"`
+	Payments   []paymentStep   `json:"payments"`
+	Offers     []offerStep     `json:"offers"`
+	NFTs       []nftStep       `json:"nfts"`
+}
+
+type wallet struct {
+	Group   string `json:"group"`
+	Address string `json:"address"`
+	Seed    string `json:"seed"`
+
+	key      *xrpl.Ed25519Key
+	mu       sync.Mutex
+	sequence uint32
+}
+
+type txResult struct {
+	Kind        string `json:"kind"`
+	Account     string `json:"account"`
+	Hash        string `json:"hash"`
+	LedgerIndex uint64 `json:"ledger_index,omitempty"`
+	Result      string `json:"result"`
+	NFTokenID   string `json:"nftoken_id,omitempty"`
+}
+
+type corpus struct {
+	Seed         string               `json:"seed"`
+	Rippled      string               `json:"rippled"`
+	Created      time.Time            `json:"created"`
+	Accounts     map[string][]*wallet `json:"accounts"`
+	Transactions []txResult           `json:"transactions"`
+}
+
+type builder struct {
+	client *rpc.Client
+	groups map[string][]*wallet
+
+	mu      sync.Mutex
+	results []txResult
+}
+
+func deriveWallet(group string, i int) *wallet {
+	entropy := xrpl.SHA512Half([]byte(fmt.Sprintf("%s/%s/%d", *seed, group, i)))[:16]
+	key := xrpl.NewEd25519Key(entropy)
+	return &wallet{Group: group, Address: key.Address(), Seed: key.Seed(), key: key}
+}
+
+// amount turns a scenario amount into its transaction form; issuers name the first account of a group
+func (b *builder) amount(raw json.RawMessage) (interface{}, error) {
+	var drops string
+	if err := json.Unmarshal(raw, &drops); err == nil {
+		if _, err := strconv.ParseUint(drops, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid XRP amount %q", drops)
+		}
+		return drops, nil
+	}
+
+	var issued struct {
+		Currency string `json:"currency"`
+		Issuer   string `json:"issuer"`
+		Value    string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &issued); err != nil {
+		return nil, fmt.Errorf("invalid amount %s", raw)
+	}
+
+	group, ok := b.groups[issued.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("unknown issuer group %q", issued.Issuer)
+	}
+	return map[string]interface{}{"currency": issued.Currency, "issuer": group[0].Address, "value": issued.Value}, nil
+}
+
+func (b *builder) group(name string) []*wallet {
+	g, ok := b.groups[name]
+	if !ok {
+		log.Fatalf("Scenario refers to unknown account group %q", name)
+	}
+	return g
+}
+
+func (b *builder) accountInfo(ctx context.Context, address string) (map[string]interface{}, error) {
+	result, err := b.client.Call(ctx, "account_info", map[string]interface{}{"account": address, "ledger_index": "validated"})
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := result["account_data"].(map[string]interface{})
+	return data, nil
+}
+
+func balanceXRP(data map[string]interface{}) float64 {
+	drops, _ := strconv.ParseFloat(fmt.Sprint(data["Balance"]), 64)
+	return drops / 1e6
+}
+
+func (b *builder) faucet(address string) error {
+	body, _ := json.Marshal(map[string]string{"destination": address})
+	resp, err := http.Post(*faucetURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	out, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("faucet returned HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// fund makes sure every account exists with at least --min-balance XRP and loads its sequence
+func (b *builder) fund(ctx context.Context, w *wallet) error {
+	data, err := b.accountInfo(ctx, w.Address)
+	var rpcErr *rpc.Error
+	if err != nil && !(asRPCError(err, &rpcErr) && rpcErr.Code == "actNotFound") {
+		return err
+	}
+
+	if data == nil || balanceXRP(data) < float64(*minBalance) {
+		log.Printf("Funding %s (%s) from the faucet\n", w.Address, w.Group)
+		if err := b.faucet(w.Address); err != nil {
+			return err
+		}
+		time.Sleep(*faucetDelay)
+
+		for deadline := time.Now().Add(time.Minute); ; time.Sleep(2 * time.Second) {
+			data, err = b.accountInfo(ctx, w.Address)
+			if err == nil && balanceXRP(data) >= float64(*minBalance) {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s was not funded within a minute", w.Address)
+			}
+		}
+	}
+
+	seq, _ := rpc.Uint(data["Sequence"])
+	w.sequence = uint32(seq)
+	return nil
+}
+
+func asRPCError(err error, target **rpc.Error) bool {
+	e, ok := err.(*rpc.Error)
+	if ok {
+		*target = e
+	}
+	return ok
+}
+
+// submit signs and submits a transaction from w, then waits for it to be validated
+func (b *builder) submit(ctx context.Context, kind string, w *wallet, tx map[string]interface{}) (map[string]interface{}, error) {
+	validated, err := b.client.ValidatedLedgerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	tx["Account"] = w.Address
+	tx["Fee"] = strconv.Itoa(*fee)
+	tx["Sequence"] = w.sequence
+	tx["LastLedgerSequence"] = uint32(validated) + 20
+	if _, ok := tx["Flags"]; !ok {
+		tx["Flags"] = uint32(0)
+	}
+
+	blob, hash, err := w.key.Sign(tx)
+	if err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	result, err := b.client.Call(ctx, "submit", map[string]interface{}{"tx_blob": strings.ToUpper(hex.EncodeToString(blob))})
+	if err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	engine, _ := result["engine_result"].(string)
+	if engine != "tesSUCCESS" && engine != "terQUEUED" {
+		if engine == "tefPAST_SEQ" || engine == "terPRE_SEQ" {
+			// somebody else used the account; reload the sequence for the next attempt
+			if data, err := b.accountInfo(ctx, w.Address); err == nil {
+				seq, _ := rpc.Uint(data["Sequence"])
+				w.sequence = uint32(seq)
+			}
+		}
+		w.mu.Unlock()
+		message, _ := result["engine_result_message"].(string)
+		return nil, fmt.Errorf("%s %s rejected: %s %s", kind, strings.ToUpper(hex.EncodeToString(hash)), engine, message)
+	}
+
+	w.sequence++
+	w.mu.Unlock()
+
+	r := txResult{Kind: kind, Account: w.Address, Hash: strings.ToUpper(hex.EncodeToString(hash))}
+	meta, err := b.wait(ctx, r.Hash, uint64(validated)+20)
+	if meta != nil {
+		r.Result, _ = meta["TransactionResult"].(string)
+		r.NFTokenID, _ = meta["nftoken_id"].(string)
+		r.LedgerIndex, _ = rpc.Uint(meta["ledger_index"])
+	}
+	if err != nil {
+		r.Result = err.Error()
+	}
+
+	b.mu.Lock()
+	b.results = append(b.results, r)
+	b.mu.Unlock()
+
+	if err == nil && r.Result != "tesSUCCESS" {
+		err = fmt.Errorf("%s %s failed with %s", kind, r.Hash, r.Result)
+	}
+	return meta, err
+}
+
+// wait polls tx until the transaction is validated or can no longer be
+func (b *builder) wait(ctx context.Context, hash string, lastLedger uint64) (map[string]interface{}, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+
+		result, err := b.client.Call(ctx, "tx", map[string]interface{}{"transaction": hash})
+		if err == nil {
+			if validated, _ := result["validated"].(bool); validated {
+				meta, _ := result["meta"].(map[string]interface{})
+				if meta == nil {
+					meta = map[string]interface{}{}
+				}
+				meta["ledger_index"] = result["ledger_index"]
+				return meta, nil
+			}
+		} else if rpcErr, ok := err.(*rpc.Error); !ok || rpcErr.Code != "txnNotFound" {
+			return nil, err
+		}
+
+		if current, err := b.client.ValidatedLedgerIndex(ctx); err == nil && current > lastLedger {
+			return nil, fmt.Errorf("expired")
+		}
+	}
+}
+
+type job struct {
+	kind   string
+	wallet *wallet
+	tx     func() (map[string]interface{}, error)
+}
+
+// run submits the jobs of one phase in parallel, keeping the jobs of every account in order
+func (b *builder) run(ctx context.Context, phase string, jobs []job) int {
+	if len(jobs) == 0 {
+		return 0
+	}
+
+	byWallet := make(map[*wallet][]job)
+	var order []*wallet
+	for _, j := range jobs {
+		if _, ok := byWallet[j.wallet]; !ok {
+			order = append(order, j.wallet)
+		}
+		byWallet[j.wallet] = append(byWallet[j.wallet], j)
+	}
+
+	log.Printf("%s: %d transactions from %d accounts\n", phase, len(jobs), len(order))
+
+	var wg sync.WaitGroup
+	var failed int
+	var mu sync.Mutex
+	sem := make(chan struct{}, *parallel)
+	for _, w := range order {
+		wg.Add(1)
+		go func(list []job) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for _, j := range list {
+				tx, err := j.tx()
+				if err == nil {
+					_, err = b.submit(ctx, j.kind, j.wallet, tx)
+				}
+				if err != nil {
+					log.Printf("ERROR: %s\n", err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}(byWallet[w])
+	}
+	wg.Wait()
+
+	return failed
+}
+
+func writeLines(path string, lines []string) error {
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	kingpin.Parse()
+
+	data, err := os.ReadFile(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var sc scenario
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sc); err != nil {
+		log.Fatalf("Invalid scenario: %s", err)
+	}
+
+	ctx := context.Background()
+	b := &builder{client: rpc.NewClient(*rippledURL, *timeout), groups: make(map[string][]*wallet)}
+	c := corpus{Seed: *seed, Rippled: *rippledURL, Created: time.Now().UTC(), Accounts: b.groups}
+
+	for _, g := range sc.Accounts {
+		if g.Count < 1 {
+			log.Fatalf("Account group %q needs a positive count", g.Name)
+		}
+		for i := 0; i < g.Count; i++ {
+			b.groups[g.Name] = append(b.groups[g.Name], deriveWallet(g.Name, i))
+		}
+	}
+
+	for _, g := range sc.Accounts {
+		for _, w := range b.groups[g.Name] {
+			if err := b.fund(ctx, w); err != nil {
+				log.Fatalf("Failed to fund %s: %s", w.Address, err)
+			}
+		}
+	}
+
+	failed := 0
+
+	var jobs []job
+	for _, g := range sc.Accounts {
+		if !g.DefaultRipple {
+			continue
+		}
+		for _, w := range b.groups[g.Name] {
+			data, err := b.accountInfo(ctx, w.Address)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if flags, _ := rpc.Uint(data["Flags"]); flags&lsfDefaultRipple != 0 {
+				continue
+			}
+			jobs = append(jobs, job{"AccountSet", w, func() (map[string]interface{}, error) {
+				return map[string]interface{}{"TransactionType": "AccountSet", "SetFlag": uint32(8)}, nil
+			}})
+		}
+	}
+	failed += b.run(ctx, "default ripple", jobs)
+
+	jobs = nil
+	var funding []job
+	for _, t := range sc.TrustLines {
+		for _, issuer := range b.group(t.Issuer) {
+			for _, holder := range b.group(t.Holders) {
+				issuer, holder, t := issuer, holder, t
+				jobs = append(jobs, job{"TrustSet", holder, func() (map[string]interface{}, error) {
+					return map[string]interface{}{
+						"TransactionType": "TrustSet",
+						"LimitAmount":     map[string]interface{}{"currency": t.Currency, "issuer": issuer.Address, "value": t.Limit},
+					}, nil
+				}})
+				if t.Fund != "" {
+					funding = append(funding, job{"Payment", issuer, func() (map[string]interface{}, error) {
+						return map[string]interface{}{
+							"TransactionType": "Payment",
+							"Destination":     holder.Address,
+							"Amount":          map[string]interface{}{"currency": t.Currency, "issuer": issuer.Address, "value": t.Fund},
+						}, nil
+					}})
+				}
+			}
+		}
+	}
+	failed += b.run(ctx, "trust lines", jobs)
+	failed += b.run(ctx, "issuing", funding)
+
+	jobs = nil
+	for _, p := range sc.Payments {
+		to := b.group(p.To)
+		n := 0
+		for _, from := range b.group(p.From) {
+			for i := 0; i < p.Count; i++ {
+				dest := to[n%len(to)]
+				if dest == from {
+					dest = to[(n+1)%len(to)]
+				}
+				n++
+				if dest == from {
+					continue
+				}
+
+				p := p
+				jobs = append(jobs, job{"Payment", from, func() (map[string]interface{}, error) {
+					amount, err := b.amount(p.Amount)
+					return map[string]interface{}{"TransactionType": "Payment", "Destination": dest.Address, "Amount": amount}, err
+				}})
+			}
+		}
+	}
+	failed += b.run(ctx, "payments", jobs)
+
+	jobs = nil
+	for _, o := range sc.Offers {
+		for _, w := range b.group(o.Account) {
+			for i := 0; i < o.Count; i++ {
+				o := o
+				jobs = append(jobs, job{"OfferCreate", w, func() (map[string]interface{}, error) {
+					pays, err := b.amount(o.TakerPays)
+					if err != nil {
+						return nil, err
+					}
+					gets, err := b.amount(o.TakerGets)
+					return map[string]interface{}{"TransactionType": "OfferCreate", "TakerPays": pays, "TakerGets": gets}, err
+				}})
+			}
+		}
+	}
+	failed += b.run(ctx, "offers", jobs)
+
+	jobs = nil
+	for _, s := range sc.NFTs {
+		n := 0
+		for _, w := range b.group(s.Account) {
+			for i := 0; i < s.Count; i++ {
+				s := s
+				uri := strings.ReplaceAll(s.URI, "{n}", strconv.Itoa(n))
+				n++
+				jobs = append(jobs, job{"NFTokenMint", w, func() (map[string]interface{}, error) {
+					flags := s.Flags
+					if flags == 0 {
+						flags = 8
+					}
+					tx := map[string]interface{}{"TransactionType": "NFTokenMint", "NFTokenTaxon": s.Taxon, "Flags": flags}
+					if uri != "" {
+						tx["URI"] = []byte(uri)
+					}
+					if s.TransferFee > 0 {
+						tx["TransferFee"] = s.TransferFee
+					}
+					return tx, nil
+				}})
+			}
+		}
+	}
+	failed += b.run(ctx, "NFTs", jobs)
+
+	c.Transactions = b.results
+	sort.Slice(c.Transactions, func(i, j int) bool {
+		if c.Transactions[i].LedgerIndex != c.Transactions[j].LedgerIndex {
+			return c.Transactions[i].LedgerIndex < c.Transactions[j].LedgerIndex
+		}
+		return c.Transactions[i].Hash < c.Transactions[j].Hash
+	})
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	var all []string
+	for _, g := range sc.Accounts {
+		var addresses []string
+		for _, w := range b.groups[g.Name] {
+			addresses = append(addresses, w.Address)
+		}
+		all = append(all, addresses...)
+		if err := writeLines(filepath.Join(*outDir, "accounts_"+g.Name+".txt"), addresses); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var hashes, nfts, ledgers []string
+	seen := make(map[uint64]bool)
+	for _, t := range c.Transactions {
+		if t.Result != "tesSUCCESS" {
+			continue
+		}
+		hashes = append(hashes, t.Hash)
+		if t.NFTokenID != "" {
+			nfts = append(nfts, t.NFTokenID)
+		}
+		if !seen[t.LedgerIndex] {
+			seen[t.LedgerIndex] = true
+			ledgers = append(ledgers, strconv.FormatUint(t.LedgerIndex, 10))
+		}
+	}
+
+	pools := map[string][]string{"accounts.txt": all, "transactions.txt": hashes, "nfts.txt": nfts, "ledgers.txt": ledgers}
+	for name, lines := range pools {
+		if err := writeLines(filepath.Join(*outDir, name), lines); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	manifest, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*outDir, "corpus.json"), manifest, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Corpus written to %s\n", *outDir)
+	log.Printf("TOTAL ACCOUNTS: %d\n", len(all))
+	log.Printf("TOTAL TRANSACTIONS: %d\n", len(hashes))
+	log.Printf("TOTAL NFTS: %d\n", len(nfts))
+	log.Printf("TOTAL FAILED: %d\n", failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
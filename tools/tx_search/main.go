@@ -0,0 +1,318 @@
+//
+// Searches the transactions table for transactions matching a set of criteria.
+//
+// The whole table is scanned in parallel token ranges (the same approach as cassandra_delete_range), rows
+// outside the ledger range are skipped before decoding and every match is printed as one NDJSON line:
+//
+//	{"hash": "...", "ledger_index": 85000000, "date": "2024-01-10T12:34:56Z", "type": "Payment",
+//	 "result": "tesSUCCESS", "tx": {...}, "meta": {...}}
+//
+// Matches are printed in scan order, not ledger order.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"xrplf/clio/cassandra"
+	"xrplf/clio/xrpl"
+)
+
+const (
+	defaultNumberOfNodesInCluster = 3
+	defaultNumberOfCoresInNode    = 8
+	defaultSmudgeFactor           = 3
+)
+
+var (
+	clusterHosts = kingpin.Arg("hosts", "Your Scylla nodes IP addresses, comma separated (i.e. 192.168.1.1,192.168.1.2,192.168.1.3)").Required().String()
+
+	fromLedger     = kingpin.Flag("from", "First ledger to search").Uint64()
+	toLedger       = kingpin.Flag("to", "Last ledger to search").Uint64()
+	txTypes        = kingpin.Flag("type", "Transaction type to match (i.e. Payment); can be repeated").Strings()
+	accounts       = kingpin.Flag("account", "Match transactions sent by or to this account; can be repeated").Strings()
+	destinationTag = kingpin.Flag("destination-tag", "Match transactions with this destination tag").Default("-1").Int64()
+	currency       = kingpin.Flag("currency", "Only compare amounts in this currency (XRP amounts are in drops)").String()
+	minAmount      = kingpin.Flag("min-amount", "Match transactions delivering at least this amount").String()
+	maxAmount      = kingpin.Flag("max-amount", "Match transactions delivering at most this amount").String()
+	results        = kingpin.Flag("result", "Transaction result to match; a trailing * matches a prefix (i.e. tec*); can be repeated").Strings()
+	withMeta       = kingpin.Flag("with-meta", "Include the decoded metadata in the output").Default("false").Bool()
+	maxMatches     = kingpin.Flag("limit", "Stop after this many matches (0 for no limit)").Default("0").Int()
+
+	nodesInCluster  = kingpin.Flag("nodes-in-cluster", "Number of nodes in your Scylla cluster").Short('n').Default(fmt.Sprintf("%d", defaultNumberOfNodesInCluster)).Int()
+	coresInNode     = kingpin.Flag("cores-in-node", "Number of cores in each node").Short('c').Default(fmt.Sprintf("%d", defaultNumberOfCoresInNode)).Int()
+	smudgeFactor    = kingpin.Flag("smudge-factor", "Yet another factor to make parallelism cooler").Short('s').Default(fmt.Sprintf("%d", defaultSmudgeFactor)).Int()
+	clusterFlags    = cassandra.RegisterFlags(kingpin.CommandLine)
+	clusterPageSize = kingpin.Flag("cluster-page-size", "Page size of results").Short('p').Default("1000").Int()
+	keyspace        = kingpin.Flag("keyspace", "Keyspace to use").Short('k').Default("clio_fh").String()
+
+	workerCount = 1
+)
+
+type criteria struct {
+	types     map[string]bool
+	accounts  map[string]bool
+	results   []string
+	minAmount *big.Rat
+	maxAmount *big.Rat
+	needMeta  bool
+}
+
+type match struct {
+	Hash        string                 `json:"hash"`
+	LedgerIndex uint64                 `json:"ledger_index"`
+	Date        string                 `json:"date"`
+	Type        string                 `json:"type"`
+	Result      string                 `json:"result,omitempty"`
+	Tx          map[string]interface{} `json:"tx"`
+	Meta        map[string]interface{} `json:"meta,omitempty"`
+}
+
+func newCriteria() (*criteria, error) {
+	c := &criteria{types: make(map[string]bool), accounts: make(map[string]bool), results: *results, needMeta: *withMeta || len(*results) > 0}
+	for _, t := range *txTypes {
+		if _, ok := xrpl.TransactionTypeCode(t); !ok {
+			return nil, fmt.Errorf("unknown transaction type %s", t)
+		}
+		c.types[t] = true
+	}
+
+	for _, a := range *accounts {
+		if _, err := xrpl.DecodeAccountID(a); err != nil {
+			return nil, fmt.Errorf("invalid account %s: %w", a, err)
+		}
+		c.accounts[a] = true
+	}
+
+	for _, bound := range []struct {
+		value  string
+		target **big.Rat
+	}{{*minAmount, &c.minAmount}, {*maxAmount, &c.maxAmount}} {
+		if bound.value == "" {
+			continue
+		}
+
+		r, ok := new(big.Rat).SetString(bound.value)
+		if !ok {
+			return nil, fmt.Errorf("invalid amount %s", bound.value)
+		}
+		*bound.target = r
+		c.needMeta = true
+	}
+
+	return c, nil
+}
+
+// returns the amount a transaction delivered, preferring the metadata over the Amount field
+func deliveredAmount(tx map[string]interface{}, meta map[string]interface{}) (*big.Rat, string, bool) {
+	amount := meta["DeliveredAmount"]
+	if amount == nil {
+		amount = tx["Amount"]
+	}
+
+	switch a := amount.(type) {
+	case string:
+		r, ok := new(big.Rat).SetString(a)
+		return r, "XRP", ok
+	case map[string]interface{}:
+		value, _ := a["value"].(string)
+		cur, _ := a["currency"].(string)
+		r, ok := new(big.Rat).SetString(value)
+		return r, cur, ok
+	}
+	return nil, "", false
+}
+
+func (c *criteria) matchResult(result string) bool {
+	if len(c.results) == 0 {
+		return true
+	}
+
+	for _, r := range c.results {
+		if prefix, ok := strings.CutSuffix(r, "*"); ok && strings.HasPrefix(result, prefix) {
+			return true
+		}
+		if r == result {
+			return true
+		}
+	}
+	return false
+}
+
+// checks the transaction fields first so that the metadata is only decoded for likely matches
+func (c *criteria) check(txBlob []byte, metaBlob []byte) (map[string]interface{}, map[string]interface{}, bool, error) {
+	tx, err := xrpl.Decode(txBlob)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if t, _ := tx["TransactionType"].(string); len(c.types) > 0 && !c.types[t] {
+		return nil, nil, false, nil
+	}
+
+	if len(c.accounts) > 0 {
+		from, _ := tx["Account"].(string)
+		to, _ := tx["Destination"].(string)
+		if !c.accounts[from] && !c.accounts[to] {
+			return nil, nil, false, nil
+		}
+	}
+
+	if *destinationTag >= 0 {
+		tag, ok := tx["DestinationTag"].(int64)
+		if !ok || tag != *destinationTag {
+			return nil, nil, false, nil
+		}
+	}
+
+	if !c.needMeta {
+		return tx, nil, true, nil
+	}
+
+	meta, err := xrpl.Decode(metaBlob)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	result, _ := meta["TransactionResult"].(string)
+	if !c.matchResult(result) {
+		return nil, nil, false, nil
+	}
+
+	if c.minAmount != nil || c.maxAmount != nil {
+		amount, cur, ok := deliveredAmount(tx, meta)
+		if !ok || (*currency != "" && cur != *currency) {
+			return nil, nil, false, nil
+		}
+		if c.minAmount != nil && amount.Cmp(c.minAmount) < 0 {
+			return nil, nil, false, nil
+		}
+		if c.maxAmount != nil && amount.Cmp(c.maxAmount) > 0 {
+			return nil, nil, false, nil
+		}
+	}
+
+	return tx, meta, true, nil
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	c, err := newCriteria()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workerCount = (*nodesInCluster) * (*coresInNode) * (*smudgeFactor)
+	ranges := cassandra.GetTokenRanges(workerCount)
+	cassandra.Shuffle(ranges)
+
+	cluster := clusterFlags.NewCluster(*clusterHosts, *keyspace)
+	cluster.PageSize = *clusterPageSize
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer session.Close()
+
+	rangesChannel := make(chan *cassandra.TokenRange, len(ranges))
+	for i := range ranges {
+		rangesChannel <- ranges[i]
+	}
+	close(rangesChannel)
+
+	var outMu sync.Mutex
+	encoder := json.NewEncoder(os.Stdout)
+
+	var wg sync.WaitGroup
+	var totalRows, totalMatches, totalErrors, rangesDone uint64
+	var stop atomic.Bool
+	startTime := time.Now()
+
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+
+			for r := range rangesChannel {
+				if stop.Load() {
+					return
+				}
+
+				iter := session.Query("SELECT hash, ledger_sequence, date, transaction, metadata FROM transactions WHERE token(hash) >= ? AND token(hash) <= ?", r.StartRange, r.EndRange).Iter()
+
+				var hash, txBlob, metaBlob []byte
+				var seq, date uint64
+				for iter.Scan(&hash, &seq, &date, &txBlob, &metaBlob) && !stop.Load() {
+					atomic.AddUint64(&totalRows, 1)
+
+					// only decode the rows that are in the correct range of sequence numbers
+					if seq < *fromLedger || (*toLedger != 0 && seq > *toLedger) {
+						continue
+					}
+
+					tx, meta, ok, err := c.check(txBlob, metaBlob)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "FAILED TO DECODE: %X: %s\n", hash, err)
+						atomic.AddUint64(&totalErrors, 1)
+						continue
+					}
+
+					if !ok {
+						continue
+					}
+
+					m := match{Hash: fmt.Sprintf("%X", hash), LedgerIndex: seq, Date: xrpl.RippleTime(uint32(date)).UTC().Format(time.RFC3339), Tx: tx}
+					m.Type, _ = tx["TransactionType"].(string)
+					if meta != nil {
+						m.Result, _ = meta["TransactionResult"].(string)
+					}
+					if *withMeta {
+						m.Meta = meta
+					}
+
+					outMu.Lock()
+					if !stop.Load() {
+						encoder.Encode(m)
+						n := atomic.AddUint64(&totalMatches, 1)
+						if *maxMatches > 0 && n >= uint64(*maxMatches) {
+							stop.Store(true)
+						}
+					}
+					outMu.Unlock()
+				}
+
+				if err := iter.Close(); err != nil {
+					log.Printf("ERROR: page iteration failed: %s\n", err)
+					fmt.Fprintf(os.Stderr, "FAILED QUERY: [from=%d][to=%d]\n", r.StartRange, r.EndRange)
+					atomic.AddUint64(&totalErrors, 1)
+				}
+
+				if done := atomic.AddUint64(&rangesDone, 1); done%uint64(workerCount*10) == 0 {
+					log.Printf("... %d/%d token ranges scanned, %d rows, %d matches ...\n", done, len(ranges), atomic.LoadUint64(&totalRows), atomic.LoadUint64(&totalMatches))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	log.Printf("TOTAL ROWS SCANNED: %d\n", totalRows)
+	log.Printf("TOTAL MATCHES: %d\n", totalMatches)
+	log.Printf("TOTAL ERRORS: %d\n", totalErrors)
+	log.Printf("TOTAL EXECUTION TIME: %s\n", time.Since(startTime))
+}
@@ -0,0 +1,674 @@
+//
+// Simulates a population of wallet clients against a Clio server over WebSocket.
+//
+// A wallet session connects, subscribes to its own account, polls account_info and account_lines at
+// its own pace and now and then pages through a few pages of account_tx, like a wallet refreshing its
+// history. Sessions end after a random lifetime and are replaced by new ones for other accounts, so the
+// number of concurrent sessions stays at --sessions while connections churn.
+//
+// Behaviour comes from weighted profiles (--profile light=60,active=30,power=10). The built-in profiles
+// can be changed or new ones added with --profiles, a JSON array of objects with the fields of `profile`.
+// Intervals and lifetimes are randomized around their configured means so sessions never synchronize.
+//
+// Client-side latencies are reported per method. With --metrics pointing at Clio's Prometheus endpoint
+// the server-side request counts, durations and errors over the run are reported as well.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	clioURL        = kingpin.Arg("url", "WebSocket URL of the Clio server").Required().String()
+	sessions       = kingpin.Flag("sessions", "Number of concurrent wallet sessions").Default("1000").Int()
+	runDuration    = kingpin.Flag("duration", "How long to run").Default("10m").Duration()
+	ramp           = kingpin.Flag("ramp", "Time over which sessions are started").Default("1m").Duration()
+	profileMix     = kingpin.Flag("profile", "Weighted profile mix as name=weight, comma separated").Default("light=60,active=30,power=10").String()
+	profilesFile   = kingpin.Flag("profiles", "JSON file with additional or replacement profiles").ExistingFile()
+	accountsFile   = kingpin.Flag("accounts", "File with one account per line. Accounts are discovered through ledger_data if omitted").ExistingFile()
+	discover       = kingpin.Flag("discover", "Number of accounts to discover when no --accounts file is given").Default("2000").Int()
+	txPageSize     = kingpin.Flag("tx-page-size", "Limit of every account_tx page").Default("20").Int()
+	requestTimeout = kingpin.Flag("request-timeout", "Timeout of every request").Default("30s").Duration()
+	reportInterval = kingpin.Flag("report-interval", "Interval between progress reports").Default("10s").Duration()
+	metricsURL     = kingpin.Flag("metrics", "Clio Prometheus metrics URL to compute server-side impact from").String()
+	jsonOutput     = kingpin.Flag("json", "Print the final summary as JSON").Default("false").Bool()
+	seed           = kingpin.Flag("seed", "Random seed").Default("1").Int64()
+)
+
+type profile struct {
+	Name            string   `json:"name"`
+	InfoInterval    duration `json:"info_interval"`
+	LinesInterval   duration `json:"lines_interval"`
+	HistoryChance   float64  `json:"history_chance"`
+	HistoryPages    int      `json:"history_pages"`
+	Lifetime        duration `json:"lifetime"`
+	SubscribeLedger bool     `json:"subscribe_ledger"`
+	weight          int
+}
+
+// duration reads "15s" style strings from the profiles file
+type duration struct{ time.Duration }
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	d.Duration = v
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+var builtinProfiles = map[string]*profile{
+	"light":  {Name: "light", InfoInterval: duration{60 * time.Second}, LinesInterval: duration{5 * time.Minute}, HistoryChance: 0.05, HistoryPages: 1, Lifetime: duration{3 * time.Minute}},
+	"active": {Name: "active", InfoInterval: duration{15 * time.Second}, LinesInterval: duration{time.Minute}, HistoryChance: 0.2, HistoryPages: 2, Lifetime: duration{15 * time.Minute}},
+	"power":  {Name: "power", InfoInterval: duration{5 * time.Second}, LinesInterval: duration{20 * time.Second}, HistoryChance: 0.3, HistoryPages: 5, Lifetime: duration{time.Hour}, SubscribeLedger: true},
+}
+
+type methodStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    uint64
+}
+
+type simulator struct {
+	accounts []string
+	profiles []*profile
+	total    int
+
+	mu      sync.Mutex
+	methods map[string]*methodStats
+	rng     *rand.Rand
+
+	started, active, connectFailures, streamMessages, requests uint64
+}
+
+func (s *simulator) record(method string, elapsed time.Duration, failed bool) {
+	s.mu.Lock()
+	m, ok := s.methods[method]
+	if !ok {
+		m = &methodStats{}
+		s.methods[method] = m
+	}
+	s.mu.Unlock()
+
+	atomic.AddUint64(&s.requests, 1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if failed {
+		m.errors++
+		return
+	}
+	m.latencies = append(m.latencies, elapsed)
+}
+
+// draws a value around `mean`, so that sessions spread out instead of firing together
+func (s *simulator) jitter(mean time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(float64(mean) * (0.5 + s.rng.Float64()))
+}
+
+func (s *simulator) lifetime(mean time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rng.ExpFloat64() * float64(mean))
+}
+
+func (s *simulator) chance(p float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < p
+}
+
+func (s *simulator) pick() (*profile, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.rng.Intn(s.total)
+	p := s.profiles[len(s.profiles)-1]
+	for _, candidate := range s.profiles {
+		if n < candidate.weight {
+			p = candidate
+			break
+		}
+		n -= candidate.weight
+	}
+	return p, s.accounts[s.rng.Intn(len(s.accounts))]
+}
+
+// a WebSocket connection matching responses to requests by id
+type connection struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan map[string]interface{}
+	done    chan struct{}
+	onEvent func()
+}
+
+func dial(url string, onEvent func()) (*connection, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &connection{conn: conn, pending: make(map[int]chan map[string]interface{}), done: make(chan struct{}), onEvent: onEvent}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *connection) readLoop() {
+	defer close(c.done)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		id, ok := msg["id"].(float64)
+		if !ok {
+			// stream messages: transactions for the subscribed account, closed ledgers
+			if c.onEvent != nil {
+				c.onEvent()
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch := c.pending[int(id)]
+		delete(c.pending, int(id))
+		c.mu.Unlock()
+
+		if ch != nil {
+			ch <- msg
+		}
+	}
+}
+
+func (c *connection) call(ctx context.Context, command string, params map[string]interface{}) (map[string]interface{}, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan map[string]interface{}, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	msg := map[string]interface{}{"id": id, "command": command}
+	for k, v := range params {
+		msg[k] = v
+	}
+
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *requestTimeout)
+	defer cancel()
+
+	select {
+	case resp := <-ch:
+		if status, _ := resp["status"].(string); status == "error" {
+			code, _ := resp["error"].(string)
+			return resp, fmt.Errorf("%s", code)
+		}
+		result, _ := resp["result"].(map[string]interface{})
+		return result, nil
+	case <-c.done:
+		return nil, fmt.Errorf("connection closed")
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *connection) close() {
+	c.writeMu.Lock()
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+	c.conn.Close()
+	<-c.done
+}
+
+func (s *simulator) timed(ctx context.Context, c *connection, command string, params map[string]interface{}) (map[string]interface{}, error) {
+	start := time.Now()
+	result, err := c.call(ctx, command, params)
+	s.record(command, time.Since(start), err != nil)
+	return result, err
+}
+
+func (s *simulator) session(ctx context.Context) {
+	p, account := s.pick()
+
+	c, err := dial(*clioURL, func() { atomic.AddUint64(&s.streamMessages, 1) })
+	if err != nil {
+		atomic.AddUint64(&s.connectFailures, 1)
+		// do not hammer a server that refuses connections
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.jitter(time.Second)):
+		}
+		return
+	}
+
+	defer c.close()
+
+	atomic.AddUint64(&s.started, 1)
+	atomic.AddUint64(&s.active, 1)
+	defer atomic.AddUint64(&s.active, ^uint64(0))
+
+	ctx, cancel := context.WithTimeout(ctx, s.lifetime(p.Lifetime.Duration))
+	defer cancel()
+
+	subscription := map[string]interface{}{"accounts": []string{account}}
+	if p.SubscribeLedger {
+		subscription["streams"] = []string{"ledger"}
+	}
+	if _, err := s.timed(ctx, c, "subscribe", subscription); err != nil {
+		return
+	}
+
+	// a wallet loads everything once when it opens
+	s.timed(ctx, c, "account_info", map[string]interface{}{"account": account, "ledger_index": "validated"})
+	s.timed(ctx, c, "account_lines", map[string]interface{}{"account": account, "ledger_index": "validated"})
+	s.history(ctx, c, account, p.HistoryPages)
+
+	nextInfo := time.After(s.jitter(p.InfoInterval.Duration))
+	nextLines := time.After(s.jitter(p.LinesInterval.Duration))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		case <-nextInfo:
+			s.timed(ctx, c, "account_info", map[string]interface{}{"account": account, "ledger_index": "validated"})
+			if s.chance(p.HistoryChance) {
+				s.history(ctx, c, account, p.HistoryPages)
+			}
+			nextInfo = time.After(s.jitter(p.InfoInterval.Duration))
+		case <-nextLines:
+			s.timed(ctx, c, "account_lines", map[string]interface{}{"account": account, "ledger_index": "validated"})
+			nextLines = time.After(s.jitter(p.LinesInterval.Duration))
+		}
+	}
+}
+
+// pages backwards through the most recent history of an account
+func (s *simulator) history(ctx context.Context, c *connection, account string, pages int) {
+	var marker interface{}
+	for i := 0; i < pages; i++ {
+		params := map[string]interface{}{"account": account, "limit": *txPageSize}
+		if marker != nil {
+			params["marker"] = marker
+		}
+
+		result, err := s.timed(ctx, c, "account_tx", params)
+		if err != nil {
+			return
+		}
+
+		if marker = result["marker"]; marker == nil {
+			return
+		}
+	}
+}
+
+func loadProfiles() ([]*profile, error) {
+	profiles := builtinProfiles
+	if *profilesFile != "" {
+		data, err := os.ReadFile(*profilesFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var custom []*profile
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("invalid profiles file: %w", err)
+		}
+		for _, p := range custom {
+			profiles[p.Name] = p
+		}
+	}
+
+	var out []*profile
+	for _, entry := range strings.Split(*profileMix, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		w, err := strconv.Atoi(weight)
+		if !ok || err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid profile weight %q", entry)
+		}
+
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		if p.InfoInterval.Duration <= 0 || p.LinesInterval.Duration <= 0 || p.Lifetime.Duration <= 0 {
+			return nil, fmt.Errorf("profile %q needs positive intervals and lifetime", name)
+		}
+
+		p.weight = w
+		out = append(out, p)
+	}
+
+	return out, nil
+}
+
+func loadAccounts(ctx context.Context) ([]string, error) {
+	if *accountsFile != "" {
+		data, err := os.ReadFile(*accountsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var accounts []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				accounts = append(accounts, line)
+			}
+		}
+		return accounts, nil
+	}
+
+	c, err := dial(*clioURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.close()
+
+	var accounts []string
+	var marker interface{}
+	for len(accounts) < *discover {
+		params := map[string]interface{}{"ledger_index": "validated", "type": "account", "limit": 256}
+		if marker != nil {
+			params["marker"] = marker
+		}
+
+		result, err := c.call(ctx, "ledger_data", params)
+		if err != nil {
+			return nil, err
+		}
+
+		state, _ := result["state"].([]interface{})
+		for _, raw := range state {
+			if object, ok := raw.(map[string]interface{}); ok {
+				if account, ok := object["Account"].(string); ok {
+					accounts = append(accounts, account)
+				}
+			}
+		}
+
+		if marker = result["marker"]; marker == nil {
+			break
+		}
+	}
+
+	return accounts, nil
+}
+
+// Prometheus samples by metric name and labels, i.e. `rpc_method_total_number{method="tx",status="finished"}`
+func scrape(url string) (map[string]float64, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			samples[line[:i]] = v
+		}
+	}
+
+	return samples, scanner.Err()
+}
+
+type methodSummary struct {
+	Method           string  `json:"method"`
+	Requests         int     `json:"requests"`
+	Errors           uint64  `json:"errors"`
+	P50Ms            float64 `json:"p50_ms"`
+	P90Ms            float64 `json:"p90_ms"`
+	P99Ms            float64 `json:"p99_ms"`
+	MaxMs            float64 `json:"max_ms"`
+	ServerFinished   float64 `json:"server_finished,omitempty"`
+	ServerErrored    float64 `json:"server_errored,omitempty"`
+	ServerMeanMs     float64 `json:"server_mean_ms,omitempty"`
+	ServerForwarded  float64 `json:"server_forwarded,omitempty"`
+	ServerFailedFwds float64 `json:"server_failed_forwards,omitempty"`
+}
+
+type summary struct {
+	DurationSec     float64            `json:"duration_sec"`
+	Sessions        uint64             `json:"sessions_started"`
+	ConnectFailures uint64             `json:"connect_failures"`
+	StreamMessages  uint64             `json:"stream_messages"`
+	Methods         []*methodSummary   `json:"methods"`
+	ServerErrors    map[string]float64 `json:"server_errors,omitempty"`
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return float64(sorted[idx].Microseconds()) / 1000
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	kingpin.Parse()
+
+	ctx := context.Background()
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts, err := loadAccounts(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load accounts: %s", err)
+	}
+	if len(accounts) == 0 {
+		log.Fatal("No accounts to simulate wallets for")
+	}
+
+	s := &simulator{accounts: accounts, profiles: profiles, methods: make(map[string]*methodStats), rng: rand.New(rand.NewSource(*seed))}
+	for _, p := range profiles {
+		s.total += p.weight
+	}
+
+	var before map[string]float64
+	if *metricsURL != "" {
+		if before, err = scrape(*metricsURL); err != nil {
+			log.Fatalf("Failed to scrape %s: %s", *metricsURL, err)
+		}
+	}
+
+	log.Printf("Running %d wallet sessions over %d accounts for %s\n", *sessions, len(accounts), *runDuration)
+
+	runCtx, cancel := context.WithTimeout(ctx, *runDuration)
+	defer cancel()
+
+	startTime := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(*sessions)
+	for i := 0; i < *sessions; i++ {
+		delay := time.Duration(float64(*ramp) * float64(i) / float64(*sessions))
+		go func() {
+			defer wg.Done()
+
+			select {
+			case <-runCtx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			for runCtx.Err() == nil {
+				s.session(runCtx)
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(*reportInterval)
+		defer ticker.Stop()
+
+		var lastRequests uint64
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				requests := atomic.LoadUint64(&s.requests)
+				log.Printf("... %d active sessions, %d started, %.1f req/s, %d stream messages, %d connect failures ...\n",
+					atomic.LoadUint64(&s.active), atomic.LoadUint64(&s.started),
+					float64(requests-lastRequests)/reportInterval.Seconds(),
+					atomic.LoadUint64(&s.streamMessages), atomic.LoadUint64(&s.connectFailures))
+				lastRequests = requests
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	sum := summary{
+		DurationSec:     time.Since(startTime).Seconds(),
+		Sessions:        atomic.LoadUint64(&s.started),
+		ConnectFailures: atomic.LoadUint64(&s.connectFailures),
+		StreamMessages:  atomic.LoadUint64(&s.streamMessages),
+	}
+
+	var after map[string]float64
+	if *metricsURL != "" {
+		if after, err = scrape(*metricsURL); err != nil {
+			log.Printf("ERROR: failed to scrape %s: %s\n", *metricsURL, err)
+		}
+	}
+	delta := func(key string) float64 { return after[key] - before[key] }
+
+	for name, m := range s.methods {
+		sort.Slice(m.latencies, func(i, j int) bool { return m.latencies[i] < m.latencies[j] })
+		ms := &methodSummary{
+			Method:   name,
+			Requests: len(m.latencies) + int(m.errors),
+			Errors:   m.errors,
+			P50Ms:    percentile(m.latencies, 50),
+			P90Ms:    percentile(m.latencies, 90),
+			P99Ms:    percentile(m.latencies, 99),
+			MaxMs:    percentile(m.latencies, 100),
+		}
+
+		if after != nil {
+			label := func(status string) string {
+				return fmt.Sprintf(`rpc_method_total_number{method="%s",status="%s"}`, name, status)
+			}
+			ms.ServerFinished = delta(label("finished"))
+			ms.ServerErrored = delta(label("errored"))
+			ms.ServerForwarded = delta(label("forwarded"))
+			ms.ServerFailedFwds = delta(label("failed_forward"))
+			if ms.ServerFinished > 0 {
+				ms.ServerMeanMs = delta(fmt.Sprintf(`rpc_method_duration_us{method="%s"}`, name)) / ms.ServerFinished / 1000
+			}
+		}
+
+		sum.Methods = append(sum.Methods, ms)
+	}
+	sort.Slice(sum.Methods, func(i, j int) bool { return sum.Methods[i].Method < sum.Methods[j].Method })
+
+	if after != nil {
+		sum.ServerErrors = make(map[string]float64)
+		for key := range after {
+			if strings.HasPrefix(key, "rpc_error_total_number") {
+				sum.ServerErrors[key] = delta(key)
+			}
+		}
+	}
+
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(sum, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("%d sessions in %.0fs, %d connect failures, %d stream messages\n\n", sum.Sessions, sum.DurationSec, sum.ConnectFailures, sum.StreamMessages)
+	fmt.Printf("%-15s %10s %8s %10s %10s %10s %10s", "method", "requests", "errors", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	if after != nil {
+		fmt.Printf(" %12s %10s %14s", "srv finished", "srv errors", "srv mean ms")
+	}
+	fmt.Println()
+
+	for _, m := range sum.Methods {
+		fmt.Printf("%-15s %10d %8d %10.2f %10.2f %10.2f %10.2f", m.Method, m.Requests, m.Errors, m.P50Ms, m.P90Ms, m.P99Ms, m.MaxMs)
+		if after != nil {
+			fmt.Printf(" %12.0f %10.0f %14.2f", m.ServerFinished, m.ServerErrored, m.ServerMeanMs)
+		}
+		fmt.Println()
+	}
+
+	var errorKeys []string
+	for k := range sum.ServerErrors {
+		errorKeys = append(errorKeys, k)
+	}
+	sort.Strings(errorKeys)
+	for _, k := range errorKeys {
+		fmt.Printf("%s: %.0f\n", k, sum.ServerErrors[k])
+	}
+}
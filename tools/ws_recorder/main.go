@@ -0,0 +1,333 @@
+//
+// Transparent websocket proxy that records every session between clients and Clio.
+//
+// Each proxied connection is written to its own session file in --out: a header line followed by
+// one line per message in either direction, with the offset since the session started:
+//
+//	{"session": "20240101T120000-000001", "client": "10.0.0.1:53211", "upstream": "ws://clio:51233/", "started_at": "..."}
+//	{"offset_ms": 12.5, "dir": "client", "type": "text", "data": "{\"command\":\"subscribe\",...}"}
+//	{"offset_ms": 14.1, "dir": "server", "type": "text", "data": "{\"result\":{...},...}"}
+//
+// Binary frames are base64-encoded. The replay command plays the client side of a session back
+// against a server with the original timing and records the server side into a new session file.
+//
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	recordCmd = kingpin.Command("record", "Proxy websocket clients to Clio and record their sessions").Default()
+	listen    = recordCmd.Flag("listen", "Address to accept client connections on").Default(":51234").String()
+	upstream  = recordCmd.Flag("upstream", "Websocket URL of Clio").Default("ws://127.0.0.1:51233").String()
+	outDir    = recordCmd.Flag("out", "Directory to write session files to").Default("sessions").String()
+
+	replayCmd     = kingpin.Command("replay", "Replay the client side of a recorded session")
+	replaySession = replayCmd.Arg("session", "Session file to replay").Required().String()
+	target        = replayCmd.Flag("target", "Websocket URL to replay against").Default("ws://127.0.0.1:51233").String()
+	speed         = replayCmd.Flag("speed", "Replay speed multiplier; 0 sends as fast as possible").Default("1").Float64()
+	linger        = replayCmd.Flag("linger", "How long to keep receiving after the last client message").Default("5s").Duration()
+	replayOut     = replayCmd.Flag("out", "File to record the replayed session to").String()
+)
+
+type sessionHeader struct {
+	Session   string `json:"session"`
+	Client    string `json:"client"`
+	Upstream  string `json:"upstream"`
+	StartedAt string `json:"started_at"`
+}
+
+type message struct {
+	OffsetMS float64 `json:"offset_ms"`
+	Dir      string  `json:"dir"`
+	Type     string  `json:"type"`
+	Data     string  `json:"data"`
+}
+
+func (m *message) payload() ([]byte, int, error) {
+	if m.Type == "binary" {
+		b, err := base64.StdEncoding.DecodeString(m.Data)
+		return b, websocket.BinaryMessage, err
+	}
+	return []byte(m.Data), websocket.TextMessage, nil
+}
+
+// writes one session file; safe for use from both directions of a connection
+type sessionWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+	start   time.Time
+	count   uint64
+}
+
+func newSessionWriter(path string, header sessionHeader) (*sessionWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(f)
+	w := &sessionWriter{file: f, buf: buf, encoder: json.NewEncoder(buf), start: time.Now()}
+	return w, w.encoder.Encode(header)
+}
+
+func (w *sessionWriter) record(dir string, msgType int, data []byte) {
+	m := message{OffsetMS: float64(time.Since(w.start).Microseconds()) / 1000, Dir: dir, Type: "text", Data: string(data)}
+	if msgType == websocket.BinaryMessage {
+		m.Type = "binary"
+		m.Data = base64.StdEncoding.EncodeToString(data)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.encoder.Encode(m); err != nil {
+		log.Printf("Failed to record message: %s\n", err)
+	}
+	w.count++
+}
+
+func (w *sessionWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+var sessionCounter uint64
+
+// copies messages from src to dst, recording each of them, until either side fails
+func pipe(src *websocket.Conn, dst *websocket.Conn, dir string, w *sessionWriter) error {
+	for {
+		msgType, data, err := src.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		w.record(dir, msgType, data)
+
+		if err := dst.WriteMessage(msgType, data); err != nil {
+			return err
+		}
+	}
+}
+
+func closeCode(err error) int {
+	if ce, ok := err.(*websocket.CloseError); ok {
+		return ce.Code
+	}
+	return websocket.CloseGoingAway
+}
+
+func handleSession(rw http.ResponseWriter, r *http.Request) {
+	upstreamURL, err := url.Parse(*upstream)
+	if err != nil {
+		log.Fatal(err)
+	}
+	upstreamURL.Path = r.URL.Path
+	upstreamURL.RawQuery = r.URL.RawQuery
+
+	server, _, err := websocket.DefaultDialer.Dial(upstreamURL.String(), nil)
+	if err != nil {
+		log.Printf("Failed to connect to upstream %s: %s\n", upstreamURL, err)
+		http.Error(rw, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+
+	defer server.Close()
+
+	client, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		log.Printf("Websocket upgrade failed: %s\n", err)
+		return
+	}
+
+	defer client.Close()
+
+	id := fmt.Sprintf("%s-%06d", time.Now().UTC().Format("20060102T150405"), atomic.AddUint64(&sessionCounter, 1))
+	header := sessionHeader{Session: id, Client: r.RemoteAddr, Upstream: upstreamURL.String(), StartedAt: time.Now().UTC().Format(time.RFC3339Nano)}
+
+	w, err := newSessionWriter(filepath.Join(*outDir, id+".ndjson"), header)
+	if err != nil {
+		log.Printf("Failed to create session file: %s\n", err)
+		return
+	}
+
+	log.Printf("Session %s started for %s\n", id, r.RemoteAddr)
+
+	errs := make(chan error, 2)
+	go func() {
+		err := pipe(client, server, "client", w)
+		server.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode(err), ""))
+		errs <- err
+	}()
+	go func() {
+		err := pipe(server, client, "server", w)
+		client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode(err), ""))
+		errs <- err
+	}()
+
+	// either side going away ends the session
+	err = <-errs
+	client.Close()
+	server.Close()
+	<-errs
+
+	if cerr := w.close(); cerr != nil {
+		log.Printf("Failed to write session %s: %s\n", id, cerr)
+	}
+
+	log.Printf("Session %s ended after %s with %d messages (%s)\n", id, time.Since(w.start).Round(time.Millisecond), w.count, err)
+}
+
+func readSession(path string) (*sessionHeader, []message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+
+	var header sessionHeader
+	var messages []message
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				return nil, nil, fmt.Errorf("bad session header: %w", err)
+			}
+			continue
+		}
+
+		var m message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return &header, messages, scanner.Err()
+}
+
+func replay() {
+	header, messages, err := readSession(*replaySession)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(*target, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var w *sessionWriter
+	if *replayOut != "" {
+		w, err = newSessionWriter(*replayOut, sessionHeader{Session: header.Session + "-replay", Client: "replay", Upstream: *target, StartedAt: time.Now().UTC().Format(time.RFC3339Nano)})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var received uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			atomic.AddUint64(&received, 1)
+			if w != nil {
+				w.record("server", msgType, data)
+			}
+		}
+	}()
+
+	var sent, recorded uint64
+	start := time.Now()
+	for _, m := range messages {
+		if m.Dir != "client" {
+			recorded++
+			continue
+		}
+
+		if *speed > 0 {
+			due := start.Add(time.Duration(m.OffsetMS / *speed * float64(time.Millisecond)))
+			time.Sleep(time.Until(due))
+		}
+
+		data, msgType, err := m.payload()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if w != nil {
+			w.record("client", msgType, data)
+		}
+
+		if err := conn.WriteMessage(msgType, data); err != nil {
+			log.Printf("Connection lost after sending %d messages: %s\n", sent, err)
+			break
+		}
+		sent++
+	}
+
+	select {
+	case <-done:
+	case <-time.After(*linger):
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.Close()
+		<-done
+	}
+
+	if w != nil {
+		if err := w.close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("Replayed session %s: sent %d messages, received %d (%d in the original recording)\n", header.Session, sent, atomic.LoadUint64(&received), recorded)
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	switch kingpin.Parse() {
+	case replayCmd.FullCommand():
+		replay()
+	case recordCmd.FullCommand():
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Proxying %s to %s, recording into %s\n", *listen, *upstream, *outDir)
+		server := &http.Server{Addr: *listen, Handler: http.HandlerFunc(handleSession), ReadHeaderTimeout: 10 * time.Second}
+		log.Fatal(server.ListenAndServe())
+	}
+}
@@ -0,0 +1,131 @@
+package xrpl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+const alphabet = "rpshnaf39wBUDNEGHJKLM4PQRST7VWXYZ2bcdeCg65jkm8oFqi1tuvAxyz"
+
+const (
+	accountIDPrefix  = 0x00
+	familySeedPrefix = 0x21
+)
+
+var (
+	alphabetIdx [256]int
+	bigRadix    = big.NewInt(58)
+)
+
+func init() {
+	for i := range alphabetIdx {
+		alphabetIdx[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		alphabetIdx[alphabet[i]] = i
+	}
+}
+
+func checksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, bigRadix, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		idx := alphabetIdx[s[i]]
+		if idx < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, bigRadix)
+		n.Add(n, big.NewInt(int64(idx)))
+	}
+
+	decoded := n.Bytes()
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), decoded...), nil
+}
+
+func encodeCheck(prefix byte, payload []byte) string {
+	return encodeCheckPrefix([]byte{prefix}, payload)
+}
+
+func encodeCheckPrefix(prefix []byte, payload []byte) string {
+	data := append(append([]byte{}, prefix...), payload...)
+	return base58Encode(append(data, checksum(data)...))
+}
+
+func decodeCheck(prefix byte, s string, size int) ([]byte, error) {
+	raw, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) != size+5 || raw[0] != prefix {
+		return nil, errors.New("invalid encoded length or prefix")
+	}
+
+	if !bytes.Equal(checksum(raw[:len(raw)-4]), raw[len(raw)-4:]) {
+		return nil, errors.New("invalid checksum")
+	}
+
+	return raw[1 : len(raw)-4], nil
+}
+
+// EncodeAccountID returns the classic r-address of a 20 byte account ID
+func EncodeAccountID(id []byte) string {
+	return encodeCheck(accountIDPrefix, id)
+}
+
+// DecodeAccountID parses a classic r-address into its 20 byte account ID
+func DecodeAccountID(address string) ([]byte, error) {
+	return decodeCheck(accountIDPrefix, address, 20)
+}
+
+// EncodeSeed returns the base58 family seed (s...) for 16 bytes of entropy
+func EncodeSeed(entropy []byte) string {
+	return encodeCheck(familySeedPrefix, entropy)
+}
+
+// AccountIDFromPublicKey derives the account ID of a 33 byte public key
+func AccountIDFromPublicKey(publicKey []byte) []byte {
+	sha := sha256.Sum256(publicKey)
+	h := ripemd160.New()
+	h.Write(sha[:])
+	return h.Sum(nil)
+}
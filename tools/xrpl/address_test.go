@@ -0,0 +1,59 @@
+package xrpl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAccountIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		address string
+		id      string
+	}{
+		{"rrrrrrrrrrrrrrrrrrrrrhoLvTp", "0000000000000000000000000000000000000000"}, // ACCOUNT_ZERO
+		{"rrrrrrrrrrrrrrrrrrrrBZbvji", "0000000000000000000000000000000000000001"},  // ACCOUNT_ONE
+		{"rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "B5F762798A53D543A014CAF8B297CFF8F2F937E8"},
+	}
+
+	for _, tt := range tests {
+		id, _ := hex.DecodeString(tt.id)
+
+		got, err := DecodeAccountID(tt.address)
+		if err != nil {
+			t.Errorf("DecodeAccountID(%s): %s", tt.address, err)
+		} else if !bytes.Equal(got, id) {
+			t.Errorf("DecodeAccountID(%s) = %X, want %s", tt.address, got, tt.id)
+		}
+
+		if got := EncodeAccountID(id); got != tt.address {
+			t.Errorf("EncodeAccountID(%s) = %s, want %s", tt.id, got, tt.address)
+		}
+	}
+}
+
+func TestDecodeAccountIDRejectsBadAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{"checksum", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTi"},
+		{"alphabet", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyT0"},
+		{"truncated", "rHb9CJAWyB4rj91VRWn96DkukG4bwdt"},
+		{"seed", "snoPBrXtMeMyMHUVTgbuqAfg1SUTb"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		if id, err := DecodeAccountID(tt.address); err == nil {
+			t.Errorf("%s: DecodeAccountID(%q) = %X, want an error", tt.name, tt.address, id)
+		}
+	}
+}
+
+func TestEncodeSeed(t *testing.T) {
+	entropy, _ := hex.DecodeString("DEDCE9CE67B451D852FD4E846FCDE31C")
+	if got, want := EncodeSeed(entropy), "snoPBrXtMeMyMHUVTgbuqAfg1SUTb"; got != want {
+		t.Errorf("EncodeSeed = %s, want %s", got, want)
+	}
+}
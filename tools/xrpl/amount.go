@@ -0,0 +1,273 @@
+package xrpl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	amountNotNative = 0x8000000000000000
+	amountPositive  = 0x4000000000000000
+	amountMPT       = 0x2000000000000000
+
+	minMantissa = 1000000000000000
+	maxMantissa = 9999999999999999
+	minExponent = -96
+	maxExponent = 80
+)
+
+// EncodeCurrency turns a 3 letter ISO-like code or a 40 character hex code into its 20 byte form
+func EncodeCurrency(code string) ([]byte, error) {
+	if code == "" || code == "XRP" {
+		return make([]byte, 20), nil
+	}
+
+	if len(code) == 40 {
+		return hex.DecodeString(code)
+	}
+
+	if len(code) != 3 {
+		return nil, fmt.Errorf("invalid currency code %q", code)
+	}
+
+	out := make([]byte, 20)
+	copy(out[12:], code)
+	return out, nil
+}
+
+// DecodeCurrency is the reverse of EncodeCurrency
+func DecodeCurrency(raw []byte) string {
+	var zero = true
+	for _, b := range raw {
+		if b != 0 {
+			zero = false
+			break
+		}
+	}
+
+	if zero {
+		return "XRP"
+	}
+
+	standard := true
+	for i, b := range raw {
+		if (i < 12 || i > 14) && b != 0 {
+			standard = false
+			break
+		}
+	}
+
+	if standard {
+		return string(raw[12:15])
+	}
+
+	return strings.ToUpper(hex.EncodeToString(raw))
+}
+
+func formatIOUValue(negative bool, mantissa uint64, exponent int) string {
+	if mantissa == 0 {
+		return "0"
+	}
+
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+
+	// same as rippled's STAmount::getText: the full mantissa in scientific notation outside these
+	// exponents, except for exponent 0 which is a plain integer
+	if exponent != 0 && (exponent < -25 || exponent > -5) {
+		return fmt.Sprintf("%s%de%d", sign, mantissa, exponent)
+	}
+
+	digits := strconv.FormatUint(mantissa, 10)
+	point := len(digits) + exponent
+
+	var integer, fraction string
+	if point <= 0 {
+		integer = "0"
+		fraction = strings.Repeat("0", -point) + digits
+	} else {
+		integer = digits[:point]
+		fraction = digits[point:]
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if fraction == "" {
+		return sign + integer
+	}
+
+	return sign + integer + "." + fraction
+}
+
+// parses a decimal string into a normalized (mantissa, exponent) pair
+func parseIOUValue(value string) (bool, uint64, int, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return false, 0, 0, fmt.Errorf("invalid amount value %q", value)
+	}
+
+	negative := r.Sign() < 0
+	r.Abs(r)
+	if r.Sign() == 0 {
+		return false, 0, 0, nil
+	}
+
+	exponent := 0
+	ten := big.NewRat(10, 1)
+	min := new(big.Rat).SetInt64(minMantissa)
+	max := new(big.Rat).SetInt64(maxMantissa)
+
+	for r.Cmp(min) < 0 {
+		r.Mul(r, ten)
+		exponent--
+	}
+	for r.Cmp(max) > 0 {
+		r.Quo(r, ten)
+		exponent++
+	}
+
+	mantissa := new(big.Int).Quo(r.Num(), r.Denom()).Uint64()
+	if exponent < minExponent || exponent > maxExponent {
+		return false, 0, 0, fmt.Errorf("amount %q out of range", value)
+	}
+
+	return negative, mantissa, exponent, nil
+}
+
+func decodeAmount(data []byte) (interface{}, int, error) {
+	if len(data) < 8 {
+		return nil, 0, errors.New("truncated amount")
+	}
+
+	raw := binary.BigEndian.Uint64(data)
+	if raw&amountNotNative == 0 {
+		if raw&amountMPT != 0 {
+			if len(data) < 33 {
+				return nil, 0, errors.New("truncated MPT amount")
+			}
+
+			value := binary.BigEndian.Uint64(data[1:9])
+			sign := ""
+			if data[0]&0x40 == 0 {
+				sign = "-"
+			}
+
+			return map[string]interface{}{
+				"value":           sign + strconv.FormatUint(value, 10),
+				"mpt_issuance_id": strings.ToUpper(hex.EncodeToString(data[9:33])),
+			}, 33, nil
+		}
+
+		drops := raw & ^uint64(amountNotNative|amountPositive)
+		if raw&amountPositive == 0 && drops != 0 {
+			return "-" + strconv.FormatUint(drops, 10), 8, nil
+		}
+		return strconv.FormatUint(drops, 10), 8, nil
+	}
+
+	if len(data) < 48 {
+		return nil, 0, errors.New("truncated issued amount")
+	}
+
+	mantissa := raw & 0x003FFFFFFFFFFFFF
+	exponent := int((raw>>54)&0xFF) - 97
+	negative := raw&amountPositive == 0
+
+	return map[string]interface{}{
+		"value":    formatIOUValue(negative, mantissa, exponent),
+		"currency": DecodeCurrency(data[8:28]),
+		"issuer":   EncodeAccountID(data[28:48]),
+	}, 48, nil
+}
+
+func encodeAmount(v interface{}) ([]byte, error) {
+	switch a := v.(type) {
+	case string:
+		drops, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XRP amount %q", a)
+		}
+
+		raw := uint64(amountPositive)
+		if drops < 0 {
+			raw = 0
+			drops = -drops
+		}
+		raw |= uint64(drops)
+
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint64(out, raw)
+		return out, nil
+
+	case map[string]interface{}:
+		value, _ := a["value"].(string)
+		currency, _ := a["currency"].(string)
+		issuer, _ := a["issuer"].(string)
+
+		negative, mantissa, exponent, err := parseIOUValue(value)
+		if err != nil {
+			return nil, err
+		}
+
+		raw := uint64(amountNotNative)
+		if mantissa != 0 {
+			if !negative {
+				raw |= amountPositive
+			}
+			raw |= uint64(exponent+97) << 54
+			raw |= mantissa
+		}
+
+		cur, err := EncodeCurrency(currency)
+		if err != nil {
+			return nil, err
+		}
+
+		iss, err := DecodeAccountID(issuer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid issuer %q: %w", issuer, err)
+		}
+
+		out := make([]byte, 8, 48)
+		binary.BigEndian.PutUint64(out, raw)
+		out = append(out, cur...)
+		return append(out, iss...), nil
+	}
+
+	return nil, fmt.Errorf("unsupported amount %v", v)
+}
+
+// XRPDrops returns the number of drops of a decoded XRP amount
+func XRPDrops(amount interface{}) (int64, bool) {
+	s, ok := amount.(string)
+	if !ok {
+		return 0, false
+	}
+
+	drops, err := strconv.ParseInt(s, 10, 64)
+	return drops, err == nil
+}
+
+// IOUValue returns the decoded value of an issued currency amount as a big.Float
+func IOUValue(amount interface{}) (*big.Float, string, string, bool) {
+	m, ok := amount.(map[string]interface{})
+	if !ok {
+		return nil, "", "", false
+	}
+
+	value, _ := m["value"].(string)
+	f, _, err := big.ParseFloat(value, 10, 128, big.ToNearestEven)
+	if err != nil {
+		return nil, "", "", false
+	}
+
+	currency, _ := m["currency"].(string)
+	issuer, _ := m["issuer"].(string)
+	return f, currency, issuer, true
+}
@@ -0,0 +1,139 @@
+package xrpl
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestFormatIOUValue(t *testing.T) {
+	tests := []struct {
+		negative bool
+		mantissa uint64
+		exponent int
+		want     string
+	}{
+		{false, 0, 0, "0"},
+		{false, 1000000000000000, -15, "1"},
+		{true, 1000000000000000, -15, "-1"},
+		{false, 1234567890123456, -15, "1.234567890123456"},
+		{false, 1000000000000000, -16, "0.1"},
+
+		// exponent 0 is past the upper switch-over but never scientific
+		{false, 1000000000000000, 0, "1000000000000000"},
+		{true, 1234567890123456, 0, "-1234567890123456"},
+
+		// the upper boundary: -5 is still decimal, -4 is scientific
+		{false, 1000000000000000, -5, "10000000000"},
+		{false, 1000000000000000, -4, "1000000000000000e-4"},
+		{false, 1234567890123456, -4, "1234567890123456e-4"},
+
+		// the lower boundary: -25 is still decimal, -26 is scientific
+		{false, 1000000000000000, -25, "0.0000000001"},
+		{false, 1000000000000000, -26, "1000000000000000e-26"},
+		{true, 1234567890123456, -26, "-1234567890123456e-26"},
+
+		{false, 9999999999999999, 80, "9999999999999999e80"},
+		{false, 1000000000000000, -96, "1000000000000000e-96"},
+	}
+
+	for _, tt := range tests {
+		if got := formatIOUValue(tt.negative, tt.mantissa, tt.exponent); got != tt.want {
+			t.Errorf("formatIOUValue(%t, %d, %d) = %s, want %s", tt.negative, tt.mantissa, tt.exponent, got, tt.want)
+		}
+	}
+}
+
+func TestParseIOUValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		negative bool
+		mantissa uint64
+		exponent int
+	}{
+		{"0", false, 0, 0},
+		{"1", false, 1000000000000000, -15},
+		{"-1", true, 1000000000000000, -15},
+		{"0.1", false, 1000000000000000, -16},
+		{"1.234567890123456", false, 1234567890123456, -15},
+		{"1000000000000000", false, 1000000000000000, 0},
+		{"1000000000000000e-4", false, 1000000000000000, -4},
+		{"1000000000000000e-26", false, 1000000000000000, -26},
+		{"9999999999999999e80", false, 9999999999999999, 80},
+	}
+
+	for _, tt := range tests {
+		negative, mantissa, exponent, err := parseIOUValue(tt.value)
+		if err != nil {
+			t.Errorf("parseIOUValue(%s): %s", tt.value, err)
+			continue
+		}
+		if negative != tt.negative || mantissa != tt.mantissa || exponent != tt.exponent {
+			t.Errorf("parseIOUValue(%s) = (%t, %d, %d), want (%t, %d, %d)",
+				tt.value, negative, mantissa, exponent, tt.negative, tt.mantissa, tt.exponent)
+		}
+
+		if got := formatIOUValue(negative, mantissa, exponent); got != tt.value {
+			t.Errorf("formatIOUValue(parseIOUValue(%s)) = %s", tt.value, got)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "1e97"} {
+		if _, _, _, err := parseIOUValue(value); err == nil {
+			t.Errorf("parseIOUValue(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestAmountRoundTrip(t *testing.T) {
+	issuer := "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"
+	usd := "0000000000000000000000005553440000000000"
+	account := "B5F762798A53D543A014CAF8B297CFF8F2F937E8"
+
+	tests := []struct {
+		name   string
+		amount interface{}
+		hex    string
+	}{
+		{"xrp", "1000000", "40000000000F4240"},
+		{"zero xrp", "0", "4000000000000000"},
+		{"iou", map[string]interface{}{"value": "1", "currency": "USD", "issuer": issuer}, "D4838D7EA4C68000" + usd + account},
+		{"negative iou", map[string]interface{}{"value": "-1", "currency": "USD", "issuer": issuer}, "94838D7EA4C68000" + usd + account},
+		{"zero iou", map[string]interface{}{"value": "0", "currency": "USD", "issuer": issuer}, "8000000000000000" + usd + account},
+	}
+
+	for _, tt := range tests {
+		raw, err := encodeAmount(tt.amount)
+		if err != nil {
+			t.Errorf("%s: encodeAmount: %s", tt.name, err)
+			continue
+		}
+		if got := strings.ToUpper(hex.EncodeToString(raw)); got != tt.hex {
+			t.Errorf("%s: encodeAmount = %s, want %s", tt.name, got, tt.hex)
+		}
+
+		decoded, n, err := decodeAmount(raw)
+		if err != nil {
+			t.Errorf("%s: decodeAmount: %s", tt.name, err)
+			continue
+		}
+		if n != len(raw) {
+			t.Errorf("%s: decodeAmount read %d bytes, want %d", tt.name, n, len(raw))
+		}
+		if !equalAmounts(decoded, tt.amount) {
+			t.Errorf("%s: decodeAmount = %v, want %v", tt.name, decoded, tt.amount)
+		}
+	}
+}
+
+func equalAmounts(a, b interface{}) bool {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok != bok {
+		return false
+	}
+	if !aok {
+		return a == b
+	}
+	return am["value"] == bm["value"] && am["currency"] == bm["currency"] && am["issuer"] == bm["issuer"]
+}
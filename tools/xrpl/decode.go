@@ -0,0 +1,407 @@
+package xrpl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errTruncated = errors.New("truncated data")
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) end() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) read(n int) ([]byte, error) {
+	if n < 0 || p.pos+n > len(p.data) {
+		return nil, errTruncated
+	}
+
+	out := p.data[p.pos : p.pos+n]
+	p.pos += n
+	return out, nil
+}
+
+func (p *parser) readByte() (byte, error) {
+	b, err := p.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (p *parser) readFieldHeader() (int, int, error) {
+	b, err := p.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	typ := int(b >> 4)
+	nth := int(b & 0x0F)
+
+	if typ == 0 {
+		t, err := p.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		typ = int(t)
+	}
+
+	if nth == 0 {
+		n, err := p.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		nth = int(n)
+	}
+
+	return typ, nth, nil
+}
+
+func (p *parser) readVLLength() (int, error) {
+	b1, err := p.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b1 <= 192:
+		return int(b1), nil
+	case b1 <= 240:
+		b2, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+		return 193 + (int(b1)-193)*256 + int(b2), nil
+	case b1 <= 254:
+		b, err := p.read(2)
+		if err != nil {
+			return 0, err
+		}
+		return 12481 + (int(b1)-241)*65536 + int(b[0])*256 + int(b[1]), nil
+	}
+
+	return 0, errors.New("invalid variable length prefix")
+}
+
+func upperHex(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func (p *parser) readIssue() (interface{}, error) {
+	cur, err := p.read(20)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := DecodeCurrency(cur)
+	if currency == "XRP" {
+		return map[string]interface{}{"currency": "XRP"}, nil
+	}
+
+	issuer, err := p.read(20)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"currency": currency, "issuer": EncodeAccountID(issuer)}, nil
+}
+
+func (p *parser) readVLAccount() (string, error) {
+	n, err := p.readVLLength()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := p.read(n)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeAccountID(b), nil
+}
+
+func (p *parser) readPathSet() (interface{}, error) {
+	var paths []interface{}
+	var path []interface{}
+
+	for {
+		t, err := p.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if t == 0x00 || t == 0xFF {
+			paths = append(paths, path)
+			path = nil
+			if t == 0x00 {
+				return paths, nil
+			}
+			continue
+		}
+
+		step := make(map[string]interface{})
+		if t&0x01 != 0 {
+			b, err := p.read(20)
+			if err != nil {
+				return nil, err
+			}
+			step["account"] = EncodeAccountID(b)
+		}
+		if t&0x10 != 0 {
+			b, err := p.read(20)
+			if err != nil {
+				return nil, err
+			}
+			step["currency"] = DecodeCurrency(b)
+		}
+		if t&0x20 != 0 {
+			b, err := p.read(20)
+			if err != nil {
+				return nil, err
+			}
+			step["issuer"] = EncodeAccountID(b)
+		}
+
+		path = append(path, step)
+	}
+}
+
+func (p *parser) readValue(f Field) (interface{}, error) {
+	switch f.Type {
+	case TypeUInt8:
+		b, err := p.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if f.Name == "TransactionResult" {
+			if name, ok := TransactionResults[b]; ok {
+				return name, nil
+			}
+		}
+		return int64(b), nil
+
+	case TypeUInt16:
+		b, err := p.read(2)
+		if err != nil {
+			return nil, err
+		}
+
+		v := binary.BigEndian.Uint16(b)
+		switch f.Name {
+		case "LedgerEntryType":
+			if name, ok := LedgerEntryTypes[v]; ok {
+				return name, nil
+			}
+		case "TransactionType":
+			if name, ok := TransactionTypes[v]; ok {
+				return name, nil
+			}
+		}
+		return int64(v), nil
+
+	case TypeUInt32:
+		b, err := p.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint32(b)), nil
+
+	case TypeUInt64:
+		b, err := p.read(8)
+		if err != nil {
+			return nil, err
+		}
+
+		v := binary.BigEndian.Uint64(b)
+		switch f.Name {
+		case "MaximumAmount", "OutstandingAmount", "MPTAmount":
+			return strconv.FormatUint(v, 10), nil
+		}
+		return strings.ToUpper(strconv.FormatUint(v, 16)), nil
+
+	case TypeHash128, TypeHash160, TypeHash192, TypeHash256, TypeUInt96, TypeUInt384, TypeUInt512, TypeNumber:
+		sizes := map[int]int{
+			TypeHash128: 16, TypeHash160: 20, TypeHash192: 24, TypeHash256: 32,
+			TypeUInt96: 12, TypeUInt384: 48, TypeUInt512: 64, TypeNumber: 12,
+		}
+
+		b, err := p.read(sizes[f.Type])
+		if err != nil {
+			return nil, err
+		}
+		return upperHex(b), nil
+
+	case TypeAmount:
+		v, n, err := decodeAmount(p.data[p.pos:])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += n
+		return v, nil
+
+	case TypeBlob:
+		n, err := p.readVLLength()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := p.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return upperHex(b), nil
+
+	case TypeAccountID:
+		return p.readVLAccount()
+
+	case TypeVector256:
+		n, err := p.readVLLength()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := p.read(n)
+		if err != nil {
+			return nil, err
+		}
+
+		var out []interface{}
+		for i := 0; i+32 <= len(b); i += 32 {
+			out = append(out, upperHex(b[i:i+32]))
+		}
+		return out, nil
+
+	case TypePathSet:
+		return p.readPathSet()
+
+	case TypeIssue:
+		return p.readIssue()
+
+	case TypeCurrency:
+		b, err := p.read(20)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeCurrency(b), nil
+
+	case TypeXChainBridge:
+		lockingDoor, err := p.readVLAccount()
+		if err != nil {
+			return nil, err
+		}
+		lockingIssue, err := p.readIssue()
+		if err != nil {
+			return nil, err
+		}
+		issuingDoor, err := p.readVLAccount()
+		if err != nil {
+			return nil, err
+		}
+		issuingIssue, err := p.readIssue()
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"LockingChainDoor":  lockingDoor,
+			"LockingChainIssue": lockingIssue,
+			"IssuingChainDoor":  issuingDoor,
+			"IssuingChainIssue": issuingIssue,
+		}, nil
+
+	case TypeSTObject:
+		return p.readObject(true)
+
+	case TypeSTArray:
+		var out []interface{}
+		for {
+			if p.end() {
+				return nil, errTruncated
+			}
+
+			if p.data[p.pos] == arrayEndMarker {
+				p.pos++
+				return out, nil
+			}
+
+			typ, nth, err := p.readFieldHeader()
+			if err != nil {
+				return nil, err
+			}
+
+			inner := fieldByCode(typ, nth)
+			if inner.Type != TypeSTObject {
+				return nil, fmt.Errorf("unexpected %s inside array", inner.Name)
+			}
+
+			obj, err := p.readObject(true)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, map[string]interface{}{inner.Name: obj})
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported field type %d for %s", f.Type, f.Name)
+}
+
+func (p *parser) readObject(nested bool) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+
+	for !p.end() {
+		if nested && p.data[p.pos] == objectEndMarker {
+			p.pos++
+			return out, nil
+		}
+
+		typ, nth, err := p.readFieldHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		f := fieldByCode(typ, nth)
+		v, err := p.readValue(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		out[f.Name] = v
+	}
+
+	if nested {
+		return nil, errTruncated
+	}
+
+	return out, nil
+}
+
+// Decode parses a serialized STObject (ledger entry, transaction or metadata) into the same JSON-like
+// representation rippled and Clio use in their APIs
+func Decode(data []byte) (map[string]interface{}, error) {
+	p := parser{data: data}
+	return p.readObject(false)
+}
+
+// DecodeLedgerEntryType reads the type of a serialized ledger entry without decoding the rest of it
+func DecodeLedgerEntryType(data []byte) (string, error) {
+	if len(data) < 3 || data[0] != 0x11 {
+		return "", errors.New("blob does not start with LedgerEntryType")
+	}
+
+	code := binary.BigEndian.Uint16(data[1:3])
+	if name, ok := LedgerEntryTypes[code]; ok {
+		return name, nil
+	}
+
+	return fmt.Sprintf("Unknown(0x%04x)", code), nil
+}
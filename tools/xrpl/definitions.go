@@ -0,0 +1,548 @@
+// Package xrpl contains the small subset of the XRPL binary format that the Clio tools need to
+// read and write raw ledger data straight from a Clio keyspace.
+package xrpl
+
+import "fmt"
+
+// Serialized type codes, see rippled's SField.h
+const (
+	TypeUInt16       = 1
+	TypeUInt32       = 2
+	TypeUInt64       = 3
+	TypeHash128      = 4
+	TypeHash256      = 5
+	TypeAmount       = 6
+	TypeBlob         = 7
+	TypeAccountID    = 8
+	TypeNumber       = 9
+	TypeSTObject     = 14
+	TypeSTArray      = 15
+	TypeUInt8        = 16
+	TypeHash160      = 17
+	TypePathSet      = 18
+	TypeVector256    = 19
+	TypeUInt96       = 20
+	TypeHash192      = 21
+	TypeUInt384      = 22
+	TypeUInt512      = 23
+	TypeIssue        = 24
+	TypeXChainBridge = 25
+	TypeCurrency     = 26
+)
+
+const (
+	objectEndMarker = 0xE1
+	arrayEndMarker  = 0xF1
+)
+
+type Field struct {
+	Name string
+	Type int
+	Nth  int
+}
+
+var fields = []Field{
+	{"LedgerEntryType", TypeUInt16, 1},
+	{"TransactionType", TypeUInt16, 2},
+	{"SignerWeight", TypeUInt16, 3},
+	{"TransferFee", TypeUInt16, 4},
+	{"TradingFee", TypeUInt16, 5},
+	{"DiscountedFee", TypeUInt16, 6},
+	{"Version", TypeUInt16, 16},
+	{"HookStateChangeCount", TypeUInt16, 17},
+	{"HookEmitCount", TypeUInt16, 18},
+	{"HookExecutionIndex", TypeUInt16, 19},
+	{"HookApiVersion", TypeUInt16, 20},
+	{"LedgerFixType", TypeUInt16, 21},
+
+	{"NetworkID", TypeUInt32, 1},
+	{"Flags", TypeUInt32, 2},
+	{"SourceTag", TypeUInt32, 3},
+	{"Sequence", TypeUInt32, 4},
+	{"PreviousTxnLgrSeq", TypeUInt32, 5},
+	{"LedgerSequence", TypeUInt32, 6},
+	{"CloseTime", TypeUInt32, 7},
+	{"ParentCloseTime", TypeUInt32, 8},
+	{"SigningTime", TypeUInt32, 9},
+	{"Expiration", TypeUInt32, 10},
+	{"TransferRate", TypeUInt32, 11},
+	{"WalletSize", TypeUInt32, 12},
+	{"OwnerCount", TypeUInt32, 13},
+	{"DestinationTag", TypeUInt32, 14},
+	{"LastUpdateTime", TypeUInt32, 15},
+	{"HighQualityIn", TypeUInt32, 16},
+	{"HighQualityOut", TypeUInt32, 17},
+	{"LowQualityIn", TypeUInt32, 18},
+	{"LowQualityOut", TypeUInt32, 19},
+	{"QualityIn", TypeUInt32, 20},
+	{"QualityOut", TypeUInt32, 21},
+	{"StampEscrow", TypeUInt32, 22},
+	{"BondAmount", TypeUInt32, 23},
+	{"LoadFee", TypeUInt32, 24},
+	{"OfferSequence", TypeUInt32, 25},
+	{"FirstLedgerSequence", TypeUInt32, 26},
+	{"LastLedgerSequence", TypeUInt32, 27},
+	{"TransactionIndex", TypeUInt32, 28},
+	{"OperationLimit", TypeUInt32, 29},
+	{"ReferenceFeeUnits", TypeUInt32, 30},
+	{"ReserveBase", TypeUInt32, 31},
+	{"ReserveIncrement", TypeUInt32, 32},
+	{"SetFlag", TypeUInt32, 33},
+	{"ClearFlag", TypeUInt32, 34},
+	{"SignerQuorum", TypeUInt32, 35},
+	{"CancelAfter", TypeUInt32, 36},
+	{"FinishAfter", TypeUInt32, 37},
+	{"SignerListID", TypeUInt32, 38},
+	{"SettleDelay", TypeUInt32, 39},
+	{"TicketCount", TypeUInt32, 40},
+	{"TicketSequence", TypeUInt32, 41},
+	{"NFTokenTaxon", TypeUInt32, 42},
+	{"MintedNFTokens", TypeUInt32, 43},
+	{"BurnedNFTokens", TypeUInt32, 44},
+	{"HookStateCount", TypeUInt32, 45},
+	{"EmitGeneration", TypeUInt32, 46},
+	{"VoteWeight", TypeUInt32, 48},
+	{"FirstNFTokenSequence", TypeUInt32, 50},
+	{"OracleDocumentID", TypeUInt32, 51},
+
+	{"IndexNext", TypeUInt64, 1},
+	{"IndexPrevious", TypeUInt64, 2},
+	{"BookNode", TypeUInt64, 3},
+	{"OwnerNode", TypeUInt64, 4},
+	{"BaseFee", TypeUInt64, 5},
+	{"ExchangeRate", TypeUInt64, 6},
+	{"LowNode", TypeUInt64, 7},
+	{"HighNode", TypeUInt64, 8},
+	{"DestinationNode", TypeUInt64, 9},
+	{"Cookie", TypeUInt64, 10},
+	{"ServerVersion", TypeUInt64, 11},
+	{"NFTokenOfferNode", TypeUInt64, 12},
+	{"EmitBurden", TypeUInt64, 13},
+	{"HookOn", TypeUInt64, 16},
+	{"HookInstructionCount", TypeUInt64, 17},
+	{"HookReturnCode", TypeUInt64, 18},
+	{"ReferenceCount", TypeUInt64, 19},
+	{"XChainClaimID", TypeUInt64, 20},
+	{"XChainAccountCreateCount", TypeUInt64, 21},
+	{"XChainAccountClaimCount", TypeUInt64, 22},
+	{"AssetPrice", TypeUInt64, 23},
+	{"MaximumAmount", TypeUInt64, 24},
+	{"OutstandingAmount", TypeUInt64, 25},
+	{"MPTAmount", TypeUInt64, 26},
+
+	{"EmailHash", TypeHash128, 1},
+
+	{"TakerPaysCurrency", TypeHash160, 1},
+	{"TakerPaysIssuer", TypeHash160, 2},
+	{"TakerGetsCurrency", TypeHash160, 3},
+	{"TakerGetsIssuer", TypeHash160, 4},
+
+	{"MPTokenIssuanceID", TypeHash192, 1},
+
+	{"LedgerHash", TypeHash256, 1},
+	{"ParentHash", TypeHash256, 2},
+	{"TransactionHash", TypeHash256, 3},
+	{"AccountHash", TypeHash256, 4},
+	{"PreviousTxnID", TypeHash256, 5},
+	{"LedgerIndex", TypeHash256, 6},
+	{"WalletLocator", TypeHash256, 7},
+	{"RootIndex", TypeHash256, 8},
+	{"AccountTxnID", TypeHash256, 9},
+	{"NFTokenID", TypeHash256, 10},
+	{"EmitParentTxnID", TypeHash256, 11},
+	{"EmitNonce", TypeHash256, 12},
+	{"EmitHookHash", TypeHash256, 13},
+	{"AMMID", TypeHash256, 14},
+	{"BookDirectory", TypeHash256, 16},
+	{"InvoiceID", TypeHash256, 17},
+	{"Nickname", TypeHash256, 18},
+	{"Amendment", TypeHash256, 19},
+	{"Digest", TypeHash256, 21},
+	{"Channel", TypeHash256, 22},
+	{"ConsensusHash", TypeHash256, 23},
+	{"CheckID", TypeHash256, 24},
+	{"ValidatedHash", TypeHash256, 25},
+	{"PreviousPageMin", TypeHash256, 26},
+	{"NextPageMin", TypeHash256, 27},
+	{"NFTokenBuyOffer", TypeHash256, 28},
+	{"NFTokenSellOffer", TypeHash256, 29},
+	{"HookStateKey", TypeHash256, 30},
+	{"HookHash", TypeHash256, 31},
+	{"HookNamespace", TypeHash256, 32},
+	{"HookSetTxnID", TypeHash256, 33},
+
+	{"Amount", TypeAmount, 1},
+	{"Balance", TypeAmount, 2},
+	{"LimitAmount", TypeAmount, 3},
+	{"TakerPays", TypeAmount, 4},
+	{"TakerGets", TypeAmount, 5},
+	{"LowLimit", TypeAmount, 6},
+	{"HighLimit", TypeAmount, 7},
+	{"Fee", TypeAmount, 8},
+	{"SendMax", TypeAmount, 9},
+	{"DeliverMin", TypeAmount, 10},
+	{"Amount2", TypeAmount, 11},
+	{"BidMin", TypeAmount, 12},
+	{"BidMax", TypeAmount, 13},
+	{"MinimumOffer", TypeAmount, 16},
+	{"RippleEscrow", TypeAmount, 17},
+	{"DeliveredAmount", TypeAmount, 18},
+	{"NFTokenBrokerFee", TypeAmount, 19},
+	{"BaseFeeDrops", TypeAmount, 22},
+	{"ReserveBaseDrops", TypeAmount, 23},
+	{"ReserveIncrementDrops", TypeAmount, 24},
+	{"LPTokenOut", TypeAmount, 25},
+	{"LPTokenIn", TypeAmount, 26},
+	{"EPrice", TypeAmount, 27},
+	{"Price", TypeAmount, 28},
+	{"SignatureReward", TypeAmount, 29},
+	{"MinAccountCreateAmount", TypeAmount, 30},
+	{"LPTokenBalance", TypeAmount, 31},
+
+	{"PublicKey", TypeBlob, 1},
+	{"MessageKey", TypeBlob, 2},
+	{"SigningPubKey", TypeBlob, 3},
+	{"TxnSignature", TypeBlob, 4},
+	{"URI", TypeBlob, 5},
+	{"Signature", TypeBlob, 6},
+	{"Domain", TypeBlob, 7},
+	{"FundCode", TypeBlob, 8},
+	{"RemoveCode", TypeBlob, 9},
+	{"ExpireCode", TypeBlob, 10},
+	{"CreateCode", TypeBlob, 11},
+	{"MemoType", TypeBlob, 12},
+	{"MemoData", TypeBlob, 13},
+	{"MemoFormat", TypeBlob, 14},
+	{"Fulfillment", TypeBlob, 16},
+	{"Condition", TypeBlob, 17},
+	{"MasterSignature", TypeBlob, 18},
+	{"UNLModifyValidator", TypeBlob, 19},
+	{"ValidatorToDisable", TypeBlob, 20},
+	{"ValidatorToReEnable", TypeBlob, 21},
+	{"HookStateData", TypeBlob, 22},
+	{"HookReturnString", TypeBlob, 23},
+	{"HookParameterName", TypeBlob, 24},
+	{"HookParameterValue", TypeBlob, 25},
+	{"DIDDocument", TypeBlob, 26},
+	{"Data", TypeBlob, 27},
+	{"AssetClass", TypeBlob, 28},
+	{"Provider", TypeBlob, 29},
+	{"MPTokenMetadata", TypeBlob, 30},
+
+	{"Account", TypeAccountID, 1},
+	{"Owner", TypeAccountID, 2},
+	{"Destination", TypeAccountID, 3},
+	{"Issuer", TypeAccountID, 4},
+	{"Authorize", TypeAccountID, 5},
+	{"Unauthorize", TypeAccountID, 6},
+	{"RegularKey", TypeAccountID, 8},
+	{"NFTokenMinter", TypeAccountID, 9},
+	{"EmitCallback", TypeAccountID, 10},
+	{"Holder", TypeAccountID, 11},
+	{"HookAccount", TypeAccountID, 16},
+	{"OtherChainSource", TypeAccountID, 18},
+	{"OtherChainDestination", TypeAccountID, 19},
+	{"AttestationSignerAccount", TypeAccountID, 20},
+	{"AttestationRewardAccount", TypeAccountID, 21},
+	{"LockingChainDoor", TypeAccountID, 22},
+	{"IssuingChainDoor", TypeAccountID, 23},
+
+	{"TransactionMetaData", TypeSTObject, 2},
+	{"CreatedNode", TypeSTObject, 3},
+	{"DeletedNode", TypeSTObject, 4},
+	{"ModifiedNode", TypeSTObject, 5},
+	{"PreviousFields", TypeSTObject, 6},
+	{"FinalFields", TypeSTObject, 7},
+	{"NewFields", TypeSTObject, 8},
+	{"TemplateEntry", TypeSTObject, 9},
+	{"Memo", TypeSTObject, 10},
+	{"SignerEntry", TypeSTObject, 11},
+	{"NFToken", TypeSTObject, 12},
+	{"EmitDetails", TypeSTObject, 13},
+	{"Hook", TypeSTObject, 14},
+	{"Signer", TypeSTObject, 16},
+	{"Majority", TypeSTObject, 18},
+	{"DisabledValidator", TypeSTObject, 19},
+	{"EmittedTxn", TypeSTObject, 20},
+	{"HookExecution", TypeSTObject, 21},
+	{"HookDefinition", TypeSTObject, 22},
+	{"HookParameter", TypeSTObject, 23},
+	{"HookGrant", TypeSTObject, 24},
+	{"VoteEntry", TypeSTObject, 25},
+	{"AuctionSlot", TypeSTObject, 26},
+	{"AuthAccount", TypeSTObject, 27},
+	{"XChainClaimProofSig", TypeSTObject, 28},
+	{"XChainCreateAccountProofSig", TypeSTObject, 29},
+	{"XChainClaimAttestationCollectionElement", TypeSTObject, 30},
+	{"XChainCreateAccountAttestationCollectionElement", TypeSTObject, 31},
+	{"PriceData", TypeSTObject, 32},
+
+	{"Signers", TypeSTArray, 3},
+	{"SignerEntries", TypeSTArray, 4},
+	{"Template", TypeSTArray, 5},
+	{"Necessary", TypeSTArray, 6},
+	{"Sufficient", TypeSTArray, 7},
+	{"AffectedNodes", TypeSTArray, 8},
+	{"Memos", TypeSTArray, 9},
+	{"NFTokens", TypeSTArray, 10},
+	{"Hooks", TypeSTArray, 11},
+	{"VoteSlots", TypeSTArray, 12},
+	{"Majorities", TypeSTArray, 16},
+	{"DisabledValidators", TypeSTArray, 17},
+	{"HookExecutions", TypeSTArray, 18},
+	{"HookParameters", TypeSTArray, 19},
+	{"HookGrants", TypeSTArray, 20},
+	{"XChainClaimAttestations", TypeSTArray, 21},
+	{"XChainCreateAccountAttestations", TypeSTArray, 22},
+	{"PriceDataSeries", TypeSTArray, 24},
+	{"AuthAccounts", TypeSTArray, 25},
+
+	{"CloseResolution", TypeUInt8, 1},
+	{"Method", TypeUInt8, 2},
+	{"TransactionResult", TypeUInt8, 3},
+	{"Scale", TypeUInt8, 4},
+	{"AssetScale", TypeUInt8, 5},
+	{"TickSize", TypeUInt8, 16},
+	{"UNLModifyDisabling", TypeUInt8, 17},
+	{"HookResult", TypeUInt8, 18},
+	{"WasLockingChainSend", TypeUInt8, 19},
+
+	{"Paths", TypePathSet, 1},
+
+	{"Indexes", TypeVector256, 1},
+	{"Hashes", TypeVector256, 2},
+	{"Amendments", TypeVector256, 3},
+	{"NFTokenOffers", TypeVector256, 4},
+
+	{"LockingChainIssue", TypeIssue, 1},
+	{"IssuingChainIssue", TypeIssue, 2},
+	{"Asset", TypeIssue, 3},
+	{"Asset2", TypeIssue, 4},
+
+	{"XChainBridge", TypeXChainBridge, 1},
+
+	{"BaseAsset", TypeCurrency, 1},
+	{"QuoteAsset", TypeCurrency, 2},
+}
+
+var (
+	fieldsByCode = make(map[[2]int]Field)
+	fieldsByName = make(map[string]Field)
+)
+
+func init() {
+	for _, f := range fields {
+		fieldsByCode[[2]int{f.Type, f.Nth}] = f
+		fieldsByName[f.Name] = f
+	}
+}
+
+func fieldByCode(typ int, nth int) Field {
+	if f, ok := fieldsByCode[[2]int{typ, nth}]; ok {
+		return f
+	}
+
+	return Field{Name: fmt.Sprintf("Field_%d_%d", typ, nth), Type: typ, Nth: nth}
+}
+
+// FieldByName looks up the definition of a known field
+func FieldByName(name string) (Field, bool) {
+	f, ok := fieldsByName[name]
+	return f, ok
+}
+
+// Ledger entry type codes as stored in the LedgerEntryType field
+var LedgerEntryTypes = map[uint16]string{
+	0x0037: "NFTokenOffer",
+	0x0043: "Check",
+	0x0049: "DID",
+	0x004e: "NegativeUNL",
+	0x0050: "NFTokenPage",
+	0x0053: "SignerList",
+	0x0054: "Ticket",
+	0x0061: "AccountRoot",
+	0x0063: "Contract",
+	0x0064: "DirectoryNode",
+	0x0066: "Amendments",
+	0x0068: "LedgerHashes",
+	0x0069: "Bridge",
+	0x006f: "Offer",
+	0x0070: "DepositPreauth",
+	0x0071: "XChainOwnedClaimID",
+	0x0072: "RippleState",
+	0x0073: "FeeSettings",
+	0x0074: "XChainOwnedCreateAccountClaimID",
+	0x0075: "Escrow",
+	0x0078: "PayChannel",
+	0x0079: "AMM",
+	0x007e: "MPTokenIssuance",
+	0x007f: "MPToken",
+	0x0080: "Oracle",
+	0x0081: "Credential",
+	0x0082: "PermissionedDomain",
+}
+
+var TransactionTypes = map[uint16]string{
+	0:   "Payment",
+	1:   "EscrowCreate",
+	2:   "EscrowFinish",
+	3:   "AccountSet",
+	4:   "EscrowCancel",
+	5:   "SetRegularKey",
+	6:   "NickNameSet",
+	7:   "OfferCreate",
+	8:   "OfferCancel",
+	9:   "Contract",
+	10:  "TicketCreate",
+	12:  "SignerListSet",
+	13:  "PaymentChannelCreate",
+	14:  "PaymentChannelFund",
+	15:  "PaymentChannelClaim",
+	16:  "CheckCreate",
+	17:  "CheckCash",
+	18:  "CheckCancel",
+	19:  "DepositPreauth",
+	20:  "TrustSet",
+	21:  "AccountDelete",
+	22:  "SetHook",
+	25:  "NFTokenMint",
+	26:  "NFTokenBurn",
+	27:  "NFTokenCreateOffer",
+	28:  "NFTokenCancelOffer",
+	29:  "NFTokenAcceptOffer",
+	30:  "Clawback",
+	35:  "AMMCreate",
+	36:  "AMMDeposit",
+	37:  "AMMWithdraw",
+	38:  "AMMVote",
+	39:  "AMMBid",
+	40:  "AMMDelete",
+	41:  "XChainCreateClaimID",
+	42:  "XChainCommit",
+	43:  "XChainClaim",
+	44:  "XChainAccountCreateCommit",
+	45:  "XChainAddClaimAttestation",
+	46:  "XChainAddAccountCreateAttestation",
+	47:  "XChainModifyBridge",
+	48:  "XChainCreateBridge",
+	49:  "DIDSet",
+	50:  "DIDDelete",
+	51:  "OracleSet",
+	52:  "OracleDelete",
+	53:  "LedgerStateFix",
+	54:  "MPTokenIssuanceCreate",
+	55:  "MPTokenIssuanceDestroy",
+	56:  "MPTokenIssuanceSet",
+	57:  "MPTokenAuthorize",
+	100: "EnableAmendment",
+	101: "SetFee",
+	102: "UNLModify",
+}
+
+// Transaction results as stored in metadata; only tes and tec codes ever make it into a validated ledger
+var TransactionResults = map[uint8]string{
+	0:   "tesSUCCESS",
+	100: "tecCLAIM",
+	101: "tecPATH_PARTIAL",
+	102: "tecUNFUNDED_ADD",
+	103: "tecUNFUNDED_OFFER",
+	104: "tecUNFUNDED_PAYMENT",
+	105: "tecFAILED_PROCESSING",
+	121: "tecDIR_FULL",
+	122: "tecINSUF_RESERVE_LINE",
+	123: "tecINSUF_RESERVE_OFFER",
+	124: "tecNO_DST",
+	125: "tecNO_DST_INSUF_XRP",
+	126: "tecNO_LINE_INSUF_RESERVE",
+	127: "tecNO_LINE_REDUNDANT",
+	128: "tecPATH_DRY",
+	129: "tecUNFUNDED",
+	130: "tecNO_ALTERNATIVE_KEY",
+	131: "tecNO_REGULAR_KEY",
+	132: "tecOWNERS",
+	133: "tecNO_ISSUER",
+	134: "tecNO_AUTH",
+	135: "tecNO_LINE",
+	136: "tecINSUFF_FEE",
+	137: "tecFROZEN",
+	138: "tecNO_TARGET",
+	139: "tecNO_PERMISSION",
+	140: "tecNO_ENTRY",
+	141: "tecINSUFFICIENT_RESERVE",
+	142: "tecNEED_MASTER_KEY",
+	143: "tecDST_TAG_NEEDED",
+	144: "tecINTERNAL",
+	145: "tecOVERSIZE",
+	146: "tecCRYPTOCONDITION_ERROR",
+	147: "tecINVARIANT_FAILED",
+	148: "tecEXPIRED",
+	149: "tecDUPLICATE",
+	150: "tecKILLED",
+	151: "tecHAS_OBLIGATIONS",
+	152: "tecTOO_SOON",
+	153: "tecHOOK_REJECTED",
+	154: "tecMAX_SEQUENCE_REACHED",
+	155: "tecNO_SUITABLE_NFTOKEN_PAGE",
+	156: "tecNFTOKEN_BUY_SELL_MISMATCH",
+	157: "tecNFTOKEN_OFFER_TYPE_MISMATCH",
+	158: "tecCANT_ACCEPT_OWN_NFTOKEN_OFFER",
+	159: "tecINSUFFICIENT_FUNDS",
+	160: "tecOBJECT_NOT_FOUND",
+	161: "tecINSUFFICIENT_PAYMENT",
+	162: "tecUNFUNDED_AMM",
+	163: "tecAMM_BALANCE",
+	164: "tecAMM_FAILED",
+	165: "tecAMM_INVALID_TOKENS",
+	166: "tecAMM_EMPTY",
+	167: "tecAMM_NOT_EMPTY",
+	168: "tecAMM_ACCOUNT",
+	169: "tecINCOMPLETE",
+	170: "tecXCHAIN_BAD_TRANSFER_ISSUE",
+	171: "tecXCHAIN_NO_CLAIM_ID",
+	172: "tecXCHAIN_BAD_CLAIM_ID",
+	173: "tecXCHAIN_CLAIM_NO_QUORUM",
+	174: "tecXCHAIN_PROOF_UNKNOWN_KEY",
+	175: "tecXCHAIN_CREATE_ACCOUNT_NONXRP_ISSUE",
+	176: "tecXCHAIN_WRONG_CHAIN",
+	177: "tecXCHAIN_REWARD_MISMATCH",
+	178: "tecXCHAIN_NO_SIGNERS_LIST",
+	179: "tecXCHAIN_SENDING_ACCOUNT_MISMATCH",
+	180: "tecXCHAIN_INSUFF_CREATE_AMOUNT",
+	181: "tecXCHAIN_ACCOUNT_CREATE_PAST",
+	182: "tecXCHAIN_ACCOUNT_CREATE_TOO_MANY",
+	183: "tecXCHAIN_PAYMENT_FAILED",
+	184: "tecXCHAIN_SELF_COMMIT",
+	185: "tecXCHAIN_BAD_PUBLIC_KEY_ACCOUNT_PAIR",
+	186: "tecXCHAIN_CREATE_ACCOUNT_DISABLED",
+	187: "tecEMPTY_DID",
+	188: "tecINVALID_UPDATE_TIME",
+	189: "tecTOKEN_PAIR_NOT_FOUND",
+	190: "tecARRAY_EMPTY",
+	191: "tecARRAY_TOO_LARGE",
+}
+
+func reverse[K comparable, V comparable](m map[K]V) map[V]K {
+	out := make(map[V]K, len(m))
+	for k, v := range m {
+		out[v] = k
+	}
+	return out
+}
+
+var (
+	ledgerEntryTypeCodes   = reverse(LedgerEntryTypes)
+	transactionTypeCodes   = reverse(TransactionTypes)
+	transactionResultCodes = reverse(TransactionResults)
+)
+
+// LedgerEntryTypeCode returns the numeric code of a ledger entry type name
+func LedgerEntryTypeCode(name string) (uint16, bool) {
+	c, ok := ledgerEntryTypeCodes[name]
+	return c, ok
+}
+
+// TransactionTypeCode returns the numeric code of a transaction type name
+func TransactionTypeCode(name string) (uint16, bool) {
+	c, ok := transactionTypeCodes[name]
+	return c, ok
+}
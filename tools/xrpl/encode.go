@@ -0,0 +1,320 @@
+package xrpl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+func appendFieldHeader(out []byte, f Field) []byte {
+	switch {
+	case f.Type < 16 && f.Nth < 16:
+		return append(out, byte(f.Type<<4|f.Nth))
+	case f.Type >= 16 && f.Nth < 16:
+		return append(out, byte(f.Nth), byte(f.Type))
+	case f.Type < 16 && f.Nth >= 16:
+		return append(out, byte(f.Type<<4), byte(f.Nth))
+	}
+
+	return append(out, 0, byte(f.Type), byte(f.Nth))
+}
+
+func appendVL(out []byte, data []byte) []byte {
+	n := len(data)
+	switch {
+	case n <= 192:
+		out = append(out, byte(n))
+	case n <= 12480:
+		n -= 193
+		out = append(out, byte(193+n/256), byte(n%256))
+	default:
+		n -= 12481
+		out = append(out, byte(241+n/65536), byte((n/256)%256), byte(n%256))
+	}
+
+	return append(out, data...)
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	switch n := v.(type) {
+	case int:
+		return uint64(n), nil
+	case int64:
+		return uint64(n), nil
+	case uint16:
+		return uint64(n), nil
+	case uint32:
+		return uint64(n), nil
+	case uint64:
+		return n, nil
+	case float64:
+		return uint64(n), nil
+	case json.Number:
+		return strconv.ParseUint(n.String(), 10, 64)
+	case string:
+		return strconv.ParseUint(n, 10, 64)
+	}
+
+	return 0, fmt.Errorf("expected a number, got %T", v)
+}
+
+func hexValue(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return hex.DecodeString(b)
+	}
+
+	return nil, fmt.Errorf("expected hex string, got %T", v)
+}
+
+func accountValue(v interface{}) ([]byte, error) {
+	switch a := v.(type) {
+	case []byte:
+		return a, nil
+	case string:
+		return DecodeAccountID(a)
+	}
+
+	return nil, fmt.Errorf("expected account, got %T", v)
+}
+
+func encodeIssue(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected issue object, got %T", v)
+	}
+
+	currency, _ := m["currency"].(string)
+	cur, err := EncodeCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if currency == "" || currency == "XRP" {
+		return cur, nil
+	}
+
+	issuer, err := accountValue(m["issuer"])
+	if err != nil {
+		return nil, err
+	}
+
+	return append(cur, issuer...), nil
+}
+
+func encodeValue(out []byte, f Field, v interface{}, signing bool) ([]byte, error) {
+	switch f.Type {
+	case TypeUInt8:
+		if s, ok := v.(string); ok && f.Name == "TransactionResult" {
+			code, ok := transactionResultCodes[s]
+			if !ok {
+				return nil, fmt.Errorf("unknown transaction result %s", s)
+			}
+			return append(out, code), nil
+		}
+
+		n, err := toUint64(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, byte(n)), nil
+
+	case TypeUInt16:
+		var n uint64
+		var err error
+
+		if s, ok := v.(string); ok && (f.Name == "LedgerEntryType" || f.Name == "TransactionType") {
+			var code uint16
+			var found bool
+			if f.Name == "LedgerEntryType" {
+				code, found = ledgerEntryTypeCodes[s]
+			} else {
+				code, found = transactionTypeCodes[s]
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown %s %s", f.Name, s)
+			}
+			n = uint64(code)
+		} else if n, err = toUint64(v); err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint16(out, uint16(n)), nil
+
+	case TypeUInt32:
+		n, err := toUint64(v)
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint32(out, uint32(n)), nil
+
+	case TypeUInt64:
+		var n uint64
+		var err error
+		switch f.Name {
+		case "MaximumAmount", "OutstandingAmount", "MPTAmount":
+			n, err = toUint64(v)
+		default:
+			if s, ok := v.(string); ok {
+				n, err = strconv.ParseUint(s, 16, 64)
+			} else {
+				n, err = toUint64(v)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(out, n), nil
+
+	case TypeHash128, TypeHash160, TypeHash192, TypeHash256, TypeUInt96, TypeUInt384, TypeUInt512, TypeNumber:
+		b, err := hexValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, b...), nil
+
+	case TypeAmount:
+		b, err := encodeAmount(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, b...), nil
+
+	case TypeBlob:
+		b, err := hexValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendVL(out, b), nil
+
+	case TypeAccountID:
+		b, err := accountValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendVL(out, b), nil
+
+	case TypeVector256:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected list of hashes, got %T", v)
+		}
+
+		var raw []byte
+		for _, h := range list {
+			b, err := hexValue(h)
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, b...)
+		}
+		return appendVL(out, raw), nil
+
+	case TypeIssue:
+		b, err := encodeIssue(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, b...), nil
+
+	case TypeCurrency:
+		s, _ := v.(string)
+		b, err := EncodeCurrency(s)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, b...), nil
+
+	case TypeSTObject:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object, got %T", v)
+		}
+
+		out, err := encodeObject(out, m, signing)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, objectEndMarker), nil
+
+	case TypeSTArray:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected array, got %T", v)
+		}
+
+		for _, item := range list {
+			wrapper, ok := item.(map[string]interface{})
+			if !ok || len(wrapper) != 1 {
+				return nil, fmt.Errorf("array elements must be single-key objects")
+			}
+
+			for name, inner := range wrapper {
+				innerField, ok := fieldsByName[name]
+				if !ok {
+					return nil, fmt.Errorf("unknown field %s", name)
+				}
+
+				var err error
+				out = appendFieldHeader(out, innerField)
+				if out, err = encodeValue(out, innerField, inner, signing); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(out, arrayEndMarker), nil
+	}
+
+	return nil, fmt.Errorf("encoding of %s is not supported", f.Name)
+}
+
+func isSigningField(name string) bool {
+	return name != "TxnSignature" && name != "Signers"
+}
+
+func encodeObject(out []byte, m map[string]interface{}, signing bool) ([]byte, error) {
+	var ordered []Field
+	for name := range m {
+		f, ok := fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", name)
+		}
+
+		if signing && !isSigningField(name) {
+			continue
+		}
+
+		ordered = append(ordered, f)
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].Type != ordered[j].Type {
+			return ordered[i].Type < ordered[j].Type
+		}
+		return ordered[i].Nth < ordered[j].Nth
+	})
+
+	var err error
+	for _, f := range ordered {
+		out = appendFieldHeader(out, f)
+		if out, err = encodeValue(out, f, m[f.Name], signing); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return out, nil
+}
+
+// Encode serializes a JSON-like object into canonical XRPL binary form
+func Encode(m map[string]interface{}) ([]byte, error) {
+	return encodeObject(nil, m, false)
+}
+
+// EncodeForSigning serializes a transaction without its signature fields
+func EncodeForSigning(m map[string]interface{}) ([]byte, error) {
+	return encodeObject(nil, m, true)
+}
@@ -0,0 +1,104 @@
+package xrpl
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectRoundTrip(t *testing.T) {
+	issuer := "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"
+
+	tests := []struct {
+		name   string
+		object map[string]interface{}
+		hex    string
+	}{
+		{
+			name: "xrp payment",
+			object: map[string]interface{}{
+				"TransactionType": "Payment",
+				"Flags":           int64(2147483648),
+				"Sequence":        int64(1),
+				"Amount":          "1000000",
+				"Fee":             "10",
+				"Account":         issuer,
+				"Destination":     "rrrrrrrrrrrrrrrrrrrrBZbvji",
+			},
+			hex: "120000" + // TransactionType
+				"2280000000" + // Flags
+				"2400000001" + // Sequence
+				"6140000000000F4240" + // Amount
+				"68400000000000000A" + // Fee
+				"8114B5F762798A53D543A014CAF8B297CFF8F2F937E8" + // Account
+				"83140000000000000000000000000000000000000001", // Destination
+		},
+		{
+			name: "trust set",
+			object: map[string]interface{}{
+				"TransactionType": "TrustSet",
+				"LimitAmount":     map[string]interface{}{"value": "1", "currency": "USD", "issuer": issuer},
+				"Account":         "rrrrrrrrrrrrrrrrrrrrBZbvji",
+			},
+			hex: "120014" + // TransactionType
+				"63D4838D7EA4C68000" + "0000000000000000000000005553440000000000" + // LimitAmount
+				"B5F762798A53D543A014CAF8B297CFF8F2F937E8" +
+				"81140000000000000000000000000000000000000001", // Account
+		},
+		{
+			name: "account root",
+			object: map[string]interface{}{
+				"LedgerEntryType": "AccountRoot",
+				"Flags":           int64(0),
+				"Sequence":        int64(5),
+				"OwnerCount":      int64(2),
+				"Balance":         "25000000",
+				"Account":         issuer,
+			},
+			hex: "110061" + // LedgerEntryType
+				"2200000000" + // Flags
+				"2400000005" + // Sequence
+				"2D00000002" + // OwnerCount
+				"6240000000017D7840" + // Balance
+				"8114B5F762798A53D543A014CAF8B297CFF8F2F937E8", // Account
+		},
+	}
+
+	for _, tt := range tests {
+		raw, err := Encode(tt.object)
+		if err != nil {
+			t.Errorf("%s: Encode: %s", tt.name, err)
+			continue
+		}
+		if got := strings.ToUpper(hex.EncodeToString(raw)); got != tt.hex {
+			t.Errorf("%s: Encode = %s, want %s", tt.name, got, tt.hex)
+		}
+
+		decoded, err := Decode(raw)
+		if err != nil {
+			t.Errorf("%s: Decode: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, tt.object) {
+			t.Errorf("%s: Decode = %v, want %v", tt.name, decoded, tt.object)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	raw, err := Encode(map[string]interface{}{"TransactionType": "Payment", "Fee": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every cut inside a field; cutting between fields (at 3) leaves a valid object
+	for n := 1; n < len(raw); n++ {
+		if n == 3 {
+			continue
+		}
+		if _, err := Decode(raw[:n]); err == nil {
+			t.Errorf("Decode of the first %d of %d bytes succeeded, want an error", n, len(raw))
+		}
+	}
+}
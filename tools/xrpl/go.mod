@@ -0,0 +1,5 @@
+module xrplf/clio/xrpl
+
+go 1.21.6
+
+require golang.org/x/crypto v0.18.0
@@ -0,0 +1,121 @@
+package xrpl
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+)
+
+// Hash prefixes, see rippled's HashPrefix.h
+var (
+	PrefixTransactionID   = []byte{'T', 'X', 'N', 0}
+	PrefixTransactionSign = []byte{'S', 'T', 'X', 0}
+	PrefixLedgerMaster    = []byte{'L', 'W', 'R', 0}
+	PrefixTxNode          = []byte{'S', 'N', 'D', 0}
+	PrefixInnerNode       = []byte{'M', 'I', 'N', 0}
+)
+
+// Keylet space keys, see rippled's Indexes.cpp
+const (
+	spaceAccount     = 'a'
+	spaceDirNode     = 'd'
+	spaceRippleState = 'r'
+	spaceOffer       = 'o'
+	spaceOwnerDir    = 'O'
+	spaceBookDir     = 'B'
+	spaceFees        = 'e'
+	spaceAmendments  = 'f'
+	spaceSkipList    = 's'
+)
+
+// SHA512Half is the first 256 bits of the SHA-512 of the concatenated input
+func SHA512Half(parts ...[]byte) []byte {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)[:32]
+}
+
+func space(s byte) []byte {
+	return []byte{0, s}
+}
+
+func AccountRootKey(account []byte) []byte {
+	return SHA512Half(space(spaceAccount), account)
+}
+
+func OwnerDirKey(account []byte) []byte {
+	return SHA512Half(space(spaceOwnerDir), account)
+}
+
+func OfferKey(account []byte, sequence uint32) []byte {
+	return SHA512Half(space(spaceOffer), account, binary.BigEndian.AppendUint32(nil, sequence))
+}
+
+func RippleStateKey(a []byte, b []byte, currency []byte) []byte {
+	low, high := a, b
+	if string(b) < string(a) {
+		low, high = b, a
+	}
+	return SHA512Half(space(spaceRippleState), low, high, currency)
+}
+
+func FeeSettingsKey() []byte {
+	return SHA512Half(space(spaceFees))
+}
+
+func AmendmentsKey() []byte {
+	return SHA512Half(space(spaceAmendments))
+}
+
+func SkipListKey() []byte {
+	return SHA512Half(space(spaceSkipList))
+}
+
+// DirPageKey is the key of page number `page` of the directory rooted at `root`; page 0 is the root itself
+func DirPageKey(root []byte, page uint64) []byte {
+	if page == 0 {
+		return root
+	}
+	return SHA512Half(space(spaceDirNode), root, binary.BigEndian.AppendUint64(nil, page))
+}
+
+// BookBase is the first possible key of the order book directory for a currency pair
+func BookBase(paysCurrency, paysIssuer, getsCurrency, getsIssuer []byte) []byte {
+	key := SHA512Half(space(spaceBookDir), paysCurrency, getsCurrency, paysIssuer, getsIssuer)
+	for i := 24; i < 32; i++ {
+		key[i] = 0
+	}
+	return key
+}
+
+// BookQuality is the offer quality encoded in the last 8 bytes of a book directory key
+func BookQuality(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[24:32])
+}
+
+// BookBaseOf is the base of the order book a book directory key belongs to: the key with its quality zeroed
+func BookBaseOf(key []byte) []byte {
+	return append(append([]byte{}, key[:24]...), make([]byte, 8)...)
+}
+
+// QualityNext is the first key after every directory of the book `base`
+func QualityNext(base []byte) []byte {
+	next := make([]byte, 32)
+	copy(next, base)
+	for i := 23; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	for i := 24; i < 32; i++ {
+		next[i] = 0
+	}
+	return next
+}
+
+// TransactionID is the hash of a signed serialized transaction
+func TransactionID(blob []byte) []byte {
+	return SHA512Half(PrefixTransactionID, blob)
+}
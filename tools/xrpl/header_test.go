@@ -0,0 +1,57 @@
+package xrpl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLedgerHeaderHash(t *testing.T) {
+	// mainnet ledger 15202439
+	h := LedgerHeader{
+		Sequence:            15202439,
+		Drops:               99998831688050493,
+		ParentHash:          mustHex(t, "12724A65B030C15A1573AA28B1BBB5DF3DA4589AA3623675A31CAE69B23B1C4E"),
+		TxHash:              mustHex(t, "325EACC5271322539EEEC2D6A5292471EF1B3E72AE7180533EFC3B8F0AD435C8"),
+		AccountHash:         mustHex(t, "D9ABF622DA26EEEE48203085D4BC23B0F77DC6F8724AC33D975DA3CA492D2E44"),
+		ParentCloseTime:     492656460,
+		CloseTime:           492656470,
+		CloseTimeResolution: 10,
+	}
+	want := mustHex(t, "F4D865D83EB88C1A1911B9E90641919A1314F36E1B099F8E95FE3B7C77BE3349")
+
+	if got := h.ComputeHash(); !bytes.Equal(got, want) {
+		t.Fatalf("ComputeHash = %X, want %X", got, want)
+	}
+
+	raw := h.Encode()
+	if len(raw) != headerSize+32 {
+		t.Fatalf("Encode wrote %d bytes, want %d", len(raw), headerSize+32)
+	}
+
+	decoded, err := DecodeLedgerHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, &h) {
+		t.Errorf("DecodeLedgerHeader = %+v, want %+v", decoded, h)
+	}
+
+	// Clio writes the hash after the header, but a bare header decodes too
+	if decoded, err := DecodeLedgerHeader(raw[:headerSize]); err != nil || decoded.Hash != nil {
+		t.Errorf("DecodeLedgerHeader of a bare header = %+v, %v", decoded, err)
+	}
+	if _, err := DecodeLedgerHeader(raw[:headerSize-1]); err == nil {
+		t.Error("DecodeLedgerHeader of a short blob succeeded, want an error")
+	}
+}
@@ -0,0 +1,144 @@
+// Package rpc is a minimal JSON-RPC client for Clio and rippled servers.
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// Error is an error status returned by the server, as opposed to a transport failure
+type Error struct {
+	Code    string
+	Message string
+	Result  map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return e.Code
+}
+
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{URL: url, HTTP: &http.Client{Timeout: timeout}}
+}
+
+// Raw posts a request body as-is and returns the raw response body along with the round trip time
+func (c *Client) Raw(ctx context.Context, body []byte) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, elapsed, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return out, elapsed, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(out))
+	}
+
+	return out, elapsed, nil
+}
+
+// Call issues `method` with a single params object and returns the decoded result object
+func (c *Client) Call(ctx context.Context, method string, params map[string]interface{}) (map[string]interface{}, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": []interface{}{params},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _, err := c.Raw(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseResponse(raw)
+}
+
+// ParseResponse extracts the result object of a JSON-RPC response, turning error statuses into *Error
+func ParseResponse(raw []byte) (map[string]interface{}, error) {
+	var envelope struct {
+		Result map[string]interface{} `json:"result"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("malformed response: %w", err)
+	}
+
+	if envelope.Result == nil {
+		return nil, fmt.Errorf("response has no result")
+	}
+
+	if status, _ := envelope.Result["status"].(string); status == "error" {
+		code, _ := envelope.Result["error"].(string)
+		message, _ := envelope.Result["error_message"].(string)
+		return envelope.Result, &Error{Code: code, Message: message, Result: envelope.Result}
+	}
+
+	return envelope.Result, nil
+}
+
+// Uint reads a numeric field that may have been decoded as json.Number, float64 or a numeric string
+func Uint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return uint64(i), err == nil && i >= 0
+	case float64:
+		return uint64(n), n >= 0
+	case string:
+		var i uint64
+		_, err := fmt.Sscan(n, &i)
+		return i, err == nil
+	}
+
+	return 0, false
+}
+
+// ValidatedLedgerIndex asks the server for its latest validated ledger sequence
+func (c *Client) ValidatedLedgerIndex(ctx context.Context) (uint64, error) {
+	result, err := c.Call(ctx, "ledger", map[string]interface{}{"ledger_index": "validated"})
+	if err != nil {
+		return 0, err
+	}
+
+	seq, ok := Uint(result["ledger_index"])
+	if !ok {
+		return 0, fmt.Errorf("response has no ledger_index")
+	}
+
+	return seq, nil
+}
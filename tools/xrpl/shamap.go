@@ -0,0 +1,67 @@
+package xrpl
+
+import (
+	"bytes"
+	"sort"
+)
+
+// TxWithMeta is a transaction of a ledger together with its metadata, as stored by Clio
+type TxWithMeta struct {
+	Transaction []byte
+	Metadata    []byte
+}
+
+type treeLeaf struct {
+	key  []byte
+	hash []byte
+}
+
+func nibble(key []byte, depth int) byte {
+	if depth%2 == 0 {
+		return key[depth/2] >> 4
+	}
+	return key[depth/2] & 0x0F
+}
+
+// hashes the subtree holding `leaves`, which are sorted and share their first `depth` nibbles. The root
+// is always an inner node, even when the tree holds a single leaf
+func subtreeHash(leaves []treeLeaf, depth int) []byte {
+	if len(leaves) == 1 && depth > 0 {
+		return leaves[0].hash
+	}
+
+	var children [16][]treeLeaf
+	for _, l := range leaves {
+		n := nibble(l.key, depth)
+		children[n] = append(children[n], l)
+	}
+
+	parts := make([][]byte, 0, 17)
+	parts = append(parts, PrefixInnerNode)
+	for _, c := range children {
+		if len(c) == 0 {
+			parts = append(parts, make([]byte, 32))
+		} else {
+			parts = append(parts, subtreeHash(c, depth+1))
+		}
+	}
+
+	return SHA512Half(parts...)
+}
+
+// TransactionTreeHash computes the root hash of a ledger's transaction tree, i.e. the TxHash of its header
+func TransactionTreeHash(txs []TxWithMeta) []byte {
+	if len(txs) == 0 {
+		return make([]byte, 32)
+	}
+
+	leaves := make([]treeLeaf, len(txs))
+	for i, tx := range txs {
+		key := TransactionID(tx.Transaction)
+		data := appendVL(appendVL(nil, tx.Transaction), tx.Metadata)
+		leaves[i] = treeLeaf{key: key, hash: SHA512Half(PrefixTxNode, data, key)}
+	}
+
+	sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i].key, leaves[j].key) < 0 })
+	return subtreeHash(leaves, 0)
+}
@@ -0,0 +1,94 @@
+package xrpl
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the hashes below are built node by node from rippled's SHAMap definitions rather than through subtreeHash
+
+func leafHash(tx TxWithMeta) []byte {
+	data := appendVL(appendVL(nil, tx.Transaction), tx.Metadata)
+	return SHA512Half(PrefixTxNode, data, TransactionID(tx.Transaction))
+}
+
+func innerHash(children map[byte][]byte) []byte {
+	parts := [][]byte{PrefixInnerNode}
+	for n := byte(0); n < 16; n++ {
+		if h, ok := children[n]; ok {
+			parts = append(parts, h)
+		} else {
+			parts = append(parts, make([]byte, 32))
+		}
+	}
+	return SHA512Half(parts...)
+}
+
+func testTx(i int) TxWithMeta {
+	return TxWithMeta{Transaction: []byte{0x12, 0x00, byte(i)}, Metadata: []byte{0x20, byte(i)}}
+}
+
+// finds the transactions whose ids share the first nibble with testTx(0) and those that don't
+func testTxsByNibble(t *testing.T) (TxWithMeta, TxWithMeta, TxWithMeta) {
+	first := testTx(0)
+	id := TransactionID(first.Transaction)
+
+	var same, other *TxWithMeta
+	for i := 1; i < 256 && (same == nil || other == nil); i++ {
+		tx := testTx(i)
+		k := TransactionID(tx.Transaction)
+		switch {
+		case nibble(k, 0) == nibble(id, 0) && nibble(k, 1) != nibble(id, 1) && same == nil:
+			same = &tx
+		case nibble(k, 0) != nibble(id, 0) && other == nil:
+			other = &tx
+		}
+	}
+	if same == nil || other == nil {
+		t.Fatal("no test transactions with the wanted ids")
+	}
+
+	return first, *same, *other
+}
+
+func TestTransactionTreeHash(t *testing.T) {
+	first, same, other := testTxsByNibble(t)
+	firstID := TransactionID(first.Transaction)
+	otherID := TransactionID(other.Transaction)
+	sameID := TransactionID(same.Transaction)
+
+	tests := []struct {
+		name string
+		txs  []TxWithMeta
+		want []byte
+	}{
+		{"empty", nil, make([]byte, 32)},
+		{
+			"single", []TxWithMeta{first},
+			innerHash(map[byte][]byte{nibble(firstID, 0): leafHash(first)}),
+		},
+		{
+			"two branches", []TxWithMeta{other, first},
+			innerHash(map[byte][]byte{
+				nibble(firstID, 0): leafHash(first),
+				nibble(otherID, 0): leafHash(other),
+			}),
+		},
+		{
+			"shared nibble", []TxWithMeta{first, same, other},
+			innerHash(map[byte][]byte{
+				nibble(firstID, 0): innerHash(map[byte][]byte{
+					nibble(firstID, 1): leafHash(first),
+					nibble(sameID, 1):  leafHash(same),
+				}),
+				nibble(otherID, 0): leafHash(other),
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		if got := TransactionTreeHash(tt.txs); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: TransactionTreeHash = %X, want %X", tt.name, got, tt.want)
+		}
+	}
+}
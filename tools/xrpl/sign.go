@@ -0,0 +1,54 @@
+package xrpl
+
+import (
+	"crypto/ed25519"
+)
+
+// prefix of ed25519 family seeds, which makes them start with "sEd"
+var ed25519SeedPrefix = []byte{0x01, 0xE1, 0x4B}
+
+// Ed25519Key is a key pair derived from 16 bytes of seed entropy the way rippled derives ed25519 keys
+type Ed25519Key struct {
+	Entropy   []byte
+	PublicKey []byte
+	private   ed25519.PrivateKey
+}
+
+func NewEd25519Key(entropy []byte) *Ed25519Key {
+	private := ed25519.NewKeyFromSeed(SHA512Half(entropy))
+	public := append([]byte{0xED}, private.Public().(ed25519.PublicKey)...)
+	return &Ed25519Key{Entropy: entropy, PublicKey: public, private: private}
+}
+
+// Seed is the base58 family seed of the key, as accepted by wallets and rippled's wallet_propose
+func (k *Ed25519Key) Seed() string {
+	return encodeCheckPrefix(ed25519SeedPrefix, k.Entropy)
+}
+
+func (k *Ed25519Key) AccountID() []byte {
+	return AccountIDFromPublicKey(k.PublicKey)
+}
+
+func (k *Ed25519Key) Address() string {
+	return EncodeAccountID(k.AccountID())
+}
+
+// Sign fills in SigningPubKey and TxnSignature and returns the signed blob along with its transaction ID
+func (k *Ed25519Key) Sign(tx map[string]interface{}) ([]byte, []byte, error) {
+	tx["SigningPubKey"] = k.PublicKey
+	delete(tx, "TxnSignature")
+
+	data, err := EncodeForSigning(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx["TxnSignature"] = ed25519.Sign(k.private, append(append([]byte{}, PrefixTransactionSign...), data...))
+
+	blob, err := Encode(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return blob, TransactionID(blob), nil
+}